@@ -1,7 +1,8 @@
 #!/bin/bash
 
-# Build the folian-parser tool
-go build -o folian-parser ./cmd/folian-parser
+# Build the folian-parser tool and its desktop frontend
+go build -o folian-parser .
+go build -o folian-gui ./cmd/folian-gui
 
-echo "Build complete. The folian-parser tool is ready to use."
-echo "Usage: ./folian-parser -input input.epub -output output.epub"
+echo "Build complete. The folian-parser tool and folian-gui are ready to use."
+echo "Usage: ./folian-parser -i input.epub -o output.epub"
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/flouciel/folian-parser/folian"
+)
+
+// defaultTheme is the name of the theme made of the default format files
+const defaultTheme = "Default"
+
+// maxUpload is the largest EPUB accepted, in bytes
+const maxUpload = 512 << 20
+
+// app processes the books dropped on the page, one at a time, and streams the
+// processing events to the open pages
+type app struct {
+	themesDir string
+	outputDir string
+	// defaultFormat is a temporary format directory with the default files
+	defaultFormat string
+
+	// processing is held while a book is processed, as the format directory and
+	// the processing options are shared
+	processing sync.Mutex
+
+	mu      sync.Mutex
+	clients map[chan []byte]bool
+	// unsubscribe stops forwarding the library events
+	unsubscribe func()
+}
+
+// message is an event sent to the page
+type message struct {
+	Type string `json:"type"`
+	// Chapter progress
+	Index int    `json:"index,omitempty"`
+	Total int    `json:"total,omitempty"`
+	Title string `json:"title,omitempty"`
+	File  string `json:"file,omitempty"`
+	// Warnings and failures
+	Kind    string `json:"kind,omitempty"`
+	Message string `json:"message,omitempty"`
+	// Report of a processed book
+	Report *report `json:"report,omitempty"`
+}
+
+// report describes a processed book
+type report struct {
+	Input     string   `json:"input"`
+	Output    string   `json:"output"`
+	Title     string   `json:"title"`
+	Author    string   `json:"author"`
+	Language  string   `json:"language"`
+	Chapters  int      `json:"chapters"`
+	Resources int      `json:"resources"`
+	Warnings  []string `json:"warnings"`
+	Seconds   float64  `json:"seconds"`
+}
+
+// newApp prepares the default theme and forwards the library events to the pages
+func newApp(themesDir, outputDir string) (*app, error) {
+	defaultFormat, err := os.MkdirTemp("", "folian-gui-format-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	if err := folian.WriteDefaultFormat(defaultFormat); err != nil {
+		os.RemoveAll(defaultFormat)
+		return nil, err
+	}
+	a := &app{themesDir: themesDir, outputDir: outputDir, defaultFormat: defaultFormat, clients: make(map[chan []byte]bool)}
+	a.unsubscribe = folian.Subscribe(a.forward)
+	return a, nil
+}
+
+// close stops forwarding events and removes the default theme
+func (a *app) close() {
+	a.unsubscribe()
+	os.RemoveAll(a.defaultFormat)
+}
+
+// routes returns the handlers of the interface
+func (a *app) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", a.serveIndex)
+	mux.HandleFunc("/themes", a.serveThemes)
+	mux.HandleFunc("/process", a.serveProcess)
+	mux.HandleFunc("/events", a.serveEvents)
+	mux.HandleFunc("/download/", a.serveDownload)
+	return mux
+}
+
+// themes lists the theme names: the default one, then the subdirectories of
+// themesDir by name
+func (a *app) themes() []string {
+	themes := []string{defaultTheme}
+	if a.themesDir == "" {
+		return themes
+	}
+	entries, err := os.ReadDir(a.themesDir)
+	if err != nil {
+		return themes
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return append(themes, names...)
+}
+
+// themeDir returns the format directory of a theme, or "" for an unknown one
+func (a *app) themeDir(theme string) string {
+	if theme == "" || theme == defaultTheme {
+		return a.defaultFormat
+	}
+	for _, name := range a.themes() {
+		if name == theme {
+			return filepath.Join(a.themesDir, name)
+		}
+	}
+	return ""
+}
+
+// serveIndex serves the page
+func (a *app) serveIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, indexPage)
+}
+
+// serveThemes lists the themes as JSON
+func (a *app) serveThemes(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.themes())
+}
+
+// serveProcess receives a dropped EPUB and processes it in the background; the
+// progress and report follow as events
+func (a *app) serveProcess(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST an EPUB file", http.StatusMethodNotAllowed)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxUpload)
+	file, header, err := r.FormFile("book")
+	if err != nil {
+		http.Error(w, "no EPUB file received: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	name := filepath.Base(header.Filename)
+	if strings.ToLower(filepath.Ext(name)) != ".epub" {
+		http.Error(w, name+" is not an EPUB file", http.StatusBadRequest)
+		return
+	}
+	formatDir := a.themeDir(r.FormValue("theme"))
+	if formatDir == "" {
+		http.Error(w, "unknown theme "+r.FormValue("theme"), http.StatusBadRequest)
+		return
+	}
+	if !a.processing.TryLock() {
+		http.Error(w, "another book is being processed", http.StatusConflict)
+		return
+	}
+
+	tempDir, err := os.MkdirTemp("", "folian-gui-*")
+	if err != nil {
+		a.processing.Unlock()
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	input := filepath.Join(tempDir, name)
+	if err := saveUpload(file, input); err != nil {
+		a.processing.Unlock()
+		os.RemoveAll(tempDir)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	go func() {
+		defer a.processing.Unlock()
+		defer os.RemoveAll(tempDir)
+		a.process(input, formatDir)
+	}()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// saveUpload writes an uploaded file
+func saveUpload(file io.Reader, path string) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to save %s: %w", filepath.Base(path), err)
+	}
+	if _, err := io.Copy(out, file); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to save %s: %w", filepath.Base(path), err)
+	}
+	return out.Close()
+}
+
+// process processes a book with a theme into the output directory and sends its
+// report, collecting the warnings and resources from the events
+func (a *app) process(input, formatDir string) {
+	name := filepath.Base(input)
+	a.broadcast(message{Type: "started", File: name})
+	started := time.Now()
+
+	result := &report{Input: name, Output: name, Warnings: []string{}}
+	unsubscribe := folian.Subscribe(func(event folian.Event) {
+		switch e := event.(type) {
+		case folian.ChapterProcessed:
+			result.Chapters++
+		case folian.ResourceCopied:
+			result.Resources++
+		case folian.WarningRaised:
+			result.Warnings = append(result.Warnings, e.Message)
+		}
+	})
+	defer unsubscribe()
+
+	// A theme is completed with the default files it lacks, as the command does
+	if err := folian.WriteDefaultFormat(formatDir); err != nil {
+		a.broadcast(message{Type: "failed", File: name, Message: err.Error()})
+		return
+	}
+	folian.SetFormatDir(formatDir)
+	pipeline := folian.NewPipeline()
+	if err := pipeline.Process(input, filepath.Join(a.outputDir, name)); err != nil {
+		a.broadcast(message{Type: "failed", File: name, Message: err.Error()})
+		return
+	}
+	if book := pipeline.Book(); book != nil {
+		result.Title = book.Metadata.Title
+		result.Author = book.Metadata.Creator
+		result.Language = book.Metadata.Language
+	}
+	result.Seconds = time.Since(started).Seconds()
+	a.broadcast(message{Type: "done", File: name, Report: result})
+}
+
+// forward sends the library events to the pages
+func (a *app) forward(event folian.Event) {
+	switch e := event.(type) {
+	case folian.ChapterProcessed:
+		a.broadcast(message{Type: "chapter", Index: e.Index, Total: e.Total, Title: e.Title, File: e.File})
+	case folian.ResourceCopied:
+		a.broadcast(message{Type: "resource", Kind: e.Kind, File: e.Target})
+	case folian.WarningRaised:
+		a.broadcast(message{Type: "warning", Kind: e.Kind, Message: e.Message})
+	}
+}
+
+// broadcast sends a message to every open page, dropping it for pages that are
+// behind
+func (a *app) broadcast(m message) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for client := range a.clients {
+		select {
+		case client <- data:
+		default:
+		}
+	}
+}
+
+// serveEvents streams the messages to a page as server-sent events
+func (a *app) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	client := make(chan []byte, 256)
+	a.mu.Lock()
+	a.clients[client] = true
+	a.mu.Unlock()
+	defer func() {
+		a.mu.Lock()
+		delete(a.clients, client)
+		a.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	flusher.Flush()
+	for {
+		select {
+		case data := <-client:
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// serveDownload serves a processed book of the output directory
+func (a *app) serveDownload(w http.ResponseWriter, r *http.Request) {
+	name := filepath.Base(strings.TrimPrefix(r.URL.Path, "/download/"))
+	path := filepath.Join(a.outputDir, name)
+	if strings.ToLower(filepath.Ext(name)) != ".epub" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/epub+zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	http.ServeFile(w, r, path)
+}
@@ -0,0 +1,66 @@
+// Command folian-gui is a desktop frontend of folian-parser for editors who do not
+// use the command line: it opens a page in the browser where EPUB files are dropped
+// or picked, a theme chosen, and the progress and report of processing shown
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+
+	"github.com/flouciel/folian-parser/internal/command"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:0", "Address to serve the interface on (default: a free port)")
+	themesDir := flag.String("themes", "", "Directory whose subdirectories are format directories offered as themes")
+	outputDir := flag.String("o", "folian-output", "Directory the processed books are written to")
+	noBrowser := flag.Bool("no-browser", false, "Do not open the interface in the browser")
+	flag.Parse()
+
+	if err := run(*addr, *themesDir, *outputDir, !*noBrowser); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// run serves the interface until Ctrl-C
+func run(addr, themesDir, outputDir string, browse bool) error {
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	app, err := newApp(themesDir, outputDir)
+	if err != nil {
+		return err
+	}
+	defer app.close()
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to start the interface: %w", err)
+	}
+	server := &http.Server{Handler: app.routes()}
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	go func() {
+		<-interrupt
+		server.Shutdown(context.Background())
+	}()
+
+	url := "http://" + listener.Addr().String() + "/"
+	fmt.Printf("📚 Folian is running on %s (Ctrl-C to stop)\n", url)
+	if browse {
+		if err := command.OpenBrowser(url); err != nil {
+			fmt.Printf("ℹ️  Could not open a browser (%v); open %s yourself\n", err, url)
+		}
+	}
+	if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+		return fmt.Errorf("failed to serve the interface: %w", err)
+	}
+	return nil
+}
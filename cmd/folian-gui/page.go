@@ -0,0 +1,123 @@
+package main
+
+// indexPage is the interface: a drop zone and file picker, the theme selector, the
+// progress of the book being processed and the report of the processed books
+const indexPage = `<!DOCTYPE html>
+<html><head><meta charset="utf-8"/><title>Folian</title>
+<style>
+body { font-family: sans-serif; max-width: 48em; margin: 2em auto; padding: 0 1em; color: #222; }
+#drop { border: 2px dashed #999; border-radius: 8px; padding: 3em 1em; text-align: center; cursor: pointer; }
+#drop.over { border-color: #2a6; background: #efe; }
+label { display: block; margin: 1em 0; }
+progress { width: 100%; }
+#log { font-size: 0.85em; color: #555; max-height: 10em; overflow: auto; }
+.report { border: 1px solid #ccc; border-radius: 8px; padding: 0.5em 1em; margin: 1em 0; }
+.report.failed { border-color: #c33; }
+.warnings li { color: #a60; }
+</style></head>
+<body>
+<h1>Folian</h1>
+<label>Theme <select id="theme"></select></label>
+<div id="drop">Drop EPUB files here, or click to choose them
+<input id="picker" type="file" accept=".epub,application/epub+zip" multiple hidden/></div>
+<h2 id="status">Ready</h2>
+<progress id="progress" max="1" value="0"></progress>
+<ul id="log"></ul>
+<h2>Reports</h2>
+<div id="reports"></div>
+<script>
+var queue = [], busy = false;
+var drop = document.getElementById("drop"), picker = document.getElementById("picker");
+var theme = document.getElementById("theme"), statusLine = document.getElementById("status");
+var progress = document.getElementById("progress"), log = document.getElementById("log");
+
+fetch("/themes").then(function (r) { return r.json(); }).then(function (themes) {
+  themes.forEach(function (name) { theme.add(new Option(name, name)); });
+});
+
+function escape(s) {
+  var div = document.createElement("div");
+  div.textContent = s;
+  return div.innerHTML;
+}
+function note(text) {
+  var li = document.createElement("li");
+  li.textContent = text;
+  log.appendChild(li);
+  log.scrollTop = log.scrollHeight;
+}
+function enqueue(files) {
+  for (var i = 0; i < files.length; i++) queue.push(files[i]);
+  next();
+}
+function next() {
+  if (busy || queue.length === 0) return;
+  busy = true;
+  var file = queue.shift(), form = new FormData();
+  form.append("book", file);
+  form.append("theme", theme.value);
+  fetch("/process", {method: "POST", body: form}).then(function (r) {
+    if (r.ok) return;
+    return r.text().then(function (text) { finish(file.name, null, text); });
+  });
+}
+function finish(name, report, error) {
+  var div = document.createElement("div");
+  div.className = "report" + (error ? " failed" : "");
+  if (error) {
+    div.innerHTML = "<h3>" + escape(name) + "</h3><p>Failed: " + escape(error) + "</p>";
+    statusLine.textContent = "Failed: " + name;
+  } else {
+    var warnings = report.warnings.map(function (w) { return "<li>" + escape(w) + "</li>"; }).join("");
+    div.innerHTML = "<h3>" + escape(report.title || name) + "</h3>" +
+      "<p>" + escape(report.author) + (report.language ? " · " + escape(report.language) : "") + "</p>" +
+      "<p>" + report.chapters + " chapters, " + report.resources + " resources in " + report.seconds.toFixed(1) + " s · " +
+      "<a href=\"/download/" + encodeURIComponent(report.output) + "\">Download " + escape(report.output) + "</a></p>" +
+      (warnings ? "<details open><summary>" + report.warnings.length + " warnings</summary><ul class=\"warnings\">" + warnings + "</ul></details>" : "<p>No warnings.</p>");
+    statusLine.textContent = "Done: " + name;
+    progress.value = 1;
+  }
+  document.getElementById("reports").prepend(div);
+  busy = false;
+  next();
+}
+
+new EventSource("/events").onmessage = function (e) {
+  var m = JSON.parse(e.data);
+  switch (m.type) {
+  case "started":
+    statusLine.textContent = "Processing " + m.file;
+    progress.value = 0;
+    log.innerHTML = "";
+    break;
+  case "chapter":
+    progress.value = m.index / m.total;
+    note("Chapter " + m.index + " of " + m.total + ": " + m.title);
+    break;
+  case "resource":
+    note("Copied " + m.kind + " " + m.file);
+    break;
+  case "warning":
+    note("Warning: " + m.message);
+    break;
+  case "done":
+    finish(m.file, m.report, null);
+    break;
+  case "failed":
+    finish(m.file, null, m.message);
+    break;
+  }
+};
+
+drop.addEventListener("click", function () { picker.click(); });
+picker.addEventListener("change", function () { enqueue(picker.files); picker.value = ""; });
+drop.addEventListener("dragover", function (e) { e.preventDefault(); drop.classList.add("over"); });
+drop.addEventListener("dragleave", function () { drop.classList.remove("over"); });
+drop.addEventListener("drop", function (e) {
+  e.preventDefault();
+  drop.classList.remove("over");
+  enqueue(e.dataTransfer.files);
+});
+</script>
+</body></html>
+`
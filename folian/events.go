@@ -0,0 +1,33 @@
+package folian
+
+import "github.com/flouciel/folian-parser/internal/events"
+
+// Event is something that happened while processing a book: a ChapterProcessed,
+// ResourceCopied or WarningRaised
+type Event = events.Event
+
+// ChapterProcessed is published when a chapter of the output was written, with its
+// position among the chapters processed
+type ChapterProcessed = events.ChapterProcessed
+
+// ResourceCopied is published when a stylesheet, font or image was written to the
+// output
+type ResourceCopied = events.ResourceCopied
+
+// WarningRaised is published for each warning about the book
+type WarningRaised = events.WarningRaised
+
+// Kinds of copied resources
+const (
+	ResourceStylesheet = events.ResourceStylesheet
+	ResourceFont       = events.ResourceFont
+	ResourceImage      = events.ResourceImage
+	ResourceCover      = events.ResourceCover
+)
+
+// Subscribe calls handler with every event published while books are processed,
+// by any pipeline, until the returned function is called. Handlers run in the
+// goroutine processing the book and should return quickly
+func Subscribe(handler func(Event)) (unsubscribe func()) {
+	return events.Subscribe(handler)
+}
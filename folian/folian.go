@@ -0,0 +1,85 @@
+// Package folian is the library surface of folian-parser: it processes EPUB files
+// as the command does, through a pipeline embedders can insert their own stages in
+package folian
+
+import (
+	"github.com/flouciel/folian-parser/internal/epub"
+	"github.com/flouciel/folian-parser/internal/parser"
+	"github.com/flouciel/folian-parser/internal/restructure"
+)
+
+// Book is a parsed EPUB: its metadata, manifest, spine, chapters and table of
+// contents
+type Book = parser.Book
+
+// Chapter is a content document of a book in reading order
+type Chapter = parser.Chapter
+
+// Metadata holds the Dublin Core metadata of a book
+type Metadata = parser.Metadata
+
+// Kinds of Process failures, matched with errors.Is
+var (
+	ErrValidation = epub.ErrValidation
+	ErrDRM        = epub.ErrDRM
+	ErrParse      = epub.ErrParse
+	ErrIO         = epub.ErrIO
+)
+
+// Middleware is a stage of the pipeline run on the parsed book before it is
+// restructured and packaged; it returns the book to continue with, usually the one
+// it was given after changing it. Middleware errors are ErrValidation failures
+type Middleware func(*Book) (*Book, error)
+
+// Chain composes middleware into one that runs them in order, stopping at the
+// first error
+func Chain(middleware ...Middleware) Middleware {
+	return func(book *Book) (*Book, error) {
+		for _, m := range middleware {
+			var err error
+			if book, err = m(book); err != nil {
+				return nil, err
+			}
+		}
+		return book, nil
+	}
+}
+
+// SetFormatDir sets the format directory holding the templates and assets of the
+// output, "format" by default; it is shared by all pipelines
+func SetFormatDir(dir string) {
+	restructure.FormatDirPath = dir
+}
+
+// Pipeline extracts and parses an EPUB, runs its middleware on the book, then
+// restructures and packages it
+type Pipeline struct {
+	processor *epub.Processor
+}
+
+// NewPipeline creates a pipeline without middleware, which processes books as the
+// command does
+func NewPipeline() *Pipeline {
+	return &Pipeline{processor: epub.NewProcessor()}
+}
+
+// Use appends middleware to the pipeline, run in the order given, and returns the
+// pipeline
+func (p *Pipeline) Use(middleware ...Middleware) *Pipeline {
+	for _, m := range middleware {
+		p.processor.Use(epub.Stage(m))
+	}
+	return p
+}
+
+// Process processes the EPUB at inputPath into outputPath; errors are tagged with
+// ErrValidation, ErrDRM, ErrParse or ErrIO
+func (p *Pipeline) Process(inputPath, outputPath string) error {
+	return p.processor.Process(inputPath, outputPath)
+}
+
+// Book returns the book of the last Process call as the middleware left it, or nil
+// when it could not be parsed
+func (p *Pipeline) Book() *Book {
+	return p.processor.Book()
+}
@@ -0,0 +1,40 @@
+package folian
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/flouciel/folian-parser/format"
+	"github.com/flouciel/folian-parser/internal/restructure"
+)
+
+// WriteDefaultFormat creates a format directory with the default stylesheet, page
+// templates, font and logo, writing only the files it does not have yet so that a
+// theme keeps its own
+func WriteDefaultFormat(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create format directory: %w", err)
+	}
+	entries, err := format.Files.ReadDir(".")
+	if err != nil {
+		return fmt.Errorf("failed to read the default format files: %w", err)
+	}
+	for _, entry := range entries {
+		filePath := filepath.Join(dir, entry.Name())
+		if _, err := os.Stat(filePath); !os.IsNotExist(err) {
+			continue
+		}
+		data, err := format.Files.ReadFile(entry.Name())
+		if err != nil {
+			return fmt.Errorf("failed to read the default %s: %w", entry.Name(), err)
+		}
+		if err := os.WriteFile(filePath, data, 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", filePath, err)
+		}
+		if restructure.DebugMode {
+			fmt.Printf("📁 Wrote default format file %s\n", filePath)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+// Package format embeds the default format directory: the stylesheet, page
+// templates, font and logo written into every output book
+package format
+
+import "embed"
+
+// Files holds the default format files
+//
+//go:embed stylesheet.css titlepage.xhtml jacket.xhtml nav.xhtml jura.ttf folian.png folian.svg
+var Files embed.FS
@@ -0,0 +1,214 @@
+// Package audio reads the narration of a read-along edition: the cue sheet listing
+// its tracks and the durations of its MP3 and AAC files
+package audio
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Track is a track of the narration: a span of an audio file
+type Track struct {
+	Title string
+	// File is the path of the audio file
+	File string
+	// Start is the offset of the track in its file, in seconds
+	Start float64
+	// End is the end of the track in its file, in seconds; 0 until the narration is resolved
+	End float64
+}
+
+// Narration is the narration of a book, from a cue sheet or the audio files of a
+// directory
+type Narration struct {
+	Title    string
+	Narrator string
+	Tracks   []Track
+}
+
+// Load reads the narration of a directory: from its cue sheet, or else one track per
+// audio file in name order; track ends are resolved from the file durations
+func Load(dir string) (*Narration, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audio directory: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audio directory: %w", err)
+	}
+
+	var cues, files []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		switch ext := strings.ToLower(filepath.Ext(entry.Name())); {
+		case ext == ".cue":
+			cues = append(cues, filepath.Join(dir, entry.Name()))
+		case MediaType(entry.Name()) != "":
+			files = append(files, filepath.Join(dir, entry.Name()))
+		}
+	}
+
+	var narration *Narration
+	switch {
+	case len(cues) > 1:
+		return nil, fmt.Errorf("%s holds %d cue sheets; keep the one for this book", dir, len(cues))
+	case len(cues) == 1:
+		if narration, err = ParseCue(cues[0]); err != nil {
+			return nil, err
+		}
+	case len(files) > 0:
+		sort.Strings(files)
+		narration = &Narration{}
+		for _, file := range files {
+			base := filepath.Base(file)
+			narration.Tracks = append(narration.Tracks, Track{Title: strings.TrimSuffix(base, filepath.Ext(base)), File: file})
+		}
+	default:
+		return nil, fmt.Errorf("%s holds no cue sheet and no MP3 or AAC files", dir)
+	}
+	if err := narration.resolve(); err != nil {
+		return nil, err
+	}
+	return narration, nil
+}
+
+// ParseCue reads a cue sheet; the audio files it names are relative to it
+func ParseCue(path string) (*Narration, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cue sheet: %w", err)
+	}
+	defer file.Close()
+
+	narration := &Narration{}
+	var current string
+	// track is the track being read, -1 before the first
+	track := -1
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+		command, rest := text, ""
+		if space := strings.IndexAny(text, " \t"); space >= 0 {
+			command, rest = text[:space], strings.TrimSpace(text[space+1:])
+		}
+		switch strings.ToUpper(command) {
+		case "FILE":
+			name := rest
+			if strings.HasPrefix(rest, `"`) {
+				if end := strings.Index(rest[1:], `"`); end >= 0 {
+					name = rest[1 : end+1]
+				}
+			} else if space := strings.LastIndexAny(rest, " \t"); space >= 0 {
+				// The file type follows an unquoted name
+				name = rest[:space]
+			}
+			current = filepath.Join(filepath.Dir(path), filepath.FromSlash(name))
+		case "TRACK":
+			if current == "" {
+				return nil, fmt.Errorf("%s:%d: TRACK before FILE", filepath.Base(path), line)
+			}
+			narration.Tracks = append(narration.Tracks, Track{File: current, Start: -1})
+			track = len(narration.Tracks) - 1
+		case "TITLE":
+			if track >= 0 {
+				narration.Tracks[track].Title = unquote(rest)
+			} else {
+				narration.Title = unquote(rest)
+			}
+		case "PERFORMER":
+			if track < 0 {
+				narration.Narrator = unquote(rest)
+			}
+		case "INDEX":
+			fields := strings.Fields(rest)
+			if track < 0 || len(fields) != 2 {
+				return nil, fmt.Errorf("%s:%d: invalid INDEX", filepath.Base(path), line)
+			}
+			seconds, err := parseCueTime(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", filepath.Base(path), line, err)
+			}
+			// INDEX 01 starts the track; INDEX 00 is the pregap before it
+			if fields[0] == "01" || narration.Tracks[track].Start < 0 {
+				narration.Tracks[track].Start = seconds
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read cue sheet: %w", err)
+	}
+	if len(narration.Tracks) == 0 {
+		return nil, fmt.Errorf("%s lists no tracks", filepath.Base(path))
+	}
+	for i := range narration.Tracks {
+		if narration.Tracks[i].Start < 0 {
+			narration.Tracks[i].Start = 0
+		}
+	}
+	return narration, nil
+}
+
+// unquote removes the quotes around a cue sheet value
+func unquote(value string) string {
+	if len(value) >= 2 && strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`) {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+// parseCueTime parses a cue sheet time, mm:ss:ff with 75 frames per second
+func parseCueTime(value string) (float64, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("invalid time %q (expected mm:ss:ff)", value)
+	}
+	var numbers [3]int
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid time %q (expected mm:ss:ff)", value)
+		}
+		numbers[i] = n
+	}
+	return float64(numbers[0]*60+numbers[1]) + float64(numbers[2])/75, nil
+}
+
+// resolve sets the end of each track: the start of the next track in the same file,
+// or the end of the file
+func (n *Narration) resolve() error {
+	durations := make(map[string]float64)
+	for i := range n.Tracks {
+		track := &n.Tracks[i]
+		if MediaType(track.File) == "" {
+			return fmt.Errorf("%s is not MP3 or AAC audio, the formats EPUB readers play", filepath.Base(track.File))
+		}
+		if i+1 < len(n.Tracks) && n.Tracks[i+1].File == track.File {
+			track.End = n.Tracks[i+1].Start
+		} else {
+			duration, ok := durations[track.File]
+			if !ok {
+				var err error
+				if duration, err = Duration(track.File); err != nil {
+					return err
+				}
+				durations[track.File] = duration
+			}
+			track.End = duration
+		}
+		if track.End <= track.Start {
+			return fmt.Errorf("track %d (%s) ends before it starts", i+1, filepath.Base(track.File))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,264 @@
+package audio
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// mediaTypes are the media types of the audio formats EPUB readers play
+var mediaTypes = map[string]string{
+	".mp3": "audio/mpeg",
+	".m4a": "audio/mp4",
+	".m4b": "audio/mp4",
+	".mp4": "audio/mp4",
+	".aac": "audio/mp4",
+}
+
+// MediaType returns the media type of an audio file, or "" when EPUB readers do not
+// play it
+func MediaType(path string) string {
+	return mediaTypes[strings.ToLower(filepath.Ext(path))]
+}
+
+// Duration returns the length of an MP3 or MP4 audio file in seconds
+func Duration(path string) (float64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open audio file: %w", err)
+	}
+	defer file.Close()
+
+	var duration float64
+	if MediaType(path) == "audio/mpeg" {
+		duration, err = mp3Duration(file)
+	} else {
+		duration, err = mp4Duration(file)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read the duration of %s: %w", filepath.Base(path), err)
+	}
+	return duration, nil
+}
+
+// mp3Bitrates are the bitrates in kbit/s by bitrate index, for MPEG-1 and MPEG-2
+// layers I, II and III
+var mp3Bitrates = [2][3][16]int{
+	{
+		{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+	},
+	{
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+	},
+}
+
+// mp3SampleRates are the sample rates of MPEG-1 by index; MPEG-2 halves and MPEG-2.5
+// quarters them
+var mp3SampleRates = [3]int{44100, 48000, 32000}
+
+// mp3Frame is a parsed MPEG audio frame header
+type mp3Frame struct {
+	length     int
+	samples    int
+	sampleRate int
+	mpeg1      bool
+	mono       bool
+}
+
+// parseMP3Frame parses the 4-byte header of an MPEG audio frame
+func parseMP3Frame(header []byte) (mp3Frame, bool) {
+	if header[0] != 0xff || header[1]&0xe0 != 0xe0 {
+		return mp3Frame{}, false
+	}
+	version := header[1] >> 3 & 3 // 0: MPEG-2.5, 2: MPEG-2, 3: MPEG-1
+	layer := 4 - int(header[1]>>1&3)
+	bitrateIndex := header[2] >> 4
+	rateIndex := header[2] >> 2 & 3
+	if version == 1 || layer == 4 || bitrateIndex == 0 || bitrateIndex == 15 || rateIndex == 3 {
+		return mp3Frame{}, false
+	}
+	frame := mp3Frame{mpeg1: version == 3, mono: header[3]>>6 == 3}
+	table := 1
+	if frame.mpeg1 {
+		table = 0
+	}
+	bitrate := mp3Bitrates[table][layer-1][bitrateIndex] * 1000
+	frame.sampleRate = mp3SampleRates[rateIndex]
+	switch version {
+	case 2:
+		frame.sampleRate /= 2
+	case 0:
+		frame.sampleRate /= 4
+	}
+	padding := int(header[2] >> 1 & 1)
+	switch {
+	case layer == 1:
+		frame.samples = 384
+		frame.length = (12*bitrate/frame.sampleRate + padding) * 4
+	case layer == 3 && !frame.mpeg1:
+		frame.samples = 576
+		frame.length = 72*bitrate/frame.sampleRate + padding
+	default:
+		frame.samples = 1152
+		frame.length = 144*bitrate/frame.sampleRate + padding
+	}
+	return frame, frame.length > 4
+}
+
+// mp3Duration reads the duration of an MP3 file from its Xing or VBRI header, or
+// else by counting its frames
+func mp3Duration(file io.Reader) (float64, error) {
+	reader := bufio.NewReaderSize(file, 64<<10)
+
+	// Skip the ID3v2 tag
+	if header, err := reader.Peek(10); err == nil && string(header[:3]) == "ID3" {
+		size := int(header[6]&0x7f)<<21 | int(header[7]&0x7f)<<14 | int(header[8]&0x7f)<<7 | int(header[9]&0x7f)
+		if header[5]&0x10 != 0 {
+			size += 10
+		}
+		if _, err := reader.Discard(10 + size); err != nil {
+			return 0, fmt.Errorf("truncated ID3 tag")
+		}
+	}
+
+	var samples int64
+	sampleRate, frames, skipped := 0, 0, 0
+	for {
+		header, err := reader.Peek(4)
+		if err != nil {
+			break
+		}
+		frame, ok := parseMP3Frame(header)
+		if !ok {
+			// Resynchronize, giving up on data that is not audio
+			if frames > 0 && header[0] == 'T' && header[1] == 'A' && header[2] == 'G' {
+				break
+			}
+			if skipped++; skipped > 1<<20 {
+				break
+			}
+			reader.Discard(1)
+			continue
+		}
+		if frames == 0 {
+			sampleRate = frame.sampleRate
+			if count, ok := vbrFrameCount(reader, frame); ok {
+				return float64(count) * float64(frame.samples) / float64(frame.sampleRate), nil
+			}
+		}
+		frames++
+		samples += int64(frame.samples)
+		if _, err := reader.Discard(frame.length); err != nil {
+			break
+		}
+	}
+	if frames == 0 || sampleRate == 0 {
+		return 0, fmt.Errorf("no MPEG audio frames found")
+	}
+	return float64(samples) / float64(sampleRate), nil
+}
+
+// vbrFrameCount reads the frame count of the Xing, Info or VBRI header in the first
+// frame of an MP3 file
+func vbrFrameCount(reader *bufio.Reader, frame mp3Frame) (int, bool) {
+	data, err := reader.Peek(frame.length)
+	if err != nil {
+		return 0, false
+	}
+	// The Xing header follows the side information, whose size depends on the
+	// version and channels
+	side := 32
+	switch {
+	case frame.mpeg1 && frame.mono, !frame.mpeg1 && !frame.mono:
+		side = 17
+	case !frame.mpeg1 && frame.mono:
+		side = 9
+	}
+	if offset := 4 + side; len(data) >= offset+12 {
+		tag := string(data[offset : offset+4])
+		if (tag == "Xing" || tag == "Info") && data[offset+7]&1 != 0 {
+			return int(binary.BigEndian.Uint32(data[offset+8:])), true
+		}
+	}
+	if len(data) >= 36+18 && string(data[36:40]) == "VBRI" {
+		return int(binary.BigEndian.Uint32(data[36+14:])), true
+	}
+	return 0, false
+}
+
+// mp4Duration reads the duration of an MP4 file from its movie header
+func mp4Duration(file io.ReadSeeker) (float64, error) {
+	end, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	moov, moovSize, err := findAtom(file, 0, end, "moov")
+	if err != nil {
+		return 0, err
+	}
+	mvhd, _, err := findAtom(file, moov, moov+moovSize, "mvhd")
+	if err != nil {
+		return 0, err
+	}
+
+	header := make([]byte, 32)
+	if _, err := file.Seek(mvhd, io.SeekStart); err != nil {
+		return 0, err
+	}
+	if _, err := io.ReadFull(file, header); err != nil {
+		return 0, fmt.Errorf("truncated movie header")
+	}
+	var timescale uint32
+	var duration uint64
+	if header[0] == 1 {
+		timescale = binary.BigEndian.Uint32(header[20:])
+		duration = binary.BigEndian.Uint64(header[24:])
+	} else {
+		timescale = binary.BigEndian.Uint32(header[12:])
+		duration = uint64(binary.BigEndian.Uint32(header[16:]))
+	}
+	if timescale == 0 {
+		return 0, fmt.Errorf("invalid movie header")
+	}
+	return float64(duration) / float64(timescale), nil
+}
+
+// findAtom finds an MP4 atom between start and end, returning the offset and size of
+// its content
+func findAtom(file io.ReadSeeker, start, end int64, name string) (int64, int64, error) {
+	header := make([]byte, 16)
+	for offset := start; offset+8 <= end; {
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			return 0, 0, err
+		}
+		if _, err := io.ReadFull(file, header[:8]); err != nil {
+			break
+		}
+		size, headerSize := int64(binary.BigEndian.Uint32(header)), int64(8)
+		switch size {
+		case 0:
+			size = end - offset
+		case 1:
+			if _, err := io.ReadFull(file, header[8:16]); err != nil {
+				return 0, 0, fmt.Errorf("truncated %s atom", string(header[4:8]))
+			}
+			size, headerSize = int64(binary.BigEndian.Uint64(header[8:])), 16
+		}
+		if size < headerSize {
+			break
+		}
+		if string(header[4:8]) == name {
+			return offset + headerSize, size - headerSize, nil
+		}
+		offset += size
+	}
+	return 0, 0, fmt.Errorf("no %s atom found", name)
+}
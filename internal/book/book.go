@@ -0,0 +1,327 @@
+// Package book writes the chapters and images converted from a manuscript as an
+// extracted EPUB, which is then restructured like any other book
+package book
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Block is a converted paragraph, heading, list item or table
+type Block struct {
+	// Level is the heading level, 0 for body text
+	Level int
+	// Title is the plain text of a heading
+	Title string
+	// List is the list element of a list item, ul or ol
+	List  string
+	Attrs string
+	HTML  string
+}
+
+// Image is an image copied into the book
+type Image struct {
+	Name      string
+	MediaType string
+	Data      []byte
+}
+
+// ImageTypes are the media types of the image formats EPUB readers show
+var ImageTypes = map[string]string{
+	".jpg": "image/jpeg", ".jpeg": "image/jpeg", ".png": "image/png", ".gif": "image/gif", ".svg": "image/svg+xml", ".webp": "image/webp",
+}
+
+// Identifier returns a urn:uuid identifier derived from the SHA-256 of a file, so
+// that converting the same manuscript again gives the same identifier
+func Identifier(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s: %w", filePath, err)
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", filePath, err)
+	}
+	return "urn:uuid:" + uuidFromHash(hash.Sum(nil)), nil
+}
+
+// IdentifierOf returns a urn:uuid identifier derived from the SHA-256 of content,
+// for books converted from more than one file
+func IdentifierOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "urn:uuid:" + uuidFromHash(sum[:])
+}
+
+// uuidFromHash formats the start of a hash as a version 5 style UUID
+func uuidFromHash(sum []byte) string {
+	b := make([]byte, 16)
+	copy(b, sum)
+	b[6] = b[6]&0x0f | 0x50
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// Chapter is a chapter of the converted book
+type Chapter struct {
+	Title string
+	Body  string
+}
+
+// idPattern matches the IDs of converted elements, which links may target
+var idPattern = regexp.MustCompile(`\bid="([^"]+)"`)
+
+// localLinkPattern matches links to a bookmark of the manuscript
+var localLinkPattern = regexp.MustCompile(`href="#([^"]+)"`)
+
+// Split splits the converted blocks into chapters at the highest heading level used,
+// appending to each chapter the notes it references and pointing links at the
+// chapter holding their target
+func Split(blocks []Block, notes map[string]string, bookTitle string) []Chapter {
+	top := 0
+	for _, b := range blocks {
+		if b.Level > 0 && (top == 0 || b.Level < top) {
+			top = b.Level
+		}
+	}
+
+	var chapters []Chapter
+	var current *Chapter
+	var body strings.Builder
+	var list string
+	flush := func() {
+		if list != "" {
+			body.WriteString("</" + list + ">\n")
+			list = ""
+		}
+		if current != nil {
+			current.Body = body.String()
+			chapters = append(chapters, *current)
+		}
+		body.Reset()
+	}
+
+	for _, b := range blocks {
+		if b.Level > 0 && b.Level == top {
+			flush()
+			current = &Chapter{Title: b.Title}
+			body.WriteString(fmt.Sprintf("<h1%s>%s</h1>\n", b.Attrs, b.HTML))
+			continue
+		}
+		if current == nil {
+			// Text before the first heading opens the book, under its title
+			current = &Chapter{Title: bookTitle}
+		}
+		if b.List != list {
+			if list != "" {
+				body.WriteString("</" + list + ">\n")
+			}
+			if b.List != "" {
+				body.WriteString("<" + b.List + ">\n")
+			}
+			list = b.List
+		}
+		switch {
+		case b.Level > 0:
+			// A chapter keeps a single heading, so section headings are set as
+			// bold paragraphs
+			body.WriteString(fmt.Sprintf("<p class=\"nonindent\"%s><strong>%s</strong></p>\n", b.Attrs, b.HTML))
+		case b.List != "":
+			body.WriteString(fmt.Sprintf("<li%s>%s</li>\n", b.Attrs, b.HTML))
+		default:
+			body.WriteString(b.HTML + "\n")
+		}
+	}
+	flush()
+
+	// Footnotes follow the chapter that references them
+	for i := range chapters {
+		var asides strings.Builder
+		for _, match := range noteRefPattern.FindAllStringSubmatch(chapters[i].Body, -1) {
+			if note, ok := notes[match[1]]; ok {
+				asides.WriteString(fmt.Sprintf("<aside epub:type=\"footnote\" class=\"footnote\" id=\"%s\">\n%s</aside>\n", match[1], note))
+			}
+		}
+		chapters[i].Body += asides.String()
+	}
+
+	// Links to bookmarks in other chapters name the chapter file; links to a
+	// chapter heading, which the restructured chapter replaces, point at the chapter
+	targets := make(map[string]int)
+	headings := make(map[string]bool)
+	for i, ch := range chapters {
+		for j, match := range idPattern.FindAllStringSubmatch(ch.Body, -1) {
+			targets[match[1]] = i
+			headings[match[1]] = j == 0 && strings.HasPrefix(ch.Body, "<h1 id=")
+		}
+	}
+	for i := range chapters {
+		chapters[i].Body = localLinkPattern.ReplaceAllStringFunc(chapters[i].Body, func(link string) string {
+			id := localLinkPattern.FindStringSubmatch(link)[1]
+			target, ok := targets[id]
+			switch {
+			case ok && headings[id]:
+				return fmt.Sprintf(`href="%s"`, ChapterFile(target))
+			case ok && target != i:
+				return fmt.Sprintf(`href="%s#%s"`, ChapterFile(target), id)
+			}
+			return link
+		})
+	}
+	return chapters
+}
+
+// noteRefPattern matches the footnote references of converted text
+var noteRefPattern = regexp.MustCompile(`epub:type="noteref" href="#([^"]+)"`)
+
+// ChapterFile names the file of a converted chapter
+func ChapterFile(index int) string {
+	return fmt.Sprintf("chapter_%03d.xhtml", index+1)
+}
+
+// Metadata is the metadata written to the package document
+type Metadata struct {
+	Title       string
+	Creator     string
+	Language    string
+	Description string
+	Date        string
+	Identifier  string
+	Subjects    []string
+	// Cover is the name of the image used as the cover, if any
+	Cover string
+}
+
+// Write writes the converted chapters and images as an extracted EPUB in dir;
+// chapters with MathML are marked as such in the package document
+func Write(dir string, metadata Metadata, chapters []Chapter, images []Image) error {
+	for _, sub := range []string{"META-INF", "OEBPS/text", "OEBPS/images"} {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.FromSlash(sub)), 0755); err != nil {
+			return fmt.Errorf("failed to create %s: %w", sub, err)
+		}
+	}
+
+	files := map[string][]byte{
+		"mimetype":               []byte("application/epub+zip"),
+		"META-INF/container.xml": []byte(containerXML),
+	}
+
+	var manifest, spine, nav strings.Builder
+	for i, ch := range chapters {
+		id := fmt.Sprintf("chapter_%03d", i+1)
+		title := html.EscapeString(ch.Title)
+		files["OEBPS/text/"+ChapterFile(i)] = []byte(fmt.Sprintf(chapterTemplate, metadata.Language, metadata.Language, title, ch.Body))
+		properties := ""
+		if strings.Contains(ch.Body, "<math") {
+			properties = ` properties="mathml"`
+		}
+		manifest.WriteString(fmt.Sprintf("    <item id=\"%s\" href=\"text/%s\" media-type=\"application/xhtml+xml\"%s/>\n", id, ChapterFile(i), properties))
+		spine.WriteString(fmt.Sprintf("    <itemref idref=\"%s\"/>\n", id))
+		nav.WriteString(fmt.Sprintf("      <li><a href=\"text/%s\">%s</a></li>\n", ChapterFile(i), title))
+	}
+	coverID := ""
+	for i, img := range images {
+		files["OEBPS/images/"+img.Name] = img.Data
+		id, properties := fmt.Sprintf("image_%03d", i+1), ""
+		if img.Name == metadata.Cover {
+			coverID, properties = id, ` properties="cover-image"`
+		}
+		manifest.WriteString(fmt.Sprintf("    <item id=\"%s\" href=\"images/%s\" media-type=\"%s\"%s/>\n", id, img.Name, img.MediaType, properties))
+	}
+	files["OEBPS/nav.xhtml"] = []byte(fmt.Sprintf(navTemplate, metadata.Language, metadata.Language, html.EscapeString(metadata.Title), nav.String()))
+
+	var extra strings.Builder
+	if metadata.Creator != "" {
+		extra.WriteString(fmt.Sprintf("    <dc:creator>%s</dc:creator>\n", html.EscapeString(metadata.Creator)))
+	}
+	if metadata.Description != "" {
+		extra.WriteString(fmt.Sprintf("    <dc:description>%s</dc:description>\n", html.EscapeString(metadata.Description)))
+	}
+	if metadata.Date != "" {
+		extra.WriteString(fmt.Sprintf("    <dc:date>%s</dc:date>\n", html.EscapeString(metadata.Date)))
+	}
+	for _, subject := range metadata.Subjects {
+		extra.WriteString(fmt.Sprintf("    <dc:subject>%s</dc:subject>\n", html.EscapeString(subject)))
+	}
+	if coverID != "" {
+		extra.WriteString(fmt.Sprintf("    <meta name=\"cover\" content=\"%s\"/>\n", coverID))
+	}
+	files["OEBPS/content.opf"] = []byte(fmt.Sprintf(opfTemplate,
+		html.EscapeString(metadata.Identifier), html.EscapeString(metadata.Title),
+		html.EscapeString(metadata.Language), extra.String(), manifest.String(), spine.String()))
+
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, filepath.FromSlash(name)), data, 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// containerXML points at the package document of a converted book
+const containerXML = `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`
+
+// opfTemplate is the package document of a converted book
+const opfTemplate = `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="bookid">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:identifier id="bookid">%s</dc:identifier>
+    <dc:title>%s</dc:title>
+    <dc:language>%s</dc:language>
+%s  </metadata>
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+%s  </manifest>
+  <spine>
+%s  </spine>
+</package>
+`
+
+// chapterTemplate is a converted chapter
+const chapterTemplate = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" lang="%s" xml:lang="%s">
+<head>
+  <title>%s</title>
+</head>
+<body>
+%s</body>
+</html>
+`
+
+// navTemplate is the navigation document of a converted book
+const navTemplate = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" lang="%s" xml:lang="%s">
+<head>
+  <title>%s</title>
+</head>
+<body>
+  <nav epub:type="toc" id="toc">
+    <ol>
+%s    </ol>
+  </nav>
+</body>
+</html>
+`
+
+// EscapeText escapes converted text for XHTML
+func EscapeText(text string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(text))
+	return buf.String()
+}
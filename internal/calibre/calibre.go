@@ -0,0 +1,126 @@
+// Package calibre converts the e-book formats folian-parser does not read, such as
+// DOCX, RTF and LIT, to EPUB with Calibre's ebook-convert
+package calibre
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/flouciel/folian-parser/internal/command"
+)
+
+// ConvertEnv names the environment variable holding the path of ebook-convert, when
+// it is neither in the PATH nor installed in the usual place
+const ConvertEnv = "EBOOK_CONVERT"
+
+// Formats are the input formats converted with Calibre, by extension
+var Formats = map[string]string{
+	".azw":   "AZW",
+	".azw3":  "AZW3",
+	".doc":   "DOC",
+	".docx":  "DOCX",
+	".fb2":   "FB2",
+	".htmlz": "HTMLZ",
+	".lit":   "LIT",
+	".lrf":   "LRF",
+	".mobi":  "MOBI",
+	".odt":   "ODT",
+	".pdb":   "PDB",
+	".rtf":   "RTF",
+	".txtz":  "TXTZ",
+}
+
+// Conversion records how an input was converted to EPUB, and where the EPUB is
+type Conversion struct {
+	Tool    string
+	Version string
+	Source  string
+	Format  string
+	Output  string
+}
+
+// Format returns the Calibre input format of a path, or "" when it is not converted
+// with Calibre
+func Format(path string) string {
+	return Formats[strings.ToLower(filepath.Ext(path))]
+}
+
+// convertLocations are the usual install locations of ebook-convert
+func convertLocations() []string {
+	switch runtime.GOOS {
+	case "darwin":
+		return []string{"/Applications/calibre.app/Contents/MacOS/ebook-convert"}
+	case "windows":
+		return []string{
+			filepath.Join(os.Getenv("ProgramFiles"), "Calibre2", "ebook-convert.exe"),
+			filepath.Join(os.Getenv("ProgramFiles(x86)"), "Calibre2", "ebook-convert.exe"),
+		}
+	}
+	return nil
+}
+
+// convertPath returns the ebook-convert executable
+func convertPath() (string, error) {
+	if path := os.Getenv(ConvertEnv); path != "" {
+		if _, err := os.Stat(path); err != nil {
+			return "", fmt.Errorf("%s points at a missing file: %w", ConvertEnv, err)
+		}
+		return path, nil
+	}
+	if path, err := exec.LookPath("ebook-convert"); err == nil {
+		return path, nil
+	}
+	for _, path := range convertLocations() {
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("Calibre's ebook-convert is not installed and %s is not set", ConvertEnv)
+}
+
+// Installed reports whether Calibre's ebook-convert can be run
+func Installed() bool {
+	_, err := convertPath()
+	return err == nil
+}
+
+// version returns the Calibre version reported by ebook-convert, e.g. "7.4.0"
+func version(program string) string {
+	output, err := exec.Command(program, "--version").Output()
+	if err != nil {
+		return ""
+	}
+	// "ebook-convert (calibre 7.4.0)"
+	line := strings.SplitN(strings.TrimSpace(string(output)), "\n", 2)[0]
+	if start := strings.Index(line, "calibre "); start >= 0 {
+		return strings.TrimRight(line[start+len("calibre "):], ") ")
+	}
+	return line
+}
+
+// Convert converts an input to an EPUB in outputDir with ebook-convert
+func Convert(inputPath, outputDir string) (*Conversion, error) {
+	format := Format(inputPath)
+	if format == "" {
+		return nil, fmt.Errorf("%s is not a format converted with Calibre", filepath.Ext(inputPath))
+	}
+	program, err := convertPath()
+	if err != nil {
+		return nil, err
+	}
+
+	base := filepath.Base(inputPath)
+	outputPath := filepath.Join(outputDir, strings.TrimSuffix(base, filepath.Ext(base))+".epub")
+	output, err := exec.Command(program, inputPath, outputPath).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("ebook-convert failed: %w: %s", err, command.LastLines(string(output), 5))
+	}
+	if _, err := os.Stat(outputPath); err != nil {
+		return nil, fmt.Errorf("ebook-convert did not write an EPUB: %s", command.LastLines(string(output), 5))
+	}
+	return &Conversion{Tool: "calibre ebook-convert", Version: version(program), Source: inputPath, Format: format, Output: outputPath}, nil
+}
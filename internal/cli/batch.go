@@ -0,0 +1,216 @@
+package cli
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/flouciel/folian-parser/internal/restructure"
+)
+
+// journalEntry records an input completed by a batch run
+type journalEntry struct {
+	Input    string    `json:"input"`
+	Output   string    `json:"output"`
+	Modified time.Time `json:"modified"`
+}
+
+// batchJournal lists the completed inputs of a batch run, one JSON entry per line,
+// so that an interrupted run resumes where it stopped
+type batchJournal struct {
+	path    string
+	entries map[string]journalEntry
+}
+
+// loadJournal reads the journal at path, which may not exist yet
+func loadJournal(path string) (*batchJournal, error) {
+	journal := &batchJournal{path: path, entries: make(map[string]journalEntry)}
+	if path == "" {
+		return journal, nil
+	}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return journal, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry journalEntry
+		// A run interrupted while writing leaves a partial last line
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err == nil {
+			journal.entries[entry.Input] = entry
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read journal: %w", err)
+	}
+	return journal, nil
+}
+
+// completed reports whether the journal records the input as processed into output
+// since it was last modified, and the output is still there
+func (j *batchJournal) completed(input, output string, modified time.Time) bool {
+	entry, ok := j.entries[input]
+	if !ok || entry.Output != output || !entry.Modified.Equal(modified) {
+		return false
+	}
+	_, err := os.Stat(output)
+	return err == nil
+}
+
+// record appends a completed input to the journal
+func (j *batchJournal) record(input, output string, modified time.Time) error {
+	entry := journalEntry{Input: input, Output: output, Modified: modified}
+	j.entries[input] = entry
+	if j.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal journal entry: %w", err)
+	}
+	file, err := os.OpenFile(j.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open journal: %w", err)
+	}
+	defer file.Close()
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+	return nil
+}
+
+// datedOutputPattern matches the names of periodical issues written by earlier runs
+var datedOutputPattern = regexp.MustCompile(`-\d{4}-\d{2}-\d{2}$`)
+
+// batchInputs lists the EPUB files under a directory, leaving out the outputs of
+// earlier runs written next to their inputs
+func batchInputs(inputDir string, alongside bool) ([]string, error) {
+	var inputs []string
+	err := filepath.Walk(inputDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.EqualFold(filepath.Ext(path), ".epub") {
+			return nil
+		}
+		name := strings.TrimSuffix(path, filepath.Ext(path))
+		if alongside && (strings.HasSuffix(name, "-fixed") || restructure.PeriodicalKind != "" && datedOutputPattern.MatchString(name)) {
+			return nil
+		}
+		inputs = append(inputs, path)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", inputDir, err)
+	}
+	sort.Strings(inputs)
+	return inputs, nil
+}
+
+// batchOutputPath returns where an input of a batch run is written: under outputDir
+// with the input's relative path, or next to the input without an output directory
+func batchOutputPath(inputDir, input, outputDir string) string {
+	if outputDir == "" {
+		return defaultOutputPath(input)
+	}
+	rel, err := filepath.Rel(inputDir, input)
+	if err != nil {
+		rel = filepath.Base(input)
+	}
+	return filepath.Join(outputDir, rel)
+}
+
+// upToDate reports whether an output exists and is newer than its input
+func upToDate(output string, inputModified time.Time) bool {
+	info, err := os.Stat(output)
+	return err == nil && info.ModTime().After(inputModified)
+}
+
+// runBatch processes every EPUB under inputDir, skipping inputs whose output is up
+// to date or that the journal records as done unless force is set, and writes the
+// catalogue of the books when cataloguePath is set; it returns the exit code of the
+// first failed input
+func runBatch(inputDir, outputDir, journalPath, cataloguePath string, force, verbose, writeMapping bool) int {
+	inputs, err := batchInputs(inputDir, outputDir == "")
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return exitIO
+	}
+	journal, err := loadJournal(journalPath)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return exitIO
+	}
+
+	fmt.Printf("📚 Batch processing %d EPUB files in %s\n", len(inputs), inputDir)
+	code := exitSuccess
+	var processed, skipped, failed int
+	var catalogue []catalogueEntry
+	for _, input := range inputs {
+		output := batchOutputPath(inputDir, input, outputDir)
+		info, err := os.Stat(input)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			continue
+		}
+
+		if !force && (journal.completed(input, output, info.ModTime()) || upToDate(output, info.ModTime())) {
+			if restructure.DebugMode {
+				fmt.Printf("⏭️  Skipping %s: %s is up to date\n", input, output)
+			}
+			summary.Inputs = append(summary.Inputs, inputSummary{Input: input, Output: output, Mode: "process", Status: "skipped"})
+			skipped++
+			if cataloguePath != "" {
+				catalogue = append(catalogue, catalogueBook(input, output, "skipped"))
+			}
+			continue
+		}
+
+		startInput(input, output, "process")
+		inputCode, err := processEPUB(input, output, verbose, writeMapping)
+		finishInput(inputCode, err)
+		if inputCode != exitSuccess {
+			if cataloguePath != "" {
+				catalogue = append(catalogue, catalogueBook(input, output, "failed"))
+			}
+			failed++
+			if code == exitSuccess {
+				code = inputCode
+			}
+			continue
+		}
+		processed++
+		if cataloguePath != "" {
+			catalogue = append(catalogue, catalogueBook(input, output, "processed"))
+		}
+		if err := journal.record(input, output, info.ModTime()); err != nil {
+			fmt.Printf("Warning: %v\n", err)
+		}
+	}
+
+	fmt.Printf("📚 Batch complete: %d processed, %d skipped, %d failed\n", processed, skipped, failed)
+	if cataloguePath != "" {
+		if err := writeCatalogue(catalogue, cataloguePath); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			if code == exitSuccess {
+				code = exitIO
+			}
+		} else {
+			fmt.Printf("🗂️  Catalogue of %d books written to %s\n", len(catalogue), cataloguePath)
+		}
+	}
+	return code
+}
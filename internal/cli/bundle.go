@@ -0,0 +1,290 @@
+package cli
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Bundle formats: archives of EPUBs delivered together, as publishers often do
+const (
+	bundleZip   = "zip"
+	bundleTarGz = "tar.gz"
+)
+
+// maxBundleEntrySize limits the size of each EPUB unpacked from a bundle
+const maxBundleEntrySize = 500 << 20
+
+// bundleInput, bundleOutput and bundleWorkDir locate the bundle being processed, so
+// that the catalogue and run summary name its EPUBs inside the bundle archives
+var bundleInput, bundleOutput, bundleWorkDir string
+
+// bundlePath names a file unpacked from or archived into the current bundle as
+// ARCHIVE!/ENTRY; other paths are returned unchanged
+func bundlePath(filePath string) string {
+	if bundleWorkDir == "" {
+		return filePath
+	}
+	for _, side := range []struct{ dir, archive string }{
+		{filepath.Join(bundleWorkDir, "input"), bundleInput},
+		{filepath.Join(bundleWorkDir, "output"), bundleOutput},
+	} {
+		if rel, err := filepath.Rel(side.dir, filePath); err == nil && !strings.HasPrefix(rel, "..") {
+			return side.archive + "!/" + filepath.ToSlash(rel)
+		}
+	}
+	return filePath
+}
+
+// bundleFormat returns the format of a bundle of EPUBs, or "" when the input is not
+// one; a .zip that is itself an EPUB is not a bundle
+func bundleFormat(input string) string {
+	lower := strings.ToLower(input)
+	switch {
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return bundleTarGz
+	case strings.HasSuffix(lower, ".zip"):
+		reader, err := zip.OpenReader(input)
+		if err != nil {
+			return ""
+		}
+		defer reader.Close()
+		for _, file := range reader.File {
+			if file.Name == "mimetype" {
+				return ""
+			}
+		}
+		return bundleZip
+	}
+	return ""
+}
+
+// bundleOutputPath returns the output archive of a bundle when none is given: the
+// input path with a -fixed suffix before its archive extension
+func bundleOutputPath(input, format string) string {
+	lower := strings.ToLower(input)
+	for _, ext := range []string{".tar.gz", ".tgz", ".zip"} {
+		if strings.HasSuffix(lower, ext) {
+			return input[:len(input)-len(ext)] + "-fixed" + input[len(input)-len(ext):]
+		}
+	}
+	return input + "-fixed." + format
+}
+
+// bundleEntryPath returns where an EPUB entry of a bundle is unpacked in dir, or ""
+// for entries that are not EPUBs or would escape dir
+func bundleEntryPath(dir, name string) string {
+	name = path.Clean(strings.ReplaceAll(name, "\\", "/"))
+	if !strings.EqualFold(path.Ext(name), ".epub") || strings.HasPrefix(name, "../") || path.IsAbs(name) {
+		return ""
+	}
+	// Skip the resource forks macOS adds to zips
+	if strings.HasPrefix(name, "__MACOSX/") || strings.HasPrefix(path.Base(name), "._") {
+		return ""
+	}
+	return filepath.Join(dir, filepath.FromSlash(name))
+}
+
+// writeBundleEntry copies an unpacked EPUB to target, refusing oversized entries
+func writeBundleEntry(target string, src io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	file, err := os.Create(target)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", target, err)
+	}
+	defer file.Close()
+
+	written, err := io.Copy(file, io.LimitReader(src, maxBundleEntrySize+1))
+	if err != nil {
+		return fmt.Errorf("failed to unpack %s: %w", filepath.Base(target), err)
+	}
+	if written > maxBundleEntrySize {
+		return fmt.Errorf("%s is larger than %d bytes", filepath.Base(target), maxBundleEntrySize)
+	}
+	return nil
+}
+
+// unpackBundle unpacks the EPUBs of a bundle into dir, returning how many it held
+func unpackBundle(input, dir, format string) (int, error) {
+	count := 0
+	if format == bundleZip {
+		reader, err := zip.OpenReader(input)
+		if err != nil {
+			return 0, fmt.Errorf("failed to open %s: %w", input, err)
+		}
+		defer reader.Close()
+		for _, file := range reader.File {
+			target := bundleEntryPath(dir, file.Name)
+			if target == "" || file.FileInfo().IsDir() {
+				continue
+			}
+			src, err := file.Open()
+			if err != nil {
+				return count, fmt.Errorf("failed to read %s: %w", file.Name, err)
+			}
+			err = writeBundleEntry(target, src)
+			src.Close()
+			if err != nil {
+				return count, err
+			}
+			count++
+		}
+		return count, nil
+	}
+
+	file, err := os.Open(input)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open %s: %w", input, err)
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s: %w", input, err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return count, fmt.Errorf("failed to read %s: %w", input, err)
+		}
+		target := bundleEntryPath(dir, header.Name)
+		if target == "" || header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := writeBundleEntry(target, tr); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// packBundle archives the files under dir, the processed EPUBs and their companion
+// files, into an output bundle of the given format
+func packBundle(dir, output, format string) error {
+	if err := os.MkdirAll(filepath.Dir(output), 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	file, err := os.Create(output)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", output, err)
+	}
+	defer file.Close()
+
+	var add func(name string, info os.FileInfo, src io.Reader) error
+	var finish func() error
+	if format == bundleZip {
+		zw := zip.NewWriter(file)
+		add = func(name string, info os.FileInfo, src io.Reader) error {
+			header, err := zip.FileInfoHeader(info)
+			if err != nil {
+				return err
+			}
+			// EPUBs are already compressed
+			header.Name, header.Method = name, zip.Store
+			if !strings.EqualFold(path.Ext(name), ".epub") {
+				header.Method = zip.Deflate
+			}
+			w, err := zw.CreateHeader(header)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(w, src)
+			return err
+		}
+		finish = zw.Close
+	} else {
+		gz := gzip.NewWriter(file)
+		tw := tar.NewWriter(gz)
+		add = func(name string, info os.FileInfo, src io.Reader) error {
+			header, err := tar.FileInfoHeader(info, "")
+			if err != nil {
+				return err
+			}
+			header.Name = name
+			if err := tw.WriteHeader(header); err != nil {
+				return err
+			}
+			_, err = io.Copy(tw, src)
+			return err
+		}
+		finish = func() error {
+			if err := tw.Close(); err != nil {
+				return err
+			}
+			return gz.Close()
+		}
+	}
+
+	err = filepath.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return err
+		}
+		src, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		return add(filepath.ToSlash(rel), info, src)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", output, err)
+	}
+	if err := finish(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", output, err)
+	}
+	return nil
+}
+
+// runBundle unpacks a bundle of EPUBs, processes each as a batch and archives the
+// outputs in the same format; it returns the exit code of the first failed EPUB
+func runBundle(input, output, format, cataloguePath string, verbose, writeMapping bool) int {
+	workDir, err := os.MkdirTemp("", "folian-bundle-*")
+	if err != nil {
+		fmt.Printf("Error: failed to create temp directory: %v\n", err)
+		return exitIO
+	}
+	defer os.RemoveAll(workDir)
+	inputDir, outputDir := filepath.Join(workDir, "input"), filepath.Join(workDir, "output")
+	bundleInput, bundleOutput, bundleWorkDir = input, output, workDir
+	defer func() { bundleWorkDir = "" }()
+
+	count, err := unpackBundle(input, inputDir, format)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return exitIO
+	}
+	if count == 0 {
+		fmt.Printf("Error: %s contains no EPUB files\n", input)
+		return exitValidation
+	}
+	fmt.Printf("📦 Unpacked %d EPUB files from %s\n", count, input)
+
+	code := runBatch(inputDir, outputDir, "", cataloguePath, true, verbose, writeMapping)
+	if _, err := os.Stat(outputDir); err != nil {
+		return code
+	}
+	if err := packBundle(outputDir, output, format); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return exitIO
+	}
+	fmt.Printf("📦 Outputs archived to %s\n", output)
+	return code
+}
@@ -0,0 +1,131 @@
+package cli
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/flouciel/folian-parser/internal/stats"
+)
+
+// catalogueEntry is a book of the catalogue written by -catalogue in batch mode
+type catalogueEntry struct {
+	Input          string   `json:"input"`
+	Output         string   `json:"output"`
+	Status         string   `json:"status"`
+	Title          string   `json:"title,omitempty"`
+	Author         string   `json:"author,omitempty"`
+	Language       string   `json:"language,omitempty"`
+	Identifier     string   `json:"identifier,omitempty"`
+	Publisher      string   `json:"publisher,omitempty"`
+	Date           string   `json:"date,omitempty"`
+	Series         string   `json:"series,omitempty"`
+	SeriesIndex    string   `json:"series_index,omitempty"`
+	Subjects       []string `json:"subjects,omitempty"`
+	Chapters       int      `json:"chapters"`
+	Words          int      `json:"words"`
+	ReadingMinutes float64  `json:"reading_minutes"`
+	OutputBytes    int64    `json:"output_bytes"`
+}
+
+// catalogueFormats are the file extensions a catalogue can be written as
+var catalogueFormats = map[string]bool{".csv": true, ".json": true}
+
+// catalogueBook reads the metadata and word counts of a batch output into its
+// catalogue entry; failed inputs keep only their status
+func catalogueBook(input, output, status string) catalogueEntry {
+	entry := catalogueEntry{Input: bundlePath(input), Output: bundlePath(output), Status: status}
+	if status == "failed" {
+		return entry
+	}
+
+	book, err := loadBook(output)
+	if err != nil {
+		fmt.Printf("Warning: Could not read %s for the catalogue: %v\n", output, err)
+		return entry
+	}
+	contentStats := stats.Analyze(book)
+
+	meta := book.Metadata
+	entry.Title = meta.Title
+	entry.Author = meta.Creator
+	entry.Language = meta.Language
+	entry.Identifier = meta.Identifier
+	entry.Publisher = meta.Publisher
+	entry.Date = meta.Date
+	entry.Series = meta.Series
+	entry.SeriesIndex = meta.SeriesIndex
+	entry.Subjects = meta.Subjects
+	entry.Chapters = len(book.Chapters)
+	entry.Words = contentStats.TotalWords
+	entry.ReadingMinutes = contentStats.ReadingMinutes
+	if info, err := os.Stat(output); err == nil {
+		entry.OutputBytes = info.Size()
+	}
+	return entry
+}
+
+// writeCatalogue writes the catalogue, choosing JSON or CSV by file extension
+func writeCatalogue(entries []catalogueEntry, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create catalogue: %w", err)
+	}
+	defer file.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		encoder := json.NewEncoder(file)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(entries)
+	case ".csv":
+		err = writeCatalogueCSV(file, entries)
+	default:
+		return fmt.Errorf("unsupported catalogue format %q (use .json or .csv)", filepath.Ext(path))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write catalogue: %w", err)
+	}
+	return nil
+}
+
+// writeCatalogueCSV writes one row per book, the subjects separated by semicolons
+func writeCatalogueCSV(file *os.File, entries []catalogueEntry) error {
+	writer := csv.NewWriter(file)
+	header := []string{"input", "output", "status", "title", "author", "language", "identifier", "publisher",
+		"date", "series", "series_index", "subjects", "chapters", "words", "reading_minutes", "output_bytes"}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		record := []string{
+			entry.Input,
+			entry.Output,
+			entry.Status,
+			entry.Title,
+			entry.Author,
+			entry.Language,
+			entry.Identifier,
+			entry.Publisher,
+			entry.Date,
+			entry.Series,
+			entry.SeriesIndex,
+			strings.Join(entry.Subjects, "; "),
+			strconv.Itoa(entry.Chapters),
+			strconv.Itoa(entry.Words),
+			strconv.FormatFloat(entry.ReadingMinutes, 'f', 1, 64),
+			strconv.FormatInt(entry.OutputBytes, 10),
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
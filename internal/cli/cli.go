@@ -0,0 +1,1241 @@
+package cli
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/flouciel/folian-parser/folian"
+	"github.com/flouciel/folian-parser/internal/calibre"
+	"github.com/flouciel/folian-parser/internal/comic"
+	"github.com/flouciel/folian-parser/internal/docx"
+	"github.com/flouciel/folian-parser/internal/epub"
+	"github.com/flouciel/folian-parser/internal/epubcheck"
+	"github.com/flouciel/folian-parser/internal/events"
+	"github.com/flouciel/folian-parser/internal/kindle"
+	"github.com/flouciel/folian-parser/internal/latex"
+	"github.com/flouciel/folian-parser/internal/library"
+	"github.com/flouciel/folian-parser/internal/network"
+	"github.com/flouciel/folian-parser/internal/pandoc"
+	"github.com/flouciel/folian-parser/internal/parser"
+	"github.com/flouciel/folian-parser/internal/policy"
+	"github.com/flouciel/folian-parser/internal/quality"
+	"github.com/flouciel/folian-parser/internal/remote"
+	"github.com/flouciel/folian-parser/internal/restructure"
+	"github.com/flouciel/folian-parser/internal/rpc"
+	"github.com/flouciel/folian-parser/internal/stats"
+	"github.com/flouciel/folian-parser/internal/timing"
+	"github.com/flouciel/folian-parser/internal/usage"
+	"github.com/flouciel/folian-parser/internal/version"
+)
+
+// validateEPUB validates the structure and integrity of an EPUB file
+func validateEPUB(epubPath string) error {
+	fmt.Printf("🔍 Validating EPUB: %s\n", epubPath)
+
+	// Check if file exists
+	if _, err := os.Stat(epubPath); os.IsNotExist(err) {
+		return fmt.Errorf("EPUB file not found: %s", epubPath)
+	}
+
+	// An extracted EPUB directory is checked for the same files as an archive
+	var names []string
+	if info, err := os.Stat(epubPath); err == nil && info.IsDir() {
+		if names, err = epub.ExplodedEntries(epubPath); err != nil {
+			return err
+		}
+	} else {
+		// Check that it is a complete ZIP file whose entries read back intact
+		if err := epub.VerifyArchive(epubPath); err != nil {
+			return fmt.Errorf("invalid EPUB file: %w", err)
+		}
+		reader, err := zip.OpenReader(epubPath)
+		if err != nil {
+			return fmt.Errorf("invalid EPUB file (not a valid ZIP): %w", err)
+		}
+		defer reader.Close()
+		for _, file := range reader.File {
+			names = append(names, file.Name)
+		}
+	}
+
+	// Check for required files
+	var hasMimetype, hasContainer, hasOPF bool
+
+	for _, name := range names {
+		switch name {
+		case "mimetype":
+			hasMimetype = true
+		case "META-INF/container.xml":
+			hasContainer = true
+		}
+		if strings.HasSuffix(name, ".opf") {
+			hasOPF = true
+		}
+	}
+
+	if !hasMimetype {
+		policy.Warn(policy.KindValidation, "Missing mimetype file")
+	}
+	if !hasContainer {
+		return fmt.Errorf("missing required META-INF/container.xml")
+	}
+	if !hasOPF {
+		return fmt.Errorf("missing required OPF file")
+	}
+
+	fmt.Println("✅ EPUB validation passed")
+	return nil
+}
+
+// analyzeEPUB analyzes the structure and content of an EPUB file, optionally exporting
+// the content statistics to statsPath as JSON or CSV
+func analyzeEPUB(epubPath, statsPath string) error {
+	fmt.Printf("📊 Analyzing EPUB structure: %s\n", epubPath)
+
+	reader, err := zip.OpenReader(epubPath)
+	if err != nil {
+		return fmt.Errorf("failed to open EPUB: %w", err)
+	}
+	defer reader.Close()
+
+	var contentFiles, imageFiles, cssFiles, fontFiles int
+	var totalSize int64
+
+	for _, file := range reader.File {
+		totalSize += int64(file.UncompressedSize64)
+
+		ext := strings.ToLower(filepath.Ext(file.Name))
+		switch {
+		case ext == ".html" || ext == ".xhtml":
+			if !strings.Contains(file.Name, "nav") &&
+			   !strings.Contains(file.Name, "toc") &&
+			   !strings.Contains(file.Name, "title") &&
+			   !strings.Contains(file.Name, "cover") {
+				contentFiles++
+			}
+		case ext == ".jpg" || ext == ".jpeg" || ext == ".png" || ext == ".gif" || ext == ".webp":
+			imageFiles++
+		case ext == ".css":
+			cssFiles++
+		case ext == ".ttf" || ext == ".otf" || ext == ".woff" || ext == ".woff2":
+			fontFiles++
+		}
+	}
+
+	fmt.Printf("📄 Content files: %d\n", contentFiles)
+	fmt.Printf("🖼️  Images: %d\n", imageFiles)
+	fmt.Printf("🎨 CSS files: %d\n", cssFiles)
+	fmt.Printf("🔤 Fonts: %d\n", fontFiles)
+	fmt.Printf("📦 Total size: %.2f MB\n", float64(totalSize)/(1024*1024))
+
+	// Analyze the chapter content
+	book, err := loadBook(epubPath)
+	if err != nil {
+		return fmt.Errorf("failed to load EPUB content: %w", err)
+	}
+	contentStats := stats.Analyze(book)
+	if fingerprint := restructure.DetectSource(book); fingerprint.Profile != "" {
+		contentStats.Producer = fingerprint.Tool
+		fmt.Printf("🏭 Producer: %s (%s)\n", fingerprint.Tool, strings.Join(fingerprint.Evidence, ", "))
+	} else if len(book.Metadata.Producers) > 0 {
+		contentStats.Producer = book.Metadata.Producers[0]
+		fmt.Printf("🏭 Producer: %s\n", contentStats.Producer)
+	}
+
+	if len(book.Renditions) > 1 {
+		fmt.Printf("📚 Renditions: %d\n", len(book.Renditions))
+		for i, rendition := range book.Renditions {
+			marker := " "
+			if i == book.Rendition {
+				marker = "*"
+			}
+			fmt.Printf("   %s %d. %s\n", marker, i+1, rendition.Describe())
+		}
+	}
+
+	fmt.Printf("📝 Words: %d (≈ %.0f min reading time)\n", contentStats.TotalWords, contentStats.ReadingMinutes)
+	fmt.Printf("🖼️  Image density: %.2f images per 1000 words\n", contentStats.ImagesPer1000Words)
+	fmt.Printf("🔠 Heading depth: h%d\n", contentStats.MaxHeadingDepth)
+	fmt.Printf("🌐 Languages: %s\n", stats.FormatLanguages(contentStats.Languages))
+	fmt.Println("📚 Chapters:")
+	for _, chapter := range contentStats.Chapters {
+		fmt.Printf("   %3d. %-40s %6d words  %5.1f min  %d images\n",
+			chapter.Index, chapter.Title, chapter.Words, chapter.ReadingMinutes, chapter.Images)
+	}
+
+	if statsPath != "" {
+		if err := writeContentStats(contentStats, statsPath); err != nil {
+			return err
+		}
+		fmt.Printf("💾 Content statistics written to %s\n", statsPath)
+	}
+
+	// Provide recommendations
+	if contentFiles > 50 {
+		fmt.Printf("💡 Recommendation: %d content files detected. Enhanced processing will consolidate these into meaningful chapters.\n", contentFiles)
+	}
+	if cssFiles > 3 {
+		fmt.Printf("💡 Recommendation: %d CSS files detected. Processing will consolidate these into a single optimized stylesheet.\n", cssFiles)
+	}
+	if fontFiles == 0 {
+		fmt.Println("💡 Recommendation: No fonts detected. Processing will add the Jura font for consistent typography.")
+	}
+
+	return nil
+}
+
+// writeContentStats exports content statistics, choosing JSON or CSV by file extension
+func writeContentStats(contentStats *stats.BookStats, statsPath string) error {
+	file, err := os.Create(statsPath)
+	if err != nil {
+		return fmt.Errorf("failed to create statistics file: %w", err)
+	}
+	defer file.Close()
+
+	switch strings.ToLower(filepath.Ext(statsPath)) {
+	case ".csv":
+		err = contentStats.WriteCSV(file)
+	case ".json":
+		err = contentStats.WriteJSON(file)
+	default:
+		return fmt.Errorf("unsupported statistics format %q (use .json or .csv)", filepath.Ext(statsPath))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write statistics: %w", err)
+	}
+
+	return nil
+}
+
+// assessEPUB prints a graded quality report for an EPUB file, optionally writing
+// it as JSON to reportPath
+func assessEPUB(epubPath, reportPath string) error {
+	fmt.Printf("🩺 Assessing EPUB quality: %s\n", epubPath)
+
+	book, err := loadBook(epubPath)
+	if err != nil {
+		return fmt.Errorf("failed to load EPUB content: %w", err)
+	}
+
+	report := quality.Assess(book)
+	if err := report.WriteText(os.Stdout); err != nil {
+		return err
+	}
+
+	if reportPath != "" {
+		file, err := os.Create(reportPath)
+		if err != nil {
+			return fmt.Errorf("failed to create quality report: %w", err)
+		}
+		defer file.Close()
+
+		if err := report.WriteJSON(file); err != nil {
+			return fmt.Errorf("failed to write quality report: %w", err)
+		}
+		fmt.Printf("💾 Quality report written to %s\n", reportPath)
+	}
+
+	return nil
+}
+
+// writeChapterMapping writes the chapter mapping entries as indented JSON
+func writeChapterMapping(entries []restructure.MappingEntry, mappingPath string) error {
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode chapter mapping: %w", err)
+	}
+	if err := os.WriteFile(mappingPath, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write chapter mapping: %w", err)
+	}
+	return nil
+}
+
+// compareEPUBs compares two EPUB files and shows the differences
+func compareEPUBs(epub1Path, epub2Path string) error {
+	fmt.Printf("📊 Comparing EPUBs:\n")
+	fmt.Printf("   📖 Original: %s\n", epub1Path)
+	fmt.Printf("   ✨ Enhanced: %s\n", epub2Path)
+	fmt.Println()
+
+	// Analyze both files
+	stats1, err := getEPUBStats(epub1Path)
+	if err != nil {
+		return fmt.Errorf("failed to analyze %s: %w", epub1Path, err)
+	}
+
+	stats2, err := getEPUBStats(epub2Path)
+	if err != nil {
+		return fmt.Errorf("failed to analyze %s: %w", epub2Path, err)
+	}
+
+	// Display comparison
+	fmt.Printf("📄 Content Files:  %d → %d", stats1.ContentFiles, stats2.ContentFiles)
+	if stats2.ContentFiles < stats1.ContentFiles {
+		fmt.Printf(" (📉 %d fewer)", stats1.ContentFiles-stats2.ContentFiles)
+	}
+	fmt.Println()
+
+	fmt.Printf("🖼️  Images:        %d → %d", stats1.ImageFiles, stats2.ImageFiles)
+	if stats2.ImageFiles > stats1.ImageFiles {
+		fmt.Printf(" (📈 %d added)", stats2.ImageFiles-stats1.ImageFiles)
+	}
+	fmt.Println()
+
+	fmt.Printf("🎨 CSS Files:     %d → %d", stats1.CSSFiles, stats2.CSSFiles)
+	if stats2.CSSFiles < stats1.CSSFiles {
+		fmt.Printf(" (📉 %d consolidated)", stats1.CSSFiles-stats2.CSSFiles)
+	}
+	fmt.Println()
+
+	fmt.Printf("🔤 Fonts:         %d → %d", stats1.FontFiles, stats2.FontFiles)
+	if stats2.FontFiles > stats1.FontFiles {
+		fmt.Printf(" (📈 %d added)", stats2.FontFiles-stats1.FontFiles)
+	}
+	fmt.Println()
+
+	fmt.Printf("📦 Size:          %.2f MB → %.2f MB",
+		float64(stats1.TotalSize)/(1024*1024),
+		float64(stats2.TotalSize)/(1024*1024))
+	sizeDiff := float64(stats2.TotalSize-stats1.TotalSize) / (1024 * 1024)
+	if sizeDiff > 0 {
+		fmt.Printf(" (📈 +%.2f MB)", sizeDiff)
+	} else if sizeDiff < 0 {
+		fmt.Printf(" (📉 %.2f MB)", sizeDiff)
+	}
+	fmt.Println()
+
+	return nil
+}
+
+// splitList splits a comma-separated flag value, dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// compilePattern compiles the case-insensitive regular expression of a flag, or
+// returns nil when it is empty
+func compilePattern(name, value string) *regexp.Regexp {
+	if value == "" {
+		return nil
+	}
+	pattern, err := regexp.Compile("(?i)" + value)
+	if err != nil {
+		fmt.Printf("Error: invalid -%s pattern: %v\n", name, err)
+		exit(exitUsage, nil)
+	}
+	return pattern
+}
+
+// EPUBStats holds statistics about an EPUB file
+type EPUBStats struct {
+	ContentFiles int
+	ImageFiles   int
+	CSSFiles     int
+	FontFiles    int
+	TotalSize    int64
+}
+
+// getEPUBStats extracts statistics from an EPUB file
+func getEPUBStats(epubPath string) (*EPUBStats, error) {
+	reader, err := zip.OpenReader(epubPath)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	stats := &EPUBStats{}
+
+	for _, file := range reader.File {
+		stats.TotalSize += int64(file.UncompressedSize64)
+
+		ext := strings.ToLower(filepath.Ext(file.Name))
+		switch {
+		case ext == ".html" || ext == ".xhtml":
+			if !strings.Contains(file.Name, "nav") &&
+			   !strings.Contains(file.Name, "toc") &&
+			   !strings.Contains(file.Name, "title") &&
+			   !strings.Contains(file.Name, "cover") {
+				stats.ContentFiles++
+			}
+		case ext == ".jpg" || ext == ".jpeg" || ext == ".png" || ext == ".gif" || ext == ".webp":
+			stats.ImageFiles++
+		case ext == ".css":
+			stats.CSSFiles++
+		case ext == ".ttf" || ext == ".otf" || ext == ".woff" || ext == ".woff2":
+			stats.FontFiles++
+		}
+	}
+
+	return stats, nil
+}
+
+// Main is the entry point of the folian-parser command
+func Main() {
+	// Print the warnings and progress published while processing
+	events.Subscribe(printEvent)
+
+	// Handle subcommands before parsing the global flags
+	if len(os.Args) > 1 {
+		if run, ok := subcommands[os.Args[1]]; ok {
+			os.Exit(subcommandExitCode(run(os.Args[2:])))
+		}
+	}
+
+	// Parse command-line arguments; flag errors exit with the usage code
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	inputPath := flag.String("i", "", "Input EPUB file path, a Word manuscript (.docx), the main .tex file of a LaTeX project, an extracted EPUB directory, a directory of EPUB files to process in batch, or an https:// or s3:// URL to download")
+	viaPandocFlag := flag.Bool("via-pandoc", false, "Convert an ODT, reStructuredText, AsciiDoc or other input pandoc reads to chapters with pandoc before restructuring it")
+	viaCalibreFlag := flag.Bool("via-calibre", false, "Convert a DOCX, RTF, LIT, MOBI or other input Calibre reads to EPUB with its ebook-convert before restructuring it")
+	maxDownloadFlag := flag.Int64("max-download", remote.DefaultMaxSize>>20, "Largest remote input downloaded, in MB")
+	inputSHA256Flag := flag.String("input-sha256", "", "Expected SHA-256 of a remote input; the download is refused when it differs")
+	allowHTTPFlag := flag.Bool("allow-http", false, "Allow downloading a remote input over plain http; only https and s3 are fetched otherwise")
+	outputPath := flag.String("o", "", "Output EPUB file path, or the output directory in batch mode")
+	formatDir := flag.String("f", "format", "Path to the format directory containing templates and assets")
+	workdirFlag := flag.String("workdir", "", "Working directory the relative paths of the options are read from, created if missing")
+	cacheDirFlag := flag.String("cache-dir", "", "Directory of the parse and chapter caches (default: the user cache directory)")
+	versionFlag := flag.Bool("v", false, "Display version information")
+	debugFlag := flag.Bool("d", false, "Enable debug output")
+	updateFlag := flag.Bool("u", false, "Check for updates and update if a newer version is available")
+	keepFilenamesFlag := flag.Bool("keep-filenames", false, "Keep the source file names instead of renaming files with spaces, '#', '%' or non-ASCII characters to URL-safe names")
+	windowsSafeFlag := flag.Bool("windows-safe", false, "Rename entries Windows cannot create (COM1.xhtml, names ending in a dot, reserved characters) and rewrite the references to them; always on on Windows")
+	workersFlag := flag.Int("workers", epub.Workers, "Number of archive entries extracted or compressed in parallel")
+	recompressFlag := flag.Bool("recompress", false, "Compress every entry of the output again instead of copying unchanged images, fonts and media from the input as they are")
+	repairFlag := flag.Bool("repair", false, "Salvage the intact entries of a corrupt or truncated EPUB and rebuild a missing mimetype, container.xml or OPF instead of refusing it")
+	offlineFlag := flag.Bool("offline", false, "Disable all network access (update checks, -fetch-meta, -jacket-lookup); also enabled by "+network.OfflineEnv+"=1")
+	channelFlag := flag.String("channel", version.ChannelStable, "Release channel checked by -u: "+strings.Join(version.Channels, " or ")+" (beta includes pre-releases)")
+	analyzeFlag := flag.Bool("a", false, "Analyze EPUB structure without processing")
+	statsFlag := flag.String("stats", "", "Export content statistics from -a to a .json or .csv file")
+	qualityFlag := flag.Bool("quality", false, "Print a graded quality report without processing")
+	qualityOutFlag := flag.String("quality-out", "", "Also write the quality report as JSON to this file")
+	validateFlag := flag.Bool("validate", false, "Validate EPUB structure only")
+	epubcheckFlag := flag.Bool("epubcheck", false, "Validate the output (or the -validate input) with EPUBCheck, found in the PATH or through "+epubcheck.JarEnv+", or with its built-in subset when not installed, and report its findings")
+	kindlePreviewFlag := flag.Bool("kindle-preview", false, "Convert the output with Kindle Previewer, when installed or found through "+kindle.PreviewerEnv+", and report its conversion warnings and errors")
+	strictFlag := flag.Bool("strict", false, "Fail the run on any warning: missing alt text, invalid dates, unresolved links, or -epubcheck errors and warnings")
+	lenientFlag := flag.Bool("lenient", false, "Skip chapters that cannot be read or written, with a warning, instead of failing the run")
+	enhancedFlag := flag.Bool("enhanced", false, "Use enhanced processing with intelligent chapter consolidation")
+	compareFlag := flag.String("compare", "", "Compare two EPUB files (provide second file path)")
+	seriesFlag := flag.String("series", "", "Series name to record in the output metadata")
+	seriesIndexFlag := flag.String("series-index", "", "Position of the book in its series (e.g. 2 or 2.5)")
+	addSubjectsFlag := flag.String("add-subjects", "", "Comma-separated subjects to add to the output metadata")
+	removeSubjectsFlag := flag.String("remove-subjects", "", "Comma-separated subjects to remove from the output metadata")
+	subjectCodesFlag := flag.Bool("subject-codes", false, "Map subjects to BISAC and Thema codes from the bundled table")
+	newIdentifierFlag := flag.Bool("new-identifier", false, "Assign a new UUID identifier (derivative edition), keeping the original as dc:source")
+	coverMaxSizeFlag := flag.Int("cover-max-size", 0, "Scale the cover down so neither side exceeds this many pixels, e.g. 1600 (0 keeps the original size)")
+	normalizeCoverFlag := flag.Bool("normalize-cover", false, "Convert WEBP covers to JPEG and GIF covers to PNG for device compatibility")
+	coverThumbnailFlag := flag.Bool("cover-thumbnail", false, "Generate a small cover thumbnail (images/cover-thumbnail.jpg) for stores that require one")
+	compatFlag := flag.Bool("compat", false, "Compatibility profile for older readers: transcode WEBP/AVIF images to JPEG/PNG (requires ImageMagick or ffmpeg)")
+	profileFlag := flag.String("profile", "", "Device profile: "+strings.Join(restructure.ProfileNames(), ", "))
+	textAlignFlag := flag.String("text-align", "", "Align body text: justify or left (default: as the theme)")
+	hyphenateFlag := flag.Bool("hyphenate", false, "Hyphenate body text automatically")
+	widowsFlag := flag.Int("widows", 0, "Least lines of a paragraph left alone at the top or bottom of a page, setting widows and orphans (0: as the theme)")
+	paragraphStyleFlag := flag.String("paragraph-style", "", "Separate paragraphs by first-line indent or by space between them: indent or spaced (default: as the theme)")
+	darkModeFlag := flag.Bool("dark-mode", false, "Emit night-mode friendly CSS: hardcoded colors and background images apply only through prefers-color-scheme queries")
+	lintCSSFlag := flag.String("lint-css", "", "Check output CSS against device capabilities: warn (report) or fix (also apply fallbacks)")
+	keepNavChaptersFlag := flag.Bool("keep-nav-chapters", false, "Keep navigation chapters during -enhanced consolidation instead of redirecting links to them to nav.xhtml")
+	jacketTextFlag := flag.String("jacket-text", "", "JSON file with the description, author_bio and series_blurb to show on the jacket page")
+	jacketLookupFlag := flag.Bool("jacket-lookup", false, "Look up the missing jacket description and author bio on OpenLibrary/Google Books by ISBN")
+	stripAdsFlag := flag.Bool("strip-ads", false, "Remove publisher ads, newsletter signups and piracy-site watermarks (pages and repeated blocks), with a report")
+	adAllowFlag := flag.String("ad-allow", "", "With -strip-ads, regular expression (case-insensitive) of text never removed")
+	adDenyFlag := flag.String("ad-deny", "", "With -strip-ads, regular expression (case-insensitive) of text always removed")
+	watermarkNameFlag := flag.String("watermark-name", "", "Purchaser name to stamp in the colophon of a personalized copy")
+	watermarkEmailFlag := flag.String("watermark-email", "", "Purchaser email to stamp in the colophon of a personalized copy")
+	watermarkIDFlag := flag.String("watermark-id", "", "Invisible identifier of a personalized copy recorded in the OPF (default: derived from the purchaser and book)")
+	ttsFlag := flag.Bool("tts", false, "Annotate the output for read-aloud systems: keep and link the book's PLS lexicons and wrap sentences in spans with IDs")
+	lexiconFlag := flag.String("lexicon", "", "PLS pronunciation lexicon to add to the book and link from every chapter")
+	audioFlag := flag.String("audio", "", "Directory of narrated MP3 or AAC files with their cue sheet; adds media overlays synchronized at paragraph level for a read-along edition")
+	normalizeTextFlag := flag.Bool("normalize-text", false, "Normalize the text: repair double-encoded UTF-8 (mojibake) and Windows-1252 characters, compose decomposed letters, remove zero-width characters and misused no-break spaces, with per-language rules")
+	normalizeTitlesFlag := flag.Bool("normalize-titles", false, "Normalize chapter titles: recase ALL-CAPS titles (title case in English, sentence case otherwise), fix spacing and trailing periods")
+	uiLangFlag := flag.String("ui-lang", "", "Language of the generated labels (chapter titles, Cover, Table of Contents, ...) instead of the book's language, e.g. vi or en")
+	altTextFlag := flag.String("alt-text", "", "JSON file mapping image paths or file names to the alt text of images that have none")
+	altTextCommandFlag := flag.String("alt-text-command", "", "Shell command proposing the alt text of an image that has none, e.g. an offline captioning model (image in FOLIAN_IMAGE; prints the alt text)")
+	altTextReportFlag := flag.String("alt-text-report", "", "Write a JSON list of the images without alt text and the alt text they were given")
+	spellFlag := flag.String("spell", "", "Directory of Hunspell dictionaries (en_US.aff/en_US.dic, ...) to check the spelling of the chapters against, in each chapter's language")
+	spellReportFlag := flag.String("spell-report", "", "Write a JSON list of the unknown and autocorrected words with their chapter and line")
+	autocorrectFlag := flag.String("autocorrect", "", "JSON file mapping misspelled words to their corrections, which replace them in the text")
+	houseLexiconFlag := flag.String("house-lexicon", "", "JSON house-style lexicon of banned words, preferred spellings and trademark capitalizations to check the chapters against")
+	houseLexiconFixFlag := flag.Bool("house-lexicon-fix", false, "Replace the variant spellings and miscapitalized trademarks of -house-lexicon instead of only reporting them")
+	houseLexiconReportFlag := flag.String("house-lexicon-report", "", "Write a JSON list of the -house-lexicon violations with their chapter and line")
+	stringsFlag := flag.String("strings", "", "JSON file of custom label translations by language, overriding the bundled ones")
+	fetchMetaFlag := flag.Bool("fetch-meta", false, "Fill missing publisher, date, description, subjects and cover from OpenLibrary/Google Books by ISBN or title and author")
+	metaPolicyFlag := flag.String("meta-policy", "ask", "How -fetch-meta picks a title/author search result: ask (prompt) or best (closest match, non-interactive)")
+	dictionaryFlag := flag.Bool("dictionary", false, "Dictionary mode: keep entry files unmerged, keep headwords and entry IDs, and write an EPUB3 search key map")
+	periodicalFlag := flag.String("periodical", "", "Periodical mode for newsletters and magazines delivered as EPUB: "+strings.Join(restructure.PeriodicalKinds, ", ")+"; sections of articles with bylines, dated title and output")
+	sourceFlag := flag.String("source", "", "Source profile tuning the cleanup to the tool or site the book comes from, detected by default: "+strings.Join(restructure.SourceProfiles, ", ")+", or none to turn the cleanup off")
+	issueDateFlag := flag.String("issue-date", "", "With -periodical, date of the issue as YYYY-MM-DD (default: today)")
+	popupFootnotesFlag := flag.Bool("popup-footnotes", false, "Convert footnote references to EPUB3 noterefs with the notes as asides in the same chapter, shown as popups by iBooks/Kobo")
+	pageWordsFlag := flag.Int("page-words", 0, "Synthesize a print page break every N words when the book has none (0 disables)")
+	idPolicyFlag := flag.String("id-policy", "referenced", "IDs chapter cleaning keeps: referenced (every ID the book links to), slugs (also stable heading IDs made from their text) or strip (only IDs indexes, citations and glossaries link to)")
+	figureListsFlag := flag.Bool("figure-lists", false, "Add lists of figures and tables (nav loi/lot) built from their captions")
+	keepVendorFlag := flag.String("keep-vendor", "", "Comma-separated vendor cleanup rules to turn off, keeping what they remove: "+strings.Join(restructure.VendorCleanups, ", ")+", or all")
+	preserveExtrasFlag := flag.Bool("preserve-extras", false, "Preserve Apple display options and convert Adobe page-map.xml instead of dropping them")
+	mappingFlag := flag.Bool("mapping", false, "Write a JSON chapter mapping (original file/fragment → output chapter/anchor) next to the output EPUB")
+	preHookFlag := flag.String("pre-hook", "", "Shell command run on the extracted EPUB before processing (FOLIAN_EXTRACTED_DIR and metadata in the environment); failure aborts")
+	postHookFlag := flag.String("post-hook", "", "Shell command run on the output EPUB (FOLIAN_OUTPUT and metadata in the environment), e.g. to scan or upload it")
+	lcpFlag := flag.Bool("lcp", false, "Encrypt the output for Readium LCP and write its content key and license server details next to it, in OUTPUT.lcp.json (e.g. book.epub.lcp.json)")
+	onixFlag := flag.Bool("onix", false, "Write an ONIX 3.0 product record of the output to OUTPUT.onix.xml for distributors")
+	checkIdempotentFlag := flag.Bool("check-idempotent", false, "Process the EPUB twice and report any differences between the passes")
+	forceFlag := flag.Bool("force", false, "In batch mode, process inputs whose output is already up to date or recorded in the journal")
+	libraryFlag := flag.Bool("library", false, "Record processed books, source hashes, options and validation results in the SQLite library database (requires sqlite3)")
+	libraryDBFlag := flag.String("library-db", library.DefaultPath(), "Location of the library database used by -library")
+	usageStatsFlag := flag.Bool("usage-stats", false, "Count processed books, their sizes and warnings in a local usage statistics file, printed by folian-parser stats; nothing is sent anywhere")
+	usageStatsFileFlag := flag.String("usage-stats-file", usage.DefaultPath(), "Location of the usage statistics file used by -usage-stats")
+	catalogueFlag := flag.String("catalogue", "", "In batch mode, write a catalogue of the books (metadata, word counts, output paths) to this .csv or .json file")
+	journalFlag := flag.String("journal", "", "In batch mode, record completed inputs in this file and skip them when the run is resumed")
+	renditionFlag := flag.String("rendition", "", "Rendition to process in multi-rendition EPUBs: its number, label, language, layout or OPF path (default: the first)")
+	keepRenditionsFlag := flag.Bool("keep-renditions", false, "Carry the other renditions of a multi-rendition EPUB into the output unchanged")
+	noCacheFlag := flag.Bool("no-cache", false, "Do not read or write the cache of extracted and parsed EPUBs and cleaned chapters")
+	openFlag := flag.Bool("open", false, "Show the output in the browser after processing, with its table of contents and reading order beside the documents, until Ctrl-C")
+	timingsFlag := flag.Bool("timings", false, "Report the wall time and allocations of each processing stage (extract, parse, consolidate, clean, package, ...)")
+	pprofFlag := flag.String("pprof", "", "Serve the pprof profiling endpoints on this address (e.g. localhost:6060) while the run lasts")
+	cpuProfileFlag := flag.String("cpuprofile", "", "Write a CPU profile of the run to this file, for go tool pprof")
+	serveGRPCFlag := flag.String("serve-grpc", "", "Serve the Folian gRPC service (proto/folian/v1/folian.proto) on this address, e.g. :50051, processing the books of the calls with the other options of the run")
+	grpcRootFlag := flag.String("grpc-root", "", "Directory the input_path and output_path of gRPC calls are resolved in; without it the calls only take inline books")
+	grpcMaxRequestFlag := flag.Int64("grpc-max-request", rpc.DefaultMaxRequest>>20, "Largest gRPC request accepted, in MB")
+	presetFlag := flag.String("preset", "", "Replay the options saved in a preset file; options given on the command line override it")
+	exportPresetFlag := flag.String("export-preset", "", "Write every option of the run, after -preset, to a preset file to share or replay")
+	summaryJSONFlag := flag.String("summary-json", "", "Write a machine-readable JSON summary of the run (status, exit code, error) to this file")
+	if err := flag.CommandLine.Parse(os.Args[1:]); err != nil {
+		if err == flag.ErrHelp {
+			os.Exit(exitSuccess)
+		}
+		os.Exit(exitUsage)
+	}
+
+	// Set the options not given on the command line from the environment, then
+	// enter the working directory before any relative path is used
+	if err := applyEnvironment(); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(exitUsage)
+	}
+	if *workdirFlag != "" {
+		if err := enterWorkdir(*workdirFlag); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(exitIO)
+		}
+	}
+	if err := ensureTempDir(); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(exitIO)
+	}
+	summaryPath = *summaryJSONFlag
+
+	// Replay a preset and save the effective options before they are read
+	if *presetFlag != "" {
+		if err := applyPreset(*presetFlag); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			exit(exitUsage, err)
+		}
+	}
+	if *exportPresetFlag != "" {
+		if err := exportPreset(*exportPresetFlag); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			exit(exitIO, err)
+		}
+	}
+
+	// Set output validation with EPUBCheck
+	runEPUBCheck = *epubcheckFlag
+	runKindlePreviewer = *kindlePreviewFlag
+
+	// Set the processing policy
+	if *strictFlag && *lenientFlag {
+		fmt.Println("Error: -strict and -lenient cannot be combined")
+		exit(exitUsage, nil)
+	}
+	policy.Strict = *strictFlag
+	policy.Lenient = *lenientFlag
+
+	// Set stage timings and profiling
+	timing.Enabled = *timingsFlag
+	if err := startProfiling(*pprofFlag, *cpuProfileFlag); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		exit(exitIO, err)
+	}
+
+	// Set the library database processing runs are recorded in
+	if *libraryFlag {
+		libraryPath = *libraryDBFlag
+	}
+
+	// Set the local usage statistics processed books are counted in
+	if *usageStatsFlag {
+		usagePath = *usageStatsFileFlag
+		events.Subscribe(countChapters)
+	}
+
+	// Set offline mode before anything can reach the network
+	if *offlineFlag || network.OfflineFromEnv() {
+		network.Disable()
+	}
+
+	// Handle update check
+	if *updateFlag {
+		version.CacheRoot = *cacheDirFlag
+		release, err := version.LatestRelease(*channelFlag)
+		if errors.Is(err, version.ErrUnavailable) {
+			fmt.Printf("ℹ️  Skipping the update check: %v\n", err)
+			exit(exitSuccess, nil)
+		}
+		if err != nil {
+			fmt.Printf("Error checking for updates: %v\n", err)
+			exit(exitIO, err)
+		}
+
+		if version.Compare(release.Version(), version.Version) > 0 {
+			fmt.Printf("A new %s version is available: %s (current: %s)\n", *channelFlag, release.Version(), version.Version)
+			fmt.Println("Updating to the latest version...")
+			if err := version.Update(release); err != nil {
+				fmt.Printf("Error updating: %v\n", err)
+				exit(exitIO, err)
+			}
+			fmt.Println("Update completed successfully!")
+			exit(exitSuccess, nil)
+		} else {
+			fmt.Printf("You are running the latest %s version (%s)\n", *channelFlag, version.Version)
+			exit(exitSuccess, nil)
+		}
+	}
+
+	// Display version information if requested
+	if *versionFlag {
+		fmt.Printf("Folian Parser version %s\n", version.Version)
+		exit(exitSuccess, nil)
+	}
+
+	// Set the format directory path
+	restructure.FormatDirPath = *formatDir
+
+	// Set debug mode
+	restructure.DebugMode = *debugFlag
+
+	// Set rendition selection
+	parser.SelectRendition = *renditionFlag
+	restructure.KeepRenditions = *keepRenditionsFlag
+
+	// Set parse and chapter caching
+	epub.NoCache = *noCacheFlag
+	epub.CacheRoot = *cacheDirFlag
+	if dir, err := epub.CacheDir(); err == nil && !epub.NoCache {
+		restructure.ChapterCacheDir = filepath.Join(filepath.Dir(dir), "chapters")
+		restructure.ChapterCacheOptions = runOptions(reportingOptions...)
+	}
+
+	// Set the renaming of unsafe entry names
+	epub.KeepFilenames = *keepFilenamesFlag
+	if *windowsSafeFlag {
+		epub.WindowsSafeNames = true
+	}
+
+	// Set repair of corrupt inputs
+	epub.Repair = *repairFlag
+
+	// Set parallel extraction and packaging
+	epub.Workers = *workersFlag
+	epub.RawCopy = !*recompressFlag
+
+	// Set LCP encryption of the output
+	epub.LCP = *lcpFlag
+
+	// Set enhanced mode
+	restructure.EnhancedMode = *enhancedFlag
+
+	// Set page break synthesis
+	restructure.PageBreakWords = *pageWordsFlag
+
+	// Set the lists of figures and tables
+	restructure.FigureLists = *figureListsFlag
+
+	// Set series metadata overrides
+	restructure.SeriesName = *seriesFlag
+	restructure.SeriesIndex = *seriesIndexFlag
+
+	// Set subject edits and BISAC/Thema mapping
+	restructure.AddSubjects = splitList(*addSubjectsFlag)
+	restructure.RemoveSubjects = splitList(*removeSubjectsFlag)
+	restructure.SubjectCodes = *subjectCodesFlag
+
+	// Set identifier strategy
+	restructure.NewIdentifier = *newIdentifierFlag
+
+	// Set cover image processing
+	restructure.CoverMaxSize = *coverMaxSizeFlag
+	restructure.NormalizeCover = *normalizeCoverFlag
+	restructure.CoverThumbnail = *coverThumbnailFlag
+	restructure.CompatibilityProfile = *compatFlag
+
+	// Set CSS linting
+	if *lintCSSFlag != "" && *lintCSSFlag != "warn" && *lintCSSFlag != "fix" {
+		fmt.Printf("Error: -lint-css must be warn or fix, got %q\n", *lintCSSFlag)
+		exit(exitUsage, nil)
+	}
+	restructure.CSSLint = *lintCSSFlag
+	restructure.DarkModeCSS = *darkModeFlag
+
+	// Set the house style options
+	if *textAlignFlag != "" && !containsString(restructure.TextAligns, *textAlignFlag) {
+		fmt.Printf("Error: Unknown text alignment %q (use %s)\n", *textAlignFlag, strings.Join(restructure.TextAligns, ", "))
+		exit(exitUsage, nil)
+	}
+	if *paragraphStyleFlag != "" && !containsString(restructure.ParagraphStyles, *paragraphStyleFlag) {
+		fmt.Printf("Error: Unknown paragraph style %q (use %s)\n", *paragraphStyleFlag, strings.Join(restructure.ParagraphStyles, ", "))
+		exit(exitUsage, nil)
+	}
+	restructure.TextAlign = *textAlignFlag
+	restructure.Hyphenate = *hyphenateFlag
+	restructure.WidowsOrphans = *widowsFlag
+	restructure.ParagraphStyle = *paragraphStyleFlag
+
+	// Set the device profile
+	if err := restructure.SetProfile(*profileFlag); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		exit(exitUsage, err)
+	}
+
+	// Set navigation chapter handling
+	restructure.KeepNavigationChapters = *keepNavChaptersFlag
+
+	// Set the jacket text sources
+	restructure.JacketTextFile = *jacketTextFlag
+	restructure.JacketLookup = *jacketLookupFlag
+
+	// Set advertisement removal and its patterns
+	restructure.StripAds = *stripAdsFlag
+	restructure.AdAllowPattern = compilePattern("ad-allow", *adAllowFlag)
+	restructure.AdDenyPattern = compilePattern("ad-deny", *adDenyFlag)
+
+	// Set the personalization watermark
+	restructure.WatermarkName = *watermarkNameFlag
+	restructure.WatermarkEmail = *watermarkEmailFlag
+	restructure.WatermarkID = *watermarkIDFlag
+
+	// Set text-to-speech annotation
+	restructure.TTSMode = *ttsFlag
+	restructure.LexiconFile = *lexiconFlag
+
+	// Set the narration of a read-along edition
+	restructure.AudioDir = *audioFlag
+
+	// Set the ONIX record export
+	restructure.ONIXExport = *onixFlag
+
+	// Set chapter title normalization
+	restructure.NormalizeTitles = *normalizeTitlesFlag
+
+	// Set text normalization
+	restructure.NormalizeText = *normalizeTextFlag
+
+	// Set the language and translations of the generated labels
+	restructure.UILanguage = *uiLangFlag
+	restructure.StringsFile = *stringsFlag
+
+	// Set alt text assistance
+	restructure.AltTextFile = *altTextFlag
+	restructure.AltTextCommand = *altTextCommandFlag
+	restructure.AltTextReport = *altTextReportFlag
+
+	// Set spelling checks and autocorrection
+	restructure.SpellDictionaries = *spellFlag
+	restructure.SpellReport = *spellReportFlag
+	restructure.AutocorrectFile = *autocorrectFlag
+
+	// Set house-style lexicon checks
+	restructure.HouseLexicon = *houseLexiconFlag
+	restructure.HouseLexiconFix = *houseLexiconFixFlag
+	restructure.HouseLexiconReport = *houseLexiconReportFlag
+
+	// Set online metadata fetching
+	if *metaPolicyFlag != "ask" && *metaPolicyFlag != "best" {
+		fmt.Printf("Error: -meta-policy must be ask or best, got %q\n", *metaPolicyFlag)
+		exit(exitUsage, nil)
+	}
+	restructure.FetchMetadata = *fetchMetaFlag
+	restructure.FetchMetadataPolicy = *metaPolicyFlag
+
+	// Skip the online lookups in offline mode
+	if network.Offline && (restructure.FetchMetadata || restructure.JacketLookup) {
+		fmt.Println("ℹ️  Offline mode: skipping -fetch-meta and -jacket-lookup")
+		restructure.FetchMetadata = false
+		restructure.JacketLookup = false
+	}
+
+	// Set dictionary mode
+	restructure.DictionaryMode = *dictionaryFlag
+
+	// Set periodical mode and the issue date, which names the output
+	if *periodicalFlag != "" {
+		if !containsString(restructure.PeriodicalKinds, *periodicalFlag) {
+			fmt.Printf("Error: Unknown periodical %q (use %s)\n", *periodicalFlag, strings.Join(restructure.PeriodicalKinds, ", "))
+			exit(exitUsage, nil)
+		}
+		issueDate := *issueDateFlag
+		if issueDate == "" {
+			issueDate = time.Now().Format("2006-01-02")
+		} else if _, err := time.Parse("2006-01-02", issueDate); err != nil {
+			fmt.Printf("Error: Invalid -issue-date %q (use YYYY-MM-DD)\n", issueDate)
+			exit(exitUsage, nil)
+		}
+		restructure.PeriodicalKind = *periodicalFlag
+		restructure.IssueDate = issueDate
+		*outputPath = strings.ReplaceAll(*outputPath, "{date}", issueDate)
+	} else if *issueDateFlag != "" {
+		fmt.Println("Error: -issue-date requires -periodical")
+		exit(exitUsage, nil)
+	}
+
+	// Set the source profile
+	if *sourceFlag != "" && *sourceFlag != "none" && !containsString(restructure.SourceProfiles, *sourceFlag) {
+		fmt.Printf("Error: Unknown source profile %q (use %s or none)\n", *sourceFlag, strings.Join(restructure.SourceProfiles, ", "))
+		exit(exitUsage, nil)
+	}
+	restructure.SourceProfile = *sourceFlag
+
+	// Set the ID policy
+	if !containsString(restructure.IDPolicies, *idPolicyFlag) {
+		fmt.Printf("Error: Unknown ID policy %q (use %s)\n", *idPolicyFlag, strings.Join(restructure.IDPolicies, ", "))
+		exit(exitUsage, nil)
+	}
+	restructure.IDPolicy = *idPolicyFlag
+
+	// Set footnote popup conversion
+	restructure.PopupFootnotes = *popupFootnotesFlag
+
+	// Set vendor support file preservation
+	restructure.PreserveExtras = *preserveExtrasFlag
+
+	// Set the vendor cleanup rules turned off
+	for _, rule := range strings.Split(*keepVendorFlag, ",") {
+		rule = strings.TrimSpace(rule)
+		switch {
+		case rule == "":
+		case rule == "all":
+			for _, name := range restructure.VendorCleanups {
+				restructure.KeepVendor[name] = true
+			}
+		case containsString(restructure.VendorCleanups, rule):
+			restructure.KeepVendor[rule] = true
+		default:
+			fmt.Printf("Error: Unknown vendor cleanup rule %q (use %s or all)\n", rule, strings.Join(restructure.VendorCleanups, ", "))
+			exit(exitUsage, nil)
+		}
+	}
+
+	// Ensure the format directory exists and contains all necessary files; the
+	// default one falls back to a temporary copy when it cannot be written
+	if err := folian.WriteDefaultFormat(*formatDir); err != nil {
+		if *formatDir != flag.Lookup("f").DefValue {
+			fmt.Printf("Error: %v\n", err)
+			exit(exitIO, err)
+		}
+		dir, fallbackErr := fallbackFormatDirectory()
+		if fallbackErr != nil {
+			err = fmt.Errorf("%v, and the built-in format files cannot be used: %w", err, fallbackErr)
+			fmt.Printf("Error: %v\n", err)
+			exit(exitIO, err)
+		}
+		fmt.Printf("ℹ️  Using the built-in format files (%v)\n", err)
+		restructure.FormatDirPath = dir
+	}
+
+	// Serve the gRPC service of a processing worker instead of one input
+	if *serveGRPCFlag != "" {
+		epub.PreHook = *preHookFlag
+		epub.PostHook = *postHookFlag
+		if err := serveGRPC(*serveGRPCFlag, *grpcRootFlag, *grpcMaxRequestFlag<<20); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			exit(exitIO, err)
+		}
+		exit(exitSuccess, nil)
+	}
+
+	// Validate input path
+	if *inputPath == "" {
+		fmt.Println("Error: Input file path is required")
+		flag.Usage()
+		exit(exitUsage, nil)
+	}
+
+	// Download a remote input, naming its default output after the remote file
+	if remote.IsURL(*inputPath) {
+		if *outputPath == "" {
+			*outputPath = defaultOutputPath(remote.Filename(*inputPath))
+		}
+		remote.AllowHTTP = *allowHTTPFlag
+		localPath, err := fetchInput(*inputPath, *maxDownloadFlag, *inputSHA256Flag)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			inputSource = *inputPath
+			startInput(*inputPath, "", "process")
+			if errors.Is(err, remote.ErrInsecure) {
+				exit(exitUsage, err)
+			}
+			exit(exitIO, err)
+		}
+		*inputPath = localPath
+	}
+
+	// Handle a bundle of EPUBs (.zip or .tar.gz), processed as a batch into an
+	// archive of the same format
+	if format := bundleFormat(*inputPath); format != "" {
+		if *analyzeFlag || *qualityFlag || *validateFlag || *compareFlag != "" || *checkIdempotentFlag {
+			fmt.Println("Error: Bundle inputs only support processing")
+			exit(exitUsage, nil)
+		}
+		if *catalogueFlag != "" && !catalogueFormats[strings.ToLower(filepath.Ext(*catalogueFlag))] {
+			fmt.Printf("Error: Unsupported catalogue format %q (use .json or .csv)\n", filepath.Ext(*catalogueFlag))
+			exit(exitUsage, nil)
+		}
+		if *outputPath == "" {
+			*outputPath = bundleOutputPath(*inputPath, format)
+			if inputSource != "" {
+				*outputPath = bundleOutputPath(remote.Filename(inputSource), format)
+			}
+		}
+		epub.PreHook = *preHookFlag
+		epub.PostHook = *postHookFlag
+		exit(runBundle(*inputPath, *outputPath, format, *catalogueFlag, *debugFlag || *enhancedFlag, *mappingFlag), nil)
+	}
+
+	// Check if input file exists
+	inputInfo, err := os.Stat(*inputPath)
+	if os.IsNotExist(err) {
+		fmt.Printf("Error: Input file does not exist: %s\n", *inputPath)
+		startInput(*inputPath, "", "process")
+		exit(exitIO, err)
+	}
+
+	// Convert an input with pandoc first when asked to, naming its default output
+	// after the original
+	if *viaPandocFlag && !inputInfo.IsDir() {
+		if *viaCalibreFlag {
+			fmt.Println("Error: -via-pandoc and -via-calibre cannot be combined")
+			exit(exitUsage, nil)
+		}
+		if pandoc.Format(*inputPath) == "" {
+			fmt.Printf("Error: -via-pandoc does not read %s inputs\n", filepath.Ext(*inputPath))
+			exit(exitUsage, nil)
+		}
+		if *outputPath == "" {
+			*outputPath = defaultOutputPath(strings.TrimSuffix(*inputPath, filepath.Ext(*inputPath)) + ".epub")
+		}
+		convertedPath, err := convertPandoc(*inputPath)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			startInput(*inputPath, "", "process")
+			exit(exitCode(err, exitIO), err)
+		}
+		*inputPath = convertedPath
+	}
+
+	// Read a Word manuscript natively unless it is converted with Calibre, naming
+	// its default output after the manuscript
+	if docx.IsDOCX(*inputPath) && !*viaCalibreFlag && !inputInfo.IsDir() {
+		if *outputPath == "" {
+			*outputPath = defaultOutputPath(strings.TrimSuffix(*inputPath, filepath.Ext(*inputPath)) + ".epub")
+		}
+		convertedPath, err := convertDOCX(*inputPath)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			startInput(*inputPath, "", "process")
+			exit(exitParse, err)
+		}
+		*inputPath = convertedPath
+	}
+
+	// Read a LaTeX project from its main file, naming its default output after it
+	if latex.IsLaTeX(*inputPath) && !inputInfo.IsDir() {
+		if *outputPath == "" {
+			*outputPath = defaultOutputPath(strings.TrimSuffix(*inputPath, filepath.Ext(*inputPath)) + ".epub")
+		}
+		convertedPath, err := convertLaTeX(*inputPath)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			startInput(*inputPath, "", "process")
+			exit(exitParse, err)
+		}
+		*inputPath = convertedPath
+	}
+
+	// Read the pages and ComicInfo.xml of a comic archive, naming its default output
+	// after it
+	if comic.Format(*inputPath) != "" && !inputInfo.IsDir() {
+		if *outputPath == "" {
+			*outputPath = defaultOutputPath(strings.TrimSuffix(*inputPath, filepath.Ext(*inputPath)) + ".epub")
+		}
+		convertedPath, err := convertComic(*inputPath)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			startInput(*inputPath, "", "process")
+			exit(exitParse, err)
+		}
+		*inputPath = convertedPath
+	}
+
+	if format := pandoc.Format(*inputPath); format != "" && calibre.Format(*inputPath) == "" && !inputInfo.IsDir() {
+		fmt.Printf("Error: %s inputs are not read natively; add -via-pandoc to convert them with pandoc first\n", format)
+		exit(exitUsage, nil)
+	}
+
+	// Convert an input in another format to EPUB with Calibre first, naming its
+	// default output after the original
+	if format := calibre.Format(*inputPath); format != "" && !inputInfo.IsDir() {
+		if !*viaCalibreFlag {
+			if pandoc.Format(*inputPath) != "" {
+				fmt.Printf("Error: %s inputs are not read natively; add -via-calibre or -via-pandoc to convert them first\n", format)
+			} else {
+				fmt.Printf("Error: %s inputs are not read natively; add -via-calibre to convert them with Calibre first\n", format)
+			}
+			exit(exitUsage, nil)
+		}
+		if *outputPath == "" {
+			*outputPath = defaultOutputPath(strings.TrimSuffix(*inputPath, filepath.Ext(*inputPath)) + ".epub")
+		}
+		convertedPath, err := convertInput(*inputPath)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			startInput(*inputPath, "", "process")
+			exit(exitCode(err, exitIO), err)
+		}
+		*inputPath = convertedPath
+	}
+
+	// Set the processing hooks
+	epub.PreHook = *preHookFlag
+	epub.PostHook = *postHookFlag
+
+	// Handle an extracted EPUB directory, processed as a single book
+	exploded := err == nil && inputInfo.IsDir() && epub.IsExplodedEPUB(*inputPath)
+	if exploded && (*analyzeFlag || *qualityFlag || *compareFlag != "" || *checkIdempotentFlag) {
+		fmt.Println("Error: An extracted EPUB directory only supports processing and -validate")
+		exit(exitUsage, nil)
+	}
+
+	// Handle batch mode
+	if err == nil && inputInfo.IsDir() && !exploded {
+		if *analyzeFlag || *qualityFlag || *validateFlag || *compareFlag != "" || *checkIdempotentFlag {
+			fmt.Println("Error: Batch mode (a directory input) only supports processing")
+			exit(exitUsage, nil)
+		}
+		if *catalogueFlag != "" && !catalogueFormats[strings.ToLower(filepath.Ext(*catalogueFlag))] {
+			fmt.Printf("Error: Unsupported catalogue format %q (use .json or .csv)\n", filepath.Ext(*catalogueFlag))
+			exit(exitUsage, nil)
+		}
+		exit(runBatch(*inputPath, *outputPath, *journalFlag, *catalogueFlag, *forceFlag, *debugFlag || *enhancedFlag, *mappingFlag), nil)
+	}
+
+	// Handle analyze-only mode
+	if *analyzeFlag {
+		startInput(*inputPath, "", "analyze")
+		if err := analyzeEPUB(*inputPath, *statsFlag); err != nil {
+			fmt.Printf("Error analyzing EPUB: %v\n", err)
+			exit(exitParse, err)
+		}
+		exit(exitSuccess, nil)
+	}
+
+	// Handle quality report mode
+	if *qualityFlag {
+		startInput(*inputPath, "", "quality")
+		if err := assessEPUB(*inputPath, *qualityOutFlag); err != nil {
+			fmt.Printf("Error assessing EPUB: %v\n", err)
+			exit(exitCode(err, exitParse), err)
+		}
+		exit(exitSuccess, nil)
+	}
+
+	// Handle validate-only mode
+	if *validateFlag {
+		startInput(*inputPath, "", "validate")
+		if err := validateEPUB(*inputPath); err != nil {
+			fmt.Printf("Error validating EPUB: %v\n", err)
+			exit(exitValidation, err)
+		}
+		if info, err := os.Stat(*inputPath); runEPUBCheck && err == nil && !info.IsDir() {
+			if err := checkEPUB(*inputPath); err != nil {
+				fmt.Printf("Error validating EPUB: %v\n", err)
+				exit(exitValidation, err)
+			}
+		}
+		if err := policy.Check(); err != nil {
+			fmt.Printf("Error validating EPUB: %v\n", err)
+			exit(exitValidation, err)
+		}
+		exit(exitSuccess, nil)
+	}
+
+	// Handle compare mode
+	if *compareFlag != "" {
+		startInput(*inputPath, "", "compare")
+		if err := compareEPUBs(*inputPath, *compareFlag); err != nil {
+			fmt.Printf("Error comparing EPUBs: %v\n", err)
+			exit(exitParse, err)
+		}
+		exit(exitSuccess, nil)
+	}
+
+	// Handle idempotency check mode
+	if *checkIdempotentFlag {
+		if *lcpFlag {
+			fmt.Println("Error: -check-idempotent cannot read back -lcp output")
+			exit(exitUsage, nil)
+		}
+		startInput(*inputPath, "", "check-idempotent")
+		if err := checkIdempotency(*inputPath); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			exit(exitCode(err, exitValidation), err)
+		}
+		exit(exitSuccess, nil)
+	}
+
+	// Generate output path if not provided
+	if *outputPath == "" {
+		*outputPath = defaultOutputPath(*inputPath)
+	}
+
+	// Validate, process and check the EPUB
+	startInput(*inputPath, *outputPath, "process")
+	code, err := processEPUB(*inputPath, *outputPath, *debugFlag || *enhancedFlag, *mappingFlag)
+
+	// Show the output; encrypted output cannot be read back
+	if *openFlag && code == exitSuccess && epub.LCP {
+		fmt.Println("ℹ️  Not showing the LCP-encrypted output")
+	} else if *openFlag && code == exitSuccess {
+		if err := showReader(*outputPath); err != nil {
+			fmt.Printf("Warning: %v\n", err)
+		}
+	}
+	exit(code, err)
+}
+
+// defaultOutputPath returns the output path used when none is given: the input
+// path with a -fixed suffix, or the issue date of a periodical
+func defaultOutputPath(inputPath string) string {
+	suffix := "-fixed"
+	if restructure.PeriodicalKind != "" {
+		suffix = "-" + restructure.IssueDate
+	}
+	// An extracted EPUB directory is packed next to itself
+	if info, err := os.Stat(inputPath); err == nil && info.IsDir() {
+		return filepath.Clean(inputPath) + suffix + ".epub"
+	}
+	ext := filepath.Ext(inputPath)
+	base := filepath.Base(inputPath)
+	dir := filepath.Dir(inputPath)
+	return filepath.Join(dir, base[:len(base)-len(ext)]+suffix+ext)
+}
+
+// processEPUB validates and restructures one EPUB, writing its chapter mapping and
+// checking the output when requested; it returns the exit code of the input
+func processEPUB(inputPath, outputPath string, verbose, writeMapping bool) (int, error) {
+	// Validate input EPUB before processing; in repair mode the damage is repaired
+	if err := validateEPUB(inputPath); err != nil {
+		if !epub.Repair {
+			fmt.Printf("Error: %v\n", err)
+			return exitValidation, err
+		}
+		fmt.Printf("Warning: %v, repairing\n", err)
+	}
+
+	// Analyze input structure
+	if verbose {
+		fmt.Println("\n📊 Input Analysis:")
+		if err := analyzeEPUB(inputPath, ""); err != nil {
+			fmt.Printf("Warning: Could not analyze input EPUB: %v\n", err)
+		}
+		fmt.Println()
+	}
+
+	// Create output directory if it doesn't exist
+	outputDir := filepath.Dir(outputPath)
+	if _, err := os.Stat(outputDir); os.IsNotExist(err) {
+		if err := os.MkdirAll(outputDir, 0755); err != nil {
+			fmt.Printf("Error: Failed to create output directory: %v\n", err)
+			return exitIO, err
+		}
+	}
+
+	// Create a processor
+	processor := epub.NewProcessor()
+
+	// Process the EPUB file
+	fmt.Printf("🔄 Processing EPUB: %s → %s\n", inputPath, outputPath)
+	err := processor.Process(inputPath, outputPath)
+	setBook(processor.Book())
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return exitCode(err, exitIO), err
+	}
+
+	fmt.Printf("✅ EPUB file successfully restructured: %s\n", outputPath)
+
+	// Write the chapter mapping alongside the EPUB
+	if writeMapping {
+		mappingPath := strings.TrimSuffix(outputPath, filepath.Ext(outputPath)) + ".mapping.json"
+		if err := writeChapterMapping(processor.Mapping(), mappingPath); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return exitIO, err
+		}
+		fmt.Printf("🗺️  Chapter mapping written to %s\n", mappingPath)
+	}
+
+	// Validate the output with EPUBCheck; encrypted output cannot be read back
+	if runEPUBCheck && epub.LCP {
+		fmt.Println("ℹ️  Skipping EPUBCheck validation of the LCP-encrypted output")
+	} else if runEPUBCheck {
+		if err := checkEPUB(outputPath); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return exitValidation, err
+		}
+	}
+
+	// Check the Kindle conversion of the output; encrypted output cannot be converted
+	if runKindlePreviewer && epub.LCP {
+		fmt.Println("ℹ️  Skipping the Kindle conversion check of the LCP-encrypted output")
+	} else if runKindlePreviewer {
+		if err := previewKindle(outputPath); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return exitValidation, err
+		}
+	}
+
+	// Post-processing validation and analysis; encrypted output cannot be read back
+	if verbose && epub.LCP {
+		fmt.Println("ℹ️  Skipping post-processing validation of the LCP-encrypted output")
+	} else if verbose {
+		fmt.Println("\n🔍 Post-processing Validation:")
+		if err := validateEPUB(outputPath); err != nil {
+			policy.Warn(policy.KindValidation, "Output validation failed: %v", err)
+		}
+
+		fmt.Println("\n📊 Output Analysis:")
+		if err := analyzeEPUB(outputPath, ""); err != nil {
+			fmt.Printf("Warning: Could not analyze output EPUB: %v\n", err)
+		}
+
+		fmt.Println("\n🩺 Output Quality:")
+		if err := assessEPUB(outputPath, ""); err != nil {
+			fmt.Printf("Warning: Could not assess output EPUB: %v\n", err)
+		}
+	}
+
+	// Strict runs fail on the warnings recorded while processing
+	if err := policy.Check(); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return exitValidation, err
+	}
+
+	return exitSuccess, nil
+}
@@ -0,0 +1,126 @@
+package cli
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/flouciel/folian-parser/folian"
+	"github.com/flouciel/folian-parser/internal/clip"
+	"github.com/flouciel/folian-parser/internal/epub"
+	"github.com/flouciel/folian-parser/internal/network"
+	"github.com/flouciel/folian-parser/internal/restructure"
+)
+
+// runClipCommand implements the clip subcommand, compiling web articles and feeds
+// into a book with a chapter per article:
+//
+//	folian-parser clip [-o clippings.epub] [-f format] [-title T] [-author A] [-lang L] [-list urls.txt] [-limit N] [-no-images] [-enhanced] url...
+func runClipCommand(args []string) error {
+	flags := flag.NewFlagSet("clip", flag.ContinueOnError)
+	outputPath := flags.String("o", "clippings.epub", "Output EPUB file path")
+	formatDir := flags.String("f", "format", "Path to the format directory containing templates and assets")
+	title := flags.String("title", "", "Title of the book (default: the feed's title, or \"Clippings\")")
+	author := flags.String("author", "", "Author of the book (default: the author of all the articles, when they share one)")
+	language := flags.String("lang", "", "Language of the book (default: that of the feed or the first article)")
+	listPath := flags.String("list", "", "File listing article or feed URLs, one per line; # starts a comment")
+	limit := flags.Int("limit", 20, "Most articles read from each feed, 0 for all of them")
+	noImages := flags.Bool("no-images", false, "Leave out the images of the articles")
+	enhanced := flags.Bool("enhanced", false, "Use enhanced processing with intelligent chapter consolidation")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: folian-parser clip [-o clippings.epub] [-f format] [-title T] [-author A] [-lang L] [-list urls.txt] [-limit N] [-no-images] [-enhanced] url...")
+		flags.PrintDefaults()
+	}
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	urls := flags.Args()
+	if *listPath != "" {
+		listed, err := readURLList(*listPath)
+		if err != nil {
+			return err
+		}
+		urls = append(urls, listed...)
+	}
+	if len(urls) == 0 {
+		flags.Usage()
+		return usageErrorf("clip requires at least one article or feed URL")
+	}
+	for _, u := range urls {
+		if !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+			return usageErrorf("%s is not an http(s) URL", u)
+		}
+	}
+	if *limit < 0 {
+		return usageErrorf("-limit must not be negative")
+	}
+	if network.OfflineFromEnv() {
+		return fmt.Errorf("clip requires network access, which %s=1 disables", network.OfflineEnv)
+	}
+
+	if err := folian.WriteDefaultFormat(*formatDir); err != nil {
+		return fmt.Errorf("failed to set up format directory: %w", err)
+	}
+	restructure.FormatDirPath = *formatDir
+	restructure.EnhancedMode = *enhanced
+
+	dir, err := os.MkdirTemp("", "folian-clip-*")
+	if err != nil {
+		return fmt.Errorf("failed to create clip directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	fmt.Printf("✂️  Clipping %d URLs\n", len(urls))
+	bookDir := filepath.Join(dir, "book")
+	document, err := clip.Compile(urls, bookDir, clip.Options{
+		Title:    *title,
+		Author:   *author,
+		Language: *language,
+		Limit:    *limit,
+		Images:   !*noImages,
+	})
+	if err != nil {
+		return err
+	}
+	packed := filepath.Join(dir, "clippings.epub")
+	if err := epub.Pack(bookDir, packed); err != nil {
+		return fmt.Errorf("failed to package the articles: %w", err)
+	}
+	fmt.Printf("ℹ️  Read %d articles and %d images for %s\n", document.Articles, document.Images, document.Title)
+	if document.Skipped > 0 {
+		fmt.Printf("ℹ️  Skipped %d articles that could not be read\n", document.Skipped)
+	}
+
+	if err := epub.NewProcessor().Process(packed, *outputPath); err != nil {
+		return fmt.Errorf("failed to process the articles: %w", err)
+	}
+	fmt.Printf("✅ Compiled %d articles into %s\n", document.Articles, *outputPath)
+	return nil
+}
+
+// readURLList reads the URLs listed in a file, one per line, skipping blank lines
+// and comments
+func readURLList(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open URL list: %w", err)
+	}
+	defer file.Close()
+
+	var urls []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			urls = append(urls, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read URL list: %w", err)
+	}
+	return urls, nil
+}
@@ -0,0 +1,95 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/flouciel/folian-parser/folian"
+)
+
+// envPrefix starts the environment variables setting options, e.g. FOLIAN_TEXT_ALIGN
+// for -text-align, so that containers can be configured without a command line
+const envPrefix = "FOLIAN_"
+
+// envOmitted are the options whose variable means something else: FOLIAN_OFFLINE
+// is read by the network package, and FOLIAN_SERIES gives hooks the series of
+// their book
+var envOmitted = []string{"offline", "series"}
+
+// envName returns the environment variable of an option
+func envName(option string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(option, "-", "_"))
+}
+
+// applyEnvironment sets the options that were not given on the command line from
+// their environment variables; a preset only sets the options neither gives
+func applyEnvironment() error {
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	var err error
+	flag.VisitAll(func(f *flag.Flag) {
+		if err != nil || given[f.Name] || containsString(envOmitted, f.Name) {
+			return
+		}
+		value, ok := os.LookupEnv(envName(f.Name))
+		if !ok {
+			return
+		}
+		if setErr := flag.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("invalid value %q in %s for -%s: %w", value, envName(f.Name), f.Name, setErr)
+		}
+	})
+	return err
+}
+
+// enterWorkdir makes dir the working directory, which the relative paths of the
+// options are read from
+func enterWorkdir(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create working directory: %w", err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		return fmt.Errorf("failed to enter working directory: %w", err)
+	}
+	return nil
+}
+
+// ensureTempDir checks that temporary directories can be created, and otherwise
+// points TMPDIR at .folian-tmp in the working directory, as for a non-root user
+// of a container whose temporary directory is read-only
+func ensureTempDir() error {
+	dir, err := os.MkdirTemp("", "folian-check-*")
+	if err == nil {
+		return os.Remove(dir)
+	}
+	fallback, absErr := filepath.Abs(".folian-tmp")
+	if absErr != nil {
+		return fmt.Errorf("temporary directory is not writable: %w", err)
+	}
+	if mkErr := os.MkdirAll(fallback, 0700); mkErr != nil {
+		return fmt.Errorf("temporary directory is not writable (%v), nor %s: %w", err, fallback, mkErr)
+	}
+	os.Setenv("TMPDIR", fallback)
+	fmt.Printf("ℹ️  Temporary directory is not writable, using %s\n", fallback)
+	return nil
+}
+
+// fallbackFormatDirectory writes the default format files to a temporary format
+// directory, removed on exit, for when the default one cannot be written, as in a
+// read-only container
+func fallbackFormatDirectory() (string, error) {
+	dir, err := os.MkdirTemp("", "folian-format-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create format directory: %w", err)
+	}
+	downloadDirs = append(downloadDirs, dir)
+	if err := folian.WriteDefaultFormat(dir); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
@@ -0,0 +1,167 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/flouciel/folian-parser/internal/calibre"
+	"github.com/flouciel/folian-parser/internal/comic"
+	"github.com/flouciel/folian-parser/internal/docx"
+	"github.com/flouciel/folian-parser/internal/epub"
+	"github.com/flouciel/folian-parser/internal/latex"
+	"github.com/flouciel/folian-parser/internal/pandoc"
+	"github.com/flouciel/folian-parser/internal/version"
+)
+
+// conversion records how an input in another format was converted to EPUB
+type conversion struct {
+	Tool    string `json:"tool"`
+	Version string `json:"version,omitempty"`
+	Source  string `json:"source"`
+	Format  string `json:"format"`
+}
+
+// inputConversion is the conversion of the input, recorded in the summary
+var inputConversion *conversion
+
+// conversionDir creates a temporary directory for a converted input, removed on exit
+func conversionDir() (string, error) {
+	dir, err := os.MkdirTemp("", "folian-convert-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create conversion directory: %w", err)
+	}
+	downloadDirs = append(downloadDirs, dir)
+	return dir, nil
+}
+
+// convertInput converts an input Calibre reads to an EPUB in a temporary directory
+// and returns the local path it is processed from
+func convertInput(inputPath string) (string, error) {
+	if !calibre.Installed() {
+		return "", fmt.Errorf("-via-calibre requires Calibre's ebook-convert in the PATH or %s", calibre.ConvertEnv)
+	}
+	dir, err := conversionDir()
+	if err != nil {
+		return "", err
+	}
+
+	fmt.Printf("🔁 Converting %s to EPUB with Calibre\n", inputPath)
+	converted, err := calibre.Convert(inputPath, dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to convert %s: %w", inputPath, err)
+	}
+	fmt.Printf("ℹ️  Converted %s from %s with %s %s\n", inputPath, converted.Format, converted.Tool, converted.Version)
+	inputConversion = &conversion{Tool: converted.Tool, Version: converted.Version, Source: inputPath, Format: converted.Format}
+	return converted.Output, nil
+}
+
+// convertDOCX reads a Word manuscript into an EPUB in a temporary directory and
+// returns the local path it is processed from
+func convertDOCX(inputPath string) (string, error) {
+	dir, err := conversionDir()
+	if err != nil {
+		return "", err
+	}
+
+	fmt.Printf("📝 Reading Word manuscript: %s\n", inputPath)
+	bookDir := filepath.Join(dir, "book")
+	document, err := docx.Convert(inputPath, bookDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", inputPath, err)
+	}
+	base := filepath.Base(inputPath)
+	epubPath := filepath.Join(dir, strings.TrimSuffix(base, filepath.Ext(base))+".epub")
+	if err := epub.Pack(bookDir, epubPath); err != nil {
+		return "", fmt.Errorf("failed to package %s: %w", inputPath, err)
+	}
+	fmt.Printf("ℹ️  Read %d chapters and %d images from %s\n", document.Chapters, document.Images, inputPath)
+	inputConversion = &conversion{Tool: "folian-parser", Version: version.Version, Source: inputPath, Format: "DOCX"}
+	return epubPath, nil
+}
+
+// convertComic reads a comic archive into an EPUB in a temporary directory and
+// returns the local path it is processed from
+func convertComic(inputPath string) (string, error) {
+	dir, err := conversionDir()
+	if err != nil {
+		return "", err
+	}
+
+	fmt.Printf("💬 Reading comic archive: %s\n", inputPath)
+	bookDir := filepath.Join(dir, "book")
+	document, err := comic.Convert(inputPath, bookDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", inputPath, err)
+	}
+	base := filepath.Base(inputPath)
+	epubPath := filepath.Join(dir, strings.TrimSuffix(base, filepath.Ext(base))+".epub")
+	if err := epub.Pack(bookDir, epubPath); err != nil {
+		return "", fmt.Errorf("failed to package %s: %w", inputPath, err)
+	}
+	issue := ""
+	if document.Series != "" {
+		issue = " of " + document.Series
+		if document.Issue != "" {
+			issue += " #" + document.Issue
+		}
+	}
+	fmt.Printf("ℹ️  Read %d pages%s from %s\n", document.Pages, issue, inputPath)
+	if !document.ComicInfo {
+		fmt.Printf("ℹ️  %s has no ComicInfo.xml; its metadata is limited to the title from its name\n", inputPath)
+	}
+	inputConversion = &conversion{Tool: "folian-parser", Version: version.Version, Source: inputPath, Format: document.Format}
+	return epubPath, nil
+}
+
+// convertLaTeX reads a LaTeX project into an EPUB in a temporary directory and
+// returns the local path it is processed from
+func convertLaTeX(inputPath string) (string, error) {
+	dir, err := conversionDir()
+	if err != nil {
+		return "", err
+	}
+
+	fmt.Printf("📐 Reading LaTeX project: %s\n", inputPath)
+	bookDir := filepath.Join(dir, "book")
+	document, err := latex.Convert(inputPath, bookDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", inputPath, err)
+	}
+	base := filepath.Base(inputPath)
+	epubPath := filepath.Join(dir, strings.TrimSuffix(base, filepath.Ext(base))+".epub")
+	if err := epub.Pack(bookDir, epubPath); err != nil {
+		return "", fmt.Errorf("failed to package %s: %w", inputPath, err)
+	}
+	fmt.Printf("ℹ️  Read %d chapters, %d images and %d equations from %s\n", document.Chapters, document.Images, document.Equations, inputPath)
+	inputConversion = &conversion{Tool: "folian-parser", Version: version.Version, Source: inputPath, Format: "LaTeX"}
+	return epubPath, nil
+}
+
+// convertPandoc converts an input pandoc reads into an EPUB in a temporary directory
+// and returns the local path it is processed from
+func convertPandoc(inputPath string) (string, error) {
+	if !pandoc.Installed() {
+		return "", fmt.Errorf("-via-pandoc requires pandoc in the PATH or %s", pandoc.PathEnv)
+	}
+	dir, err := conversionDir()
+	if err != nil {
+		return "", err
+	}
+
+	fmt.Printf("🔁 Converting %s to EPUB with pandoc\n", inputPath)
+	bookDir := filepath.Join(dir, "book")
+	document, err := pandoc.Convert(inputPath, bookDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to convert %s: %w", inputPath, err)
+	}
+	base := filepath.Base(inputPath)
+	epubPath := filepath.Join(dir, strings.TrimSuffix(base, filepath.Ext(base))+".epub")
+	if err := epub.Pack(bookDir, epubPath); err != nil {
+		return "", fmt.Errorf("failed to package %s: %w", inputPath, err)
+	}
+	fmt.Printf("ℹ️  Converted %s from %s with pandoc %s: %d chapters and %d images\n", inputPath, document.Format, document.Version, document.Chapters, document.Images)
+	inputConversion = &conversion{Tool: "pandoc", Version: document.Version, Source: inputPath, Format: document.Format}
+	return epubPath, nil
+}
@@ -0,0 +1,86 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/flouciel/folian-parser/internal/diff"
+	"github.com/flouciel/folian-parser/internal/epub"
+	"github.com/flouciel/folian-parser/internal/parser"
+)
+
+// runDiffCommand implements the diff subcommand:
+//
+//	folian-parser diff [-format text|html|json] [-o report] old.epub new.epub
+func runDiffCommand(args []string) error {
+	flags := flag.NewFlagSet("diff", flag.ContinueOnError)
+	format := flags.String("format", "text", "Report format: text, html or json")
+	outputPath := flags.String("o", "", "Write the report to this file instead of stdout")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: folian-parser diff [-format text|html|json] [-o report] old.epub new.epub")
+		flags.PrintDefaults()
+	}
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return usageErrorf("diff requires exactly two EPUB files")
+	}
+
+	report, err := diffEPUBs(flags.Arg(0), flags.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *outputPath != "" {
+		file, err := os.Create(*outputPath)
+		if err != nil {
+			return fmt.Errorf("failed to create report file: %w", err)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	if err := report.Write(w, *format); err != nil {
+		return fmt.Errorf("failed to write diff report: %w", err)
+	}
+
+	if *outputPath != "" {
+		fmt.Printf("✅ Diff report written to %s\n", *outputPath)
+	}
+	return nil
+}
+
+// diffEPUBs loads two EPUB files and compares their content
+func diffEPUBs(oldPath, newPath string) (*diff.Report, error) {
+	oldBook, err := loadBook(oldPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %w", oldPath, err)
+	}
+
+	newBook, err := loadBook(newPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %w", newPath, err)
+	}
+
+	report := diff.Compare(oldBook, newBook)
+	report.Old = oldPath
+	report.New = newPath
+	return report, nil
+}
+
+// loadBook extracts and parses an EPUB file, keeping the parsed content in memory
+func loadBook(epubPath string) (*parser.Book, error) {
+	tempDir, err := os.MkdirTemp("", "epub-load-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	return epub.NewProcessor().Load(epubPath, tempDir)
+}
@@ -0,0 +1,34 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/flouciel/folian-parser/internal/epubcheck"
+	"github.com/flouciel/folian-parser/internal/policy"
+)
+
+// runEPUBCheck validates outputs with EPUBCheck, or its built-in subset, after processing
+var runEPUBCheck bool
+
+// checkEPUB validates an EPUB with EPUBCheck, printing and recording its findings in the
+// run summary; in strict runs, its errors and warnings fail the input
+func checkEPUB(epubPath string) error {
+	if !epubcheck.Installed() {
+		fmt.Printf("ℹ️  %s is not installed (or %s is not set), using the built-in checks\n", epubcheck.Command, epubcheck.JarEnv)
+	}
+	report, err := epubcheck.Run(epubPath)
+	if err != nil {
+		return fmt.Errorf("failed to run epubcheck: %w", err)
+	}
+	if current != nil {
+		current.EPUBCheck = report
+	}
+	if err := report.WriteText(os.Stdout); err != nil {
+		return err
+	}
+	if policy.Strict && report.Errors+report.Warnings > 0 {
+		return fmt.Errorf("%s reported %d errors and %d warnings in %s", report.Validator, report.Errors, report.Warnings, epubPath)
+	}
+	return nil
+}
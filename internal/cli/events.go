@@ -0,0 +1,25 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/flouciel/folian-parser/internal/events"
+	"github.com/flouciel/folian-parser/internal/restructure"
+)
+
+// printEvent prints the processing events the command reports: every warning, and
+// the chapters and resources written in debug mode
+func printEvent(event events.Event) {
+	switch e := event.(type) {
+	case events.WarningRaised:
+		fmt.Printf("Warning: %s\n", e.Message)
+	case events.ChapterProcessed:
+		if restructure.DebugMode {
+			fmt.Printf("✅ Created chapter: %s (%d chars)\n", e.File, e.Size)
+		}
+	case events.ResourceCopied:
+		if restructure.DebugMode {
+			fmt.Printf("✅ Copied %s: %s → %s\n", e.Kind, e.Source, e.Target)
+		}
+	}
+}
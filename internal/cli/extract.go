@@ -0,0 +1,86 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/flouciel/folian-parser/folian"
+	"github.com/flouciel/folian-parser/internal/epub"
+	"github.com/flouciel/folian-parser/internal/extract"
+	"github.com/flouciel/folian-parser/internal/parser"
+	"github.com/flouciel/folian-parser/internal/restructure"
+)
+
+// runExtractCommand implements the extract subcommand:
+//
+//	folian-parser extract [-format text|markdown] [-o dir] [-f format] [-raw] book.epub
+func runExtractCommand(args []string) error {
+	flags := flag.NewFlagSet("extract", flag.ContinueOnError)
+	format := flags.String("format", extract.FormatText, "Output format: text or markdown")
+	outputDir := flags.String("o", "", "Output directory (default: the book's name without extension)")
+	formatDir := flags.String("f", "format", "Path to the format directory used to clean the book")
+	raw := flags.Bool("raw", false, "Extract the chapters as they are, without cleaning the book first")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: folian-parser extract [-format text|markdown] [-o dir] [-f format] [-raw] book.epub")
+		flags.PrintDefaults()
+	}
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return usageErrorf("extract requires exactly one EPUB file")
+	}
+	if *format != extract.FormatText && *format != extract.FormatMarkdown {
+		return usageErrorf("unknown extract format %q (use text or markdown)", *format)
+	}
+
+	inputPath := flags.Arg(0)
+	if *outputDir == "" {
+		*outputDir = strings.TrimSuffix(inputPath, filepath.Ext(inputPath))
+	}
+
+	var book *parser.Book
+	var err error
+	if *raw {
+		book, err = loadBook(inputPath)
+	} else {
+		book, err = loadCleanBook(inputPath, *formatDir)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load %s: %w", inputPath, err)
+	}
+
+	written, err := extract.Write(book, *outputDir, *format)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("✅ Extracted %d chapters to %s\n", len(written), *outputDir)
+	return nil
+}
+
+// loadCleanBook restructures an EPUB into a temporary file and loads the result, so
+// that the extracted chapters are those of the cleaned book
+func loadCleanBook(epubPath, formatDir string) (*parser.Book, error) {
+	restructure.FormatDirPath = formatDir
+	if err := folian.WriteDefaultFormat(formatDir); err != nil {
+		return nil, fmt.Errorf("failed to set up format directory: %w", err)
+	}
+
+	tempDir, err := os.MkdirTemp("", "epub-extract-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	cleanPath := filepath.Join(tempDir, "clean.epub")
+	if err := epub.NewProcessor().Process(epubPath, cleanPath); err != nil {
+		return nil, fmt.Errorf("failed to clean book: %w", err)
+	}
+	return loadBook(cleanPath)
+}
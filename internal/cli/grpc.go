@@ -0,0 +1,314 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+
+	"github.com/flouciel/folian-parser/internal/epubcheck"
+	"github.com/flouciel/folian-parser/internal/events"
+	"github.com/flouciel/folian-parser/internal/policy"
+	"github.com/flouciel/folian-parser/internal/quality"
+	"github.com/flouciel/folian-parser/internal/rpc"
+	"github.com/flouciel/folian-parser/internal/version"
+)
+
+// grpcService is the path prefix of the methods of the folian.v1.Folian service
+// defined in proto/folian/v1/folian.proto
+const grpcService = "/folian.v1.Folian/"
+
+// grpcHealthCheck is the method of the standard gRPC health checking protocol,
+// grpc.health.v1.Health
+const grpcHealthCheck = "/grpc.health.v1.Health/Check"
+
+// healthServing is the SERVING status of a HealthCheckResponse
+const healthServing = 1
+
+// grpcWorker answers the calls of the Folian service one book at a time, as the
+// processing options and state are shared
+type grpcWorker struct {
+	mu sync.Mutex
+	// root is the directory the paths of the requests are resolved in; without one
+	// books are only sent and returned inline
+	root string
+}
+
+// bookRequest is a decoded ProcessRequest or BookRequest
+type bookRequest struct {
+	epub       []byte
+	inputPath  string
+	outputPath string
+	// inline returns the output in the result, written to a temporary outputPath
+	inline bool
+}
+
+// grpcResult is the Result message ending a call
+type grpcResult struct {
+	code       int
+	err        error
+	epub       []byte
+	outputPath string
+	report     interface{}
+}
+
+// serveGRPC serves the Folian service on addr with the options of the run until
+// Ctrl-C, reading and writing the paths of the requests under root and refusing
+// request messages larger than maxRequest bytes
+func serveGRPC(addr, root string, maxRequest int64) error {
+	worker := &grpcWorker{}
+	if root != "" {
+		resolved, err := filepath.Abs(root)
+		if err == nil {
+			resolved, err = filepath.EvalSymlinks(resolved)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to resolve the gRPC root: %w", err)
+		}
+		worker.root = resolved
+	}
+	health := http.NewServeMux()
+	health.HandleFunc("/healthz", serveHealth)
+	server := rpc.NewServer(health)
+	server.MaxRequest = maxRequest
+	server.Handle(grpcHealthCheck, checkHealth)
+	server.Handle(grpcService+"Process", worker.handle("process", worker.process))
+	server.Handle(grpcService+"Analyze", worker.handle("analyze", worker.analyze))
+	server.Handle(grpcService+"Validate", worker.handle("validate", worker.validate))
+	httpServer := &http.Server{Addr: addr, Handler: server.Handler()}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	go func() {
+		<-interrupt
+		httpServer.Shutdown(context.Background())
+	}()
+
+	fmt.Printf("🛰️  Serving the Folian gRPC service on %s (Ctrl-C to stop)\n", addr)
+	if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return fmt.Errorf("failed to serve gRPC: %w", err)
+	}
+	return nil
+}
+
+// serveHealth answers the HTTP health checks of container orchestrators, with
+// the version serving
+func serveHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok", "version": version.Version})
+}
+
+// checkHealth answers the gRPC health checks: the worker serves as long as it
+// answers, a busy one queuing the calls
+func checkHealth(request []byte, send func(rpc.Message) error) error {
+	var message rpc.Message
+	message.Int(1, healthServing)
+	return send(message)
+}
+
+// handle returns the handler of a method: it decodes the request, writes an inline
+// book to a temporary file, streams the events published while the method runs and
+// sends its result
+func (g *grpcWorker) handle(mode string, method func(input string, request bookRequest) grpcResult) rpc.StreamHandler {
+	return func(data []byte, send func(rpc.Message) error) error {
+		request, err := decodeBookRequest(data)
+		if err != nil {
+			return err
+		}
+		if request.inputPath != "" {
+			if request.inputPath, err = g.resolvePath(request.inputPath); err != nil {
+				return err
+			}
+		}
+		if request.outputPath != "" {
+			if request.outputPath, err = g.resolvePath(request.outputPath); err != nil {
+				return err
+			}
+		}
+
+		g.mu.Lock()
+		defer g.mu.Unlock()
+
+		tempDir, err := os.MkdirTemp("", "folian-grpc-*")
+		if err != nil {
+			return rpc.Errorf(rpc.CodeInternal, "failed to create temp directory: %v", err)
+		}
+		defer os.RemoveAll(tempDir)
+		input := request.inputPath
+		if len(request.epub) > 0 {
+			input = filepath.Join(tempDir, "input.epub")
+			if err := os.WriteFile(input, request.epub, 0644); err != nil {
+				return rpc.Errorf(rpc.CodeInternal, "failed to write the book: %v", err)
+			}
+		}
+		if request.outputPath == "" {
+			request.inline = true
+			request.outputPath = filepath.Join(tempDir, "output.epub")
+		}
+
+		// The events of the book are sent as they are published; a client that went
+		// away only stops receiving them
+		unsubscribe := events.Subscribe(func(event events.Event) {
+			if message, ok := eventMessage(event); ok {
+				send(message)
+			}
+		})
+		startInput(input, "", mode)
+		result := method(input, request)
+		unsubscribe()
+		book := *current
+		current = nil
+		return send(resultMessage(result, book))
+	}
+}
+
+// decodeBookRequest reads the fields of a ProcessRequest or BookRequest, which
+// share their numbers
+func decodeBookRequest(data []byte) (bookRequest, error) {
+	var request bookRequest
+	fields, err := rpc.Fields(data)
+	if err != nil {
+		return request, rpc.Errorf(rpc.CodeInvalidArgument, "%v", err)
+	}
+	for _, field := range fields {
+		switch field.Number {
+		case 1:
+			request.epub = field.Bytes
+		case 2:
+			request.inputPath = string(field.Bytes)
+		case 3:
+			request.outputPath = string(field.Bytes)
+		}
+	}
+	if len(request.epub) == 0 && request.inputPath == "" {
+		return request, rpc.Errorf(rpc.CodeInvalidArgument, "the request has neither epub nor input_path")
+	}
+	return request, nil
+}
+
+// resolvePath resolves a path of a request in the worker's root. The service has
+// no authentication, so a path outside the root, through .. or a symlink, is
+// refused, as are all paths when the worker has no root
+func (g *grpcWorker) resolvePath(name string) (string, error) {
+	if g.root == "" {
+		return "", rpc.Errorf(rpc.CodeInvalidArgument, "the worker takes no paths without -grpc-root; send the book as epub")
+	}
+	if !filepath.IsLocal(filepath.FromSlash(name)) {
+		return "", rpc.Errorf(rpc.CodeInvalidArgument, "path %q is not relative to the worker's root", name)
+	}
+	path := filepath.Join(g.root, filepath.FromSlash(name))
+	resolved, err := filepath.EvalSymlinks(path)
+	if os.IsNotExist(err) {
+		// An output to be created is resolved by its directory
+		if resolved, err = filepath.EvalSymlinks(filepath.Dir(path)); err == nil {
+			resolved = filepath.Join(resolved, filepath.Base(path))
+		}
+	}
+	if err != nil {
+		return "", rpc.Errorf(rpc.CodeInvalidArgument, "failed to resolve path %q: %v", name, err)
+	}
+	if rel, err := filepath.Rel(g.root, resolved); err != nil || !filepath.IsLocal(rel) {
+		return "", rpc.Errorf(rpc.CodeInvalidArgument, "path %q leads outside the worker's root", name)
+	}
+	return resolved, nil
+}
+
+// process restructures the book as the command does; the output is returned
+// inline unless the request named where to write it
+func (g *grpcWorker) process(input string, request bookRequest) grpcResult {
+	code, err := processEPUB(input, request.outputPath, false, false)
+	result := grpcResult{code: code, err: err, outputPath: request.outputPath}
+	if code == exitSuccess && request.inline {
+		if result.epub, err = os.ReadFile(request.outputPath); err != nil {
+			return grpcResult{code: exitIO, err: err}
+		}
+		result.outputPath = ""
+	}
+	return result
+}
+
+// analyze grades the quality of the book
+func (g *grpcWorker) analyze(input string, request bookRequest) grpcResult {
+	book, err := loadBook(input)
+	if err != nil {
+		return grpcResult{code: exitCode(err, exitParse), err: err}
+	}
+	setBook(book)
+	return grpcResult{code: exitSuccess, report: quality.Assess(book)}
+}
+
+// validate checks the structure of the book and validates it with EPUBCheck
+func (g *grpcWorker) validate(input string, request bookRequest) grpcResult {
+	if err := validateEPUB(input); err != nil {
+		return grpcResult{code: exitValidation, err: err}
+	}
+	report, err := epubcheck.Run(input)
+	if err != nil {
+		return grpcResult{code: exitIO, err: fmt.Errorf("failed to run epubcheck: %w", err)}
+	}
+	result := grpcResult{code: exitSuccess, report: report}
+	if report.Errors > 0 || policy.Strict && report.Warnings > 0 {
+		result.code = exitValidation
+		result.err = fmt.Errorf("%s reported %d errors and %d warnings", report.Validator, report.Errors, report.Warnings)
+	} else if err := policy.Check(); err != nil {
+		result.code, result.err = exitValidation, err
+	}
+	return result
+}
+
+// eventMessage encodes a processing event as an Event message
+func eventMessage(event events.Event) (rpc.Message, bool) {
+	var message, sub rpc.Message
+	switch e := event.(type) {
+	case events.ChapterProcessed:
+		sub.Int(1, int64(e.Index))
+		sub.Int(2, int64(e.Total))
+		sub.String(3, e.Title)
+		sub.String(4, e.File)
+		sub.Int(5, int64(e.Size))
+		message.Embed(1, sub)
+	case events.ResourceCopied:
+		sub.String(1, e.Kind)
+		sub.String(2, e.Source)
+		sub.String(3, e.Target)
+		sub.Int(4, int64(e.Size))
+		message.Embed(2, sub)
+	case events.WarningRaised:
+		sub.String(1, e.Kind)
+		sub.String(2, e.Message)
+		message.Embed(3, sub)
+	default:
+		return nil, false
+	}
+	return message, true
+}
+
+// resultMessage encodes the result of a call, with the metadata of its book, as the
+// last Event message
+func resultMessage(result grpcResult, book inputSummary) rpc.Message {
+	var sub rpc.Message
+	sub.Int(1, int64(result.code))
+	sub.String(2, exitStatuses[result.code])
+	if result.err != nil {
+		sub.String(3, result.err.Error())
+	}
+	sub.Bytes(4, result.epub)
+	sub.String(5, result.outputPath)
+	if result.report != nil {
+		if data, err := json.Marshal(result.report); err == nil {
+			sub.Bytes(6, data)
+		}
+	}
+	sub.String(7, book.Title)
+	sub.String(8, book.Author)
+	sub.String(9, book.Identifier)
+
+	var message rpc.Message
+	message.Embed(4, sub)
+	return message
+}
@@ -0,0 +1,128 @@
+package cli
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+
+	"github.com/flouciel/folian-parser/internal/epub"
+)
+
+// volatilePatterns match generated content that legitimately changes between runs
+var volatilePatterns = []*regexp.Regexp{
+	regexp.MustCompile(`<meta property="dcterms:modified">[^<]*</meta>`),
+}
+
+// checkIdempotency processes the input EPUB twice (the second time on the output of
+// the first run) and reports any differences between the two generated EPUBs
+func checkIdempotency(inputPath string) error {
+	fmt.Printf("🔁 Checking round-trip idempotency: %s\n", inputPath)
+
+	tempDir, err := os.MkdirTemp("", "epub-idempotency-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	firstPass := filepath.Join(tempDir, "pass1.epub")
+	secondPass := filepath.Join(tempDir, "pass2.epub")
+
+	if err := epub.NewProcessor().Process(inputPath, firstPass); err != nil {
+		return fmt.Errorf("first pass failed: %w", err)
+	}
+	if err := epub.NewProcessor().Process(firstPass, secondPass); err != nil {
+		return fmt.Errorf("second pass failed: %w", err)
+	}
+
+	differences, err := diffEPUBEntries(firstPass, secondPass)
+	if err != nil {
+		return fmt.Errorf("failed to compare passes: %w", err)
+	}
+
+	if len(differences) > 0 {
+		fmt.Printf("❌ Processing is not idempotent (%d differences):\n", len(differences))
+		for _, difference := range differences {
+			fmt.Printf("   • %s\n", difference)
+		}
+		return fmt.Errorf("second pass produced %d differences", len(differences))
+	}
+
+	fmt.Println("✅ Processing is idempotent: second pass produced no changes")
+	return nil
+}
+
+// diffEPUBEntries compares the entries of two EPUB files and returns a description of
+// every added, removed or changed entry, ignoring volatile generated content
+func diffEPUBEntries(epub1Path, epub2Path string) ([]string, error) {
+	entries1, err := readEPUBEntries(epub1Path)
+	if err != nil {
+		return nil, err
+	}
+	entries2, err := readEPUBEntries(epub2Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var differences []string
+	for name, content1 := range entries1 {
+		content2, ok := entries2[name]
+		if !ok {
+			differences = append(differences, fmt.Sprintf("removed: %s", name))
+			continue
+		}
+		if !bytes.Equal(normalizeVolatile(content1), normalizeVolatile(content2)) {
+			differences = append(differences, fmt.Sprintf("changed: %s", name))
+		}
+	}
+	for name := range entries2 {
+		if _, ok := entries1[name]; !ok {
+			differences = append(differences, fmt.Sprintf("added: %s", name))
+		}
+	}
+
+	sort.Strings(differences)
+	return differences, nil
+}
+
+// readEPUBEntries reads every file of an EPUB into memory keyed by its archive path
+func readEPUBEntries(epubPath string) (map[string][]byte, error) {
+	reader, err := zip.OpenReader(epubPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open EPUB %s: %w", epubPath, err)
+	}
+	defer reader.Close()
+
+	entries := make(map[string][]byte)
+	for _, file := range reader.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+
+		rc, err := file.Open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %s: %w", file.Name, err)
+		}
+		content, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", file.Name, err)
+		}
+
+		entries[file.Name] = content
+	}
+
+	return entries, nil
+}
+
+// normalizeVolatile blanks out content that is expected to differ between runs
+func normalizeVolatile(content []byte) []byte {
+	for _, pattern := range volatilePatterns {
+		content = pattern.ReplaceAll(content, nil)
+	}
+	return content
+}
@@ -0,0 +1,128 @@
+package cli
+
+import (
+	"archive/zip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/flouciel/folian-parser/folian"
+	"github.com/flouciel/folian-parser/internal/epub"
+	"github.com/flouciel/folian-parser/internal/restructure"
+)
+
+// writeTestEPUB writes an EPUB with the given entries after its mimetype
+func writeTestEPUB(t *testing.T, path string, entries map[string]string) {
+	t.Helper()
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	writer := zip.NewWriter(file)
+	mimetype, err := writer.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mimetype.Write([]byte("application/epub+zip"))
+	for name, content := range entries {
+		entry, err := writer.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry.Write([]byte(content))
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// fixtureChapter is a chapter of the test book
+func fixtureChapter(n int) string {
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml"><head><title>Chapter %[1]d</title></head><body><h1>Chapter %[1]d</h1>
+<p>This is the text of chapter %[1]d, long enough for the processing to keep it as a chapter of the book.</p>
+<p>Another paragraph of text so that the chapter is not considered empty.</p></body></html>`, n)
+}
+
+// fixtureEntries are the entries of a two-chapter test book
+var fixtureEntries = map[string]string{
+	"META-INF/container.xml": `<?xml version="1.0"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container"><rootfiles><rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/></rootfiles></container>`,
+	"OEBPS/content.opf": `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="id"><metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>Test Book</dc:title><dc:creator>Anon</dc:creator><dc:language>en</dc:language><dc:identifier id="id">urn:uuid:12345678-1234-5234-8234-123456789abc</dc:identifier></metadata>
+<manifest><item id="c1" href="c1.xhtml" media-type="application/xhtml+xml"/><item id="c2" href="c2.xhtml" media-type="application/xhtml+xml"/></manifest><spine><itemref idref="c1"/><itemref idref="c2"/></spine></package>`,
+	"OEBPS/c1.xhtml": fixtureChapter(1),
+	"OEBPS/c2.xhtml": fixtureChapter(2),
+}
+
+// useTestFormat points the processing at the default format files in a temporary
+// directory, without caches
+func useTestFormat(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := folian.WriteDefaultFormat(dir); err != nil {
+		t.Fatal(err)
+	}
+	formatDir, noCache, chapterCache := restructure.FormatDirPath, epub.NoCache, restructure.ChapterCacheDir
+	restructure.FormatDirPath, epub.NoCache, restructure.ChapterCacheDir = dir, true, ""
+	t.Cleanup(func() {
+		restructure.FormatDirPath, epub.NoCache, restructure.ChapterCacheDir = formatDir, noCache, chapterCache
+	})
+}
+
+func TestProcessingIsIdempotent(t *testing.T) {
+	useTestFormat(t)
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.epub")
+	writeTestEPUB(t, input, fixtureEntries)
+
+	firstPass := filepath.Join(dir, "pass1.epub")
+	secondPass := filepath.Join(dir, "pass2.epub")
+	if err := epub.NewProcessor().Process(input, firstPass); err != nil {
+		t.Fatalf("first pass: %v", err)
+	}
+	if err := epub.NewProcessor().Process(firstPass, secondPass); err != nil {
+		t.Fatalf("second pass: %v", err)
+	}
+
+	differences, err := diffEPUBEntries(firstPass, secondPass)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(differences) > 0 {
+		t.Errorf("second pass produced differences:\n%s", strings.Join(differences, "\n"))
+	}
+	if err := checkIdempotency(input); err != nil {
+		t.Errorf("checkIdempotency: %v", err)
+	}
+}
+
+func TestDiffEPUBEntriesReportsChanges(t *testing.T) {
+	dir := t.TempDir()
+	before := filepath.Join(dir, "before.epub")
+	after := filepath.Join(dir, "after.epub")
+	writeTestEPUB(t, before, map[string]string{
+		"OEBPS/c1.xhtml": fixtureChapter(1),
+		"OEBPS/c2.xhtml": fixtureChapter(2),
+		"OEBPS/old.css":  "p { margin: 0 }",
+		"OEBPS/same.opf": `<meta property="dcterms:modified">2024-01-01T00:00:00Z</meta>`,
+	})
+	writeTestEPUB(t, after, map[string]string{
+		"OEBPS/c1.xhtml": fixtureChapter(1),
+		"OEBPS/c2.xhtml": strings.Replace(fixtureChapter(2), "Another", "One more", 1),
+		"OEBPS/new.css":  "p { margin: 0 }",
+		"OEBPS/same.opf": `<meta property="dcterms:modified">2025-06-30T12:00:00Z</meta>`,
+	})
+
+	differences, err := diffEPUBEntries(before, after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"added: OEBPS/new.css", "changed: OEBPS/c2.xhtml", "removed: OEBPS/old.css"}
+	if strings.Join(differences, "\n") != strings.Join(want, "\n") {
+		t.Errorf("differences = %q, want %q", differences, want)
+	}
+}
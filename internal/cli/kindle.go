@@ -0,0 +1,36 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/flouciel/folian-parser/internal/kindle"
+	"github.com/flouciel/folian-parser/internal/policy"
+)
+
+// runKindlePreviewer converts outputs with Kindle Previewer after processing
+var runKindlePreviewer bool
+
+// previewKindle converts an EPUB with Kindle Previewer, printing and recording its
+// findings in the run summary; they count as one warning, so that -strict fails on
+// them. A missing Kindle Previewer skips the check
+func previewKindle(epubPath string) error {
+	if !kindle.Installed() {
+		fmt.Printf("ℹ️  Kindle Previewer is not installed (or %s is not set), skipping the Kindle conversion check\n", kindle.PreviewerEnv)
+		return nil
+	}
+	report, err := kindle.Preview(epubPath)
+	if err != nil {
+		return fmt.Errorf("failed to run Kindle Previewer: %w", err)
+	}
+	if current != nil {
+		current.KindlePreviewer = report
+	}
+	if err := report.WriteText(os.Stdout); err != nil {
+		return err
+	}
+	if report.Errors+report.Warnings > 0 {
+		policy.Warn(policy.KindValidation, "Kindle Previewer reported %d errors and %d warnings converting %s", report.Errors, report.Warnings, epubPath)
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package cli
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/flouciel/folian-parser/internal/epub"
+	"github.com/flouciel/folian-parser/internal/library"
+	"github.com/flouciel/folian-parser/internal/quality"
+	"github.com/flouciel/folian-parser/internal/restructure"
+)
+
+// libraryPath is the library database processing runs are recorded in, when set
+var libraryPath string
+
+// fileSHA256 returns the hex SHA-256 of a file
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// runOptions returns the command-line options set for the run as a JSON object,
+// leaving out the input and output paths recorded on their own and the given options
+func runOptions(omit ...string) string {
+	options := make(map[string]string)
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name != "i" && f.Name != "o" && !containsString(omit, f.Name) {
+			options[f.Name] = f.Value.String()
+		}
+	})
+	data, err := json.Marshal(options)
+	if err != nil {
+		return "{}"
+	}
+	return string(data)
+}
+
+// reportingOptions are the options that change what a run reports, how fast it
+// goes or where it writes, but not the chapters it writes; they are left out of
+// the options identifying cached chapters
+var reportingOptions = []string{
+	"d", "f", "timings", "pprof", "cpuprofile", "workers", "recompress", "no-cache",
+	"summary-json", "mapping", "epubcheck", "strict", "library", "library-db",
+	"journal", "catalogue", "force", "post-hook", "workdir", "cache-dir", "usage-stats",
+	"usage-stats-file", "grpc-root", "grpc-max-request",
+}
+
+// containsString reports whether list holds s
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// recordLibrary records a processing run in the library database with the source
+// hash, the options and the quality assessment of the output
+func recordLibrary(entry inputSummary) {
+	if libraryPath == "" {
+		return
+	}
+	lib, err := library.Open(libraryPath)
+	if err != nil {
+		fmt.Printf("Warning: %v\n", err)
+		return
+	}
+
+	book := library.Book{
+		ProcessedAt: time.Now().UTC().Format(time.RFC3339),
+		Input:       entry.Input,
+		Output:      entry.Output,
+		Title:       entry.Title,
+		Author:      entry.Author,
+		Identifier:  entry.Identifier,
+		Status:      entry.Status,
+		ExitCode:    entry.ExitCode,
+		Error:       entry.Error,
+		Options:     runOptions(),
+	}
+	// A downloaded input is recorded by its URL and hashed from its download
+	if entry.Source != "" {
+		book.Input = entry.Source
+	}
+	if book.SourceSHA256, err = fileSHA256(entry.Input); err != nil && !os.IsNotExist(err) {
+		fmt.Printf("Warning: Could not hash %s for the library: %v\n", entry.Input, err)
+	}
+
+	// Encrypted output cannot be read back for assessment
+	if entry.ExitCode == exitSuccess && !epub.LCP {
+		if output, err := loadBook(entry.Output); err == nil {
+			report := quality.Assess(output)
+			findings := len(report.Findings)
+			book.Language = output.Metadata.Language
+			book.QualityScore = &report.Score
+			book.QualityGrade = report.Grade
+			book.Findings = &findings
+		} else {
+			fmt.Printf("Warning: Could not assess %s for the library: %v\n", entry.Output, err)
+		}
+	}
+
+	if err := lib.Add(book); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+		return
+	}
+	if restructure.DebugMode {
+		fmt.Printf("📚 Recorded %s in the library %s\n", entry.Input, libraryPath)
+	}
+}
+
+// runLibraryCommand implements the list and search subcommands:
+//
+//	folian-parser list [-db library.db] [-n 20]
+//	folian-parser search [-db library.db] [-n 20] term
+func runLibraryCommand(name string, args []string) error {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	dbPath := flags.String("db", library.DefaultPath(), "Library database")
+	limit := flags.Int("n", 20, "Number of runs to show, newest first (0 shows all)")
+	jsonOutput := flags.Bool("json", false, "Print the runs as JSON")
+	flags.Usage = func() {
+		if name == "search" {
+			fmt.Fprintln(flags.Output(), "Usage: folian-parser search [-db library.db] [-n 20] [-json] term")
+		} else {
+			fmt.Fprintln(flags.Output(), "Usage: folian-parser list [-db library.db] [-n 20] [-json]")
+		}
+		flags.PrintDefaults()
+	}
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	if name == "search" && flags.NArg() != 1 {
+		flags.Usage()
+		return usageErrorf("search requires exactly one term")
+	}
+	if name == "list" && flags.NArg() != 0 {
+		flags.Usage()
+		return usageErrorf("list takes no arguments")
+	}
+	if _, err := os.Stat(*dbPath); os.IsNotExist(err) {
+		return fmt.Errorf("library database %s does not exist (process books with -library first)", *dbPath)
+	}
+
+	lib, err := library.Open(*dbPath)
+	if err != nil {
+		return err
+	}
+	var books []library.Book
+	if name == "search" {
+		books, err = lib.Search(flags.Arg(0), *limit)
+	} else {
+		books, err = lib.List(*limit)
+	}
+	if err != nil {
+		return err
+	}
+
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(books)
+	}
+	if len(books) == 0 {
+		fmt.Println("No books found")
+		return nil
+	}
+	for _, book := range books {
+		printLibraryBook(book)
+	}
+	return nil
+}
+
+// printLibraryBook prints a recorded run on two lines: the book, then its run
+func printLibraryBook(book library.Book) {
+	title := book.Title
+	if title == "" {
+		title = book.Input
+	}
+	if book.Author != "" {
+		title += " — " + book.Author
+	}
+	grade := "-"
+	if book.QualityGrade != "" {
+		grade = book.QualityGrade
+	}
+	fmt.Printf("%4d  %s  [%s] %s\n", book.ID, strings.Replace(book.ProcessedAt, "T", " ", 1), grade, title)
+
+	detail := fmt.Sprintf("%s → %s (%s", book.Input, book.Output, book.Status)
+	if book.Error != "" {
+		detail += ": " + book.Error
+	}
+	fmt.Printf("      %s)\n", detail)
+}
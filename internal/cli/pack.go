@@ -0,0 +1,41 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+
+	"github.com/flouciel/folian-parser/internal/epub"
+)
+
+// runPackCommand implements the pack subcommand, zipping an extracted EPUB directory
+// back into an EPUB without processing it:
+//
+//	folian-parser pack [-o book.epub] dir
+func runPackCommand(args []string) error {
+	flags := flag.NewFlagSet("pack", flag.ContinueOnError)
+	outputPath := flags.String("o", "", "Output EPUB file (default: the directory name with .epub)")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: folian-parser pack [-o book.epub] dir")
+		flags.PrintDefaults()
+	}
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return usageErrorf("pack requires exactly one directory")
+	}
+
+	dir := filepath.Clean(flags.Arg(0))
+	if *outputPath == "" {
+		*outputPath = dir + ".epub"
+	}
+	if err := epub.Pack(dir, *outputPath); err != nil {
+		return err
+	}
+
+	fmt.Printf("📦 Packed %s into %s\n", dir, *outputPath)
+	return nil
+}
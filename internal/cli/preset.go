@@ -0,0 +1,104 @@
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"time"
+
+	"github.com/flouciel/folian-parser/internal/version"
+)
+
+// presetFormat is the version of the preset file format written by exportPreset;
+// presets of a later format are refused
+const presetFormat = 1
+
+// Preset is the complete configuration of a run, saved with -export-preset and
+// replayed with -preset
+type Preset struct {
+	Format int `json:"preset"`
+	// Version is the folian-parser version that wrote the preset
+	Version string    `json:"folian_version"`
+	Created time.Time `json:"created"`
+	// Options holds the value of every option by name, defaults included, so that
+	// a replay is not changed by later defaults
+	Options map[string]string `json:"options"`
+}
+
+// presetOmitted are the options that name the files of one run, act instead of
+// processing, or only concern this machine; they are neither saved nor replayed
+var presetOmitted = []string{
+	"i", "o", "v", "u", "a", "quality", "validate", "compare", "preset", "export-preset",
+	"summary-json", "stats", "quality-out", "catalogue", "journal", "pprof", "cpuprofile",
+	"library-db", "input-sha256", "serve-grpc", "workdir", "cache-dir", "usage-stats",
+	"usage-stats-file", "grpc-root", "grpc-max-request",
+}
+
+// applyPreset sets the options of a preset file that were not given on the
+// command line, which override the preset
+func applyPreset(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read preset: %w", err)
+	}
+	var preset Preset
+	if err := json.Unmarshal(data, &preset); err != nil {
+		return fmt.Errorf("failed to parse preset %s: %w", path, err)
+	}
+	if preset.Format < 1 || preset.Format > presetFormat {
+		return fmt.Errorf("preset %s has format %d, this version reads format %d (update folian-parser)", path, preset.Format, presetFormat)
+	}
+	if preset.Version != "" && preset.Version != version.Version {
+		fmt.Printf("ℹ️  Preset %s was written by folian-parser %s (running %s)\n", path, preset.Version, version.Version)
+	}
+
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	names := make([]string, 0, len(preset.Options))
+	for name := range preset.Options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if given[name] || containsString(presetOmitted, name) {
+			continue
+		}
+		if flag.Lookup(name) == nil {
+			return fmt.Errorf("preset %s sets -%s, which this version does not have", path, name)
+		}
+		if err := flag.Set(name, preset.Options[name]); err != nil {
+			return fmt.Errorf("preset %s: invalid value %q for -%s: %w", path, preset.Options[name], name, err)
+		}
+	}
+	fmt.Printf("ℹ️  Applied preset %s\n", path)
+	return nil
+}
+
+// exportPreset writes the value of every option of the run, after any preset was
+// applied, as a preset file
+func exportPreset(path string) error {
+	preset := Preset{
+		Format:  presetFormat,
+		Version: version.Version,
+		Created: time.Now().UTC().Truncate(time.Second),
+		Options: make(map[string]string),
+	}
+	flag.VisitAll(func(f *flag.Flag) {
+		if !containsString(presetOmitted, f.Name) {
+			preset.Options[f.Name] = f.Value.String()
+		}
+	})
+	data, err := json.MarshalIndent(preset, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode preset: %w", err)
+	}
+	if err := ioutil.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write preset: %w", err)
+	}
+	fmt.Printf("💾 Preset written to %s\n", path)
+	return nil
+}
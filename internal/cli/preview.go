@@ -0,0 +1,299 @@
+package cli
+
+import (
+	"context"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/flouciel/folian-parser/folian"
+	"github.com/flouciel/folian-parser/internal/epub"
+	"github.com/flouciel/folian-parser/internal/restructure"
+)
+
+// previewPollInterval is how often the preview checks the format directory and the
+// book for changes
+const previewPollInterval = 500 * time.Millisecond
+
+// previewEventsPath is the server-sent events stream the previewed pages listen to
+const previewEventsPath = "/__preview/events"
+
+// previewScript reloads a previewed page when the book is rebuilt
+const previewScript = `<script type="text/javascript">//<![CDATA[
+new EventSource("` + previewEventsPath + `").onmessage = function () { location.reload(); };
+//]]></script>`
+
+// previewServer serves the latest build of a book and tells the open pages when it
+// is rebuilt
+type previewServer struct {
+	input string
+	// watched are the paths whose changes trigger a rebuild
+	watched []string
+
+	mu sync.RWMutex
+	// tempDir holds the current build, and dir its restructured content
+	tempDir string
+	dir     string
+	clients map[chan struct{}]bool
+}
+
+// runPreviewCommand implements the preview subcommand, serving the restructured book
+// over HTTP and rebuilding it, reloading the open pages, whenever a file of the
+// format directory or the book changes:
+//
+//	folian-parser preview [-f format] [-addr localhost:8080] [-profile name] [-enhanced] book.epub
+func runPreviewCommand(args []string) error {
+	flags := flag.NewFlagSet("preview", flag.ContinueOnError)
+	formatDir := flags.String("f", "format", "Path to the format directory containing templates and assets")
+	addr := flags.String("addr", "localhost:8080", "Address to serve the preview on")
+	profile := flags.String("profile", "", "Device profile: "+strings.Join(restructure.ProfileNames(), ", "))
+	enhanced := flags.Bool("enhanced", false, "Use enhanced processing with intelligent chapter consolidation")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: folian-parser preview [-f format] [-addr localhost:8080] [-profile name] [-enhanced] book.epub")
+		flags.PrintDefaults()
+	}
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return usageErrorf("preview requires exactly one EPUB file or extracted EPUB directory")
+	}
+
+	if err := folian.WriteDefaultFormat(*formatDir); err != nil {
+		return err
+	}
+	restructure.FormatDirPath = *formatDir
+	restructure.EnhancedMode = *enhanced
+	if err := restructure.SetProfile(*profile); err != nil {
+		return err
+	}
+
+	server := &previewServer{
+		input:   flags.Arg(0),
+		watched: []string{*formatDir, flags.Arg(0)},
+		clients: make(map[chan struct{}]bool),
+	}
+	if err := server.build(); err != nil {
+		return err
+	}
+	defer server.cleanup()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", server.serveIndex)
+	mux.HandleFunc("/book/", server.serveBook)
+	mux.HandleFunc(previewEventsPath, server.serveEvents)
+	httpServer := &http.Server{Addr: *addr, Handler: mux}
+
+	// Stop serving on Ctrl-C, removing the build
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		httpServer.Shutdown(context.Background())
+	}()
+
+	go server.watch()
+
+	fmt.Printf("👀 Previewing %s on http://%s/ (watching %s; Ctrl-C to stop)\n", server.input, *addr, *formatDir)
+	if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return fmt.Errorf("failed to serve preview: %w", err)
+	}
+	return nil
+}
+
+// build restructures the book into a new directory and makes it the served one
+func (s *previewServer) build() error {
+	started := time.Now()
+	tempDir, err := os.MkdirTemp("", "folian-preview-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	dir, err := epub.NewProcessor().Build(s.input, tempDir)
+	if err != nil {
+		os.RemoveAll(tempDir)
+		return err
+	}
+
+	s.mu.Lock()
+	previous := s.tempDir
+	s.tempDir, s.dir = tempDir, dir
+	s.mu.Unlock()
+	if previous != "" {
+		os.RemoveAll(previous)
+	}
+
+	fmt.Printf("🔄 Built %s in %s\n", s.input, time.Since(started).Round(time.Millisecond))
+	return nil
+}
+
+// cleanup removes the current build
+func (s *previewServer) cleanup() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tempDir != "" {
+		os.RemoveAll(s.tempDir)
+	}
+}
+
+// watch rebuilds the book and reloads the open pages when a watched file changes;
+// a failed build is reported and the previous one kept
+func (s *previewServer) watch() {
+	last := watchSignature(s.watched)
+	for range time.Tick(previewPollInterval) {
+		current := watchSignature(s.watched)
+		if current == last {
+			continue
+		}
+		last = current
+		if err := s.build(); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			continue
+		}
+		s.reload()
+	}
+}
+
+// watchSignature returns the names, sizes and modification times of the files
+// under the given paths, to notice changes
+func watchSignature(paths []string) string {
+	var b strings.Builder
+	for _, root := range paths {
+		filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() {
+				fmt.Fprintf(&b, "%s %d %d\n", path, info.Size(), info.ModTime().UnixNano())
+			}
+			return nil
+		})
+	}
+	return b.String()
+}
+
+// reload tells the open pages that the book was rebuilt
+func (s *previewServer) reload() {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for client := range s.clients {
+		select {
+		case client <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// serveEvents streams a message to a page each time the book is rebuilt
+func (s *previewServer) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	client := make(chan struct{}, 1)
+	s.mu.Lock()
+	s.clients[client] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, client)
+		s.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	flusher.Flush()
+	for {
+		select {
+		case <-client:
+			fmt.Fprint(w, "data: reload\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// previewPackage is the part of the restructured OPF listed on the index page
+type previewPackage struct {
+	Title    string `xml:"metadata>title"`
+	Manifest []struct {
+		ID   string `xml:"id,attr"`
+		Href string `xml:"href,attr"`
+	} `xml:"manifest>item"`
+	Spine []struct {
+		IDRef string `xml:"idref,attr"`
+	} `xml:"spine>itemref"`
+}
+
+// serveIndex lists the documents of the book in reading order
+func (s *previewServer) serveIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	s.mu.RLock()
+	data, err := ioutil.ReadFile(filepath.Join(s.dir, "OEBPS", "content.opf"))
+	s.mu.RUnlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var pkg previewPackage
+	if err := xml.Unmarshal(data, &pkg); err != nil {
+		http.Error(w, fmt.Sprintf("failed to parse content.opf: %v", err), http.StatusInternalServerError)
+		return
+	}
+	hrefs := make(map[string]string)
+	for _, item := range pkg.Manifest {
+		hrefs[item.ID] = item.Href
+	}
+
+	var items strings.Builder
+	for _, itemref := range pkg.Spine {
+		if href, ok := hrefs[itemref.IDRef]; ok {
+			fmt.Fprintf(&items, "<li><a href=\"/book/OEBPS/%s\">%s</a></li>\n", html.EscapeString(href), html.EscapeString(href))
+		}
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, "<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\"/><title>%s</title></head><body>\n<h1>%s</h1>\n<ol>\n%s</ol>\n%s\n</body></html>\n",
+		html.EscapeString(pkg.Title), html.EscapeString(pkg.Title), items.String(), previewScript)
+}
+
+// serveBook serves the files of the current build, adding the reload script to the
+// documents
+func (s *previewServer) serveBook(w http.ResponseWriter, r *http.Request) {
+	name := path.Clean("/" + strings.TrimPrefix(r.URL.Path, "/book/"))
+	s.mu.RLock()
+	filePath := filepath.Join(s.dir, filepath.FromSlash(name))
+	s.mu.RUnlock()
+
+	ext := strings.ToLower(path.Ext(name))
+	if ext != ".xhtml" && ext != ".html" {
+		w.Header().Set("Cache-Control", "no-store")
+		http.ServeFile(w, r, filePath)
+		return
+	}
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	content := string(data)
+	if i := strings.LastIndex(content, "</body>"); i >= 0 {
+		content = content[:i] + previewScript + "\n" + content[i:]
+	}
+	w.Header().Set("Content-Type", "application/xhtml+xml; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	fmt.Fprint(w, content)
+}
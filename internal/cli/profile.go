@@ -0,0 +1,72 @@
+package cli
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	_ "net/http/pprof"
+	"os"
+	"runtime/pprof"
+	"time"
+
+	"github.com/flouciel/folian-parser/internal/timing"
+)
+
+// cpuProfile is the file the CPU profile of the run is written to by stopProfiling
+var cpuProfile *os.File
+
+// startProfiling serves the pprof endpoints on addr and writes a CPU profile of the
+// run to cpuProfilePath, each when set; when either fails, neither is left running
+func startProfiling(addr, cpuProfilePath string) error {
+	if cpuProfilePath != "" {
+		file, err := os.Create(cpuProfilePath)
+		if err != nil {
+			return fmt.Errorf("failed to create CPU profile: %w", err)
+		}
+		if err := pprof.StartCPUProfile(file); err != nil {
+			file.Close()
+			return fmt.Errorf("failed to start CPU profile: %w", err)
+		}
+		cpuProfile = file
+	}
+
+	if addr != "" {
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			if cpuProfile != nil {
+				pprof.StopCPUProfile()
+				cpuProfile.Close()
+				os.Remove(cpuProfile.Name())
+				cpuProfile = nil
+			}
+			return fmt.Errorf("failed to serve pprof: %w", err)
+		}
+		server := &http.Server{}
+		go func() {
+			if err := server.Serve(listener); err != nil {
+				fmt.Printf("Warning: pprof endpoint stopped: %v\n", err)
+			}
+		}()
+		fmt.Printf("ℹ️  Serving pprof on http://%s/debug/pprof/\n", listener.Addr())
+	}
+	return nil
+}
+
+// stopProfiling finishes the CPU profile of the run
+func stopProfiling() {
+	if cpuProfile == nil {
+		return
+	}
+	pprof.StopCPUProfile()
+	cpuProfile.Close()
+	fmt.Printf("📈 CPU profile written to %s\n", cpuProfile.Name())
+	cpuProfile = nil
+}
+
+// printTimings prints the stages measured for the current input when -timings is set
+func printTimings(total time.Duration) {
+	if !timing.Enabled {
+		return
+	}
+	timing.WriteText(os.Stdout, total)
+}
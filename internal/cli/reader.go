@@ -0,0 +1,247 @@
+package cli
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io/fs"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/flouciel/folian-parser/internal/command"
+)
+
+// readerContainer is the part of container.xml naming the package document
+type readerContainer struct {
+	Rootfiles []struct {
+		FullPath string `xml:"full-path,attr"`
+	} `xml:"rootfiles>rootfile"`
+}
+
+// readerPackage is the part of the package document the reader shows
+type readerPackage struct {
+	Title    string `xml:"metadata>title"`
+	Manifest []struct {
+		ID         string `xml:"id,attr"`
+		Href       string `xml:"href,attr"`
+		Properties string `xml:"properties,attr"`
+	} `xml:"manifest>item"`
+	Spine []struct {
+		IDRef string `xml:"idref,attr"`
+	} `xml:"spine>itemref"`
+}
+
+// readerPage lays out the table of contents and reading order beside the document
+const readerPage = `<!DOCTYPE html>
+<html><head><meta charset="utf-8"/><title>%s</title>
+<style>
+body { margin: 0; display: flex; height: 100vh; font-family: sans-serif; }
+aside { width: 20em; overflow: auto; padding: 0 1em; border-right: 1px solid #ccc; font-size: 0.9em; }
+aside ol { padding-left: 1.2em; }
+aside a.current { font-weight: bold; }
+iframe { flex: 1; border: 0; height: 100%%; }
+</style></head>
+<body>
+<aside>
+<h2>%s</h2>
+<h3>Contents</h3>
+%s
+<h3>Reading order</h3>
+<ol>
+%s</ol>
+</aside>
+<iframe name="content" src="%s"></iframe>
+<script>
+document.querySelector("iframe").addEventListener("load", function () {
+  var current = this.contentWindow.location.pathname;
+  document.querySelectorAll("aside a").forEach(function (a) {
+    a.classList.toggle("current", a.pathname === current);
+  });
+});
+</script>
+</body></html>
+`
+
+// showReader serves an EPUB with a reader page, its table of contents and reading
+// order beside the documents, opens it in the browser and serves until Ctrl-C
+func showReader(epubPath string) error {
+	archive, err := zip.OpenReader(epubPath)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", epubPath, err)
+	}
+	defer archive.Close()
+
+	page, err := readerIndex(archive)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, page)
+	})
+	mux.HandleFunc("/book/", func(w http.ResponseWriter, r *http.Request) {
+		serveArchiveFile(w, r, archive, strings.TrimPrefix(r.URL.Path, "/book/"))
+	})
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return fmt.Errorf("failed to start reader: %w", err)
+	}
+	server := &http.Server{Handler: mux}
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	go func() {
+		<-interrupt
+		server.Shutdown(context.Background())
+	}()
+
+	url := "http://" + listener.Addr().String() + "/"
+	fmt.Printf("📖 Showing %s on %s (Ctrl-C to stop)\n", epubPath, url)
+	if err := command.OpenBrowser(url); err != nil {
+		fmt.Printf("ℹ️  Could not open a browser (%v); open %s yourself\n", err, url)
+	}
+	if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+		return fmt.Errorf("failed to serve reader: %w", err)
+	}
+	return nil
+}
+
+// readerIndex builds the reader page of an EPUB from its package document and
+// navigation document
+func readerIndex(archive *zip.ReadCloser) (string, error) {
+	var container readerContainer
+	if err := readArchiveXML(archive, "META-INF/container.xml", &container); err != nil {
+		return "", err
+	}
+	if len(container.Rootfiles) == 0 {
+		return "", fmt.Errorf("container.xml names no package document")
+	}
+	opfPath := container.Rootfiles[0].FullPath
+	var pkg readerPackage
+	if err := readArchiveXML(archive, opfPath, &pkg); err != nil {
+		return "", err
+	}
+
+	// Links point at the archive files, relative to the package document
+	opfDir := path.Dir(opfPath)
+	bookURL := func(dir, href string) string {
+		return "/book/" + path.Join(dir, href)
+	}
+
+	hrefs := make(map[string]string)
+	navHref := ""
+	for _, item := range pkg.Manifest {
+		hrefs[item.ID] = item.Href
+		if strings.Contains(" "+item.Properties+" ", " nav ") {
+			navHref = item.Href
+		}
+	}
+
+	var spine strings.Builder
+	first := ""
+	for _, itemref := range pkg.Spine {
+		href, ok := hrefs[itemref.IDRef]
+		if !ok {
+			continue
+		}
+		if first == "" {
+			first = bookURL(opfDir, href)
+		}
+		fmt.Fprintf(&spine, "<li><a href=\"%s\" target=\"content\">%s</a></li>\n", html.EscapeString(bookURL(opfDir, href)), html.EscapeString(href))
+	}
+
+	contents := "<p>No navigation document.</p>"
+	if navHref != "" {
+		toc, err := readerContents(archive, path.Join(opfDir, navHref), func(href string) string {
+			return bookURL(path.Dir(path.Join(opfDir, navHref)), href)
+		})
+		if err != nil {
+			return "", err
+		}
+		contents = toc
+	}
+
+	title := html.EscapeString(pkg.Title)
+	return fmt.Sprintf(readerPage, title, title, contents, spine.String(), html.EscapeString(first)), nil
+}
+
+// readerContents returns the table of contents of a navigation document with its
+// links opening in the reader frame
+func readerContents(archive *zip.ReadCloser, navPath string, link func(string) string) (string, error) {
+	file, err := archive.Open(navPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s: %w", navPath, err)
+	}
+	defer file.Close()
+	doc, err := goquery.NewDocumentFromReader(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse %s: %w", navPath, err)
+	}
+
+	toc := doc.Find(`nav[epub\:type~="toc"]`).First()
+	if toc.Length() == 0 {
+		toc = doc.Find("nav").First()
+	}
+	toc.Find("a[href]").Each(func(i int, a *goquery.Selection) {
+		href, _ := a.Attr("href")
+		a.SetAttr("href", link(href))
+		a.SetAttr("target", "content")
+	})
+	list, err := goquery.OuterHtml(toc.Find("ol").First())
+	if err != nil || toc.Find("ol").Length() == 0 {
+		return "<p>Empty table of contents.</p>", nil
+	}
+	return list, nil
+}
+
+// readArchiveXML decodes an XML file of an archive
+func readArchiveXML(archive *zip.ReadCloser, name string, v interface{}) error {
+	file, err := archive.Open(name)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", name, err)
+	}
+	defer file.Close()
+	if err := xml.NewDecoder(file).Decode(v); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", name, err)
+	}
+	return nil
+}
+
+// serveArchiveFile serves a file of an archive with the media type of its extension
+func serveArchiveFile(w http.ResponseWriter, r *http.Request, archive *zip.ReadCloser, name string) {
+	data, err := fs.ReadFile(archive, path.Clean(name))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	ext := strings.ToLower(path.Ext(name))
+	mediaType := mime.TypeByExtension(ext)
+	switch ext {
+	case ".xhtml":
+		mediaType = "application/xhtml+xml; charset=utf-8"
+	case ".ncx":
+		mediaType = "application/x-dtbncx+xml"
+	case ".opf":
+		mediaType = "application/oebps-package+xml"
+	}
+	if mediaType == "" {
+		mediaType = http.DetectContentType(data)
+	}
+	w.Header().Set("Content-Type", mediaType)
+	w.Write(data)
+}
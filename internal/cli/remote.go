@@ -0,0 +1,42 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/flouciel/folian-parser/internal/remote"
+)
+
+// inputSource is the URL the input was downloaded from, recorded in the summary
+var inputSource string
+
+// downloadDirs are the temporary directories of downloaded and converted inputs,
+// and of the fallback format directory, removed on exit
+var downloadDirs []string
+
+// fetchInput downloads a remote input into a temporary directory and returns the
+// local path it is processed from
+func fetchInput(rawURL string, maxSizeMB int64, expectedSHA256 string) (string, error) {
+	dir, err := os.MkdirTemp("", "folian-input-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create download directory: %w", err)
+	}
+	downloadDirs = append(downloadDirs, dir)
+
+	fmt.Printf("⬇️  Downloading %s\n", rawURL)
+	download, err := remote.Fetch(rawURL, dir, maxSizeMB<<20, expectedSHA256)
+	if err != nil {
+		return "", err
+	}
+	fmt.Printf("ℹ️  Downloaded %d bytes, SHA-256 %s\n", download.Size, download.SHA256)
+	inputSource = rawURL
+	return download.Path, nil
+}
+
+// removeDownloads removes the temporary directories of downloaded and converted inputs
+func removeDownloads() {
+	for _, dir := range downloadDirs {
+		os.RemoveAll(dir)
+	}
+	downloadDirs = nil
+}
@@ -0,0 +1,84 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/flouciel/folian-parser/internal/epub"
+)
+
+// subcommands are run instead of processing when named by the first argument
+var subcommands = map[string]func(args []string) error{
+	"diff":    runDiffCommand,
+	"extract": runExtractCommand,
+	"list": func(args []string) error {
+		return runLibraryCommand("list", args)
+	},
+	"search": func(args []string) error {
+		return runLibraryCommand("search", args)
+	},
+	"unpack":      runUnpackCommand,
+	"pack":        runPackCommand,
+	"preview":     runPreviewCommand,
+	"clip":        runClipCommand,
+	"stats":       runStatsCommand,
+	"cache-clear": runCacheClearCommand,
+}
+
+// usageError is a misuse of a subcommand, such as an unknown option or a missing
+// argument, which exits with exitUsage
+type usageError struct {
+	err error
+	// reported is set when the flag package has printed the error already
+	reported bool
+}
+
+func (e usageError) Error() string { return e.err.Error() }
+
+func (e usageError) Unwrap() error { return e.err }
+
+// usageErrorf returns a usageError
+func usageErrorf(format string, args ...interface{}) error {
+	return usageError{err: fmt.Errorf(format, args...)}
+}
+
+// parseFlags parses the options of a subcommand; the flag package reports their
+// errors, and -h is not one
+func parseFlags(flags *flag.FlagSet, args []string) error {
+	if err := flags.Parse(args); err != nil {
+		return usageError{err: err, reported: true}
+	}
+	return nil
+}
+
+// subcommandExitCode prints the error a subcommand returned and returns its exit
+// code, as for processing: usage errors exit with exitUsage, and other errors by
+// their kind or with exitIO
+func subcommandExitCode(err error) int {
+	var usage usageError
+	switch {
+	case err == nil || errors.Is(err, flag.ErrHelp):
+		return exitSuccess
+	case errors.As(err, &usage):
+		if !usage.reported {
+			fmt.Printf("Error: %v\n", err)
+		}
+		return exitUsage
+	}
+	fmt.Printf("Error: %v\n", err)
+	return exitCode(err, exitIO)
+}
+
+// runCacheClearCommand implements the cache-clear subcommand, emptying the parse
+// and chapter caches
+func runCacheClearCommand(args []string) error {
+	if len(args) != 0 {
+		return usageErrorf("cache-clear takes no arguments")
+	}
+	if err := epub.ClearCache(); err != nil {
+		return err
+	}
+	fmt.Println("🗑️  Cache cleared")
+	return nil
+}
@@ -0,0 +1,172 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/flouciel/folian-parser/internal/epub"
+	"github.com/flouciel/folian-parser/internal/epubcheck"
+	"github.com/flouciel/folian-parser/internal/parser"
+	"github.com/flouciel/folian-parser/internal/policy"
+	"github.com/flouciel/folian-parser/internal/timing"
+	"github.com/flouciel/folian-parser/internal/version"
+)
+
+// Exit codes; their values are part of the command-line contract so that scripts and
+// CI can tell failures apart
+const (
+	exitSuccess    = 0
+	exitUsage      = 1
+	exitValidation = 2
+	exitDRM        = 3
+	exitParse      = 4
+	exitIO         = 5
+)
+
+// exitStatuses names the exit codes in the run summary
+var exitStatuses = map[int]string{
+	exitSuccess:    "success",
+	exitUsage:      "usage",
+	exitValidation: "validation-failure",
+	exitDRM:        "drm",
+	exitParse:      "parse-error",
+	exitIO:         "io-error",
+}
+
+// exitCode returns the exit code for a processing error, or fallback when the error
+// has no known kind
+func exitCode(err error, fallback int) int {
+	switch {
+	case err == nil:
+		return exitSuccess
+	case errors.Is(err, epub.ErrDRM):
+		return exitDRM
+	case errors.Is(err, epub.ErrParse):
+		return exitParse
+	case errors.Is(err, epub.ErrValidation):
+		return exitValidation
+	case errors.Is(err, epub.ErrIO):
+		return exitIO
+	}
+	return fallback
+}
+
+// runSummary is the machine-readable summary written by -summary-json
+type runSummary struct {
+	Version  string         `json:"version"`
+	Started  time.Time      `json:"started"`
+	ExitCode int            `json:"exit_code"`
+	Inputs   []inputSummary `json:"inputs"`
+}
+
+// inputSummary is the outcome of processing one input
+type inputSummary struct {
+	Input string `json:"input"`
+	// Source is the URL a remote input was downloaded from
+	Source     string `json:"source,omitempty"`
+	Output     string `json:"output,omitempty"`
+	Mode       string `json:"mode"`
+	Status     string `json:"status"`
+	ExitCode   int    `json:"exit_code"`
+	Error      string `json:"error,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+	Title      string `json:"title,omitempty"`
+	Author     string `json:"author,omitempty"`
+	Identifier string `json:"identifier,omitempty"`
+	// Conversion records how a DOCX or -via-calibre input was converted to EPUB
+	Conversion *conversion `json:"conversion,omitempty"`
+	// EPUBCheck holds the findings of -epubcheck
+	EPUBCheck *epubcheck.Report `json:"epubcheck,omitempty"`
+	// KindlePreviewer holds the findings of -kindle-preview
+	KindlePreviewer *epubcheck.Report `json:"kindle_previewer,omitempty"`
+	// Warnings are the warnings recorded for the input
+	Warnings []policy.Warning `json:"warnings,omitempty"`
+	// Timings are the stages measured by -timings
+	Timings []timing.Stage `json:"timings,omitempty"`
+	started time.Time
+	// chapters counts the chapters written, for -usage-stats
+	chapters int
+}
+
+// summaryPath is where -summary-json writes the run summary
+var summaryPath string
+
+// summary collects the outcome of the run for -summary-json
+var summary = runSummary{Version: version.Version, Started: time.Now()}
+
+// current is the input being handled, recorded in the summary on exit
+var current *inputSummary
+
+// startInput begins the summary entry of an input
+func startInput(input, output, mode string) {
+	current = &inputSummary{Input: input, Source: inputSource, Conversion: inputConversion, Output: output, Mode: mode, started: time.Now()}
+	policy.Reset()
+	timing.Reset()
+	if source := bundlePath(input); source != input {
+		current.Source = source
+	}
+}
+
+// setBook records the metadata of the current input
+func setBook(book *parser.Book) {
+	if current == nil || book == nil {
+		return
+	}
+	current.Title = book.Metadata.Title
+	current.Author = book.Metadata.Creator
+	current.Identifier = book.Metadata.Identifier
+}
+
+// finishInput records the outcome of the current input in the summary
+func finishInput(code int, err error) {
+	if current == nil {
+		return
+	}
+	current.Status = exitStatuses[code]
+	current.ExitCode = code
+	elapsed := time.Since(current.started)
+	current.DurationMS = elapsed.Milliseconds()
+	current.Warnings = policy.Warnings()
+	current.Timings = timing.Stages()
+	printTimings(elapsed)
+	if err != nil {
+		current.Error = err.Error()
+	}
+	summary.Inputs = append(summary.Inputs, *current)
+	if current.Mode == "process" {
+		recordLibrary(*current)
+		recordUsage(*current)
+	}
+	current = nil
+}
+
+// exit records the outcome of the current input, writes the run summary when
+// requested and exits with the given code
+func exit(code int, err error) {
+	finishInput(code, err)
+	summary.ExitCode = code
+
+	if summaryPath != "" {
+		if err := writeSummary(summaryPath); err != nil {
+			fmt.Printf("Warning: %v\n", err)
+		}
+	}
+	removeDownloads()
+	stopProfiling()
+	os.Exit(code)
+}
+
+// writeSummary writes the run summary as JSON
+func writeSummary(path string) error {
+	data, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal run summary: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write run summary: %w", err)
+	}
+	return nil
+}
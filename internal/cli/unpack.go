@@ -0,0 +1,46 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/flouciel/folian-parser/internal/epub"
+)
+
+// runUnpackCommand implements the unpack subcommand, extracting an EPUB into a
+// working directory to edit and repack with pack:
+//
+//	folian-parser unpack [-o dir] [-pretty] book.epub
+func runUnpackCommand(args []string) error {
+	flags := flag.NewFlagSet("unpack", flag.ContinueOnError)
+	outputDir := flags.String("o", "", "Output directory (default: the book's name without extension)")
+	pretty := flags.Bool("pretty", false, "Indent the XHTML, OPF, NCX and XML files for reading and editing")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: folian-parser unpack [-o dir] [-pretty] book.epub")
+		flags.PrintDefaults()
+	}
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return usageErrorf("unpack requires exactly one EPUB file")
+	}
+
+	inputPath := flags.Arg(0)
+	if *outputDir == "" {
+		*outputDir = strings.TrimSuffix(inputPath, filepath.Ext(inputPath))
+	}
+
+	// Keep the entry names of the book, which pack writes back as they are
+	epub.KeepFilenames = true
+	if err := epub.Unpack(inputPath, *outputDir, *pretty); err != nil {
+		return err
+	}
+
+	fmt.Printf("📂 Unpacked %s into %s\n", inputPath, *outputDir)
+	return nil
+}
@@ -0,0 +1,130 @@
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/flouciel/folian-parser/internal/events"
+	"github.com/flouciel/folian-parser/internal/restructure"
+	"github.com/flouciel/folian-parser/internal/usage"
+)
+
+// usagePath is the usage statistics ledger processed books are counted in, set by
+// -usage-stats
+var usagePath string
+
+// countChapters counts the chapters written for the current input, for the usage
+// statistics
+func countChapters(event events.Event) {
+	if _, ok := event.(events.ChapterProcessed); ok && current != nil {
+		current.chapters++
+	}
+}
+
+// recordUsage counts a processing run in the usage statistics ledger
+func recordUsage(entry inputSummary) {
+	if usagePath == "" {
+		return
+	}
+	run := usage.Run{
+		Status:   entry.Status,
+		Duration: time.Duration(entry.DurationMS) * time.Millisecond,
+		Warnings: entry.Warnings,
+	}
+	if info, err := os.Stat(entry.Input); err == nil && !info.IsDir() {
+		run.InputBytes = info.Size()
+	}
+	// The output and chapters of a failed run are not averaged, as it has none
+	if entry.ExitCode == exitSuccess {
+		run.Chapters = entry.chapters
+		if info, err := os.Stat(entry.Output); err == nil && !info.IsDir() {
+			run.OutputBytes = info.Size()
+		}
+	}
+	if err := usage.Record(usagePath, run); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+		return
+	}
+	if restructure.DebugMode {
+		fmt.Printf("📈 Counted %s in the usage statistics %s\n", entry.Input, usagePath)
+	}
+}
+
+// runStatsCommand implements the stats subcommand, printing the usage statistics:
+//
+//	folian-parser stats [-file usage.json] [-n 10] [-json] [-reset]
+func runStatsCommand(args []string) error {
+	flags := flag.NewFlagSet("stats", flag.ContinueOnError)
+	filePath := flags.String("file", usage.DefaultPath(), "Usage statistics file written by -usage-stats")
+	limit := flags.Int("n", 10, "Number of most common warning kinds to show (0 shows all)")
+	jsonOutput := flags.Bool("json", false, "Print the statistics as JSON")
+	reset := flags.Bool("reset", false, "Delete the usage statistics")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: folian-parser stats [-file usage.json] [-n 10] [-json] [-reset]")
+		flags.PrintDefaults()
+	}
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return usageErrorf("stats takes no arguments")
+	}
+
+	if *reset {
+		if err := os.Remove(*filePath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to delete usage statistics: %w", err)
+		}
+		fmt.Println("🗑️  Usage statistics deleted")
+		return nil
+	}
+	if _, err := os.Stat(*filePath); os.IsNotExist(err) {
+		return fmt.Errorf("usage statistics %s do not exist (process books with -usage-stats first)", *filePath)
+	}
+	ledger, err := usage.Load(*filePath)
+	if err != nil {
+		return err
+	}
+
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(ledger)
+	}
+	printUsage(ledger, *limit)
+	return nil
+}
+
+// printUsage prints the totals and averages of the usage statistics and their
+// most common warnings
+func printUsage(ledger *usage.Ledger, limit int) {
+	fmt.Printf("📈 Usage statistics since %s\n", ledger.Since.Local().Format("2006-01-02"))
+	fmt.Printf("   Books processed: %d", ledger.Books)
+	for _, status := range usage.Top(ledger.Statuses, 0) {
+		fmt.Printf(", %d %s", status.Count, status.Name)
+	}
+	fmt.Println()
+	if ledger.Books == 0 {
+		return
+	}
+	books := int64(ledger.Books)
+	succeeded := int64(ledger.Statuses[exitStatuses[exitSuccess]])
+	fmt.Printf("   Average input: %.2f MB\n", float64(ledger.InputBytes)/float64(books)/(1024*1024))
+	if succeeded > 0 {
+		fmt.Printf("   Average output: %.2f MB\n", float64(ledger.OutputBytes)/float64(succeeded)/(1024*1024))
+		fmt.Printf("   Average chapters: %.1f\n", float64(ledger.Chapters)/float64(succeeded))
+	}
+	fmt.Printf("   Average time: %.1fs\n", float64(ledger.DurationMS)/float64(books)/1000)
+
+	if len(ledger.Warnings) == 0 {
+		fmt.Println("   No warnings")
+		return
+	}
+	fmt.Println("   Warnings by kind:")
+	for _, kind := range usage.Top(ledger.Warnings, limit) {
+		fmt.Printf("     %5d  %s\n", kind.Count, kind.Name)
+	}
+}
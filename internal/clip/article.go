@@ -0,0 +1,454 @@
+package clip
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+
+	"github.com/flouciel/folian-parser/internal/htmlnode"
+)
+
+// article is the readable content of a web page, with its metadata
+type article struct {
+	Title    string
+	Author   string
+	Date     string
+	Site     string
+	Language string
+	// Content is the article as XHTML blocks
+	Content string
+	// Words is the number of words of the article's text
+	Words int
+}
+
+// droppedElements are the elements that never hold article text
+var droppedElements = map[string]bool{
+	"script": true, "style": true, "noscript": true, "nav": true, "header": true, "footer": true,
+	"aside": true, "form": true, "iframe": true, "button": true, "svg": true, "template": true,
+	"object": true, "embed": true, "input": true, "select": true, "textarea": true, "canvas": true,
+	"video": true, "audio": true, "dialog": true, "menu": true,
+}
+
+// unlikelyPattern matches the classes and IDs of page furniture around an article:
+// comments, sharing buttons, bylines (credited in the attribution), related links,
+// newsletters, ads and the like
+var unlikelyPattern = regexp.MustCompile(`(?i)comment|share|social|byline|related|newsletter|subscribe|promo|advert|\bads?\b|sponsor|sidebar|cookie|banner|popup|modal|breadcrumb|masthead|pagination|\bmenu\b|\bnav\b|footer|disqus|outbrain|taboola`)
+
+// likelyPattern matches the classes and IDs of article content, which are kept even
+// when they also look like page furniture
+var likelyPattern = regexp.MustCompile(`(?i)article|\bbody\b|content|entry|\bmain\b|\bpost\b|story|\btext\b`)
+
+// keptElements are the elements written to the book, by their name in it; other
+// elements are replaced by their content. Headings within an article become bold
+// paragraphs, since its chapter keeps a single heading
+var keptElements = map[string]string{
+	"p": "p", "h1": "p", "h2": "p", "h3": "p", "h4": "p", "h5": "p", "h6": "p",
+	"blockquote": "blockquote", "ul": "ul", "ol": "ol", "li": "li", "dl": "dl", "dt": "dt", "dd": "dd",
+	"pre": "pre", "figure": "figure", "figcaption": "figcaption", "table": "table", "caption": "caption",
+	"thead": "thead", "tbody": "tbody", "tfoot": "tfoot", "tr": "tr", "th": "th", "td": "td",
+	"hr": "hr", "br": "br", "img": "img", "a": "a", "em": "em", "i": "i", "strong": "strong", "b": "b",
+	"u": "u", "s": "s", "del": "del", "ins": "ins", "sub": "sub", "sup": "sup", "code": "code",
+	"kbd": "kbd", "samp": "samp", "var": "var", "q": "q", "cite": "cite", "abbr": "abbr",
+	"small": "small", "mark": "mark",
+}
+
+// blockElements are the block elements of an article; divisions that hold one are
+// unwrapped, the others become paragraphs
+var blockElements = map[string]bool{
+	"p": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"blockquote": true, "ul": true, "ol": true, "dl": true, "pre": true, "figure": true,
+	"table": true, "hr": true, "div": true, "section": true, "article": true, "main": true,
+}
+
+// keptAttributes are the attributes kept on the elements of an article; the page's
+// classes, IDs and styles mean nothing in the book
+var keptAttributes = map[string]bool{
+	"href": true, "src": true, "alt": true, "title": true, "colspan": true, "rowspan": true,
+	"start": true, "reversed": true, "lang": true,
+}
+
+// voidElements are the elements written as empty XML elements
+var voidElements = map[string]bool{"br": true, "hr": true, "img": true}
+
+// extractor writes the content of a page as XHTML, with its links made absolute and
+// its images copied into the book by image
+type extractor struct {
+	base  *url.URL
+	image func(src string) string
+}
+
+// extractArticle reads the metadata of a page and its readable content: its article
+// element, its main element or else the element holding most of its paragraphs
+func extractArticle(data []byte, pageURL string, image func(string) string) (*article, error) {
+	doc, err := html.Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL %s: %w", pageURL, err)
+	}
+	result := pageMetadata(doc, base)
+
+	// A base element moves the links of the page
+	htmlnode.Walk(doc, func(n *html.Node) bool {
+		if n.Type == html.ElementNode && n.Data == "base" {
+			if href, err := base.Parse(htmlnode.Attribute(n, "href")); err == nil && htmlnode.Attribute(n, "href") != "" {
+				base = href
+			}
+			return false
+		}
+		return true
+	})
+
+	removeFurniture(doc)
+	content := mainContent(doc)
+	if content == nil {
+		return nil, fmt.Errorf("no article content found")
+	}
+
+	// The article's own heading repeats its title, which heads its chapter
+	if heading := firstHeading(content); heading != nil {
+		if result.Title == "" {
+			result.Title = collapse(htmlnode.Text(heading))
+		}
+		if strings.EqualFold(collapse(htmlnode.Text(heading)), result.Title) {
+			heading.Parent.RemoveChild(heading)
+		}
+	}
+
+	e := &extractor{base: base, image: image}
+	result.Content = e.blocks(content)
+	result.Words = len(strings.Fields(htmlnode.Text(content)))
+	if result.Words == 0 {
+		return nil, fmt.Errorf("no article text found")
+	}
+	return result, nil
+}
+
+// extractFragment writes the HTML a feed carries for an article as XHTML
+func extractFragment(fragment, pageURL string, image func(string) string) (string, int) {
+	nodes, err := html.ParseFragment(strings.NewReader(fragment), &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body})
+	if err != nil {
+		return "", 0
+	}
+	body := &html.Node{Type: html.ElementNode, Data: "body"}
+	for _, n := range nodes {
+		body.AppendChild(n)
+	}
+	removeFurniture(body)
+	base, _ := url.Parse(pageURL)
+	if base == nil {
+		base = &url.URL{}
+	}
+	e := &extractor{base: base, image: image}
+	return e.blocks(body), len(strings.Fields(htmlnode.Text(body)))
+}
+
+// pageMetadata reads the title, author, date, site and language of a page from its
+// meta elements
+func pageMetadata(doc *html.Node, base *url.URL) *article {
+	result := &article{Site: strings.TrimPrefix(base.Hostname(), "www.")}
+	var title, ogTitle string
+	htmlnode.Walk(doc, func(n *html.Node) bool {
+		if n.Type != html.ElementNode {
+			return true
+		}
+		switch n.Data {
+		case "html":
+			result.Language = htmlnode.Attribute(n, "lang")
+		case "title":
+			title = collapse(htmlnode.Text(n))
+		case "meta":
+			content := collapse(htmlnode.Attribute(n, "content"))
+			switch strings.ToLower(firstOf(htmlnode.Attribute(n, "property"), htmlnode.Attribute(n, "name"))) {
+			case "og:title":
+				ogTitle = content
+			case "og:site_name":
+				result.Site = content
+			case "author", "article:author", "dc.creator":
+				if result.Author == "" && !strings.Contains(content, "://") {
+					result.Author = content
+				}
+			case "article:published_time", "date", "dc.date", "dcterms.date", "pubdate":
+				if result.Date == "" {
+					result.Date = normalizeDate(content)
+				}
+			}
+		case "time":
+			if result.Date == "" {
+				result.Date = normalizeDate(htmlnode.Attribute(n, "datetime"))
+			}
+		case "body":
+			// The byline of the page marks its author when no meta element does
+			if result.Author == "" {
+				htmlnode.Walk(n, func(child *html.Node) bool {
+					if child.Type == html.ElementNode && (htmlnode.Attribute(child, "rel") == "author" || htmlnode.HasClass(child, "byline") || htmlnode.HasClass(child, "author")) {
+						byline := collapse(htmlnode.Text(child))
+						if byline != "" && len(byline) <= 80 {
+							result.Author = strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(byline, "By "), "by "))
+							return false
+						}
+					}
+					return result.Author == ""
+				})
+			}
+		}
+		return true
+	})
+	result.Title = firstOf(ogTitle, title)
+	return result
+}
+
+// removeFurniture removes the elements around an article that are not part of it
+func removeFurniture(root *html.Node) {
+	htmlnode.Walk(root, func(n *html.Node) bool {
+		switch n.Type {
+		case html.CommentNode:
+			n.Parent.RemoveChild(n)
+			return false
+		case html.ElementNode:
+		default:
+			return true
+		}
+		if droppedElements[n.Data] || htmlnode.Attribute(n, "hidden") != "" || htmlnode.Attribute(n, "aria-hidden") == "true" {
+			n.Parent.RemoveChild(n)
+			return false
+		}
+		if n.Data == "html" || n.Data == "body" || n.Data == "article" || n.Data == "main" {
+			return true
+		}
+		names := htmlnode.Attribute(n, "class") + " " + htmlnode.Attribute(n, "id") + " " + htmlnode.Attribute(n, "role")
+		if unlikelyPattern.MatchString(names) && !likelyPattern.MatchString(names) {
+			n.Parent.RemoveChild(n)
+			return false
+		}
+		return true
+	})
+}
+
+// mainContent returns the element holding the article of a page: the article
+// element with the most text, the main element, or else the element whose
+// paragraphs hold the most text
+func mainContent(doc *html.Node) *html.Node {
+	var best *html.Node
+	bestLength := 0
+	for _, match := range []func(*html.Node) bool{
+		func(n *html.Node) bool { return n.Data == "article" },
+		func(n *html.Node) bool { return n.Data == "main" || htmlnode.Attribute(n, "role") == "main" },
+	} {
+		htmlnode.Walk(doc, func(n *html.Node) bool {
+			if n.Type == html.ElementNode && match(n) {
+				if length := len(collapse(htmlnode.Text(n))); length > bestLength {
+					best, bestLength = n, length
+				}
+			}
+			return true
+		})
+		if best != nil {
+			return best
+		}
+	}
+
+	// Paragraphs score their parent, and half as much their grandparent
+	scores := make(map[*html.Node]int)
+	htmlnode.Walk(doc, func(n *html.Node) bool {
+		if n.Type != html.ElementNode || (n.Data != "p" && n.Data != "pre" && n.Data != "blockquote") {
+			return true
+		}
+		length := len(collapse(htmlnode.Text(n)))
+		if length < 25 {
+			return false
+		}
+		if parent := n.Parent; parent != nil {
+			scores[parent] += length
+			if grandparent := parent.Parent; grandparent != nil {
+				scores[grandparent] += length / 2
+			}
+		}
+		return false
+	})
+	for n, score := range scores {
+		if score > bestLength || (score == bestLength && best != nil && n.Parent == best) {
+			best, bestLength = n, score
+		}
+	}
+	if best == nil {
+		htmlnode.Walk(doc, func(n *html.Node) bool {
+			if n.Type == html.ElementNode && n.Data == "body" {
+				best = n
+				return false
+			}
+			return true
+		})
+	}
+	return best
+}
+
+// firstHeading returns the heading that opens an element, before any of its text
+func firstHeading(root *html.Node) *html.Node {
+	var heading *html.Node
+	done := false
+	htmlnode.Walk(root, func(n *html.Node) bool {
+		if done {
+			return false
+		}
+		if htmlnode.IsHeading(n) {
+			heading, done = n, true
+			return false
+		}
+		if n.Type == html.TextNode && strings.TrimSpace(n.Data) != "" {
+			done = true
+		}
+		return true
+	})
+	return heading
+}
+
+// blocks writes the children of an element as XHTML blocks, unwrapping divisions
+// that hold blocks and wrapping loose inline content in paragraphs
+func (e *extractor) blocks(parent *html.Node) string {
+	var out, inline strings.Builder
+	flush := func() {
+		if content := strings.TrimSpace(inline.String()); content != "" {
+			out.WriteString("<p>" + content + "</p>\n")
+		}
+		inline.Reset()
+	}
+	for n := parent.FirstChild; n != nil; n = n.NextSibling {
+		if n.Type == html.ElementNode && blockElements[n.Data] {
+			flush()
+			if _, kept := keptElements[n.Data]; kept {
+				if block := e.render(n); block != "" {
+					out.WriteString(block + "\n")
+				}
+			} else {
+				out.WriteString(e.blocks(n))
+			}
+			continue
+		}
+		e.write(&inline, n)
+	}
+	flush()
+	return out.String()
+}
+
+// render writes a block as XHTML, or "" when it holds nothing
+func (e *extractor) render(n *html.Node) string {
+	var out strings.Builder
+	e.write(&out, n)
+	block := out.String()
+	if !voidElements[n.Data] && !strings.Contains(block, "<img") && strings.TrimSpace(htmlnode.Text(n)) == "" {
+		return ""
+	}
+	return block
+}
+
+// write writes a node as XHTML with the elements and attributes the book keeps
+func (e *extractor) write(out *strings.Builder, n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		out.WriteString(html.EscapeString(n.Data))
+		return
+	case html.ElementNode:
+	default:
+		return
+	}
+
+	name, kept := keptElements[n.Data]
+	if n.Data == "div" || n.Data == "section" {
+		// Divisions nested in a kept block are unwrapped into it
+		name, kept = "", false
+	}
+	var attrs []html.Attribute
+	switch name {
+	case "img":
+		src := e.image(e.resolve(imageSource(n)))
+		if src == "" {
+			return
+		}
+		attrs = append(attrs, html.Attribute{Key: "src", Val: src}, html.Attribute{Key: "alt", Val: htmlnode.Attribute(n, "alt")})
+	case "a":
+		href := htmlnode.Attribute(n, "href")
+		if href == "" || strings.HasPrefix(href, "#") || strings.HasPrefix(strings.ToLower(href), "javascript:") {
+			// Links within the page do not survive it
+			kept = false
+			break
+		}
+		attrs = append(attrs, html.Attribute{Key: "href", Val: e.resolve(href)})
+	default:
+		for _, attr := range n.Attr {
+			if keptAttributes[attr.Key] && attr.Namespace == "" && attr.Key != "href" && attr.Key != "src" {
+				attrs = append(attrs, attr)
+			}
+		}
+	}
+	if !kept {
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			e.write(out, child)
+		}
+		return
+	}
+
+	out.WriteString("<" + name)
+	for _, attr := range attrs {
+		out.WriteString(fmt.Sprintf(` %s="%s"`, attr.Key, html.EscapeString(attr.Val)))
+	}
+	if voidElements[name] {
+		out.WriteString("/>")
+		return
+	}
+	if htmlnode.IsHeading(n) {
+		out.WriteString(` class="nonindent"><strong>`)
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			e.write(out, child)
+		}
+		out.WriteString("</strong></p>")
+		return
+	}
+	out.WriteString(">")
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		e.write(out, child)
+	}
+	out.WriteString("</" + name + ">")
+}
+
+// imageSource returns the source of an image, preferring the lazily loaded one
+// pages set aside in data attributes
+func imageSource(n *html.Node) string {
+	for _, key := range []string{"data-src", "data-original", "data-lazy-src"} {
+		if src := htmlnode.Attribute(n, key); src != "" {
+			return src
+		}
+	}
+	if src := htmlnode.Attribute(n, "src"); src != "" && !strings.HasPrefix(src, "data:") {
+		return src
+	}
+	if srcset := strings.Fields(htmlnode.Attribute(n, "srcset")); len(srcset) > 0 {
+		return strings.TrimSuffix(srcset[0], ",")
+	}
+	return htmlnode.Attribute(n, "src")
+}
+
+// resolve makes a link of the page absolute
+func (e *extractor) resolve(ref string) string {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return ""
+	}
+	resolved, err := e.base.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return resolved.String()
+}
+
+// collapse collapses the runs of white space of a text
+func collapse(value string) string {
+	return strings.Join(strings.Fields(value), " ")
+}
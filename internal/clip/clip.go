@@ -0,0 +1,330 @@
+// Package clip compiles web articles, listed by URL or in RSS and Atom feeds, into a
+// book with a chapter per article, which is then restructured like any other book
+package clip
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/flouciel/folian-parser/internal/book"
+	"github.com/flouciel/folian-parser/internal/network"
+	"github.com/flouciel/folian-parser/internal/policy"
+	"github.com/flouciel/folian-parser/internal/version"
+)
+
+// MaxPageSize is the largest page, feed or image downloaded
+const MaxPageSize = 20 << 20
+
+// client is the HTTP client used to fetch pages, feeds and images
+var client = network.NewClient(60 * time.Second)
+
+// Options are the settings of a compilation
+type Options struct {
+	// Title and Author override the title and author of the book
+	Title  string
+	Author string
+	// Language is the language of the book, by default that of the first article
+	Language string
+	// Limit is the most articles read from each feed, 0 for all of them
+	Limit int
+	// Images copies the images of the articles into the book
+	Images bool
+}
+
+// Document is the outcome of compiling articles
+type Document struct {
+	Title    string
+	Articles int
+	Images   int
+	// Skipped is the number of articles that could not be read
+	Skipped int
+}
+
+// source is an article to compile, with what its feed says of it
+type source struct {
+	item feedItem
+	site string
+	// page is the article's page, when it was given by URL
+	page []byte
+}
+
+// compiler gathers the chapters and images of a book
+type compiler struct {
+	options Options
+	images  []book.Image
+	// copied maps the image URLs already fetched to their names in the book
+	copied map[string]string
+}
+
+// Compile fetches the articles of the given URLs, expanding feeds into their items,
+// and writes them as an extracted EPUB in dir, which is created
+func Compile(urls []string, dir string, options Options) (*Document, error) {
+	c := &compiler{options: options, copied: make(map[string]string)}
+
+	// Feeds name the book when it compiles a single one
+	var sources []source
+	var feedTitles []string
+	feedLanguage := ""
+	for _, rawURL := range urls {
+		data, _, err := fetch(rawURL, "")
+		if err != nil {
+			policy.Warn(policy.KindOther, "Skipping %s: %v", rawURL, err)
+			continue
+		}
+		if !isFeed(data) {
+			sources = append(sources, source{item: feedItem{URL: rawURL}, page: data})
+			continue
+		}
+		parsed, err := parseFeed(data)
+		if err != nil {
+			policy.Warn(policy.KindOther, "Skipping the feed %s: %v", rawURL, err)
+			continue
+		}
+		feedTitles = append(feedTitles, parsed.Title)
+		if feedLanguage == "" {
+			feedLanguage = parsed.Language
+		}
+		items := parsed.Items
+		if options.Limit > 0 && len(items) > options.Limit {
+			items = items[:options.Limit]
+		}
+		for _, item := range items {
+			if item.URL != "" {
+				if resolved, err := url.Parse(rawURL); err == nil {
+					if link, err := resolved.Parse(item.URL); err == nil {
+						item.URL = link.String()
+					}
+				}
+			}
+			sources = append(sources, source{item: item, site: parsed.Title})
+		}
+	}
+
+	var chapters []book.Chapter
+	var articles []*article
+	document := &Document{}
+	for _, src := range sources {
+		a, err := c.read(src)
+		if err != nil {
+			policy.Warn(policy.KindOther, "Skipping %s: %v", firstOf(src.item.URL, src.item.Title), err)
+			document.Skipped++
+			continue
+		}
+		articles = append(articles, a)
+		chapters = append(chapters, book.Chapter{Title: a.Title, Body: chapterBody(a, src.item.URL)})
+	}
+	if len(chapters) == 0 {
+		return nil, fmt.Errorf("no article could be read")
+	}
+
+	metadata := book.Metadata{
+		Title:    options.Title,
+		Creator:  options.Author,
+		Language: firstOf(options.Language, feedLanguage, articles[0].Language, "en"),
+		Date:     time.Now().Format("2006-01-02"),
+	}
+	if metadata.Title == "" {
+		if len(feedTitles) == 1 && feedTitles[0] != "" {
+			metadata.Title = feedTitles[0]
+		} else if len(articles) == 1 {
+			metadata.Title = articles[0].Title
+		} else {
+			metadata.Title = "Clippings"
+		}
+	}
+	// An author wrote the book when all of its articles are theirs
+	if metadata.Creator == "" {
+		metadata.Creator = articles[0].Author
+		for _, a := range articles {
+			if a.Author != metadata.Creator {
+				metadata.Creator = ""
+				break
+			}
+		}
+	}
+	metadata.Description = description(articles)
+	for _, a := range articles {
+		metadata.Identifier += a.Site + "\n" + a.Title + "\n"
+	}
+	metadata.Identifier = book.IdentifierOf([]byte(metadata.Identifier + metadata.Date))
+
+	if err := book.Write(dir, metadata, chapters, c.images); err != nil {
+		return nil, err
+	}
+	document.Title = metadata.Title
+	document.Articles = len(chapters)
+	document.Images = len(c.images)
+	return document, nil
+}
+
+// read reads an article from its page, or from the content its feed carries when the
+// page cannot be fetched or holds less of it
+func (c *compiler) read(src source) (*article, error) {
+	item := src.item
+	var a *article
+	var pageErr error
+	if src.page == nil && item.URL != "" {
+		src.page, _, pageErr = fetch(item.URL, "")
+	}
+	// The content is chosen without images, which are only copied for the one kept
+	noImages := func(string) string { return "" }
+	if src.page != nil {
+		a, pageErr = extractArticle(src.page, item.URL, noImages)
+	}
+	if _, words := extractFragment(item.Content, item.URL, noImages); words > 0 && (a == nil || words > a.Words) {
+		if a == nil {
+			a = &article{Site: src.site}
+			if u, err := url.Parse(item.URL); err == nil && u.Hostname() != "" {
+				a.Site = strings.TrimPrefix(u.Hostname(), "www.")
+			}
+		}
+		a.Content, a.Words = extractFragment(item.Content, item.URL, c.image)
+	} else if a != nil {
+		page, _ := extractArticle(src.page, item.URL, c.image)
+		a.Content = page.Content
+	}
+	if a == nil {
+		if pageErr == nil {
+			pageErr = fmt.Errorf("no link or content")
+		}
+		return nil, pageErr
+	}
+
+	// What the feed says of an article is more reliable than what its page does
+	a.Title = firstOf(item.Title, a.Title, a.Site, item.URL)
+	a.Author = firstOf(item.Author, a.Author)
+	a.Date = firstOf(item.Date, a.Date)
+	if src.site != "" && a.Site == "" {
+		a.Site = src.site
+	}
+	return a, nil
+}
+
+// chapterBody writes an article as a chapter: its title, its content and where it
+// was published
+func chapterBody(a *article, pageURL string) string {
+	var body strings.Builder
+	body.WriteString(fmt.Sprintf("<h1>%s</h1>\n", html.EscapeString(a.Title)))
+	body.WriteString(a.Content)
+
+	var credits []string
+	if pageURL != "" {
+		credits = append(credits, fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(pageURL), html.EscapeString(firstOf(a.Site, pageURL))))
+	} else if a.Site != "" {
+		credits = append(credits, html.EscapeString(a.Site))
+	}
+	if a.Author != "" {
+		credits = append(credits, "by "+html.EscapeString(a.Author))
+	}
+	if a.Date != "" {
+		credits = append(credits, html.EscapeString(a.Date))
+	}
+	if len(credits) > 0 {
+		body.WriteString(fmt.Sprintf("<p class=\"source\">Source: %s</p>\n", strings.Join(credits, ", ")))
+	}
+	return body.String()
+}
+
+// description lists the sites the articles come from
+func description(articles []*article) string {
+	var sites []string
+	seen := make(map[string]bool)
+	for _, a := range articles {
+		if a.Site != "" && !seen[a.Site] {
+			seen[a.Site] = true
+			sites = append(sites, a.Site)
+		}
+	}
+	if len(sites) == 0 {
+		return ""
+	}
+	noun := "articles"
+	if len(articles) == 1 {
+		noun = "article"
+	}
+	return fmt.Sprintf("%d %s from %s", len(articles), noun, strings.Join(sites, ", "))
+}
+
+// image copies an image of an article into the book once, returning its path from a
+// chapter, or "" when it is not copied
+func (c *compiler) image(src string) string {
+	if !c.options.Images || src == "" {
+		return ""
+	}
+	if name, ok := c.copied[src]; ok {
+		if name == "" {
+			return ""
+		}
+		return "../images/" + name
+	}
+	c.copied[src] = ""
+	if !strings.HasPrefix(src, "http://") && !strings.HasPrefix(src, "https://") {
+		return ""
+	}
+
+	data, contentType, err := fetch(src, "image/")
+	if err != nil {
+		policy.Warn(policy.KindOther, "Skipping the image %s: %v", src, err)
+		return ""
+	}
+	ext := ""
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		for candidate, known := range book.ImageTypes {
+			if known == mediaType && (ext == "" || candidate < ext) {
+				ext = candidate
+			}
+		}
+	}
+	if ext == "" {
+		if u, err := url.Parse(src); err == nil {
+			ext = strings.ToLower(path.Ext(u.Path))
+		}
+	}
+	mediaType, ok := book.ImageTypes[ext]
+	if !ok {
+		policy.Warn(policy.KindOther, "Skipping the image %s, which EPUB readers cannot show", src)
+		return ""
+	}
+	name := fmt.Sprintf("image_%03d%s", len(c.images)+1, ext)
+	c.images = append(c.images, book.Image{Name: name, MediaType: mediaType, Data: data})
+	c.copied[src] = name
+	return "../images/" + name
+}
+
+// fetch downloads a page, feed or image, refusing responses over MaxPageSize and, when
+// kind is set, of another media type
+func fetch(rawURL, kind string) ([]byte, string, error) {
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid URL: %w", err)
+	}
+	req.Header.Set("User-Agent", "folian-parser/"+version.Version)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to fetch: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("failed to fetch: status code %d", resp.StatusCode)
+	}
+	contentType := resp.Header.Get("Content-Type")
+	if kind != "" && contentType != "" && !strings.HasPrefix(contentType, kind) {
+		return nil, "", fmt.Errorf("unexpected content type %s", contentType)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, MaxPageSize+1))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to fetch: %w", err)
+	}
+	if len(data) > MaxPageSize {
+		return nil, "", fmt.Errorf("over the %d byte limit", MaxPageSize)
+	}
+	return data, contentType, nil
+}
@@ -0,0 +1,187 @@
+package clip
+
+import (
+	"bytes"
+	"encoding/xml"
+	"html"
+	"strings"
+	"time"
+)
+
+// feedDocument is an RSS 2.0, RSS 1.0 (RDF) or Atom feed
+type feedDocument struct {
+	XMLName xml.Name
+	Channel struct {
+		Title    string    `xml:"title"`
+		Language string    `xml:"language"`
+		Items    []rssItem `xml:"item"`
+	} `xml:"channel"`
+	// Items are the items of an RSS 1.0 feed, which follow its channel
+	Items []rssItem `xml:"item"`
+	// Title and Entries are those of an Atom feed
+	Title   string      `xml:"title"`
+	Lang    string      `xml:"http://www.w3.org/XML/1998/namespace lang,attr"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+// rssItem is an item of an RSS feed
+type rssItem struct {
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	Author      string `xml:"author"`
+	Creator     string `xml:"http://purl.org/dc/elements/1.1/ creator"`
+	PubDate     string `xml:"pubDate"`
+	Date        string `xml:"http://purl.org/dc/elements/1.1/ date"`
+	Description string `xml:"description"`
+	Content     string `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+}
+
+// atomEntry is an entry of an Atom feed
+type atomEntry struct {
+	Title string `xml:"title"`
+	Links []struct {
+		Rel  string `xml:"rel,attr"`
+		Href string `xml:"href,attr"`
+	} `xml:"link"`
+	Author struct {
+		Name string `xml:"name"`
+	} `xml:"author"`
+	Published string   `xml:"published"`
+	Updated   string   `xml:"updated"`
+	Summary   atomText `xml:"summary"`
+	Content   atomText `xml:"content"`
+}
+
+// atomText is an Atom text construct: text, escaped HTML or inline XHTML
+type atomText struct {
+	Type  string `xml:"type,attr"`
+	Text  string `xml:",chardata"`
+	Inner string `xml:",innerxml"`
+}
+
+// HTML returns the content of an Atom text construct as HTML
+func (t atomText) HTML() string {
+	switch t.Type {
+	case "xhtml":
+		return t.Inner
+	case "html":
+		return t.Text
+	}
+	return html.EscapeString(t.Text)
+}
+
+// feedItem is an article listed in a feed
+type feedItem struct {
+	Title  string
+	URL    string
+	Author string
+	Date   string
+	// Content is the HTML the feed carries for the article, its full text or a
+	// summary
+	Content string
+}
+
+// feed is a parsed feed
+type feed struct {
+	Title    string
+	Language string
+	Items    []feedItem
+}
+
+// isFeed reports whether a document is an RSS or Atom feed, by its root element
+func isFeed(data []byte) bool {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.Strict = false
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return false
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			switch start.Name.Local {
+			case "rss", "RDF", "feed":
+				return true
+			}
+			return false
+		}
+	}
+}
+
+// parseFeed reads the articles of an RSS or Atom feed
+func parseFeed(data []byte) (*feed, error) {
+	var doc feedDocument
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.Strict = false
+	decoder.Entity = xml.HTMLEntity
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	if doc.XMLName.Local == "feed" {
+		result := &feed{Title: strings.TrimSpace(doc.Title), Language: doc.Lang}
+		for _, entry := range doc.Entries {
+			item := feedItem{
+				Title:  strings.TrimSpace(entry.Title),
+				Author: strings.TrimSpace(entry.Author.Name),
+				Date:   normalizeDate(firstOf(entry.Published, entry.Updated)),
+			}
+			for _, link := range entry.Links {
+				if link.Rel == "" || link.Rel == "alternate" {
+					item.URL = strings.TrimSpace(link.Href)
+					break
+				}
+			}
+			if item.Content = entry.Content.HTML(); strings.TrimSpace(item.Content) == "" {
+				item.Content = entry.Summary.HTML()
+			}
+			result.Items = append(result.Items, item)
+		}
+		return result, nil
+	}
+
+	result := &feed{Title: strings.TrimSpace(doc.Channel.Title), Language: strings.TrimSpace(doc.Channel.Language)}
+	for _, entry := range append(doc.Channel.Items, doc.Items...) {
+		result.Items = append(result.Items, feedItem{
+			Title:   strings.TrimSpace(entry.Title),
+			URL:     strings.TrimSpace(entry.Link),
+			Author:  strings.TrimSpace(firstOf(entry.Creator, entry.Author)),
+			Date:    normalizeDate(firstOf(entry.PubDate, entry.Date)),
+			Content: firstOf(entry.Content, entry.Description),
+		})
+	}
+	return result, nil
+}
+
+// dateLayouts are the date formats of feeds and article pages
+var dateLayouts = []string{
+	time.RFC3339, time.RFC1123Z, time.RFC1123, time.RFC822Z, time.RFC822,
+	"Mon, 2 Jan 2006 15:04:05 -0700", "Mon, 2 Jan 2006 15:04:05 MST", "2 Jan 2006 15:04:05 -0700",
+	"2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02",
+}
+
+// normalizeDate returns a feed or page date as YYYY-MM-DD, or "" when it cannot be
+// read
+func normalizeDate(value string) string {
+	value = strings.TrimSpace(value)
+	for _, layout := range dateLayouts {
+		if date, err := time.Parse(layout, value); err == nil {
+			return date.Format("2006-01-02")
+		}
+	}
+	if len(value) >= 10 {
+		if date, err := time.Parse("2006-01-02", value[:10]); err == nil {
+			return date.Format("2006-01-02")
+		}
+	}
+	return ""
+}
+
+// firstOf returns the first of its values that is not blank
+func firstOf(values ...string) string {
+	for _, value := range values {
+		if strings.TrimSpace(value) != "" {
+			return value
+		}
+	}
+	return ""
+}
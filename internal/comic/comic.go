@@ -0,0 +1,294 @@
+// Package comic reads CBZ and CBR comic archives, whose pages are images in name
+// order, and their ComicInfo.xml metadata
+package comic
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/flouciel/folian-parser/internal/book"
+	"github.com/flouciel/folian-parser/internal/parser"
+)
+
+// ExtractEnv names the environment variable holding the path of unrar, when neither
+// unrar nor bsdtar is in the PATH
+const ExtractEnv = "UNRAR"
+
+// Formats are the comic archive formats, by extension
+var Formats = map[string]string{
+	".cbz": "CBZ",
+	".cbr": "CBR",
+}
+
+// Document is the outcome of reading a comic archive
+type Document struct {
+	Title  string
+	Series string
+	Issue  string
+	Format string
+	Pages  int
+	// ComicInfo reports whether the archive holds ComicInfo.xml metadata
+	ComicInfo bool
+}
+
+// Format returns the format of a comic archive, or "" when the path is not one
+func Format(path string) string {
+	return Formats[strings.ToLower(filepath.Ext(path))]
+}
+
+// archiveFile is a file of a comic archive
+type archiveFile struct {
+	name string
+	data []byte
+}
+
+// Convert reads the pages and ComicInfo.xml of a comic archive and writes them as an
+// extracted EPUB in dir, which is created
+func Convert(inputPath, dir string) (*Document, error) {
+	format := Format(inputPath)
+	if format == "" {
+		return nil, fmt.Errorf("%s is not a comic archive", filepath.Ext(inputPath))
+	}
+	files, err := readArchive(inputPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var pages []archiveFile
+	info := &parser.ComicInfo{}
+	var comicInfo []byte
+	for _, file := range files {
+		base := path.Base(file.name)
+		switch {
+		case strings.HasPrefix(base, ".") || strings.HasPrefix(file.name, "__MACOSX/"):
+		case strings.EqualFold(base, "ComicInfo.xml"):
+			comicInfo = file.data
+		case book.ImageTypes[strings.ToLower(path.Ext(base))] != "":
+			pages = append(pages, file)
+		}
+	}
+	if len(pages) == 0 {
+		return nil, fmt.Errorf("%s holds no page images", filepath.Base(inputPath))
+	}
+	sort.Slice(pages, func(i, j int) bool { return naturalLess(pages[i].name, pages[j].name) })
+	if comicInfo != nil {
+		if info, err = parser.ParseComicInfo(comicInfo); err != nil {
+			return nil, err
+		}
+	}
+
+	metadata := book.Metadata{
+		Title:       info.DisplayTitle(),
+		Language:    strings.TrimSpace(info.LanguageISO),
+		Description: strings.TrimSpace(info.Summary),
+		Date:        info.Date(),
+		Subjects:    info.Subjects(),
+	}
+	if metadata.Title == "" {
+		base := filepath.Base(inputPath)
+		metadata.Title = strings.TrimSuffix(base, filepath.Ext(base))
+	}
+	if metadata.Language == "" {
+		metadata.Language = "en"
+	}
+	for _, credit := range info.Credits() {
+		if credit.Role == "aut" || credit.Role == "art" {
+			metadata.Creator = credit.Name
+			break
+		}
+	}
+	if metadata.Identifier, err = book.Identifier(inputPath); err != nil {
+		return nil, err
+	}
+
+	chapters := book.Split(pageBlocks(pages, info), nil, metadata.Title)
+	var images []book.Image
+	for i, page := range pages {
+		ext := strings.ToLower(path.Ext(page.name))
+		images = append(images, book.Image{Name: pageImage(i, ext), MediaType: book.ImageTypes[ext], Data: page.data})
+	}
+	if cover := coverPage(info); cover < len(images) {
+		metadata.Cover = images[cover].Name
+	}
+	if err := book.Write(dir, metadata, chapters, images); err != nil {
+		return nil, err
+	}
+
+	// The parser reads the series and credits the package document lacks from the
+	// ComicInfo.xml kept with the book
+	if comicInfo != nil {
+		if err := os.WriteFile(filepath.Join(dir, "META-INF", "ComicInfo.xml"), comicInfo, 0644); err != nil {
+			return nil, fmt.Errorf("failed to write ComicInfo.xml: %w", err)
+		}
+	}
+	return &Document{
+		Title:     metadata.Title,
+		Series:    strings.TrimSpace(info.Series),
+		Issue:     strings.TrimSpace(info.Number),
+		Format:    format,
+		Pages:     len(pages),
+		ComicInfo: comicInfo != nil,
+	}, nil
+}
+
+// pageImage names the image of a page in the book
+func pageImage(index int, ext string) string {
+	return fmt.Sprintf("page_%03d%s", index+1, ext)
+}
+
+// pageBlocks returns a block per page; pages bookmarked in ComicInfo.xml open a
+// chapter and deleted pages are left out
+func pageBlocks(pages []archiveFile, info *parser.ComicInfo) []book.Block {
+	described := make(map[int]parser.ComicPage)
+	for _, page := range info.Pages {
+		described[page.Image] = page
+	}
+	var blocks []book.Block
+	for i, page := range pages {
+		description := described[i]
+		if strings.EqualFold(description.Type, "Deleted") {
+			continue
+		}
+		if bookmark := strings.TrimSpace(description.Bookmark); bookmark != "" {
+			blocks = append(blocks, book.Block{Level: 1, Title: bookmark, HTML: book.EscapeText(bookmark)})
+		}
+		ext := strings.ToLower(path.Ext(page.name))
+		blocks = append(blocks, book.Block{HTML: fmt.Sprintf(`<div class="comic-page"><img src="../images/%s" alt="Page %d"/></div>`, pageImage(i, ext), i+1)})
+	}
+	return blocks
+}
+
+// coverPage returns the index of the front cover in ComicInfo.xml, or else of the
+// first page
+func coverPage(info *parser.ComicInfo) int {
+	for _, page := range info.Pages {
+		if strings.EqualFold(page.Type, "FrontCover") && page.Image >= 0 {
+			return page.Image
+		}
+	}
+	return 0
+}
+
+// readArchive returns the files of a comic archive; CBR archives that are really
+// ZIP files are read as such, the others are extracted with unrar or bsdtar
+func readArchive(inputPath string) ([]archiveFile, error) {
+	data, err := os.ReadFile(inputPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", inputPath, err)
+	}
+	if bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		return readZip(data)
+	}
+	if Format(inputPath) == "CBZ" {
+		return nil, fmt.Errorf("%s is not a ZIP archive", filepath.Base(inputPath))
+	}
+	return extractRAR(inputPath)
+}
+
+// readZip returns the files of a ZIP archive
+func readZip(data []byte) ([]archiveFile, error) {
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive: %w", err)
+	}
+	var files []archiveFile
+	for _, entry := range reader.File {
+		if entry.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := entry.Open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %s: %w", entry.Name, err)
+		}
+		content, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", entry.Name, err)
+		}
+		files = append(files, archiveFile{name: strings.ReplaceAll(entry.Name, "\\", "/"), data: content})
+	}
+	return files, nil
+}
+
+// extractRAR extracts a RAR archive with unrar or bsdtar and returns its files
+func extractRAR(inputPath string) ([]archiveFile, error) {
+	workDir, err := os.MkdirTemp("", "folian-comic-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create extraction directory: %w", err)
+	}
+	defer os.RemoveAll(workDir)
+
+	var command *exec.Cmd
+	if program := os.Getenv(ExtractEnv); program != "" {
+		command = exec.Command(program, "x", "-o+", "-idq", inputPath, workDir+string(filepath.Separator))
+	} else if program, err := exec.LookPath("unrar"); err == nil {
+		command = exec.Command(program, "x", "-o+", "-idq", inputPath, workDir+string(filepath.Separator))
+	} else if program, err := exec.LookPath("bsdtar"); err == nil {
+		command = exec.Command(program, "-xf", inputPath, "-C", workDir)
+	} else {
+		return nil, fmt.Errorf("reading CBR archives requires unrar or bsdtar in the PATH, or %s", ExtractEnv)
+	}
+	if output, err := command.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("failed to extract %s: %w: %s", filepath.Base(inputPath), err, strings.TrimSpace(string(output)))
+	}
+
+	var files []archiveFile
+	err = filepath.WalkDir(workDir, func(file string, entry os.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(workDir, file)
+		files = append(files, archiveFile{name: filepath.ToSlash(rel), data: content})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", filepath.Base(inputPath), err)
+	}
+	return files, nil
+}
+
+// naturalLess orders page names with their numbers compared by value, so that
+// page 2 comes before page 10
+func naturalLess(a, b string) bool {
+	a, b = strings.ToLower(a), strings.ToLower(b)
+	for a != "" && b != "" {
+		if digits(a) > 0 && digits(b) > 0 {
+			i, j := digits(a), digits(b)
+			x, y := strings.TrimLeft(a[:i], "0"), strings.TrimLeft(b[:j], "0")
+			if len(x) != len(y) {
+				return len(x) < len(y)
+			}
+			if x != y {
+				return x < y
+			}
+			a, b = a[i:], b[j:]
+			continue
+		}
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
+
+// digits returns the length of the run of digits starting s
+func digits(s string) int {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	return i
+}
@@ -0,0 +1,37 @@
+// Package command holds the helpers shared by the code running external programs:
+// summarizing their output for errors and opening the browser
+package command
+
+import (
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// LastLines returns the last n non-empty lines of a command's output
+func LastLines(output string, n int) string {
+	var lines []string
+	for _, line := range strings.Split(output, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// OpenBrowser opens a URL in the default browser
+func OpenBrowser(url string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	return cmd.Start()
+}
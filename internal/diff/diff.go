@@ -0,0 +1,242 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/flouciel/folian-parser/internal/parser"
+)
+
+// Operation kinds used in diff results
+const (
+	OpEqual  = "equal"
+	OpInsert = "insert"
+	OpDelete = "delete"
+)
+
+// maxDiffCells limits the size of the LCS table used for token diffs
+const maxDiffCells = 4000000
+
+// Report holds the content-level differences between two books
+type Report struct {
+	Old      string        `json:"old"`
+	New      string        `json:"new"`
+	Metadata []FieldChange `json:"metadata"`
+	Spine    []Op          `json:"spine"`
+	TOC      []Op          `json:"toc"`
+	Chapters []ChapterDiff `json:"chapters"`
+}
+
+// FieldChange describes a metadata field whose value differs
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// Op is a run of tokens that are equal, inserted or deleted
+type Op struct {
+	Kind   string   `json:"op"`
+	Tokens []string `json:"tokens"`
+}
+
+// ChapterDiff describes the word-level differences of a chapter pair
+type ChapterDiff struct {
+	Index    int    `json:"index"`
+	OldTitle string `json:"oldTitle"`
+	NewTitle string `json:"newTitle"`
+	Added    int    `json:"added"`
+	Removed  int    `json:"removed"`
+	Ops      []Op   `json:"ops"`
+}
+
+// Compare compares the metadata, spine order, TOC tree and chapter text of two books
+func Compare(oldBook, newBook *parser.Book) *Report {
+	report := &Report{
+		Old: oldBook.Path,
+		New: newBook.Path,
+	}
+
+	report.Metadata = compareMetadata(oldBook.Metadata, newBook.Metadata)
+
+	if ops := Tokens(spineHrefs(oldBook), spineHrefs(newBook)); hasChanges(ops) {
+		report.Spine = ops
+	}
+
+	if ops := Tokens(flattenTOC(oldBook.TOC, 0), flattenTOC(newBook.TOC, 0)); hasChanges(ops) {
+		report.TOC = ops
+	}
+
+	chapterCount := len(oldBook.Chapters)
+	if len(newBook.Chapters) > chapterCount {
+		chapterCount = len(newBook.Chapters)
+	}
+	for i := 0; i < chapterCount; i++ {
+		var oldChapter, newChapter parser.Chapter
+		if i < len(oldBook.Chapters) {
+			oldChapter = oldBook.Chapters[i]
+		}
+		if i < len(newBook.Chapters) {
+			newChapter = newBook.Chapters[i]
+		}
+
+		ops := Tokens(strings.Fields(oldChapter.Text()), strings.Fields(newChapter.Text()))
+		if !hasChanges(ops) {
+			continue
+		}
+
+		chapterDiff := ChapterDiff{
+			Index:    i + 1,
+			OldTitle: oldChapter.Title,
+			NewTitle: newChapter.Title,
+			Ops:      ops,
+		}
+		for _, op := range ops {
+			switch op.Kind {
+			case OpInsert:
+				chapterDiff.Added += len(op.Tokens)
+			case OpDelete:
+				chapterDiff.Removed += len(op.Tokens)
+			}
+		}
+		report.Chapters = append(report.Chapters, chapterDiff)
+	}
+
+	return report
+}
+
+// Changed reports whether any difference was found
+func (r *Report) Changed() bool {
+	return len(r.Metadata) > 0 || len(r.Spine) > 0 || len(r.TOC) > 0 || len(r.Chapters) > 0
+}
+
+// compareMetadata returns the metadata fields whose values differ
+func compareMetadata(oldMeta, newMeta parser.Metadata) []FieldChange {
+	fields := []struct {
+		name     string
+		old, new string
+	}{
+		{"title", oldMeta.Title, newMeta.Title},
+		{"creator", oldMeta.Creator, newMeta.Creator},
+		{"language", oldMeta.Language, newMeta.Language},
+		{"identifier", oldMeta.Identifier, newMeta.Identifier},
+		{"publisher", oldMeta.Publisher, newMeta.Publisher},
+		{"description", oldMeta.Description, newMeta.Description},
+		{"date", oldMeta.Date, newMeta.Date},
+	}
+
+	var changes []FieldChange
+	for _, field := range fields {
+		if strings.TrimSpace(field.old) != strings.TrimSpace(field.new) {
+			changes = append(changes, FieldChange{Field: field.name, Old: field.old, New: field.new})
+		}
+	}
+	return changes
+}
+
+// spineHrefs returns the manifest hrefs of the spine items in reading order
+func spineHrefs(book *parser.Book) []string {
+	var hrefs []string
+	for _, item := range book.Spine {
+		if manifestItem, ok := book.Manifest[item.IDRef]; ok {
+			hrefs = append(hrefs, manifestItem.Href)
+		}
+	}
+	return hrefs
+}
+
+// flattenTOC renders a TOC tree as indented lines so it can be diffed
+func flattenTOC(entries []parser.TOCEntry, depth int) []string {
+	var lines []string
+	for _, entry := range entries {
+		lines = append(lines, fmt.Sprintf("%s%s [%s]", strings.Repeat("  ", depth), entry.Title, entry.Href))
+		lines = append(lines, flattenTOC(entry.Children, depth+1)...)
+	}
+	return lines
+}
+
+// hasChanges reports whether a diff contains any insertions or deletions
+func hasChanges(ops []Op) bool {
+	for _, op := range ops {
+		if op.Kind != OpEqual {
+			return true
+		}
+	}
+	return false
+}
+
+// Tokens computes a minimal diff between two token sequences
+func Tokens(a, b []string) []Op {
+	// Strip the common prefix and suffix to keep the LCS table small
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var ops []Op
+	ops = appendOp(ops, OpEqual, a[:prefix]...)
+
+	midA := a[prefix : len(a)-suffix]
+	midB := b[prefix : len(b)-suffix]
+	if len(midA)*len(midB) > maxDiffCells {
+		// Too large for an exact diff, report the middle as replaced
+		ops = appendOp(ops, OpDelete, midA...)
+		ops = appendOp(ops, OpInsert, midB...)
+	} else {
+		ops = append(ops, lcsDiff(midA, midB)...)
+	}
+
+	return appendOp(ops, OpEqual, a[len(a)-suffix:]...)
+}
+
+// lcsDiff diffs two token sequences using a longest common subsequence table
+func lcsDiff(a, b []string) []Op {
+	width := len(b) + 1
+	table := make([]int32, (len(a)+1)*width)
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				table[i*width+j] = table[(i+1)*width+j+1] + 1
+			} else if table[(i+1)*width+j] >= table[i*width+j+1] {
+				table[i*width+j] = table[(i+1)*width+j]
+			} else {
+				table[i*width+j] = table[i*width+j+1]
+			}
+		}
+	}
+
+	var ops []Op
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			ops = appendOp(ops, OpEqual, a[i])
+			i++
+			j++
+		case table[(i+1)*width+j] >= table[i*width+j+1]:
+			ops = appendOp(ops, OpDelete, a[i])
+			i++
+		default:
+			ops = appendOp(ops, OpInsert, b[j])
+			j++
+		}
+	}
+	ops = appendOp(ops, OpDelete, a[i:]...)
+	return appendOp(ops, OpInsert, b[j:]...)
+}
+
+// appendOp appends tokens to the diff, merging them into the previous op of the same kind
+func appendOp(ops []Op, kind string, tokens ...string) []Op {
+	if len(tokens) == 0 {
+		return ops
+	}
+	if len(ops) > 0 && ops[len(ops)-1].Kind == kind {
+		ops[len(ops)-1].Tokens = append(ops[len(ops)-1].Tokens, tokens...)
+		return ops
+	}
+	return append(ops, Op{Kind: kind, Tokens: append([]string(nil), tokens...)})
+}
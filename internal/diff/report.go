@@ -0,0 +1,156 @@
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+)
+
+// contextTokens is the number of unchanged words shown around each change in text reports
+const contextTokens = 6
+
+// Write renders the report in the given format (text, html or json)
+func (r *Report) Write(w io.Writer, format string) error {
+	switch format {
+	case "", "text":
+		return r.WriteText(w)
+	case "html":
+		return r.WriteHTML(w)
+	case "json":
+		return r.WriteJSON(w)
+	default:
+		return fmt.Errorf("unsupported diff format: %s", format)
+	}
+}
+
+// WriteJSON renders the report as indented JSON
+func (r *Report) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// WriteText renders the report as a human-readable text report
+func (r *Report) WriteText(w io.Writer) error {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", r.Old, r.New)
+	if !r.Changed() {
+		b.WriteString("\nNo content differences found\n")
+		_, err := io.WriteString(w, b.String())
+		return err
+	}
+
+	if len(r.Metadata) > 0 {
+		b.WriteString("\nMetadata:\n")
+		for _, change := range r.Metadata {
+			fmt.Fprintf(&b, "  %s: %q → %q\n", change.Field, change.Old, change.New)
+		}
+	}
+
+	if len(r.Spine) > 0 {
+		b.WriteString("\nSpine order:\n")
+		writeLineOps(&b, r.Spine)
+	}
+
+	if len(r.TOC) > 0 {
+		b.WriteString("\nTable of contents:\n")
+		writeLineOps(&b, r.TOC)
+	}
+
+	for _, chapter := range r.Chapters {
+		fmt.Fprintf(&b, "\nChapter %d: %q → %q (+%d/-%d words)\n",
+			chapter.Index, chapter.OldTitle, chapter.NewTitle, chapter.Added, chapter.Removed)
+		b.WriteString("  ")
+		for i, op := range chapter.Ops {
+			switch op.Kind {
+			case OpDelete:
+				fmt.Fprintf(&b, "[-%s-] ", strings.Join(op.Tokens, " "))
+			case OpInsert:
+				fmt.Fprintf(&b, "{+%s+} ", strings.Join(op.Tokens, " "))
+			default:
+				b.WriteString(abbreviate(op.Tokens, i > 0, i < len(chapter.Ops)-1))
+				b.WriteString(" ")
+			}
+		}
+		b.WriteString("\n")
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeLineOps writes line-based diff ops in unified diff style
+func writeLineOps(b *strings.Builder, ops []Op) {
+	for _, op := range ops {
+		prefix := "   "
+		switch op.Kind {
+		case OpDelete:
+			prefix = "  -"
+		case OpInsert:
+			prefix = "  +"
+		}
+		for _, line := range op.Tokens {
+			fmt.Fprintf(b, "%s %s\n", prefix, line)
+		}
+	}
+}
+
+// abbreviate shortens a run of unchanged words to the context around neighbouring changes
+func abbreviate(tokens []string, changeBefore, changeAfter bool) string {
+	if len(tokens) <= 2*contextTokens {
+		return strings.Join(tokens, " ")
+	}
+
+	var parts []string
+	if changeBefore {
+		parts = append(parts, strings.Join(tokens[:contextTokens], " "))
+	}
+	parts = append(parts, "…")
+	if changeAfter {
+		parts = append(parts, strings.Join(tokens[len(tokens)-contextTokens:], " "))
+	}
+	return strings.Join(parts, " ")
+}
+
+// htmlReportTemplate renders the report as a standalone HTML page
+var htmlReportTemplate = template.Must(template.New("diff").Funcs(template.FuncMap{
+	"join": strings.Join,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8"/>
+  <title>EPUB diff</title>
+  <style>
+    body { font-family: sans-serif; margin: 2em; line-height: 1.5; }
+    del { background: #fdd; color: #900; }
+    ins { background: #dfd; color: #060; text-decoration: none; }
+    pre { background: #f6f6f6; padding: 0.5em; }
+  </style>
+</head>
+<body>
+  <h1>EPUB diff</h1>
+  <p><del>{{.Old}}</del><br/><ins>{{.New}}</ins></p>
+{{if not .Changed}}  <p>No content differences found</p>
+{{end}}{{if .Metadata}}  <h2>Metadata</h2>
+  <table>
+{{range .Metadata}}    <tr><th>{{.Field}}</th><td><del>{{.Old}}</del></td><td><ins>{{.New}}</ins></td></tr>
+{{end}}  </table>
+{{end}}{{if .Spine}}  <h2>Spine order</h2>
+  <pre>{{range .Spine}}{{$kind := .Kind}}{{range .Tokens}}{{if eq $kind "delete"}}<del>- {{.}}</del>{{else if eq $kind "insert"}}<ins>+ {{.}}</ins>{{else}}  {{.}}{{end}}
+{{end}}{{end}}</pre>
+{{end}}{{if .TOC}}  <h2>Table of contents</h2>
+  <pre>{{range .TOC}}{{$kind := .Kind}}{{range .Tokens}}{{if eq $kind "delete"}}<del>- {{.}}</del>{{else if eq $kind "insert"}}<ins>+ {{.}}</ins>{{else}}  {{.}}{{end}}
+{{end}}{{end}}</pre>
+{{end}}{{range .Chapters}}  <h2>Chapter {{.Index}}: {{.NewTitle}} (+{{.Added}}/-{{.Removed}} words)</h2>
+  <p>{{range .Ops}}{{if eq .Kind "delete"}}<del>{{join .Tokens " "}}</del> {{else if eq .Kind "insert"}}<ins>{{join .Tokens " "}}</ins> {{else}}{{join .Tokens " "}} {{end}}{{end}}</p>
+{{end}}</body>
+</html>
+`))
+
+// WriteHTML renders the report as a standalone HTML page
+func (r *Report) WriteHTML(w io.Writer) error {
+	return htmlReportTemplate.Execute(w, r)
+}
@@ -0,0 +1,604 @@
+package docx
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/flouciel/folian-parser/internal/book"
+	"github.com/flouciel/folian-parser/internal/htmlnode"
+	"github.com/flouciel/folian-parser/internal/policy"
+)
+
+// style is a paragraph style of the manuscript
+type style struct {
+	name    string
+	basedOn string
+	level   int
+}
+
+// skippedElements are the elements whose content is not part of the text: deleted
+// text, field codes, text boxes, the fallback of alternate content and properties
+var skippedElements = map[string]bool{
+	"del": true, "delText": true, "instrText": true, "txbxContent": true, "txBody": true, "Fallback": true, "sectPr": true, "tabs": true, "commentReference": true,
+}
+
+// headingPattern matches the names of the built-in heading styles, e.g. "heading 1"
+var headingPattern = regexp.MustCompile(`^heading ?([1-9])$`)
+
+// sceneBreakPattern matches the paragraphs that only mark a scene break
+var sceneBreakPattern = regexp.MustCompile(`^\s*([*#~•]\s*){1,5}\s*$`)
+
+// formatTags close formatting that continues from one run into the next, so that it
+// is merged into one element
+var formatTags = strings.NewReplacer("</strong><strong>", "", "</em><em>", "", "</sup><sup>", "", "</sub><sub>", "", "</s><s>", "")
+
+// converter converts the WordprocessingML of a manuscript to XHTML
+type converter struct {
+	m            *manuscript
+	documentRels map[string]relationship
+	styles       map[string]style
+	numbering    map[string]string
+	images       []book.Image
+	imageNames   map[string]string
+	notes        map[string]string
+	// title is the text of the first Title paragraph; language the default language
+	title    string
+	language string
+}
+
+// newConverter reads the styles, numbering and notes of a manuscript
+func newConverter(m *manuscript, documentPart string) (*converter, error) {
+	c := &converter{m: m, styles: make(map[string]style), numbering: make(map[string]string), imageNames: make(map[string]string), notes: make(map[string]string)}
+	var err error
+	if c.documentRels, err = m.relationships(documentPart); err != nil {
+		return nil, err
+	}
+	if err := c.readStyles(); err != nil {
+		return nil, err
+	}
+	if err := c.readNumbering(); err != nil {
+		return nil, err
+	}
+
+	// Footnotes and endnotes are converted like the body, into the note they are in
+	for _, part := range []struct{ name, prefix string }{{"word/footnotes.xml", "fn"}, {"word/endnotes.xml", "en"}} {
+		data, err := m.read(part.name)
+		if err != nil {
+			return nil, err
+		}
+		if data == nil {
+			continue
+		}
+		rels, err := m.relationships(part.name)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.convertNotes(data, rels, part.prefix); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", part.name, err)
+		}
+	}
+	return c, nil
+}
+
+// readStyles reads the paragraph styles and default language of word/styles.xml
+func (c *converter) readStyles() error {
+	data, err := c.m.read("word/styles.xml")
+	if err != nil || data == nil {
+		return err
+	}
+	var styles struct {
+		Defaults struct {
+			Lang struct {
+				Val string `xml:"val,attr"`
+			} `xml:"rPrDefault>rPr>lang"`
+		} `xml:"docDefaults"`
+		Styles []struct {
+			ID      string   `xml:"styleId,attr"`
+			Name    valAttr  `xml:"name"`
+			BasedOn valAttr  `xml:"basedOn"`
+			Outline *valAttr `xml:"pPr>outlineLvl"`
+		} `xml:"style"`
+	}
+	if err := xml.Unmarshal(data, &styles); err != nil {
+		return fmt.Errorf("failed to parse word/styles.xml: %w", err)
+	}
+	c.language = styles.Defaults.Lang.Val
+	for _, s := range styles.Styles {
+		st := style{name: strings.ToLower(s.Name.Val), basedOn: s.BasedOn.Val}
+		if s.Outline != nil {
+			if level, err := strconv.Atoi(s.Outline.Val); err == nil && level < 9 {
+				st.level = level + 1
+			}
+		}
+		c.styles[s.ID] = st
+	}
+	return nil
+}
+
+// valAttr is an element whose value is its w:val attribute
+type valAttr struct {
+	Val string `xml:"val,attr"`
+}
+
+// readNumbering reads which numbered lists of word/numbering.xml are bulleted
+func (c *converter) readNumbering() error {
+	data, err := c.m.read("word/numbering.xml")
+	if err != nil || data == nil {
+		return err
+	}
+	var numbering struct {
+		Abstract []struct {
+			ID     string `xml:"abstractNumId,attr"`
+			Levels []struct {
+				Format valAttr `xml:"numFmt"`
+			} `xml:"lvl"`
+		} `xml:"abstractNum"`
+		Nums []struct {
+			ID       string  `xml:"numId,attr"`
+			Abstract valAttr `xml:"abstractNumId"`
+		} `xml:"num"`
+	}
+	if err := xml.Unmarshal(data, &numbering); err != nil {
+		return fmt.Errorf("failed to parse word/numbering.xml: %w", err)
+	}
+	formats := make(map[string]string)
+	for _, abstract := range numbering.Abstract {
+		if len(abstract.Levels) > 0 {
+			formats[abstract.ID] = abstract.Levels[0].Format.Val
+		}
+	}
+	for _, num := range numbering.Nums {
+		if formats[num.Abstract.Val] == "bullet" || formats[num.Abstract.Val] == "" {
+			c.numbering[num.ID] = "ul"
+		} else {
+			c.numbering[num.ID] = "ol"
+		}
+	}
+	return nil
+}
+
+// styleOf resolves a paragraph style through the styles it is based on, returning
+// its lowercase name and heading level
+func (c *converter) styleOf(id string) (string, int) {
+	name, level := "", 0
+	for depth := 0; id != "" && depth < 10; depth++ {
+		st, ok := c.styles[id]
+		if !ok {
+			break
+		}
+		if name == "" {
+			name = st.name
+		}
+		if level == 0 {
+			level = st.level
+		}
+		if match := headingPattern.FindStringSubmatch(st.name); match != nil && level == 0 {
+			level, _ = strconv.Atoi(match[1])
+		}
+		id = st.basedOn
+	}
+	if name == "" {
+		name = strings.ToLower(id)
+		if match := headingPattern.FindStringSubmatch(name); match != nil {
+			level, _ = strconv.Atoi(match[1])
+		}
+	}
+	return name, level
+}
+
+// paragraph is the state of the paragraph being converted
+type paragraph struct {
+	styleID string
+	align   string
+	numID   string
+	level   int
+	id      string
+	html    strings.Builder
+	text    strings.Builder
+}
+
+// run is the formatting of the run being converted
+type run struct {
+	bold, italic, strike, superscript, subscript bool
+}
+
+// table is the state of a table being converted
+type table struct {
+	html strings.Builder
+	cell strings.Builder
+}
+
+// convert converts a part's body to blocks
+func (c *converter) convert(data []byte, rels map[string]relationship) ([]book.Block, error) {
+	var blocks []book.Block
+	err := c.walk(data, rels, func(b book.Block) { blocks = append(blocks, b) }, nil)
+	return blocks, err
+}
+
+// convertNotes converts the notes of a footnotes or endnotes part, keyed by the IDs
+// of their asides
+func (c *converter) convertNotes(data []byte, rels map[string]relationship, prefix string) error {
+	notes := make(map[string]*strings.Builder)
+	return c.walk(data, rels, nil, func(id string, b book.Block) {
+		key := prefix + id
+		if notes[key] == nil {
+			notes[key] = &strings.Builder{}
+		}
+		notes[key].WriteString(b.HTML + "\n")
+		c.notes[key] = notes[key].String()
+	})
+}
+
+// walk converts the paragraphs and tables of a part in document order, passing body
+// blocks to emit, or the blocks of each note to emitNote
+func (c *converter) walk(data []byte, rels map[string]relationship, emit func(book.Block), emitNote func(string, book.Block)) error {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	var (
+		para    *paragraph
+		r       run
+		tables  []*table
+		skip    int
+		noteID  string
+		altText string
+		// links records, for each open hyperlink, whether its element was written
+		links []bool
+	)
+
+	finish := func(b book.Block) {
+		if len(tables) > 0 {
+			tables[len(tables)-1].cell.WriteString(b.HTML)
+			return
+		}
+		if emitNote != nil {
+			if noteID != "" {
+				emitNote(noteID, b)
+			}
+			return
+		}
+		emit(b)
+	}
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			if skip > 0 || skippedElements[t.Name.Local] {
+				skip++
+				continue
+			}
+			switch t.Name.Local {
+			case "footnote", "endnote":
+				noteID = attr(t, "id")
+			case "p":
+				para = &paragraph{}
+			case "pStyle":
+				if para != nil {
+					para.styleID = attr(t, "val")
+				}
+			case "jc":
+				if para != nil {
+					para.align = attr(t, "val")
+				}
+			case "numId":
+				if para != nil {
+					para.numID = attr(t, "val")
+				}
+			case "outlineLvl":
+				if para != nil {
+					if level, err := strconv.Atoi(attr(t, "val")); err == nil && level < 9 {
+						para.level = level + 1
+					}
+				}
+			case "r":
+				r = run{}
+			case "b":
+				r.bold = on(t)
+			case "i":
+				r.italic = on(t)
+			case "u":
+				// Manuscripts underline what is set in italics
+				r.italic = r.italic || attr(t, "val") != "none"
+			case "strike", "dstrike":
+				r.strike = on(t)
+			case "vertAlign":
+				r.superscript = attr(t, "val") == "superscript"
+				r.subscript = attr(t, "val") == "subscript"
+			case "t":
+				var text string
+				if err := decoder.DecodeElement(&text, &t); err != nil {
+					return err
+				}
+				if para != nil {
+					para.html.WriteString(r.format(book.EscapeText(text)))
+					para.text.WriteString(text)
+				}
+			case "tab":
+				if para != nil {
+					para.html.WriteString(" ")
+					para.text.WriteString(" ")
+				}
+			case "br", "cr":
+				if para != nil && attr(t, "type") != "page" && attr(t, "type") != "column" {
+					para.html.WriteString("<br/>")
+				}
+			case "noBreakHyphen":
+				if para != nil {
+					para.html.WriteString("‑")
+					para.text.WriteString("‑")
+				}
+			case "bookmarkStart":
+				if para == nil {
+					continue
+				}
+				name := anchorID(attr(t, "name"))
+				if name == "" || strings.HasPrefix(name, "_GoBack") {
+					continue
+				}
+				if para.id == "" {
+					para.id = name
+				} else {
+					para.html.WriteString(fmt.Sprintf(`<a id="%s"></a>`, name))
+				}
+			case "hyperlink":
+				if para == nil {
+					continue
+				}
+				href := ""
+				if rel, ok := rels[attr(t, "id")]; ok && rel.External {
+					href = rel.Target
+				} else if anchor := anchorID(attr(t, "anchor")); anchor != "" {
+					href = "#" + anchor
+				}
+				// Unresolvable links keep their text
+				if href != "" {
+					para.html.WriteString(fmt.Sprintf(`<a href="%s">`, book.EscapeText(href)))
+				}
+				links = append(links, href != "")
+			case "footnoteReference", "endnoteReference":
+				if para == nil {
+					continue
+				}
+				prefix := "fn"
+				if t.Name.Local == "endnoteReference" {
+					prefix = "en"
+				}
+				id := attr(t, "id")
+				para.html.WriteString(fmt.Sprintf(`<a epub:type="noteref" href="#%s%s" id="ref-%s%s"><sup>%s</sup></a>`, prefix, id, prefix, id, id))
+			case "docPr":
+				altText = attr(t, "descr")
+				if altText == "" {
+					altText = attr(t, "title")
+				}
+			case "blip", "imagedata":
+				id := attr(t, "embed")
+				if id == "" {
+					id = attr(t, "id")
+				}
+				if para != nil {
+					if src := c.addImage(rels, id); src != "" {
+						para.html.WriteString(fmt.Sprintf(`<img src="../images/%s" alt="%s"/>`, src, book.EscapeText(altText)))
+						para.text.WriteString("￼")
+					}
+				}
+				altText = ""
+			case "tbl":
+				tables = append(tables, &table{})
+				tables[len(tables)-1].html.WriteString("<table>\n")
+			case "tr":
+				if len(tables) > 0 {
+					tables[len(tables)-1].html.WriteString("<tr>")
+				}
+			case "tc":
+				if len(tables) > 0 {
+					tables[len(tables)-1].cell.Reset()
+				}
+			}
+
+		case xml.EndElement:
+			if skip > 0 {
+				skip--
+				continue
+			}
+			switch t.Name.Local {
+			case "footnote", "endnote":
+				noteID = ""
+			case "hyperlink":
+				if len(links) > 0 {
+					if para != nil && links[len(links)-1] {
+						para.html.WriteString("</a>")
+					}
+					links = links[:len(links)-1]
+				}
+			case "p":
+				if para != nil {
+					if b, ok := c.paragraphBlock(para, len(tables) > 0, emitNote != nil); ok {
+						finish(b)
+					}
+				}
+				para = nil
+				links = nil
+			case "tc":
+				if len(tables) > 0 {
+					current := tables[len(tables)-1]
+					current.html.WriteString("<td>" + current.cell.String() + "</td>")
+				}
+			case "tr":
+				if len(tables) > 0 {
+					tables[len(tables)-1].html.WriteString("</tr>\n")
+				}
+			case "tbl":
+				if len(tables) > 0 {
+					current := tables[len(tables)-1]
+					tables = tables[:len(tables)-1]
+					current.html.WriteString("</table>")
+					finish(book.Block{HTML: current.html.String()})
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// format wraps converted text in the elements of the run's formatting
+func (r run) format(text string) string {
+	if text == "" {
+		return ""
+	}
+	if r.superscript {
+		text = "<sup>" + text + "</sup>"
+	} else if r.subscript {
+		text = "<sub>" + text + "</sub>"
+	}
+	if r.strike {
+		text = "<s>" + text + "</s>"
+	}
+	if r.italic {
+		text = "<em>" + text + "</em>"
+	}
+	if r.bold {
+		text = "<strong>" + text + "</strong>"
+	}
+	return text
+}
+
+// paragraphBlock converts a finished paragraph to a block, mapping its style to the
+// theme classes; empty paragraphs, tables of contents and the Title paragraph, which
+// becomes the book title, give no block
+func (c *converter) paragraphBlock(para *paragraph, inTable, inNote bool) (book.Block, bool) {
+	name, level := c.styleOf(para.styleID)
+	if para.level > 0 && level == 0 {
+		level = para.level
+	}
+	content := formatTags.Replace(strings.TrimSpace(para.html.String()))
+	text := strings.TrimSpace(para.text.String())
+	if text == "" && !strings.Contains(content, "<img") {
+		return book.Block{}, false
+	}
+	if strings.HasPrefix(name, "toc") {
+		return book.Block{}, false
+	}
+
+	attrs := ""
+	if para.id != "" {
+		attrs = fmt.Sprintf(` id="%s"`, para.id)
+	}
+	if inTable || inNote {
+		return book.Block{HTML: fmt.Sprintf("<p%s>%s</p>", attrs, content)}, true
+	}
+
+	switch {
+	case name == "title":
+		if c.title == "" {
+			c.title = text
+			return book.Block{}, false
+		}
+		return book.Block{HTML: fmt.Sprintf("<p class=\"title\"%s>%s</p>", attrs, content)}, true
+	case level > 0:
+		return book.Block{Level: level, Title: text, Attrs: attrs, HTML: content}, true
+	case para.numID != "" && para.numID != "0":
+		list := c.numbering[para.numID]
+		if list == "" {
+			list = "ul"
+		}
+		return book.Block{List: list, Attrs: attrs, HTML: content}, true
+	case name == "scene break" || sceneBreakPattern.MatchString(text):
+		return book.Block{HTML: `<hr class="scene-break"/>`}, true
+	}
+
+	tag, class := "p", ""
+	switch {
+	case name == "epigraph":
+		tag, class = "blockquote", "epigraph"
+	case name == "quote" || name == "intense quote" || name == "block text" || strings.Contains(name, "block quote") || strings.Contains(name, "extract"):
+		tag = "blockquote"
+	case strings.Contains(name, "poem") || strings.Contains(name, "verse") || strings.Contains(name, "poetry"):
+		class = "poem"
+	case strings.Contains(name, "code") || name == "html preformatted" || name == "plain text":
+		tag = "pre"
+	case name == "author" || name == "byline":
+		class = "author"
+	case name == "first paragraph" || strings.Contains(name, "no indent"):
+		class = "nonindent"
+	case name == "subtitle" || para.align == "center":
+		class = "center"
+	case para.align == "right" || para.align == "end":
+		class = "right"
+	}
+	if class != "" {
+		attrs = fmt.Sprintf(` class="%s"`, class) + attrs
+	}
+	if tag == "blockquote" {
+		return book.Block{HTML: fmt.Sprintf("<blockquote%s><p>%s</p></blockquote>", attrs, content)}, true
+	}
+	return book.Block{HTML: fmt.Sprintf("<%s%s>%s</%s>", tag, attrs, content, tag)}, true
+}
+
+// addImage copies an embedded image into the book once and returns its file name,
+// or "" when it cannot be shown
+func (c *converter) addImage(rels map[string]relationship, id string) string {
+	rel, ok := rels[id]
+	if !ok || rel.External {
+		return ""
+	}
+	if name, ok := c.imageNames[rel.Target]; ok {
+		return name
+	}
+	ext := strings.ToLower(path.Ext(rel.Target))
+	mediaType, ok := book.ImageTypes[ext]
+	if !ok {
+		policy.Warn(policy.KindOther, "Skipping the %s image %s, which EPUB readers cannot show", strings.ToUpper(strings.TrimPrefix(ext, ".")), path.Base(rel.Target))
+		c.imageNames[rel.Target] = ""
+		return ""
+	}
+	data, err := c.m.read(rel.Target)
+	if err != nil || data == nil {
+		policy.Warn(policy.KindOther, "Skipping the missing image %s", rel.Target)
+		c.imageNames[rel.Target] = ""
+		return ""
+	}
+	name := fmt.Sprintf("image_%03d%s", len(c.images)+1, ext)
+	c.images = append(c.images, book.Image{Name: name, MediaType: mediaType, Data: data})
+	c.imageNames[rel.Target] = name
+	return name
+}
+
+// attr returns the value of an attribute by its local name
+func attr(element xml.StartElement, name string) string {
+	for _, a := range element.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// on reports whether a toggle property such as w:b is switched on
+func on(element xml.StartElement) bool {
+	switch attr(element, "val") {
+	case "0", "false", "off", "none":
+		return false
+	}
+	return true
+}
+
+// anchorID turns a bookmark name into an XML ID
+func anchorID(name string) string {
+	name = htmlnode.AnchorPattern.ReplaceAllString(name, "_")
+	if name != "" && (name[0] >= '0' && name[0] <= '9' || name[0] == '-' || name[0] == '.') {
+		name = "b" + name
+	}
+	return name
+}
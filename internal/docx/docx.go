@@ -0,0 +1,180 @@
+// Package docx reads Word manuscripts (.docx) and writes them as extracted EPUBs:
+// top-level headings start chapters, paragraph styles map to the theme classes, and
+// embedded images, footnotes and the document properties are carried over
+package docx
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/flouciel/folian-parser/internal/book"
+)
+
+// maxPartSize is the largest part read from a manuscript
+const maxPartSize = 256 << 20
+
+// Document is the outcome of converting a manuscript
+type Document struct {
+	Title    string
+	Author   string
+	Chapters int
+	Images   int
+}
+
+// IsDOCX reports whether a path names a Word manuscript
+func IsDOCX(filePath string) bool {
+	return strings.EqualFold(filepath.Ext(filePath), ".docx")
+}
+
+// relationship is an entry of a part's relationships: an embedded image or a link
+type relationship struct {
+	Target   string
+	External bool
+}
+
+// coreProperties are the document properties of docProps/core.xml
+type coreProperties struct {
+	Title       string `xml:"title"`
+	Creator     string `xml:"creator"`
+	Language    string `xml:"language"`
+	Description string `xml:"description"`
+	Subject     string `xml:"subject"`
+	Keywords    string `xml:"keywords"`
+	Created     string `xml:"created"`
+}
+
+// manuscript is an open .docx package
+type manuscript struct {
+	files map[string]*zip.File
+}
+
+// read returns the content of a part, or nil when the package does not have it
+func (m *manuscript) read(name string) ([]byte, error) {
+	file, ok := m.files[name]
+	if !ok {
+		return nil, nil
+	}
+	if file.UncompressedSize64 > maxPartSize {
+		return nil, fmt.Errorf("%s is too large (%d bytes)", name, file.UncompressedSize64)
+	}
+	reader, err := file.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", name, err)
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(io.LimitReader(reader, maxPartSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	return data, nil
+}
+
+// relationships reads the relationships of a part, keyed by their IDs
+func (m *manuscript) relationships(part string) (map[string]relationship, error) {
+	relsPath := path.Join(path.Dir(part), "_rels", path.Base(part)+".rels")
+	data, err := m.read(relsPath)
+	if err != nil || data == nil {
+		return map[string]relationship{}, err
+	}
+	var rels struct {
+		Relationships []struct {
+			ID         string `xml:"Id,attr"`
+			Target     string `xml:"Target,attr"`
+			TargetMode string `xml:"TargetMode,attr"`
+		} `xml:"Relationship"`
+	}
+	if err := xml.Unmarshal(data, &rels); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", relsPath, err)
+	}
+	result := make(map[string]relationship)
+	for _, rel := range rels.Relationships {
+		if rel.TargetMode == "External" {
+			result[rel.ID] = relationship{Target: rel.Target, External: true}
+			continue
+		}
+		target := path.Join(path.Dir(part), rel.Target)
+		if strings.HasPrefix(rel.Target, "/") {
+			target = strings.TrimPrefix(rel.Target, "/")
+		}
+		result[rel.ID] = relationship{Target: target}
+	}
+	return result, nil
+}
+
+// Convert reads a .docx manuscript and writes it as an extracted EPUB in dir, which
+// is created
+func Convert(docxPath, dir string) (*Document, error) {
+	reader, err := zip.OpenReader(docxPath)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DOCX file (not a valid ZIP): %w", err)
+	}
+	defer reader.Close()
+
+	m := &manuscript{files: make(map[string]*zip.File)}
+	for _, file := range reader.File {
+		m.files[file.Name] = file
+	}
+	documentPart := "word/document.xml"
+	if _, ok := m.files[documentPart]; !ok {
+		return nil, fmt.Errorf("invalid DOCX file: %s is missing", documentPart)
+	}
+
+	c, err := newConverter(m, documentPart)
+	if err != nil {
+		return nil, err
+	}
+	data, err := m.read(documentPart)
+	if err != nil {
+		return nil, err
+	}
+	body, err := c.convert(data, c.documentRels)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", documentPart, err)
+	}
+
+	// Metadata comes from the document properties, falling back on the Title
+	// paragraph and the file name
+	var core coreProperties
+	if data, err := m.read("docProps/core.xml"); err == nil && data != nil {
+		xml.Unmarshal(data, &core)
+	}
+	metadata := book.Metadata{
+		Title:       strings.TrimSpace(core.Title),
+		Creator:     strings.TrimSpace(core.Creator),
+		Language:    strings.TrimSpace(core.Language),
+		Description: strings.TrimSpace(core.Description),
+		Date:        strings.TrimSpace(core.Created),
+	}
+	if metadata.Title == "" {
+		metadata.Title = c.title
+	}
+	if metadata.Title == "" {
+		base := filepath.Base(docxPath)
+		metadata.Title = strings.TrimSuffix(base, filepath.Ext(base))
+	}
+	if metadata.Language == "" {
+		metadata.Language = c.language
+	}
+	if metadata.Language == "" {
+		metadata.Language = "en"
+	}
+	for _, subject := range strings.FieldsFunc(core.Subject+","+core.Keywords, func(r rune) bool { return r == ',' || r == ';' }) {
+		if subject = strings.TrimSpace(subject); subject != "" {
+			metadata.Subjects = append(metadata.Subjects, subject)
+		}
+	}
+	if metadata.Identifier, err = book.Identifier(docxPath); err != nil {
+		return nil, err
+	}
+
+	chapters := book.Split(body, c.notes, metadata.Title)
+	if err := book.Write(dir, metadata, chapters, c.images); err != nil {
+		return nil, err
+	}
+	return &Document{Title: metadata.Title, Author: metadata.Creator, Chapters: len(chapters), Images: len(c.images)}, nil
+}
@@ -0,0 +1,201 @@
+package epub
+
+import (
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/flouciel/folian-parser/internal/parser"
+	"github.com/flouciel/folian-parser/internal/policy"
+)
+
+// NoCache disables the cache of extracted and parsed EPUBs
+var NoCache bool
+
+// CacheRoot is the directory the cache is kept in, instead of the user cache
+// directory, when set
+var CacheRoot string
+
+// cacheFormat versions the cached book model; bump it when the parser output changes
+const cacheFormat = "v5"
+
+// cacheMaxAge is how long an unused cache entry is kept
+const cacheMaxAge = 30 * 24 * time.Hour
+
+// CacheDir returns the directory holding the cache of parsed EPUBs
+func CacheDir() (string, error) {
+	if CacheRoot != "" {
+		return filepath.Join(CacheRoot, "folian-parser", cacheFormat), nil
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find the user cache directory: %w", err)
+	}
+	return filepath.Join(dir, "folian-parser", cacheFormat), nil
+}
+
+// ClearCache removes every cached EPUB
+func ClearCache() error {
+	dir, err := CacheDir()
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(filepath.Dir(dir)); err != nil {
+		return fmt.Errorf("failed to clear cache: %w", err)
+	}
+	return nil
+}
+
+// cacheKey returns the SHA-256 of a file's content and the selected rendition
+func cacheKey(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	hash.Write([]byte(parser.SelectRendition))
+	if WindowsSafeNames {
+		hash.Write([]byte("windows-safe-names"))
+	}
+	if KeepFilenames {
+		hash.Write([]byte("keep-filenames"))
+	}
+	if Repair {
+		hash.Write([]byte("repair"))
+	}
+	if policy.Lenient {
+		hash.Write([]byte("lenient"))
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// cacheEntry returns the cache directory of an input file, or "" when caching is
+// disabled or unavailable
+func cacheEntry(inputPath string) string {
+	if NoCache {
+		return ""
+	}
+	dir, err := CacheDir()
+	if err != nil {
+		return ""
+	}
+	key, err := cacheKey(inputPath)
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, key)
+}
+
+// loadCached returns the cached book of an entry, pointing at its cached files
+func loadCached(entry string) (*parser.Book, error) {
+	file, err := os.Open(filepath.Join(entry, "book.gob"))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var book parser.Book
+	if err := gob.NewDecoder(file).Decode(&book); err != nil {
+		return nil, fmt.Errorf("failed to decode cached book: %w", err)
+	}
+	book.Path = filepath.Join(entry, "extracted")
+
+	// Mark the entry as used so that pruning keeps it
+	now := time.Now()
+	os.Chtimes(entry, now, now)
+	return &book, nil
+}
+
+// storeCached saves an extracted and parsed book under its cache entry, replacing
+// the entry atomically, and prunes entries unused for cacheMaxAge
+func storeCached(entry, extractedPath string, book *parser.Book) error {
+	root := filepath.Dir(entry)
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	pruneCache(root)
+
+	staging, err := os.MkdirTemp(root, "staging-*")
+	if err != nil {
+		return fmt.Errorf("failed to create cache entry: %w", err)
+	}
+	defer os.RemoveAll(staging)
+
+	if err := copyTree(extractedPath, filepath.Join(staging, "extracted")); err != nil {
+		return err
+	}
+
+	file, err := os.Create(filepath.Join(staging, "book.gob"))
+	if err != nil {
+		return fmt.Errorf("failed to create cached book: %w", err)
+	}
+	cached := *book
+	cached.Path = ""
+	err = gob.NewEncoder(file).Encode(&cached)
+	file.Close()
+	if err != nil {
+		return fmt.Errorf("failed to encode cached book: %w", err)
+	}
+
+	os.RemoveAll(entry)
+	if err := os.Rename(staging, entry); err != nil {
+		return fmt.Errorf("failed to store cache entry: %w", err)
+	}
+	return nil
+}
+
+// pruneCache removes the entries of the cache directory unused for cacheMaxAge
+func pruneCache(root string) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err == nil && time.Since(info.ModTime()) > cacheMaxAge {
+			os.RemoveAll(filepath.Join(root, entry.Name()))
+		}
+	}
+}
+
+// copyTree copies a directory tree
+func copyTree(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+
+		in, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("failed to copy %s: %w", rel, err)
+		}
+		defer in.Close()
+		out, err := os.Create(target)
+		if err != nil {
+			return fmt.Errorf("failed to copy %s: %w", rel, err)
+		}
+		defer out.Close()
+		if _, err := io.Copy(out, in); err != nil {
+			return fmt.Errorf("failed to copy %s: %w", rel, err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,76 @@
+package epub
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Kinds of Process failures, matched with errors.Is so that callers can report them
+// differently
+var (
+	ErrValidation = errors.New("validation failure")
+	ErrDRM        = errors.New("DRM-protected EPUB")
+	ErrParse      = errors.New("parse error")
+	ErrIO         = errors.New("I/O error")
+)
+
+// kindError tags an error with its kind without changing its message
+type kindError struct {
+	kind error
+	err  error
+}
+
+func (e *kindError) Error() string   { return e.err.Error() }
+func (e *kindError) Unwrap() []error { return []error{e.kind, e.err} }
+
+// withKind tags a non-nil error with its kind
+func withKind(kind, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &kindError{kind: kind, err: err}
+}
+
+// fontObfuscationAlgorithms are the encryption algorithms that only obfuscate
+// embedded fonts, which does not prevent reading the book
+var fontObfuscationAlgorithms = map[string]bool{
+	"http://www.idpf.org/2008/embedding": true,
+	"http://ns.adobe.com/pdf/enc#RC":     true,
+}
+
+// checkDRM reports an error when the extracted EPUB is encrypted with DRM (Adobe
+// ADEPT, Apple FairPlay or other encryption of content documents)
+func checkDRM(extractedPath string) error {
+	for _, name := range []string{"rights.xml", "sinf.xml"} {
+		if _, err := os.Stat(filepath.Join(extractedPath, "META-INF", name)); err == nil {
+			return withKind(ErrDRM, fmt.Errorf("EPUB is DRM-protected (META-INF/%s)", name))
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(extractedPath, "META-INF", "encryption.xml"))
+	if err != nil {
+		return nil
+	}
+	var encryption struct {
+		Data []struct {
+			Method struct {
+				Algorithm string `xml:"Algorithm,attr"`
+			} `xml:"EncryptionMethod"`
+			Reference struct {
+				URI string `xml:"URI,attr"`
+			} `xml:"CipherData>CipherReference"`
+		} `xml:"EncryptedData"`
+	}
+	if err := xml.Unmarshal(data, &encryption); err != nil {
+		return withKind(ErrParse, fmt.Errorf("failed to parse META-INF/encryption.xml: %w", err))
+	}
+	for _, encrypted := range encryption.Data {
+		if !fontObfuscationAlgorithms[encrypted.Method.Algorithm] {
+			return withKind(ErrDRM, fmt.Errorf("EPUB is DRM-protected (%s is encrypted)", encrypted.Reference.URI))
+		}
+	}
+	return nil
+}
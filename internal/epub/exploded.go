@@ -0,0 +1,159 @@
+package epub
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/flouciel/folian-parser/internal/policy"
+	"github.com/flouciel/folian-parser/internal/xmlfmt"
+)
+
+// packJunk are the files operating systems leave in directories, never packed
+var packJunk = map[string]bool{".DS_Store": true, "Thumbs.db": true, "desktop.ini": true}
+
+// IsExplodedEPUB reports whether a directory is an extracted EPUB, holding a
+// META-INF/container.xml or a mimetype file, rather than a directory of EPUB files
+func IsExplodedEPUB(dir string) bool {
+	if _, err := os.Stat(filepath.Join(dir, "META-INF", "container.xml")); err == nil {
+		return true
+	}
+	info, err := os.Stat(filepath.Join(dir, "mimetype"))
+	return err == nil && !info.IsDir()
+}
+
+// ExplodedEntries returns the slash-separated names of the files of an extracted
+// EPUB, as they would be named in its archive
+func ExplodedEntries(dir string) ([]string, error) {
+	var names []string
+	err := filepath.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || packJunk[info.Name()] {
+			return err
+		}
+		rel, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return err
+		}
+		names = append(names, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", dir, err)
+	}
+	return names, nil
+}
+
+// Pack zips an extracted EPUB directory into an EPUB file, with the mimetype entry
+// first and uncompressed as the format requires
+func Pack(dir, outputPath string) error {
+	if _, err := os.Stat(filepath.Join(dir, "META-INF", "container.xml")); err != nil {
+		return fmt.Errorf("%s is not an extracted EPUB: META-INF/container.xml is missing", dir)
+	}
+	if abs, err := filepath.Abs(outputPath); err == nil {
+		if rel, err := filepath.Rel(longPath(dir), abs); err == nil && !strings.HasPrefix(rel, "..") {
+			return fmt.Errorf("the output %s must not be inside %s", outputPath, dir)
+		}
+	}
+	return NewProcessor().createEPUB(dir, outputPath)
+}
+
+// prettyExtensions are the files indented by Unpack, true for XHTML documents
+var prettyExtensions = map[string]bool{
+	".xhtml": true, ".html": true, ".htm": true, ".opf": false, ".ncx": false, ".xml": false,
+}
+
+// Unpack extracts an EPUB into dir, which must not exist or be empty, for editing; with
+// pretty set, its XHTML, OPF, NCX and XML files are indented
+func Unpack(epubPath, dir string, pretty bool) error {
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+		return fmt.Errorf("%s already exists and is not empty", dir)
+	}
+	if err := VerifyArchive(epubPath); err != nil {
+		return err
+	}
+
+	// Extract next to dir and move the result into place
+	parent := filepath.Dir(filepath.Clean(dir))
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	tempDir, err := os.MkdirTemp(parent, ".folian-unpack-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	extractedPath, err := NewProcessor().extractEPUB(epubPath, tempDir)
+	if err != nil {
+		return fmt.Errorf("failed to extract EPUB: %w", err)
+	}
+	if pretty {
+		if err := indentFiles(extractedPath); err != nil {
+			return err
+		}
+	}
+	os.Remove(dir)
+	if err := os.Rename(extractedPath, dir); err != nil {
+		return fmt.Errorf("failed to move the extracted files to %s: %w", dir, err)
+	}
+	return nil
+}
+
+// indentFiles indents the XML files of an extracted EPUB in place; files that do not
+// parse are left as they are, with a warning
+func indentFiles(dir string) error {
+	return filepath.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		xhtml, ok := prettyExtensions[strings.ToLower(filepath.Ext(filePath))]
+		if !ok {
+			return nil
+		}
+		data, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", filePath, err)
+		}
+		formatted, err := xmlfmt.Format(data, xhtml)
+		if err != nil {
+			rel, _ := filepath.Rel(dir, filePath)
+			policy.Warn(policy.KindOther, "Could not indent %s: %v", filepath.ToSlash(rel), err)
+			return nil
+		}
+		return ioutil.WriteFile(filePath, formatted, info.Mode())
+	})
+}
+
+// copyExploded copies an extracted EPUB directory into tempDir, so that processing
+// leaves it untouched, renaming its unsafe entries as extraction does
+func (p *Processor) copyExploded(dir, tempDir string) (string, error) {
+	extractPath := filepath.Join(tempDir, "extracted")
+	if err := copyTree(dir, extractPath); err != nil {
+		return "", fmt.Errorf("failed to copy %s: %w", dir, err)
+	}
+
+	segment := entrySegment()
+	if segment == nil {
+		return extractPath, nil
+	}
+	names, err := ExplodedEntries(extractPath)
+	if err != nil {
+		return "", err
+	}
+	renames := safeEntryNames(names, segment)
+	for original, renamed := range renames {
+		target := filepath.Join(extractPath, filepath.FromSlash(renamed))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return "", fmt.Errorf("failed to create directory: %w", err)
+		}
+		if err := os.Rename(filepath.Join(extractPath, filepath.FromSlash(original)), target); err != nil {
+			return "", fmt.Errorf("failed to rename %s: %w", original, err)
+		}
+	}
+	if err := renameEntries(extractPath, renames); err != nil {
+		return "", fmt.Errorf("failed to rewrite references to renamed entries: %w", err)
+	}
+	return extractPath, nil
+}
@@ -0,0 +1,65 @@
+package epub
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/flouciel/folian-parser/internal/parser"
+)
+
+// PreHook is a shell command run on the extracted EPUB before it is restructured;
+// a failing hook aborts processing
+var PreHook string
+
+// PostHook is a shell command run on the written output EPUB; a failing hook fails
+// the run, but the output is kept
+var PostHook string
+
+// runHook runs a hook command through the shell with the book's paths and metadata
+// exposed as FOLIAN_* environment variables
+func runHook(name, command string, book *parser.Book, env map[string]string) error {
+	if command == "" {
+		return nil
+	}
+
+	shell, flag := "sh", "-c"
+	if runtime.GOOS == "windows" {
+		shell, flag = "cmd", "/C"
+	}
+	cmd := exec.Command(shell, flag, command)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), hookEnvironment(book, env)...)
+
+	fmt.Printf("🪝 Running %s: %s\n", name, command)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s failed: %w", name, err)
+	}
+	return nil
+}
+
+// hookEnvironment lists the FOLIAN_* variables describing the book being processed
+func hookEnvironment(book *parser.Book, env map[string]string) []string {
+	variables := map[string]string{
+		"FOLIAN_TITLE":      book.Metadata.Title,
+		"FOLIAN_AUTHOR":     book.Metadata.Creator,
+		"FOLIAN_LANGUAGE":   book.Metadata.Language,
+		"FOLIAN_IDENTIFIER": book.Metadata.Identifier,
+		"FOLIAN_PUBLISHER":  book.Metadata.Publisher,
+		"FOLIAN_DATE":       book.Metadata.Date,
+		"FOLIAN_SUBJECTS":   strings.Join(book.Metadata.Subjects, ", "),
+		"FOLIAN_SERIES":     book.Metadata.Series,
+	}
+	for name, value := range env {
+		variables[name] = value
+	}
+
+	var environment []string
+	for name, value := range variables {
+		environment = append(environment, name+"="+value)
+	}
+	return environment
+}
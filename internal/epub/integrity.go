@@ -0,0 +1,108 @@
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// zipLocalHeader starts every zip archive and entry
+var zipLocalHeader = []byte("PK\x03\x04")
+
+// CorruptEntry is an entry of an archive that cannot be read back intact
+type CorruptEntry struct {
+	Name   string
+	Reason string
+}
+
+// IntegrityError lists the corrupt entries of an input archive
+type IntegrityError struct {
+	Path    string
+	Entries []CorruptEntry
+}
+
+func (e *IntegrityError) Error() string {
+	var parts []string
+	for _, entry := range e.Entries {
+		parts = append(parts, fmt.Sprintf("%s (%s)", entry.Name, entry.Reason))
+	}
+	return fmt.Sprintf("%s is corrupt: %d damaged entries: %s", e.Path, len(e.Entries), strings.Join(parts, "; "))
+}
+
+// VerifyArchive checks that an EPUB archive is complete and that every entry reads
+// back with its recorded size and CRC-32, naming the corrupt entries; a truncated
+// archive whose central directory is lost is reported as such
+func VerifyArchive(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to open EPUB file: %w", err)
+	}
+
+	reader, err := zip.OpenReader(path)
+	if err != nil {
+		if errors.Is(err, zip.ErrFormat) {
+			return describeUnreadableArchive(path, err)
+		}
+		return fmt.Errorf("failed to open EPUB file: %w", err)
+	}
+	defer reader.Close()
+
+	var corrupt []CorruptEntry
+	for _, file := range reader.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+		if offset, err := file.DataOffset(); err == nil && offset+int64(file.CompressedSize64) > info.Size() {
+			corrupt = append(corrupt, CorruptEntry{file.Name, fmt.Sprintf("truncated: data ends %d bytes past the end of the file", offset+int64(file.CompressedSize64)-info.Size())})
+			continue
+		}
+		if reason := verifyEntry(file); reason != "" {
+			corrupt = append(corrupt, CorruptEntry{file.Name, reason})
+		}
+	}
+	if len(corrupt) > 0 {
+		return &IntegrityError{Path: path, Entries: corrupt}
+	}
+	return nil
+}
+
+// verifyEntry reads an entry through, returning why it is corrupt or ""
+func verifyEntry(file *zip.File) string {
+	src, err := file.Open()
+	if err != nil {
+		return err.Error()
+	}
+	defer src.Close()
+
+	n, err := io.Copy(io.Discard, src)
+	switch {
+	case errors.Is(err, zip.ErrChecksum):
+		return "CRC-32 mismatch"
+	case err == io.ErrUnexpectedEOF:
+		return fmt.Sprintf("truncated after %d of %d bytes", n, file.UncompressedSize64)
+	case err != nil:
+		return "unreadable data: " + err.Error()
+	case uint64(n) != file.UncompressedSize64:
+		return fmt.Sprintf("size mismatch: %d bytes instead of %d", n, file.UncompressedSize64)
+	}
+	return ""
+}
+
+// describeUnreadableArchive explains why an archive has no readable central
+// directory: not a zip at all, or a zip cut short
+func describeUnreadableArchive(path string, err error) error {
+	head := make([]byte, len(zipLocalHeader))
+	file, openErr := os.Open(path)
+	if openErr != nil {
+		return fmt.Errorf("failed to open EPUB file: %w", err)
+	}
+	defer file.Close()
+	if _, readErr := io.ReadFull(file, head); readErr != nil || !bytes.Equal(head, zipLocalHeader) {
+		return fmt.Errorf("%s is not a zip archive: %w", path, err)
+	}
+	return fmt.Errorf("%s is truncated or damaged: its central directory is missing (the download or copy may be incomplete): %w", path, err)
+}
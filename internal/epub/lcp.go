@@ -0,0 +1,244 @@
+package epub
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/flouciel/folian-parser/internal/restructure"
+)
+
+// LCP encrypts the output with a Readium LCP content key, writing the key and the
+// details a license server needs next to the output
+var LCP bool
+
+// lcpEncryptedContentKey is the retrieval type of the content key held by the
+// license document
+const lcpEncryptedContentKey = "http://readium.org/2014/01/lcp#EncryptedContentKey"
+
+// coverImagePattern matches the href of the cover-image manifest item of the OPF
+var coverImagePattern = regexp.MustCompile(`<item[^>]*href="([^"]+)"[^>]*properties="[^"]*cover-image[^"]*"|<item[^>]*properties="[^"]*cover-image[^"]*"[^>]*href="([^"]+)"`)
+
+// lcpProtection records the content key of an encrypted publication and the
+// resources it encrypts
+type lcpProtection struct {
+	contentID  string
+	contentKey []byte
+	encrypted  map[string]bool
+}
+
+// lcpLicenseInfo is the sidecar handed to the license server, in the format of the
+// Readium lcpencrypt notification
+type lcpLicenseInfo struct {
+	ContentID           string `json:"content-id"`
+	ContentKey          string `json:"content-encryption-key"`
+	Location            string `json:"protected-content-location"`
+	Length              int64  `json:"protected-content-length"`
+	SHA256              string `json:"protected-content-sha256"`
+	Disposition         string `json:"protected-content-disposition"`
+	ContentType         string `json:"protected-content-type"`
+	EncryptionAlgorithm string `json:"content-encryption-algorithm"`
+}
+
+// lcpExempt reports whether a resource is left in clear: the mimetype, META-INF,
+// the package document and the cover image, which libraries show before licensing
+func lcpExempt(relPath, coverPath string) bool {
+	return relPath == "mimetype" ||
+		strings.HasPrefix(relPath, "META-INF/") ||
+		strings.HasSuffix(relPath, ".opf") ||
+		relPath == coverPath
+}
+
+// lcpCoverPath returns the path of the cover image declared by the package document
+func lcpCoverPath(contentPath string) string {
+	opf, err := ioutil.ReadFile(filepath.Join(contentPath, "OEBPS", "content.opf"))
+	if err != nil {
+		return ""
+	}
+	match := coverImagePattern.FindStringSubmatch(string(opf))
+	if match == nil {
+		return ""
+	}
+	href := match[1]
+	if href == "" {
+		href = match[2]
+	}
+	return "OEBPS/" + href
+}
+
+// lcpCompressedTypes are the extensions of resources already compressed, which are
+// encrypted without deflating them first
+var lcpCompressedTypes = map[string]bool{
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".avif": true,
+	".mp3": true, ".m4a": true, ".mp4": true, ".woff": true, ".woff2": true,
+}
+
+// encryptLCPResource optionally deflates a resource, then encrypts it with
+// AES-256-CBC under a random IV written before the ciphertext, as LCP requires
+func encryptLCPResource(key, content []byte, deflate bool) ([]byte, error) {
+	var compressed bytes.Buffer
+	if deflate {
+		writer, err := flate.NewWriter(&compressed, flate.BestCompression)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := writer.Write(content); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+	} else {
+		compressed.Write(content)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	padding := aes.BlockSize - compressed.Len()%aes.BlockSize
+	plaintext := append(compressed.Bytes(), bytes.Repeat([]byte{byte(padding)}, padding)...)
+
+	encrypted := make([]byte, aes.BlockSize+len(plaintext))
+	iv := encrypted[:aes.BlockSize]
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return nil, err
+	}
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted[aes.BlockSize:], plaintext)
+	return encrypted, nil
+}
+
+// compressionMethod returns the ZIP method number of a resource: 8 when deflated
+func compressionMethod(deflate bool) int {
+	if deflate {
+		return 8
+	}
+	return 0
+}
+
+// protectLCP encrypts the resources of a restructured EPUB in place with a new
+// content key and writes META-INF/encryption.xml referencing the license; the
+// content ID is the book identifier without its URN prefix
+func protectLCP(contentPath, identifier string) (*lcpProtection, error) {
+	protection := &lcpProtection{contentKey: make([]byte, 32), encrypted: make(map[string]bool)}
+	if _, err := io.ReadFull(rand.Reader, protection.contentKey); err != nil {
+		return nil, fmt.Errorf("failed to generate content key: %w", err)
+	}
+	protection.contentID = strings.TrimPrefix(identifier, "urn:uuid:")
+	if protection.contentID == "" {
+		id := make([]byte, 16)
+		if _, err := io.ReadFull(rand.Reader, id); err != nil {
+			return nil, fmt.Errorf("failed to generate content ID: %w", err)
+		}
+		protection.contentID = hex.EncodeToString(id)
+	}
+	coverPath := lcpCoverPath(contentPath)
+
+	var entries strings.Builder
+	err := filepath.Walk(contentPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		relPath, err := filepath.Rel(contentPath, path)
+		if err != nil {
+			return err
+		}
+		relPath = filepath.ToSlash(relPath)
+		if lcpExempt(relPath, coverPath) {
+			return nil
+		}
+
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", relPath, err)
+		}
+		deflate := !lcpCompressedTypes[strings.ToLower(filepath.Ext(relPath))]
+		encrypted, err := encryptLCPResource(protection.contentKey, content, deflate)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt %s: %w", relPath, err)
+		}
+		if err := ioutil.WriteFile(path, encrypted, 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", relPath, err)
+		}
+		protection.encrypted[relPath] = true
+
+		fmt.Fprintf(&entries, `  <enc:EncryptedData>
+    <enc:EncryptionMethod Algorithm="http://www.w3.org/2001/04/xmlenc#aes256-cbc"/>
+    <ds:KeyInfo>
+      <ds:RetrievalMethod URI="license.lcpl#/encryption/content_key" Type="%s"/>
+    </ds:KeyInfo>
+    <enc:CipherData>
+      <enc:CipherReference URI="%s"/>
+    </enc:CipherData>
+    <enc:EncryptionProperties>
+      <enc:EncryptionProperty xmlns:ns="http://www.idpf.org/2016/encryption#compression">
+        <ns:Compression Method="%d" OriginalLength="%d"/>
+      </enc:EncryptionProperty>
+    </enc:EncryptionProperties>
+  </enc:EncryptedData>
+`, lcpEncryptedContentKey, html.EscapeString(relPath), compressionMethod(deflate), len(content))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	encryptionXML := `<?xml version="1.0" encoding="UTF-8"?>
+<encryption xmlns="urn:oasis:names:tc:opendocument:xmlns:container" xmlns:enc="http://www.w3.org/2001/04/xmlenc#" xmlns:ds="http://www.w3.org/2000/09/xmldsig#">
+` + entries.String() + `</encryption>
+`
+	if err := ioutil.WriteFile(filepath.Join(contentPath, "META-INF", "encryption.xml"), []byte(encryptionXML), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write encryption.xml: %w", err)
+	}
+
+	if restructure.DebugMode {
+		fmt.Printf("🔐 Encrypted %d resources for LCP (content ID %s)\n", len(protection.encrypted), protection.contentID)
+	}
+	return protection, nil
+}
+
+// writeLCPLicenseInfo writes the content key and the details of the protected EPUB
+// to its path with .lcp.json appended, e.g. book.epub.lcp.json, from which a license server issues META-INF/license.lcpl
+func writeLCPLicenseInfo(protection *lcpProtection, outputPath string) error {
+	data, err := ioutil.ReadFile(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to read protected EPUB: %w", err)
+	}
+	sum := sha256.Sum256(data)
+
+	info := lcpLicenseInfo{
+		ContentID:           protection.contentID,
+		ContentKey:          base64.StdEncoding.EncodeToString(protection.contentKey),
+		Location:            outputPath,
+		Length:              int64(len(data)),
+		SHA256:              hex.EncodeToString(sum[:]),
+		Disposition:         filepath.Base(outputPath),
+		ContentType:         "application/epub+zip",
+		EncryptionAlgorithm: "http://www.w3.org/2001/04/xmlenc#aes256-cbc",
+	}
+	sidecar, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal LCP license info: %w", err)
+	}
+	infoPath := outputPath + ".lcp.json"
+	if err := ioutil.WriteFile(infoPath, append(sidecar, '\n'), 0600); err != nil {
+		return fmt.Errorf("failed to write LCP license info: %w", err)
+	}
+
+	fmt.Printf("🔐 LCP content key and license details written to %s; keep it private and import it into the license server\n", infoPath)
+	return nil
+}
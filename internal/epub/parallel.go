@@ -0,0 +1,258 @@
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+	"unicode/utf8"
+)
+
+// Workers is the number of entries extracted or compressed at the same time
+var Workers = runtime.NumCPU()
+
+// maxEntrySize limits the size of extracted files to prevent zip bombs
+const maxEntrySize = 100 * 1024 * 1024 // 100MB limit per file
+
+// zipVersion20 is the version needed to extract deflated and stored entries
+const zipVersion20 = 20
+
+// forEach calls fn with 0 to n-1 on Workers goroutines and returns the first error
+func forEach(n int, fn func(int) error) error {
+	workers := Workers
+	if workers > n {
+		workers = n
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var first error
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				if err := fn(i); err != nil {
+					mu.Lock()
+					if first == nil {
+						first = err
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return first
+}
+
+// extractJob is an archive entry and the path it is extracted to
+type extractJob struct {
+	file *zip.File
+	path string
+}
+
+// extractFiles extracts archive entries in parallel; their directories must exist
+func extractFiles(jobs []extractJob) error {
+	return forEach(len(jobs), func(i int) error {
+		return extractFile(jobs[i].file, jobs[i].path)
+	})
+}
+
+// extractFile extracts one archive entry, refusing files over maxEntrySize
+func extractFile(file *zip.File, path string) error {
+	srcFile, err := file.Open()
+	if err != nil {
+		return fmt.Errorf("failed to open file in archive: %w", err)
+	}
+	defer srcFile.Close()
+
+	dstFile, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+
+	_, err = io.CopyN(dstFile, srcFile, maxEntrySize)
+	if closeErr := dstFile.Close(); err == nil || err == io.EOF {
+		err = closeErr
+	}
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("failed to extract file or file too large: %w", err)
+	}
+	return nil
+}
+
+// compressors reuses the deflate writers, which are costly to allocate
+var compressors = sync.Pool{New: func() interface{} {
+	compressor, _ := flate.NewWriter(nil, flate.DefaultCompression)
+	return compressor
+}}
+
+// packJob is a file to add to the output archive under name
+type packJob struct {
+	path   string
+	name   string
+	method uint16
+	// candidates are the input entries the file may be an unchanged copy of
+	candidates []*zip.File
+}
+
+// packedEntry is a file compressed for the output archive, or the input entry it
+// is copied from
+type packedEntry struct {
+	header *zip.FileHeader
+	data   []byte
+	raw    *zip.File
+	err    error
+}
+
+// packFiles compresses files on Workers goroutines and writes them to the archive
+// in order, with at most Workers compressed files held in memory; a single worker
+// streams them instead
+func packFiles(zipWriter *zip.Writer, jobs []packJob) error {
+	if Workers <= 1 {
+		for _, job := range jobs {
+			if err := streamFile(zipWriter, job); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	results := make([]chan packedEntry, len(jobs))
+	for i := range results {
+		results[i] = make(chan packedEntry, 1)
+	}
+
+	// Compress ahead of the writer, stopping when it gives up
+	slots := make(chan struct{}, max(Workers, 1))
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for i, job := range jobs {
+			select {
+			case slots <- struct{}{}:
+			case <-done:
+				return
+			}
+			go func(i int, job packJob) {
+				results[i] <- packFile(job)
+			}(i, job)
+		}
+	}()
+
+	for i := range jobs {
+		entry := <-results[i]
+		<-slots
+		if entry.err != nil {
+			return entry.err
+		}
+		if entry.raw != nil {
+			if err := copyUnchanged(zipWriter, entry.raw, entry.header.Name); err != nil {
+				return err
+			}
+			continue
+		}
+		writer, err := zipWriter.CreateRaw(entry.header)
+		if err != nil {
+			return fmt.Errorf("failed to create ZIP entry: %w", err)
+		}
+		if _, err := writer.Write(entry.data); err != nil {
+			return fmt.Errorf("failed to write file to ZIP: %w", err)
+		}
+	}
+	return nil
+}
+
+// streamFile compresses a file into the archive as it is read
+func streamFile(zipWriter *zip.Writer, job packJob) error {
+	if len(job.candidates) > 0 {
+		crc, err := fileCRC(job.path)
+		if err != nil {
+			return err
+		}
+		if raw := matchRawSource(crc, job.candidates); raw != nil {
+			return copyUnchanged(zipWriter, raw, job.name)
+		}
+	}
+
+	writer, err := zipWriter.CreateHeader(&zip.FileHeader{Name: job.name, Method: job.method})
+	if err != nil {
+		return fmt.Errorf("failed to create ZIP entry: %w", err)
+	}
+	file, err := os.Open(job.path)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+	if _, err := io.Copy(writer, file); err != nil {
+		return fmt.Errorf("failed to write file to ZIP: %w", err)
+	}
+	return nil
+}
+
+// packFile reads and compresses a file into an entry that is written as is
+func packFile(job packJob) packedEntry {
+	content, err := os.ReadFile(job.path)
+	if err != nil {
+		return packedEntry{err: fmt.Errorf("failed to open file: %w", err)}
+	}
+
+	crc := crc32.ChecksumIEEE(content)
+	if raw := matchRawSource(crc, job.candidates); raw != nil {
+		return packedEntry{header: &zip.FileHeader{Name: job.name}, raw: raw}
+	}
+
+	header := &zip.FileHeader{
+		Name:               job.name,
+		Method:             job.method,
+		CreatorVersion:     zipVersion20,
+		ReaderVersion:      zipVersion20,
+		CRC32:              crc,
+		UncompressedSize64: uint64(len(content)),
+	}
+	// Mark names that are not ASCII as UTF-8, as zip.Writer.CreateHeader does
+	if utf8.ValidString(job.name) && !isASCII(job.name) {
+		header.Flags |= 0x800
+	}
+
+	data := content
+	if job.method == zip.Deflate {
+		var buf bytes.Buffer
+		compressor := compressors.Get().(*flate.Writer)
+		defer compressors.Put(compressor)
+		compressor.Reset(&buf)
+		if _, err := compressor.Write(content); err != nil {
+			return packedEntry{err: fmt.Errorf("failed to compress %s: %w", job.name, err)}
+		}
+		if err := compressor.Close(); err != nil {
+			return packedEntry{err: fmt.Errorf("failed to compress %s: %w", job.name, err)}
+		}
+		data = buf.Bytes()
+	}
+	header.CompressedSize64 = uint64(len(data))
+	return packedEntry{header: header, data: data}
+}
+
+// isASCII reports whether s holds only ASCII characters
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
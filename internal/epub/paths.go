@@ -0,0 +1,272 @@
+package epub
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"unicode"
+
+	"github.com/flouciel/folian-parser/internal/restructure"
+)
+
+// WindowsSafeNames renames extracted entries that Windows cannot create, such as
+// COM1.xhtml or names ending in a dot, and rewrites the references to them; it is
+// always on when running on Windows
+var WindowsSafeNames = runtime.GOOS == "windows"
+
+// KeepFilenames keeps the entry names of the source EPUB; by default entries whose
+// names are not URL-safe ASCII (spaces, '#', '%', accented or other non-ASCII
+// letters) are renamed and the references to them rewritten
+var KeepFilenames bool
+
+// asciiFolds maps accented Latin letters, Vietnamese included, to their ASCII base
+var asciiFolds = func() map[rune]rune {
+	groups := map[rune]string{
+		'a': "àáảãạăằắẳẵặâầấẩẫậäåāą",
+		'c': "çćč",
+		'd': "đď",
+		'e': "èéẻẽẹêềếểễệëēęě",
+		'g': "ğ",
+		'i': "ìíỉĩịîïī",
+		'l': "ł",
+		'n': "ñńň",
+		'o': "òóỏõọôồốổỗộơờớởỡợöøō",
+		'r': "ř",
+		's': "śšş",
+		't': "ť",
+		'u': "ùúủũụưừứửữựûüūů",
+		'y': "ỳýỷỹỵÿ",
+		'z': "źżž",
+	}
+	folds := make(map[rune]rune)
+	for base, letters := range groups {
+		for _, letter := range letters {
+			folds[letter] = base
+			folds[unicode.ToUpper(letter)] = unicode.ToUpper(base)
+		}
+	}
+	return folds
+}()
+
+// urlUnsafeChars matches the runs of characters that are not URL-safe in filenames
+var urlUnsafeChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// urlSafeSegment returns a path segment of URL-safe ASCII: accented letters lose
+// their accents and other characters become underscores
+func urlSafeSegment(segment string) string {
+	folded := strings.Map(func(r rune) rune {
+		if base, ok := asciiFolds[r]; ok {
+			return base
+		}
+		return r
+	}, segment)
+	safe := urlUnsafeChars.ReplaceAllString(folded, "_")
+
+	ext := path.Ext(safe)
+	stem := strings.Trim(strings.TrimSuffix(safe, ext), "_")
+	if stem == "" {
+		stem = "item"
+	}
+	return stem + ext
+}
+
+// entrySegment returns the function renaming the path segments of extracted
+// entries, or nil when entries keep their names
+func entrySegment() func(string) string {
+	switch {
+	case !KeepFilenames && WindowsSafeNames:
+		return func(segment string) string { return windowsSafeSegment(urlSafeSegment(segment)) }
+	case !KeepFilenames:
+		return urlSafeSegment
+	case WindowsSafeNames:
+		return windowsSafeSegment
+	}
+	return nil
+}
+
+// windowsReservedNames are the device names Windows reserves, with any extension
+var windowsReservedNames = map[string]bool{
+	"con": true, "prn": true, "aux": true, "nul": true,
+	"com1": true, "com2": true, "com3": true, "com4": true, "com5": true, "com6": true, "com7": true, "com8": true, "com9": true,
+	"lpt1": true, "lpt2": true, "lpt3": true, "lpt4": true, "lpt5": true, "lpt6": true, "lpt7": true, "lpt8": true, "lpt9": true,
+}
+
+// windowsInvalidChars matches the characters Windows does not allow in filenames
+var windowsInvalidChars = regexp.MustCompile(`[<>:"|?*\x00-\x1f]`)
+
+// referencePattern matches the attributes and CSS url() values that reference other
+// entries of the book
+var referencePattern = regexp.MustCompile(`((?:\s(?:href|src|xlink:href|full-path|poster|data))\s*=\s*["'])([^"']*)(["'])|(url\(\s*["']?)([^"')]+)(["']?\s*\))`)
+
+// referencingExtensions are the files whose references are rewritten after renames
+var referencingExtensions = map[string]bool{
+	".opf": true, ".ncx": true, ".xhtml": true, ".html": true, ".htm": true, ".xml": true, ".css": true, ".svg": true, ".smil": true,
+}
+
+// entryName returns a zip entry name with forward slashes, as archives written on
+// Windows sometimes use backslashes
+func entryName(name string) string {
+	return strings.ReplaceAll(name, "\\", "/")
+}
+
+// windowsSafeSegment returns a path segment Windows can create: invalid characters
+// become underscores, trailing dots and spaces are dropped, and reserved device
+// names get an underscore prefix
+func windowsSafeSegment(segment string) string {
+	segment = windowsInvalidChars.ReplaceAllString(segment, "_")
+	segment = strings.TrimRight(segment, ". ")
+	if segment == "" {
+		return "_"
+	}
+	base := strings.ToLower(segment)
+	if i := strings.Index(base, "."); i >= 0 {
+		base = base[:i]
+	}
+	if windowsReservedNames[strings.TrimSpace(base)] {
+		segment = "_" + segment
+	}
+	return segment
+}
+
+// safeEntryNames maps the entry names of an archive that must be renamed to unique
+// names; Windows compares names without case, so neither do the collision checks
+func safeEntryNames(names []string, safeSegment func(string) string) map[string]string {
+	used := make(map[string]bool)
+	for _, name := range names {
+		used[strings.ToLower(name)] = true
+	}
+
+	renames := make(map[string]string)
+	for _, name := range names {
+		segments := strings.Split(name, "/")
+		for i, segment := range segments {
+			if segment != "" {
+				segments[i] = safeSegment(segment)
+			}
+		}
+		safe := strings.Join(segments, "/")
+		if safe == name {
+			continue
+		}
+
+		// Number the renamed entry when its new name is taken
+		ext := path.Ext(safe)
+		stem := strings.TrimSuffix(safe, ext)
+		for n := 2; used[strings.ToLower(safe)]; n++ {
+			safe = fmt.Sprintf("%s-%d%s", stem, n, ext)
+		}
+		used[strings.ToLower(safe)] = true
+		renames[name] = safe
+	}
+	return renames
+}
+
+// rewriteReferences points the references of the extracted text files at renamed
+// entries; references are resolved against the referencing file and may be
+// percent-encoded
+func rewriteReferences(extractPath string, renames map[string]string) error {
+	if len(renames) == 0 {
+		return nil
+	}
+
+	return filepath.Walk(extractPath, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !referencingExtensions[strings.ToLower(filepath.Ext(filePath))] {
+			return err
+		}
+		rel, err := filepath.Rel(extractPath, filePath)
+		if err != nil {
+			return err
+		}
+		dir := path.Dir(filepath.ToSlash(rel))
+		if filepath.Base(filePath) == "container.xml" {
+			// The rootfile full-path is relative to the root of the container
+			dir = "."
+		}
+
+		data, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", rel, err)
+		}
+		content := referencePattern.ReplaceAllStringFunc(string(data), func(match string) string {
+			parts := referencePattern.FindStringSubmatch(match)
+			prefix, ref, suffix := parts[1], parts[2], parts[3]
+			if prefix == "" {
+				prefix, ref, suffix = parts[4], parts[5], parts[6]
+			}
+			if renamed, ok := renamedReference(dir, ref, renames); ok {
+				return prefix + renamed + suffix
+			}
+			return match
+		})
+		if content == string(data) {
+			return nil
+		}
+		return ioutil.WriteFile(filePath, []byte(content), info.Mode())
+	})
+}
+
+// renamedReference returns a reference from the files in dir rewritten to the new
+// name of the entry it points at, keeping its fragment
+func renamedReference(dir, ref string, renames map[string]string) (string, bool) {
+	if ref == "" || strings.HasPrefix(ref, "#") || strings.Contains(ref, ":") {
+		return "", false
+	}
+	file, fragment := ref, ""
+	if i := strings.Index(ref, "#"); i >= 0 {
+		file, fragment = ref[:i], ref[i:]
+	}
+	decoded, err := url.PathUnescape(file)
+	if err != nil {
+		decoded = file
+	}
+	target := path.Join(dir, entryName(decoded))
+	renamed, ok := renames[target]
+	if !ok {
+		return "", false
+	}
+
+	// Keep the reference relative to the referencing file
+	relative := renamed
+	if dir != "." {
+		relative = relativePath(dir, renamed)
+	}
+	return (&url.URL{Path: relative}).EscapedPath() + fragment, true
+}
+
+// relativePath returns the slash-separated path of target relative to dir
+func relativePath(dir, target string) string {
+	rel, err := filepath.Rel(filepath.FromSlash(dir), filepath.FromSlash(target))
+	if err != nil {
+		return target
+	}
+	return filepath.ToSlash(rel)
+}
+
+// renameEntries applies the entry renames of an extraction to the files it
+// references, reporting them in debug mode
+func renameEntries(extractPath string, renames map[string]string) error {
+	if len(renames) == 0 {
+		return nil
+	}
+	if restructure.DebugMode {
+		for original, renamed := range renames {
+			fmt.Printf("🏷️  Renamed entry: %s -> %s\n", original, renamed)
+		}
+	}
+	return rewriteReferences(extractPath, renames)
+}
+
+// longPath returns the absolute form of a path; Go applies the Windows long-path
+// prefix to absolute paths only, so output paths past MAX_PATH need it
+func longPath(p string) string {
+	if abs, err := filepath.Abs(p); err == nil {
+		return abs
+	}
+	return p
+}
@@ -2,20 +2,29 @@ package epub
 
 import (
 	"archive/zip"
+	"bufio"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
 	"strings"
 
 	"github.com/flouciel/folian-parser/internal/parser"
+	"github.com/flouciel/folian-parser/internal/policy"
 	"github.com/flouciel/folian-parser/internal/restructure"
+	"github.com/flouciel/folian-parser/internal/timing"
 )
 
 // Processor handles the EPUB processing workflow
 type Processor struct {
 	parser      *parser.EPUBParser
 	restructure *restructure.Restructurer
+	book        *parser.Book
+	// lcp holds the content key of an output encrypted for LCP
+	lcp *lcpProtection
+	// source is the input archive unchanged resources are copied from
+	source string
+	// stages transform the parsed book before it is restructured
+	stages []Stage
 }
 
 // NewProcessor creates a new EPUB processor
@@ -26,40 +35,234 @@ func NewProcessor() *Processor {
 	}
 }
 
-// Process takes an input EPUB file, restructures it, and saves it to the output path
+// Process takes an input EPUB file, restructures it, and saves it to the output path;
+// errors are tagged with ErrValidation, ErrDRM, ErrParse or ErrIO
 func (p *Processor) Process(inputPath, outputPath string) error {
+	p.book = nil
+
+	// Unchanged resources are copied from the input archive, which a repaired or
+	// extracted book does not have
+	p.source = ""
+	if info, err := os.Stat(inputPath); RawCopy && !Repair && err == nil && !info.IsDir() {
+		p.source = inputPath
+	}
+
 	// Create a temporary directory for extraction
 	tempDir, err := os.MkdirTemp("", "epub-restructure-*")
 	if err != nil {
-		return fmt.Errorf("failed to create temp directory: %w", err)
+		return withKind(ErrIO, fmt.Errorf("failed to create temp directory: %w", err))
 	}
 	defer os.RemoveAll(tempDir)
 
-	// Extract the EPUB file
-	extractedPath, err := p.extractEPUB(inputPath, tempDir)
+	// Extract and parse the EPUB file
+	extractedPath, book, err := p.extractAndParse(inputPath, tempDir, true)
 	if err != nil {
-		return fmt.Errorf("failed to extract EPUB: %w", err)
+		return err
 	}
+	p.book = book
 
-	// Parse the EPUB content
-	book, err := p.parser.Parse(extractedPath)
-	if err != nil {
-		return fmt.Errorf("failed to parse EPUB: %w", err)
+	// Run the pre-processing hook, parsing again to pick up its changes
+	if PreHook != "" {
+		env := map[string]string{"FOLIAN_INPUT": inputPath, "FOLIAN_OUTPUT": outputPath, "FOLIAN_EXTRACTED_DIR": extractedPath}
+		if err := runHook("pre-hook", PreHook, book, env); err != nil {
+			return withKind(ErrValidation, err)
+		}
+		stopParse := timing.Start(timing.StageParse)
+		book, err = p.parser.Parse(extractedPath)
+		stopParse()
+		if err != nil {
+			return withKind(ErrParse, fmt.Errorf("failed to parse EPUB after pre-hook: %w", err))
+		}
+		p.book = book
+	}
+
+	// Run the transformation stages of embedders on the parsed book
+	if book, err = p.runStages(book); err != nil {
+		return err
 	}
+	p.book = book
 
-	// Restructure the EPUB
+	// Restructure the EPUB; its stages are measured by the restructurer
 	restructuredPath, err := p.restructure.Restructure(book, tempDir)
 	if err != nil {
-		return fmt.Errorf("failed to restructure EPUB: %w", err)
+		return withKind(ErrIO, fmt.Errorf("failed to restructure EPUB: %w", err))
+	}
+
+	// Encrypt the resources for Readium LCP
+	p.lcp = nil
+	if LCP {
+		if p.lcp, err = protectLCP(restructuredPath, book.Metadata.Identifier); err != nil {
+			return withKind(ErrIO, fmt.Errorf("failed to encrypt EPUB for LCP: %w", err))
+		}
 	}
 
 	// Create the new EPUB file
+	stopPackage := timing.Start(timing.StagePackage)
 	err = p.createEPUB(restructuredPath, outputPath)
+	stopPackage()
 	if err != nil {
-		return fmt.Errorf("failed to create output EPUB: %w", err)
+		return withKind(ErrIO, fmt.Errorf("failed to create output EPUB: %w", err))
+	}
+	if p.lcp != nil {
+		if err := writeLCPLicenseInfo(p.lcp, outputPath); err != nil {
+			return withKind(ErrIO, err)
+		}
 	}
 
-	return nil
+	// Write the ONIX product record next to the output
+	if restructure.ONIXExport {
+		if err := restructure.WriteONIX(book, outputPath, p.lcp != nil); err != nil {
+			return withKind(ErrIO, err)
+		}
+	}
+
+	// Run the post-processing hook on the written EPUB
+	env := map[string]string{"FOLIAN_INPUT": inputPath, "FOLIAN_OUTPUT": outputPath}
+	return withKind(ErrValidation, runHook("post-hook", PostHook, book, env))
+}
+
+// Book returns the parsed metadata of the last processed EPUB, or nil when it could
+// not be parsed
+func (p *Processor) Book() *parser.Book {
+	return p.book
+}
+
+// Mapping returns where the original files and fragments of the last processed
+// EPUB ended up in the restructured output
+func (p *Processor) Mapping() []restructure.MappingEntry {
+	return p.restructure.Mapping()
+}
+
+// Load extracts an EPUB file into tempDir and parses it without restructuring; a
+// cached book is read in place
+func (p *Processor) Load(inputPath, tempDir string) (*parser.Book, error) {
+	_, book, err := p.extractAndParse(inputPath, tempDir, false)
+	return book, err
+}
+
+// Build extracts, parses and restructures an EPUB into tempDir without packaging
+// it, and returns the restructured directory; stages run, hooks and encryption do not
+func (p *Processor) Build(inputPath, tempDir string) (string, error) {
+	p.book = nil
+	_, book, err := p.extractAndParse(inputPath, tempDir, true)
+	if err != nil {
+		return "", err
+	}
+	p.book = book
+	if book, err = p.runStages(book); err != nil {
+		return "", err
+	}
+	p.book = book
+
+	restructuredPath, err := p.restructure.Restructure(book, tempDir)
+	if err != nil {
+		return "", withKind(ErrIO, fmt.Errorf("failed to restructure EPUB: %w", err))
+	}
+	return restructuredPath, nil
+}
+
+// extractAndParse extracts and parses an EPUB, reusing the cached model of an
+// identical file. For processing, cached files are copied into tempDir so that they
+// can be modified, and encrypted books are refused before parsing
+func (p *Processor) extractAndParse(inputPath, tempDir string, processing bool) (string, *parser.Book, error) {
+	// An extracted EPUB directory is parsed from a copy, and never cached since it
+	// may be edited between runs
+	if info, err := os.Stat(inputPath); err == nil && info.IsDir() {
+		stopExtract := timing.Start(timing.StageExtract)
+		extractedPath, err := p.copyExploded(inputPath, tempDir)
+		stopExtract()
+		if err != nil {
+			return "", nil, withKind(ErrIO, err)
+		}
+		if Repair {
+			if err := reconstructContainer(extractedPath, filepath.Base(inputPath)); err != nil {
+				return "", nil, withKind(ErrValidation, fmt.Errorf("failed to repair EPUB: %w", err))
+			}
+		}
+		return p.parseExtracted(extractedPath, processing, "")
+	}
+
+	entry := cacheEntry(inputPath)
+	if entry != "" {
+		stopParse := timing.Start(timing.StageParse)
+		book, err := loadCached(entry)
+		stopParse()
+		if err == nil {
+			if restructure.DebugMode {
+				fmt.Printf("🗃️  Using cached parse of %s\n", inputPath)
+			}
+			for _, warning := range book.Warnings {
+				policy.Warn(warning.Kind, "%s", warning.Message)
+			}
+			if !processing {
+				return book.Path, book, nil
+			}
+			extractedPath := filepath.Join(tempDir, "extracted")
+			stopExtract := timing.Start(timing.StageExtract)
+			err := copyTree(book.Path, extractedPath)
+			stopExtract()
+			if err != nil {
+				return "", nil, withKind(ErrIO, fmt.Errorf("failed to copy cached EPUB: %w", err))
+			}
+			if err := checkDRM(extractedPath); err != nil {
+				return "", nil, err
+			}
+			book.Path = extractedPath
+			return extractedPath, book, nil
+		}
+	}
+
+	// Verify the archive first, so that damaged entries are named instead of failing
+	// mid-extraction; in repair mode the intact entries are salvaged instead
+	var extractedPath string
+	stopExtract := timing.Start(timing.StageExtract)
+	if err := VerifyArchive(inputPath); err != nil {
+		if !Repair {
+			return "", nil, withKind(ErrValidation, err)
+		}
+		if extractedPath, err = p.repairEPUB(inputPath, tempDir); err != nil {
+			return "", nil, withKind(ErrValidation, fmt.Errorf("failed to repair EPUB: %w", err))
+		}
+	} else if extractedPath, err = p.extractEPUB(inputPath, tempDir); err != nil {
+		return "", nil, withKind(ErrIO, fmt.Errorf("failed to extract EPUB: %w", err))
+	}
+
+	// Rebuild the container files a damaged book lost
+	if Repair {
+		title := strings.TrimSuffix(filepath.Base(inputPath), filepath.Ext(inputPath))
+		if err := reconstructContainer(extractedPath, title); err != nil {
+			return "", nil, withKind(ErrValidation, fmt.Errorf("failed to repair EPUB: %w", err))
+		}
+	}
+	stopExtract()
+
+	return p.parseExtracted(extractedPath, processing, entry)
+}
+
+// parseExtracted parses an extracted EPUB, refusing encrypted books when processing,
+// and caches the result under entry when set
+func (p *Processor) parseExtracted(extractedPath string, processing bool, entry string) (string, *parser.Book, error) {
+	// Refuse encrypted books, whose content cannot be restructured
+	if processing {
+		if err := checkDRM(extractedPath); err != nil {
+			return "", nil, err
+		}
+	}
+
+	// Parse the EPUB content
+	stopParse := timing.Start(timing.StageParse)
+	book, err := p.parser.Parse(extractedPath)
+	stopParse()
+	if err != nil {
+		return "", nil, withKind(ErrParse, fmt.Errorf("failed to parse EPUB: %w", err))
+	}
+
+	if entry != "" {
+		if err := storeCached(entry, extractedPath, book); err != nil && restructure.DebugMode {
+			fmt.Printf("⚠️  Could not cache %s: %v\n", extractedPath, err)
+		}
+	}
+	return extractedPath, book, nil
 }
 
 // extractEPUB extracts the EPUB file to a temporary directory
@@ -77,10 +280,26 @@ func (p *Processor) extractEPUB(epubPath, tempDir string) (string, error) {
 		return "", fmt.Errorf("failed to create extraction directory: %w", err)
 	}
 
-	// Extract all files
+	// Rename the entries that are not URL-safe or that Windows cannot create
+	var renames map[string]string
+	if segment := entrySegment(); segment != nil {
+		var names []string
+		for _, file := range reader.File {
+			names = append(names, entryName(file.Name))
+		}
+		renames = safeEntryNames(names, segment)
+	}
+
+	// Create the directories, then extract the files in parallel
+	var jobs []extractJob
 	for _, file := range reader.File {
+		name := entryName(file.Name)
+		if renamed, ok := renames[name]; ok {
+			name = renamed
+		}
+
 		// Validate file path to prevent path traversal
-		filePath := filepath.Join(extractPath, file.Name)
+		filePath := filepath.Join(extractPath, filepath.FromSlash(name))
 		if !strings.HasPrefix(filePath, extractPath) {
 			return "", fmt.Errorf("invalid file path (potential path traversal attack): %s", file.Name)
 		}
@@ -98,33 +317,22 @@ func (p *Processor) extractEPUB(epubPath, tempDir string) (string, error) {
 			return "", fmt.Errorf("failed to create directory: %w", err)
 		}
 
-		// Extract the file
-		srcFile, err := file.Open()
-		if err != nil {
-			return "", fmt.Errorf("failed to open file in archive: %w", err)
-		}
-		defer srcFile.Close()
-
-		dstFile, err := os.Create(filePath)
-		if err != nil {
-			srcFile.Close()
-			return "", fmt.Errorf("failed to create file: %w", err)
-		}
-		defer dstFile.Close()
-
-		// Limit the size of extracted files to prevent zip bombs
-		const maxSize = 100 * 1024 * 1024 // 100MB limit per file
-		_, err = io.CopyN(dstFile, srcFile, maxSize)
-		if err != nil && err != io.EOF {
-			return "", fmt.Errorf("failed to extract file or file too large: %w", err)
-		}
+		jobs = append(jobs, extractJob{file: file, path: filePath})
+	}
+	if err := extractFiles(jobs); err != nil {
+		return "", err
 	}
 
+	if err := renameEntries(extractPath, renames); err != nil {
+		return "", fmt.Errorf("failed to rewrite references to renamed entries: %w", err)
+	}
 	return extractPath, nil
 }
 
 // createEPUB creates a new EPUB file from the restructured content
 func (p *Processor) createEPUB(contentPath, outputPath string) error {
+	outputPath = longPath(outputPath)
+
 	// Create the output directory if it doesn't exist
 	outputDir := filepath.Dir(outputPath)
 	if err := os.MkdirAll(outputDir, 0755); err != nil {
@@ -138,9 +346,21 @@ func (p *Processor) createEPUB(contentPath, outputPath string) error {
 	}
 	defer outputFile.Close()
 
-	// Create a new ZIP writer
-	zipWriter := zip.NewWriter(outputFile)
-	defer zipWriter.Close()
+	// Create a new ZIP writer, buffered since entries are written whole
+	buffered := bufio.NewWriter(outputFile)
+	zipWriter := zip.NewWriter(buffered)
+
+	// Index the input entries unchanged resources are copied from; encrypted
+	// resources never match them
+	var sources rawSources
+	if p.source != "" && p.lcp == nil {
+		reader, err := zip.OpenReader(p.source)
+		if err != nil {
+			return fmt.Errorf("failed to open input EPUB: %w", err)
+		}
+		defer reader.Close()
+		sources = indexRawSources(&reader.Reader)
+	}
 
 	// Add mimetype file first (must be uncompressed and first in the archive)
 	mimetypeWriter, err := zipWriter.CreateHeader(&zip.FileHeader{
@@ -155,14 +375,15 @@ func (p *Processor) createEPUB(contentPath, outputPath string) error {
 		return fmt.Errorf("failed to write mimetype: %w", err)
 	}
 
-	// Walk through the restructured content and add all files to the ZIP
+	// Walk through the restructured content and collect all files for the ZIP
+	var jobs []packJob
 	err = filepath.Walk(contentPath, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
 
-		// Skip directories
-		if info.IsDir() {
+		// Skip directories and the files operating systems leave in them
+		if info.IsDir() || packJunk[info.Name()] {
 			return nil
 		}
 
@@ -173,38 +394,37 @@ func (p *Processor) createEPUB(contentPath, outputPath string) error {
 		}
 
 		// Normalize path separators to forward slashes for EPUB
-		relPath = strings.ReplaceAll(relPath, "\\", "/")
+		relPath = filepath.ToSlash(relPath)
 
 		// Skip the mimetype file as we've already added it
 		if relPath == "mimetype" {
 			return nil
 		}
 
-		// Create a new file in the ZIP
-		writer, err := zipWriter.Create(relPath)
-		if err != nil {
-			return fmt.Errorf("failed to create ZIP entry: %w", err)
+		// Create a new file in the ZIP; encrypted resources do not compress and are
+		// stored as they are
+		method := zip.Deflate
+		if p.lcp != nil && p.lcp.encrypted[relPath] {
+			method = zip.Store
 		}
-
-		// Open the source file
-		file, err := os.Open(path)
-		if err != nil {
-			return fmt.Errorf("failed to open file: %w", err)
-		}
-		defer file.Close()
-
-		// Copy the file content to the ZIP
-		_, err = io.Copy(writer, file)
-		if err != nil {
-			return fmt.Errorf("failed to write file to ZIP: %w", err)
-		}
-
+		jobs = append(jobs, packJob{path: path, name: relPath, method: method, candidates: sources.candidates(relPath, info.Size())})
 		return nil
 	})
-
 	if err != nil {
 		return fmt.Errorf("failed to add files to EPUB: %w", err)
 	}
 
+	// Compress the files in parallel, writing them in walk order; unchanged
+	// resources are copied from the input instead
+	if err := packFiles(zipWriter, jobs); err != nil {
+		return fmt.Errorf("failed to add files to EPUB: %w", err)
+	}
+	if err := zipWriter.Close(); err != nil {
+		return fmt.Errorf("failed to finish EPUB: %w", err)
+	}
+	if err := buffered.Flush(); err != nil {
+		return fmt.Errorf("failed to write EPUB: %w", err)
+	}
+
 	return nil
 }
\ No newline at end of file
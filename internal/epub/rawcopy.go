@@ -0,0 +1,104 @@
+package epub
+
+import (
+	"archive/zip"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/flouciel/folian-parser/internal/restructure"
+)
+
+// RawCopy copies the images, fonts and media the output holds unchanged from the
+// input archive as they are, without decompressing and compressing them again
+var RawCopy = true
+
+// rawCopyTypes are the extensions of the binary resources copied as they are
+var rawCopyTypes = map[string]bool{
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".avif": true,
+	".ttf": true, ".otf": true, ".woff": true, ".woff2": true,
+	".mp3": true, ".m4a": true, ".aac": true, ".ogg": true, ".opus": true, ".mp4": true, ".webm": true,
+}
+
+// rawSources indexes the binary entries of an input archive by uncompressed size,
+// to find the entries an output file is an unchanged copy of
+type rawSources map[uint64][]*zip.File
+
+// indexRawSources indexes the binary entries of an archive
+func indexRawSources(reader *zip.Reader) rawSources {
+	sources := make(rawSources)
+	for _, file := range reader.File {
+		if file.FileInfo().IsDir() || !rawCopyTypes[strings.ToLower(path.Ext(file.Name))] {
+			continue
+		}
+		if file.Method != zip.Store && file.Method != zip.Deflate {
+			continue
+		}
+		sources[file.UncompressedSize64] = append(sources[file.UncompressedSize64], file)
+	}
+	return sources
+}
+
+// candidates returns the entries an output file of the given name and size may be
+// an unchanged copy of
+func (s rawSources) candidates(name string, size int64) []*zip.File {
+	if s == nil || size < 0 || !rawCopyTypes[strings.ToLower(path.Ext(name))] {
+		return nil
+	}
+	return s[uint64(size)]
+}
+
+// matchRawSource returns the candidate entry whose CRC-32 matches the content, or
+// nil when the content was changed
+func matchRawSource(crc uint32, candidates []*zip.File) *zip.File {
+	for _, file := range candidates {
+		if file.CRC32 == crc {
+			return file
+		}
+	}
+	return nil
+}
+
+// fileCRC returns the CRC-32 of a file
+func fileCRC(filePath string) (uint32, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+	hash := crc32.NewIEEE()
+	if _, err := io.Copy(hash, file); err != nil {
+		return 0, fmt.Errorf("failed to read file: %w", err)
+	}
+	return hash.Sum32(), nil
+}
+
+// copyUnchanged copies the compressed data of an input entry to the archive under name
+func copyUnchanged(zipWriter *zip.Writer, source *zip.File, name string) error {
+	reader, err := source.OpenRaw()
+	if err != nil {
+		return fmt.Errorf("failed to open %s in the input: %w", source.Name, err)
+	}
+	header := source.FileHeader
+	header.Name = name
+	header.Comment = ""
+	header.Extra = nil
+	header.Flags &^= 0x800
+	if !isASCII(name) {
+		header.Flags |= 0x800
+	}
+	writer, err := zipWriter.CreateRaw(&header)
+	if err != nil {
+		return fmt.Errorf("failed to create ZIP entry: %w", err)
+	}
+	if _, err := io.Copy(writer, reader); err != nil {
+		return fmt.Errorf("failed to copy %s from the input: %w", source.Name, err)
+	}
+	if restructure.DebugMode {
+		fmt.Printf("📦 Copied %s unchanged from %s\n", name, source.Name)
+	}
+	return nil
+}
@@ -0,0 +1,487 @@
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"html"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/flouciel/folian-parser/internal/policy"
+	"github.com/flouciel/folian-parser/internal/restructure"
+)
+
+// Repair salvages the readable entries of corrupt EPUB archives instead of refusing
+// them, and rebuilds a missing mimetype, container.xml or OPF
+var Repair bool
+
+// maxSalvagedSize limits the size of each entry recovered from a damaged archive, as
+// extraction does
+const maxSalvagedSize = 100 * 1024 * 1024
+
+// zipDataDescriptor optionally precedes the data descriptor that follows an entry
+// whose sizes are not in its local header
+var zipDataDescriptor = []byte("PK\x07\x08")
+
+// salvagedEntry is an entry read intact from a damaged archive
+type salvagedEntry struct {
+	name string
+	data []byte
+}
+
+// fullPathPattern matches the rootfile paths of a container.xml
+var fullPathPattern = regexp.MustCompile(`full-path\s*=\s*["']([^"']+)["']`)
+
+// manifestItemPattern matches the item elements of an OPF manifest with their
+// leading whitespace
+var manifestItemPattern = regexp.MustCompile(`\s*<item\b[^>]*/>`)
+
+// navTypePattern matches the toc nav element of an EPUB3 navigation document
+var navTypePattern = regexp.MustCompile(`epub:type\s*=\s*["']toc["']`)
+
+// repairMediaTypes are the media types of the files listed in a rebuilt OPF
+var repairMediaTypes = map[string]string{
+	".xhtml": "application/xhtml+xml",
+	".html":  "application/xhtml+xml",
+	".htm":   "application/xhtml+xml",
+	".css":   "text/css",
+	".jpg":   "image/jpeg",
+	".jpeg":  "image/jpeg",
+	".png":   "image/png",
+	".gif":   "image/gif",
+	".svg":   "image/svg+xml",
+	".webp":  "image/webp",
+	".ttf":   "font/ttf",
+	".otf":   "font/otf",
+	".woff":  "font/woff",
+	".woff2": "font/woff2",
+	".ncx":   "application/x-dtbncx+xml",
+	".js":    "application/javascript",
+	".mp3":   "audio/mpeg",
+	".mp4":   "video/mp4",
+	".smil":  "application/smil+xml",
+}
+
+// salvageEntries reads every intact entry of a damaged archive, from its central
+// directory when it has one and otherwise from the local file headers, returning
+// the entries that had to be dropped
+func salvageEntries(epubPath string) ([]salvagedEntry, []CorruptEntry, error) {
+	if reader, err := zip.OpenReader(epubPath); err == nil {
+		defer reader.Close()
+		var entries []salvagedEntry
+		var dropped []CorruptEntry
+		for _, file := range reader.File {
+			if file.FileInfo().IsDir() {
+				continue
+			}
+			if reason := verifyEntry(file); reason != "" {
+				dropped = append(dropped, CorruptEntry{file.Name, reason})
+				continue
+			}
+			src, err := file.Open()
+			if err != nil {
+				dropped = append(dropped, CorruptEntry{file.Name, err.Error()})
+				continue
+			}
+			data, err := ioutil.ReadAll(io.LimitReader(src, maxSalvagedSize+1))
+			src.Close()
+			if err != nil || len(data) > maxSalvagedSize {
+				dropped = append(dropped, CorruptEntry{file.Name, "unreadable or too large"})
+				continue
+			}
+			entries = append(entries, salvagedEntry{entryName(file.Name), data})
+		}
+		return entries, dropped, nil
+	}
+
+	data, err := ioutil.ReadFile(epubPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read EPUB file: %w", err)
+	}
+	fmt.Println("ℹ️  Central directory missing, recovering entries from local file headers")
+	entries, dropped := scanLocalHeaders(data)
+	return entries, dropped, nil
+}
+
+// scanLocalHeaders recovers the entries of an archive whose central directory is
+// lost by walking its local file headers; each entry is checked against its CRC-32
+func scanLocalHeaders(data []byte) ([]salvagedEntry, []CorruptEntry) {
+	var entries []salvagedEntry
+	var dropped []CorruptEntry
+	seen := make(map[string]bool)
+
+	for offset := 0; ; {
+		i := bytes.Index(data[offset:], zipLocalHeader)
+		if i < 0 {
+			break
+		}
+		offset += i
+		entry, next, reason := readLocalEntry(data, offset)
+		switch {
+		case entry == nil && reason == "":
+			// Not a header after all, or a directory
+			offset += len(zipLocalHeader)
+			continue
+		case reason != "":
+			dropped = append(dropped, CorruptEntry{entry.name, reason})
+			offset += len(zipLocalHeader)
+			continue
+		}
+		if !seen[entry.name] {
+			seen[entry.name] = true
+			entries = append(entries, *entry)
+		}
+		offset = next
+	}
+	return entries, dropped
+}
+
+// readLocalEntry reads the entry whose local file header starts at offset, returning
+// it with the offset following its data, or the reason it cannot be recovered
+func readLocalEntry(data []byte, offset int) (*salvagedEntry, int, string) {
+	const headerSize = 30
+	if offset+headerSize > len(data) {
+		return nil, 0, ""
+	}
+	header := data[offset : offset+headerSize]
+	flags := binary.LittleEndian.Uint16(header[6:])
+	method := binary.LittleEndian.Uint16(header[8:])
+	crc := binary.LittleEndian.Uint32(header[14:])
+	compressedSize := int(binary.LittleEndian.Uint32(header[18:]))
+	nameLen := int(binary.LittleEndian.Uint16(header[26:]))
+	extraLen := int(binary.LittleEndian.Uint16(header[28:]))
+
+	start := offset + headerSize + nameLen + extraLen
+	if start > len(data) {
+		return nil, 0, ""
+	}
+	entry := &salvagedEntry{name: entryName(string(data[offset+headerSize : offset+headerSize+nameLen]))}
+	if entry.name == "" || strings.HasSuffix(entry.name, "/") {
+		return nil, 0, ""
+	}
+
+	// Entries streamed with a data descriptor have their sizes and CRC after the data
+	hasDescriptor := flags&0x8 != 0
+	var end int
+	switch {
+	case method == zip.Deflate && hasDescriptor:
+		src := bytes.NewReader(data[start:])
+		content, err := ioutil.ReadAll(io.LimitReader(flate.NewReader(src), maxSalvagedSize+1))
+		if err != nil {
+			return entry, 0, "unreadable data: " + err.Error()
+		}
+		entry.data = content
+		end = len(data) - src.Len()
+	case method == zip.Store && hasDescriptor:
+		// The stored data runs up to its descriptor
+		i := bytes.Index(data[start:], zipDataDescriptor)
+		if i < 0 {
+			return entry, 0, "truncated: data descriptor missing"
+		}
+		entry.data = data[start : start+i]
+		end = start + i
+	case method == zip.Store || method == zip.Deflate:
+		end = start + compressedSize
+		if end > len(data) {
+			return entry, 0, fmt.Sprintf("truncated: data ends %d bytes past the end of the file", end-len(data))
+		}
+		entry.data = data[start:end]
+		if method == zip.Deflate {
+			content, err := ioutil.ReadAll(io.LimitReader(flate.NewReader(bytes.NewReader(entry.data)), maxSalvagedSize+1))
+			if err != nil {
+				return entry, 0, "unreadable data: " + err.Error()
+			}
+			entry.data = content
+		}
+	default:
+		return entry, 0, fmt.Sprintf("unsupported compression method %d", method)
+	}
+	if len(entry.data) > maxSalvagedSize {
+		return entry, 0, "too large"
+	}
+
+	if hasDescriptor {
+		if bytes.HasPrefix(data[end:], zipDataDescriptor) {
+			end += len(zipDataDescriptor)
+		}
+		if end+12 > len(data) {
+			return entry, 0, "truncated: data descriptor missing"
+		}
+		crc = binary.LittleEndian.Uint32(data[end:])
+		end += 12
+	}
+	if crc32.ChecksumIEEE(entry.data) != crc {
+		return entry, 0, "CRC-32 mismatch"
+	}
+	return entry, end, ""
+}
+
+// repairEPUB extracts the intact entries of a damaged EPUB into tempDir, reporting
+// the entries it drops
+func (p *Processor) repairEPUB(epubPath, tempDir string) (string, error) {
+	entries, dropped, err := salvageEntries(epubPath)
+	if err != nil {
+		return "", err
+	}
+	for _, entry := range dropped {
+		policy.Warn(policy.KindValidation, "Dropped corrupt entry %s (%s)", entry.Name, entry.Reason)
+	}
+	if len(entries) == 0 {
+		return "", fmt.Errorf("no entry of %s could be recovered", epubPath)
+	}
+	fmt.Printf("ℹ️  Salvaged %d entries of %s\n", len(entries), epubPath)
+
+	extractPath := filepath.Join(tempDir, "extracted")
+	if err := os.MkdirAll(extractPath, 0755); err != nil {
+		return "", fmt.Errorf("failed to create extraction directory: %w", err)
+	}
+
+	// Rename the entries that are not URL-safe or that Windows cannot create
+	var renames map[string]string
+	if segment := entrySegment(); segment != nil {
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.name)
+		}
+		renames = safeEntryNames(names, segment)
+	}
+
+	for _, entry := range entries {
+		name := entry.name
+		if renamed, ok := renames[name]; ok {
+			name = renamed
+		}
+
+		// Validate file path to prevent path traversal
+		filePath := filepath.Join(extractPath, filepath.FromSlash(name))
+		if !strings.HasPrefix(filePath, extractPath) {
+			return "", fmt.Errorf("invalid file path (potential path traversal attack): %s", entry.name)
+		}
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			return "", fmt.Errorf("failed to create directory: %w", err)
+		}
+		if err := ioutil.WriteFile(filePath, entry.data, 0644); err != nil {
+			return "", fmt.Errorf("failed to create file: %w", err)
+		}
+	}
+
+	if err := renameEntries(extractPath, renames); err != nil {
+		return "", fmt.Errorf("failed to rewrite references to renamed entries: %w", err)
+	}
+	return extractPath, nil
+}
+
+// reconstructContainer writes the mimetype, container.xml and OPF an extracted EPUB
+// is missing: container.xml points at the OPF found in the book, and an OPF is
+// rebuilt from the content files when there is none. Items of a surviving OPF whose
+// files were lost are removed from it
+func reconstructContainer(extractPath, title string) error {
+	mimetypePath := filepath.Join(extractPath, "mimetype")
+	if _, err := os.Stat(mimetypePath); err != nil {
+		policy.Warn(policy.KindValidation, "Missing mimetype file, recreated")
+		if err := ioutil.WriteFile(mimetypePath, []byte("application/epub+zip"), 0644); err != nil {
+			return fmt.Errorf("failed to write mimetype: %w", err)
+		}
+	}
+
+	// Keep a container.xml whose rootfile exists
+	containerPath := filepath.Join(extractPath, "META-INF", "container.xml")
+	if data, err := ioutil.ReadFile(containerPath); err == nil {
+		if match := fullPathPattern.FindSubmatch(data); match != nil {
+			opfPath := filepath.Join(extractPath, filepath.FromSlash(string(match[1])))
+			if _, err := os.Stat(opfPath); err == nil {
+				return pruneMissingItems(opfPath)
+			}
+		}
+	}
+
+	opfPath, files, err := findOPF(extractPath)
+	if err != nil {
+		return err
+	}
+	if opfPath != "" {
+		if err := pruneMissingItems(filepath.Join(extractPath, filepath.FromSlash(opfPath))); err != nil {
+			return err
+		}
+	} else {
+		if len(files) == 0 {
+			return fmt.Errorf("no content files could be recovered to rebuild the OPF from")
+		}
+		opfPath = "content.opf"
+		policy.Warn(policy.KindValidation, "No OPF file found, rebuilt %s from %d recovered files", opfPath, len(files))
+		if err := writeRebuiltOPF(filepath.Join(extractPath, opfPath), title, files); err != nil {
+			return err
+		}
+	}
+
+	policy.Warn(policy.KindValidation, "Missing or broken META-INF/container.xml, recreated pointing at %s", opfPath)
+	container := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="%s" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`, html.EscapeString(opfPath))
+	if err := os.MkdirAll(filepath.Dir(containerPath), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	if err := ioutil.WriteFile(containerPath, []byte(container), 0644); err != nil {
+		return fmt.Errorf("failed to write container.xml: %w", err)
+	}
+	return nil
+}
+
+// pruneMissingItems removes the manifest items of an OPF whose files are missing,
+// with their spine entries
+func pruneMissingItems(opfPath string) error {
+	data, err := ioutil.ReadFile(opfPath)
+	if err != nil {
+		return fmt.Errorf("failed to read OPF file: %w", err)
+	}
+
+	opfDir := filepath.Dir(opfPath)
+	var missing []string
+	content := manifestItemPattern.ReplaceAllStringFunc(string(data), func(item string) string {
+		href := itemAttribute(item, "href")
+		if href == "" || strings.Contains(href, ":") {
+			return item
+		}
+		file := html.UnescapeString(href)
+		if i := strings.Index(file, "#"); i >= 0 {
+			file = file[:i]
+		}
+		if decoded, err := url.PathUnescape(file); err == nil {
+			file = decoded
+		}
+		if _, err := os.Stat(filepath.Join(opfDir, filepath.FromSlash(file))); err == nil {
+			return item
+		}
+		policy.Warn(policy.KindValidation, "Removed %s from the OPF, its file was lost", file)
+		missing = append(missing, itemAttribute(item, "id"))
+		return ""
+	})
+	if len(missing) == 0 {
+		return nil
+	}
+	for _, id := range missing {
+		if id == "" {
+			continue
+		}
+		itemref := regexp.MustCompile(`\s*<itemref\b[^>]*\bidref\s*=\s*["']` + regexp.QuoteMeta(id) + `["'][^>]*/>`)
+		content = itemref.ReplaceAllString(content, "")
+	}
+	if err := ioutil.WriteFile(opfPath, []byte(content), 0644); err != nil {
+		return fmt.Errorf("failed to write OPF file: %w", err)
+	}
+	return nil
+}
+
+// itemAttribute returns the value of an attribute of an OPF element, or ""
+func itemAttribute(element, name string) string {
+	match := regexp.MustCompile(`\b` + name + `\s*=\s*["']([^"']*)["']`).FindStringSubmatch(element)
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
+
+// findOPF returns the first OPF of an extracted EPUB, or "" with the content files a
+// new OPF would list, as sorted slash-separated paths
+func findOPF(extractPath string) (string, []string, error) {
+	var opfs, files []string
+	err := filepath.Walk(extractPath, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(extractPath, filePath)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		switch {
+		case strings.EqualFold(path.Ext(rel), ".opf"):
+			opfs = append(opfs, rel)
+		case rel == "mimetype" || strings.HasPrefix(rel, "META-INF/"):
+		default:
+			files = append(files, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to list recovered files: %w", err)
+	}
+	if len(opfs) > 0 {
+		sort.Strings(opfs)
+		return opfs[0], nil, nil
+	}
+	sort.Strings(files)
+	return "", files, nil
+}
+
+// writeRebuiltOPF writes an OPF listing the recovered files, with the XHTML
+// documents in name order as the spine
+func writeRebuiltOPF(opfPath, title string, files []string) error {
+	var manifest, spine strings.Builder
+	for i, file := range files {
+		ext := strings.ToLower(path.Ext(file))
+		mediaType, ok := repairMediaTypes[ext]
+		if !ok {
+			continue
+		}
+		id := fmt.Sprintf("item%d", i+1)
+		href := html.EscapeString((&url.URL{Path: file}).EscapedPath())
+		properties := ""
+		if mediaType != "application/xhtml+xml" {
+			fmt.Fprintf(&manifest, "    <item id=\"%s\" href=\"%s\" media-type=\"%s\"/>\n", id, href, mediaType)
+			continue
+		}
+		if strings.Contains(strings.ToLower(path.Base(file)), "nav") && isNavDocument(filepath.Join(filepath.Dir(opfPath), filepath.FromSlash(file))) {
+			properties = ` properties="nav"`
+		}
+		fmt.Fprintf(&manifest, "    <item id=\"%s\" href=\"%s\" media-type=\"%s\"%s/>\n", id, href, mediaType, properties)
+		if properties == "" {
+			fmt.Fprintf(&spine, "    <itemref idref=\"%s\"/>\n", id)
+		}
+	}
+	if spine.Len() == 0 {
+		return fmt.Errorf("no XHTML documents could be recovered to rebuild the OPF from")
+	}
+
+	opf := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="bookid">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:identifier id="bookid">urn:folian:repaired:%s</dc:identifier>
+    <dc:title>%s</dc:title>
+    <dc:language>und</dc:language>
+  </metadata>
+  <manifest>
+%s  </manifest>
+  <spine>
+%s  </spine>
+</package>
+`, html.EscapeString(urlSafeSegment(title)), html.EscapeString(title), manifest.String(), spine.String())
+	if err := ioutil.WriteFile(opfPath, []byte(opf), 0644); err != nil {
+		return fmt.Errorf("failed to write rebuilt OPF: %w", err)
+	}
+	if restructure.DebugMode {
+		fmt.Printf("🩹 Rebuilt OPF:\n%s", opf)
+	}
+	return nil
+}
+
+// isNavDocument reports whether an XHTML file is an EPUB3 navigation document
+func isNavDocument(filePath string) bool {
+	data, err := ioutil.ReadFile(filePath)
+	return err == nil && navTypePattern.Match(data)
+}
@@ -0,0 +1,33 @@
+package epub
+
+import (
+	"fmt"
+
+	"github.com/flouciel/folian-parser/internal/parser"
+)
+
+// Stage is a transformation of the parsed book run between parsing and
+// restructuring, such as renumbering footnotes; it returns the book to continue
+// with, usually the one it was given
+type Stage func(*parser.Book) (*parser.Book, error)
+
+// Use appends stages to the ones run on each book, in the order given
+func (p *Processor) Use(stages ...Stage) {
+	p.stages = append(p.stages, stages...)
+}
+
+// runStages passes the book through the stages in order; a failing stage stops
+// processing
+func (p *Processor) runStages(book *parser.Book) (*parser.Book, error) {
+	for i, stage := range p.stages {
+		next, err := stage(book)
+		if err != nil {
+			return nil, withKind(ErrValidation, fmt.Errorf("stage %d failed: %w", i+1, err))
+		}
+		if next == nil {
+			return nil, withKind(ErrValidation, fmt.Errorf("stage %d returned no book", i+1))
+		}
+		book = next
+	}
+	return book, nil
+}
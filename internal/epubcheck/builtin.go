@@ -0,0 +1,341 @@
+package epubcheck
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// epubMimetype is the content of the mimetype entry
+const epubMimetype = "application/epub+zip"
+
+// referenceAttributes are the attributes of content documents that reference
+// other resources
+var referenceAttributes = map[string]bool{"href": true, "src": true, "poster": true, "data": true}
+
+// builtinPackage is the part of the package document read by the built-in checks
+type builtinPackage struct {
+	Version          string `xml:"version,attr"`
+	UniqueIdentifier string `xml:"unique-identifier,attr"`
+	Identifiers      []struct {
+		ID    string `xml:"id,attr"`
+		Value string `xml:",chardata"`
+	} `xml:"metadata>identifier"`
+	Items []struct {
+		ID         string `xml:"id,attr"`
+		Href       string `xml:"href,attr"`
+		MediaType  string `xml:"media-type,attr"`
+		Properties string `xml:"properties,attr"`
+	} `xml:"manifest>item"`
+	Spine struct {
+		Toc      string `xml:"toc,attr"`
+		Itemrefs []struct {
+			IDRef string `xml:"idref,attr"`
+		} `xml:"itemref"`
+	} `xml:"spine"`
+}
+
+// contentDocument holds the IDs and references of a parsed XHTML document
+type contentDocument struct {
+	ids        map[string]bool
+	references []reference
+}
+
+// reference is a link or resource reference of a content document
+type reference struct {
+	target string
+	line   int
+	column int
+}
+
+// checker runs the built-in checks over an EPUB archive
+type checker struct {
+	report *Report
+	files  map[string]*zip.File
+	epub3  bool
+}
+
+// Builtin validates an EPUB with the built-in subset of the EPUBCheck checks: the
+// OCF container, the package document, manifest and spine, the NCX identifier,
+// well-formed content documents and the resources and fragments they reference.
+// Messages use the EPUBCheck IDs of the same problems
+func Builtin(epubPath string) (*Report, error) {
+	report := &Report{Validator: ValidatorBuiltin, Messages: []Message{}}
+	reader, err := zip.OpenReader(epubPath)
+	if err != nil {
+		report.Add(Message{ID: "PKG-008", Severity: SeverityFatal, Text: fmt.Sprintf("Unable to read file: %v", err), Path: epubPath})
+		return report, nil
+	}
+	defer reader.Close()
+
+	c := &checker{report: report, files: make(map[string]*zip.File)}
+	for _, file := range reader.File {
+		c.files[file.Name] = file
+	}
+	c.checkMimetype(reader.File)
+	c.checkPackage()
+	sortMessages(report.Messages)
+	return report, nil
+}
+
+// checkMimetype checks that the mimetype entry comes first, stored and with the
+// EPUB media type
+func (c *checker) checkMimetype(files []*zip.File) {
+	if len(files) == 0 || files[0].Name != "mimetype" {
+		c.report.Add(Message{ID: "PKG-006", Severity: SeverityError, Text: "Mimetype file entry is missing or is not the first file in the archive."})
+		if c.files["mimetype"] == nil {
+			return
+		}
+	}
+	file := c.files["mimetype"]
+	data, err := readFile(file)
+	if err != nil || string(data) != epubMimetype || file.Method != zip.Store {
+		c.report.Add(Message{ID: "PKG-007", Severity: SeverityError, Text: "Mimetype file should only contain the string \"" + epubMimetype + "\" and should not be compressed.", Path: "mimetype"})
+	}
+}
+
+// checkPackage checks the container, the package document and everything it lists
+func (c *checker) checkPackage() {
+	containerData, err := readFile(c.files["META-INF/container.xml"])
+	if err != nil {
+		c.report.Add(Message{ID: "RSC-002", Severity: SeverityFatal, Text: "Required META-INF/container.xml resource could not be found."})
+		return
+	}
+	var container struct {
+		Rootfiles []struct {
+			FullPath string `xml:"full-path,attr"`
+		} `xml:"rootfiles>rootfile"`
+	}
+	if err := xml.Unmarshal(containerData, &container); err != nil {
+		c.parseError("META-INF/container.xml", err)
+		return
+	}
+	if len(container.Rootfiles) == 0 {
+		c.report.Add(Message{ID: "RSC-003", Severity: SeverityFatal, Text: "No rootfile with media type \"application/oebps-package+xml\" was found in the container.", Path: "META-INF/container.xml"})
+		return
+	}
+
+	opfPath := container.Rootfiles[0].FullPath
+	opfData, err := readFile(c.files[opfPath])
+	if err != nil {
+		c.report.Add(Message{ID: "RSC-001", Severity: SeverityFatal, Text: fmt.Sprintf("File %q could not be found.", opfPath), Path: "META-INF/container.xml"})
+		return
+	}
+	var pkg builtinPackage
+	if err := xml.Unmarshal(opfData, &pkg); err != nil {
+		c.parseError(opfPath, err)
+		return
+	}
+	c.epub3 = strings.HasPrefix(pkg.Version, "3")
+	opfDir := path.Dir(opfPath)
+
+	// Unique identifier
+	uid, found := "", false
+	for _, identifier := range pkg.Identifiers {
+		if identifier.ID == pkg.UniqueIdentifier {
+			uid, found = strings.TrimSpace(identifier.Value), true
+		}
+	}
+	if !found {
+		c.report.Add(Message{ID: "OPF-030", Severity: SeverityError, Text: fmt.Sprintf("The unique-identifier %q was not found.", pkg.UniqueIdentifier), Path: opfPath})
+	} else if uid == "" {
+		c.report.Add(Message{ID: "RSC-005", Severity: SeverityError, Text: "Error while parsing file: element \"dc:identifier\" must not be empty.", Path: opfPath})
+	}
+
+	// Manifest
+	manifest := make(map[string]string)
+	declared := map[string]bool{opfPath: true}
+	var navItems int
+	var documents []string
+	for _, item := range pkg.Items {
+		itemPath := resolve(opfDir, item.Href)
+		manifest[item.ID] = itemPath
+		declared[itemPath] = true
+		if hasProperty(item.Properties, "nav") {
+			navItems++
+		}
+		if c.files[itemPath] == nil {
+			c.report.Add(Message{ID: "RSC-001", Severity: SeverityError, Text: fmt.Sprintf("File %q could not be found.", itemPath), Path: opfPath})
+			continue
+		}
+		if item.MediaType == "application/xhtml+xml" {
+			documents = append(documents, itemPath)
+		}
+	}
+	if c.epub3 && navItems != 1 {
+		c.report.Add(Message{ID: "RSC-005", Severity: SeverityError, Text: "Error while parsing file: Exactly one manifest item must declare the \"nav\" property.", Path: opfPath})
+	}
+	for name, file := range c.files {
+		if !declared[name] && name != "mimetype" && !strings.HasPrefix(name, "META-INF/") && !file.FileInfo().IsDir() {
+			c.report.Add(Message{ID: "OPF-003", Severity: SeverityWarning, Text: fmt.Sprintf("Item %q exists in the EPUB, but is not declared in the OPF manifest.", name)})
+		}
+	}
+
+	// Spine and NCX
+	for _, itemref := range pkg.Spine.Itemrefs {
+		if _, exists := manifest[itemref.IDRef]; !exists {
+			c.report.Add(Message{ID: "OPF-049", Severity: SeverityError, Text: fmt.Sprintf("Item id %q was not found in the manifest.", itemref.IDRef), Path: opfPath})
+		}
+	}
+	if pkg.Spine.Toc != "" {
+		if ncxPath, exists := manifest[pkg.Spine.Toc]; !exists {
+			c.report.Add(Message{ID: "OPF-049", Severity: SeverityError, Text: fmt.Sprintf("Item id %q was not found in the manifest.", pkg.Spine.Toc), Path: opfPath})
+		} else if found {
+			c.checkNCX(ncxPath, uid)
+		}
+	}
+
+	// Content documents and the resources they reference
+	parsed := make(map[string]*contentDocument)
+	for _, documentPath := range documents {
+		if document := c.parseDocument(documentPath); document != nil {
+			parsed[documentPath] = document
+		}
+	}
+	for _, documentPath := range documents {
+		if document := parsed[documentPath]; document != nil {
+			c.checkReferences(documentPath, document, parsed, declared)
+		}
+	}
+}
+
+// checkNCX checks that the NCX identifier matches the package's unique identifier
+func (c *checker) checkNCX(ncxPath, uid string) {
+	data, err := readFile(c.files[ncxPath])
+	if err != nil {
+		return
+	}
+	var ncx struct {
+		Meta []struct {
+			Name    string `xml:"name,attr"`
+			Content string `xml:"content,attr"`
+		} `xml:"head>meta"`
+	}
+	if err := xml.Unmarshal(data, &ncx); err != nil {
+		c.parseError(ncxPath, err)
+		return
+	}
+	for _, meta := range ncx.Meta {
+		if meta.Name == "dtb:uid" && strings.TrimSpace(meta.Content) != uid {
+			c.report.Add(Message{ID: "NCX-001", Severity: SeverityError, Text: fmt.Sprintf("NCX identifier (%q) does not match OPF identifier (%q).", meta.Content, uid), Path: ncxPath})
+		}
+	}
+}
+
+// parseDocument parses a content document, reporting it when it is not well-formed
+func (c *checker) parseDocument(documentPath string) *contentDocument {
+	data, err := readFile(c.files[documentPath])
+	if err != nil {
+		c.report.Add(Message{ID: "PKG-008", Severity: SeverityFatal, Text: fmt.Sprintf("Unable to read file: %v", err), Path: documentPath})
+		return nil
+	}
+
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	if !c.epub3 {
+		// EPUB2 documents may use the entities of the XHTML 1.1 DTD
+		decoder.Entity = xml.HTMLEntity
+	}
+	document := &contentDocument{ids: make(map[string]bool)}
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return document
+		}
+		if err != nil {
+			c.parseError(documentPath, err)
+			return nil
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		line, column := decoder.InputPos()
+		for _, attr := range start.Attr {
+			switch {
+			case attr.Name.Local == "id" && (attr.Name.Space == "" || attr.Name.Space == "http://www.w3.org/XML/1998/namespace"):
+				document.ids[attr.Value] = true
+			case referenceAttributes[attr.Name.Local] && (attr.Name.Space == "" || attr.Name.Space == "http://www.w3.org/1999/xlink"):
+				document.references = append(document.references, reference{strings.TrimSpace(attr.Value), line, column})
+			}
+		}
+	}
+}
+
+// checkReferences checks that the references of a content document point at
+// resources in the EPUB, declared in the manifest, and at fragments that exist
+func (c *checker) checkReferences(documentPath string, document *contentDocument, parsed map[string]*contentDocument, declared map[string]bool) {
+	for _, ref := range document.references {
+		parsedURL, err := url.Parse(ref.target)
+		if err != nil || ref.target == "" || parsedURL.Scheme != "" || strings.HasPrefix(ref.target, "//") {
+			continue
+		}
+
+		targetPath := documentPath
+		if parsedURL.Path != "" {
+			targetPath = path.Join(path.Dir(documentPath), parsedURL.Path)
+		}
+		message := Message{Path: documentPath, Line: ref.line, Column: ref.column}
+		switch {
+		case c.files[targetPath] == nil:
+			message.ID, message.Severity = "RSC-007", SeverityError
+			message.Text = fmt.Sprintf("Referenced resource %q could not be found in the EPUB.", targetPath)
+		case !declared[targetPath]:
+			message.ID, message.Severity = "RSC-008", SeverityError
+			message.Text = fmt.Sprintf("Referenced resource %q is not declared in the OPF manifest.", targetPath)
+		case parsedURL.Fragment != "" && parsed[targetPath] != nil && !parsed[targetPath].ids[parsedURL.Fragment]:
+			message.ID, message.Severity = "RSC-012", SeverityError
+			message.Text = fmt.Sprintf("Fragment identifier is not defined: %s#%s", targetPath, parsedURL.Fragment)
+		default:
+			continue
+		}
+		c.report.Add(message)
+	}
+}
+
+// parseError reports a file that is not well-formed XML
+func (c *checker) parseError(filePath string, err error) {
+	message := Message{ID: "RSC-005", Severity: SeverityError, Text: fmt.Sprintf("Error while parsing file: %v", err), Path: filePath}
+	if syntaxErr, ok := err.(*xml.SyntaxError); ok {
+		message.Line = syntaxErr.Line
+		message.Text = "Error while parsing file: " + syntaxErr.Msg
+	}
+	c.report.Add(message)
+}
+
+// readFile reads an archive entry, failing when it does not exist
+func readFile(file *zip.File) ([]byte, error) {
+	if file == nil {
+		return nil, fmt.Errorf("not found")
+	}
+	rc, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
+
+// resolve returns the archive path of an href relative to dir
+func resolve(dir, href string) string {
+	href = strings.SplitN(href, "#", 2)[0]
+	if decoded, err := url.PathUnescape(href); err == nil {
+		href = decoded
+	}
+	return path.Join(dir, href)
+}
+
+// hasProperty reports whether a space-separated property list holds property
+func hasProperty(properties, property string) bool {
+	for _, p := range strings.Fields(properties) {
+		if p == property {
+			return true
+		}
+	}
+	return false
+}
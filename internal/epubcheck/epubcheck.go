@@ -0,0 +1,204 @@
+// Package epubcheck validates EPUB files with an installed EPUBCheck, or with a
+// built-in subset of its checks when EPUBCheck is not installed
+package epubcheck
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// Command is the EPUBCheck launcher looked up in the PATH
+const Command = "epubcheck"
+
+// JarEnv names the environment variable holding the path of epubcheck.jar, run with
+// java when the launcher is not in the PATH
+const JarEnv = "EPUBCHECK_JAR"
+
+// Validators named in reports
+const (
+	ValidatorEPUBCheck = "epubcheck"
+	ValidatorBuiltin   = "built-in"
+)
+
+// Severities of the messages, as EPUBCheck names them
+const (
+	SeverityFatal   = "FATAL"
+	SeverityError   = "ERROR"
+	SeverityWarning = "WARNING"
+	SeverityUsage   = "USAGE"
+	SeverityInfo    = "INFO"
+)
+
+// Message is a finding of the validator
+type Message struct {
+	ID       string `json:"id"`
+	Severity string `json:"severity"`
+	Text     string `json:"message"`
+	Path     string `json:"path,omitempty"`
+	Line     int    `json:"line,omitempty"`
+	Column   int    `json:"column,omitempty"`
+}
+
+// Location returns where the message applies, as path:line:column
+func (m Message) Location() string {
+	location := m.Path
+	if m.Line > 0 {
+		location += fmt.Sprintf(":%d", m.Line)
+		if m.Column > 0 {
+			location += fmt.Sprintf(":%d", m.Column)
+		}
+	}
+	return location
+}
+
+// Report is the outcome of validating an EPUB
+type Report struct {
+	// Validator is the EPUBCheck version, or ValidatorBuiltin
+	Validator string    `json:"validator"`
+	Errors    int       `json:"errors"`
+	Warnings  int       `json:"warnings"`
+	Messages  []Message `json:"messages"`
+}
+
+// Add records a message and counts it
+func (r *Report) Add(message Message) {
+	switch message.Severity {
+	case SeverityFatal, SeverityError:
+		r.Errors++
+	case SeverityWarning:
+		r.Warnings++
+	}
+	r.Messages = append(r.Messages, message)
+}
+
+// WriteText writes the report for people, one message per line
+func (r *Report) WriteText(w io.Writer) error {
+	fmt.Fprintf(w, "🧪 %s: %d errors, %d warnings\n", r.Validator, r.Errors, r.Warnings)
+	for _, message := range r.Messages {
+		line := fmt.Sprintf("   %-7s %s", message.Severity, message.ID)
+		if location := message.Location(); location != "" {
+			line += " " + location
+		}
+		if _, err := fmt.Fprintf(w, "%s: %s\n", line, message.Text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Installed reports whether EPUBCheck can be run, from the PATH or through JarEnv
+func Installed() bool {
+	_, _, err := command()
+	return err == nil
+}
+
+// command returns the program and arguments that run EPUBCheck
+func command() (string, []string, error) {
+	if path, err := exec.LookPath(Command); err == nil {
+		return path, nil, nil
+	}
+	if jar := os.Getenv(JarEnv); jar != "" {
+		if _, err := os.Stat(jar); err != nil {
+			return "", nil, fmt.Errorf("%s points at a missing file: %w", JarEnv, err)
+		}
+		java, err := exec.LookPath("java")
+		if err != nil {
+			return "", nil, fmt.Errorf("%s is set but java is not installed", JarEnv)
+		}
+		return java, []string{"-jar", jar}, nil
+	}
+	return "", nil, fmt.Errorf("%s is not installed and %s is not set", Command, JarEnv)
+}
+
+// Run validates an EPUB with EPUBCheck when installed, else with the built-in checks
+func Run(epubPath string) (*Report, error) {
+	if !Installed() {
+		return Builtin(epubPath)
+	}
+	return External(epubPath)
+}
+
+// epubcheckOutput is the part of the EPUBCheck JSON report that is read
+type epubcheckOutput struct {
+	Checker struct {
+		CheckerVersion string `json:"checkerVersion"`
+	} `json:"checker"`
+	Messages []struct {
+		ID        string `json:"ID"`
+		Severity  string `json:"severity"`
+		Message   string `json:"message"`
+		Locations []struct {
+			Path   string `json:"path"`
+			Line   int    `json:"line"`
+			Column int    `json:"column"`
+		} `json:"locations"`
+	} `json:"messages"`
+}
+
+// External validates an EPUB with the installed EPUBCheck, reading its JSON report
+func External(epubPath string) (*Report, error) {
+	program, args, err := command()
+	if err != nil {
+		return nil, err
+	}
+
+	jsonFile, err := ioutil.TempFile("", "folian-epubcheck-*.json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create epubcheck report file: %w", err)
+	}
+	jsonFile.Close()
+	defer os.Remove(jsonFile.Name())
+
+	// EPUBCheck exits with an error status when it reports errors, so the status
+	// only matters when no report was written
+	output, runErr := exec.Command(program, append(args, epubPath, "--json", jsonFile.Name())...).CombinedOutput()
+	data, err := ioutil.ReadFile(jsonFile.Name())
+	if err != nil || len(data) == 0 {
+		if runErr != nil {
+			return nil, fmt.Errorf("epubcheck failed: %w: %s", runErr, strings.TrimSpace(string(output)))
+		}
+		return nil, fmt.Errorf("epubcheck wrote no report")
+	}
+
+	var result epubcheckOutput
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("failed to read epubcheck report: %w", err)
+	}
+
+	report := &Report{Validator: strings.TrimSpace(ValidatorEPUBCheck + " " + result.Checker.CheckerVersion), Messages: []Message{}}
+	for _, m := range result.Messages {
+		message := Message{ID: m.ID, Severity: strings.ToUpper(m.Severity), Text: m.Message}
+		if len(m.Locations) == 0 {
+			report.Add(message)
+			continue
+		}
+		for _, location := range m.Locations {
+			message.Path, message.Line, message.Column = location.Path, location.Line, location.Column
+			report.Add(message)
+		}
+	}
+	sortMessages(report.Messages)
+	return report, nil
+}
+
+// severityRank orders messages from the most severe
+var severityRank = map[string]int{SeverityFatal: 0, SeverityError: 1, SeverityWarning: 2, SeverityUsage: 3, SeverityInfo: 4}
+
+// sortMessages orders messages by severity, then by location
+func sortMessages(messages []Message) {
+	sort.SliceStable(messages, func(i, j int) bool {
+		if severityRank[messages[i].Severity] != severityRank[messages[j].Severity] {
+			return severityRank[messages[i].Severity] < severityRank[messages[j].Severity]
+		}
+		if messages[i].Path != messages[j].Path {
+			return messages[i].Path < messages[j].Path
+		}
+		return messages[i].Line < messages[j].Line
+	})
+}
@@ -0,0 +1,100 @@
+// Package events publishes what happens while a book is processed, such as each
+// chapter written, resource copied and warning raised, to the subscribers that show
+// it: the command's output, or the progress view of a frontend
+package events
+
+import "sync"
+
+// Event is something that happened while processing a book: a ChapterProcessed,
+// ResourceCopied or WarningRaised
+type Event interface {
+	event()
+}
+
+// ChapterProcessed is published when a chapter of the output was written
+type ChapterProcessed struct {
+	// Index is the position of the chapter among the Total chapters processed,
+	// from 1; chapters left empty are not published, so some indexes are missing
+	Index int
+	Total int
+	Title string
+	// File is the path of the chapter in the output, e.g. chapters/chapter_001.xhtml
+	File string
+	Size int
+}
+
+// ResourceCopied is published when a stylesheet, font or image was written to the
+// output
+type ResourceCopied struct {
+	// Kind is ResourceStylesheet, ResourceFont, ResourceImage or ResourceCover
+	Kind string
+	// Source is the file the resource was read from, Target its path in the output
+	Source string
+	Target string
+	Size   int
+}
+
+// Kinds of copied resources
+const (
+	ResourceStylesheet = "stylesheet"
+	ResourceFont       = "font"
+	ResourceImage      = "image"
+	ResourceCover      = "cover"
+)
+
+// WarningRaised is published for each warning about the book, with its policy kind
+type WarningRaised struct {
+	Kind    string
+	Message string
+}
+
+func (ChapterProcessed) event() {}
+func (ResourceCopied) event()   {}
+func (WarningRaised) event()    {}
+
+// Handler receives the published events
+type Handler func(Event)
+
+// subscription is a subscribed handler with the ID it is unsubscribed by
+type subscription struct {
+	id      int
+	handler Handler
+}
+
+var (
+	mu            sync.Mutex
+	subscriptions []subscription
+	nextID        int
+)
+
+// Subscribe calls handler with every event published until the returned function
+// is called. Handlers run in the goroutine processing the book, in the order they
+// subscribed, and should return quickly
+func Subscribe(handler Handler) (unsubscribe func()) {
+	mu.Lock()
+	defer mu.Unlock()
+	nextID++
+	id := nextID
+	subscriptions = append(subscriptions, subscription{id: id, handler: handler})
+	return func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for i, s := range subscriptions {
+			if s.id == id {
+				subscriptions = append(subscriptions[:i:i], subscriptions[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// Publish passes an event to the subscribers
+func Publish(event Event) {
+	mu.Lock()
+	current := subscriptions
+	mu.Unlock()
+
+	for _, s := range current {
+		s.handler(event)
+	}
+}
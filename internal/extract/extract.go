@@ -0,0 +1,396 @@
+package extract
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/flouciel/folian-parser/internal/htmlnode"
+	"github.com/flouciel/folian-parser/internal/parser"
+	nethtml "golang.org/x/net/html"
+)
+
+// Output formats of the extraction
+const (
+	FormatText     = "text"
+	FormatMarkdown = "markdown"
+)
+
+// blockElements are the elements rendered as blocks of their own
+var blockElements = map[string]bool{
+	"p": true, "div": true, "section": true, "article": true, "aside": true, "header": true,
+	"footer": true, "main": true, "nav": true, "figure": true, "figcaption": true, "blockquote": true,
+	"ul": true, "ol": true, "li": true, "dl": true, "dt": true, "dd": true, "table": true, "tr": true,
+	"pre": true, "hr": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+}
+
+// skippedElements are the elements whose content is not book text
+var skippedElements = map[string]bool{
+	"script": true, "style": true, "head": true, "title": true,
+}
+
+// markdownEscaper escapes the characters Markdown would read as emphasis or code
+var markdownEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "_", `\_`, "`", "\\`")
+
+// renderer converts the body of a chapter to text or Markdown blocks
+type renderer struct {
+	markdown bool
+}
+
+// Chapter converts the body of a chapter to plain text or Markdown
+func Chapter(content, format string) (string, error) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(content))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse chapter: %w", err)
+	}
+
+	r := renderer{markdown: format == FormatMarkdown}
+	var blocks []string
+	for _, node := range doc.Find("body").Nodes {
+		blocks = append(blocks, r.blocks(node)...)
+	}
+	return strings.Join(blocks, "\n\n") + "\n", nil
+}
+
+// isBlock reports whether a node is a block element
+func isBlock(node *nethtml.Node) bool {
+	return node.Type == nethtml.ElementNode && blockElements[node.Data]
+}
+
+// blocks renders the children of a container, gathering runs of inline content into
+// paragraphs
+func (r renderer) blocks(node *nethtml.Node) []string {
+	var blocks []string
+	var inline strings.Builder
+	flush := func() {
+		lines := strings.Split(inline.String(), "\n")
+		for i, line := range lines {
+			lines[i] = strings.Join(strings.Fields(line), " ")
+		}
+		if text := strings.Trim(strings.Join(lines, "\n"), "\n"); text != "" {
+			blocks = append(blocks, text)
+		}
+		inline.Reset()
+	}
+
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if !isBlock(child) {
+			inline.WriteString(r.inline(child))
+			continue
+		}
+		flush()
+		blocks = append(blocks, r.block(child)...)
+	}
+	flush()
+	return blocks
+}
+
+// block renders a block element
+func (r renderer) block(node *nethtml.Node) []string {
+	switch node.Data {
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		text := r.inlineChildren(node)
+		if text == "" {
+			return nil
+		}
+		if r.markdown {
+			level := int(node.Data[1] - '0')
+			return []string{strings.Repeat("#", level) + " " + text}
+		}
+		return []string{text}
+	case "hr":
+		if r.markdown {
+			return []string{"---"}
+		}
+		return []string{"* * *"}
+	case "pre":
+		text := strings.Trim(textContent(node), "\n")
+		if r.markdown {
+			return []string{"```\n" + text + "\n```"}
+		}
+		return []string{text}
+	case "blockquote":
+		prefix := "    "
+		if r.markdown {
+			prefix = "> "
+		}
+		var quoted []string
+		for _, block := range r.blocks(node) {
+			quoted = append(quoted, prefixLines(block, prefix))
+		}
+		separator := "\n\n"
+		if r.markdown {
+			separator = "\n>\n"
+		}
+		if len(quoted) == 0 {
+			return nil
+		}
+		return []string{strings.Join(quoted, separator)}
+	case "ul", "ol":
+		return r.list(node)
+	case "table":
+		return r.table(node)
+	}
+	return r.blocks(node)
+}
+
+// list renders the items of a list, numbering those of an ordered list
+func (r renderer) list(node *nethtml.Node) []string {
+	var items []string
+	number := 0
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type != nethtml.ElementNode || child.Data != "li" {
+			continue
+		}
+		number++
+		marker := "- "
+		if node.Data == "ol" {
+			marker = strconv.Itoa(number) + ". "
+		}
+		item := strings.Join(r.blocks(child), "\n")
+		items = append(items, marker+prefixLines(item, strings.Repeat(" ", len(marker)))[len(marker):])
+	}
+	if len(items) == 0 {
+		return nil
+	}
+	return []string{strings.Join(items, "\n")}
+}
+
+// table renders a table one row per line, its cells separated by bars
+func (r renderer) table(node *nethtml.Node) []string {
+	var rows []string
+	var walk func(n *nethtml.Node)
+	walk = func(n *nethtml.Node) {
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type != nethtml.ElementNode {
+				continue
+			}
+			if child.Data != "tr" {
+				walk(child)
+				continue
+			}
+			var cells []string
+			for cell := child.FirstChild; cell != nil; cell = cell.NextSibling {
+				if cell.Type == nethtml.ElementNode && (cell.Data == "td" || cell.Data == "th") {
+					cells = append(cells, strings.Join(r.blocks(cell), " "))
+				}
+			}
+			if r.markdown {
+				rows = append(rows, "| "+strings.Join(cells, " | ")+" |")
+				if len(rows) == 1 {
+					rows = append(rows, strings.Repeat("| --- ", len(cells))+"|")
+				}
+			} else {
+				rows = append(rows, strings.Join(cells, " | "))
+			}
+		}
+	}
+	walk(node)
+	if len(rows) == 0 {
+		return nil
+	}
+	return []string{strings.Join(rows, "\n")}
+}
+
+// inlineChildren renders the children of an element as one line
+func (r renderer) inlineChildren(node *nethtml.Node) string {
+	var text strings.Builder
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		text.WriteString(r.inline(child))
+	}
+	return strings.Join(strings.Fields(text.String()), " ")
+}
+
+// inline renders inline content, with Markdown emphasis, links and images when
+// writing Markdown
+func (r renderer) inline(node *nethtml.Node) string {
+	switch node.Type {
+	case nethtml.TextNode:
+		text := strings.Join(strings.Fields(node.Data), " ")
+		if text == "" {
+			if node.Data != "" {
+				return " "
+			}
+			return ""
+		}
+		if unicode.IsSpace([]rune(node.Data)[0]) {
+			text = " " + text
+		}
+		if unicode.IsSpace([]rune(node.Data)[len([]rune(node.Data))-1]) {
+			text += " "
+		}
+		if r.markdown {
+			text = markdownEscaper.Replace(text)
+		}
+		return text
+	case nethtml.ElementNode:
+	default:
+		return ""
+	}
+
+	if skippedElements[node.Data] {
+		return ""
+	}
+	switch node.Data {
+	case "br":
+		if r.markdown {
+			return "\\\n"
+		}
+		return "\n"
+	case "img":
+		alt := htmlnode.Attribute(node, "alt")
+		if r.markdown {
+			return fmt.Sprintf("![%s](%s)", alt, htmlnode.Attribute(node, "src"))
+		}
+		if alt != "" {
+			return "[" + alt + "]"
+		}
+		return ""
+	}
+
+	var raw strings.Builder
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		raw.WriteString(r.inline(child))
+	}
+	text := strings.TrimSpace(raw.String())
+	if !r.markdown || text == "" {
+		return raw.String()
+	}
+
+	// Markers go around the trimmed text, keeping the spaces at its edges outside
+	lead := raw.String()[:strings.Index(raw.String(), text)]
+	trail := raw.String()[len(lead)+len(text):]
+	switch node.Data {
+	case "em", "i", "cite":
+		text = "*" + text + "*"
+	case "strong", "b":
+		text = "**" + text + "**"
+	case "code":
+		text = "`" + text + "`"
+	case "a":
+		if href := htmlnode.Attribute(node, "href"); strings.HasPrefix(href, "http://") || strings.HasPrefix(href, "https://") {
+			text = fmt.Sprintf("[%s](%s)", text, href)
+		}
+	}
+	return lead + text + trail
+}
+
+// textContent returns the raw text of a node, keeping its whitespace
+func textContent(node *nethtml.Node) string {
+	if node.Type == nethtml.TextNode {
+		return node.Data
+	}
+	var text strings.Builder
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		text.WriteString(textContent(child))
+	}
+	return text.String()
+}
+
+// prefixLines prefixes every line of a block
+func prefixLines(block, prefix string) string {
+	lines := strings.Split(block, "\n")
+	for i, line := range lines {
+		lines[i] = prefix + line
+	}
+	return strings.Join(lines, "\n")
+}
+
+// header renders the metadata header of a chapter file: YAML front matter for
+// Markdown, "Field: value" lines for text
+func header(book *parser.Book, index int, chapter parser.Chapter, format string) string {
+	fields := [][2]string{
+		{"title", book.Metadata.Title},
+		{"author", book.Metadata.Creator},
+		{"language", book.Metadata.Language},
+		{"identifier", book.Metadata.Identifier},
+		{"publisher", book.Metadata.Publisher},
+		{"date", book.Metadata.Date},
+		{"chapter", strconv.Itoa(index)},
+		{"chapter_title", chapter.Title},
+	}
+
+	var out strings.Builder
+	if format == FormatMarkdown {
+		out.WriteString("---\n")
+		for _, field := range fields {
+			switch {
+			case field[1] == "":
+			case field[0] == "chapter":
+				fmt.Fprintf(&out, "%s: %s\n", field[0], field[1])
+			default:
+				fmt.Fprintf(&out, "%s: %s\n", field[0], strconv.Quote(field[1]))
+			}
+		}
+		out.WriteString("---\n\n")
+		return out.String()
+	}
+	for _, field := range fields {
+		if field[1] != "" {
+			name := strings.Replace(field[0], "_", " ", -1)
+			fmt.Fprintf(&out, "%s: %s\n", strings.ToUpper(name[:1])+name[1:], field[1])
+		}
+	}
+	out.WriteString("\n")
+	return out.String()
+}
+
+// slug turns a chapter title into a file name part
+func slug(title string) string {
+	var out strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(title) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			out.WriteRune(r)
+			dash = false
+		} else if !dash && out.Len() > 0 {
+			out.WriteRune('-')
+			dash = true
+		}
+	}
+	runes := []rune(strings.TrimRight(out.String(), "-"))
+	if len(runes) > 40 {
+		runes = []rune(strings.TrimRight(string(runes[:40]), "-"))
+	}
+	return string(runes)
+}
+
+// Write writes one file per chapter of the book to outputDir, each starting with
+// the book's metadata header, and returns the paths written
+func Write(book *parser.Book, outputDir, format string) ([]string, error) {
+	if format != FormatText && format != FormatMarkdown {
+		return nil, fmt.Errorf("unknown extract format %q (use text or markdown)", format)
+	}
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	extension := ".txt"
+	if format == FormatMarkdown {
+		extension = ".md"
+	}
+
+	var written []string
+	for i, chapter := range book.Chapters {
+		body, err := Chapter(chapter.Content, format)
+		if err != nil {
+			return written, fmt.Errorf("failed to convert %s: %w", chapter.ID, err)
+		}
+
+		name := fmt.Sprintf("%03d", i+1)
+		if s := slug(chapter.Title); s != "" {
+			name += "-" + s
+		}
+		path := filepath.Join(outputDir, name+extension)
+		if err := ioutil.WriteFile(path, []byte(header(book, i+1, chapter, format)+body), 0644); err != nil {
+			return written, fmt.Errorf("failed to write %s: %w", path, err)
+		}
+		written = append(written, path)
+	}
+	return written, nil
+}
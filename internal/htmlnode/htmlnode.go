@@ -0,0 +1,66 @@
+// Package htmlnode holds the helpers shared by the converters that read parsed
+// HTML: walking the node tree, reading text, attributes and classes, and the IDs
+// of converted documents
+package htmlnode
+
+import (
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// AnchorPattern matches the characters not allowed in converted IDs
+var AnchorPattern = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// Walk visits the nodes under n in document order until visit returns false for a
+// node, which skips its children
+func Walk(n *html.Node, visit func(*html.Node) bool) {
+	for child := n.FirstChild; child != nil; {
+		next := child.NextSibling
+		if visit(child) {
+			Walk(child, visit)
+		}
+		child = next
+	}
+}
+
+// Text returns the text of a node and the nodes under it
+func Text(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var out strings.Builder
+	Walk(n, func(child *html.Node) bool {
+		if child.Type == html.TextNode {
+			out.WriteString(child.Data)
+		}
+		return true
+	})
+	return out.String()
+}
+
+// IsHeading reports whether a node is a heading element
+func IsHeading(n *html.Node) bool {
+	return n.Type == html.ElementNode && len(n.Data) == 2 && n.Data[0] == 'h' && n.Data[1] >= '1' && n.Data[1] <= '6'
+}
+
+// Attribute returns the value of an attribute of a node
+func Attribute(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+// HasClass reports whether a node has a class
+func HasClass(n *html.Node, class string) bool {
+	for _, name := range strings.Fields(Attribute(n, "class")) {
+		if name == class {
+			return true
+		}
+	}
+	return false
+}
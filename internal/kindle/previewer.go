@@ -0,0 +1,121 @@
+// Package kindle runs Kindle Previewer on EPUB files and collects the warnings and
+// errors of its Kindle conversion
+package kindle
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"github.com/flouciel/folian-parser/internal/epubcheck"
+)
+
+// PreviewerEnv names the environment variable holding the path of the Kindle
+// Previewer executable, when it is not installed in the usual place
+const PreviewerEnv = "KINDLE_PREVIEWER"
+
+// ValidatorPreviewer names Kindle Previewer in reports
+const ValidatorPreviewer = "kindle-previewer"
+
+// previewerCommands are the names Kindle Previewer is looked up by in the PATH
+var previewerCommands = []string{"kindlepreviewer", "Kindle Previewer 3"}
+
+// previewerMessage matches the conversion messages of Kindle Previewer and
+// KindleGen, e.g. "Warning(prcgen):W14001: Hyperlink not resolved: ...", with their
+// severity, converter component, code and text
+var previewerMessage = regexp.MustCompile(`\b(Warning|Error|Info)\(([^)]*)\)\s*:\s*([EWI]\d+)\s*:\s*(.+)`)
+
+// previewerLocations are the usual install locations of Kindle Previewer
+func previewerLocations() []string {
+	switch runtime.GOOS {
+	case "darwin":
+		return []string{"/Applications/Kindle Previewer 3.app/Contents/MacOS/Kindle Previewer 3"}
+	case "windows":
+		return []string{filepath.Join(os.Getenv("LOCALAPPDATA"), "Amazon", "Kindle Previewer 3", "Kindle Previewer 3.exe")}
+	}
+	return nil
+}
+
+// previewerPath returns the Kindle Previewer executable
+func previewerPath() (string, error) {
+	if path := os.Getenv(PreviewerEnv); path != "" {
+		if _, err := os.Stat(path); err != nil {
+			return "", fmt.Errorf("%s points at a missing file: %w", PreviewerEnv, err)
+		}
+		return path, nil
+	}
+	for _, name := range previewerCommands {
+		if path, err := exec.LookPath(name); err == nil {
+			return path, nil
+		}
+	}
+	for _, path := range previewerLocations() {
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("Kindle Previewer is not installed and %s is not set", PreviewerEnv)
+}
+
+// Installed reports whether Kindle Previewer can be run
+func Installed() bool {
+	_, err := previewerPath()
+	return err == nil
+}
+
+// Preview converts an EPUB with Kindle Previewer and returns the warnings and errors
+// of the conversion
+func Preview(epubPath string) (*epubcheck.Report, error) {
+	program, err := previewerPath()
+	if err != nil {
+		return nil, err
+	}
+	outputDir, err := ioutil.TempDir("", "folian-kindle-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kindle Previewer output directory: %w", err)
+	}
+	defer os.RemoveAll(outputDir)
+
+	// Kindle Previewer exits with an error status when the conversion fails, which
+	// its logs explain; the status only matters when nothing was logged
+	output, runErr := exec.Command(program, epubPath, "-convert", "-output", outputDir).CombinedOutput()
+	report := &epubcheck.Report{Validator: ValidatorPreviewer, Messages: []epubcheck.Message{}}
+	seen := make(map[string]bool)
+	collect := func(text string) {
+		scanner := bufio.NewScanner(strings.NewReader(text))
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			match := previewerMessage.FindStringSubmatch(scanner.Text())
+			if match == nil || seen[match[0]] {
+				continue
+			}
+			seen[match[0]] = true
+			report.Add(epubcheck.Message{ID: match[3], Severity: strings.ToUpper(match[1]), Text: strings.TrimSpace(match[4])})
+		}
+	}
+
+	collect(string(output))
+	filepath.Walk(outputDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".txt", ".log", ".csv":
+			if data, err := ioutil.ReadFile(path); err == nil {
+				collect(string(data))
+			}
+		}
+		return nil
+	})
+
+	if runErr != nil && len(report.Messages) == 0 {
+		return nil, fmt.Errorf("Kindle Previewer failed: %w: %s", runErr, strings.TrimSpace(string(output)))
+	}
+	return report, nil
+}
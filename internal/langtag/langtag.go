@@ -0,0 +1,45 @@
+// Package langtag reads the language tags books declare in their markup, for the
+// restructuring and the statistics to agree on the language of a chapter
+package langtag
+
+import (
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// Declared returns the language tag of an element's xml:lang attribute, or of its
+// lang attribute; blank attributes declare none
+func Declared(n *html.Node) string {
+	var lang, xmlLang string
+	for _, attr := range n.Attr {
+		switch attr.Key {
+		case "xml:lang":
+			xmlLang = strings.TrimSpace(attr.Val)
+		case "lang":
+			lang = strings.TrimSpace(attr.Val)
+		}
+	}
+	if xmlLang != "" {
+		return xmlLang
+	}
+	return lang
+}
+
+// Element returns the language tag declared by the first element of a selection
+func Element(s *goquery.Selection) string {
+	if s.Length() == 0 {
+		return ""
+	}
+	return Declared(s.Get(0))
+}
+
+// Primary returns the lowercase primary subtag of a language tag
+func Primary(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if i := strings.IndexAny(tag, "-_"); i >= 0 {
+		tag = tag[:i]
+	}
+	return tag
+}
@@ -0,0 +1,910 @@
+package latex
+
+import (
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/flouciel/folian-parser/internal/book"
+	"github.com/flouciel/folian-parser/internal/htmlnode"
+	"github.com/flouciel/folian-parser/internal/policy"
+)
+
+// Heading levels of the sectioning commands; parts and chapters both start chapters
+const (
+	levelChapter = 1
+	levelSection = 2
+)
+
+// sectionLevels are the heading levels of the sectioning commands
+var sectionLevels = map[string]int{
+	"part": 1, "chapter": 1, "section": 2, "subsection": 3, "subsubsection": 4, "paragraph": 5, "subparagraph": 6,
+}
+
+// ignoredCommands are layout and bookkeeping commands dropped with their arguments
+var ignoredCommands = map[string]int{
+	"maketitle": 0, "tableofcontents": 0, "listoffigures": 0, "listoftables": 0, "newpage": 0, "clearpage": 0,
+	"cleardoublepage": 0, "pagebreak": 0, "frontmatter": 0, "mainmatter": 0, "backmatter": 0, "noindent": 0,
+	"indent": 0, "centering": 0, "raggedright": 0, "raggedleft": 0, "bigskip": 0, "medskip": 0, "smallskip": 0,
+	"vfill": 0, "hfill": 0, "null": 0, "linebreak": 0, "nolinebreak": 0, "protect": 0, "relax": 0, "sloppy": 0,
+	"normalsize": 0, "small": 0, "footnotesize": 0, "scriptsize": 0, "tiny": 0, "large": 0, "Large": 0,
+	"LARGE": 0, "huge": 0, "Huge": 0, "hline": 0, "toprule": 0, "midrule": 0, "bottomrule": 0, "printindex": 0,
+	"vspace": 1, "hspace": 1, "vskip": 0, "thispagestyle": 1, "pagestyle": 1, "pagenumbering": 1,
+	"setcounter": 2, "addtocounter": 2, "setlength": 2, "addtolength": 2, "addcontentsline": 3, "markboth": 2,
+	"markright": 1, "bibliographystyle": 1, "nocite": 1, "index": 1, "glossary": 1, "hypersetup": 1,
+	"graphicspath": 1, "cline": 1, "title": 1, "author": 1, "date": 1, "usepackage": 1, "documentclass": 1,
+	"newtheorem": 2, "captionsetup": 1, "newcommand": 0, "label": 1,
+}
+
+// textCommands map text formatting commands to their elements
+var textCommands = map[string]string{
+	"emph": "em", "textit": "em", "textsl": "em", "underline": "em", "textbf": "strong",
+	"texttt": "code", "textsuperscript": "sup", "textsubscript": "sub", "sout": "s",
+}
+
+// plainCommands are commands whose argument is kept as plain text
+var plainCommands = map[string]bool{
+	"textrm": true, "textsf": true, "textup": true, "textmd": true, "textnormal": true, "mbox": true,
+	"hbox": true, "text": true, "textsc": true, "uppercase": false, "MakeUppercase": false,
+}
+
+// declarations map the font declarations of a group, e.g. {\em ...}, to elements
+var declarations = map[string]string{
+	"em": "em", "it": "em", "itshape": "em", "sl": "em", "slshape": "em", "bf": "strong", "bfseries": "strong",
+	"tt": "code", "ttfamily": "code",
+}
+
+// symbols map text symbol commands to their characters
+var symbols = map[string]string{
+	"ldots": "…", "dots": "…", "textellipsis": "…", "textendash": "–", "textemdash": "—", "S": "§", "P": "¶",
+	"copyright": "©", "textcopyright": "©", "textregistered": "®", "texttrademark": "™", "textbackslash": `\`,
+	"textasciitilde": "~", "textasciicircum": "^", "textbar": "|", "textless": "<", "textgreater": ">",
+	"textbullet": "•", "textdegree": "°", "dag": "†", "ddag": "‡", "pounds": "£", "euro": "€", "texteuro": "€",
+	"LaTeX": "LaTeX", "TeX": "TeX", "LaTeXe": "LaTeX2ε", "ss": "ß", "ae": "æ", "AE": "Æ", "oe": "œ", "OE": "Œ",
+	"o": "ø", "O": "Ø", "aa": "å", "AA": "Å", "l": "ł", "L": "Ł", "i": "ı", "j": "ȷ", "quad": "\u2003",
+	"qquad": "\u2003\u2003", "enspace": "\u2002", "thinspace": "\u2009", "textquoteleft": "‘",
+	"textquoteright": "’", "textquotedblleft": "“", "textquotedblright": "”", "guillemotleft": "«",
+	"guillemotright": "»", "today": "",
+	"%": "%", "$": "$", "&": "&", "#": "#", "_": "_", "{": "{", "}": "}", " ": " ", ",": "\u2009", "-": "",
+	"@": "", "/": "", ";": "\u2005", ":": "\u2005", "!": "", "\n": " ",
+}
+
+// accents map accent commands to combining characters
+var accents = map[string]string{
+	"'": "\u0301", "`": "\u0300", "^": "\u0302", "\"": "\u0308", "~": "\u0303", "=": "\u0304", ".": "\u0307",
+	"c": "\u0327", "v": "\u030c", "u": "\u0306", "H": "\u030b", "k": "\u0328", "r": "\u030a", "d": "\u0323", "b": "\u0331",
+}
+
+// precomposed holds the precomposed forms of the common accented letters
+var precomposed = map[string]string{
+	"a\u0301": "á", "e\u0301": "é", "i\u0301": "í", "o\u0301": "ó", "u\u0301": "ú", "y\u0301": "ý",
+	"A\u0301": "Á", "E\u0301": "É", "I\u0301": "Í", "O\u0301": "Ó", "U\u0301": "Ú", "Y\u0301": "Ý",
+	"a\u0300": "à", "e\u0300": "è", "i\u0300": "ì", "o\u0300": "ò", "u\u0300": "ù",
+	"A\u0300": "À", "E\u0300": "È", "I\u0300": "Ì", "O\u0300": "Ò", "U\u0300": "Ù",
+	"a\u0302": "â", "e\u0302": "ê", "i\u0302": "î", "o\u0302": "ô", "u\u0302": "û",
+	"A\u0302": "Â", "E\u0302": "Ê", "I\u0302": "Î", "O\u0302": "Ô", "U\u0302": "Û",
+	"a\u0308": "ä", "e\u0308": "ë", "i\u0308": "ï", "o\u0308": "ö", "u\u0308": "ü", "y\u0308": "ÿ",
+	"A\u0308": "Ä", "E\u0308": "Ë", "I\u0308": "Ï", "O\u0308": "Ö", "U\u0308": "Ü",
+	"a\u0303": "ã", "o\u0303": "õ", "n\u0303": "ñ", "A\u0303": "Ã", "O\u0303": "Õ", "N\u0303": "Ñ",
+	"c\u0327": "ç", "C\u0327": "Ç", "a\u030a": "å", "A\u030a": "Å",
+	"c\u030c": "č", "s\u030c": "š", "z\u030c": "ž", "C\u030c": "Č", "S\u030c": "Š", "Z\u030c": "Ž",
+	"\u0131\u0301": "í", "\u0131\u0300": "ì", "\u0131\u0302": "î", "\u0131\u0308": "ï",
+}
+
+// label is the target of a \label: its anchor and the number a \ref shows
+type label struct {
+	number string
+}
+
+// referencePattern matches the placeholders of references resolved once every label
+// and bibliography entry is known
+var referencePattern = regexp.MustCompile("\x00(ref|cite):([^\x00]*)\x00")
+
+// converter converts the body of a LaTeX document to blocks
+type converter struct {
+	rootDir       string
+	graphicsPaths []string
+	// numberFrom is the heading level numbered with a single number: chapters in
+	// books and reports, sections otherwise
+	numberFrom int
+	counters   [7]int
+	appendix   bool
+	// current is the number a following \label refers to
+	current   string
+	labels    map[string]label
+	citations map[string]int
+	figures   int
+	tables    int
+	equations int
+	// equationNumber counts the numbered equations of equationChapter
+	equationNumber  int
+	equationChapter int
+	notes           map[string]string
+	images          []book.Image
+	imageKeys       map[string]string
+	warned          map[string]bool
+}
+
+// newConverter creates a converter for a project
+func newConverter(rootDir string) *converter {
+	return &converter{
+		rootDir:    rootDir,
+		numberFrom: levelSection,
+		labels:     make(map[string]label),
+		citations:  make(map[string]int),
+		notes:      make(map[string]string),
+		imageKeys:  make(map[string]string),
+		warned:     make(map[string]bool),
+	}
+}
+
+// warnOnce prints a warning the first time it is given
+func (c *converter) warnOnce(format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	if c.warned[message] {
+		return
+	}
+	c.warned[message] = true
+	policy.Warn(policy.KindOther, "%s", message)
+}
+
+// paragraphBreak matches the blank lines that separate paragraphs
+var paragraphBreak = regexp.MustCompile(`^\n[ \t]*\n`)
+
+// blocks converts LaTeX body text to blocks
+func (c *converter) blocks(s string) []book.Block {
+	var blocks []book.Block
+	var para strings.Builder
+	flush := func() {
+		if content := strings.TrimSpace(para.String()); content != "" && content != "<br/>" {
+			blocks = append(blocks, book.Block{HTML: "<p>" + content + "</p>"})
+		}
+		para.Reset()
+	}
+
+	for i := 0; i < len(s); {
+		if loc := paragraphBreak.FindStringIndex(s[i:]); loc != nil {
+			flush()
+			i += loc[1]
+			continue
+		}
+		if s[i] != '\\' {
+			html, next := c.inlineAt(s, i)
+			para.WriteString(html)
+			i = next
+			continue
+		}
+
+		name, next := readCommand(s, i)
+		switch {
+		case sectionLevels[name] > 0:
+			flush()
+			starred := next < len(s) && s[next] == '*'
+			if starred {
+				next++
+			}
+			if _, after, ok := readOptional(s, skipSpace(s, next)); ok {
+				next = after
+			}
+			title, after := readArgument(s, next)
+			i = after
+			blocks = append(blocks, c.heading(name, title, starred))
+			// A following \label names the heading
+			if key, after, ok := c.followingLabel(s, i); ok {
+				c.labels[key] = label{number: c.current}
+				blocks[len(blocks)-1].Attrs = fmt.Sprintf(` id="%s"`, anchorID(key))
+				i = after
+			}
+		case name == "begin":
+			env, after := readArgument(s, next)
+			env = strings.TrimSpace(env)
+			end, afterEnd, ok := findEnd(s, after, env)
+			if !ok {
+				c.warnOnce("The LaTeX environment %s is not closed", env)
+				i = after
+				continue
+			}
+			if inline, ok := c.inlineEnvironment(env, s[after:end]); ok {
+				para.WriteString(inline)
+			} else {
+				flush()
+				blocks = append(blocks, c.environment(env, s[after:end])...)
+			}
+			i = afterEnd
+		case name == "par":
+			flush()
+			i = next
+		case name == "appendix":
+			c.appendix = true
+			c.counters = [7]int{}
+			i = next
+		case name == "bibliography":
+			_, after := readArgument(s, next)
+			c.warnOnce("BibTeX bibliographies are not read; citations keep their keys")
+			i = after
+		default:
+			html, after := c.inlineAt(s, i)
+			para.WriteString(html)
+			i = after
+		}
+	}
+	flush()
+	return blocks
+}
+
+// followingLabel reads a \label that directly follows position i
+func (c *converter) followingLabel(s string, i int) (string, int, bool) {
+	j := skipSpace(s, i)
+	if !strings.HasPrefix(s[j:], `\label`) {
+		return "", i, false
+	}
+	key, after := readArgument(s, j+len(`\label`))
+	return strings.TrimSpace(key), after, true
+}
+
+// heading converts a sectioning command, numbering it as LaTeX would
+func (c *converter) heading(command, title string, starred bool) book.Block {
+	level := sectionLevels[command]
+	content := c.inline(title)
+	number := ""
+	if command == "part" {
+		c.current = ""
+	} else if !starred {
+		c.counters[level]++
+		for deeper := level + 1; deeper < len(c.counters); deeper++ {
+			c.counters[deeper] = 0
+		}
+		var parts []string
+		for l := c.numberFrom; l <= level; l++ {
+			if l == c.numberFrom && c.appendix {
+				parts = append(parts, string(rune('A'+c.counters[l]-1)))
+			} else {
+				parts = append(parts, strconv.Itoa(c.counters[l]))
+			}
+		}
+		number = strings.Join(parts, ".")
+		c.current = number
+	}
+
+	// Chapters keep their own title; numbers are shown on the sections in them
+	plainTitle := c.plain(title)
+	if number != "" && level > c.numberFrom {
+		content = number + " " + content
+		plainTitle = number + " " + plainTitle
+	}
+	return book.Block{Level: level, Title: plainTitle, HTML: content}
+}
+
+// inline converts LaTeX inline text to XHTML
+func (c *converter) inline(s string) string {
+	var out strings.Builder
+	for i := 0; i < len(s); {
+		html, next := c.inlineAt(s, i)
+		out.WriteString(html)
+		i = next
+	}
+	return strings.TrimSpace(out.String())
+}
+
+// plain returns the text of LaTeX inline text, without markup
+func (c *converter) plain(s string) string {
+	text := regexp.MustCompile(`<[^>]+>`).ReplaceAllString(c.inline(s), "")
+	text = referencePattern.ReplaceAllString(text, "")
+	return strings.TrimSpace(html.UnescapeString(text))
+}
+
+// inlineAt converts the inline element at s[i]: text, a command, a group or math, and
+// returns its XHTML and the position after it
+func (c *converter) inlineAt(s string, i int) (string, int) {
+	switch s[i] {
+	case '\\':
+		return c.command(s, i)
+	case '$':
+		display := strings.HasPrefix(s[i:], "$$")
+		delimiter := "$"
+		if display {
+			delimiter = "$$"
+		}
+		start := i + len(delimiter)
+		end := indexUnescaped(s, delimiter, start)
+		if end < 0 {
+			return "$", i + 1
+		}
+		return c.math(s[start:end], display), end + len(delimiter)
+	case '{':
+		content, next, ok := readGroup(s, i)
+		if !ok {
+			return "", i + 1
+		}
+		return c.group(content), next
+	case '}':
+		return "", i + 1
+	case '~':
+		return "\u00a0", i + 1
+	case '\n', '\t':
+		return " ", i + 1
+	case '-':
+		switch {
+		case strings.HasPrefix(s[i:], "---"):
+			return "—", i + 3
+		case strings.HasPrefix(s[i:], "--"):
+			return "–", i + 2
+		}
+	case '`':
+		if strings.HasPrefix(s[i:], "``") {
+			return "“", i + 2
+		}
+		return "‘", i + 1
+	case '\'':
+		if strings.HasPrefix(s[i:], "''") {
+			return "”", i + 2
+		}
+		return "’", i + 1
+	case '&':
+		return "&amp;", i + 1
+	case '<':
+		return "&lt;", i + 1
+	case '>':
+		return "&gt;", i + 1
+	case '"':
+		return "&quot;", i + 1
+	}
+	// Copy the run of ordinary text
+	j := i + 1
+	for j < len(s) && !strings.ContainsRune("\\${}~\n\t-`'&<>\"", rune(s[j])) {
+		j++
+	}
+	return s[i:j], j
+}
+
+// indexUnescaped returns the position of the first unescaped delimiter from start
+func indexUnescaped(s, delimiter string, start int) int {
+	for j := start; j < len(s); j++ {
+		if s[j] == '\\' {
+			j++
+			continue
+		}
+		if strings.HasPrefix(s[j:], delimiter) {
+			return j
+		}
+	}
+	return -1
+}
+
+// group converts a braced group, applying a font declaration at its start
+func (c *converter) group(content string) string {
+	trimmed := strings.TrimLeft(content, " \n")
+	if strings.HasPrefix(trimmed, `\`) {
+		name, next := readCommand(trimmed, 0)
+		if tag, ok := declarations[name]; ok {
+			return "<" + tag + ">" + c.inline(trimmed[next:]) + "</" + tag + ">"
+		}
+	}
+	var out strings.Builder
+	for i := 0; i < len(content); {
+		html, next := c.inlineAt(content, i)
+		out.WriteString(html)
+		i = next
+	}
+	return out.String()
+}
+
+// command converts the command at s[i]
+func (c *converter) command(s string, i int) (string, int) {
+	name, next := readCommand(s, i)
+	if next < len(s) && s[next] == '*' && isLetter(name[0]) {
+		next++
+	}
+
+	if accent, ok := accents[name]; ok && (len(name) == 1 && !isLetter(name[0]) || next < len(s) && (s[next] == '{' || s[next] == ' ')) {
+		letter, after := readArgument(s, next)
+		letter = strings.TrimPrefix(letter, `\`)
+		if letter == "i" || letter == "j" {
+			letter = symbols[letter]
+		}
+		if composed, ok := precomposed[letter+accent]; ok {
+			return composed, after
+		}
+		return html.EscapeString(letter) + accent, after
+	}
+	if symbol, ok := symbols[name]; ok {
+		// A control word swallows the space after it
+		if isLetter(name[0]) && next < len(s) && s[next] == ' ' {
+			next++
+		}
+		if strings.HasPrefix(s[next:], "{}") {
+			next += 2
+		}
+		return html.EscapeString(symbol), next
+	}
+	if tag, ok := textCommands[name]; ok {
+		content, after := readArgument(s, next)
+		return "<" + tag + ">" + c.inline(content) + "</" + tag + ">", after
+	}
+	if plainCommands[name] {
+		content, after := readArgument(s, next)
+		return c.inline(content), after
+	}
+	if args, ok := ignoredCommands[name]; ok {
+		after := next
+		if _, end, ok := readOptional(s, skipSpace(s, after)); ok {
+			after = end
+		}
+		for n := 0; n < args; n++ {
+			_, after = readArgument(s, after)
+		}
+		if name == "label" {
+			key, _ := readArgument(s, next)
+			key = strings.TrimSpace(key)
+			c.labels[key] = label{number: c.current}
+			return fmt.Sprintf(`<a id="%s"></a>`, anchorID(key)), after
+		}
+		return "", after
+	}
+
+	switch name {
+	case `\`, "newline":
+		if _, after, ok := readOptional(s, next); ok {
+			next = after
+		}
+		return "<br/>", next
+	case "(", "[":
+		closing := `\)`
+		if name == "[" {
+			closing = `\]`
+		}
+		end := strings.Index(s[next:], closing)
+		if end < 0 {
+			return "", next
+		}
+		return c.math(s[next:next+end], name == "["), next + end + len(closing)
+	case "footnote":
+		if _, after, ok := readOptional(s, next); ok {
+			next = after
+		}
+		content, after := readArgument(s, next)
+		number := len(c.notes) + 1
+		id := fmt.Sprintf("fn%d", number)
+		c.notes[id] = "<p>" + c.inline(content) + "</p>\n"
+		return fmt.Sprintf(`<a epub:type="noteref" href="#%s" id="ref-%s"><sup>%d</sup></a>`, id, id, number), after
+	case "url":
+		content, after := readArgument(s, next)
+		url := html.EscapeString(strings.TrimSpace(content))
+		return fmt.Sprintf(`<a href="%s">%s</a>`, url, url), after
+	case "href":
+		target, after := readArgument(s, next)
+		content, after := readArgument(s, after)
+		return fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(strings.TrimSpace(target)), c.inline(content)), after
+	case "ref", "autoref", "cref", "Cref", "pageref", "eqref", "nameref":
+		key, after := readArgument(s, next)
+		placeholder := "\x00ref:" + strings.TrimSpace(key) + "\x00"
+		if name == "eqref" {
+			placeholder = "(" + placeholder + ")"
+		}
+		return placeholder, after
+	case "cite", "citep", "citet", "parencite", "textcite", "autocite":
+		note := ""
+		if opt, after, ok := readOptional(s, next); ok {
+			next, note = after, opt
+			if _, after, ok := readOptional(s, next); ok {
+				next = after
+			}
+		}
+		keys, after := readArgument(s, next)
+		var refs []string
+		for _, key := range strings.Split(keys, ",") {
+			refs = append(refs, "\x00cite:"+strings.TrimSpace(key)+"\x00")
+		}
+		if note != "" {
+			refs[len(refs)-1] += ", " + c.inline(note)
+		}
+		return "[" + strings.Join(refs, ", ") + "]", after
+	case "includegraphics":
+		if _, after, ok := readOptional(s, next); ok {
+			next = after
+		}
+		file, after := readArgument(s, next)
+		return c.image(strings.TrimSpace(file), ""), after
+	case "verb":
+		if next < len(s) {
+			delimiter := s[next]
+			if end := strings.IndexByte(s[next+1:], delimiter); end >= 0 {
+				return "<code>" + html.EscapeString(s[next+1:next+1+end]) + "</code>", next + end + 2
+			}
+		}
+		return "", next
+	case "caption":
+		// Captions are set by their float
+		if _, after, ok := readOptional(s, next); ok {
+			next = after
+		}
+		_, after := readArgument(s, next)
+		return "", after
+	case "MakeUppercase", "uppercase", "MakeLowercase", "lowercase":
+		content, after := readArgument(s, next)
+		if strings.Contains(name, "pper") {
+			return strings.ToUpper(c.inline(content)), after
+		}
+		return strings.ToLower(c.inline(content)), after
+	case "item":
+		// An item outside a list starts a line
+		if label, after, ok := readOptional(s, next); ok {
+			return "<br/>" + c.inline(label) + " ", after
+		}
+		return "<br/>", next
+	}
+
+	// Unknown commands keep the text of their arguments
+	c.warnOnce("Unsupported LaTeX command \\%s, keeping its text", name)
+	var out strings.Builder
+	after := next
+	if _, end, ok := readOptional(s, after); ok {
+		after = end
+	}
+	for {
+		content, end, ok := readGroup(s, after)
+		if !ok {
+			break
+		}
+		out.WriteString(c.inline(content))
+		after = end
+	}
+	return out.String(), after
+}
+
+// imageExtensions are the extensions tried for an \includegraphics without one
+var imageExtensions = []string{".png", ".jpg", ".jpeg", ".svg", ".gif", ".webp"}
+
+// image copies an included graphic into the book once and returns its img element,
+// or "" when it cannot be shown
+func (c *converter) image(file, alt string) string {
+	if name, ok := c.imageKeys[file]; ok {
+		if name == "" {
+			return ""
+		}
+		return fmt.Sprintf(`<img src="../images/%s" alt="%s"/>`, name, html.EscapeString(alt))
+	}
+	c.imageKeys[file] = ""
+
+	var found string
+	for _, dir := range append([]string{c.rootDir}, c.graphicsPaths...) {
+		candidate := filepath.Join(dir, filepath.FromSlash(file))
+		candidates := []string{candidate}
+		if filepath.Ext(file) == "" {
+			candidates = nil
+			for _, ext := range imageExtensions {
+				candidates = append(candidates, candidate+ext)
+			}
+		}
+		for _, path := range candidates {
+			if _, err := os.Stat(path); err == nil {
+				found = path
+				break
+			}
+		}
+		if found != "" {
+			break
+		}
+	}
+	if found == "" {
+		c.warnOnce("Skipping the missing or unsupported figure %s (use PNG, JPEG or SVG)", file)
+		return ""
+	}
+	ext := strings.ToLower(filepath.Ext(found))
+	mediaType, ok := book.ImageTypes[ext]
+	if !ok {
+		c.warnOnce("Skipping the %s figure %s, which EPUB readers cannot show (use PNG, JPEG or SVG)", strings.ToUpper(strings.TrimPrefix(ext, ".")), file)
+		return ""
+	}
+	data, err := os.ReadFile(found)
+	if err != nil {
+		c.warnOnce("Skipping the unreadable figure %s: %v", file, err)
+		return ""
+	}
+	name := fmt.Sprintf("figure_%03d%s", len(c.images)+1, ext)
+	c.images = append(c.images, book.Image{Name: name, MediaType: mediaType, Data: data})
+	c.imageKeys[file] = name
+	return fmt.Sprintf(`<img src="../images/%s" alt="%s"/>`, name, html.EscapeString(alt))
+}
+
+// listEnvironments map the list environments to their elements
+var listEnvironments = map[string]string{"itemize": "ul", "enumerate": "ol", "description": "dl"}
+
+// mathEnvironments are the display math environments; the numbered ones are listed true
+var mathEnvironments = map[string]bool{
+	"equation": true, "equation*": false, "displaymath": false, "math": false, "align": true, "align*": false,
+	"gather": true, "gather*": false, "multline": true, "multline*": false, "eqnarray": true, "eqnarray*": false,
+	"flalign": true, "flalign*": false, "alignat": true, "alignat*": false,
+}
+
+// inlineEnvironment converts the environments that are part of a paragraph
+func (c *converter) inlineEnvironment(env, content string) (string, bool) {
+	switch env {
+	case "math":
+		return c.math(content, false), true
+	}
+	return "", false
+}
+
+// environment converts a block environment
+func (c *converter) environment(env, content string) []book.Block {
+	if numbered, ok := mathEnvironments[env]; ok {
+		return []book.Block{c.displayMath(env, content, numbered)}
+	}
+	if tag, ok := listEnvironments[env]; ok {
+		return []book.Block{{HTML: c.list(tag, content)}}
+	}
+
+	switch env {
+	case "quote", "quotation", "abstract":
+		return []book.Block{{HTML: "<blockquote>" + joinBlocks(c.blocks(content)) + "</blockquote>"}}
+	case "verse":
+		var stanzas []string
+		for _, stanza := range regexp.MustCompile(`\n[ \t]*\n`).Split(strings.TrimSpace(content), -1) {
+			var lines []string
+			for _, line := range splitTop(stanza, `\\`) {
+				if line = c.inline(line); line != "" {
+					lines = append(lines, "<p>"+line+"</p>")
+				}
+			}
+			if len(lines) > 0 {
+				stanzas = append(stanzas, `<div class="stanza">`+strings.Join(lines, "")+"</div>")
+			}
+		}
+		return []book.Block{{HTML: `<div class="poem">` + strings.Join(stanzas, "") + "</div>"}}
+	case "center", "flushright", "flushleft":
+		class := map[string]string{"center": "center", "flushright": "right", "flushleft": "nonindent"}[env]
+		blocks := c.blocks(content)
+		for i := range blocks {
+			blocks[i].HTML = strings.Replace(blocks[i].HTML, "<p>", `<p class="`+class+`">`, 1)
+		}
+		return blocks
+	case "figure", "figure*", "wrapfigure", "table", "table*":
+		return []book.Block{c.float(env, content)}
+	case "tabular", "tabular*", "tabularx", "longtable":
+		return []book.Block{{HTML: c.table(env, content)}}
+	case "verbatim", "verbatim*", "lstlisting", "Verbatim":
+		return []book.Block{{HTML: "<pre>" + html.EscapeString(strings.Trim(stripOptional(content), "\n")) + "</pre>"}}
+	case "minted":
+		_, after := readArgument(content, 0)
+		return []book.Block{{HTML: "<pre>" + html.EscapeString(strings.Trim(content[after:], "\n")) + "</pre>"}}
+	case "comment":
+		return nil
+	case "thebibliography":
+		return c.bibliography(content)
+	case "document", "minipage", "titlepage", "small", "footnotesize", "landscape", "samepage", "multicols":
+		if env == "minipage" || env == "multicols" {
+			_, after := readArgument(content, skipSpace(content, 0))
+			if _, end, ok := readOptional(content, 0); ok {
+				_, after = readArgument(content, end)
+			}
+			content = content[after:]
+		}
+		return c.blocks(content)
+	}
+
+	// Theorem-like and unknown environments keep their content
+	c.warnOnce("Unsupported LaTeX environment %s, keeping its content", env)
+	return c.blocks(stripOptional(content))
+}
+
+// stripOptional removes the optional argument at the start of an environment's content
+func stripOptional(content string) string {
+	if _, after, ok := readOptional(content, 0); ok {
+		return content[after:]
+	}
+	return content
+}
+
+// joinBlocks joins the XHTML of converted blocks, setting headings as bold paragraphs
+func joinBlocks(blocks []book.Block) string {
+	var out strings.Builder
+	for _, b := range blocks {
+		if b.Level > 0 {
+			out.WriteString(`<p class="nonindent"><strong>` + b.HTML + "</strong></p>")
+			continue
+		}
+		out.WriteString(b.HTML)
+	}
+	return out.String()
+}
+
+// unwrapParagraph returns the content of converted text that is a single paragraph
+func unwrapParagraph(content string) string {
+	if strings.HasPrefix(content, "<p>") && strings.HasSuffix(content, "</p>") && strings.Count(content, "<p>") == 1 {
+		return strings.TrimSuffix(strings.TrimPrefix(content, "<p>"), "</p>")
+	}
+	return content
+}
+
+// list converts an itemize, enumerate or description environment
+func (c *converter) list(tag, content string) string {
+	var out strings.Builder
+	out.WriteString("<" + tag + ">")
+	for n, item := range splitTop(stripOptional(content), `\item`) {
+		if n == 0 {
+			// Text before the first item is not part of the list
+			continue
+		}
+		term := ""
+		if opt, after, ok := readOptional(item, skipSpace(item, 0)); ok {
+			term, item = c.inline(opt), item[after:]
+		}
+		body := unwrapParagraph(joinBlocks(c.blocks(strings.TrimSpace(item))))
+		if tag == "dl" {
+			out.WriteString("<dt>" + term + "</dt><dd>" + body + "</dd>")
+		} else {
+			out.WriteString("<li>" + body + "</li>")
+		}
+	}
+	out.WriteString("</" + tag + ">")
+	return out.String()
+}
+
+// float converts a figure or table with its caption, numbering it as LaTeX would
+func (c *converter) float(env, content string) book.Block {
+	content = stripOptional(content)
+	if strings.HasPrefix(env, "wrapfigure") {
+		_, after := readArgument(content, 0)
+		content = content[after:]
+	}
+	kind, number := "Figure", 0
+	if strings.HasPrefix(env, "table") {
+		c.tables++
+		kind, number = "Table", c.tables
+	} else {
+		c.figures++
+		number = c.figures
+	}
+	c.current = strconv.Itoa(number)
+
+	// The caption is the alt text of the figure's images
+	caption := ""
+	if loc := regexp.MustCompile(`\\caption\s*(?:\[[^\]]*\])?\s*\{`).FindStringIndex(content); loc != nil {
+		caption, _, _ = readGroup(content, loc[1]-1)
+	}
+	id := ""
+	if loc := regexp.MustCompile(`\\label\s*\{([^}]*)\}`).FindStringSubmatch(content); loc != nil {
+		c.labels[strings.TrimSpace(loc[1])] = label{number: c.current}
+		id = fmt.Sprintf(` id="%s"`, anchorID(strings.TrimSpace(loc[1])))
+		content = strings.Replace(content, loc[0], "", 1)
+	}
+	// Images are set after the content is converted, marked by their index
+	var figures []string
+	content = regexp.MustCompile(`\\includegraphics\s*(?:\[[^\]]*\])?\s*\{([^}]*)\}`).ReplaceAllStringFunc(content, func(command string) string {
+		file := regexp.MustCompile(`\{([^}]*)\}`).FindStringSubmatch(command)[1]
+		figures = append(figures, c.image(strings.TrimSpace(file), c.plain(caption)))
+		return fmt.Sprintf("\x01%d\x01", len(figures)-1)
+	})
+
+	body := joinBlocks(c.blocks(content))
+	body = regexp.MustCompile("\x01([0-9]+)\x01").ReplaceAllStringFunc(body, func(marker string) string {
+		n, _ := strconv.Atoi(strings.Trim(marker, "\x01"))
+		return figures[n]
+	})
+	if caption != "" {
+		body += fmt.Sprintf("<figcaption>%s %d: %s</figcaption>", kind, number, c.inline(caption))
+	}
+	return book.Block{HTML: fmt.Sprintf("<figure%s>%s</figure>", id, body)}
+}
+
+// ruleCommands are the horizontal rules of tables, dropped from their rows
+var ruleCommands = regexp.MustCompile(`\\(hline|toprule|midrule|bottomrule|endhead|endfirsthead|endfoot|endlastfoot)\b|\\(cline|cmidrule)(\([^)]*\))?\s*\{[^}]*\}`)
+
+// table converts a tabular environment
+func (c *converter) table(env, content string) string {
+	if env == "tabular*" || env == "tabularx" {
+		_, after := readArgument(content, 0)
+		content = content[after:]
+	}
+	content = stripOptional(content)
+	_, after := readArgument(content, 0)
+	content = ruleCommands.ReplaceAllString(content[after:], "")
+
+	var out strings.Builder
+	out.WriteString("<table>")
+	for _, row := range splitTop(content, `\\`) {
+		row = strings.TrimSpace(row)
+		if _, after, ok := readOptional(row, 0); ok {
+			row = strings.TrimSpace(row[after:])
+		}
+		if row == "" {
+			continue
+		}
+		out.WriteString("<tr>")
+		for _, cell := range splitTop(row, "&") {
+			cell = strings.TrimSpace(cell)
+			span := ""
+			if strings.HasPrefix(cell, `\multicolumn`) {
+				count, after := readArgument(cell, len(`\multicolumn`))
+				_, after = readArgument(cell, after)
+				text, _ := readArgument(cell, after)
+				span = fmt.Sprintf(` colspan="%s"`, strings.TrimSpace(count))
+				cell = text
+			}
+			out.WriteString("<td" + span + ">" + c.inline(cell) + "</td>")
+		}
+		out.WriteString("</tr>")
+	}
+	out.WriteString("</table>")
+	return out.String()
+}
+
+// bibliography converts a thebibliography environment to a numbered list under its
+// own heading
+func (c *converter) bibliography(content string) []book.Block {
+	_, after := readArgument(content, 0)
+	title := "References"
+	if c.numberFrom == levelChapter {
+		title = "Bibliography"
+	}
+	var out strings.Builder
+	out.WriteString(`<ol class="bibliography">`)
+	for n, item := range splitTop(content[after:], `\bibitem`) {
+		if n == 0 {
+			continue
+		}
+		if _, end, ok := readOptional(item, skipSpace(item, 0)); ok {
+			item = item[end:]
+		}
+		key, end := readArgument(item, 0)
+		key = strings.TrimSpace(key)
+		c.citations[key] = n
+		out.WriteString(fmt.Sprintf(`<li id="%s">%s</li>`, anchorID("bib-"+key), c.inline(item[end:])))
+	}
+	out.WriteString("</ol>")
+	return []book.Block{{Level: c.numberFrom, Title: title, HTML: title}, {HTML: out.String()}}
+}
+
+// resolve replaces the reference placeholders of the converted blocks
+func (c *converter) resolve(blocks []book.Block) []book.Block {
+	for i := range blocks {
+		blocks[i].HTML = c.resolveText(blocks[i].HTML)
+		blocks[i].Title = referencePattern.ReplaceAllStringFunc(blocks[i].Title, func(placeholder string) string {
+			match := referencePattern.FindStringSubmatch(placeholder)
+			if match[1] == "ref" {
+				return c.labels[match[2]].number
+			}
+			return match[2]
+		})
+	}
+	return blocks
+}
+
+// resolveText replaces the reference placeholders of converted text with links to
+// the labels and bibliography entries they name
+func (c *converter) resolveText(text string) string {
+	return referencePattern.ReplaceAllStringFunc(text, func(placeholder string) string {
+		match := referencePattern.FindStringSubmatch(placeholder)
+		key := match[2]
+		if match[1] == "cite" {
+			if n, ok := c.citations[key]; ok {
+				return fmt.Sprintf(`<a href="#%s">%d</a>`, anchorID("bib-"+key), n)
+			}
+			return html.EscapeString(key)
+		}
+		target, ok := c.labels[key]
+		if !ok {
+			c.warnOnce("Undefined LaTeX reference %s", key)
+			return "??"
+		}
+		number := target.number
+		if number == "" {
+			number = "↗"
+		}
+		return fmt.Sprintf(`<a href="#%s">%s</a>`, anchorID(key), number)
+	})
+}
+
+// anchorID turns a label into an XML ID
+func anchorID(key string) string {
+	key = htmlnode.AnchorPattern.ReplaceAllString(key, "-")
+	if key == "" || !isLetter(key[0]) && key[0] != '_' {
+		key = "l" + key
+	}
+	return key
+}
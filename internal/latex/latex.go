@@ -0,0 +1,359 @@
+// Package latex reads LaTeX projects, the main .tex file with the files it includes,
+// and writes them as extracted EPUBs: \chapter (or \section) starts a chapter, the
+// usual environments map to the theme classes, and math is rendered to MathML
+package latex
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/flouciel/folian-parser/internal/book"
+	"github.com/flouciel/folian-parser/internal/policy"
+)
+
+// maxIncludeDepth is how deeply \input and \include are followed
+const maxIncludeDepth = 16
+
+// Document is the outcome of converting a LaTeX project
+type Document struct {
+	Title     string
+	Author    string
+	Chapters  int
+	Images    int
+	Equations int
+}
+
+// IsLaTeX reports whether a path names the main file of a LaTeX project
+func IsLaTeX(filePath string) bool {
+	return strings.EqualFold(filepath.Ext(filePath), ".tex")
+}
+
+// includePattern matches the commands that include another file of the project
+var includePattern = regexp.MustCompile(`\\(input|include|subfile)\s*\{([^}]+)\}`)
+
+// verbatimEnvironments are the environments whose content is not LaTeX
+var verbatimEnvironments = []string{"verbatim", "verbatim*", "lstlisting", "minted", "Verbatim", "comment"}
+
+// readProject reads a file of the project with its comments removed and the files it
+// includes inlined, relative to the directory of the main file
+func readProject(filePath, rootDir string, depth int) (string, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", filePath, err)
+	}
+	text := stripComments(strings.ReplaceAll(string(data), "\r\n", "\n"))
+	if depth >= maxIncludeDepth {
+		return text, nil
+	}
+
+	var failure error
+	text = includePattern.ReplaceAllStringFunc(text, func(command string) string {
+		name := strings.TrimSpace(includePattern.FindStringSubmatch(command)[2])
+		includePath := filepath.Join(rootDir, filepath.FromSlash(name))
+		if filepath.Ext(includePath) == "" {
+			includePath += ".tex"
+		}
+		if _, err := os.Stat(includePath); err != nil {
+			policy.Warn(policy.KindOther, "Skipping the missing LaTeX file %s", name)
+			return ""
+		}
+		included, err := readProject(includePath, rootDir, depth+1)
+		if err != nil {
+			failure = err
+			return ""
+		}
+		// \include starts a new page, and the included text a new paragraph
+		return "\n" + included + "\n"
+	})
+	return text, failure
+}
+
+// stripComments removes the % comments of LaTeX source, keeping escaped percent
+// signs and the content of verbatim environments
+func stripComments(text string) string {
+	lines := strings.Split(text, "\n")
+	verbatim := ""
+	for i, line := range lines {
+		if verbatim != "" {
+			if strings.Contains(line, `\end{`+verbatim+`}`) {
+				verbatim = ""
+			}
+			continue
+		}
+		for _, env := range verbatimEnvironments {
+			if strings.Contains(line, `\begin{`+env+`}`) && !strings.Contains(line, `\end{`+env+`}`) {
+				verbatim = env
+			}
+		}
+		for j := 0; j < len(line); j++ {
+			if line[j] == '\\' {
+				j++
+				continue
+			}
+			if line[j] == '%' {
+				// A comment also swallows the line break, joining the next line
+				lines[i] = line[:j] + "\x00"
+				break
+			}
+		}
+	}
+	text = strings.Join(lines, "\n")
+	text = regexp.MustCompile("\x00\n[ \t]*").ReplaceAllString(text, "")
+	return strings.ReplaceAll(text, "\x00", "")
+}
+
+// languages maps babel and polyglossia language names to language codes
+var languages = map[string]string{
+	"english": "en", "american": "en-US", "british": "en-GB", "usenglish": "en-US", "ukenglish": "en-GB",
+	"french": "fr", "francais": "fr", "german": "de", "ngerman": "de", "spanish": "es", "italian": "it",
+	"portuguese": "pt", "brazilian": "pt-BR", "dutch": "nl", "polish": "pl", "russian": "ru", "czech": "cs",
+	"swedish": "sv", "danish": "da", "norsk": "nb", "finnish": "fi", "greek": "el", "turkish": "tr",
+	"vietnamese": "vi", "japanese": "ja", "chinese": "zh", "catalan": "ca", "hungarian": "hu",
+}
+
+// babelPattern and polyglossiaPattern match the language selection of the preamble
+var (
+	babelPattern        = regexp.MustCompile(`\\usepackage\s*\[([^\]]*)\]\s*\{babel\}`)
+	polyglossiaPattern  = regexp.MustCompile(`\\set(?:default|main)language\s*(?:\[[^\]]*\])?\s*\{([^}]+)\}`)
+	classPattern        = regexp.MustCompile(`\\documentclass\s*(?:\[[^\]]*\])?\s*\{([^}]+)\}`)
+	graphicsPathPattern = regexp.MustCompile(`\\graphicspath\s*\{((?:\{[^}]*\})+)\}`)
+)
+
+// preambleLanguage returns the language code of the document's main language
+func preambleLanguage(preamble string) string {
+	if match := polyglossiaPattern.FindStringSubmatch(preamble); match != nil {
+		return languages[strings.TrimSpace(match[1])]
+	}
+	if match := babelPattern.FindStringSubmatch(preamble); match != nil {
+		// babel's main language is the last one listed
+		options := strings.Split(match[1], ",")
+		for i := len(options) - 1; i >= 0; i-- {
+			option := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(options[i]), "main="))
+			if code, ok := languages[option]; ok {
+				return code
+			}
+		}
+	}
+	return ""
+}
+
+// Convert reads a LaTeX project from its main file and writes it as an extracted
+// EPUB in dir, which is created
+func Convert(mainPath, dir string) (*Document, error) {
+	rootDir := filepath.Dir(mainPath)
+	source, err := readProject(mainPath, rootDir, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	// Split the preamble from the body; a file without \begin{document} is all body
+	preamble, body := "", source
+	if start := strings.Index(source, `\begin{document}`); start >= 0 {
+		preamble, body = source[:start], source[start+len(`\begin{document}`):]
+		if end := strings.Index(body, `\end{document}`); end >= 0 {
+			body = body[:end]
+		}
+	}
+
+	// Expand the macros defined by the document
+	macros := parseMacros(preamble)
+	for name, macro := range parseMacros(body) {
+		macros[name] = macro
+	}
+	body = expandMacros(removeDefinitions(body), macros)
+
+	c := newConverter(rootDir)
+	if match := classPattern.FindStringSubmatch(preamble); match != nil {
+		switch strings.TrimSpace(match[1]) {
+		case "book", "report", "memoir", "scrbook", "scrreprt":
+			c.numberFrom = levelChapter
+		}
+	}
+	if match := graphicsPathPattern.FindStringSubmatch(preamble); match != nil {
+		for _, dir := range regexp.MustCompile(`\{([^}]*)\}`).FindAllStringSubmatch(match[1], -1) {
+			c.graphicsPaths = append(c.graphicsPaths, filepath.Join(rootDir, filepath.FromSlash(dir[1])))
+		}
+	}
+
+	metadata := book.Metadata{
+		Title:    c.plain(expandMacros(commandArgument(preamble+body, "title"), macros)),
+		Language: preambleLanguage(preamble),
+	}
+	if author := commandArgument(preamble+body, "author"); author != "" {
+		var authors []string
+		for _, name := range regexp.MustCompile(`\\and\b`).Split(expandMacros(author, macros), -1) {
+			// Affiliations and notes follow the name after a line break
+			name = strings.SplitN(name, `\\`, 2)[0]
+			name = regexp.MustCompile(`\\thanks\s*\{[^}]*\}`).ReplaceAllString(name, "")
+			if name = strings.TrimSpace(c.plain(name)); name != "" {
+				authors = append(authors, name)
+			}
+		}
+		metadata.Creator = strings.Join(authors, ", ")
+	}
+	if date := commandArgument(preamble+body, "date"); date != "" && !strings.Contains(date, `\today`) {
+		metadata.Date = strings.TrimSpace(c.plain(date))
+	}
+	if metadata.Title == "" {
+		base := filepath.Base(mainPath)
+		metadata.Title = strings.TrimSuffix(base, filepath.Ext(base))
+	}
+	if metadata.Language == "" {
+		metadata.Language = "en"
+	}
+	if metadata.Identifier, err = book.Identifier(mainPath); err != nil {
+		return nil, err
+	}
+
+	blocks := c.resolve(c.blocks(body))
+	for key, note := range c.notes {
+		c.notes[key] = c.resolveText(note)
+	}
+	chapters := book.Split(blocks, c.notes, metadata.Title)
+	if err := book.Write(dir, metadata, chapters, c.images); err != nil {
+		return nil, err
+	}
+	return &Document{Title: metadata.Title, Author: metadata.Creator, Chapters: len(chapters), Images: len(c.images), Equations: c.equations}, nil
+}
+
+// commandArgument returns the argument of the first use of a command, e.g. \title
+func commandArgument(source, name string) string {
+	pattern := regexp.MustCompile(`\\` + name + `\s*(?:\[[^\]]*\])?\s*\{`)
+	loc := pattern.FindStringIndex(source)
+	if loc == nil {
+		return ""
+	}
+	content, _, _ := readGroup(source, loc[1]-1)
+	return content
+}
+
+// macro is a command defined with \newcommand or \def
+type macro struct {
+	args int
+	// optional is the default of an optional first argument, when it has one
+	optional *string
+	body     string
+}
+
+// definitionPattern matches the start of a macro definition
+var definitionPattern = regexp.MustCompile(`\\(?:newcommand|renewcommand|providecommand|DeclareRobustCommand)\*?\s*\{?\\([A-Za-z@]+)\}?|\\def\s*\\([A-Za-z@]+)`)
+
+// parseMacros reads the macros defined in the source
+func parseMacros(source string) map[string]macro {
+	macros := make(map[string]macro)
+	for _, loc := range definitionPattern.FindAllStringSubmatchIndex(source, -1) {
+		name := ""
+		if loc[2] >= 0 {
+			name = source[loc[2]:loc[3]]
+		} else {
+			name = source[loc[4]:loc[5]]
+		}
+		i := skipSpace(source, loc[1])
+		m := macro{}
+		if arg, next, ok := readOptional(source, i); ok && loc[2] >= 0 {
+			fmt.Sscanf(strings.TrimSpace(arg), "%d", &m.args)
+			i = skipSpace(source, next)
+			if def, next, ok := readOptional(source, i); ok {
+				m.optional = &def
+				i = skipSpace(source, next)
+			}
+		}
+		body, _, ok := readGroup(source, i)
+		if !ok {
+			continue
+		}
+		m.body = body
+		macros[name] = m
+	}
+	return macros
+}
+
+// removeDefinitions removes the macro definitions from the body
+func removeDefinitions(source string) string {
+	var out strings.Builder
+	last := 0
+	for _, loc := range definitionPattern.FindAllStringSubmatchIndex(source, -1) {
+		if loc[0] < last {
+			continue
+		}
+		i := skipSpace(source, loc[1])
+		for {
+			_, next, ok := readOptional(source, i)
+			if !ok {
+				break
+			}
+			i = skipSpace(source, next)
+		}
+		_, next, ok := readGroup(source, i)
+		if !ok {
+			continue
+		}
+		out.WriteString(source[last:loc[0]])
+		last = next
+	}
+	out.WriteString(source[last:])
+	return out.String()
+}
+
+// expandMacros replaces the uses of the document's macros with their bodies
+func expandMacros(source string, macros map[string]macro) string {
+	if len(macros) == 0 {
+		return source
+	}
+	// Macros may use other macros; a few passes expand them without looping forever
+	for pass := 0; pass < 8; pass++ {
+		changed := false
+		var out strings.Builder
+		for i := 0; i < len(source); {
+			if source[i] != '\\' {
+				out.WriteByte(source[i])
+				i++
+				continue
+			}
+			name, next := readCommand(source, i)
+			m, ok := macros[name]
+			if !ok || name == "" {
+				out.WriteString(source[i:next])
+				i = next
+				continue
+			}
+			args := make([]string, 0, m.args)
+			if m.optional != nil {
+				if arg, after, ok := readOptional(source, skipSpace(source, next)); ok {
+					args = append(args, arg)
+					next = after
+				} else {
+					args = append(args, *m.optional)
+				}
+			}
+			for len(args) < m.args {
+				arg, after, ok := readGroup(source, skipSpace(source, next))
+				if !ok {
+					// A single token argument
+					j := skipSpace(source, next)
+					if j >= len(source) {
+						break
+					}
+					arg, after = source[j:j+1], j+1
+				}
+				args = append(args, arg)
+				next = after
+			}
+			body := m.body
+			for n := len(args); n >= 1; n-- {
+				body = strings.ReplaceAll(body, fmt.Sprintf("#%d", n), args[n-1])
+			}
+			out.WriteString(body)
+			i = next
+			changed = true
+		}
+		source = out.String()
+		if !changed {
+			break
+		}
+	}
+	return source
+}
@@ -0,0 +1,534 @@
+package latex
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+
+	"github.com/flouciel/folian-parser/internal/book"
+)
+
+// mathNamespace is the namespace of MathML elements
+const mathNamespace = "http://www.w3.org/1998/Math/MathML"
+
+// greekLetters map the Greek letter commands to their characters
+var greekLetters = map[string]string{
+	"alpha": "α", "beta": "β", "gamma": "γ", "delta": "δ", "epsilon": "ϵ", "varepsilon": "ε", "zeta": "ζ",
+	"eta": "η", "theta": "θ", "vartheta": "ϑ", "iota": "ι", "kappa": "κ", "lambda": "λ", "mu": "μ", "nu": "ν",
+	"xi": "ξ", "pi": "π", "varpi": "ϖ", "rho": "ρ", "varrho": "ϱ", "sigma": "σ", "varsigma": "ς", "tau": "τ",
+	"upsilon": "υ", "phi": "ϕ", "varphi": "φ", "chi": "χ", "psi": "ψ", "omega": "ω",
+	"Gamma": "Γ", "Delta": "Δ", "Theta": "Θ", "Lambda": "Λ", "Xi": "Ξ", "Pi": "Π", "Sigma": "Σ",
+	"Upsilon": "Υ", "Phi": "Φ", "Psi": "Ψ", "Omega": "Ω",
+}
+
+// mathIdentifiers map symbol commands that are identifiers to their characters
+var mathIdentifiers = map[string]string{
+	"infty": "∞", "partial": "∂", "nabla": "∇", "emptyset": "∅", "varnothing": "∅", "hbar": "ℏ", "ell": "ℓ",
+	"Re": "ℜ", "Im": "ℑ", "aleph": "ℵ", "wp": "℘", "imath": "ı", "jmath": "ȷ",
+}
+
+// mathOperators map symbol commands that are operators to their characters
+var mathOperators = map[string]string{
+	"times": "×", "cdot": "⋅", "div": "÷", "pm": "±", "mp": "∓", "ast": "∗", "star": "⋆", "circ": "∘",
+	"bullet": "∙", "oplus": "⊕", "otimes": "⊗", "leq": "≤", "le": "≤", "geq": "≥", "ge": "≥", "neq": "≠",
+	"ne": "≠", "approx": "≈", "equiv": "≡", "sim": "∼", "simeq": "≃", "cong": "≅", "propto": "∝",
+	"ll": "≪", "gg": "≫", "to": "→", "rightarrow": "→", "leftarrow": "←", "gets": "←",
+	"Rightarrow": "⇒", "Leftarrow": "⇐", "leftrightarrow": "↔", "Leftrightarrow": "⇔", "iff": "⟺",
+	"implies": "⟹", "mapsto": "↦", "uparrow": "↑", "downarrow": "↓", "in": "∈", "notin": "∉", "ni": "∋",
+	"subset": "⊂", "subseteq": "⊆", "supset": "⊃", "supseteq": "⊇", "cup": "∪", "cap": "∩",
+	"setminus": "∖", "forall": "∀", "exists": "∃", "nexists": "∄", "neg": "¬", "lnot": "¬", "land": "∧",
+	"wedge": "∧", "lor": "∨", "vee": "∨", "mid": "∣", "parallel": "∥", "perp": "⊥", "angle": "∠",
+	"ldots": "…", "cdots": "⋯", "vdots": "⋮", "ddots": "⋱", "dots": "…", "prime": "′",
+	"langle": "⟨", "rangle": "⟩", "lceil": "⌈", "rceil": "⌉", "lfloor": "⌊", "rfloor": "⌋",
+	"lvert": "|", "rvert": "|", "vert": "|", "lVert": "‖", "rVert": "‖", "Vert": "‖", "|": "‖",
+	"{": "{", "}": "}", "backslash": "∖", "colon": ":", "%": "%", "#": "#", "&": "&", "_": "_",
+}
+
+// largeOperators map the operators whose limits go above and below in display math
+var largeOperators = map[string]string{
+	"sum": "∑", "prod": "∏", "coprod": "∐", "bigcup": "⋃", "bigcap": "⋂", "bigoplus": "⨁",
+	"bigotimes": "⨂", "bigvee": "⋁", "bigwedge": "⋀",
+}
+
+// integrals map the integral signs, whose limits are set as scripts
+var integrals = map[string]string{"int": "∫", "iint": "∬", "iiint": "∭", "oint": "∮"}
+
+// mathFunctions are the function names set upright; the ones with limits are listed true
+var mathFunctions = map[string]bool{
+	"sin": false, "cos": false, "tan": false, "cot": false, "sec": false, "csc": false, "arcsin": false,
+	"arccos": false, "arctan": false, "sinh": false, "cosh": false, "tanh": false, "log": false, "ln": false,
+	"lg": false, "exp": false, "deg": false, "dim": false, "ker": false, "hom": false, "arg": false,
+	"lim": true, "liminf": true, "limsup": true, "max": true, "min": true, "sup": true, "inf": true,
+	"det": true, "gcd": true, "Pr": true,
+}
+
+// mathVariants map the math alphabet commands to MathML variants
+var mathVariants = map[string]string{
+	"mathbf": "bold", "boldsymbol": "bold-italic", "bm": "bold-italic", "mathbb": "double-struck",
+	"mathcal": "script", "mathscr": "script", "mathfrak": "fraktur", "mathsf": "sans-serif",
+	"mathtt": "monospace", "mathit": "italic", "mathrm": "normal",
+}
+
+// mathAccents map the accent commands to their characters; the ones set below are listed
+// in underAccents
+var mathAccents = map[string]string{
+	"hat": "^", "widehat": "^", "bar": "¯", "overline": "¯", "vec": "→", "overrightarrow": "→",
+	"dot": "˙", "ddot": "¨", "tilde": "~", "widetilde": "~", "check": "ˇ", "breve": "˘", "acute": "´",
+	"grave": "`", "underline": "_", "overbrace": "⏞", "underbrace": "⏟",
+}
+
+// underAccents are the accents set below their base
+var underAccents = map[string]bool{"underline": true, "underbrace": true}
+
+// mathSpaces map the spacing commands to widths
+var mathSpaces = map[string]string{
+	",": "0.1667em", ":": "0.2222em", ";": "0.2778em", " ": "0.25em", "quad": "1em", "qquad": "2em",
+	"thinspace": "0.1667em", "medspace": "0.2222em", "thickspace": "0.2778em", "enspace": "0.5em",
+}
+
+// mathDelimiters map the delimiter commands of \left, \right and \big to characters
+var mathDelimiters = map[string]string{
+	"{": "{", "}": "}", "langle": "⟨", "rangle": "⟩", "lceil": "⌈", "rceil": "⌉", "lfloor": "⌊",
+	"rfloor": "⌋", "|": "‖", "vert": "|", "Vert": "‖", "lvert": "|", "rvert": "|", "lVert": "‖",
+	"rVert": "‖", "backslash": "∖", "uparrow": "↑", "downarrow": "↓",
+}
+
+// matrixFences map the matrix environments to their fences
+var matrixFences = map[string][2]string{
+	"matrix": {"", ""}, "smallmatrix": {"", ""}, "pmatrix": {"(", ")"}, "bmatrix": {"[", "]"},
+	"Bmatrix": {"{", "}"}, "vmatrix": {"|", "|"}, "Vmatrix": {"‖", "‖"}, "cases": {"{", ""},
+	"aligned": {"", ""}, "split": {"", ""}, "gathered": {"", ""}, "array": {"", ""}, "alignedat": {"", ""},
+}
+
+// equationLabel matches the labels and numbering switches inside display math
+var equationLabel = regexp.MustCompile(`\\label\s*\{([^}]*)\}|\\(nonumber|notag)\b`)
+
+// math converts TeX math to a MathML element
+func (c *converter) math(tex string, display bool) string {
+	return c.mathElement(tex, tex, display)
+}
+
+// mathElement converts TeX math to a MathML element whose alternative text is alt
+func (c *converter) mathElement(tex, alt string, display bool) string {
+	c.equations++
+	mode := "inline"
+	if display {
+		mode = "block"
+	}
+	return fmt.Sprintf(`<math xmlns="%s" display="%s" alttext="%s">%s</math>`,
+		mathNamespace, mode, html.EscapeString(strings.Join(strings.Fields(alt), " ")), c.mathRow(tex, display))
+}
+
+// displayMath converts a display math environment, numbering its equation as LaTeX
+// would and naming it by its \label
+func (c *converter) displayMath(env, content string, numbered bool) book.Block {
+	id := ""
+	for _, match := range equationLabel.FindAllStringSubmatch(content, -1) {
+		if match[2] != "" {
+			numbered = false
+			continue
+		}
+		if id == "" {
+			id = strings.TrimSpace(match[1])
+		}
+	}
+	content = equationLabel.ReplaceAllString(content, "")
+	if strings.HasPrefix(env, "alignat") {
+		_, after := readArgument(content, 0)
+		content = content[after:]
+	}
+
+	// Multi-line environments are set as tables of their lines and columns
+	tex := content
+	if base := strings.TrimSuffix(env, "*"); base != "equation" && base != "displaymath" && base != "math" {
+		tex = `\begin{aligned}` + content + `\end{aligned}`
+	}
+	html := c.mathElement(tex, content, true)
+	if numbered {
+		c.current = c.nextEquation()
+		html += " (" + c.current + ")"
+	}
+	attrs := ""
+	if id != "" {
+		c.labels[id] = label{number: c.current}
+		attrs = fmt.Sprintf(` id="%s"`, anchorID(id))
+	}
+	return book.Block{HTML: fmt.Sprintf(`<p class="center"%s>%s</p>`, attrs, html)}
+}
+
+// nextEquation returns the next equation number, counted per chapter in books
+func (c *converter) nextEquation() string {
+	chapter := c.counters[levelChapter]
+	if c.numberFrom != levelChapter || chapter == 0 {
+		c.equationNumber++
+		return fmt.Sprint(c.equationNumber)
+	}
+	if c.equationChapter != chapter {
+		c.equationChapter = chapter
+		c.equationNumber = 0
+	}
+	c.equationNumber++
+	return fmt.Sprintf("%d.%d", chapter, c.equationNumber)
+}
+
+// mathRow converts TeX math to MathML content, wrapping several nodes in an mrow
+func (c *converter) mathRow(tex string, display bool) string {
+	p := &mathParser{c: c, s: tex, display: display}
+	return wrapRow(p.expression(nil))
+}
+
+// wrapRow joins MathML nodes as a single node
+func wrapRow(nodes []string) string {
+	if len(nodes) == 1 {
+		return nodes[0]
+	}
+	return "<mrow>" + strings.Join(nodes, "") + "</mrow>"
+}
+
+// mathParser reads TeX math
+type mathParser struct {
+	c       *converter
+	s       string
+	i       int
+	display bool
+}
+
+// expression reads nodes until the end of the input or until stop reports true
+func (p *mathParser) expression(stop func() bool) []string {
+	var nodes []string
+	for {
+		p.i = skipSpace(p.s, p.i)
+		if p.i >= len(p.s) || stop != nil && stop() {
+			return nodes
+		}
+		base, limits := "<mrow></mrow>", false
+		if p.s[p.i] != '^' && p.s[p.i] != '_' {
+			var ok bool
+			base, limits, ok = p.atom()
+			if !ok {
+				continue
+			}
+		}
+		nodes = append(nodes, p.scripts(base, limits))
+	}
+}
+
+// scripts reads the sub- and superscripts following a base
+func (p *mathParser) scripts(base string, limits bool) string {
+	var sub, sup string
+	for {
+		j := skipSpace(p.s, p.i)
+		if j >= len(p.s) {
+			break
+		}
+		switch p.s[j] {
+		case '_':
+			p.i = j + 1
+			sub = p.argument()
+			continue
+		case '^':
+			p.i = j + 1
+			sup = p.argument()
+			continue
+		case '\'':
+			p.i = j
+			var primes strings.Builder
+			for p.i < len(p.s) && p.s[p.i] == '\'' {
+				primes.WriteString("′")
+				p.i++
+			}
+			sup = "<mo>" + primes.String() + "</mo>"
+			continue
+		}
+		break
+	}
+
+	under, over, both := "msub", "msup", "msubsup"
+	if limits && p.display {
+		under, over, both = "munder", "mover", "munderover"
+	}
+	switch {
+	case sub != "" && sup != "":
+		return "<" + both + ">" + base + sub + sup + "</" + both + ">"
+	case sub != "":
+		return "<" + under + ">" + base + sub + "</" + under + ">"
+	case sup != "":
+		return "<" + over + ">" + base + sup + "</" + over + ">"
+	}
+	return base
+}
+
+// argument reads the argument of a command or script: a group or a single atom
+func (p *mathParser) argument() string {
+	p.i = skipSpace(p.s, p.i)
+	if content, next, ok := readGroup(p.s, p.i); ok {
+		p.i = next
+		return p.c.mathRow(content, p.display)
+	}
+	for p.i < len(p.s) {
+		if node, _, ok := p.atom(); ok {
+			return node
+		}
+	}
+	return "<mrow></mrow>"
+}
+
+// rawArgument reads the source of a command argument
+func (p *mathParser) rawArgument() string {
+	content, next := readArgument(p.s, p.i)
+	p.i = next
+	return content
+}
+
+// atom reads a single node, reporting whether its limits go above and below it; ok is
+// false for input that produces nothing
+func (p *mathParser) atom() (node string, limits bool, ok bool) {
+	ch := p.s[p.i]
+	switch {
+	case ch == '\\':
+		return p.command()
+	case ch == '{':
+		content, next, ok := readGroup(p.s, p.i)
+		if !ok {
+			p.i++
+			return "", false, false
+		}
+		p.i = next
+		return p.c.mathRow(content, p.display), false, true
+	case ch == '}' || ch == '&':
+		p.i++
+		return "", false, false
+	case ch >= '0' && ch <= '9' || ch == '.' && p.i+1 < len(p.s) && p.s[p.i+1] >= '0' && p.s[p.i+1] <= '9':
+		j := p.i
+		for j < len(p.s) && (p.s[j] >= '0' && p.s[j] <= '9' || p.s[j] == '.' && j+1 < len(p.s) && p.s[j+1] >= '0' && p.s[j+1] <= '9') {
+			j++
+		}
+		number := p.s[p.i:j]
+		p.i = j
+		return "<mn>" + number + "</mn>", false, true
+	case isLetter(ch) && ch != '@':
+		p.i++
+		return "<mi>" + string(ch) + "</mi>", false, true
+	case ch == '~':
+		p.i++
+		return `<mspace width="0.25em"/>`, false, true
+	case ch == '-':
+		p.i++
+		return "<mo>−</mo>", false, true
+	case ch == '*':
+		p.i++
+		return "<mo>∗</mo>", false, true
+	}
+
+	// Other characters are operators, or text when they are not ASCII
+	r := []rune(p.s[p.i:])[0]
+	p.i += len(string(r))
+	if r > 127 {
+		return "<mi>" + string(r) + "</mi>", false, true
+	}
+	return "<mo>" + html.EscapeString(string(r)) + "</mo>", false, true
+}
+
+// command reads a math command
+func (p *mathParser) command() (string, bool, bool) {
+	name, next := readCommand(p.s, p.i)
+	p.i = next
+	if name == "" {
+		return "", false, false
+	}
+	if value, ok := greekLetters[name]; ok {
+		if name[0] >= 'A' && name[0] <= 'Z' {
+			return `<mi mathvariant="normal">` + value + "</mi>", false, true
+		}
+		return "<mi>" + value + "</mi>", false, true
+	}
+	if value, ok := mathIdentifiers[name]; ok {
+		return "<mi>" + value + "</mi>", false, true
+	}
+	if value, ok := mathOperators[name]; ok {
+		return "<mo>" + html.EscapeString(value) + "</mo>", false, true
+	}
+	if value, ok := largeOperators[name]; ok {
+		return `<mo largeop="true">` + value + "</mo>", true, true
+	}
+	if value, ok := integrals[name]; ok {
+		return `<mo largeop="true">` + value + "</mo>", false, true
+	}
+	if limits, ok := mathFunctions[name]; ok {
+		return "<mi>" + name + "</mi><mo>⁡</mo>", limits, true
+	}
+	if width, ok := mathSpaces[name]; ok {
+		return fmt.Sprintf(`<mspace width="%s"/>`, width), false, true
+	}
+	if variant, ok := mathVariants[name]; ok {
+		content := p.rawArgument()
+		row := p.c.mathRow(content, p.display)
+		return variantPattern.ReplaceAllString(row, `<$1 mathvariant="`+variant+`">`), false, true
+	}
+	if accent, ok := mathAccents[name]; ok {
+		base := p.argument()
+		if underAccents[name] {
+			return `<munder accentunder="true">` + base + "<mo>" + accent + "</mo></munder>", false, true
+		}
+		return `<mover accent="true">` + base + "<mo>" + html.EscapeString(accent) + "</mo></mover>", false, true
+	}
+
+	switch name {
+	case "frac", "dfrac", "tfrac", "cfrac":
+		numerator := p.argument()
+		denominator := p.argument()
+		return "<mfrac>" + numerator + denominator + "</mfrac>", false, true
+	case "binom", "dbinom", "tbinom":
+		top := p.argument()
+		bottom := p.argument()
+		return `<mrow><mo>(</mo><mfrac linethickness="0">` + top + bottom + "</mfrac><mo>)</mo></mrow>", false, true
+	case "sqrt":
+		if index, next, ok := readOptional(p.s, skipSpace(p.s, p.i)); ok {
+			p.i = next
+			radicand := p.argument()
+			return "<mroot>" + radicand + p.c.mathRow(index, p.display) + "</mroot>", false, true
+		}
+		return "<msqrt>" + p.argument() + "</msqrt>", false, true
+	case "text", "textrm", "textnormal", "mbox", "textit", "textbf", "mathnormal":
+		return "<mtext>" + html.EscapeString(p.c.plain(p.rawArgument())) + "</mtext>", false, true
+	case "operatorname":
+		if p.i < len(p.s) && p.s[p.i] == '*' {
+			p.i++
+			return "<mi>" + html.EscapeString(p.c.plain(p.rawArgument())) + "</mi><mo>⁡</mo>", true, true
+		}
+		return "<mi>" + html.EscapeString(p.c.plain(p.rawArgument())) + "</mi><mo>⁡</mo>", false, true
+	case "left", "right", "big", "Big", "bigg", "Bigg", "bigl", "bigr", "Bigl", "Bigr", "biggl", "biggr", "middle":
+		delimiter := p.delimiter()
+		if name != "left" {
+			if delimiter == "" {
+				return "", false, false
+			}
+			return `<mo stretchy="true">` + html.EscapeString(delimiter) + "</mo>", false, true
+		}
+		inner := p.expression(func() bool {
+			return strings.HasPrefix(p.s[p.i:], `\right`) && (p.i+6 >= len(p.s) || !isLetter(p.s[p.i+6]))
+		})
+		closing := ""
+		if strings.HasPrefix(p.s[p.i:], `\right`) {
+			p.i += len(`\right`)
+			closing = p.delimiter()
+		}
+		return fence(delimiter, closing, strings.Join(inner, "")), false, true
+	case "begin":
+		env := strings.TrimSpace(p.rawArgument())
+		end, after, ok := findEnd(p.s, p.i, env)
+		if !ok {
+			return "", false, false
+		}
+		content := p.s[p.i:end]
+		p.i = after
+		return p.matrix(env, content), false, true
+	case "limits":
+		return "", false, false
+	case "label", "tag", "nonumber", "notag", "displaystyle", "textstyle", "scriptstyle", "nolimits":
+		if name == "label" || name == "tag" {
+			p.rawArgument()
+		}
+		return "", false, false
+	case `\`:
+		return "", false, false
+	case "!":
+		return `<mspace width="-0.1667em"/>`, false, true
+	case "not":
+		node, _, ok := p.atom()
+		if !ok {
+			return "", false, false
+		}
+		return "<mrow>" + node + "<mo≯</mo></mrow>", false, true
+	case "mod", "bmod", "pmod":
+		if name == "pmod" {
+			return "<mo>(</mo><mi>mod</mi>" + p.argument() + "<mo>)</mo>", false, true
+		}
+		return "<mo>mod</mo>", false, true
+	case "stackrel", "overset", "underset":
+		top := p.argument()
+		base := p.argument()
+		if name == "underset" {
+			return "<munder>" + base + top + "</munder>", false, true
+		}
+		return "<mover>" + base + top + "</mover>", false, true
+	}
+
+	p.c.warnOnce("Unsupported math command \\%s, keeping it as text", name)
+	return "<mtext>\\" + html.EscapeString(name) + "</mtext>", false, true
+}
+
+// variantPattern matches the token elements a math alphabet applies to
+var variantPattern = regexp.MustCompile(`<(mi|mn)>`)
+
+// delimiter reads the delimiter of \left, \right or \big; "." is the empty delimiter
+func (p *mathParser) delimiter() string {
+	p.i = skipSpace(p.s, p.i)
+	if p.i >= len(p.s) {
+		return ""
+	}
+	if p.s[p.i] == '\\' {
+		name, next := readCommand(p.s, p.i)
+		p.i = next
+		return mathDelimiters[name]
+	}
+	ch := p.s[p.i]
+	p.i++
+	if ch == '.' {
+		return ""
+	}
+	return string(ch)
+}
+
+// fence wraps content in stretchy delimiters
+func fence(open, close, content string) string {
+	var out strings.Builder
+	out.WriteString("<mrow>")
+	if open != "" {
+		out.WriteString(`<mo fence="true" stretchy="true">` + html.EscapeString(open) + "</mo>")
+	}
+	out.WriteString(content)
+	if close != "" {
+		out.WriteString(`<mo fence="true" stretchy="true">` + html.EscapeString(close) + "</mo>")
+	}
+	out.WriteString("</mrow>")
+	return out.String()
+}
+
+// matrix converts a matrix or alignment environment to a table
+func (p *mathParser) matrix(env, content string) string {
+	fences, ok := matrixFences[env]
+	if !ok {
+		p.c.warnOnce("Unsupported math environment %s, keeping its content", env)
+		return p.c.mathRow(content, p.display)
+	}
+	if env == "array" || env == "alignedat" {
+		_, after := readArgument(content, 0)
+		content = content[after:]
+	}
+	aligned := env == "aligned" || env == "split" || env == "alignedat"
+	var out strings.Builder
+	if aligned {
+		out.WriteString(`<mtable displaystyle="true" columnalign="right left" columnspacing="0">`)
+	} else if env == "cases" {
+		out.WriteString(`<mtable columnalign="left left">`)
+	} else {
+		out.WriteString("<mtable>")
+	}
+	for _, row := range splitTop(content, `\\`) {
+		if strings.TrimSpace(row) == "" {
+			continue
+		}
+		out.WriteString("<mtr>")
+		for _, cell := range splitTop(row, "&") {
+			out.WriteString("<mtd>" + p.c.mathRow(strings.TrimSpace(cell), p.display) + "</mtd>")
+		}
+		out.WriteString("</mtr>")
+	}
+	out.WriteString("</mtable>")
+	if fences[0] == "" && fences[1] == "" {
+		return out.String()
+	}
+	return fence(fences[0], fences[1], out.String())
+}
@@ -0,0 +1,153 @@
+package latex
+
+import "strings"
+
+// isLetter reports whether a byte is an ASCII letter, which command names consist of
+func isLetter(b byte) bool {
+	return b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b == '@'
+}
+
+// skipSpace returns the position of the first non-space byte from i
+func skipSpace(s string, i int) int {
+	for i < len(s) && (s[i] == ' ' || s[i] == '\t' || s[i] == '\n') {
+		i++
+	}
+	return i
+}
+
+// readCommand reads the name of the command at s[i], a backslash, and returns it with
+// the position after it; control symbols such as \% have a one-character name
+func readCommand(s string, i int) (string, int) {
+	j := i + 1
+	if j >= len(s) {
+		return "", j
+	}
+	if !isLetter(s[j]) {
+		return s[j : j+1], j + 1
+	}
+	for j < len(s) && isLetter(s[j]) {
+		j++
+	}
+	return s[i+1 : j], j
+}
+
+// readGroup reads the balanced {...} group at s[i] and returns its content and the
+// position after it
+func readGroup(s string, i int) (string, int, bool) {
+	return readDelimited(s, i, '{', '}')
+}
+
+// readOptional reads the optional [...] argument at s[i]
+func readOptional(s string, i int) (string, int, bool) {
+	return readDelimited(s, i, '[', ']')
+}
+
+// readDelimited reads the content between balanced delimiters at s[i], skipping
+// escaped delimiters and braced groups inside brackets
+func readDelimited(s string, i int, open, close byte) (string, int, bool) {
+	if i >= len(s) || s[i] != open {
+		return "", i, false
+	}
+	depth, braces := 0, 0
+	for j := i; j < len(s); j++ {
+		switch s[j] {
+		case '\\':
+			j++
+		case '{':
+			braces++
+			if open == '{' {
+				depth++
+			}
+		case '}':
+			braces--
+			if open == '{' {
+				depth--
+				if depth == 0 {
+					return s[i+1 : j], j + 1, true
+				}
+			}
+		case open:
+			if braces == 0 {
+				depth++
+			}
+		case close:
+			if braces == 0 {
+				depth--
+				if depth == 0 {
+					return s[i+1 : j], j + 1, true
+				}
+			}
+		}
+	}
+	return "", i, false
+}
+
+// readArgument reads a command argument: a braced group, or else a single character
+// or command
+func readArgument(s string, i int) (string, int) {
+	i = skipSpace(s, i)
+	if content, next, ok := readGroup(s, i); ok {
+		return content, next
+	}
+	if i >= len(s) {
+		return "", i
+	}
+	if s[i] == '\\' {
+		_, next := readCommand(s, i)
+		return s[i:next], next
+	}
+	return s[i : i+1], i + 1
+}
+
+// findEnd returns the end of the content of the environment opened before i, and the
+// position after its \end, counting nested environments of the same name
+func findEnd(s string, i int, env string) (int, int, bool) {
+	begin, end := `\begin{`+env+`}`, `\end{`+env+`}`
+	depth := 1
+	for j := i; j < len(s); {
+		nextBegin := strings.Index(s[j:], begin)
+		nextEnd := strings.Index(s[j:], end)
+		if nextEnd < 0 {
+			return 0, 0, false
+		}
+		if nextBegin >= 0 && nextBegin < nextEnd {
+			depth++
+			j += nextBegin + len(begin)
+			continue
+		}
+		depth--
+		if depth == 0 {
+			return j + nextEnd, j + nextEnd + len(end), true
+		}
+		j += nextEnd + len(end)
+	}
+	return 0, 0, false
+}
+
+// splitTop splits s at the separators that are outside groups and environments
+func splitTop(s, separator string) []string {
+	var parts []string
+	depth, envs, last := 0, 0, 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case strings.HasPrefix(s[i:], `\begin{`):
+			envs++
+			i += len(`\begin{`) - 1
+		case strings.HasPrefix(s[i:], `\end{`):
+			envs--
+			i += len(`\end{`) - 1
+		case depth == 0 && envs == 0 && strings.HasPrefix(s[i:], separator) &&
+			!(separator == `\item` && i+len(separator) < len(s) && isLetter(s[i+len(separator)])):
+			parts = append(parts, s[last:i])
+			i += len(separator) - 1
+			last = i + 1
+		case s[i] == '\\':
+			i++
+		case s[i] == '{':
+			depth++
+		case s[i] == '}':
+			depth--
+		}
+	}
+	return append(parts, s[last:])
+}
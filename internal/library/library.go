@@ -0,0 +1,173 @@
+package library
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// sqliteCommand is the SQLite command-line shell the library database is run through
+const sqliteCommand = "sqlite3"
+
+// schema creates the books table of a new library database
+const schema = `CREATE TABLE IF NOT EXISTS books (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  processed_at TEXT NOT NULL,
+  input TEXT NOT NULL,
+  output TEXT NOT NULL,
+  source_sha256 TEXT NOT NULL,
+  title TEXT NOT NULL,
+  author TEXT NOT NULL,
+  identifier TEXT NOT NULL,
+  language TEXT NOT NULL,
+  status TEXT NOT NULL,
+  exit_code INTEGER NOT NULL,
+  error TEXT NOT NULL,
+  options TEXT NOT NULL,
+  quality_score INTEGER,
+  quality_grade TEXT NOT NULL,
+  findings INTEGER
+);
+CREATE INDEX IF NOT EXISTS books_source ON books (source_sha256);
+`
+
+// Book is a processing run of a book recorded in the library
+type Book struct {
+	ID           int64  `json:"id"`
+	ProcessedAt  string `json:"processed_at"`
+	Input        string `json:"input"`
+	Output       string `json:"output"`
+	SourceSHA256 string `json:"source_sha256"`
+	Title        string `json:"title"`
+	Author       string `json:"author"`
+	Identifier   string `json:"identifier"`
+	Language     string `json:"language"`
+	Status       string `json:"status"`
+	ExitCode     int    `json:"exit_code"`
+	Error        string `json:"error"`
+	// Options holds the command-line options of the run as a JSON object
+	Options string `json:"options"`
+	// QualityScore, QualityGrade and Findings are the validation results of the
+	// output; the score and findings are nil when it was not assessed
+	QualityScore *int   `json:"quality_score"`
+	QualityGrade string `json:"quality_grade"`
+	Findings     *int   `json:"findings"`
+}
+
+// Library is a SQLite library database of processed books
+type Library struct {
+	path string
+}
+
+// DefaultPath returns the location of the library database in the user config
+// directory
+func DefaultPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "folian-library.db"
+	}
+	return filepath.Join(dir, "folian-parser", "library.db")
+}
+
+// Open opens the library database at path, creating it and its schema when missing
+func Open(path string) (*Library, error) {
+	if _, err := exec.LookPath(sqliteCommand); err != nil {
+		return nil, fmt.Errorf("the library database requires the %s command-line tool", sqliteCommand)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create library directory: %w", err)
+	}
+
+	library := &Library{path: path}
+	if _, err := library.run(schema); err != nil {
+		return nil, fmt.Errorf("failed to create library schema: %w", err)
+	}
+	return library, nil
+}
+
+// run runs SQL statements against the database, returning query rows as JSON
+func (l *Library) run(sql string) ([]byte, error) {
+	cmd := exec.Command(sqliteCommand, "-bail", "-json", l.path)
+	cmd.Stdin = strings.NewReader(sql)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w: %s", sqliteCommand, err, strings.TrimSpace(stderr.String()))
+	}
+	return output, nil
+}
+
+// quote renders a string as an SQL literal
+func quote(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+// nullableInt renders an optional integer as an SQL literal
+func nullableInt(n *int) string {
+	if n == nil {
+		return "NULL"
+	}
+	return fmt.Sprint(*n)
+}
+
+// Add records a processing run
+func (l *Library) Add(book Book) error {
+	sql := fmt.Sprintf(`INSERT INTO books (processed_at, input, output, source_sha256, title, author, identifier,
+  language, status, exit_code, error, options, quality_score, quality_grade, findings)
+VALUES (%s, %s, %s, %s, %s, %s, %s, %s, %s, %d, %s, %s, %s, %s, %s);
+`, quote(book.ProcessedAt), quote(book.Input), quote(book.Output), quote(book.SourceSHA256), quote(book.Title),
+		quote(book.Author), quote(book.Identifier), quote(book.Language), quote(book.Status), book.ExitCode,
+		quote(book.Error), quote(book.Options), nullableInt(book.QualityScore), quote(book.QualityGrade), nullableInt(book.Findings))
+	if _, err := l.run(sql); err != nil {
+		return fmt.Errorf("failed to record %s in the library: %w", book.Input, err)
+	}
+	return nil
+}
+
+// query runs a SELECT over the books table and decodes its rows
+func (l *Library) query(where string, limit int) ([]Book, error) {
+	sql := "SELECT * FROM books"
+	if where != "" {
+		sql += " WHERE " + where
+	}
+	sql += " ORDER BY id DESC"
+	if limit > 0 {
+		sql += fmt.Sprintf(" LIMIT %d", limit)
+	}
+
+	output, err := l.run(sql + ";\n")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query the library: %w", err)
+	}
+	// The JSON mode prints nothing for an empty result
+	if len(bytes.TrimSpace(output)) == 0 {
+		return nil, nil
+	}
+
+	var books []Book
+	if err := json.Unmarshal(output, &books); err != nil {
+		return nil, fmt.Errorf("failed to decode library rows: %w", err)
+	}
+	return books, nil
+}
+
+// List returns the most recent runs, newest first; limit 0 returns them all
+func (l *Library) List(limit int) ([]Book, error) {
+	return l.query("", limit)
+}
+
+// Search returns the runs whose title, author, identifier, input, output or source
+// hash contain a term, ignoring case for ASCII letters
+func (l *Library) Search(term string, limit int) ([]Book, error) {
+	pattern := quote("%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(term) + "%")
+	var conditions []string
+	for _, column := range []string{"title", "author", "identifier", "input", "output", "source_sha256"} {
+		conditions = append(conditions, fmt.Sprintf(`%s LIKE %s ESCAPE '\'`, column, pattern))
+	}
+	return l.query(strings.Join(conditions, " OR "), limit)
+}
@@ -0,0 +1,389 @@
+package lookup
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/flouciel/folian-parser/internal/network"
+)
+
+// Record holds the bibliographic data found online for a book
+type Record struct {
+	Source      string
+	Title       string
+	Authors     []string
+	Publisher   string
+	Date        string
+	Description string
+	AuthorBio   string
+	Subjects    []string
+	CoverURL    string
+	// workKey is the OpenLibrary work of a search result, whose details are
+	// fetched by Complete
+	workKey string
+}
+
+// API endpoints
+const (
+	openLibraryURL      = "https://openlibrary.org"
+	openLibraryCoverURL = "https://covers.openlibrary.org/b/id/%d-L.jpg"
+	googleBooksURL      = "https://www.googleapis.com/books/v1/volumes"
+)
+
+// client is the HTTP client used for lookups
+var client = network.NewClient(15 * time.Second)
+
+// ByISBN looks a book up on OpenLibrary, filling the fields it lacks from Google Books
+func ByISBN(isbn string) (*Record, error) {
+	isbn = strings.NewReplacer("-", "", " ", "").Replace(strings.TrimPrefix(strings.ToLower(isbn), "urn:isbn:"))
+	if isbn == "" {
+		return nil, fmt.Errorf("no ISBN to look up")
+	}
+
+	record, openLibraryErr := openLibraryISBN(isbn)
+	google, googleErr := googleBooks("isbn:" + isbn)
+	switch {
+	case openLibraryErr != nil && googleErr != nil:
+		return nil, fmt.Errorf("failed to look up ISBN %s: %v; %v", isbn, openLibraryErr, googleErr)
+	case openLibraryErr != nil:
+		return google, nil
+	case googleErr == nil:
+		record.merge(google)
+	}
+	return record, nil
+}
+
+// merge fills the empty fields of a record from another one
+func (r *Record) merge(other *Record) {
+	if r.Title == "" {
+		r.Title = other.Title
+	}
+	if len(r.Authors) == 0 {
+		r.Authors = other.Authors
+	}
+	if r.Publisher == "" {
+		r.Publisher = other.Publisher
+	}
+	if r.Date == "" {
+		r.Date = other.Date
+	}
+	if r.Description == "" {
+		r.Description = other.Description
+	}
+	if r.AuthorBio == "" {
+		r.AuthorBio = other.AuthorBio
+	}
+	if len(r.Subjects) == 0 {
+		r.Subjects = other.Subjects
+	}
+	if r.CoverURL == "" {
+		r.CoverURL = other.CoverURL
+	}
+	r.Source += "+" + other.Source
+}
+
+// textValue decodes OpenLibrary text fields, which are either a string or a
+// {"type": ..., "value": ...} object
+type textValue string
+
+// UnmarshalJSON implements json.Unmarshaler
+func (t *textValue) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*t = textValue(s)
+		return nil
+	}
+	var typed struct {
+		Value string `json:"value"`
+	}
+	if err := json.Unmarshal(data, &typed); err != nil {
+		return err
+	}
+	*t = textValue(typed.Value)
+	return nil
+}
+
+// openLibraryKey references another OpenLibrary record
+type openLibraryKey struct {
+	Key string `json:"key"`
+}
+
+// openLibraryISBN looks an edition up on OpenLibrary, following its work for the
+// description and subjects and its first author for the biography
+func openLibraryISBN(isbn string) (*Record, error) {
+	var edition struct {
+		Title       string           `json:"title"`
+		Publishers  []string         `json:"publishers"`
+		PublishDate string           `json:"publish_date"`
+		Description textValue        `json:"description"`
+		Subjects    []string         `json:"subjects"`
+		Covers      []int            `json:"covers"`
+		Works       []openLibraryKey `json:"works"`
+		Authors     []openLibraryKey `json:"authors"`
+	}
+	if err := getJSON(openLibraryURL+"/isbn/"+url.PathEscape(isbn)+".json", &edition); err != nil {
+		return nil, err
+	}
+
+	record := &Record{
+		Source:      "OpenLibrary",
+		Title:       edition.Title,
+		Date:        edition.PublishDate,
+		Description: string(edition.Description),
+		Subjects:    edition.Subjects,
+	}
+	if len(edition.Publishers) > 0 {
+		record.Publisher = edition.Publishers[0]
+	}
+	if len(edition.Covers) > 0 && edition.Covers[0] > 0 {
+		record.CoverURL = fmt.Sprintf(openLibraryCoverURL, edition.Covers[0])
+	}
+
+	if len(edition.Works) > 0 && (record.Description == "" || len(record.Subjects) == 0) {
+		var work struct {
+			Description textValue `json:"description"`
+			Subjects    []string  `json:"subjects"`
+		}
+		if err := getJSON(openLibraryURL+edition.Works[0].Key+".json", &work); err == nil {
+			if record.Description == "" {
+				record.Description = string(work.Description)
+			}
+			if len(record.Subjects) == 0 {
+				record.Subjects = work.Subjects
+			}
+		}
+	}
+
+	for i, author := range edition.Authors {
+		var details struct {
+			Name string    `json:"name"`
+			Bio  textValue `json:"bio"`
+		}
+		if err := getJSON(openLibraryURL+author.Key+".json", &details); err != nil {
+			continue
+		}
+		record.Authors = append(record.Authors, details.Name)
+		if i == 0 {
+			record.AuthorBio = string(details.Bio)
+		}
+	}
+
+	return record, nil
+}
+
+// Search looks a book up by title and author on OpenLibrary and Google Books,
+// returning the candidates ordered by how well they match
+func Search(title, author string) ([]*Record, error) {
+	if strings.TrimSpace(title) == "" {
+		return nil, fmt.Errorf("no title to look up")
+	}
+
+	records, openLibraryErr := openLibrarySearch(title, author)
+	query := "intitle:" + title
+	if author != "" {
+		query += " inauthor:" + author
+	}
+	google, googleErr := googleBooksVolumes(query, maxResults)
+	if openLibraryErr != nil && googleErr != nil {
+		return nil, fmt.Errorf("failed to look up %q: %v; %v", title, openLibraryErr, googleErr)
+	}
+	records = append(records, google...)
+
+	sort.SliceStable(records, func(i, j int) bool {
+		return Score(records[i], title, author) > Score(records[j], title, author)
+	})
+	return records, nil
+}
+
+// maxResults is the number of candidates requested from each service
+const maxResults = 5
+
+// openLibrarySearch returns the OpenLibrary works matching a title and author
+func openLibrarySearch(title, author string) ([]*Record, error) {
+	var result struct {
+		Docs []struct {
+			Key              string   `json:"key"`
+			Title            string   `json:"title"`
+			AuthorName       []string `json:"author_name"`
+			Publisher        []string `json:"publisher"`
+			FirstPublishYear int      `json:"first_publish_year"`
+			Subject          []string `json:"subject"`
+			CoverI           int      `json:"cover_i"`
+		} `json:"docs"`
+	}
+	query := url.Values{"title": {title}, "limit": {fmt.Sprint(maxResults)}}
+	if author != "" {
+		query.Set("author", author)
+	}
+	if err := getJSON(openLibraryURL+"/search.json?"+query.Encode(), &result); err != nil {
+		return nil, err
+	}
+
+	var records []*Record
+	for _, doc := range result.Docs {
+		record := &Record{
+			Source:   "OpenLibrary",
+			Title:    doc.Title,
+			Authors:  doc.AuthorName,
+			Subjects: doc.Subject,
+			workKey:  doc.Key,
+		}
+		if len(doc.Publisher) > 0 {
+			record.Publisher = doc.Publisher[0]
+		}
+		if doc.FirstPublishYear > 0 {
+			record.Date = fmt.Sprint(doc.FirstPublishYear)
+		}
+		if doc.CoverI > 0 {
+			record.CoverURL = fmt.Sprintf(openLibraryCoverURL, doc.CoverI)
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// Complete fetches the description of an OpenLibrary search result, which the
+// search does not return
+func Complete(record *Record) {
+	if record.workKey == "" || record.Description != "" {
+		return
+	}
+	var work struct {
+		Description textValue `json:"description"`
+	}
+	if err := getJSON(openLibraryURL+record.workKey+".json", &work); err == nil {
+		record.Description = string(work.Description)
+	}
+}
+
+// Score rates how well a record matches a title and author, from 0 to 1
+func Score(record *Record, title, author string) float64 {
+	score := wordOverlap(record.Title, title)
+	if author == "" {
+		return score
+	}
+	best := 0.0
+	for _, name := range record.Authors {
+		if overlap := wordOverlap(name, author); overlap > best {
+			best = overlap
+		}
+	}
+	return (2*score + best) / 3
+}
+
+// wordOverlap returns the share of distinct words two strings have in common
+func wordOverlap(a, b string) float64 {
+	words := func(s string) map[string]bool {
+		set := make(map[string]bool)
+		for _, word := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}) {
+			set[word] = true
+		}
+		return set
+	}
+	wordsA, wordsB := words(a), words(b)
+	if len(wordsA) == 0 || len(wordsB) == 0 {
+		return 0
+	}
+	common := 0
+	for word := range wordsA {
+		if wordsB[word] {
+			common++
+		}
+	}
+	return float64(common) / float64(len(wordsA)+len(wordsB)-common)
+}
+
+// Download fetches a file such as a cover image
+func Download(fileURL string) ([]byte, error) {
+	resp, err := client.Get(fileURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", fileURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch %s: status code %d", fileURL, resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// googleBooks returns the first Google Books volume matching a query
+func googleBooks(query string) (*Record, error) {
+	records, err := googleBooksVolumes(query, 1)
+	if err != nil {
+		return nil, err
+	}
+	return records[0], nil
+}
+
+// googleBooksVolumes returns the Google Books volumes matching a query
+func googleBooksVolumes(query string, limit int) ([]*Record, error) {
+	var result struct {
+		Items []struct {
+			VolumeInfo struct {
+				Title         string   `json:"title"`
+				Authors       []string `json:"authors"`
+				Publisher     string   `json:"publisher"`
+				PublishedDate string   `json:"publishedDate"`
+				Description   string   `json:"description"`
+				Categories    []string `json:"categories"`
+				ImageLinks    struct {
+					Thumbnail string `json:"thumbnail"`
+				} `json:"imageLinks"`
+			} `json:"volumeInfo"`
+		} `json:"items"`
+	}
+	if err := getJSON(fmt.Sprintf("%s?q=%s&maxResults=%d", googleBooksURL, url.QueryEscape(query), limit), &result); err != nil {
+		return nil, err
+	}
+	if len(result.Items) == 0 {
+		return nil, fmt.Errorf("no Google Books volume matches %s", query)
+	}
+
+	var records []*Record
+	for _, item := range result.Items {
+		info := item.VolumeInfo
+		records = append(records, &Record{
+			Source:      "Google Books",
+			Title:       info.Title,
+			Authors:     info.Authors,
+			Publisher:   info.Publisher,
+			Date:        info.PublishedDate,
+			Description: info.Description,
+			Subjects:    info.Categories,
+			CoverURL:    strings.Replace(info.ImageLinks.Thumbnail, "http://", "https://", 1),
+		})
+	}
+	return records, nil
+}
+
+// getJSON fetches a URL and decodes its JSON response
+func getJSON(requestURL string, v interface{}) error {
+	resp, err := client.Get(requestURL)
+	if err != nil {
+		return fmt.Errorf("failed to fetch %s: %w", requestURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch %s: status code %d", requestURL, resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("failed to decode %s: %w", requestURL, err)
+	}
+	return nil
+}
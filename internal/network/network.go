@@ -0,0 +1,56 @@
+package network
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// OfflineEnv is the environment variable that enables offline mode when set to 1,
+// true, yes or on
+const OfflineEnv = "FOLIAN_OFFLINE"
+
+// Offline disables all network access: every request fails with ErrOffline before
+// a connection is attempted
+var Offline bool
+
+// ErrOffline is returned for requests made in offline mode
+var ErrOffline = errors.New("network access is disabled (offline mode)")
+
+// baseTransport performs the requests allowed out of offline mode
+var baseTransport = http.DefaultTransport
+
+// guardedTransport refuses every request in offline mode
+type guardedTransport struct{}
+
+// RoundTrip performs a request unless offline mode is enabled
+func (guardedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if Offline {
+		return nil, ErrOffline
+	}
+	return baseTransport.RoundTrip(req)
+}
+
+// NewClient returns an HTTP client whose requests fail in offline mode
+func NewClient(timeout time.Duration) *http.Client {
+	return &http.Client{Timeout: timeout, Transport: guardedTransport{}}
+}
+
+// OfflineFromEnv reports whether OfflineEnv enables offline mode
+func OfflineFromEnv() bool {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv(OfflineEnv))) {
+	case "1", "true", "yes", "on":
+		return true
+	}
+	return false
+}
+
+// Disable enables offline mode: requests through NewClient clients and the default
+// HTTP client fail, and child processes such as hooks inherit OfflineEnv
+func Disable() {
+	Offline = true
+	http.DefaultTransport = guardedTransport{}
+	os.Setenv(OfflineEnv, "1")
+}
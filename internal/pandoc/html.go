@@ -0,0 +1,290 @@
+package pandoc
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/net/html"
+
+	"github.com/flouciel/folian-parser/internal/book"
+	"github.com/flouciel/folian-parser/internal/htmlnode"
+	"github.com/flouciel/folian-parser/internal/policy"
+)
+
+// keptAttributes are the attributes kept on converted elements; pandoc's roles, data
+// attributes and inline styles are dropped
+var keptAttributes = map[string]bool{
+	"id": true, "class": true, "href": true, "src": true, "alt": true, "title": true, "lang": true,
+	"colspan": true, "rowspan": true, "start": true, "type": true, "reversed": true, "value": true,
+}
+
+// voidElements are the HTML elements written as empty XML elements
+var voidElements = map[string]bool{
+	"br": true, "hr": true, "img": true, "col": true, "wbr": true, "source": true, "track": true,
+}
+
+// converted is pandoc's output split into blocks
+type converted struct {
+	metadata book.Metadata
+	blocks   []book.Block
+	notes    map[string]string
+	images   []book.Image
+	// sources maps the image files already copied to their names in the book
+	sources map[string]string
+	baseDir string
+}
+
+// parse reads the standalone HTML document pandoc writes; images are resolved
+// relative to baseDir
+func parse(data []byte, baseDir string) (*converted, error) {
+	doc, err := html.Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	c := &converted{notes: make(map[string]string), sources: make(map[string]string), baseDir: baseDir}
+
+	var authors, keywords []string
+	htmlnode.Walk(doc, func(n *html.Node) bool {
+		if n.Type != html.ElementNode {
+			return false
+		}
+		switch n.Data {
+		case "html":
+			c.metadata.Language = htmlnode.Attribute(n, "lang")
+		case "title":
+			c.metadata.Title = strings.TrimSpace(htmlnode.Text(n))
+		case "meta":
+			content := strings.TrimSpace(htmlnode.Attribute(n, "content"))
+			switch htmlnode.Attribute(n, "name") {
+			case "author":
+				authors = append(authors, content)
+			case "dcterms.date":
+				c.metadata.Date = content
+			case "description":
+				c.metadata.Description = content
+			case "keywords":
+				keywords = append(keywords, strings.Split(content, ",")...)
+			}
+		case "body":
+			c.body(n)
+			return false
+		}
+		return true
+	})
+	c.metadata.Creator = strings.Join(authors, ", ")
+	for _, keyword := range keywords {
+		if keyword = strings.TrimSpace(keyword); keyword != "" {
+			c.metadata.Subjects = append(c.metadata.Subjects, keyword)
+		}
+	}
+	return c, nil
+}
+
+// body converts the children of an element to blocks, descending into the sections
+// and divisions that hold headings
+func (c *converted) body(parent *html.Node) {
+	for n := parent.FirstChild; n != nil; n = n.NextSibling {
+		if n.Type == html.TextNode {
+			if strings.TrimSpace(n.Data) != "" {
+				c.blocks = append(c.blocks, book.Block{HTML: "<p>" + html.EscapeString(strings.TrimSpace(n.Data)) + "</p>"})
+			}
+			continue
+		}
+		if n.Type != html.ElementNode {
+			continue
+		}
+
+		id := htmlnode.Attribute(n, "id")
+		switch {
+		case id == "title-block-header" || id == "TOC" || n.Data == "script" || n.Data == "style":
+			// The title block repeats the metadata, and the book gets its own contents
+		case htmlnode.HasClass(n, "footnotes"):
+			c.footnotes(n)
+		case htmlnode.IsHeading(n):
+			attrs := ""
+			if id != "" {
+				attrs = fmt.Sprintf(` id="%s"`, html.EscapeString(id))
+			}
+			c.blocks = append(c.blocks, book.Block{
+				Level: int(n.Data[1] - '0'),
+				Title: strings.Join(strings.Fields(htmlnode.Text(n)), " "),
+				Attrs: attrs,
+				HTML:  c.children(n),
+			})
+		case (n.Data == "section" || n.Data == "div") && holdsHeading(n):
+			// A section's ID, which links target, moves to its heading
+			if heading := firstElement(n); id != "" && heading != nil && htmlnode.IsHeading(heading) && htmlnode.Attribute(heading, "id") == "" {
+				heading.Attr = append(heading.Attr, html.Attribute{Key: "id", Val: id})
+			}
+			c.body(n)
+		case n.Data == "hr":
+			// Transitions between sections are scene breaks
+			c.blocks = append(c.blocks, book.Block{HTML: `<hr class="scene-break"/>`})
+		default:
+			if content := c.render(n); content != "" {
+				c.blocks = append(c.blocks, book.Block{HTML: content})
+			}
+		}
+	}
+}
+
+// firstElement returns the first child element of a node
+func firstElement(n *html.Node) *html.Node {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == html.ElementNode {
+			return child
+		}
+	}
+	return nil
+}
+
+// holdsHeading reports whether one of an element's children is a heading
+func holdsHeading(n *html.Node) bool {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if htmlnode.IsHeading(child) {
+			return true
+		}
+		if child.Type == html.ElementNode && (child.Data == "section" || child.Data == "div") && holdsHeading(child) {
+			return true
+		}
+	}
+	return false
+}
+
+// footnotes collects the notes of pandoc's footnote section, keyed by their IDs,
+// without their links back to the text
+func (c *converted) footnotes(section *html.Node) {
+	htmlnode.Walk(section, func(n *html.Node) bool {
+		if n.Type == html.ElementNode && n.Data == "a" && htmlnode.HasClass(n, "footnote-back") {
+			n.Parent.RemoveChild(n)
+			return false
+		}
+		return true
+	})
+	htmlnode.Walk(section, func(n *html.Node) bool {
+		if n.Type != html.ElementNode || n.Data != "li" {
+			return true
+		}
+		if id := htmlnode.Attribute(n, "id"); id != "" {
+			note := c.children(n)
+			if !strings.HasPrefix(strings.TrimSpace(note), "<") {
+				note = "<p>" + note + "</p>"
+			}
+			c.notes[id] = note + "\n"
+		}
+		return false
+	})
+}
+
+// render writes an element as XHTML
+func (c *converted) render(n *html.Node) string {
+	var out strings.Builder
+	c.write(&out, n)
+	return out.String()
+}
+
+// children writes the content of an element as XHTML
+func (c *converted) children(n *html.Node) string {
+	var out strings.Builder
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		c.write(&out, child)
+	}
+	return strings.TrimSpace(out.String())
+}
+
+// write writes a node as XHTML, pointing images at their copies in the book and
+// footnote references at the notes
+func (c *converted) write(out *strings.Builder, n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		out.WriteString(html.EscapeString(n.Data))
+		return
+	case html.ElementNode:
+	default:
+		return
+	}
+
+	name := n.Data
+	var attrs []html.Attribute
+	switch {
+	case name == "img":
+		src := c.image(htmlnode.Attribute(n, "src"))
+		if src == "" {
+			return
+		}
+		attrs = append(attrs, html.Attribute{Key: "src", Val: src}, html.Attribute{Key: "alt", Val: htmlnode.Attribute(n, "alt")})
+	case name == "a" && htmlnode.HasClass(n, "footnote-ref"):
+		// Note references are marked as such, named after the note
+		target := strings.TrimPrefix(htmlnode.Attribute(n, "href"), "#")
+		attrs = append(attrs,
+			html.Attribute{Namespace: "epub", Key: "type", Val: "noteref"},
+			html.Attribute{Key: "href", Val: "#" + target},
+			html.Attribute{Key: "id", Val: "ref-" + target})
+	default:
+		for _, attr := range n.Attr {
+			if keptAttributes[attr.Key] || n.Namespace != "" {
+				attrs = append(attrs, attr)
+			}
+		}
+	}
+	if name == "math" && htmlnode.Attribute(n, "xmlns") == "" {
+		attrs = append(attrs, html.Attribute{Key: "xmlns", Val: "http://www.w3.org/1998/Math/MathML"})
+	}
+
+	out.WriteString("<" + name)
+	for _, attr := range attrs {
+		key := attr.Key
+		if attr.Namespace != "" && !(attr.Namespace == "xmlns" && key == "xmlns") {
+			key = attr.Namespace + ":" + key
+		}
+		out.WriteString(fmt.Sprintf(` %s="%s"`, key, html.EscapeString(attr.Val)))
+	}
+	if n.FirstChild == nil && (voidElements[name] || n.Namespace != "") {
+		out.WriteString("/>")
+		return
+	}
+	out.WriteString(">")
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		c.write(out, child)
+	}
+	out.WriteString("</" + name + ">")
+}
+
+// image copies an image pandoc extracted or the input links to into the book once,
+// returning its path from a chapter, or "" when it cannot be shown
+func (c *converted) image(src string) string {
+	if name, ok := c.sources[src]; ok {
+		if name == "" {
+			return ""
+		}
+		return "../images/" + name
+	}
+	c.sources[src] = ""
+	if strings.Contains(src, "://") || strings.HasPrefix(src, "data:") {
+		policy.Warn(policy.KindOther, "Skipping the remote image %s", src)
+		return ""
+	}
+
+	path := filepath.FromSlash(src)
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(c.baseDir, path)
+	}
+	ext := strings.ToLower(filepath.Ext(path))
+	mediaType, ok := book.ImageTypes[ext]
+	if !ok {
+		policy.Warn(policy.KindOther, "Skipping the image %s, which EPUB readers cannot show", src)
+		return ""
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		policy.Warn(policy.KindOther, "Skipping the missing image %s", src)
+		return ""
+	}
+	name := fmt.Sprintf("image_%03d%s", len(c.images)+1, ext)
+	c.images = append(c.images, book.Image{Name: name, MediaType: mediaType, Data: data})
+	c.sources[src] = name
+	return "../images/" + name
+}
@@ -0,0 +1,162 @@
+// Package pandoc reads the formats pandoc understands, such as ODT, reStructuredText
+// and AsciiDoc, by converting them to HTML with pandoc and splitting the result into
+// chapters like a Word manuscript
+package pandoc
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/flouciel/folian-parser/internal/book"
+	"github.com/flouciel/folian-parser/internal/command"
+)
+
+// PathEnv names the environment variable holding the path of pandoc, when it is not
+// in the PATH
+const PathEnv = "PANDOC"
+
+// Formats are the pandoc readers of the input formats read with pandoc, by extension
+var Formats = map[string]string{
+	".adoc":      "asciidoc",
+	".asciidoc":  "asciidoc",
+	".dbk":       "docbook",
+	".docbook":   "docbook",
+	".docx":      "docx",
+	".fb2":       "fb2",
+	".ipynb":     "ipynb",
+	".md":        "markdown",
+	".markdown":  "markdown",
+	".mediawiki": "mediawiki",
+	".muse":      "muse",
+	".odt":       "odt",
+	".opml":      "opml",
+	".org":       "org",
+	".rst":       "rst",
+	".rtf":       "rtf",
+	".t2t":       "t2t",
+	".textile":   "textile",
+	".typ":       "typst",
+}
+
+// formatNames are the names of the pandoc readers shown to users
+var formatNames = map[string]string{
+	"asciidoc": "AsciiDoc", "docbook": "DocBook", "docx": "DOCX", "fb2": "FB2", "ipynb": "Jupyter notebook",
+	"markdown": "Markdown", "mediawiki": "MediaWiki", "muse": "Muse", "odt": "ODT", "opml": "OPML",
+	"org": "Org", "rst": "reStructuredText", "rtf": "RTF", "t2t": "txt2tags", "textile": "Textile",
+	"typst": "Typst",
+}
+
+// Document is the outcome of converting an input with pandoc
+type Document struct {
+	Title    string
+	Author   string
+	Format   string
+	Version  string
+	Chapters int
+	Images   int
+}
+
+// Format returns the name of the format of a path read with pandoc, or "" when
+// pandoc is not used for it
+func Format(path string) string {
+	return formatNames[Formats[strings.ToLower(filepath.Ext(path))]]
+}
+
+// programPath returns the pandoc executable
+func programPath() (string, error) {
+	if path := os.Getenv(PathEnv); path != "" {
+		if _, err := os.Stat(path); err != nil {
+			return "", fmt.Errorf("%s points at a missing file: %w", PathEnv, err)
+		}
+		return path, nil
+	}
+	if path, err := exec.LookPath("pandoc"); err == nil {
+		return path, nil
+	}
+	return "", fmt.Errorf("pandoc is not installed and %s is not set", PathEnv)
+}
+
+// Installed reports whether pandoc can be run
+func Installed() bool {
+	_, err := programPath()
+	return err == nil
+}
+
+// version returns the version reported by pandoc, e.g. "3.1.11"
+func version(program string) string {
+	output, err := exec.Command(program, "--version").Output()
+	if err != nil {
+		return ""
+	}
+	// "pandoc 3.1.11"
+	line := strings.SplitN(strings.TrimSpace(string(output)), "\n", 2)[0]
+	return strings.TrimSpace(strings.TrimPrefix(line, "pandoc"))
+}
+
+// Convert converts an input to HTML with pandoc and writes it as an extracted EPUB in
+// dir, which is created
+func Convert(inputPath, dir string) (*Document, error) {
+	reader := Formats[strings.ToLower(filepath.Ext(inputPath))]
+	if reader == "" {
+		return nil, fmt.Errorf("%s is not a format read with pandoc", filepath.Ext(inputPath))
+	}
+	program, err := programPath()
+	if err != nil {
+		return nil, err
+	}
+
+	// Pandoc writes a standalone HTML document, with the images of the input
+	// extracted next to it and math as MathML
+	workDir, err := os.MkdirTemp("", "folian-pandoc-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create pandoc directory: %w", err)
+	}
+	defer os.RemoveAll(workDir)
+	if inputPath, err = filepath.Abs(inputPath); err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", inputPath, err)
+	}
+	htmlPath := filepath.Join(workDir, "book.html")
+	cmd := exec.Command(program, "--from", reader, "--to", "html5", "--standalone", "--mathml", "--wrap=none",
+		"--extract-media", filepath.Join(workDir, "media"), "--output", htmlPath, inputPath)
+	// Images the input links to are found relative to it
+	cmd.Dir = filepath.Dir(inputPath)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("pandoc failed: %w: %s", err, command.LastLines(string(output), 5))
+	}
+	data, err := os.ReadFile(htmlPath)
+	if err != nil {
+		return nil, fmt.Errorf("pandoc did not write HTML: %w", err)
+	}
+
+	converted, err := parse(data, cmd.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pandoc's output: %w", err)
+	}
+	metadata := converted.metadata
+	if metadata.Title == "" {
+		base := filepath.Base(inputPath)
+		metadata.Title = strings.TrimSuffix(base, filepath.Ext(base))
+	}
+	if metadata.Language == "" {
+		metadata.Language = "en"
+	}
+	if metadata.Identifier, err = book.Identifier(inputPath); err != nil {
+		return nil, err
+	}
+
+	chapters := book.Split(converted.blocks, converted.notes, metadata.Title)
+	if err := book.Write(dir, metadata, chapters, converted.images); err != nil {
+		return nil, err
+	}
+	return &Document{
+		Title:    metadata.Title,
+		Author:   metadata.Creator,
+		Format:   formatNames[reader],
+		Version:  version(program),
+		Chapters: len(chapters),
+		Images:   len(converted.images),
+	}, nil
+}
@@ -0,0 +1,211 @@
+package parser
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/flouciel/folian-parser/internal/policy"
+)
+
+// ComicInfo is the ComicInfo.xml metadata of a comic archive or comic EPUB, in the
+// ComicRack schema
+type ComicInfo struct {
+	Title       string
+	Series      string
+	Number      string
+	Volume      string
+	Summary     string
+	Year        string
+	Month       string
+	Day         string
+	Writer      string
+	Penciller   string
+	Inker       string
+	Colorist    string
+	Letterer    string
+	CoverArtist string
+	Editor      string
+	Publisher   string
+	Genre       string
+	Tags        string
+	LanguageISO string
+	Pages       []ComicPage `xml:"Pages>Page"`
+}
+
+// ComicPage describes a page of a comic archive, by the index of its image
+type ComicPage struct {
+	Image    int    `xml:"Image,attr"`
+	Type     string `xml:"Type,attr"`
+	Bookmark string `xml:"Bookmark,attr"`
+}
+
+// comicRoles are the MARC relator codes of the ComicInfo credits, in the order they
+// are credited
+var comicRoles = []struct {
+	field func(*ComicInfo) string
+	role  string
+}{
+	{func(c *ComicInfo) string { return c.Writer }, "aut"},
+	{func(c *ComicInfo) string { return c.Penciller }, "art"},
+	{func(c *ComicInfo) string { return c.Inker }, "ill"},
+	{func(c *ComicInfo) string { return c.Colorist }, "clr"},
+	{func(c *ComicInfo) string { return c.Letterer }, "ill"},
+	{func(c *ComicInfo) string { return c.CoverArtist }, "cov"},
+	{func(c *ComicInfo) string { return c.Editor }, "edt"},
+}
+
+// ParseComicInfo parses a ComicInfo.xml document
+func ParseComicInfo(data []byte) (*ComicInfo, error) {
+	var info ComicInfo
+	if err := xml.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("failed to parse ComicInfo.xml: %w", err)
+	}
+	return &info, nil
+}
+
+// DisplayTitle returns the title of the issue, or its series and number when it
+// has none
+func (c *ComicInfo) DisplayTitle() string {
+	title := strings.TrimSpace(c.Title)
+	series := strings.TrimSpace(c.Series)
+	switch {
+	case title != "":
+		return title
+	case series != "" && strings.TrimSpace(c.Number) != "":
+		return series + " #" + strings.TrimSpace(c.Number)
+	}
+	return series
+}
+
+// Date returns the publication date as YYYY, YYYY-MM or YYYY-MM-DD, or "" when
+// the year is unknown
+func (c *ComicInfo) Date() string {
+	year := strings.TrimSpace(c.Year)
+	if year == "" || year == "-1" {
+		return ""
+	}
+	date := year
+	for _, part := range []string{c.Month, c.Day} {
+		var n int
+		if _, err := fmt.Sscanf(strings.TrimSpace(part), "%d", &n); err != nil || n <= 0 {
+			break
+		}
+		date += fmt.Sprintf("-%02d", n)
+	}
+	return date
+}
+
+// Credits returns the people credited in the issue with their MARC relator roles,
+// each person once per role
+func (c *ComicInfo) Credits() []Contributor {
+	var credits []Contributor
+	seen := make(map[Contributor]bool)
+	for _, credit := range comicRoles {
+		for _, name := range splitComicList(credit.field(c)) {
+			contributor := Contributor{Name: name, Role: credit.role}
+			if !seen[contributor] {
+				seen[contributor] = true
+				credits = append(credits, contributor)
+			}
+		}
+	}
+	return credits
+}
+
+// Subjects returns the genres and tags of the issue
+func (c *ComicInfo) Subjects() []string {
+	return append(splitComicList(c.Genre), splitComicList(c.Tags)...)
+}
+
+// splitComicList splits a comma-separated ComicInfo list
+func splitComicList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// findComicInfo returns the path of the ComicInfo.xml of an extracted EPUB, at its
+// root, in META-INF or next to its package document, or "" when it has none
+func findComicInfo(epubPath, opfDir string) string {
+	for _, dir := range []string{epubPath, filepath.Join(epubPath, "META-INF"), opfDir} {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() && strings.EqualFold(entry.Name(), "ComicInfo.xml") {
+				return filepath.Join(dir, entry.Name())
+			}
+		}
+	}
+	return ""
+}
+
+// applyComicInfo fills the metadata the package document lacks from the book's
+// ComicInfo.xml, and adds its credits to the contributors
+func (p *EPUBParser) applyComicInfo(book *Book, opfDir string) {
+	path := findComicInfo(book.Path, opfDir)
+	if path == "" {
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		p.warn(book, policy.KindOther, "Ignoring ComicInfo.xml: %v", err)
+		return
+	}
+	info, err := ParseComicInfo(data)
+	if err != nil {
+		p.warn(book, policy.KindOther, "Ignoring ComicInfo.xml: %v", err)
+		return
+	}
+
+	meta := &book.Metadata
+	if meta.Title == "" {
+		meta.Title = info.DisplayTitle()
+	}
+	if meta.Series == "" && strings.TrimSpace(info.Series) != "" {
+		meta.Series = strings.TrimSpace(info.Series)
+		meta.SeriesIndex = strings.TrimSpace(info.Number)
+	}
+	if meta.Publisher == "" {
+		meta.Publisher = strings.TrimSpace(info.Publisher)
+	}
+	if meta.Description == "" {
+		meta.Description = strings.TrimSpace(info.Summary)
+	}
+	if date := info.Date(); meta.Date == "" && date != "" {
+		meta.Date = date
+		meta.Dates = append(meta.Dates, DateEvent{Event: "publication", Value: date})
+	}
+	if meta.Language == "" {
+		meta.Language = strings.TrimSpace(info.LanguageISO)
+	}
+	if len(meta.Subjects) == 0 {
+		meta.Subjects = info.Subjects()
+	}
+
+	// The first writer, or else the first artist, is the main creator; the
+	// others are credited as contributors once
+	credited := make(map[Contributor]bool)
+	for _, contributor := range meta.Contributors {
+		credited[contributor] = true
+	}
+	for _, credit := range info.Credits() {
+		author := credit.Role == "aut" || credit.Role == "art"
+		switch {
+		case meta.Creator == "" && author:
+			meta.Creator = credit.Name
+		case author && strings.EqualFold(credit.Name, meta.Creator):
+		case !credited[credit]:
+			credited[credit] = true
+			meta.Contributors = append(meta.Contributors, credit)
+		}
+	}
+}
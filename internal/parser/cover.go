@@ -0,0 +1,109 @@
+package parser
+
+import (
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// maxCoverPageWords is the most text a page may carry and still count as a
+// full-page image
+const maxCoverPageWords = 10
+
+// findCoverImage locates the cover image from, in order: the cover-image manifest
+// property, the EPUB2 cover meta, the guide's cover page, cover-like manifest ids
+// and a full-page image on the first spine document (common with InDesign exports).
+// An image-only first page showing the cover is recorded as the cover page.
+func (p *EPUBParser) findCoverImage(book *Book, basePath string) {
+	book.CoverImage = p.declaredCoverImage(book, basePath)
+
+	for _, spineItem := range book.Spine {
+		item, ok := book.Manifest[spineItem.IDRef]
+		if !ok || !strings.Contains(item.MediaType, "application/xhtml+xml") || p.isGeneratedPage(item) {
+			continue
+		}
+		image, fullPage := p.pageImage(book, basePath, item.Href)
+		if fullPage && (book.CoverImage == "" || book.CoverImage == image) {
+			book.CoverImage = image
+			book.CoverPage = item.Href
+		}
+		return
+	}
+}
+
+// declaredCoverImage returns the cover image declared by the manifest, metadata or
+// guide, falling back to image ids containing "cover"
+func (p *EPUBParser) declaredCoverImage(book *Book, basePath string) string {
+	for _, item := range book.Manifest {
+		if strings.Contains(" "+item.Properties+" ", " cover-image ") {
+			return item.Href
+		}
+	}
+
+	if item, ok := book.Manifest[book.coverID]; ok && strings.HasPrefix(item.MediaType, "image/") {
+		return item.Href
+	}
+
+	for _, reference := range book.Guide {
+		if reference.Type != "cover" {
+			continue
+		}
+		href := strings.SplitN(reference.Href, "#", 2)[0]
+		if image, _ := p.pageImage(book, basePath, href); image != "" {
+			return image
+		}
+	}
+
+	// Prefer the shortest matching href, e.g. cover.jpg over cover-back.jpg
+	cover := ""
+	for _, item := range book.Manifest {
+		if !strings.HasPrefix(item.MediaType, "image/") || !strings.Contains(strings.ToLower(item.ID), "cover") {
+			continue
+		}
+		if cover == "" || len(item.Href) < len(cover) || (len(item.Href) == len(cover) && item.Href < cover) {
+			cover = item.Href
+		}
+	}
+	return cover
+}
+
+// pageImage returns the manifest href of the first image on a page and whether
+// the page is a full-page image with no more than a few words of text
+func (p *EPUBParser) pageImage(book *Book, basePath, href string) (string, bool) {
+	content, err := ioutil.ReadFile(filepath.Join(basePath, filepath.FromSlash(href)))
+	if err != nil {
+		return "", false
+	}
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(string(content)))
+	if err != nil {
+		return "", false
+	}
+
+	images := doc.Find("body img, body image")
+	if images.Length() == 0 {
+		return "", false
+	}
+
+	src, ok := images.First().Attr("src")
+	if !ok {
+		src, ok = images.First().Attr("xlink:href")
+	}
+	if !ok {
+		src, _ = images.First().Attr("href")
+	}
+	if src == "" || strings.Contains(src, "://") {
+		return "", false
+	}
+
+	image := path.Join(path.Dir(href), fileHref(src))
+	for _, item := range book.Manifest {
+		if item.Href == image && strings.HasPrefix(item.MediaType, "image/") {
+			words := len(strings.Fields(doc.Find("body").Text()))
+			return image, images.Length() == 1 && words <= maxCoverPageWords
+		}
+	}
+	return "", false
+}
@@ -5,10 +5,16 @@ import (
 	"fmt"
 	"html"
 	"io/ioutil"
+	"net/url"
+	"path"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
 
 	"github.com/PuerkitoBio/goquery"
+	"github.com/flouciel/folian-parser/internal/policy"
+	nethtml "golang.org/x/net/html"
 )
 
 // EPUBParser parses EPUB files
@@ -22,14 +28,27 @@ func NewEPUBParser() *EPUBParser {
 // Book represents the parsed EPUB book
 type Book struct {
 	Path        string
+	OPFPath     string
 	Metadata    Metadata
 	Spine       []SpineItem
 	Manifest    map[string]ManifestItem
 	CoverImage  string
+	CoverPage   string
 	Stylesheets []string
 	Fonts       []string
 	Images      []string
 	Chapters    []Chapter
+	TOC         []TOCEntry
+	Guide       []GuideReference
+	// Renditions lists the OPF rootfiles of container.xml; Rendition is the index of
+	// the parsed one
+	Renditions []Rendition
+	Rendition  int
+	// Warnings are the problems found while parsing, repeated when the parse is
+	// read back from the cache
+	Warnings []policy.Warning
+
+	coverID string
 }
 
 // Metadata contains the book metadata
@@ -41,6 +60,48 @@ type Metadata struct {
 	Publisher   string
 	Description string
 	Date        string
+	Series      string
+	SeriesIndex string
+	Subjects    []string
+	// Dates lists every dated event (publication, creation, modification); Date is the publication date
+	Dates []DateEvent
+	// Identifiers lists every dc:identifier; Identifier is the package's unique identifier
+	Identifiers []Identifier
+	// Contributors lists the creators after the first and the other contributors
+	Contributors []Contributor
+	// Producers names the tools that wrote the input, from its generator metas and
+	// book producer contributors
+	Producers []string
+	// Metas lists the meta elements of the package that refine nothing
+	Metas []Meta
+}
+
+// Meta is an EPUB2 name/content or EPUB3 property meta element of the package
+type Meta struct {
+	Name     string
+	Content  string
+	Property string
+	Value    string
+}
+
+// Contributor is a person credited for the book besides its main creator
+type Contributor struct {
+	Name string
+	// Role is the MARC relator code of the contribution, e.g. "art" for an artist
+	Role string
+}
+
+// DateEvent represents a dc:date with its opf:event, or an EPUB3 dcterms date
+type DateEvent struct {
+	Event string
+	Value string
+}
+
+// Identifier represents a dc:identifier with its scheme, if declared
+type Identifier struct {
+	ID     string
+	Scheme string
+	Value  string
 }
 
 // ManifestItem represents an item in the EPUB manifest
@@ -58,12 +119,55 @@ type SpineItem struct {
 	Properties string
 }
 
+// GuideReference represents a reference in the EPUB2 OPF guide
+type GuideReference struct {
+	Type  string
+	Title string
+	Href  string
+}
+
 // Chapter represents a chapter in the book
 type Chapter struct {
 	ID      string
 	Title   string
 	Content string
 	Order   int
+	// Sources lists the IDs of the original chapters merged into this one
+	Sources []string
+	// NonLinear marks auxiliary content (linear="no") outside the main reading flow
+	NonLinear bool
+}
+
+// TOCEntry represents an entry in the book's table of contents
+type TOCEntry struct {
+	Title    string
+	Href     string
+	Children []TOCEntry
+}
+
+// Text returns the normalized plain text of the chapter body, keeping the text
+// of adjacent block elements separated
+func (c Chapter) Text() string {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(c.Content))
+	if err != nil {
+		return ""
+	}
+
+	var words []string
+	var walk func(node *nethtml.Node)
+	walk = func(node *nethtml.Node) {
+		if node.Type == nethtml.TextNode {
+			words = append(words, strings.Fields(node.Data)...)
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	for _, node := range doc.Find("body").Nodes {
+		walk(node)
+	}
+
+	return strings.Join(words, " ")
 }
 
 // Parse parses an extracted EPUB file
@@ -75,57 +179,54 @@ func (p *EPUBParser) Parse(epubPath string) (*Book, error) {
 
 	// Find and parse the container.xml file
 	containerPath := filepath.Join(epubPath, "META-INF", "container.xml")
-	rootFilePath, err := p.parseContainer(containerPath)
+	renditions, err := parseRenditions(containerPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse container.xml: %w", err)
 	}
+	book.Renditions = renditions
+	book.Rendition, err = selectRendition(renditions)
+	if err != nil {
+		return nil, err
+	}
+	rootFilePath := renditions[book.Rendition].FullPath
 
 	// Parse the OPF file
+	book.OPFPath = rootFilePath
 	opfPath := filepath.Join(epubPath, rootFilePath)
 	err = p.parseOPF(opfPath, book)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse OPF file: %w", err)
 	}
 
+	// Fill the metadata of a comic from its ComicInfo.xml
+	p.applyComicInfo(book, filepath.Dir(opfPath))
+
+	// Locate the cover image
+	p.findCoverImage(book, filepath.Dir(opfPath))
+
 	// Categorize files and parse chapters
 	err = p.categorizeFiles(book, filepath.Dir(opfPath))
 	if err != nil {
 		return nil, fmt.Errorf("failed to categorize files: %w", err)
 	}
 
-	return book, nil
-}
-
-// parseContainer parses the container.xml file to find the OPF file
-func (p *EPUBParser) parseContainer(containerPath string) (string, error) {
-	data, err := ioutil.ReadFile(containerPath)
-	if err != nil {
-		return "", fmt.Errorf("failed to read container.xml: %w", err)
+	// Parse the table of contents; lenient runs continue without it, since the
+	// navigation is rebuilt from the chapters
+	err = p.parseTOC(book, filepath.Dir(opfPath))
+	if err != nil && policy.Lenient {
+		p.warn(book, policy.KindOther, "Ignoring the table of contents: %v", err)
+		book.TOC = nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to parse table of contents: %w", err)
 	}
 
-	type RootFile struct {
-		FullPath  string `xml:"full-path,attr"`
-		MediaType string `xml:"media-type,attr"`
-	}
-
-	type RootFiles struct {
-		RootFile []RootFile `xml:"rootfile"`
-	}
-
-	type Container struct {
-		RootFiles RootFiles `xml:"rootfiles"`
-	}
-
-	var container Container
-	if err := xml.Unmarshal(data, &container); err != nil {
-		return "", fmt.Errorf("failed to unmarshal container.xml: %w", err)
-	}
-
-	if len(container.RootFiles.RootFile) == 0 {
-		return "", fmt.Errorf("no root file found in container.xml")
-	}
+	return book, nil
+}
 
-	return container.RootFiles.RootFile[0].FullPath, nil
+// warn prints a warning about the book and keeps it with the book
+func (p *EPUBParser) warn(book *Book, kind, format string, args ...interface{}) {
+	policy.Warn(kind, format, args...)
+	book.Warnings = append(book.Warnings, policy.Warning{Kind: kind, Message: fmt.Sprintf(format, args...)})
 }
 
 // parseOPF parses the OPF file
@@ -136,14 +237,35 @@ func (p *EPUBParser) parseOPF(opfPath string, book *Book) error {
 	}
 
 	type Package struct {
-		Metadata struct {
+		UniqueIdentifier string `xml:"unique-identifier,attr"`
+		Metadata         struct {
 			Title       []string `xml:"title"`
-			Creator     []string `xml:"creator"`
+			Creator     []opfContributor `xml:"creator"`
+			Contributor []opfContributor `xml:"contributor"`
 			Language    []string `xml:"language"`
-			Identifier  []string `xml:"identifier"`
+			Identifier  []struct {
+				ID     string `xml:"id,attr"`
+				Scheme string `xml:"scheme,attr"`
+				Value  string `xml:",chardata"`
+			} `xml:"identifier"`
 			Publisher   []string `xml:"publisher"`
 			Description []string `xml:"description"`
-			Date        []string `xml:"date"`
+			Date        []struct {
+				Event string `xml:"event,attr"`
+				Value string `xml:",chardata"`
+			} `xml:"date"`
+			Subject     []struct {
+				ID    string `xml:"id,attr"`
+				Value string `xml:",chardata"`
+			} `xml:"subject"`
+			Metas       []struct {
+				ID       string `xml:"id,attr"`
+				Name     string `xml:"name,attr"`
+				Content  string `xml:"content,attr"`
+				Property string `xml:"property,attr"`
+				Refines  string `xml:"refines,attr"`
+				Value    string `xml:",chardata"`
+			} `xml:"meta"`
 		} `xml:"metadata"`
 		Manifest struct {
 			Items []struct {
@@ -160,6 +282,13 @@ func (p *EPUBParser) parseOPF(opfPath string, book *Book) error {
 				Properties string `xml:"properties,attr"`
 			} `xml:"itemref"`
 		} `xml:"spine"`
+		Guide struct {
+			References []struct {
+				Type  string `xml:"type,attr"`
+				Title string `xml:"title,attr"`
+				Href  string `xml:"href,attr"`
+			} `xml:"reference"`
+		} `xml:"guide"`
 	}
 
 	var pkg Package
@@ -172,13 +301,25 @@ func (p *EPUBParser) parseOPF(opfPath string, book *Book) error {
 		book.Metadata.Title = pkg.Metadata.Title[0]
 	}
 	if len(pkg.Metadata.Creator) > 0 {
-		book.Metadata.Creator = pkg.Metadata.Creator[0]
+		book.Metadata.Creator = pkg.Metadata.Creator[0].Value
 	}
 	if len(pkg.Metadata.Language) > 0 {
 		book.Metadata.Language = pkg.Metadata.Language[0]
 	}
-	if len(pkg.Metadata.Identifier) > 0 {
-		book.Metadata.Identifier = pkg.Metadata.Identifier[0]
+	for _, identifier := range pkg.Metadata.Identifier {
+		value := strings.TrimSpace(identifier.Value)
+		if value == "" {
+			continue
+		}
+		book.Metadata.Identifiers = append(book.Metadata.Identifiers, Identifier{
+			ID:     identifier.ID,
+			Scheme: identifier.Scheme,
+			Value:  value,
+		})
+		// Prefer the identifier referenced by the package's unique-identifier
+		if book.Metadata.Identifier == "" || (identifier.ID != "" && identifier.ID == pkg.UniqueIdentifier) {
+			book.Metadata.Identifier = value
+		}
 	}
 	if len(pkg.Metadata.Publisher) > 0 {
 		book.Metadata.Publisher = pkg.Metadata.Publisher[0]
@@ -186,22 +327,144 @@ func (p *EPUBParser) parseOPF(opfPath string, book *Book) error {
 	if len(pkg.Metadata.Description) > 0 {
 		book.Metadata.Description = pkg.Metadata.Description[0]
 	}
-	if len(pkg.Metadata.Date) > 0 {
-		book.Metadata.Date = pkg.Metadata.Date[0]
+	for _, date := range pkg.Metadata.Date {
+		value := strings.TrimSpace(date.Value)
+		if value == "" {
+			continue
+		}
+		event := strings.ToLower(date.Event)
+		if event == "" {
+			event = "publication"
+		}
+		book.Metadata.Dates = append(book.Metadata.Dates, DateEvent{Event: event, Value: value})
+		if event == "publication" && book.Metadata.Date == "" {
+			book.Metadata.Date = value
+		}
+	}
+
+	// Extract free-form subjects, skipping coded subjects (BISAC, Thema) which are
+	// refined with an authority and regenerated on output
+	codedSubjects := make(map[string]bool)
+	for _, meta := range pkg.Metadata.Metas {
+		if meta.Property == "authority" {
+			codedSubjects[strings.TrimPrefix(meta.Refines, "#")] = true
+		}
+	}
+	for _, subject := range pkg.Metadata.Subject {
+		value := strings.TrimSpace(subject.Value)
+		if value != "" && (subject.ID == "" || !codedSubjects[subject.ID]) {
+			book.Metadata.Subjects = append(book.Metadata.Subjects, value)
+		}
+	}
+
+	// Extract EPUB3 creation and issue dates
+	for _, meta := range pkg.Metadata.Metas {
+		value := strings.TrimSpace(meta.Value)
+		switch {
+		case value == "" || meta.Refines != "":
+		case meta.Property == "dcterms:created":
+			book.Metadata.Dates = append(book.Metadata.Dates, DateEvent{Event: "creation", Value: value})
+		case meta.Property == "dcterms:issued" && book.Metadata.Date == "":
+			book.Metadata.Date = value
+			book.Metadata.Dates = append(book.Metadata.Dates, DateEvent{Event: "publication", Value: value})
+		}
+	}
+
+	// Extract series from EPUB3 collections, falling back to calibre metadata
+	for _, meta := range pkg.Metadata.Metas {
+		if meta.Property != "belongs-to-collection" || book.Metadata.Series != "" {
+			continue
+		}
+		collectionType, position := "", ""
+		for _, refinement := range pkg.Metadata.Metas {
+			if meta.ID == "" || refinement.Refines != "#"+meta.ID {
+				continue
+			}
+			switch refinement.Property {
+			case "collection-type":
+				collectionType = strings.TrimSpace(refinement.Value)
+			case "group-position":
+				position = strings.TrimSpace(refinement.Value)
+			}
+		}
+		if collectionType == "" || collectionType == "series" {
+			book.Metadata.Series = strings.TrimSpace(meta.Value)
+			book.Metadata.SeriesIndex = position
+		}
+	}
+	for _, meta := range pkg.Metadata.Metas {
+		switch {
+		case meta.Name == "calibre:series" && book.Metadata.Series == "":
+			book.Metadata.Series = strings.TrimSpace(meta.Content)
+		case meta.Name == "calibre:series_index" && book.Metadata.SeriesIndex == "":
+			book.Metadata.SeriesIndex = strings.TrimSpace(meta.Content)
+		}
+	}
+
+	// Extract the other creators and contributors with their roles, from EPUB3
+	// refinements or EPUB2 opf:role attributes
+	roles := make(map[string]string)
+	for _, meta := range pkg.Metadata.Metas {
+		if meta.Property == "role" && meta.Refines != "" {
+			roles[strings.TrimPrefix(meta.Refines, "#")] = strings.TrimSpace(meta.Value)
+		}
+	}
+	var contributors []opfContributor
+	if len(pkg.Metadata.Creator) > 1 {
+		contributors = append(contributors, pkg.Metadata.Creator[1:]...)
+	}
+	contributors = append(contributors, pkg.Metadata.Contributor...)
+	for i, contributor := range contributors {
+		name := strings.TrimSpace(contributor.Value)
+		role := contributor.Role
+		if refined, ok := roles[contributor.ID]; ok && contributor.ID != "" {
+			role = refined
+		}
+		if role == "" && i < len(pkg.Metadata.Creator)-1 {
+			role = "aut"
+		}
+		// The book producer is the tool that wrote the input, which the output
+		// names as its generator instead
+		if name == "" || role == "bkp" {
+			if name != "" {
+				book.Metadata.Producers = append(book.Metadata.Producers, name)
+			}
+			continue
+		}
+		book.Metadata.Contributors = append(book.Metadata.Contributors, Contributor{Name: name, Role: role})
+	}
+
+	// Extract the meta elements and the tools named by generator metas; Sigil and
+	// calibre name themselves in metas of their own
+	for _, meta := range pkg.Metadata.Metas {
+		content := strings.TrimSpace(meta.Content)
+		if meta.Refines == "" {
+			book.Metadata.Metas = append(book.Metadata.Metas, Meta{Name: meta.Name, Content: content, Property: meta.Property, Value: strings.TrimSpace(meta.Value)})
+		}
+		switch {
+		case meta.Name == "generator" && content != "":
+			book.Metadata.Producers = append(book.Metadata.Producers, content)
+		case meta.Name == "Sigil version" && content != "":
+			book.Metadata.Producers = append(book.Metadata.Producers, "Sigil "+content)
+		case strings.HasPrefix(meta.Name, "calibre:") && !slices.Contains(book.Metadata.Producers, "calibre"):
+			book.Metadata.Producers = append(book.Metadata.Producers, "calibre")
+		}
 	}
 
 	// Extract manifest
 	for _, item := range pkg.Manifest.Items {
 		book.Manifest[item.ID] = ManifestItem{
 			ID:         item.ID,
-			Href:       item.Href,
+			Href:       fileHref(item.Href),
 			MediaType:  item.MediaType,
 			Properties: item.Properties,
 		}
+	}
 
-		// Check for cover image
-		if item.Properties == "cover-image" {
-			book.CoverImage = item.Href
+	// Remember the EPUB2 cover declaration for findCoverImage
+	for _, meta := range pkg.Metadata.Metas {
+		if meta.Name == "cover" {
+			book.coverID = strings.TrimSpace(meta.Content)
 		}
 	}
 
@@ -214,9 +477,44 @@ func (p *EPUBParser) parseOPF(opfPath string, book *Book) error {
 		})
 	}
 
+	// Extract guide
+	for _, reference := range pkg.Guide.References {
+		book.Guide = append(book.Guide, GuideReference{
+			Type:  reference.Type,
+			Title: reference.Title,
+			Href:  fileHref(reference.Href),
+		})
+	}
+
 	return nil
 }
 
+// opfContributor is a dc:creator or dc:contributor of the package document
+type opfContributor struct {
+	ID    string `xml:"id,attr"`
+	Role  string `xml:"role,attr"`
+	Value string `xml:",chardata"`
+}
+
+// slashHref normalizes the backslash separators some Windows tools write in hrefs
+// to the forward slashes of URLs
+func slashHref(href string) string {
+	return strings.ReplaceAll(href, "\\", "/")
+}
+
+// fileHref decodes the percent-encoded path of an href (RFC 3986), so that it names
+// the file it points at; the fragment is kept as written
+func fileHref(href string) string {
+	file, fragment := slashHref(href), ""
+	if i := strings.Index(file, "#"); i >= 0 {
+		file, fragment = file[:i], file[i:]
+	}
+	if decoded, err := url.PathUnescape(file); err == nil {
+		file = decoded
+	}
+	return file + fragment
+}
+
 // categorizeFiles categorizes files in the EPUB and parses chapters
 func (p *EPUBParser) categorizeFiles(book *Book, basePath string) error {
 	// Categorize files by type
@@ -231,10 +529,16 @@ func (p *EPUBParser) categorizeFiles(book *Book, basePath string) error {
 		}
 	}
 
+	// Keep resource order stable since the manifest is stored in a map
+	sort.Strings(book.Stylesheets)
+	sort.Strings(book.Fonts)
+	sort.Strings(book.Images)
+
 	// Parse chapters based on spine
 	for i, spineItem := range book.Spine {
 		manifestItem, ok := book.Manifest[spineItem.IDRef]
 		if !ok {
+			p.warn(book, policy.KindLink, "Spine item %q is not in the manifest", spineItem.IDRef)
 			continue
 		}
 
@@ -243,10 +547,18 @@ func (p *EPUBParser) categorizeFiles(book *Book, basePath string) error {
 			continue
 		}
 
+		// Skip navigation documents and pages that are regenerated on output
+		if p.isGeneratedPage(manifestItem) || manifestItem.Href == book.CoverPage {
+			continue
+		}
+
 		// Read the chapter content
 		chapterPath := filepath.Join(basePath, manifestItem.Href)
 		content, err := ioutil.ReadFile(chapterPath)
-		if err != nil {
+		if err != nil && policy.Lenient {
+			p.warn(book, policy.KindChapter, "Skipping chapter %s: %v", manifestItem.Href, err)
+			continue
+		} else if err != nil {
 			return fmt.Errorf("failed to read chapter file: %w", err)
 		}
 
@@ -255,10 +567,11 @@ func (p *EPUBParser) categorizeFiles(book *Book, basePath string) error {
 
 		// Create chapter
 		chapter := Chapter{
-			ID:      manifestItem.ID,
-			Title:   title,
-			Content: string(content),
-			Order:   i,
+			ID:        manifestItem.ID,
+			Title:     title,
+			Content:   string(content),
+			Order:     i,
+			NonLinear: strings.TrimSpace(spineItem.Linear) == "no",
 		}
 
 		book.Chapters = append(book.Chapters, chapter)
@@ -267,6 +580,139 @@ func (p *EPUBParser) categorizeFiles(book *Book, basePath string) error {
 	return nil
 }
 
+// parseTOC parses the table of contents from the EPUB3 navigation document,
+// falling back to the NCX file for EPUB2 books
+func (p *EPUBParser) parseTOC(book *Book, basePath string) error {
+	var navItem, ncxItem *ManifestItem
+	for _, item := range book.Manifest {
+		item := item
+		switch {
+		case strings.Contains(" "+item.Properties+" ", " nav "):
+			navItem = &item
+		case item.MediaType == "application/x-dtbncx+xml":
+			ncxItem = &item
+		}
+	}
+
+	if navItem != nil {
+		content, err := ioutil.ReadFile(filepath.Join(basePath, navItem.Href))
+		if err != nil {
+			return fmt.Errorf("failed to read navigation document: %w", err)
+		}
+		book.TOC = p.parseNavTOC(string(content), path.Dir(navItem.Href))
+		if len(book.TOC) > 0 {
+			return nil
+		}
+	}
+
+	if ncxItem != nil {
+		content, err := ioutil.ReadFile(filepath.Join(basePath, ncxItem.Href))
+		if err != nil {
+			return fmt.Errorf("failed to read toc.ncx: %w", err)
+		}
+		book.TOC, err = p.parseNCXTOC(content, path.Dir(ncxItem.Href))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parseNavTOC extracts the entries of the toc nav element of an EPUB3 navigation document
+func (p *EPUBParser) parseNavTOC(content, navDir string) []TOCEntry {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(content))
+	if err != nil {
+		return nil
+	}
+
+	var entries []TOCEntry
+	doc.Find("nav").EachWithBreak(func(i int, nav *goquery.Selection) bool {
+		if epubType, _ := nav.Attr("epub:type"); epubType != "toc" {
+			return true
+		}
+		entries = p.parseNavList(nav.ChildrenFiltered("ol"), navDir)
+		return false
+	})
+
+	return entries
+}
+
+// parseNavList recursively converts a nav ol element into TOC entries
+func (p *EPUBParser) parseNavList(list *goquery.Selection, navDir string) []TOCEntry {
+	var entries []TOCEntry
+	list.ChildrenFiltered("li").Each(func(i int, li *goquery.Selection) {
+		link := li.ChildrenFiltered("a, span").First()
+		href, _ := link.Attr("href")
+		if href != "" {
+			href = path.Join(navDir, fileHref(href))
+		}
+		entries = append(entries, TOCEntry{
+			Title:    strings.Join(strings.Fields(link.Text()), " "),
+			Href:     href,
+			Children: p.parseNavList(li.ChildrenFiltered("ol"), navDir),
+		})
+	})
+	return entries
+}
+
+// parseNCXTOC extracts the navMap entries of an EPUB2 NCX file
+func (p *EPUBParser) parseNCXTOC(data []byte, ncxDir string) ([]TOCEntry, error) {
+	type NavPoint struct {
+		Label   string `xml:"navLabel>text"`
+		Content struct {
+			Src string `xml:"src,attr"`
+		} `xml:"content"`
+		NavPoints []NavPoint `xml:"navPoint"`
+	}
+
+	type NCX struct {
+		NavPoints []NavPoint `xml:"navMap>navPoint"`
+	}
+
+	var ncx NCX
+	if err := xml.Unmarshal(data, &ncx); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal toc.ncx: %w", err)
+	}
+
+	var convert func(points []NavPoint) []TOCEntry
+	convert = func(points []NavPoint) []TOCEntry {
+		var entries []TOCEntry
+		for _, point := range points {
+			href := point.Content.Src
+			if href != "" {
+				href = path.Join(ncxDir, fileHref(href))
+			}
+			entries = append(entries, TOCEntry{
+				Title:    strings.TrimSpace(point.Label),
+				Href:     href,
+				Children: convert(point.NavPoints),
+			})
+		}
+		return entries
+	}
+
+	return convert(ncx.NavPoints), nil
+}
+
+// isGeneratedPage reports whether a manifest item is a navigation document or a
+// title/jacket page that the restructurer generates itself, so that processing
+// an already restructured EPUB does not turn them into chapters
+func (p *EPUBParser) isGeneratedPage(item ManifestItem) bool {
+	for _, prop := range strings.Fields(item.Properties) {
+		if prop == "nav" {
+			return true
+		}
+	}
+
+	switch filepath.Base(item.Href) {
+	case "nav.xhtml", "titlepage.xhtml", "jacket.xhtml":
+		return true
+	}
+
+	return false
+}
+
 // extractTitle extracts the title from HTML content using proper HTML parsing
 func (p *EPUBParser) extractTitle(content string) string {
 	// Parse HTML content
@@ -331,4 +777,4 @@ func (p *EPUBParser) extractTitleFallback(content string) string {
 	}
 
 	return "Untitled"
-}
\ No newline at end of file
+}
@@ -0,0 +1,124 @@
+package parser
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// SelectRendition chooses the rendition to parse in EPUBs declaring several in
+// container.xml: its 1-based position, label, language, layout or OPF path. The
+// first rendition is parsed by default
+var SelectRendition string
+
+// Rendition is a rootfile of container.xml with its EPUB Multiple-Rendition
+// selection attributes
+type Rendition struct {
+	FullPath   string
+	MediaType  string
+	Label      string
+	Language   string
+	Layout     string
+	Media      string
+	AccessMode string
+}
+
+// Describe returns a one-line description of the rendition
+func (r Rendition) Describe() string {
+	var details []string
+	for _, detail := range []string{r.Label, r.Language, r.Layout, r.Media, r.AccessMode} {
+		if detail != "" {
+			details = append(details, detail)
+		}
+	}
+	if len(details) == 0 {
+		return r.FullPath
+	}
+	return fmt.Sprintf("%s (%s)", r.FullPath, strings.Join(details, ", "))
+}
+
+// matches reports whether the rendition is selected by a label, language, layout
+// or path
+func (r Rendition) matches(selector string) bool {
+	for _, value := range []string{r.FullPath, r.Label, r.Layout, r.Media, r.AccessMode} {
+		if value != "" && strings.EqualFold(value, selector) {
+			return true
+		}
+	}
+	language := strings.ToLower(r.Language)
+	selector = strings.ToLower(selector)
+	return language != "" && (language == selector || strings.HasPrefix(language, selector+"-"))
+}
+
+// parseRenditions reads every OPF rootfile declared by container.xml
+func parseRenditions(containerPath string) ([]Rendition, error) {
+	data, err := ioutil.ReadFile(containerPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read container.xml: %w", err)
+	}
+
+	type RootFile struct {
+		FullPath  string     `xml:"full-path,attr"`
+		MediaType string     `xml:"media-type,attr"`
+		Attrs     []xml.Attr `xml:",any,attr"`
+	}
+
+	type Container struct {
+		RootFiles []RootFile `xml:"rootfiles>rootfile"`
+	}
+
+	var container Container
+	if err := xml.Unmarshal(data, &container); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal container.xml: %w", err)
+	}
+
+	var renditions []Rendition
+	for _, rootFile := range container.RootFiles {
+		// Other rootfiles, such as PDF versions, are not EPUB renditions
+		if rootFile.MediaType != "" && rootFile.MediaType != "application/oebps-package+xml" {
+			continue
+		}
+		rendition := Rendition{FullPath: slashHref(rootFile.FullPath), MediaType: rootFile.MediaType}
+		for _, attr := range rootFile.Attrs {
+			switch attr.Name.Local {
+			case "label":
+				rendition.Label = attr.Value
+			case "language":
+				rendition.Language = attr.Value
+			case "layout":
+				rendition.Layout = attr.Value
+			case "media":
+				rendition.Media = attr.Value
+			case "accessMode":
+				rendition.AccessMode = attr.Value
+			}
+		}
+		renditions = append(renditions, rendition)
+	}
+
+	if len(renditions) == 0 {
+		return nil, fmt.Errorf("no root file found in container.xml")
+	}
+	return renditions, nil
+}
+
+// selectRendition returns the index of the rendition chosen by SelectRendition
+func selectRendition(renditions []Rendition) (int, error) {
+	if SelectRendition == "" {
+		return 0, nil
+	}
+	if n, err := strconv.Atoi(SelectRendition); err == nil {
+		if n < 1 || n > len(renditions) {
+			return 0, fmt.Errorf("rendition %d does not exist (the EPUB has %d)", n, len(renditions))
+		}
+		return n - 1, nil
+	}
+	for i, rendition := range renditions {
+		if rendition.matches(SelectRendition) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("no rendition matches %q", SelectRendition)
+}
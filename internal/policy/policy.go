@@ -0,0 +1,86 @@
+// Package policy holds the processing policy shared by the parser, the restructurer
+// and the validator: strict runs fail on any warning about the book, lenient runs
+// continue past errors in single chapters
+package policy
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/flouciel/folian-parser/internal/events"
+)
+
+// Strict fails the run when any warning was recorded
+var Strict bool
+
+// Lenient skips chapters that cannot be read or written instead of failing the run
+var Lenient bool
+
+// Warning kinds
+const (
+	KindAltText    = "alt-text"
+	KindDate       = "date"
+	KindLink       = "link"
+	KindChapter    = "chapter"
+	KindValidation = "validation"
+	KindSpelling   = "spelling"
+	KindLexicon    = "lexicon"
+	KindOther      = "other"
+)
+
+// Warning is a problem with the book that does not stop processing
+type Warning struct {
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+}
+
+// warnings are the warnings recorded for the current input
+var warnings []Warning
+
+// Warn records a warning for the strict check and publishes it, for the command
+// to print
+func Warn(kind, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	warnings = append(warnings, Warning{Kind: kind, Message: message})
+	events.Publish(events.WarningRaised{Kind: kind, Message: message})
+}
+
+// Warnings returns the warnings recorded for the current input
+func Warnings() []Warning {
+	return warnings
+}
+
+// Reset forgets the recorded warnings, before the next input
+func Reset() {
+	warnings = nil
+}
+
+// ChapterError handles an error confined to one chapter: lenient runs warn and
+// return nil so that the chapter is skipped, other runs return the error
+func ChapterError(chapter string, err error) error {
+	if !Lenient {
+		return err
+	}
+	Warn(KindChapter, "Skipping chapter %s: %v", chapter, err)
+	return nil
+}
+
+// Check returns an error in strict runs when warnings were recorded
+func Check() error {
+	if !Strict || len(warnings) == 0 {
+		return nil
+	}
+	kinds := make(map[string]int)
+	var order []string
+	for _, warning := range warnings {
+		if kinds[warning.Kind] == 0 {
+			order = append(order, warning.Kind)
+		}
+		kinds[warning.Kind]++
+	}
+	counts := make([]string, len(order))
+	for i, kind := range order {
+		counts[i] = fmt.Sprintf("%d %s", kinds[kind], kind)
+	}
+	return fmt.Errorf("strict mode: %d warnings (%s)", len(warnings), strings.Join(counts, ", "))
+}
@@ -0,0 +1,311 @@
+package quality
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/flouciel/folian-parser/internal/parser"
+)
+
+// Categories scored by the quality report
+const (
+	CategoryStructure     = "structure"
+	CategoryMetadata      = "metadata"
+	CategoryAccessibility = "accessibility"
+	CategoryMarkup        = "markup"
+)
+
+// Finding severities
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+	SeverityInfo    = "info"
+)
+
+// minChapterWords is the word count below which a chapter is reported as suspiciously short
+const minChapterWords = 50
+
+// Report is a graded quality assessment of a book
+type Report struct {
+	Title    string         `json:"title"`
+	Score    int            `json:"score"`
+	Grade    string         `json:"grade"`
+	Scores   map[string]int `json:"scores"`
+	Findings []Finding      `json:"findings"`
+}
+
+// Finding is an actionable issue found while assessing a book
+type Finding struct {
+	Category string `json:"category"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+	Penalty  int    `json:"penalty"`
+}
+
+// Assess grades the structure, metadata completeness, accessibility and markup
+// cleanliness of a parsed book
+func Assess(book *parser.Book) *Report {
+	report := &Report{
+		Title:  book.Metadata.Title,
+		Scores: make(map[string]int),
+	}
+
+	checkStructure(book, report)
+	checkMetadata(book, report)
+	checkContent(book, report)
+
+	// Each category starts at 100 and loses the penalties of its findings
+	total := 0
+	for _, category := range []string{CategoryStructure, CategoryMetadata, CategoryAccessibility, CategoryMarkup} {
+		score := 100
+		for _, finding := range report.Findings {
+			if finding.Category == category {
+				score -= finding.Penalty
+			}
+		}
+		if score < 0 {
+			score = 0
+		}
+		report.Scores[category] = score
+		total += score
+	}
+	report.Score = total / 4
+	report.Grade = grade(report.Score)
+
+	return report
+}
+
+// add records a finding with its score penalty
+func (r *Report) add(category, severity string, penalty int, format string, args ...interface{}) {
+	r.Findings = append(r.Findings, Finding{
+		Category: category,
+		Severity: severity,
+		Message:  fmt.Sprintf(format, args...),
+		Penalty:  penalty,
+	})
+}
+
+// checkStructure checks the spine, table of contents and chapter sizes
+func checkStructure(book *parser.Book, report *Report) {
+	if len(book.Spine) == 0 {
+		report.add(CategoryStructure, SeverityError, 50, "Spine is empty; reading order cannot be determined")
+	}
+
+	missing := 0
+	for _, item := range book.Spine {
+		if _, ok := book.Manifest[item.IDRef]; !ok {
+			missing++
+		}
+	}
+	if missing > 0 {
+		report.add(CategoryStructure, SeverityError, capPenalty(10*missing, 40),
+			"%d spine items reference missing manifest entries; check the OPF spine", missing)
+	}
+
+	if len(book.Chapters) == 0 {
+		report.add(CategoryStructure, SeverityError, 50, "No readable XHTML chapters found in the spine")
+	}
+
+	if len(book.TOC) == 0 {
+		report.add(CategoryStructure, SeverityWarning, 20, "No table of contents found (nav.xhtml or toc.ncx); chapter titles will be derived from headings")
+	} else {
+		hrefs := make(map[string]bool)
+		for _, item := range book.Manifest {
+			hrefs[path.Clean(item.Href)] = true
+		}
+		broken := countBrokenTOCEntries(book.TOC, hrefs)
+		if broken > 0 {
+			report.add(CategoryStructure, SeverityWarning, capPenalty(5*broken, 30),
+				"%d table of contents entries point to files missing from the manifest", broken)
+		}
+	}
+
+	short := 0
+	for _, chapter := range book.Chapters {
+		if len(strings.Fields(chapter.Text())) < minChapterWords {
+			short++
+		}
+	}
+	if short > 0 {
+		report.add(CategoryStructure, SeverityInfo, capPenalty(2*short, 20),
+			"%d chapters have fewer than %d words; consider -enhanced to consolidate them", short, minChapterWords)
+	}
+}
+
+// countBrokenTOCEntries counts TOC entries whose target file is not in the manifest
+func countBrokenTOCEntries(entries []parser.TOCEntry, hrefs map[string]bool) int {
+	broken := 0
+	for _, entry := range entries {
+		target := strings.SplitN(entry.Href, "#", 2)[0]
+		if target != "" && !hrefs[path.Clean(target)] {
+			broken++
+		}
+		broken += countBrokenTOCEntries(entry.Children, hrefs)
+	}
+	return broken
+}
+
+// checkMetadata checks that the descriptive metadata is complete
+func checkMetadata(book *parser.Book, report *Report) {
+	fields := []struct {
+		name     string
+		value    string
+		severity string
+		penalty  int
+	}{
+		{"title", book.Metadata.Title, SeverityError, 25},
+		{"creator", book.Metadata.Creator, SeverityError, 20},
+		{"language", book.Metadata.Language, SeverityWarning, 15},
+		{"identifier", book.Metadata.Identifier, SeverityWarning, 15},
+		{"publisher", book.Metadata.Publisher, SeverityInfo, 5},
+		{"description", book.Metadata.Description, SeverityInfo, 10},
+		{"date", book.Metadata.Date, SeverityInfo, 5},
+	}
+	for _, field := range fields {
+		if strings.TrimSpace(field.value) == "" {
+			report.add(CategoryMetadata, field.severity, field.penalty, "Missing dc:%s in the OPF metadata", field.name)
+		}
+	}
+
+	if book.CoverImage == "" {
+		report.add(CategoryMetadata, SeverityWarning, 5, "No cover image declared; title and jacket pages will not be generated")
+	}
+}
+
+// checkContent checks the chapter markup for accessibility and cleanliness issues
+func checkContent(book *parser.Book, report *Report) {
+	var images, missingAlt, withoutHeadings, publisherClasses, inlineStyles, emptyElements, fontTags int
+
+	for _, chapter := range book.Chapters {
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(chapter.Content))
+		if err != nil {
+			continue
+		}
+
+		doc.Find("img").Each(func(i int, s *goquery.Selection) {
+			images++
+			if _, ok := s.Attr("alt"); !ok {
+				missingAlt++
+			}
+		})
+
+		if doc.Find("h1, h2, h3, h4, h5, h6").Length() == 0 {
+			withoutHeadings++
+		}
+
+		doc.Find("[class]").Each(func(i int, s *goquery.Selection) {
+			class, _ := s.Attr("class")
+			for _, cls := range strings.Fields(strings.ToLower(class)) {
+				if strings.Contains(cls, "calibre") || strings.HasPrefix(cls, "sgc-") ||
+					strings.HasPrefix(cls, "kobo-") || strings.HasPrefix(cls, "adobe-") {
+					publisherClasses++
+					break
+				}
+			}
+		})
+
+		inlineStyles += doc.Find("[style]").Length()
+		fontTags += doc.Find("font").Length()
+		doc.Find("div, span").Each(func(i int, s *goquery.Selection) {
+			if strings.TrimSpace(s.Text()) == "" && s.Children().Length() == 0 {
+				emptyElements++
+			}
+		})
+	}
+
+	if book.Metadata.Language == "" {
+		report.add(CategoryAccessibility, SeverityWarning, 20, "No book language declared; screen readers cannot select a voice")
+	}
+	if missingAlt > 0 {
+		report.add(CategoryAccessibility, SeverityWarning, capPenalty(missingAlt*40/images, 40),
+			"%d of %d images have no alt attribute", missingAlt, images)
+	}
+	if withoutHeadings > 0 {
+		report.add(CategoryAccessibility, SeverityInfo, capPenalty(2*withoutHeadings, 20),
+			"%d chapters have no headings; navigation by heading will not work", withoutHeadings)
+	}
+
+	if publisherClasses > 0 {
+		report.add(CategoryMarkup, SeverityInfo, capPenalty(publisherClasses/10, 30),
+			"%d elements carry publisher-specific classes (calibre, sgc-, kobo-, adobe-); they will be removed", publisherClasses)
+	}
+	if inlineStyles > 0 {
+		report.add(CategoryMarkup, SeverityWarning, capPenalty(inlineStyles/5, 30),
+			"%d elements use inline style attributes", inlineStyles)
+	}
+	if fontTags > 0 {
+		report.add(CategoryMarkup, SeverityWarning, capPenalty(2*fontTags, 20),
+			"%d deprecated <font> elements found", fontTags)
+	}
+	if emptyElements > 0 {
+		report.add(CategoryMarkup, SeverityInfo, capPenalty(emptyElements/10, 20),
+			"%d empty div/span elements found", emptyElements)
+	}
+}
+
+// capPenalty limits a penalty to max, charging at least one point
+func capPenalty(penalty, max int) int {
+	if penalty < 1 {
+		return 1
+	}
+	if penalty > max {
+		return max
+	}
+	return penalty
+}
+
+// grade converts a score to a letter grade
+func grade(score int) string {
+	switch {
+	case score >= 90:
+		return "A"
+	case score >= 80:
+		return "B"
+	case score >= 70:
+		return "C"
+	case score >= 60:
+		return "D"
+	default:
+		return "F"
+	}
+}
+
+// WriteJSON writes the report as indented JSON
+func (r *Report) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// WriteText writes a human-readable version of the report
+func (r *Report) WriteText(w io.Writer) error {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "🏅 Quality grade: %s (%d/100)\n", r.Grade, r.Score)
+	for _, category := range []string{CategoryStructure, CategoryMetadata, CategoryAccessibility, CategoryMarkup} {
+		fmt.Fprintf(&b, "   %-14s %3d/100\n", category+":", r.Scores[category])
+	}
+
+	if len(r.Findings) == 0 {
+		b.WriteString("✅ No issues found\n")
+	} else {
+		b.WriteString("📋 Findings:\n")
+		for _, finding := range r.Findings {
+			icon := "ℹ️ "
+			switch finding.Severity {
+			case SeverityError:
+				icon = "❌"
+			case SeverityWarning:
+				icon = "⚠️ "
+			}
+			fmt.Fprintf(&b, "   %s [%s] %s\n", icon, finding.Category, finding.Message)
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,251 @@
+package remote
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/flouciel/folian-parser/internal/network"
+)
+
+// DefaultMaxSize is the largest download accepted when no limit is given
+const DefaultMaxSize = 500 << 20
+
+// emptyPayloadHash is the SHA-256 of an empty request body, signed into S3 GETs
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// AllowHTTP allows downloading inputs over plain http, whose content can be
+// altered in transit
+var AllowHTTP bool
+
+// ErrInsecure is returned for plain http downloads, including redirects to one,
+// unless AllowHTTP is set
+var ErrInsecure = errors.New("plain http downloads are refused")
+
+// client is the HTTP client used for downloads
+var client = newClient()
+
+// newClient returns the download client, which refuses redirects to plain http
+// unless AllowHTTP is set
+func newClient() *http.Client {
+	c := network.NewClient(30 * time.Minute)
+	c.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		if req.URL.Scheme == "http" && !AllowHTTP {
+			return fmt.Errorf("%w: redirected to %s", ErrInsecure, req.URL)
+		}
+		return nil
+	}
+	return c
+}
+
+// Download is a remote input fetched to a local file
+type Download struct {
+	URL    string
+	Path   string
+	Size   int64
+	SHA256 string
+}
+
+// IsURL reports whether an input names a remote file: an http(s) or s3 URL; plain
+// http ones are only fetched with AllowHTTP
+func IsURL(input string) bool {
+	lower := strings.ToLower(input)
+	return strings.HasPrefix(lower, "https://") || strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "s3://")
+}
+
+// Filename returns the file name of a remote input, for naming its output
+func Filename(rawURL string) string {
+	name := "download.epub"
+	if u, err := url.Parse(rawURL); err == nil {
+		if base := path.Base(u.Path); base != "." && base != "/" && base != "" {
+			name = base
+		}
+	}
+	return name
+}
+
+// Fetch downloads a remote input into dir, refusing files larger than maxSize bytes
+// and, when expectedSHA256 is set, files with another SHA-256. S3 objects are read
+// with the AWS credentials of the environment and checked against their stored
+// SHA-256 when they have one
+func Fetch(rawURL, dir string, maxSize int64, expectedSHA256 string) (*Download, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+
+	req, err := newRequest(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", rawURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download %s: status code %d", rawURL, resp.StatusCode)
+	}
+	if resp.ContentLength > maxSize {
+		return nil, fmt.Errorf("%s is %d bytes, over the %d byte download limit", rawURL, resp.ContentLength, maxSize)
+	}
+
+	file, err := os.Create(filepath.Join(dir, Filename(rawURL)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create download file: %w", err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(file, hash), io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", rawURL, err)
+	}
+	if size > maxSize {
+		return nil, fmt.Errorf("%s is over the %d byte download limit", rawURL, maxSize)
+	}
+	if resp.ContentLength >= 0 && size != resp.ContentLength {
+		return nil, fmt.Errorf("download of %s is truncated: got %d of %d bytes", rawURL, size, resp.ContentLength)
+	}
+
+	sum := hash.Sum(nil)
+	download := &Download{URL: rawURL, Path: file.Name(), Size: size, SHA256: hex.EncodeToString(sum)}
+	if expectedSHA256 != "" && !strings.EqualFold(download.SHA256, expectedSHA256) {
+		return nil, fmt.Errorf("checksum mismatch for %s: expected %s, got %s", rawURL, strings.ToLower(expectedSHA256), download.SHA256)
+	}
+	// Multipart objects store a checksum of their part checksums, suffixed with the
+	// part count, which cannot be compared
+	if stored := resp.Header.Get("x-amz-checksum-sha256"); stored != "" && !strings.Contains(stored, "-") && stored != base64.StdEncoding.EncodeToString(sum) {
+		return nil, fmt.Errorf("checksum mismatch for %s: the object's stored SHA-256 differs from the download", rawURL)
+	}
+	return download, nil
+}
+
+// newRequest builds the GET request of a remote input, signing S3 requests
+func newRequest(rawURL string) (*http.Request, error) {
+	if strings.HasPrefix(strings.ToLower(rawURL), "http://") && !AllowHTTP {
+		return nil, fmt.Errorf("%w: %s (use https, or -allow-http)", ErrInsecure, rawURL)
+	}
+	if !strings.HasPrefix(strings.ToLower(rawURL), "s3://") {
+		req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+		if err != nil {
+			return nil, fmt.Errorf("invalid input URL %s: %w", rawURL, err)
+		}
+		return req, nil
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" || strings.Trim(u.Path, "/") == "" {
+		return nil, fmt.Errorf("invalid S3 URL %s: expected s3://bucket/key", rawURL)
+	}
+	bucket, key := u.Host, strings.TrimPrefix(u.Path, "/")
+	region := firstEnv("AWS_REGION", "AWS_DEFAULT_REGION")
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	// Custom endpoints (MinIO, R2, ...) use path-style URLs
+	endpoint := fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", bucket, region, escapeKey(key))
+	if custom := firstEnv("AWS_ENDPOINT_URL_S3", "AWS_ENDPOINT_URL"); custom != "" {
+		endpoint = fmt.Sprintf("%s/%s/%s", strings.TrimRight(custom, "/"), bucket, escapeKey(key))
+	}
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 URL %s: %w", rawURL, err)
+	}
+	req.Header.Set("x-amz-checksum-mode", "ENABLED")
+
+	accessKey, secretKey := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if accessKey != "" && secretKey != "" {
+		signS3(req, accessKey, secretKey, os.Getenv("AWS_SESSION_TOKEN"), region, time.Now().UTC())
+	}
+	return req, nil
+}
+
+// firstEnv returns the first of the environment variables that is set
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// escapeKey percent-encodes an S3 object key for its URL path, keeping the slashes
+func escapeKey(key string) string {
+	var out strings.Builder
+	for _, b := range []byte(key) {
+		switch {
+		case b >= 'A' && b <= 'Z', b >= 'a' && b <= 'z', b >= '0' && b <= '9', strings.IndexByte("-._~/", b) >= 0:
+			out.WriteByte(b)
+		default:
+			fmt.Fprintf(&out, "%%%02X", b)
+		}
+	}
+	return out.String()
+}
+
+// signS3 signs an S3 GET request with AWS Signature Version 4
+func signS3(req *http.Request, accessKey, secretKey, sessionToken, region string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", emptyPayloadHash)
+	if sessionToken != "" {
+		req.Header.Set("x-amz-security-token", sessionToken)
+	}
+
+	// Every header of the request is signed, with the host
+	signed := []string{"host"}
+	for name := range req.Header {
+		signed = append(signed, strings.ToLower(name))
+	}
+	sort.Strings(signed)
+	var canonicalHeaders strings.Builder
+	for _, name := range signed {
+		value := req.Header.Get(name)
+		if name == "host" {
+			value = req.URL.Host
+		}
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", name, strings.TrimSpace(value))
+	}
+	signedHeaders := strings.Join(signed, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method, req.URL.EscapedPath(), req.URL.RawQuery, canonicalHeaders.String(), signedHeaders, emptyPayloadHash,
+	}, "\n")
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date, region)
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(requestHash[:])}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", accessKey, scope, signedHeaders, signature))
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data under key
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
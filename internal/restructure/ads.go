@@ -0,0 +1,259 @@
+package restructure
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/flouciel/folian-parser/internal/parser"
+)
+
+// StripAds removes publisher advertisements, newsletter signups and piracy-site
+// watermarks, reporting what was removed
+var StripAds bool
+
+// AdAllowPattern matches text that is never removed as an advertisement
+var AdAllowPattern *regexp.Regexp
+
+// AdDenyPattern matches text that is always removed as an advertisement
+var AdDenyPattern *regexp.Regexp
+
+// adKeywords are phrases of publisher advertisements and signups, weighted by how
+// strongly they indicate one; piracy-site watermarks weigh the most
+var adKeywords = map[string]int{
+	"newsletter":             2,
+	"mailing list":           2,
+	"sign up":                1,
+	"subscribe":              1,
+	"also by":                1,
+	"also available":         1,
+	"coming soon":            1,
+	"visit us at":            1,
+	"follow us on":           1,
+	"exclusive offers":       1,
+	"free ebook":             2,
+	"download more":          2,
+	"downloaded from":        2,
+	"ebook miễn phí":         2,
+	"tải sách":               2,
+	"tải ebook":              2,
+	"đọc sách online":        2,
+	"đăng ký nhận":           1,
+	"sachvui":                2,
+	"thuviensach":            2,
+	"ebookvie":               2,
+	"waka.vn":                2,
+	"this ebook was brought": 2,
+	"scanned and proofed":    2,
+	"for more ebooks":        2,
+	"join our community":     1,
+	"get a free":             1,
+}
+
+// adThreshold is the keyword score that marks a short block or page as an advertisement
+const adThreshold = 2
+
+// maxAdPageLength and maxAdBlockLength are the longest text of a page or block
+// removed on keywords; longer ones are content that mentions them
+const (
+	maxAdPageLength  = 2000
+	maxAdBlockLength = 400
+)
+
+// minRepeatedChapters is the fewest chapters a block must repeat in to count as a
+// stamped watermark, which must also appear in half the chapters
+const minRepeatedChapters = 3
+
+// adBlockSelector selects the blocks that may hold an advertisement
+const adBlockSelector = "body p, body div, body aside, body section, body footer, body header"
+
+// adBlockChildren are the elements that make a block a container of other blocks
+const adBlockChildren = "p, div, aside, section, footer, header, table, ul, ol, blockquote"
+
+// adScore scores text on the advertisement keywords it contains
+func adScore(text string) int {
+	lower := strings.ToLower(text)
+	score := 0
+	for keyword, weight := range adKeywords {
+		if strings.Contains(lower, keyword) {
+			score += weight
+		}
+	}
+	return score
+}
+
+// linkDensity returns the share of a selection's text that is link text
+func linkDensity(s *goquery.Selection) float64 {
+	text := len(strings.TrimSpace(s.Text()))
+	if text == 0 {
+		return 0
+	}
+	links := 0
+	s.Find("a").Each(func(i int, a *goquery.Selection) {
+		links += len(strings.TrimSpace(a.Text()))
+	})
+	return float64(links) / float64(text)
+}
+
+// normalizedText returns the text of a selection in lowercase with collapsed
+// whitespace, to compare blocks across chapters
+func normalizedText(s *goquery.Selection) string {
+	return strings.ToLower(strings.Join(strings.Fields(s.Text()), " "))
+}
+
+// adBlocks returns the leaf blocks of a document: those holding no other blocks
+func adBlocks(doc *goquery.Document) *goquery.Selection {
+	return doc.Find(adBlockSelector).FilterFunction(func(i int, s *goquery.Selection) bool {
+		return s.Find(adBlockChildren).Length() == 0
+	})
+}
+
+// allowed reports whether text matches the allow pattern
+func allowed(text string) bool {
+	return AdAllowPattern != nil && AdAllowPattern.MatchString(text)
+}
+
+// denied reports whether text matches the deny pattern
+func denied(text string) bool {
+	return AdDenyPattern != nil && AdDenyPattern.MatchString(text)
+}
+
+// adPageReason returns why a chapter is an advertisement page, or "": a short page
+// that scores on the keywords and is mostly made of blocks that do
+func adPageReason(chapter parser.Chapter) string {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(chapter.Content))
+	if err != nil {
+		return ""
+	}
+	body := doc.Find("body")
+	text := strings.Join(strings.Fields(body.Text()), " ")
+	if allowed(chapter.Title) || allowed(text) {
+		return ""
+	}
+	if denied(chapter.Title) {
+		return "title matches the deny pattern"
+	}
+	if text == "" || len(text) > maxAdPageLength {
+		return ""
+	}
+
+	adText := 0
+	adBlocks(doc).AddSelection(doc.Find("body h1, body h2, body h3, body h4, body h5, body h6")).Each(func(i int, s *goquery.Selection) {
+		if blockText := normalizedText(s); adScore(blockText) > 0 {
+			adText += len(blockText)
+		}
+	})
+	if float64(adText)/float64(len(text)) < 0.5 {
+		return ""
+	}
+
+	switch score := adScore(chapter.Title + " " + text); {
+	case score >= adThreshold:
+		return "advertisement keywords"
+	case score > 0 && linkDensity(body) > 0.3:
+		return "advertisement keywords and links"
+	}
+	return ""
+}
+
+// removeAdChapters drops the chapters that are advertisement pages
+func (r *Restructurer) removeAdChapters(book *parser.Book) {
+	r.adChapterIDs = nil
+	r.adBlocksRemoved = 0
+	if !StripAds {
+		return
+	}
+
+	var kept []parser.Chapter
+	for _, chapter := range book.Chapters {
+		if reason := adPageReason(chapter); reason != "" {
+			fmt.Printf("🧹 Removed advertisement page %q (%s)\n", chapter.Title, reason)
+			r.adChapterIDs = append(r.adChapterIDs, chapter.ID)
+			continue
+		}
+		kept = append(kept, chapter)
+	}
+	book.Chapters = kept
+}
+
+// collectRepeatedBlocks finds the short blocks repeated across the book's chapters,
+// such as a watermark stamped at the end of every chapter
+func (r *Restructurer) collectRepeatedBlocks(book *parser.Book) {
+	r.repeatedBlocks = make(map[string]int)
+	if !StripAds {
+		return
+	}
+
+	counts := make(map[string]int)
+	for _, chapter := range book.Chapters {
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(chapter.Content))
+		if err != nil {
+			continue
+		}
+		seen := make(map[string]bool)
+		adBlocks(doc).Each(func(i int, s *goquery.Selection) {
+			text := normalizedText(s)
+			// Scene breaks and other short ornaments repeat legitimately
+			if len(text) < 15 || len(text) > maxAdBlockLength || seen[text] {
+				return
+			}
+			seen[text] = true
+			counts[text]++
+		})
+	}
+
+	for text, count := range counts {
+		if count >= minRepeatedChapters && count*2 >= len(book.Chapters) {
+			r.repeatedBlocks[text] = count
+		}
+	}
+}
+
+// adBlockReason returns why a block is an advertisement, or ""
+func (r *Restructurer) adBlockReason(s *goquery.Selection) string {
+	text := normalizedText(s)
+	if text == "" || allowed(text) {
+		return ""
+	}
+	if denied(text) {
+		return "matches the deny pattern"
+	}
+	if count := r.repeatedBlocks[text]; count > 0 {
+		return fmt.Sprintf("repeated in %d chapters", count)
+	}
+	if len(text) <= maxAdBlockLength {
+		score := adScore(text)
+		if score >= adThreshold || (score > 0 && linkDensity(s) > 0.5) {
+			return "advertisement keywords"
+		}
+	}
+	return ""
+}
+
+// stripAdBlocks removes the advertisement blocks of a chapter, reporting each
+func (r *Restructurer) stripAdBlocks(doc *goquery.Document, title string) {
+	if !StripAds {
+		return
+	}
+	adBlocks(doc).Each(func(i int, s *goquery.Selection) {
+		reason := r.adBlockReason(s)
+		if reason == "" {
+			return
+		}
+		excerpt := strings.Join(strings.Fields(s.Text()), " ")
+		if len([]rune(excerpt)) > 60 {
+			excerpt = string([]rune(excerpt)[:57]) + "..."
+		}
+		fmt.Printf("🧹 Removed advertisement block in %q (%s): %s\n", title, reason, excerpt)
+		s.Remove()
+		r.adBlocksRemoved++
+	})
+}
+
+// reportAds prints the totals of the advertisement removal
+func (r *Restructurer) reportAds() {
+	if StripAds {
+		fmt.Printf("ℹ️  Removed %d advertisement pages and %d advertisement blocks\n", len(r.adChapterIDs), r.adBlocksRemoved)
+	}
+}
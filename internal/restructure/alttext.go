@@ -0,0 +1,159 @@
+package restructure
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"github.com/flouciel/folian-parser/internal/policy"
+)
+
+// AltTextFile is a JSON file mapping image paths or file names to the alt text of
+// the images that have none
+var AltTextFile string
+
+// AltTextCommand is a shell command proposing the alt text of an image that has
+// none, such as an offline captioning model; it runs with the image in
+// FOLIAN_IMAGE and prints the alt text
+var AltTextCommand string
+
+// AltTextReport is the JSON file listing the images without alt text and the alt
+// text they were given
+var AltTextReport string
+
+// srcPattern finds the source of an image tag
+var srcPattern = regexp.MustCompile(`\ssrc\s*=\s*"([^"]*)"`)
+
+// AltTextEntry is an image without alt text in the alt text report
+type AltTextEntry struct {
+	Chapter string `json:"chapter"`
+	Image   string `json:"image"`
+	Alt     string `json:"alt,omitempty"`
+	// Source is where the alt text came from: "file", "command", or "" when the
+	// image still has none
+	Source string `json:"source,omitempty"`
+}
+
+// loadAltTexts reads the alt texts of AltTextFile and prepares the alt text report
+func (r *Restructurer) loadAltTexts() error {
+	r.altTexts = nil
+	r.proposedAltTexts = make(map[string]string)
+	r.altTextEntries = nil
+	if AltTextFile == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(AltTextFile)
+	if err != nil {
+		return fmt.Errorf("failed to read alt text file: %w", err)
+	}
+	if err := json.Unmarshal(data, &r.altTexts); err != nil {
+		return fmt.Errorf("failed to parse alt text file %s: %w", AltTextFile, err)
+	}
+	return nil
+}
+
+// assistAltText gives the images of a written chapter that have no alt text the alt
+// text of AltTextFile or proposed by AltTextCommand, recording them for the report
+func (r *Restructurer) assistAltText(content, target, title, oebpsPath string) string {
+	if AltTextFile == "" && AltTextCommand == "" && AltTextReport == "" {
+		return content
+	}
+	return imagePattern.ReplaceAllStringFunc(content, func(image string) string {
+		match := srcPattern.FindStringSubmatch(image)
+		if altPattern.MatchString(image) || match == nil {
+			return image
+		}
+		src := html.UnescapeString(match[1])
+		file := path.Join(path.Dir(target), src)
+
+		entry := AltTextEntry{Chapter: target, Image: file}
+		if alt, ok := r.lookupAltText(src, file); ok {
+			entry.Alt, entry.Source = alt, "file"
+		} else if AltTextCommand != "" {
+			if alt := r.proposeAltText(filepath.Join(oebpsPath, filepath.FromSlash(file)), title); alt != "" {
+				entry.Alt, entry.Source = alt, "command"
+			}
+		}
+		r.altTextEntries = append(r.altTextEntries, entry)
+		if entry.Source == "" {
+			return image
+		}
+		if DebugMode {
+			fmt.Printf("🏷️  Alt text for %s (%s): %s\n", file, entry.Source, entry.Alt)
+		}
+		return strings.Replace(image, "<img", fmt.Sprintf(`<img alt="%s"`, html.EscapeString(entry.Alt)), 1)
+	})
+}
+
+// lookupAltText finds the alt text of an image in AltTextFile by its source, its
+// path in the output or its file name
+func (r *Restructurer) lookupAltText(src, file string) (string, bool) {
+	for _, key := range []string{src, file, path.Base(file), decodeHref(path.Base(file))} {
+		if alt, ok := r.altTexts[key]; ok {
+			return alt, true
+		}
+	}
+	return "", false
+}
+
+// proposeAltText runs AltTextCommand on an image, once per image, returning the
+// first line it prints
+func (r *Restructurer) proposeAltText(image, title string) string {
+	if alt, ok := r.proposedAltTexts[image]; ok {
+		return alt
+	}
+	shell, flag := "sh", "-c"
+	if runtime.GOOS == "windows" {
+		shell, flag = "cmd", "/C"
+	}
+	cmd := exec.Command(shell, flag, AltTextCommand)
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), "FOLIAN_IMAGE="+image, "FOLIAN_CHAPTER="+title)
+	output, err := cmd.Output()
+	alt := ""
+	if err != nil {
+		policy.Warn(policy.KindAltText, "Alt text command failed for %s: %v", filepath.Base(image), err)
+	} else {
+		alt = strings.TrimSpace(strings.SplitN(strings.TrimSpace(string(output)), "\n", 2)[0])
+	}
+	r.proposedAltTexts[image] = alt
+	return alt
+}
+
+// writeAltTextReport writes the images without alt text to AltTextReport
+func (r *Restructurer) writeAltTextReport() error {
+	missing := 0
+	for _, entry := range r.altTextEntries {
+		if entry.Source == "" {
+			missing++
+		}
+	}
+	if len(r.altTextEntries) > 0 && (AltTextFile != "" || AltTextCommand != "") {
+		fmt.Printf("ℹ️  Gave %d of %d images without alt text one\n", len(r.altTextEntries)-missing, len(r.altTextEntries))
+	}
+	if AltTextReport == "" {
+		return nil
+	}
+
+	entries := r.altTextEntries
+	if entries == nil {
+		entries = []AltTextEntry{}
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode alt text report: %w", err)
+	}
+	if err := ioutil.WriteFile(AltTextReport, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write alt text report: %w", err)
+	}
+	fmt.Printf("ℹ️  Listed %d images without alt text in %s\n", len(entries), AltTextReport)
+	return nil
+}
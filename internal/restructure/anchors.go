@@ -0,0 +1,148 @@
+package restructure
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/flouciel/folian-parser/internal/parser"
+)
+
+// IDPolicy decides which IDs chapter cleaning keeps, one of IDPolicies: "referenced"
+// keeps every ID the book links to, "slugs" also gives headings stable IDs made from
+// their text, and "strip" only keeps the IDs indexes, citations and glossaries link to
+var IDPolicy = "referenced"
+
+// IDPolicies are the ID policies selectable with IDPolicy
+var IDPolicies = []string{"referenced", "slugs", "strip"}
+
+// maxSlugLength is the length in characters the heading slugs are cut to
+const maxSlugLength = 48
+
+// collectLinkedAnchors keeps the anchors the chapters and the table of contents link
+// to, so that deep links into the book survive the ID cleanup
+func (r *Restructurer) collectLinkedAnchors(book *parser.Book) {
+	if IDPolicy == "strip" {
+		return
+	}
+	keep := func(href string) {
+		if i := strings.Index(href, "#"); i >= 0 && i < len(href)-1 && !strings.Contains(href, "://") {
+			r.keptAnchors[href[i+1:]] = true
+		}
+	}
+	for _, chapter := range book.Chapters {
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(chapter.Content))
+		if err != nil {
+			continue
+		}
+		doc.Find("a[href]").Each(func(i int, s *goquery.Selection) {
+			keep(s.AttrOr("href", ""))
+		})
+	}
+	var walk func(entries []parser.TOCEntry)
+	walk = func(entries []parser.TOCEntry) {
+		for _, entry := range entries {
+			keep(entry.Href)
+			walk(entry.Children)
+		}
+	}
+	walk(book.TOC)
+
+	if DebugMode {
+		fmt.Printf("🔗 Keeping %d linked anchors\n", len(r.keptAnchors))
+	}
+}
+
+// assignHeadingSlugs gives the headings of the chapters to write stable IDs made
+// from their text, recording the IDs they replace to rewrite the links to them
+func (r *Restructurer) assignHeadingSlugs(chapters []parser.Chapter) {
+	r.anchorRenames = make(map[string]map[string]string)
+	if IDPolicy != "slugs" {
+		return
+	}
+	headings := 0
+	for i, chapter := range chapters {
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(chapter.Content))
+		if err != nil {
+			continue
+		}
+		used := make(map[string]bool)
+		doc.Find("[id]").Each(func(i int, s *goquery.Selection) {
+			used[s.AttrOr("id", "")] = true
+		})
+
+		renames := make(map[string]string)
+		doc.Find("h1, h2, h3, h4, h5, h6").Each(func(i int, s *goquery.Selection) {
+			base := headingSlug(s.Text())
+			id := base
+			for n := 2; used[id]; n++ {
+				id = fmt.Sprintf("%s-%d", base, n)
+			}
+			used[id] = true
+			if old, hasID := s.Attr("id"); hasID {
+				renames[old] = id
+			}
+			s.SetAttr("id", id)
+			r.keptAnchors[id] = true
+			headings++
+		})
+		if len(renames) == 0 {
+			continue
+		}
+
+		// Links within the chapter are rewritten here, the others with the chapter
+		// mapping
+		doc.Find("a[href^='#']").Each(func(i int, s *goquery.Selection) {
+			if id, renamed := renames[strings.TrimPrefix(s.AttrOr("href", ""), "#")]; renamed {
+				s.SetAttr("href", "#"+id)
+			}
+		})
+		if content, err := doc.Html(); err == nil {
+			chapters[i].Content = content
+			r.anchorRenames[fmt.Sprintf("chapter_%03d.xhtml", i+1)] = renames
+		}
+	}
+
+	if DebugMode {
+		fmt.Printf("🔗 Gave %d headings stable IDs\n", headings)
+	}
+}
+
+// renamedAnchor returns the ID that replaces an anchor of an output chapter, or the
+// anchor itself
+func (r *Restructurer) renamedAnchor(file, id string) string {
+	if renamed, ok := r.anchorRenames[file][id]; ok {
+		return renamed
+	}
+	return id
+}
+
+// headingSlug makes an ID from the text of a heading: its lowercased letters and
+// digits, with dashes between the words
+func headingSlug(text string) string {
+	var b strings.Builder
+	dash := false
+	length := 0
+	for _, c := range strings.ToLower(text) {
+		if !unicode.IsLetter(c) && !unicode.IsDigit(c) {
+			dash = true
+			continue
+		}
+		if length >= maxSlugLength {
+			break
+		}
+		if dash && b.Len() > 0 {
+			b.WriteByte('-')
+			length++
+		}
+		dash = false
+		b.WriteRune(c)
+		length++
+	}
+	slug := b.String()
+	if first := []rune(slug + " ")[0]; !unicode.IsLetter(first) {
+		slug = strings.TrimSuffix("section-"+slug, "-")
+	}
+	return slug
+}
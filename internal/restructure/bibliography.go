@@ -0,0 +1,101 @@
+package restructure
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/flouciel/folian-parser/internal/parser"
+)
+
+// bibliographyTitlePattern matches the titles of bibliographies and reference lists
+var bibliographyTitlePattern = regexp.MustCompile(`(?i)^\s*(select\s+|selected\s+)?(bibliography|references|works\s+cited|literature\s+cited|sources|further\s+reading|bibliographie|literaturverzeichnis|bibliografía|bibliografia|referências|literatuurlijst|tài\s+liệu\s+tham\s+khảo|список\s+литературы|参考文献|참고\s*문헌)\s*$`)
+
+// bibliographyTypePattern matches the EPUB3 and ARIA semantics of a bibliography
+var bibliographyTypePattern = regexp.MustCompile(`(?:epub:type="[^"]*\bbibliography\b[^"]*"|role="doc-bibliography")`)
+
+// isBibliographyChapter reports whether a chapter is a bibliography, whose entries
+// are citation targets rather than navigation
+func isBibliographyChapter(title, content string) bool {
+	return bibliographyTitlePattern.MatchString(title) || bibliographyTypePattern.MatchString(content)
+}
+
+// collectCitationTargets records the bibliography entries the book's citations link
+// to, so that the cleanup keeps their anchors even when they look like
+// publisher-generated IDs
+func (r *Restructurer) collectCitationTargets(book *parser.Book) {
+	if entries := r.keepLinkedAnchors(book, isBibliographyChapter); DebugMode && len(entries) > 0 {
+		fmt.Printf("📚 Keeping %d cited bibliography entries\n", len(entries))
+	}
+}
+
+// keepLinkedAnchors adds the anchors of the chapters matched by isTarget that the
+// book links to to the anchors the cleanup keeps, and returns them
+func (r *Restructurer) keepLinkedAnchors(book *parser.Book, isTarget func(title, content string) bool) map[string]bool {
+	anchors := make(map[string]bool)
+	for _, chapter := range book.Chapters {
+		if !isTarget(chapter.Title, chapter.Content) {
+			continue
+		}
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(chapter.Content))
+		if err != nil {
+			continue
+		}
+		doc.Find("body [id]").Each(func(i int, s *goquery.Selection) {
+			id, _ := s.Attr("id")
+			anchors[id] = true
+		})
+	}
+	linked := make(map[string]bool)
+	if len(anchors) == 0 {
+		return linked
+	}
+
+	for _, chapter := range book.Chapters {
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(chapter.Content))
+		if err != nil {
+			continue
+		}
+		doc.Find("a[href*='#']").Each(func(i int, s *goquery.Selection) {
+			href, _ := s.Attr("href")
+			id := href[strings.Index(href, "#")+1:]
+			if anchors[id] && !strings.Contains(href, "://") {
+				r.keptAnchors[id] = true
+				linked[id] = true
+			}
+		})
+	}
+	return linked
+}
+
+// markBibliography removes the bibliography's own title heading, which the chapter
+// heading replaces, wraps the entries in a bibliography section and marks the list
+// items or paragraphs that are its entries as biblioentry
+func markBibliography(doc *goquery.Document, title string) {
+	doc.Find("h1, h2, h3, h4, h5, h6").Each(func(i int, s *goquery.Selection) {
+		if bibliographyTitlePattern.MatchString(s.Text()) {
+			s.Remove()
+		}
+	})
+	removeTitleHeading(doc, title)
+
+	if doc.Find(`[role="doc-bibliography"]`).Length() == 0 {
+		doc.Find("body").WrapInnerHtml(`<section epub:type="bibliography" role="doc-bibliography"></section>`)
+	}
+
+	section := doc.Find(`[role="doc-bibliography"]`)
+	if section.Find(`[epub\:type~="biblioentry"]`).Length() > 0 {
+		return
+	}
+	entries := section.Find("li")
+	if entries.Length() == 0 {
+		entries = section.Find("p")
+	}
+	entries.Each(func(i int, s *goquery.Selection) {
+		if strings.TrimSpace(s.Text()) == "" {
+			return
+		}
+		addEpubType(s, "biblioentry")
+	})
+}
@@ -0,0 +1,168 @@
+package restructure
+
+import (
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ChapterCacheDir holds the cleaned chapters of previous runs, reused for chapters
+// whose source and options did not change; empty disables the chapter cache
+var ChapterCacheDir string
+
+// ChapterCacheOptions identifies the options of the run, which are part of the key
+// of every cached chapter
+var ChapterCacheOptions string
+
+// chapterCacheFormat versions the cached chapters; bump it when chapter cleaning
+// changes
+const chapterCacheFormat = "c7"
+
+// chapterCacheMaxAge is how long an unused cached chapter is kept
+const chapterCacheMaxAge = 30 * 24 * time.Hour
+
+// chapterCachePruned records that the chapter cache was pruned in this run
+var chapterCachePruned bool
+
+// cachedChapter is a cleaned chapter with the findings of cleaning it
+type cachedChapter struct {
+	Content         string
+	UnresolvedLinks []string
+}
+
+// chapterCacheContext returns the part of the chapter cache keys shared by the
+// chapters of a book: the options and the book-wide state cleaning depends on, or
+// "" when chapters cannot be cached because cleaning one depends on the others
+func (r *Restructurer) chapterCacheContext() string {
+	if ChapterCacheDir == "" || StripAds || PopupFootnotes || r.synthesizePages {
+		return ""
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n%s\n", chapterCacheFormat, ChapterCacheOptions)
+	for _, table := range []map[string]string{r.chapterMapping, r.droppedFiles, r.imageRenames} {
+		writeSortedMap(&b, table)
+	}
+	files := make([]string, 0, len(r.anchorRenames))
+	for file := range r.anchorRenames {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		fmt.Fprintf(&b, "%s\n", file)
+		writeSortedMap(&b, r.anchorRenames[file])
+	}
+	var targets []string
+	for id := range r.keptAnchors {
+		targets = append(targets, id)
+	}
+	sort.Strings(targets)
+	var terms []string
+	for id := range r.glossaryTerms {
+		terms = append(terms, id)
+	}
+	sort.Strings(terms)
+	fmt.Fprintf(&b, "%q\n%q\n%v\n", targets, terms, r.lexicons)
+
+	sum := sha256.Sum256([]byte(b.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+// writeSortedMap writes a map in key order
+func writeSortedMap(b *strings.Builder, table map[string]string) {
+	keys := make([]string, 0, len(table))
+	for key := range table {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(b, "%q=%q\n", key, table[key])
+	}
+	b.WriteString("\n")
+}
+
+// chapterCacheKey returns the cache key of a chapter, or "" when it is not cached
+func chapterCacheKey(context, title, content string) string {
+	if context == "" {
+		return ""
+	}
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\n%q\n", context, title)
+	hash.Write([]byte(content))
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// loadCachedChapter returns the cached chapter of a key
+func loadCachedChapter(key string) (*cachedChapter, bool) {
+	if key == "" {
+		return nil, false
+	}
+	path := filepath.Join(ChapterCacheDir, key+".gob")
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, false
+	}
+	defer file.Close()
+
+	var chapter cachedChapter
+	if err := gob.NewDecoder(file).Decode(&chapter); err != nil {
+		return nil, false
+	}
+
+	// Mark the chapter as used so that pruning keeps it
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return &chapter, true
+}
+
+// storeCachedChapter saves a cleaned chapter under its key, replacing the file
+// atomically, and prunes chapters unused for chapterCacheMaxAge once per run
+func storeCachedChapter(key string, chapter cachedChapter) error {
+	if key == "" {
+		return nil
+	}
+	if err := os.MkdirAll(ChapterCacheDir, 0755); err != nil {
+		return fmt.Errorf("failed to create chapter cache directory: %w", err)
+	}
+	if !chapterCachePruned {
+		pruneChapterCache()
+		chapterCachePruned = true
+	}
+
+	file, err := os.CreateTemp(ChapterCacheDir, "staging-*")
+	if err != nil {
+		return fmt.Errorf("failed to create cached chapter: %w", err)
+	}
+	defer os.Remove(file.Name())
+	err = gob.NewEncoder(file).Encode(&chapter)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to encode cached chapter: %w", err)
+	}
+	if err := os.Rename(file.Name(), filepath.Join(ChapterCacheDir, key+".gob")); err != nil {
+		return fmt.Errorf("failed to store cached chapter: %w", err)
+	}
+	return nil
+}
+
+// pruneChapterCache removes the cached chapters unused for chapterCacheMaxAge
+func pruneChapterCache() {
+	entries, err := os.ReadDir(ChapterCacheDir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err == nil && time.Since(info.ModTime()) > chapterCacheMaxAge {
+			os.Remove(filepath.Join(ChapterCacheDir, entry.Name()))
+		}
+	}
+}
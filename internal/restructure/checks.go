@@ -0,0 +1,59 @@
+package restructure
+
+import (
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/flouciel/folian-parser/internal/policy"
+)
+
+// generatedPages are the pages written by the restructurer itself, which links to
+// them keep pointing at
+var generatedPages = map[string]bool{"nav.xhtml": true, "titlepage.xhtml": true, "jacket.xhtml": true}
+
+// imagePattern and altPattern find the images of a chapter and their alt attribute
+var (
+	imagePattern = regexp.MustCompile(`<img\b[^>]*>`)
+	altPattern   = regexp.MustCompile(`\salt\s*=`)
+)
+
+// maxListedLinks is the number of unresolved links named in a warning
+const maxListedLinks = 3
+
+// noteUnresolvedLink records a link of the current chapter to a document that is
+// not part of the output
+func (r *Restructurer) noteUnresolvedLink(href string) {
+	file := strings.SplitN(href, "#", 2)[0]
+	if file == "" || strings.Contains(href, "://") || strings.HasPrefix(href, "mailto:") || generatedPages[filepath.Base(decodeHref(file))] {
+		return
+	}
+	switch strings.ToLower(path.Ext(file)) {
+	case ".xhtml", ".html", ".htm":
+		r.unresolvedLinks = append(r.unresolvedLinks, href)
+	}
+}
+
+// reportChapterWarnings warns about the images of a written chapter that have no
+// alt text and the links that point at no output file
+func (r *Restructurer) reportChapterWarnings(target, title, content string) {
+	missingAlt := 0
+	for _, image := range imagePattern.FindAllString(content, -1) {
+		if !altPattern.MatchString(image) {
+			missingAlt++
+		}
+	}
+	if missingAlt > 0 {
+		policy.Warn(policy.KindAltText, "%d images in %s (%s) have no alt text", missingAlt, target, title)
+	}
+
+	if len(r.unresolvedLinks) > 0 {
+		listed := r.unresolvedLinks
+		if len(listed) > maxListedLinks {
+			listed = listed[:maxListedLinks]
+		}
+		policy.Warn(policy.KindLink, "%d links in %s (%s) point at no output file: %s", len(r.unresolvedLinks), target, title, strings.Join(listed, ", "))
+	}
+	r.unresolvedLinks = nil
+}
@@ -0,0 +1,108 @@
+package restructure
+
+// compositionTable lists the canonical compositions of the Latin, Greek and Cyrillic
+// letters as triples of a letter, a combining mark and the letter they compose,
+// taken from the Unicode character database
+const compositionTable = "" +
+	"A\u0300\u00c0E\u0300\u00c8I\u0300\u00ccN\u0300\u01f8O\u0300\u00d2U\u0300\u00d9" +
+	"W\u0300\u1e80Y\u0300\u1ef2a\u0300\u00e0e\u0300\u00e8i\u0300\u00ecn\u0300\u01f9" +
+	"o\u0300\u00f2u\u0300\u00f9w\u0300\u1e81y\u0300\u1ef3\u00c2\u0300\u1ea6\u00ca\u0300\u1ec0" +
+	"\u00d4\u0300\u1ed2\u00dc\u0300\u01db\u00e2\u0300\u1ea7\u00ea\u0300\u1ec1\u00f4\u0300\u1ed3\u00fc\u0300\u01dc" +
+	"\u0102\u0300\u1eb0\u0103\u0300\u1eb1\u0112\u0300\u1e14\u0113\u0300\u1e15\u014c\u0300\u1e50\u014d\u0300\u1e51" +
+	"\u01a0\u0300\u1edc\u01a1\u0300\u1edd\u01af\u0300\u1eea\u01b0\u0300\u1eeb\u0415\u0300\u0400\u0418\u0300\u040d" +
+	"\u0435\u0300\u0450\u0438\u0300\u045dA\u0301\u00c1C\u0301\u0106E\u0301\u00c9G\u0301\u01f4" +
+	"I\u0301\u00cdK\u0301\u1e30L\u0301\u0139M\u0301\u1e3eN\u0301\u0143O\u0301\u00d3" +
+	"P\u0301\u1e54R\u0301\u0154S\u0301\u015aU\u0301\u00daW\u0301\u1e82Y\u0301\u00dd" +
+	"Z\u0301\u0179a\u0301\u00e1c\u0301\u0107e\u0301\u00e9g\u0301\u01f5i\u0301\u00ed" +
+	"k\u0301\u1e31l\u0301\u013am\u0301\u1e3fn\u0301\u0144o\u0301\u00f3p\u0301\u1e55" +
+	"r\u0301\u0155s\u0301\u015bu\u0301\u00faw\u0301\u1e83y\u0301\u00fdz\u0301\u017a" +
+	"\u00c2\u0301\u1ea4\u00c5\u0301\u01fa\u00c6\u0301\u01fc\u00c7\u0301\u1e08\u00ca\u0301\u1ebe\u00cf\u0301\u1e2e" +
+	"\u00d4\u0301\u1ed0\u00d5\u0301\u1e4c\u00d8\u0301\u01fe\u00dc\u0301\u01d7\u00e2\u0301\u1ea5\u00e5\u0301\u01fb" +
+	"\u00e6\u0301\u01fd\u00e7\u0301\u1e09\u00ea\u0301\u1ebf\u00ef\u0301\u1e2f\u00f4\u0301\u1ed1\u00f5\u0301\u1e4d" +
+	"\u00f8\u0301\u01ff\u00fc\u0301\u01d8\u0102\u0301\u1eae\u0103\u0301\u1eaf\u0112\u0301\u1e16\u0113\u0301\u1e17" +
+	"\u014c\u0301\u1e52\u014d\u0301\u1e53\u0168\u0301\u1e78\u0169\u0301\u1e79\u01a0\u0301\u1eda\u01a1\u0301\u1edb" +
+	"\u01af\u0301\u1ee8\u01b0\u0301\u1ee9\u0391\u0301\u0386\u0395\u0301\u0388\u0397\u0301\u0389\u0399\u0301\u038a" +
+	"\u039f\u0301\u038c\u03a5\u0301\u038e\u03a9\u0301\u038f\u03b1\u0301\u03ac\u03b5\u0301\u03ad\u03b7\u0301\u03ae" +
+	"\u03b9\u0301\u03af\u03bf\u0301\u03cc\u03c5\u0301\u03cd\u03c9\u0301\u03ce\u03ca\u0301\u0390\u03cb\u0301\u03b0" +
+	"\u03d2\u0301\u03d3\u0413\u0301\u0403\u041a\u0301\u040c\u0433\u0301\u0453\u043a\u0301\u045cA\u0302\u00c2" +
+	"C\u0302\u0108E\u0302\u00caG\u0302\u011cH\u0302\u0124I\u0302\u00ceJ\u0302\u0134" +
+	"O\u0302\u00d4S\u0302\u015cU\u0302\u00dbW\u0302\u0174Y\u0302\u0176Z\u0302\u1e90" +
+	"a\u0302\u00e2c\u0302\u0109e\u0302\u00eag\u0302\u011dh\u0302\u0125i\u0302\u00ee" +
+	"j\u0302\u0135o\u0302\u00f4s\u0302\u015du\u0302\u00fbw\u0302\u0175y\u0302\u0177" +
+	"z\u0302\u1e91\u1ea0\u0302\u1eac\u1ea1\u0302\u1ead\u1eb8\u0302\u1ec6\u1eb9\u0302\u1ec7\u1ecc\u0302\u1ed8" +
+	"\u1ecd\u0302\u1ed9A\u0303\u00c3E\u0303\u1ebcI\u0303\u0128N\u0303\u00d1O\u0303\u00d5" +
+	"U\u0303\u0168V\u0303\u1e7cY\u0303\u1ef8a\u0303\u00e3e\u0303\u1ebdi\u0303\u0129" +
+	"n\u0303\u00f1o\u0303\u00f5u\u0303\u0169v\u0303\u1e7dy\u0303\u1ef9\u00c2\u0303\u1eaa" +
+	"\u00ca\u0303\u1ec4\u00d4\u0303\u1ed6\u00e2\u0303\u1eab\u00ea\u0303\u1ec5\u00f4\u0303\u1ed7\u0102\u0303\u1eb4" +
+	"\u0103\u0303\u1eb5\u01a0\u0303\u1ee0\u01a1\u0303\u1ee1\u01af\u0303\u1eee\u01b0\u0303\u1eefA\u0304\u0100" +
+	"E\u0304\u0112G\u0304\u1e20I\u0304\u012aO\u0304\u014cU\u0304\u016aY\u0304\u0232" +
+	"a\u0304\u0101e\u0304\u0113g\u0304\u1e21i\u0304\u012bo\u0304\u014du\u0304\u016b" +
+	"y\u0304\u0233\u00c4\u0304\u01de\u00c6\u0304\u01e2\u00d5\u0304\u022c\u00d6\u0304\u022a\u00dc\u0304\u01d5" +
+	"\u00e4\u0304\u01df\u00e6\u0304\u01e3\u00f5\u0304\u022d\u00f6\u0304\u022b\u00fc\u0304\u01d6\u01ea\u0304\u01ec" +
+	"\u01eb\u0304\u01ed\u0226\u0304\u01e0\u0227\u0304\u01e1\u022e\u0304\u0230\u022f\u0304\u0231\u0418\u0304\u04e2" +
+	"\u0423\u0304\u04ee\u0438\u0304\u04e3\u0443\u0304\u04ef\u1e36\u0304\u1e38\u1e37\u0304\u1e39\u1e5a\u0304\u1e5c" +
+	"\u1e5b\u0304\u1e5dA\u0306\u0102E\u0306\u0114G\u0306\u011eI\u0306\u012cO\u0306\u014e" +
+	"U\u0306\u016ca\u0306\u0103e\u0306\u0115g\u0306\u011fi\u0306\u012do\u0306\u014f" +
+	"u\u0306\u016d\u0228\u0306\u1e1c\u0229\u0306\u1e1d\u0410\u0306\u04d0\u0415\u0306\u04d6\u0416\u0306\u04c1" +
+	"\u0418\u0306\u0419\u0423\u0306\u040e\u0430\u0306\u04d1\u0435\u0306\u04d7\u0436\u0306\u04c2\u0438\u0306\u0439" +
+	"\u0443\u0306\u045e\u1ea0\u0306\u1eb6\u1ea1\u0306\u1eb7A\u0307\u0226B\u0307\u1e02C\u0307\u010a" +
+	"D\u0307\u1e0aE\u0307\u0116F\u0307\u1e1eG\u0307\u0120H\u0307\u1e22I\u0307\u0130" +
+	"M\u0307\u1e40N\u0307\u1e44O\u0307\u022eP\u0307\u1e56R\u0307\u1e58S\u0307\u1e60" +
+	"T\u0307\u1e6aW\u0307\u1e86X\u0307\u1e8aY\u0307\u1e8eZ\u0307\u017ba\u0307\u0227" +
+	"b\u0307\u1e03c\u0307\u010bd\u0307\u1e0be\u0307\u0117f\u0307\u1e1fg\u0307\u0121" +
+	"h\u0307\u1e23m\u0307\u1e41n\u0307\u1e45o\u0307\u022fp\u0307\u1e57r\u0307\u1e59" +
+	"s\u0307\u1e61t\u0307\u1e6bw\u0307\u1e87x\u0307\u1e8by\u0307\u1e8fz\u0307\u017c" +
+	"\u015a\u0307\u1e64\u015b\u0307\u1e65\u0160\u0307\u1e66\u0161\u0307\u1e67\u017f\u0307\u1e9b\u1e62\u0307\u1e68" +
+	"\u1e63\u0307\u1e69A\u0308\u00c4E\u0308\u00cbH\u0308\u1e26I\u0308\u00cfO\u0308\u00d6" +
+	"U\u0308\u00dcW\u0308\u1e84X\u0308\u1e8cY\u0308\u0178a\u0308\u00e4e\u0308\u00eb" +
+	"h\u0308\u1e27i\u0308\u00efo\u0308\u00f6t\u0308\u1e97u\u0308\u00fcw\u0308\u1e85" +
+	"x\u0308\u1e8dy\u0308\u00ff\u00d5\u0308\u1e4e\u00f5\u0308\u1e4f\u016a\u0308\u1e7a\u016b\u0308\u1e7b" +
+	"\u0399\u0308\u03aa\u03a5\u0308\u03ab\u03b9\u0308\u03ca\u03c5\u0308\u03cb\u03d2\u0308\u03d4\u0406\u0308\u0407" +
+	"\u0410\u0308\u04d2\u0415\u0308\u0401\u0416\u0308\u04dc\u0417\u0308\u04de\u0418\u0308\u04e4\u041e\u0308\u04e6" +
+	"\u0423\u0308\u04f0\u0427\u0308\u04f4\u042b\u0308\u04f8\u042d\u0308\u04ec\u0430\u0308\u04d3\u0435\u0308\u0451" +
+	"\u0436\u0308\u04dd\u0437\u0308\u04df\u0438\u0308\u04e5\u043e\u0308\u04e7\u0443\u0308\u04f1\u0447\u0308\u04f5" +
+	"\u044b\u0308\u04f9\u044d\u0308\u04ed\u0456\u0308\u0457\u04d8\u0308\u04da\u04d9\u0308\u04db\u04e8\u0308\u04ea" +
+	"\u04e9\u0308\u04ebA\u0309\u1ea2E\u0309\u1ebaI\u0309\u1ec8O\u0309\u1eceU\u0309\u1ee6" +
+	"Y\u0309\u1ef6a\u0309\u1ea3e\u0309\u1ebbi\u0309\u1ec9o\u0309\u1ecfu\u0309\u1ee7" +
+	"y\u0309\u1ef7\u00c2\u0309\u1ea8\u00ca\u0309\u1ec2\u00d4\u0309\u1ed4\u00e2\u0309\u1ea9\u00ea\u0309\u1ec3" +
+	"\u00f4\u0309\u1ed5\u0102\u0309\u1eb2\u0103\u0309\u1eb3\u01a0\u0309\u1ede\u01a1\u0309\u1edf\u01af\u0309\u1eec" +
+	"\u01b0\u0309\u1eedA\u030a\u00c5U\u030a\u016ea\u030a\u00e5u\u030a\u016fw\u030a\u1e98" +
+	"y\u030a\u1e99O\u030b\u0150U\u030b\u0170o\u030b\u0151u\u030b\u0171\u0423\u030b\u04f2" +
+	"\u0443\u030b\u04f3A\u030c\u01cdC\u030c\u010cD\u030c\u010eE\u030c\u011aG\u030c\u01e6" +
+	"H\u030c\u021eI\u030c\u01cfK\u030c\u01e8L\u030c\u013dN\u030c\u0147O\u030c\u01d1" +
+	"R\u030c\u0158S\u030c\u0160T\u030c\u0164U\u030c\u01d3Z\u030c\u017da\u030c\u01ce" +
+	"c\u030c\u010dd\u030c\u010fe\u030c\u011bg\u030c\u01e7h\u030c\u021fi\u030c\u01d0" +
+	"j\u030c\u01f0k\u030c\u01e9l\u030c\u013en\u030c\u0148o\u030c\u01d2r\u030c\u0159" +
+	"s\u030c\u0161t\u030c\u0165u\u030c\u01d4z\u030c\u017e\u00dc\u030c\u01d9\u00fc\u030c\u01da" +
+	"\u01b7\u030c\u01ee\u0292\u030c\u01efA\u030f\u0200E\u030f\u0204I\u030f\u0208O\u030f\u020c" +
+	"R\u030f\u0210U\u030f\u0214a\u030f\u0201e\u030f\u0205i\u030f\u0209o\u030f\u020d" +
+	"r\u030f\u0211u\u030f\u0215\u0474\u030f\u0476\u0475\u030f\u0477A\u0311\u0202E\u0311\u0206" +
+	"I\u0311\u020aO\u0311\u020eR\u0311\u0212U\u0311\u0216a\u0311\u0203e\u0311\u0207" +
+	"i\u0311\u020bo\u0311\u020fr\u0311\u0213u\u0311\u0217O\u031b\u01a0U\u031b\u01af" +
+	"o\u031b\u01a1u\u031b\u01b0\u00d2\u031b\u1edc\u00d3\u031b\u1eda\u00d5\u031b\u1ee0\u00d9\u031b\u1eea" +
+	"\u00da\u031b\u1ee8\u00f2\u031b\u1edd\u00f3\u031b\u1edb\u00f5\u031b\u1ee1\u00f9\u031b\u1eeb\u00fa\u031b\u1ee9" +
+	"\u0168\u031b\u1eee\u0169\u031b\u1eef\u1ecc\u031b\u1ee2\u1ecd\u031b\u1ee3\u1ece\u031b\u1ede\u1ecf\u031b\u1edf" +
+	"\u1ee4\u031b\u1ef0\u1ee5\u031b\u1ef1\u1ee6\u031b\u1eec\u1ee7\u031b\u1eedA\u0323\u1ea0B\u0323\u1e04" +
+	"D\u0323\u1e0cE\u0323\u1eb8H\u0323\u1e24I\u0323\u1ecaK\u0323\u1e32L\u0323\u1e36" +
+	"M\u0323\u1e42N\u0323\u1e46O\u0323\u1eccR\u0323\u1e5aS\u0323\u1e62T\u0323\u1e6c" +
+	"U\u0323\u1ee4V\u0323\u1e7eW\u0323\u1e88Y\u0323\u1ef4Z\u0323\u1e92a\u0323\u1ea1" +
+	"b\u0323\u1e05d\u0323\u1e0de\u0323\u1eb9h\u0323\u1e25i\u0323\u1ecbk\u0323\u1e33" +
+	"l\u0323\u1e37m\u0323\u1e43n\u0323\u1e47o\u0323\u1ecdr\u0323\u1e5bs\u0323\u1e63" +
+	"t\u0323\u1e6du\u0323\u1ee5v\u0323\u1e7fw\u0323\u1e89y\u0323\u1ef5z\u0323\u1e93" +
+	"\u00c2\u0323\u1eac\u00ca\u0323\u1ec6\u00d4\u0323\u1ed8\u00e2\u0323\u1ead\u00ea\u0323\u1ec7\u00f4\u0323\u1ed9" +
+	"\u0102\u0323\u1eb6\u0103\u0323\u1eb7\u01a0\u0323\u1ee2\u01a1\u0323\u1ee3\u01af\u0323\u1ef0\u01b0\u0323\u1ef1" +
+	"\u1e60\u0323\u1e68\u1e61\u0323\u1e69U\u0324\u1e72u\u0324\u1e73A\u0325\u1e00a\u0325\u1e01" +
+	"S\u0326\u0218T\u0326\u021as\u0326\u0219t\u0326\u021bC\u0327\u00c7D\u0327\u1e10" +
+	"E\u0327\u0228G\u0327\u0122H\u0327\u1e28K\u0327\u0136L\u0327\u013bN\u0327\u0145" +
+	"R\u0327\u0156S\u0327\u015eT\u0327\u0162c\u0327\u00e7d\u0327\u1e11e\u0327\u0229" +
+	"g\u0327\u0123h\u0327\u1e29k\u0327\u0137l\u0327\u013cn\u0327\u0146r\u0327\u0157" +
+	"s\u0327\u015ft\u0327\u0163\u0106\u0327\u1e08\u0107\u0327\u1e09\u0114\u0327\u1e1c\u0115\u0327\u1e1d" +
+	"A\u0328\u0104E\u0328\u0118I\u0328\u012eO\u0328\u01eaU\u0328\u0172a\u0328\u0105" +
+	"e\u0328\u0119i\u0328\u012fo\u0328\u01ebu\u0328\u0173\u014c\u0328\u01ec\u014d\u0328\u01ed" +
+	"D\u032d\u1e12E\u032d\u1e18L\u032d\u1e3cN\u032d\u1e4aT\u032d\u1e70U\u032d\u1e76" +
+	"d\u032d\u1e13e\u032d\u1e19l\u032d\u1e3dn\u032d\u1e4bt\u032d\u1e71u\u032d\u1e77" +
+	"H\u032e\u1e2ah\u032e\u1e2bE\u0330\u1e1aI\u0330\u1e2cU\u0330\u1e74e\u0330\u1e1b" +
+	"i\u0330\u1e2du\u0330\u1e75B\u0331\u1e06D\u0331\u1e0eK\u0331\u1e34L\u0331\u1e3a" +
+	"N\u0331\u1e48R\u0331\u1e5eT\u0331\u1e6eZ\u0331\u1e94b\u0331\u1e07d\u0331\u1e0f" +
+	"h\u0331\u1e96k\u0331\u1e35l\u0331\u1e3bn\u0331\u1e49r\u0331\u1e5ft\u0331\u1e6f" +
+	"z\u0331\u1e95"
@@ -0,0 +1,294 @@
+package restructure
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/flouciel/folian-parser/internal/policy"
+)
+
+// CoverMaxSize scales the cover down so neither side exceeds N pixels (0 keeps its size)
+var CoverMaxSize int
+
+// NormalizeCover converts WEBP covers to JPEG and GIF covers to PNG
+var NormalizeCover bool
+
+// CoverThumbnail generates a small JPEG thumbnail of the cover for stores that require one
+var CoverThumbnail bool
+
+// coverThumbnailSize is the longest side of the generated cover thumbnail
+const coverThumbnailSize = 300
+
+// coverThumbnailFile is the filename of the generated cover thumbnail
+const coverThumbnailFile = "cover-thumbnail.jpg"
+
+// defaultCoverWidth and defaultCoverHeight size the titlepage SVG when the cover
+// dimensions cannot be read
+const (
+	defaultCoverWidth  = 1038
+	defaultCoverHeight = 1380
+)
+
+// svgViewBoxPattern and svgImageSizePattern match the fixed sizes of titlepage
+// templates that predate the cover dimension placeholders
+var (
+	svgViewBoxPattern   = regexp.MustCompile(`viewBox="0 0 \d+ \d+"`)
+	svgImageSizePattern = regexp.MustCompile(`(<image[^>]*?)width="\d+" height="\d+"`)
+)
+
+// jpegQuality is the quality used when re-encoding JPEG images
+const jpegQuality = 90
+
+// prepareCover applies the cover normalization and resizing options, returning the
+// cover data and its (possibly changed) file extension
+func prepareCover(content []byte, ext string) ([]byte, string) {
+	ext = strings.ToLower(ext)
+
+	if CompatibilityProfile && needsTranscoding(ext) {
+		if converted, newExt, err := transcodeImage(content, ext); err != nil {
+			policy.Warn(policy.KindOther, "Keeping %s cover: %v", strings.ToUpper(ext[1:]), err)
+		} else {
+			if DebugMode {
+				fmt.Printf("🖼️  Transcoded %s cover to %s\n", strings.ToUpper(ext[1:]), strings.ToUpper(newExt[1:]))
+			}
+			content, ext = converted, newExt
+		}
+	}
+
+	if NormalizeCover {
+		switch ext {
+		case ".gif":
+			img, err := gif.Decode(bytes.NewReader(content))
+			if err != nil {
+				policy.Warn(policy.KindOther, "Failed to decode GIF cover: %v", err)
+				break
+			}
+			var buf bytes.Buffer
+			if err := png.Encode(&buf, img); err != nil {
+				policy.Warn(policy.KindOther, "Failed to convert GIF cover to PNG: %v", err)
+				break
+			}
+			content, ext = buf.Bytes(), ".png"
+			if DebugMode {
+				fmt.Printf("🖼️  Converted GIF cover to PNG\n")
+			}
+		case ".webp":
+			converted, err := convertImage(content, ext, ".jpg")
+			if err != nil {
+				policy.Warn(policy.KindOther, "Keeping WEBP cover: %v", err)
+				break
+			}
+			content, ext = converted, ".jpg"
+			if DebugMode {
+				fmt.Printf("🖼️  Converted WEBP cover to JPEG\n")
+			}
+		}
+	}
+
+	if CoverMaxSize > 0 {
+		config, _, err := image.DecodeConfig(bytes.NewReader(content))
+		if err != nil {
+			policy.Warn(policy.KindOther, "Cannot resize cover (%s): %v", ext, err)
+			return content, ext
+		}
+		if config.Width <= CoverMaxSize && config.Height <= CoverMaxSize {
+			return content, ext
+		}
+
+		img, format, err := image.Decode(bytes.NewReader(content))
+		if err != nil {
+			policy.Warn(policy.KindOther, "Failed to decode cover: %v", err)
+			return content, ext
+		}
+		scaled := scaleImage(img, CoverMaxSize)
+
+		var buf bytes.Buffer
+		scaledExt := ext
+		if format == "jpeg" {
+			err = jpeg.Encode(&buf, scaled, &jpeg.Options{Quality: jpegQuality})
+		} else {
+			// GIF palettes degrade when scaled, so scaled covers are written as PNG
+			err = png.Encode(&buf, scaled)
+			scaledExt = ".png"
+		}
+		if err != nil {
+			policy.Warn(policy.KindOther, "Failed to encode resized cover: %v", err)
+			return content, ext
+		}
+		content, ext = buf.Bytes(), scaledExt
+
+		if DebugMode {
+			bounds := scaled.Bounds()
+			fmt.Printf("🖼️  Resized cover from %dx%d to %dx%d\n", config.Width, config.Height, bounds.Dx(), bounds.Dy())
+		}
+	}
+
+	return content, ext
+}
+
+// setCoverDimensions sizes the titlepage SVG wrapper to the cover image so that it
+// displays without distortion
+func setCoverDimensions(titlePage string, content []byte) string {
+	width, height, ok := imageDimensions(content)
+	if !ok {
+		policy.Warn(policy.KindOther, "Cannot read cover dimensions, using %dx%d for the titlepage", defaultCoverWidth, defaultCoverHeight)
+		width, height = defaultCoverWidth, defaultCoverHeight
+	} else if DebugMode {
+		fmt.Printf("🖼️  Cover dimensions: %dx%d\n", width, height)
+	}
+
+	w, h := strconv.Itoa(width), strconv.Itoa(height)
+	if strings.Contains(titlePage, "{{COVER_WIDTH}}") {
+		return strings.NewReplacer("{{COVER_WIDTH}}", w, "{{COVER_HEIGHT}}", h).Replace(titlePage)
+	}
+
+	titlePage = svgViewBoxPattern.ReplaceAllString(titlePage, `viewBox="0 0 `+w+` `+h+`"`)
+	return svgImageSizePattern.ReplaceAllString(titlePage, `${1}width="`+w+`" height="`+h+`"`)
+}
+
+// imageDimensions reads the pixel dimensions from an image header (JPEG, PNG, GIF or WEBP)
+func imageDimensions(content []byte) (int, int, bool) {
+	if config, _, err := image.DecodeConfig(bytes.NewReader(content)); err == nil {
+		return config.Width, config.Height, true
+	}
+	return webpDimensions(content)
+}
+
+// webpDimensions reads the canvas size from a WEBP header (lossy, lossless or extended)
+func webpDimensions(content []byte) (int, int, bool) {
+	if len(content) < 30 || string(content[0:4]) != "RIFF" || string(content[8:12]) != "WEBP" {
+		return 0, 0, false
+	}
+
+	data := content[20:]
+	switch string(content[12:16]) {
+	case "VP8 ":
+		// Frame tag (3 bytes) and start code (9d 01 2a) precede the 14-bit sizes
+		if data[3] != 0x9d || data[4] != 0x01 || data[5] != 0x2a {
+			return 0, 0, false
+		}
+		width := int(data[6]) | int(data[7]&0x3f)<<8
+		height := int(data[8]) | int(data[9]&0x3f)<<8
+		return width, height, true
+	case "VP8L":
+		if data[0] != 0x2f {
+			return 0, 0, false
+		}
+		bits := uint32(data[1]) | uint32(data[2])<<8 | uint32(data[3])<<16 | uint32(data[4])<<24
+		return int(bits&0x3fff) + 1, int(bits>>14&0x3fff) + 1, true
+	case "VP8X":
+		width := int(data[4]) | int(data[5])<<8 | int(data[6])<<16
+		height := int(data[7]) | int(data[8])<<8 | int(data[9])<<16
+		return width + 1, height + 1, true
+	}
+	return 0, 0, false
+}
+
+// writeCoverThumbnail writes a JPEG thumbnail of the cover to the images directory
+func writeCoverThumbnail(content []byte, imagesPath string) error {
+	img, _, err := image.Decode(bytes.NewReader(content))
+	if err != nil {
+		return fmt.Errorf("failed to decode cover: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, scaleImage(img, coverThumbnailSize), &jpeg.Options{Quality: jpegQuality}); err != nil {
+		return fmt.Errorf("failed to encode cover thumbnail: %w", err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(imagesPath, coverThumbnailFile), buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write cover thumbnail: %w", err)
+	}
+	return nil
+}
+
+// scaleImage scales an image down with a box filter so that neither side exceeds
+// maxSize, keeping its aspect ratio
+func scaleImage(src image.Image, maxSize int) image.Image {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= maxSize && height <= maxSize {
+		return src
+	}
+
+	newWidth, newHeight := maxSize, height*maxSize/width
+	if height > width {
+		newWidth, newHeight = width*maxSize/height, maxSize
+	}
+	if newWidth < 1 {
+		newWidth = 1
+	}
+	if newHeight < 1 {
+		newHeight = 1
+	}
+
+	dst := image.NewRGBA64(image.Rect(0, 0, newWidth, newHeight))
+	for y := 0; y < newHeight; y++ {
+		y0, y1 := bounds.Min.Y+y*height/newHeight, bounds.Min.Y+(y+1)*height/newHeight
+		for x := 0; x < newWidth; x++ {
+			x0, x1 := bounds.Min.X+x*width/newWidth, bounds.Min.X+(x+1)*width/newWidth
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			dst.SetRGBA64(x, y, color.RGBA64{uint16(r / n), uint16(g / n), uint16(b / n), uint16(a / n)})
+		}
+	}
+	return dst
+}
+
+// imageConverters are the external tools tried, in order, for formats the standard
+// library cannot decode; each receives the input and output paths
+var imageConverters = []struct {
+	name string
+	args func(in, out string) []string
+}{
+	{"magick", func(in, out string) []string { return []string{in, out} }},
+	{"convert", func(in, out string) []string { return []string{in, out} }},
+	{"ffmpeg", func(in, out string) []string { return []string{"-loglevel", "error", "-y", "-i", in, out} }},
+}
+
+// convertImage converts image data between formats using the first available
+// external converter (ImageMagick or ffmpeg)
+func convertImage(content []byte, fromExt, toExt string) ([]byte, error) {
+	tempDir, err := ioutil.TempDir("", "folian-image-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	in := filepath.Join(tempDir, "input"+fromExt)
+	out := filepath.Join(tempDir, "output"+toExt)
+	if err := ioutil.WriteFile(in, content, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write %s image: %w", fromExt, err)
+	}
+
+	for _, converter := range imageConverters {
+		if _, err := exec.LookPath(converter.name); err != nil {
+			continue
+		}
+		if output, err := exec.Command(converter.name, converter.args(in, out)...).CombinedOutput(); err != nil {
+			return nil, fmt.Errorf("%s failed to convert %s to %s: %v: %s", converter.name, fromExt, toExt, err, strings.TrimSpace(string(output)))
+		}
+		return ioutil.ReadFile(out)
+	}
+
+	return nil, fmt.Errorf("no image converter found for %s (install ImageMagick or ffmpeg)", fromExt)
+}
@@ -0,0 +1,233 @@
+package restructure
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// CSSLint checks the output styles against the device capability matrix: "warn"
+// reports unsupported features and "fix" also applies their fallbacks. A device
+// profile always applies the fallbacks for its devices.
+var CSSLint string
+
+// cssCapability is a row of the device capability matrix
+type cssCapability struct {
+	feature string
+	// pattern matches a normalized declaration ("property: value")
+	pattern *regexp.Regexp
+	// unsupported lists the profiles whose devices lack the feature
+	unsupported []string
+	// fallback rewrites a declaration; an empty result drops it and a nil fallback
+	// only warns
+	fallback func(declaration string) string
+}
+
+// remUnitPattern matches lengths in rem units
+var remUnitPattern = regexp.MustCompile(`(\d)rem\b`)
+
+// cssCapabilities is the device capability matrix
+var cssCapabilities = []cssCapability{
+	{
+		feature:     "flexbox",
+		pattern:     regexp.MustCompile(`^display: (inline-)?flex\b`),
+		unsupported: []string{"kindle", "adobe", "strict-epub2"},
+		fallback:    func(string) string { return "display: block" },
+	},
+	{
+		feature:     "grid layout",
+		pattern:     regexp.MustCompile(`^display: (inline-)?grid\b`),
+		unsupported: []string{"kindle", "kobo", "adobe", "strict-epub2"},
+		fallback:    func(string) string { return "display: block" },
+	},
+	{
+		feature:     "fixed positioning",
+		pattern:     regexp.MustCompile(`^position: fixed\b`),
+		unsupported: []string{"kindle", "kobo", "adobe", "strict-epub2"},
+		fallback:    func(string) string { return "" },
+	},
+	{
+		feature:     "multi-column layout",
+		pattern:     regexp.MustCompile(`^column(s|-count|-width|-gap|-rule):`),
+		unsupported: []string{"kindle", "adobe", "strict-epub2"},
+		fallback:    func(string) string { return "" },
+	},
+	{
+		feature:     "rem units",
+		pattern:     regexp.MustCompile(`\drem\b`),
+		unsupported: []string{"adobe", "strict-epub2"},
+		fallback:    func(declaration string) string { return remUnitPattern.ReplaceAllString(declaration, "${1}em") },
+	},
+	{
+		feature:     "viewport units",
+		pattern:     regexp.MustCompile(`\d(vh|vw|vmin|vmax)\b`),
+		unsupported: []string{"kindle", "adobe", "strict-epub2"},
+	},
+	{
+		feature:     "calc()",
+		pattern:     regexp.MustCompile(`\bcalc\(`),
+		unsupported: []string{"adobe", "strict-epub2"},
+	},
+	{
+		feature:     "custom properties",
+		pattern:     regexp.MustCompile(`^--|\bvar\(`),
+		unsupported: []string{"kindle", "adobe", "strict-epub2"},
+	},
+	{
+		feature:     "WOFF fonts",
+		pattern:     regexp.MustCompile(`^src: .*\.woff2?\b`),
+		unsupported: []string{"kindle", "adobe", "strict-epub2"},
+	},
+}
+
+// cssAtRuleCapabilities lists at-rules missing on some devices, matched against the
+// whole stylesheet
+var cssAtRuleCapabilities = []cssCapability{
+	{
+		feature:     "@supports",
+		pattern:     regexp.MustCompile(`@supports\b`),
+		unsupported: []string{"kindle", "adobe", "strict-epub2"},
+	},
+	{
+		feature:     "@media prefers-color-scheme",
+		pattern:     regexp.MustCompile(`prefers-color-scheme`),
+		unsupported: []string{"kindle", "adobe", "strict-epub2"},
+	},
+}
+
+// styleAttrPattern matches inline style attributes
+var styleAttrPattern = regexp.MustCompile(`(\sstyle=")([^"]*)(")`)
+
+// cssLintEnabled reports whether output styles need to be linted or fixed
+func cssLintEnabled() bool {
+	return ActiveProfile != nil || CSSLint != ""
+}
+
+// cssLintTargets returns the profiles to check against: the active one, or all of them
+func cssLintTargets() []string {
+	if ActiveProfile != nil {
+		return []string{ActiveProfile.Name}
+	}
+	return ProfileNames()
+}
+
+// lintCSS checks a stylesheet against the capability matrix, reporting and, when
+// fixing, applying fallbacks for features the target devices lack
+func lintCSS(css, source string) string {
+	for _, capability := range cssAtRuleCapabilities {
+		if capability.pattern.MatchString(css) {
+			reportCSSFinding(capability, source, "", false)
+		}
+	}
+	return cssBlockPattern.ReplaceAllStringFunc(css, func(block string) string {
+		return "{" + lintDeclarations(block[1:len(block)-1], source) + "}"
+	})
+}
+
+// lintXHTMLStyles lints the style blocks and inline style attributes of a content document
+func lintXHTMLStyles(content, source string) string {
+	content = styleBlockPattern.ReplaceAllStringFunc(content, func(block string) string {
+		return lintCSS(block, source)
+	})
+	return styleAttrPattern.ReplaceAllStringFunc(content, func(attr string) string {
+		parts := styleAttrPattern.FindStringSubmatch(attr)
+		return parts[1] + lintDeclarations(parts[2], source) + parts[3]
+	})
+}
+
+// lintDeclarations checks a semicolon-separated declaration list
+func lintDeclarations(declarations, source string) string {
+	fix := ActiveProfile != nil || CSSLint == "fix"
+
+	list := strings.Split(declarations, ";")
+	kept := list[:0]
+	for _, declaration := range list {
+		// Keep surrounding whitespace and comments when a declaration is rewritten
+		parts := declarationPattern.FindStringSubmatch(declaration)
+		prefix, body, suffix := parts[1], parts[2], parts[3]
+		normalized := normalizeDeclaration(body)
+
+		dropped := false
+		for _, capability := range cssCapabilities {
+			if normalized == "" || !capability.pattern.MatchString(normalized) || len(unsupportedTargets(capability)) == 0 {
+				continue
+			}
+			applied := fix && capability.fallback != nil
+			reportCSSFinding(capability, source, normalized, applied)
+			if !applied {
+				continue
+			}
+
+			normalized = capability.fallback(normalized)
+			body = normalized
+			if normalized == "" {
+				dropped = true
+				break
+			}
+		}
+		if dropped {
+			continue
+		}
+		kept = append(kept, prefix+body+suffix)
+	}
+	return strings.Join(kept, ";")
+}
+
+// declarationPattern splits a declaration into leading whitespace and comments, the
+// declaration itself and trailing whitespace
+var declarationPattern = regexp.MustCompile(`(?s)^(\s*(?:/\*.*?\*/\s*)*)(.*?)(\s*)$`)
+
+// cssCommentPattern matches CSS comments
+var cssCommentPattern = regexp.MustCompile(`(?s)/\*.*?\*/`)
+
+// normalizeDeclaration lowercases a declaration's property and puts it in
+// "property: value" form without comments, or returns "" for blank or malformed input
+func normalizeDeclaration(declaration string) string {
+	parts := strings.SplitN(cssCommentPattern.ReplaceAllString(declaration, ""), ":", 2)
+	if len(parts) != 2 {
+		return ""
+	}
+	property := strings.ToLower(strings.TrimSpace(parts[0]))
+	value := strings.Join(strings.Fields(parts[1]), " ")
+	if property == "" || value == "" {
+		return ""
+	}
+	return property + ": " + value
+}
+
+// unsupportedTargets returns the lint targets that lack a feature
+func unsupportedTargets(capability cssCapability) []string {
+	var devices []string
+	for _, target := range cssLintTargets() {
+		for _, name := range capability.unsupported {
+			if name == target {
+				devices = append(devices, name)
+			}
+		}
+	}
+	sort.Strings(devices)
+	return devices
+}
+
+// reportCSSFinding prints a lint finding when linting was requested, and fallbacks
+// applied by a profile in debug mode
+func reportCSSFinding(capability cssCapability, source, declaration string, applied bool) {
+	devices := unsupportedTargets(capability)
+	if len(devices) == 0 || (CSSLint == "" && !(DebugMode && applied)) {
+		return
+	}
+
+	location := source
+	if declaration != "" {
+		location += ": " + declaration
+	}
+	message := fmt.Sprintf("%s unsupported on %s", capability.feature, strings.Join(devices, ", "))
+	switch {
+	case applied && capability.fallback(declaration) == "":
+		message += " (removed)"
+	case applied:
+		message += " (→ " + capability.fallback(declaration) + ")"
+	}
+	fmt.Printf("🎨 CSS %s: %s\n", location, message)
+}
@@ -0,0 +1,266 @@
+package restructure
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DarkModeCSS moves hardcoded colors and background images into prefers-color-scheme
+// media queries so that the reader's night mode controls the colors elsewhere
+var DarkModeCSS bool
+
+// colorProperties are the declarations that pin text or background colors
+var colorProperties = map[string]bool{
+	"color":            true,
+	"background":       true,
+	"background-color": true,
+	"background-image": true,
+}
+
+// namedColors maps the CSS color keywords common in book stylesheets to RGB
+var namedColors = map[string][3]int{
+	"black":     {0, 0, 0},
+	"white":     {255, 255, 255},
+	"gray":      {128, 128, 128},
+	"grey":      {128, 128, 128},
+	"silver":    {192, 192, 192},
+	"darkgray":  {169, 169, 169},
+	"darkgrey":  {169, 169, 169},
+	"lightgray": {211, 211, 211},
+	"lightgrey": {211, 211, 211},
+	"dimgray":   {105, 105, 105},
+	"dimgrey":   {105, 105, 105},
+	"red":       {255, 0, 0},
+	"green":     {0, 128, 0},
+	"blue":      {0, 0, 255},
+	"navy":      {0, 0, 128},
+	"maroon":    {128, 0, 0},
+}
+
+// Patterns matching the colors darkModeColor can invert
+var (
+	hexColorPattern = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+	rgbColorPattern = regexp.MustCompile(`^rgb\(\s*(\d+)\s*,\s*(\d+)\s*,\s*(\d+)\s*\)$`)
+)
+
+// themedRule holds the color declarations moved out of a rule
+type themedRule struct {
+	selector string
+	light    []string
+	dark     []string
+}
+
+// darkModeStyleBlocks applies darkModeStyles to the style blocks of a content document
+func darkModeStyleBlocks(content string) string {
+	return styleBlockPattern.ReplaceAllStringFunc(content, func(block string) string {
+		start, end := strings.Index(block, ">")+1, strings.LastIndex(block, "</")
+		return block[:start] + darkModeStyles(block[start:end]) + block[end:]
+	})
+}
+
+// darkModeStyles rewrites a stylesheet so that its top-level rules no longer pin
+// colors: the original colors apply only in light mode and lightness-inverted ones
+// in dark mode; background images are kept for light mode only
+func darkModeStyles(css string) string {
+	var out strings.Builder
+	var themed []themedRule
+
+	// last is the end of the text already written, start the beginning of the selector
+	depth, last, start := 0, 0, 0
+	for i := 0; i < len(css); i++ {
+		switch css[i] {
+		case '{':
+			if depth == 0 {
+				end := matchingBrace(css, i)
+				selector := strings.TrimSpace(cssCommentPattern.ReplaceAllString(css[start:i], ""))
+				if end < 0 || strings.HasPrefix(selector, "@") || strings.Contains(css[i+1:end], "{") {
+					depth++
+					continue
+				}
+				body := css[i+1 : end]
+
+				kept, rule := splitColorDeclarations(selector, body)
+				out.WriteString(css[last:i+1] + kept + "}")
+				if len(rule.light) > 0 {
+					themed = append(themed, rule)
+				}
+				i, last, start = end, end+1, end+1
+				continue
+			}
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				start = i + 1
+			}
+		}
+	}
+	out.WriteString(css[last:])
+
+	if len(themed) == 0 {
+		return out.String()
+	}
+
+	out.WriteString("\n/* Colors apply only to the matching reader theme */\n@media (prefers-color-scheme: light) {\n")
+	for _, rule := range themed {
+		fmt.Fprintf(&out, "  %s { %s }\n", rule.selector, strings.Join(rule.light, "; "))
+	}
+	out.WriteString("}\n@media (prefers-color-scheme: dark) {\n")
+	for _, rule := range themed {
+		if len(rule.dark) > 0 {
+			fmt.Fprintf(&out, "  %s { %s }\n", rule.selector, strings.Join(rule.dark, "; "))
+		}
+	}
+	out.WriteString("}\n")
+
+	if DebugMode {
+		fmt.Printf("🌙 Moved colors of %d rules into prefers-color-scheme queries\n", len(themed))
+	}
+	return out.String()
+}
+
+// matchingBrace returns the index of the brace closing the one at open, or -1
+func matchingBrace(css string, open int) int {
+	depth := 0
+	for i := open; i < len(css); i++ {
+		switch css[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// splitColorDeclarations removes the color declarations from a rule body, returning
+// the remaining body and the light and dark variants of the removed declarations
+func splitColorDeclarations(selector, body string) (string, themedRule) {
+	rule := themedRule{selector: strings.Join(strings.Fields(selector), " ")}
+
+	declarations := strings.Split(body, ";")
+	kept := declarations[:0]
+	for _, declaration := range declarations {
+		normalized := normalizeDeclaration(declaration)
+		parts := strings.SplitN(normalized, ": ", 2)
+		if normalized == "" || !colorProperties[parts[0]] || isThemeNeutral(parts[1]) {
+			kept = append(kept, declaration)
+			continue
+		}
+
+		rule.light = append(rule.light, normalized)
+		if inverted, ok := darkModeColor(parts[1]); ok && parts[0] != "background-image" {
+			rule.dark = append(rule.dark, parts[0]+": "+inverted)
+		}
+	}
+
+	body = strings.Join(kept, ";")
+	if strings.TrimSpace(strings.Replace(body, ";", "", -1)) == "" {
+		body = " "
+	}
+	return body, rule
+}
+
+// isThemeNeutral reports whether a color value already follows the reader's theme
+func isThemeNeutral(value string) bool {
+	switch strings.ToLower(strings.TrimSpace(strings.TrimSuffix(value, "!important"))) {
+	case "inherit", "initial", "unset", "currentcolor", "transparent", "none":
+		return true
+	}
+	return false
+}
+
+// darkModeColor inverts the lightness of a color, keeping its hue, so that dark text
+// becomes light and light backgrounds become dark
+func darkModeColor(value string) (string, bool) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	important := ""
+	if strings.HasSuffix(value, "!important") {
+		value, important = strings.TrimSpace(strings.TrimSuffix(value, "!important")), " !important"
+	}
+
+	var rgb [3]int
+	if named, ok := namedColors[value]; ok {
+		rgb = named
+	} else if match := hexColorPattern.FindStringSubmatch(value); match != nil {
+		hex := match[1]
+		if len(hex) == 3 {
+			hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+		}
+		for i := range rgb {
+			channel, _ := strconv.ParseUint(hex[i*2:i*2+2], 16, 8)
+			rgb[i] = int(channel)
+		}
+	} else if match := rgbColorPattern.FindStringSubmatch(value); match != nil {
+		for i := range rgb {
+			rgb[i], _ = strconv.Atoi(match[i+1])
+		}
+	} else {
+		return "", false
+	}
+
+	h, s, l := rgbToHSL(rgb)
+	inverted := hslToRGB(h, s, 1-l)
+	return fmt.Sprintf("#%02x%02x%02x%s", inverted[0], inverted[1], inverted[2], important), true
+}
+
+// rgbToHSL converts 8-bit RGB channels to hue (degrees), saturation and lightness
+func rgbToHSL(rgb [3]int) (float64, float64, float64) {
+	r, g, b := float64(rgb[0])/255, float64(rgb[1])/255, float64(rgb[2])/255
+	max, min := math.Max(r, math.Max(g, b)), math.Min(r, math.Min(g, b))
+	l := (max + min) / 2
+	if max == min {
+		return 0, 0, l
+	}
+
+	d := max - min
+	s := d / (1 - math.Abs(2*l-1))
+	var h float64
+	switch max {
+	case r:
+		h = math.Mod((g-b)/d, 6)
+	case g:
+		h = (b-r)/d + 2
+	default:
+		h = (r-g)/d + 4
+	}
+	h *= 60
+	if h < 0 {
+		h += 360
+	}
+	return h, s, l
+}
+
+// hslToRGB converts hue (degrees), saturation and lightness to 8-bit RGB channels
+func hslToRGB(h, s, l float64) [3]int {
+	c := (1 - math.Abs(2*l-1)) * s
+	x := c * (1 - math.Abs(math.Mod(h/60, 2)-1))
+	m := l - c/2
+
+	var r, g, b float64
+	switch {
+	case h < 60:
+		r, g, b = c, x, 0
+	case h < 120:
+		r, g, b = x, c, 0
+	case h < 180:
+		r, g, b = 0, c, x
+	case h < 240:
+		r, g, b = 0, x, c
+	case h < 300:
+		r, g, b = x, 0, c
+	default:
+		r, g, b = c, 0, x
+	}
+	return [3]int{
+		int(math.Round((r + m) * 255)),
+		int(math.Round((g + m) * 255)),
+		int(math.Round((b + m) * 255)),
+	}
+}
@@ -0,0 +1,88 @@
+package restructure
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/flouciel/folian-parser/internal/parser"
+	"github.com/flouciel/folian-parser/internal/policy"
+)
+
+// dateLayouts are the date formats that can be repaired into W3CDTF, with the
+// W3CDTF layout each one keeps its precision in
+var dateLayouts = []struct {
+	layout string
+	output string
+}{
+	{time.RFC3339Nano, "2006-01-02T15:04:05Z"},
+	{"2006-01-02T15:04:05", "2006-01-02T15:04:05Z"},
+	{"2006-01-02 15:04:05", "2006-01-02T15:04:05Z"},
+	{"2006-01-02 15:04:05Z07:00", "2006-01-02T15:04:05Z"},
+	{"2006-01-02", "2006-01-02"},
+	{"2006-1-2", "2006-01-02"},
+	{"2006/01/02", "2006-01-02"},
+	{"2006/1/2", "2006-01-02"},
+	{"2006.01.02", "2006-01-02"},
+	{"January 2, 2006", "2006-01-02"},
+	{"Jan 2, 2006", "2006-01-02"},
+	{"2 January 2006", "2006-01-02"},
+	{"2 Jan 2006", "2006-01-02"},
+	{"2006-01", "2006-01"},
+	{"January 2006", "2006-01"},
+	{"Jan 2006", "2006-01"},
+	{"2006", "2006"},
+}
+
+// normalizeDate converts a date to W3CDTF (YYYY, YYYY-MM, YYYY-MM-DD or a UTC
+// timestamp), reporting false for values that cannot be repaired
+func normalizeDate(value string) (string, bool) {
+	value = strings.TrimSpace(value)
+	for _, layout := range dateLayouts {
+		parsed, err := time.Parse(layout.layout, value)
+		if err != nil {
+			continue
+		}
+		// Calibre writes year 101 for unknown dates
+		if parsed.Year() < 1000 {
+			return "", false
+		}
+		return parsed.UTC().Format(layout.output), true
+	}
+	return "", false
+}
+
+// resolvePublicationDate returns the normalized publication date, falling back to
+// the given time when the book has no date; an invalid date is dropped, returning
+// "", rather than replaced by a date the book was not published on
+func resolvePublicationDate(book *parser.Book, fallback string) string {
+	if book.Metadata.Date == "" {
+		return fallback
+	}
+	date, ok := normalizeDate(book.Metadata.Date)
+	if !ok {
+		policy.Warn(policy.KindDate, "Dropping invalid publication date %q", book.Metadata.Date)
+		return ""
+	}
+	if DebugMode && date != book.Metadata.Date {
+		fmt.Printf("📅 Normalized publication date: %s → %s\n", book.Metadata.Date, date)
+	}
+	return date
+}
+
+// buildDateMetadata renders the creation date as dcterms:created; the publication date
+// is written as dc:date and the modification date is always the processing time
+func buildDateMetadata(book *parser.Book) []metaElement {
+	for _, event := range book.Metadata.Dates {
+		if event.Event != "creation" {
+			continue
+		}
+		date, ok := normalizeDate(event.Value)
+		if !ok {
+			policy.Warn(policy.KindDate, "Dropping invalid creation date %q", event.Value)
+			return nil
+		}
+		return []metaElement{newMeta("meta", date, "property", "dcterms:created")}
+	}
+	return nil
+}
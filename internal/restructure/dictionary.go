@@ -0,0 +1,243 @@
+package restructure
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/flouciel/folian-parser/internal/parser"
+	"github.com/flouciel/folian-parser/internal/policy"
+	"golang.org/x/net/html/atom"
+)
+
+// DictionaryMode processes dictionary-style books with many small entries: files are
+// never consolidated, entry headwords and IDs are kept and an EPUB3 search key map
+// is written for lookups
+var DictionaryMode bool
+
+// searchKeyMapMediaType is the media type of EPUB3 dictionary search key maps
+const searchKeyMapMediaType = "application/vnd.epub.search-key-map+xml"
+
+// kindleNamespaces declares the Kindle dictionary markup prefixes
+const kindleNamespaces = ` xmlns:idx="https://kindlegen.s3.amazonaws.com/AmazonKindlePublishingGuidelines.pdf" xmlns:mbp="https://kindlegen.s3.amazonaws.com/AmazonKindlePublishingGuidelines.pdf"`
+
+// searchKey is a lookup entry of the search key map
+type searchKey struct {
+	Href        string
+	Value       string
+	Inflections []string
+}
+
+// isDictionaryEntry reports whether an element is a dictionary entry, in EPUB3
+// (epub:type="dictentry") or Kindle (idx:entry) markup
+func isDictionaryEntry(s *goquery.Selection) bool {
+	epubType, _ := s.Attr("epub:type")
+	return strings.Contains(" "+epubType+" ", " dictentry ") || goquery.NodeName(s) == "idx:entry"
+}
+
+// markDictionaryEntries gives the entries of a chapter stable IDs so that the search
+// key map can point at them
+func markDictionaryEntries(doc *goquery.Document) {
+	next := 1
+	doc.Find("body *").Each(func(i int, s *goquery.Selection) {
+		if _, exists := s.Attr("id"); exists || !isDictionaryEntry(s) {
+			return
+		}
+		for findID(doc, fmt.Sprintf("entry-%d", next)).Length() > 0 {
+			next++
+		}
+		s.SetAttr("id", fmt.Sprintf("entry-%d", next))
+	})
+}
+
+// removeTitleHeading removes the headings repeating the chapter title, which the
+// clean heading replaces, and demotes the remaining h1 headings below it
+func removeTitleHeading(doc *goquery.Document, title string) {
+	doc.Find("h1, h2, h3, h4, h5, h6").Each(func(i int, s *goquery.Selection) {
+		if strings.EqualFold(strings.TrimSpace(s.Text()), strings.TrimSpace(title)) {
+			s.Remove()
+		} else if goquery.NodeName(s) == "h1" {
+			s.Nodes[0].Data, s.Nodes[0].DataAtom = "h2", atom.H2
+		}
+	})
+}
+
+// addDictionaryNamespaces declares the Kindle prefixes on a chapter that uses them
+func addDictionaryNamespaces(content string) string {
+	if !strings.Contains(content, "<idx:") && !strings.Contains(content, "<mbp:") {
+		return content
+	}
+	return strings.Replace(content, `xmlns:epub="http://www.idpf.org/2007/ops"`, `xmlns:epub="http://www.idpf.org/2007/ops"`+kindleNamespaces, 1)
+}
+
+// collectSearchKeys adds the headwords of the entries of a written chapter to the
+// search key map
+func (r *Restructurer) collectSearchKeys(content, target string) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(content))
+	if err != nil {
+		return
+	}
+
+	doc.Find("body *").Each(func(i int, s *goquery.Selection) {
+		id, exists := s.Attr("id")
+		if !exists || !isDictionaryEntry(s) {
+			return
+		}
+
+		key := searchKey{Href: target + "#" + id}
+		s.Find("*").EachWithBreak(func(i int, child *goquery.Selection) bool {
+			if goquery.NodeName(child) == "idx:orth" {
+				key.Value, _ = child.Attr("value")
+				if key.Value == "" {
+					key.Value = child.Text()
+				}
+				return false
+			}
+			return true
+		})
+		if key.Value == "" {
+			key.Value = s.Find("dfn, h1, h2, h3, h4, h5, h6, b, strong").First().Text()
+		}
+		key.Value = strings.Join(strings.Fields(key.Value), " ")
+		if key.Value == "" {
+			return
+		}
+
+		s.Find("*").Each(func(i int, child *goquery.Selection) {
+			if goquery.NodeName(child) != "idx:iform" {
+				return
+			}
+			if value, _ := child.Attr("value"); value != "" && value != key.Value {
+				key.Inflections = append(key.Inflections, value)
+			}
+		})
+		r.searchKeys = append(r.searchKeys, key)
+	})
+}
+
+// originalSearchKeys reads the book's own search key map, mapping its targets to the
+// output chapters
+func (r *Restructurer) originalSearchKeys(book *parser.Book) ([]searchKey, error) {
+	var item parser.ManifestItem
+	for _, manifestItem := range book.Manifest {
+		if manifestItem.MediaType == searchKeyMapMediaType || strings.Contains(" "+manifestItem.Properties+" ", " search-key-map ") {
+			item = manifestItem
+			break
+		}
+	}
+	if item.Href == "" {
+		return nil, nil
+	}
+	if !DictionaryMode {
+		fmt.Printf("ℹ️  Dropping search key map %s (use -dictionary to keep it)\n", item.Href)
+		return nil, nil
+	}
+
+	opfDir := filepath.Dir(filepath.Join(book.Path, book.OPFPath))
+	data, err := ioutil.ReadFile(filepath.Join(opfDir, filepath.FromSlash(item.Href)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", item.Href, err)
+	}
+
+	type Value struct {
+		Value string `xml:"value,attr"`
+	}
+	type Match struct {
+		Value  string  `xml:"value,attr"`
+		Values []Value `xml:"value"`
+	}
+	type Group struct {
+		Href    string  `xml:"href,attr"`
+		Matches []Match `xml:"match"`
+	}
+	type SearchKeyMap struct {
+		Groups []Group `xml:"search-key-group"`
+	}
+
+	var keyMap SearchKeyMap
+	if err := xml.Unmarshal(data, &keyMap); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal %s: %w", item.Href, err)
+	}
+
+	var keys []searchKey
+	for _, group := range keyMap.Groups {
+		target, ok := r.mapHref(path.Join(path.Dir(item.Href), group.Href))
+		if !ok {
+			if DebugMode {
+				fmt.Printf("⚠️  Search key group: no output location for %s\n", group.Href)
+			}
+			continue
+		}
+		for _, match := range group.Matches {
+			key := searchKey{Href: target, Value: match.Value}
+			for _, value := range match.Values {
+				key.Inflections = append(key.Inflections, value.Value)
+			}
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// processSearchKeys writes OEBPS/search-key-map.xml from the book's own search key
+// map and the headwords of the entries found in the written chapters
+func (r *Restructurer) processSearchKeys(book *parser.Book, oebpsPath string) error {
+	r.hasSearchKeyMap = false
+
+	original, err := r.originalSearchKeys(book)
+	if err != nil {
+		return err
+	}
+	if !DictionaryMode {
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	var content strings.Builder
+	content.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	content.WriteString(`<search-key-map xmlns="http://www.idpf.org/2007/ops"`)
+	if book.Metadata.Language != "" {
+		fmt.Fprintf(&content, ` xml:lang="%s"`, xmlEscape(book.Metadata.Language))
+	}
+	content.WriteString(">\n")
+	written := 0
+	for _, key := range append(original, r.searchKeys...) {
+		if seen[key.Href+"\x00"+key.Value] {
+			continue
+		}
+		seen[key.Href+"\x00"+key.Value] = true
+
+		fmt.Fprintf(&content, "  <search-key-group href=\"%s\">\n", xmlEscape(key.Href))
+		if len(key.Inflections) == 0 {
+			fmt.Fprintf(&content, "    <match value=\"%s\"/>\n", xmlEscape(key.Value))
+		} else {
+			fmt.Fprintf(&content, "    <match value=\"%s\">\n", xmlEscape(key.Value))
+			for _, inflection := range key.Inflections {
+				fmt.Fprintf(&content, "      <value value=\"%s\"/>\n", xmlEscape(inflection))
+			}
+			content.WriteString("    </match>\n")
+		}
+		content.WriteString("  </search-key-group>\n")
+		written++
+	}
+	content.WriteString("</search-key-map>\n")
+
+	if written == 0 {
+		policy.Warn(policy.KindOther, "No dictionary entries found (mark entries with epub:type=\"dictentry\" or idx:entry)")
+		return nil
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(oebpsPath, "search-key-map.xml"), []byte(content.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write search-key-map.xml: %w", err)
+	}
+	r.hasSearchKeyMap = true
+
+	if DebugMode {
+		fmt.Printf("📖 Wrote search key map with %d entries\n", written)
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package restructure
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/flouciel/folian-parser/internal/parser"
+)
+
+// PreserveExtras preserves vendor support files (Apple display options, Adobe page-map)
+// instead of dropping them
+var PreserveExtras bool
+
+// appleDisplayOptionsPath is the location of the iBooks display options file in an EPUB
+const appleDisplayOptionsPath = "META-INF/com.apple.ibooks.display-options.xml"
+
+// pageMapMediaType is the media type of Adobe page-map files
+const pageMapMediaType = "application/oebps-page-map+xml"
+
+// processExtras copies the Apple display options and converts the Adobe page-map
+// so that its references point into the restructured book
+func (r *Restructurer) processExtras(book *parser.Book, restructuredPath, oebpsPath string) error {
+	r.hasPageMap = false
+
+	// Adobe page templates are vendor cleanup rather than extras
+	if err := r.processPageTemplates(book, oebpsPath); err != nil {
+		return err
+	}
+
+	displayOptionsPath := filepath.Join(book.Path, filepath.FromSlash(appleDisplayOptionsPath))
+	_, displayOptionsErr := os.Stat(displayOptionsPath)
+	pageMapItem, hasPageMap := findPageMap(book)
+
+	if !PreserveExtras {
+		if displayOptionsErr == nil {
+			fmt.Printf("ℹ️  Dropping %s (use -preserve-extras to keep it)\n", appleDisplayOptionsPath)
+		}
+		if hasPageMap {
+			fmt.Printf("ℹ️  Dropping page-map %s (use -preserve-extras to convert it)\n", pageMapItem.Href)
+		}
+		return nil
+	}
+
+	if displayOptionsErr == nil {
+		content, err := ioutil.ReadFile(displayOptionsPath)
+		if err != nil {
+			return fmt.Errorf("failed to read Apple display options: %w", err)
+		}
+		if vendorCleanup("display-options") {
+			cleaned, changed := cleanDisplayOptions(string(content))
+			if changed > 0 {
+				fmt.Printf("ℹ️  Fixed %d Apple display options for the reflowable output (use -keep-vendor display-options to keep them)\n", changed)
+			}
+			content = []byte(cleaned)
+		}
+		outputPath := filepath.Join(restructuredPath, filepath.FromSlash(appleDisplayOptionsPath))
+		if err := ioutil.WriteFile(outputPath, content, 0644); err != nil {
+			return fmt.Errorf("failed to write Apple display options: %w", err)
+		}
+		if DebugMode {
+			fmt.Printf("✅ Preserved %s\n", appleDisplayOptionsPath)
+		}
+	}
+
+	if hasPageMap {
+		if err := r.convertPageMap(book, pageMapItem, oebpsPath); err != nil {
+			return fmt.Errorf("failed to convert page-map: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// findPageMap returns the manifest item of the Adobe page-map, if any
+func findPageMap(book *parser.Book) (parser.ManifestItem, bool) {
+	for _, item := range book.Manifest {
+		if item.MediaType == pageMapMediaType {
+			return item, true
+		}
+	}
+	return parser.ManifestItem{}, false
+}
+
+// convertPageMap rewrites the page-map hrefs through the chapter mapping and writes
+// the result to OEBPS/page-map.xml
+func (r *Restructurer) convertPageMap(book *parser.Book, item parser.ManifestItem, oebpsPath string) error {
+	opfDir := filepath.Dir(filepath.Join(book.Path, book.OPFPath))
+	data, err := ioutil.ReadFile(filepath.Join(opfDir, filepath.FromSlash(item.Href)))
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", item.Href, err)
+	}
+
+	type Page struct {
+		Name string `xml:"name,attr"`
+		Href string `xml:"href,attr"`
+	}
+	type PageMap struct {
+		Pages []Page `xml:"page"`
+	}
+
+	var pageMap PageMap
+	if err := xml.Unmarshal(data, &pageMap); err != nil {
+		return fmt.Errorf("failed to unmarshal %s: %w", item.Href, err)
+	}
+
+	var content strings.Builder
+	content.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	content.WriteString(`<page-map xmlns="http://www.idpf.org/2007/opf">` + "\n")
+	converted := 0
+	for _, page := range pageMap.Pages {
+		target, ok := r.mapHref(path.Join(path.Dir(item.Href), page.Href))
+		if !ok {
+			if DebugMode {
+				fmt.Printf("⚠️  Page %s: no output location for %s\n", page.Name, page.Href)
+			}
+			continue
+		}
+		fmt.Fprintf(&content, "  <page name=\"%s\" href=\"%s\"/>\n", xmlEscape(page.Name), xmlEscape(target))
+		converted++
+	}
+	content.WriteString("</page-map>\n")
+
+	if err := ioutil.WriteFile(filepath.Join(oebpsPath, "page-map.xml"), []byte(content.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write page-map.xml: %w", err)
+	}
+	r.hasPageMap = true
+
+	if DebugMode {
+		fmt.Printf("✅ Converted page-map: %d of %d pages mapped\n", converted, len(pageMap.Pages))
+	}
+	return nil
+}
+
+// mapHref returns the output location of an original href (relative to the OPF),
+// falling back to the chapter file when the anchor is unknown
+func (r *Restructurer) mapHref(href string) (string, bool) {
+	file := strings.SplitN(href, "#", 2)[0]
+	target := ""
+	for _, entry := range r.mapping {
+		if entry.Source == href {
+			return entry.Target, true
+		}
+		if entry.Source == file && target == "" {
+			target = entry.Target
+		}
+	}
+	return target, target != ""
+}
+
+// xmlEscape escapes a string for use in XML text or attribute values
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// buildGuide returns the regenerated EPUB2 guide: cover, toc and text point at the generated
+// files, and the remaining original references are mapped to their new locations
+func (r *Restructurer) buildGuide(book *parser.Book) []opfReference {
+	var references []opfReference
+	if book.CoverImage != "" {
+		references = append(references, opfReference{"cover", r.localize("", "cover"), "titlepage.xhtml"})
+	}
+	references = append(references, opfReference{"toc", r.localize("", "table_of_contents"), "nav.xhtml"})
+	for _, entry := range r.mapping {
+		if strings.HasPrefix(entry.Target, "chapters/") {
+			references = append(references, opfReference{"text", r.localize("", "beginning"), entry.Target})
+			break
+		}
+	}
+
+	for _, original := range book.Guide {
+		switch original.Type {
+		case "cover", "toc", "text":
+			continue
+		}
+		target, ok := r.mapHref(original.Href)
+		if !ok {
+			if DebugMode {
+				fmt.Printf("⚠️  Dropping guide reference %s: no output location for %s\n", original.Type, original.Href)
+			}
+			continue
+		}
+		references = append(references, opfReference{original.Type, original.Title, target})
+	}
+	return references
+}
@@ -0,0 +1,342 @@
+package restructure
+
+import (
+	"encoding/xml"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// FigureLists adds lists of the captioned figures and tables (nav loi and lot) to the
+// navigation document
+var FigureLists bool
+
+// figureClasses are publisher class fragments of the divs wrapping an image and its
+// caption
+var figureClasses = []string{"figure", "fig", "illus", "image", "img", "picture", "photo"}
+
+// captionClasses are publisher class fragments of captions
+var captionClasses = []string{"caption", "legend", "figtitle"}
+
+// figureLabelPattern matches the labels opening figure captions, e.g. "Figure 3:",
+// "Fig. 2." or "Plate IV"
+var figureLabelPattern = regexp.MustCompile(`(?i)^(figure|fig\.|figura|abbildung|abb\.|plate|illustration|ilustración|map|chart|diagram|hình|ảnh)\s*[0-9ivxlc]+[a-z]?\b`)
+
+// tableLabelPattern matches the labels opening table captions, e.g. "Table 2:"
+var tableLabelPattern = regexp.MustCompile(`(?i)^(table|tab\.|tableau|tabla|tabella|tabelle|tabel|tabela|bảng)\s*[0-9ivxlc]+[a-z]?\b`)
+
+// floatStylePattern matches an inline float to the left or right
+var floatStylePattern = regexp.MustCompile(`float\s*:\s*(left|right)`)
+
+// figureStyles are the theme's figure styles, added to themes that predate them
+const figureStyles = `
+/* Figures */
+figure {
+  margin: 1.5em auto;
+  text-align: center;
+}
+figure img {
+  max-width: 100%;
+}
+figcaption {
+  font-size: 0.85em;
+  font-style: italic;
+  text-indent: 0;
+  margin-top: 0.5em;
+}
+figure.float-left {
+  float: left;
+  max-width: 50%;
+  margin: 0.5em 1em 0.5em 0;
+}
+figure.float-right {
+  float: right;
+  max-width: 50%;
+  margin: 0.5em 0 0.5em 1em;
+}
+caption {
+  font-size: 0.85em;
+  font-style: italic;
+  margin-bottom: 0.5em;
+}
+`
+
+// markFigures turns the publisher's figure markup into figure elements with their
+// captions as figcaption, before the cleanup strips the classes that identify them:
+// wrappers around an image and its caption, and image paragraphs followed by a
+// caption paragraph; floats become float-left and float-right figures
+func markFigures(doc *goquery.Document) int {
+	figures := 0
+
+	// Figures already marked up only need their captions
+	doc.Find("body figure").Each(func(i int, s *goquery.Selection) {
+		if s.Children().Filter("figcaption").Length() == 0 {
+			if caption := figureCaption(s); caption != nil {
+				renameNode(caption.Nodes[0], "figcaption", atom.Figcaption)
+			}
+		}
+		markFloat(s, s)
+	})
+
+	// Wrappers around a single image
+	doc.Find("body div").Each(func(i int, s *goquery.Selection) {
+		if !hasClassFragment(s, figureClasses) || hasClassFragment(s, captionClasses) || !isFigureBlock(s) {
+			return
+		}
+		caption := figureCaption(s)
+		if caption != nil {
+			renameNode(caption.Nodes[0], "figcaption", atom.Figcaption)
+		}
+		renameNode(s.Nodes[0], "figure", atom.Figure)
+		markFloat(s, s.Find("img, svg").First())
+		unwrapImageBlocks(s)
+		figures++
+	})
+
+	// Image paragraphs followed by their caption
+	doc.Find("body p, body div").Each(func(i int, s *goquery.Selection) {
+		if s.ParentsFiltered("figure").Length() > 0 || !isImageBlock(s) {
+			return
+		}
+		next := s.Next()
+		if next.Length() == 0 || !isCaptionBlock(next) {
+			return
+		}
+		figure := &html.Node{Type: html.ElementNode, Data: "figure", DataAtom: atom.Figure}
+		markFloat(goquery.NewDocumentFromNode(figure).Selection, s.Find("img, svg").First())
+		node := s.Nodes[0]
+		node.Parent.InsertBefore(figure, node)
+		for child := node.FirstChild; child != nil; child = node.FirstChild {
+			node.RemoveChild(child)
+			figure.AppendChild(child)
+		}
+		node.Parent.RemoveChild(node)
+
+		caption := next.Nodes[0]
+		caption.Parent.RemoveChild(caption)
+		renameNode(caption, "figcaption", atom.Figcaption)
+		caption.Attr = nil
+		figure.AppendChild(caption)
+		figures++
+	})
+
+	// Tables opened or followed by their caption paragraph
+	doc.Find("body table").Each(func(i int, s *goquery.Selection) {
+		if s.Children().Filter("caption").Length() > 0 {
+			return
+		}
+		for _, block := range []*goquery.Selection{s.Prev(), s.Next()} {
+			if block.Length() == 0 || !isTableCaptionBlock(block) {
+				continue
+			}
+			caption := block.Nodes[0]
+			caption.Parent.RemoveChild(caption)
+			renameNode(caption, "caption", atom.Caption)
+			caption.Attr = nil
+			s.Nodes[0].InsertBefore(caption, s.Nodes[0].FirstChild)
+			figures++
+			return
+		}
+	})
+
+	if DebugMode && figures > 0 {
+		fmt.Printf("🖼️  Preserved %d figures and tables with their captions\n", figures)
+	}
+	return figures
+}
+
+// isTableCaptionBlock reports whether a paragraph next to a table is its caption,
+// opening with a table label
+func isTableCaptionBlock(s *goquery.Selection) bool {
+	if goquery.NodeName(s) != "p" && goquery.NodeName(s) != "div" || s.Find("img, svg, p, div, table").Length() > 0 {
+		return false
+	}
+	text := strings.TrimSpace(s.Text())
+	return text != "" && len(strings.Fields(text)) <= 80 && tableLabelPattern.MatchString(text)
+}
+
+// isFigureBlock reports whether a wrapper holds a single image and, besides its
+// caption, no text
+func isFigureBlock(s *goquery.Selection) bool {
+	if s.Find("img, svg").Length() != 1 {
+		return false
+	}
+	text := strings.TrimSpace(s.Text())
+	if caption := figureCaption(s); caption != nil {
+		text = strings.TrimSpace(strings.Replace(text, strings.TrimSpace(caption.Text()), "", 1))
+	}
+	return text == ""
+}
+
+// isImageBlock reports whether a paragraph or division holds an image and nothing
+// else
+func isImageBlock(s *goquery.Selection) bool {
+	return s.Find("img, svg").Length() == 1 && strings.TrimSpace(s.Text()) == "" &&
+		s.Find("p, div, table").Length() == 0
+}
+
+// isCaptionBlock reports whether a paragraph is a caption: by its class or by the
+// figure label it opens with
+func isCaptionBlock(s *goquery.Selection) bool {
+	if goquery.NodeName(s) != "p" && goquery.NodeName(s) != "div" || s.Find("img, svg, p, div, table").Length() > 0 {
+		return false
+	}
+	text := strings.TrimSpace(s.Text())
+	if text == "" || len(strings.Fields(text)) > 80 {
+		return false
+	}
+	return hasClassFragment(s, captionClasses) || figureLabelPattern.MatchString(text)
+}
+
+// figureCaption returns the caption of a figure: a child marked as a caption, or the
+// text block that follows its image
+func figureCaption(figure *goquery.Selection) *goquery.Selection {
+	var caption *goquery.Selection
+	figure.Find("p, div, span").EachWithBreak(func(i int, s *goquery.Selection) bool {
+		if hasClassFragment(s, captionClasses) && s.Find("img, svg").Length() == 0 {
+			caption = s
+			return false
+		}
+		return true
+	})
+	if caption != nil {
+		return caption
+	}
+	last := figure.Children().Last()
+	if last.Length() == 0 || goquery.NodeName(last) != "p" && goquery.NodeName(last) != "div" || last.Find("img, svg, p, div, table").Length() > 0 {
+		return nil
+	}
+	if text := strings.TrimSpace(last.Text()); text != "" && len(strings.Fields(text)) <= 80 {
+		return last
+	}
+	return nil
+}
+
+// markFloat classes a figure float-left or float-right when the publisher floated it
+// or its image
+func markFloat(figure, source *goquery.Selection) {
+	for _, s := range []*goquery.Selection{source, figure} {
+		style, _ := s.Attr("style")
+		align, _ := s.Attr("align")
+		side := ""
+		if match := floatStylePattern.FindStringSubmatch(strings.ToLower(style)); match != nil {
+			side = match[1]
+		} else if align == "left" || align == "right" {
+			side = align
+		} else if hasClassFragment(s, []string{"left", "right"}) && (hasClassFragment(s, figureClasses) || hasClassFragment(s, []string{"float"})) {
+			side = "left"
+			if hasClassFragment(s, []string{"right"}) {
+				side = "right"
+			}
+		}
+		if side != "" {
+			figure.AddClass("float-" + side)
+			return
+		}
+	}
+}
+
+// unwrapImageBlocks replaces the paragraphs and divisions that only hold the image
+// of a figure by the image
+func unwrapImageBlocks(figure *goquery.Selection) {
+	figure.Children().Filter("p, div").Each(func(i int, s *goquery.Selection) {
+		if isImageBlock(s) {
+			s.Contents().Unwrap()
+		}
+	})
+}
+
+// renameNode changes the element of a node, keeping its attributes and content
+func renameNode(node *html.Node, name string, a atom.Atom) {
+	node.Data, node.DataAtom = name, a
+}
+
+// numberFigures gives the captioned figures and tables of a chapter without an ID
+// one, so the lists of figures and tables can link to them
+func numberFigures(doc *goquery.Document) {
+	used := make(map[string]bool)
+	doc.Find("[id]").Each(func(i int, s *goquery.Selection) {
+		id, _ := s.Attr("id")
+		used[id] = true
+	})
+	for _, kind := range []struct{ selector, caption, prefix string }{
+		{"body figure", "figcaption", "figure"},
+		{"body table", "caption", "table"},
+	} {
+		number := 0
+		doc.Find(kind.selector).Each(func(i int, s *goquery.Selection) {
+			if _, hasID := s.Attr("id"); hasID || s.Children().Filter(kind.caption).Length() == 0 {
+				return
+			}
+			id := ""
+			for id == "" || used[id] {
+				number++
+				id = fmt.Sprintf("%s-%d", kind.prefix, number)
+			}
+			used[id] = true
+			s.SetAttr("id", id)
+		})
+	}
+}
+
+// collectFigures adds the captioned figures and tables of a written chapter to the
+// lists of figures and tables
+func (r *Restructurer) collectFigures(content, target string) {
+	if !FigureLists {
+		return
+	}
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(content))
+	if err != nil {
+		return
+	}
+	collect := func(selector, caption string, list *[]pageListEntry) {
+		doc.Find(selector).Each(func(i int, s *goquery.Selection) {
+			id, _ := s.Attr("id")
+			label := strings.Join(strings.Fields(s.Children().Filter(caption).First().Text()), " ")
+			if label != "" {
+				*list = append(*list, pageListEntry{Label: label, Href: target + "#" + id})
+			}
+		})
+	}
+	collect("figure[id]", "figcaption", &r.figureList)
+	collect("table[id]", "caption", &r.tableList)
+}
+
+// figureListNavs renders the loi and lot nav elements, leaving out empty lists
+func (r *Restructurer) figureListNavs() (string, error) {
+	var navs strings.Builder
+	for _, list := range []struct {
+		epubType, title string
+		entries         []pageListEntry
+	}{
+		{"loi", r.localize("", "list_of_figures"), r.figureList},
+		{"lot", r.localize("", "list_of_tables"), r.tableList},
+	} {
+		if len(list.entries) == 0 {
+			continue
+		}
+		nav := struct {
+			XMLName xml.Name  `xml:"nav"`
+			Type    string    `xml:"epub:type,attr"`
+			ID      string    `xml:"id,attr"`
+			Heading string    `xml:"h2"`
+			Entries []navLink `xml:"ol>li"`
+		}{Type: list.epubType, ID: list.epubType, Heading: list.title}
+		for _, entry := range list.entries {
+			nav.Entries = append(nav.Entries, newNavLink(entry.Href, entry.Label, ""))
+		}
+		fragment, err := marshalFragment(nav)
+		if err != nil {
+			return "", err
+		}
+		navs.WriteString(fragment)
+	}
+	if DebugMode && navs.Len() > 0 {
+		fmt.Printf("🖼️  Listed %d figures and %d tables\n", len(r.figureList), len(r.tableList))
+	}
+	return navs.String(), nil
+}
@@ -0,0 +1,212 @@
+package restructure
+
+import (
+	"fmt"
+	"html"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/flouciel/folian-parser/internal/parser"
+	"golang.org/x/net/html/atom"
+)
+
+// PopupFootnotes converts footnote references into EPUB3 noterefs with the note copied
+// into an aside of the referencing chapter, so that readers show it as a popup
+var PopupFootnotes bool
+
+// noteMarkerPattern matches the text of a footnote reference: a number, letter or
+// symbol, optionally bracketed
+var noteMarkerPattern = regexp.MustCompile(`^[\[(]?(\d{1,4}|[a-z]{1,2}|[*†‡§¶]{1,3})[\])]?$`)
+
+// maxNoteLength is the longest note text copied into a popup
+const maxNoteLength = 3000
+
+// loadNoteDocuments parses the original chapters so that notes in other files can be
+// copied into the chapters referencing them
+func (r *Restructurer) loadNoteDocuments(book *parser.Book) {
+	r.noteDocuments = make(map[string]*goquery.Document)
+	if !PopupFootnotes {
+		return
+	}
+
+	for _, chapter := range book.Chapters {
+		manifestItem, exists := book.Manifest[chapter.ID]
+		if !exists {
+			continue
+		}
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(chapter.Content))
+		if err != nil {
+			continue
+		}
+		r.noteDocuments[filepath.Base(manifestItem.Href)] = doc
+	}
+}
+
+// isNoteReference reports whether a link is a footnote reference rather than a
+// backlink from a note or an ordinary cross-reference
+func isNoteReference(s *goquery.Selection) bool {
+	href, _ := s.Attr("href")
+	if !strings.Contains(href, "#") || strings.Contains(href, "://") {
+		return false
+	}
+	if epubType, _ := s.Attr("epub:type"); strings.Contains(" "+epubType+" ", " noteref ") {
+		return true
+	}
+
+	marker := strings.TrimSpace(s.Text())
+	if !noteMarkerPattern.MatchString(strings.ToLower(marker)) {
+		return false
+	}
+	if s.ParentsFiltered("sup").Length() == 0 && s.Find("sup").Length() == 0 && !strings.ContainsAny(marker, "[(*†‡§¶") {
+		return false
+	}
+
+	// Notes usually open with a backlink to their reference, which is not a reference itself
+	block := s.ParentsFiltered("p, li, div, aside, dd, td").First()
+	return !strings.HasPrefix(strings.TrimSpace(block.Text()), marker)
+}
+
+// findID returns the element of a document with the given id
+func findID(doc *goquery.Document, id string) *goquery.Selection {
+	return doc.Find("[id]").FilterFunction(func(i int, s *goquery.Selection) bool {
+		value, _ := s.Attr("id")
+		return value == id
+	}).First()
+}
+
+// noteBlock returns the block holding the note an anchor id points at
+func noteBlock(doc *goquery.Document, id string) *goquery.Selection {
+	target := findID(doc, id)
+	if target.Length() == 0 || target.Is("body, section, h1, h2, h3, h4, h5, h6") {
+		return nil
+	}
+	if target.Is("a, span, sup, b, i, em, strong") {
+		target = target.ParentsFiltered("p, li, div, aside, dd").First()
+		if target.Length() == 0 {
+			return nil
+		}
+	}
+	if target.Is("div") && target.Find("h1, h2, h3, h4, h5, h6, section").Length() > 0 {
+		return nil
+	}
+	if text := strings.TrimSpace(target.Text()); text == "" || len(text) > maxNoteLength {
+		return nil
+	}
+	return target
+}
+
+// isFootnote reports whether a block is already marked as an EPUB3 note
+func isFootnote(s *goquery.Selection) bool {
+	epubType, _ := s.Attr("epub:type")
+	return strings.Contains(" "+epubType+" ", " footnote ") || strings.Contains(" "+epubType+" ", " endnote ")
+}
+
+// convertFootnotes turns the footnote references of a chapter into noterefs pointing
+// at asides in the same document: notes already in the chapter are marked in place
+// and notes in other files are copied to the end of the chapter
+func (r *Restructurer) convertFootnotes(doc *goquery.Document) {
+	if !PopupFootnotes {
+		return
+	}
+
+	body := doc.Find("body")
+	converted := 0
+	copied := make(map[string]string)
+	doc.Find("a[href]").Each(func(i int, s *goquery.Selection) {
+		if !isNoteReference(s) {
+			return
+		}
+		href, _ := s.Attr("href")
+		file, id := href[:strings.Index(href, "#")], href[strings.Index(href, "#")+1:]
+		if id == "" {
+			return
+		}
+
+		// Links into the chapter's own file are local once chapters are written
+		if file != "" {
+			if local := noteBlock(doc, id); local != nil {
+				if source, exists := r.noteDocuments[filepath.Base(file)]; exists {
+					if block := noteBlock(source, id); block != nil && block.Text() == local.Text() {
+						file = ""
+					}
+				}
+			}
+		}
+
+		var noteID string
+		if file == "" {
+			block := noteBlock(doc, id)
+			if block == nil {
+				return
+			}
+			noteID = id
+			if !isFootnote(block) && !isFootnote(block.Parent()) {
+				markFootnote(block, id)
+			}
+		} else if existing, ok := copied[href]; ok {
+			noteID = existing
+		} else {
+			source, exists := r.noteDocuments[filepath.Base(file)]
+			if !exists {
+				return
+			}
+			block := noteBlock(source, id)
+			if block == nil {
+				return
+			}
+			noteID = id
+			if findID(doc, id).Length() > 0 {
+				noteID = "fn-" + id
+			}
+			body.AppendHtml(footnoteAside(block, noteID))
+			copied[href] = noteID
+		}
+
+		s.SetAttr("href", "#"+noteID)
+		s.SetAttr("epub:type", "noteref")
+		s.SetAttr("role", "doc-noteref")
+		converted++
+	})
+
+	if DebugMode && converted > 0 {
+		fmt.Printf("📝 Converted %d footnote references to popups\n", converted)
+	}
+}
+
+// markFootnote marks a note in the chapter as an EPUB3 footnote: list items become
+// endnotes in place, other blocks are wrapped in an aside that takes over their id
+func markFootnote(block *goquery.Selection, id string) {
+	if block.Is("li") {
+		block.SetAttr("epub:type", "endnote")
+		block.SetAttr("role", "doc-endnote")
+		return
+	}
+	if block.Is("aside") {
+		block.SetAttr("epub:type", "footnote")
+		block.SetAttr("role", "doc-footnote")
+		return
+	}
+	block.Find("[id]").AddSelection(block).FilterFunction(func(i int, s *goquery.Selection) bool {
+		value, _ := s.Attr("id")
+		return value == id
+	}).RemoveAttr("id")
+	block.WrapHtml(fmt.Sprintf(`<aside id="%s" epub:type="footnote" role="doc-footnote" class="footnote"></aside>`, html.EscapeString(id)))
+}
+
+// footnoteAside renders a copy of a note from another file as a footnote aside
+func footnoteAside(block *goquery.Selection, id string) string {
+	note := block.Clone()
+	note.Find("[id]").RemoveAttr("id")
+	note.RemoveAttr("id")
+	if note.Is("li, dd, aside") {
+		note.Nodes[0].Data = "p"
+		note.Nodes[0].DataAtom = atom.P
+	}
+	content, err := goquery.OuterHtml(note)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf(`<aside id="%s" epub:type="footnote" role="doc-footnote" class="footnote">%s</aside>`, html.EscapeString(id), content)
+}
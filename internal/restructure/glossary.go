@@ -0,0 +1,250 @@
+package restructure
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/flouciel/folian-parser/internal/parser"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// glossaryTitlePattern matches the titles of glossaries
+var glossaryTitlePattern = regexp.MustCompile(`(?i)^\s*(glossary(\s+of\s+terms)?|glossaire|glosario|glossário|glossar|glossario|woordenlijst|(bảng\s+)?thuật\s+ngữ|глоссарий|术语表|用語集|용어집)\s*$`)
+
+// glossaryTypePattern matches the EPUB3 and ARIA semantics of a glossary
+var glossaryTypePattern = regexp.MustCompile(`(?:epub:type="[^"]*\bglossary\b[^"]*"|role="doc-glossary")`)
+
+// glossaryTermClasses and glossaryDefinitionClasses are publisher class fragments of
+// the paragraphs holding a term and its definition
+var (
+	glossaryTermClasses       = []string{"glossterm", "term"}
+	glossaryDefinitionClasses = []string{"glossdef", "definition", "def"}
+)
+
+// glossarySeparators are the characters separating a term from its definition
+const glossarySeparators = "  :.—–-"
+
+// isGlossaryChapter reports whether a chapter is a glossary
+func isGlossaryChapter(title, content string) bool {
+	return glossaryTitlePattern.MatchString(title) || glossaryTypePattern.MatchString(content)
+}
+
+// collectGlossaryTargets records the glossary terms the book's text links to, so that
+// the cleanup keeps their anchors and the links are marked as glossary references
+func (r *Restructurer) collectGlossaryTargets(book *parser.Book) {
+	r.glossaryTerms = r.keepLinkedAnchors(book, isGlossaryChapter)
+	if DebugMode && len(r.glossaryTerms) > 0 {
+		fmt.Printf("📖 Keeping %d linked glossary terms\n", len(r.glossaryTerms))
+	}
+}
+
+// markGlossaryRefs marks the links to glossary terms as glossary references
+func (r *Restructurer) markGlossaryRefs(doc *goquery.Document) {
+	if len(r.glossaryTerms) == 0 {
+		return
+	}
+	doc.Find("a[href*='#']").Each(func(i int, s *goquery.Selection) {
+		href, _ := s.Attr("href")
+		if r.glossaryTerms[href[strings.Index(href, "#")+1:]] && !strings.Contains(href, "://") {
+			addEpubType(s, "glossref")
+		}
+	})
+}
+
+// markGlossary removes the glossary's own title heading, which the chapter heading
+// replaces, turns the term paragraphs into a definition list, wraps the glossary in
+// a glossary section and gives the terms IDs to link to; letter headings are kept
+func markGlossary(doc *goquery.Document, title string) {
+	doc.Find("h1, h2, h3, h4, h5, h6").Each(func(i int, s *goquery.Selection) {
+		if glossaryTitlePattern.MatchString(s.Text()) {
+			s.Remove()
+		}
+	})
+	removeTitleHeading(doc, title)
+
+	terms := convertGlossaryParagraphs(doc)
+	if doc.Find(`[role="doc-glossary"]`).Length() == 0 {
+		doc.Find("body").WrapInnerHtml(`<section epub:type="glossary" role="doc-glossary"></section>`)
+	}
+
+	doc.Find(`[role="doc-glossary"] dl`).Each(func(i int, s *goquery.Selection) {
+		addEpubType(s, "glossary")
+		s.Children().Filter("dt").Each(func(i int, s *goquery.Selection) {
+			addEpubType(s, "glossterm")
+		})
+		s.Children().Filter("dd").Each(func(i int, s *goquery.Selection) {
+			addEpubType(s, "glossdef")
+		})
+	})
+
+	// Number the terms without an anchor
+	used := make(map[string]bool)
+	doc.Find("[id]").Each(func(i int, s *goquery.Selection) {
+		id, _ := s.Attr("id")
+		used[id] = true
+	})
+	number := 0
+	doc.Find(`[role="doc-glossary"] dt`).Each(func(i int, s *goquery.Selection) {
+		if _, hasID := s.Attr("id"); hasID {
+			return
+		}
+		id := ""
+		for id == "" || used[id] {
+			number++
+			id = fmt.Sprintf("term-%d", number)
+		}
+		used[id] = true
+		s.SetAttr("id", id)
+	})
+
+	if DebugMode && terms > 0 {
+		fmt.Printf("📖 Converted %d glossary terms to a definition list\n", terms)
+	}
+}
+
+// convertGlossaryParagraphs turns the runs of paragraphs opening with a bold term,
+// and of term and definition paragraphs marked by their classes, into definition
+// lists, returning the number of terms
+func convertGlossaryParagraphs(doc *goquery.Document) int {
+	terms := 0
+	doc.Find("body p").Each(func(i int, s *goquery.Selection) {
+		if s.Nodes[0].Parent == nil || s.ParentsFiltered("dl").Length() > 0 || !isGlossaryEntry(s) || isGlossaryEntry(s.Prev()) {
+			return
+		}
+
+		// The run starts here; collect its entries
+		var run []*goquery.Selection
+		for entry := s; entry.Length() > 0 && isGlossaryEntry(entry); {
+			run = append(run, entry)
+			next := entry.Next()
+			if hasClassFragment(entry, glossaryTermClasses) && next.Length() > 0 && hasClassFragment(next, glossaryDefinitionClasses) {
+				run = append(run, next)
+				next = next.Next()
+			}
+			entry = next
+		}
+
+		dl := &html.Node{Type: html.ElementNode, Data: "dl", DataAtom: atom.Dl}
+		first := s.Nodes[0]
+		first.Parent.InsertBefore(dl, first)
+		for _, entry := range run {
+			node := entry.Nodes[0]
+			node.Parent.RemoveChild(node)
+			switch {
+			case hasClassFragment(entry, glossaryDefinitionClasses):
+				renameNode(node, "dd", atom.Dd)
+				node.Attr = nil
+				dl.AppendChild(node)
+			case hasClassFragment(entry, glossaryTermClasses):
+				id, hasID := entry.Attr("id")
+				renameNode(node, "dt", atom.Dt)
+				node.Attr = nil
+				if hasID {
+					node.Attr = []html.Attribute{{Key: "id", Val: id}}
+				}
+				dl.AppendChild(node)
+				terms++
+			default:
+				dl.AppendChild(splitGlossaryEntry(entry))
+				dl.AppendChild(node)
+				terms++
+			}
+		}
+	})
+	return terms
+}
+
+// isGlossaryEntry reports whether a paragraph is a glossary term, marked by its class
+// or opening with a short bold term followed by its definition
+func isGlossaryEntry(s *goquery.Selection) bool {
+	if s.Length() == 0 || goquery.NodeName(s) != "p" {
+		return false
+	}
+	if hasClassFragment(s, glossaryTermClasses) {
+		return true
+	}
+	term := glossaryTerm(s.Nodes[0])
+	if term == nil {
+		return false
+	}
+	termText := goquery.NewDocumentFromNode(term).Text()
+	rest := strings.TrimSpace(strings.TrimPrefix(s.Text(), termText))
+	return len(strings.Fields(termText)) <= 8 && strings.Trim(rest, glossarySeparators) != ""
+}
+
+// glossaryTerm returns the bold element a paragraph opens with, or nil
+func glossaryTerm(p *html.Node) *html.Node {
+	for child := p.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == html.TextNode && strings.TrimSpace(child.Data) == "" {
+			continue
+		}
+		if child.Type == html.ElementNode && (child.DataAtom == atom.B || child.DataAtom == atom.Strong || child.DataAtom == atom.Dfn) {
+			return child
+		}
+		return nil
+	}
+	return nil
+}
+
+// splitGlossaryEntry moves the bold term of a glossary paragraph into a dt, which it
+// returns, and turns the paragraph into the dd holding the definition
+func splitGlossaryEntry(entry *goquery.Selection) *html.Node {
+	node := entry.Nodes[0]
+	dt := &html.Node{Type: html.ElementNode, Data: "dt", DataAtom: atom.Dt}
+	if id, hasID := entry.Attr("id"); hasID {
+		dt.Attr = []html.Attribute{{Key: "id", Val: id}}
+	}
+	term := glossaryTerm(node)
+	for child := term.FirstChild; child != nil; child = term.FirstChild {
+		term.RemoveChild(child)
+		dt.AppendChild(child)
+	}
+	node.RemoveChild(term)
+
+	// Drop the separator the definition opens with
+	for child := node.FirstChild; child != nil; child = node.FirstChild {
+		if child.Type != html.TextNode {
+			break
+		}
+		child.Data = strings.TrimLeft(child.Data, glossarySeparators)
+		if child.Data != "" {
+			break
+		}
+		node.RemoveChild(child)
+	}
+	renameNode(node, "dd", atom.Dd)
+	node.Attr = nil
+	return dt
+}
+
+// markIndexEntries marks the lists of an index and their entries and locators with
+// the EPUB index vocabulary
+func markIndexEntries(doc *goquery.Document) {
+	index := doc.Find(`[role="doc-index"]`)
+	if index.Find(`[epub\:type~="index-entry"]`).Length() > 0 {
+		return
+	}
+	index.Find("ul, ol").Each(func(i int, s *goquery.Selection) {
+		addEpubType(s, "index-entry-list")
+	})
+	index.Find("li").Each(func(i int, s *goquery.Selection) {
+		addEpubType(s, "index-entry")
+	})
+	index.Find("li a[href]").Each(func(i int, s *goquery.Selection) {
+		addEpubType(s, "index-locator")
+	})
+}
+
+// addEpubType adds a semantic to the epub:type of an element
+func addEpubType(s *goquery.Selection, value string) {
+	types := strings.Fields(s.AttrOr("epub:type", ""))
+	for _, t := range types {
+		if t == value {
+			return
+		}
+	}
+	s.SetAttr("epub:type", strings.Join(append(types, value), " "))
+}
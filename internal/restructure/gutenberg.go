@@ -0,0 +1,363 @@
+package restructure
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/flouciel/folian-parser/internal/parser"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// gutenbergLicenseTitle titles the back matter that holds the Project Gutenberg
+// header and license
+const gutenbergLicenseTitle = "The Project Gutenberg License"
+
+// gutenbergStyles sets the small capitals and transcriber's notes of Project
+// Gutenberg books
+const gutenbergStyles = `
+/* Project Gutenberg */
+span.small-caps {
+  font-variant: small-caps;
+}
+div.transcriber-note {
+  border: 1px solid;
+  padding: 0.5em 1em;
+}
+div.transcriber-note p {
+  text-indent: 0;
+}
+`
+
+// gutenbergStartPattern and gutenbergEndPattern match the separators around the
+// text of a Project Gutenberg book
+var (
+	gutenbergStartPattern = regexp.MustCompile(`(?i)\*{3}\s*START OF (THE|THIS) PROJECT GUTENBERG E-?BOOK`)
+	gutenbergEndPattern   = regexp.MustCompile(`(?i)\*{3}\s*END OF (THE|THIS) PROJECT GUTENBERG E-?BOOK|^\s*End of (the )?Project Gutenberg(’s|'s)?\b`)
+)
+
+// gutenbergSeparatorPattern matches a whole separator line, left out of the back
+// matter
+var gutenbergSeparatorPattern = regexp.MustCompile(`(?i)\*{3}\s*(START|END) OF (THE|THIS) PROJECT GUTENBERG E-?BOOK[^*]*\*{3}`)
+
+// gutenbergBoilerplate selects the header and footer sections of the books made by
+// Project Gutenberg's ebookmaker
+const gutenbergBoilerplate = "#pg-header, #pg-machine-header, #pg-footer, .pg-boilerplate"
+
+// gutenbergClasses map the classes of Project Gutenberg's HTML conventions to the
+// theme's: indentation, alignment and small capitals
+var gutenbergClasses = map[string]string{
+	"nind": "nonindent", "noind": "nonindent", "noindent": "nonindent", "nindent": "nonindent", "pnind": "nonindent", "hang": "nonindent",
+	"center": "center", "c": "center", "pc": "center", "tac": "center", "centered": "center",
+	"right": "right", "pr": "right", "tar": "right", "signature": "right", "sig": "right",
+	"smcap": "small-caps", "smcaps": "small-caps", "sc": "small-caps", "smallcaps": "small-caps", "caps": "small-caps",
+}
+
+// gutenbergNoteClasses are the classes and IDs of transcriber's notes
+var gutenbergNoteClasses = map[string]bool{
+	"transnote": true, "transnotes": true, "tnote": true, "tnotes": true, "trnote": true, "tn": true,
+	"transcriber": true, "transcribers-note": true, "transcribersnote": true, "trans-note": true, "tnbox": true,
+}
+
+// gutenbergRulePattern matches the classes of the decorative rules between chapters,
+// which the chapter files replace
+var gutenbergRulePattern = regexp.MustCompile(`^(chap\d*|full|r\d+|pb|page)$`)
+
+// isGutenberg reports whether a book comes from Project Gutenberg, by its metadata
+// or the opening of its text
+func isGutenberg(book *parser.Book) bool {
+	for _, value := range []string{book.Metadata.Publisher, book.Metadata.Identifier} {
+		if strings.Contains(strings.ToLower(value), "gutenberg") {
+			return true
+		}
+	}
+	return len(book.Chapters) > 0 && gutenbergStartPattern.MatchString(book.Chapters[0].Content)
+}
+
+// prepareGutenberg applies the gutenberg profile: the header and license are moved
+// to the back matter and the markup is mapped to the theme
+func (r *Restructurer) prepareGutenberg(book *parser.Book, oebpsPath string) error {
+	var headers, footers []string
+	var kept []parser.Chapter
+	licenseID := ""
+	notes := 0
+	for _, chapter := range book.Chapters {
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(chapter.Content))
+		if err != nil {
+			kept = append(kept, chapter)
+			continue
+		}
+		header, footer := extractGutenbergBoilerplate(doc)
+		headers = append(headers, header...)
+		footers = append(footers, footer...)
+		notes += mapGutenbergMarkup(doc)
+
+		// Files that only held boilerplate give their place to the back matter
+		body := doc.Find("body")
+		if (len(header) > 0 || len(footer) > 0) && strings.TrimSpace(body.Text()) == "" && body.Find("img, svg").Length() == 0 {
+			if licenseID == "" {
+				licenseID = chapter.ID
+			}
+			continue
+		}
+		if content, err := doc.Html(); err == nil {
+			chapter.Content = content
+		}
+		// A chapter titled by the header takes the title of its first heading
+		if strings.Contains(chapter.Title, "Project Gutenberg") {
+			if heading := strings.TrimSpace(body.Find("h1, h2, h3").First().Text()); heading != "" {
+				chapter.Title = strings.Join(strings.Fields(heading), " ")
+			}
+		}
+		kept = append(kept, chapter)
+	}
+
+	boilerplate := append(headers, footers...)
+	if len(boilerplate) > 0 {
+		if licenseID == "" {
+			licenseID = "pg-license"
+		}
+		content := gutenbergSeparatorPattern.ReplaceAllString(strings.Join(boilerplate, "\n"), "")
+		kept = append(kept, parser.Chapter{
+			ID:      licenseID,
+			Title:   gutenbergLicenseTitle,
+			Content: fmt.Sprintf("<html><head><title>%s</title></head><body>\n%s\n</body></html>", gutenbergLicenseTitle, content),
+			Order:   len(kept) + 1,
+		})
+	}
+	book.Chapters = kept
+
+	stylesheet := filepath.Join(oebpsPath, "styles", "stylesheet.css")
+	if css, err := ioutil.ReadFile(stylesheet); err == nil && !strings.Contains(string(css), "span.small-caps") {
+		if err := ioutil.WriteFile(stylesheet, append(css, gutenbergStyles...), 0644); err != nil {
+			return fmt.Errorf("failed to update stylesheet: %w", err)
+		}
+	}
+	if DebugMode {
+		fmt.Printf("📜 Project Gutenberg: moved %d boilerplate blocks to the back matter, marked %d transcriber's notes\n", len(boilerplate), notes)
+	}
+	return nil
+}
+
+// extractGutenbergBoilerplate removes the header and footer of a Project Gutenberg
+// chapter and returns them as HTML: ebookmaker's boilerplate sections, or else
+// whatever precedes the start separator and follows the end separator
+func extractGutenbergBoilerplate(doc *goquery.Document) (header, footer []string) {
+	doc.Find("#pg-start-separator, #pg-end-separator").Remove()
+	doc.Find(gutenbergBoilerplate).Each(func(i int, s *goquery.Selection) {
+		if s.ParentsFiltered(gutenbergBoilerplate).Length() > 0 {
+			return
+		}
+		block, _ := goquery.OuterHtml(s)
+		id, _ := s.Attr("id")
+		if strings.Contains(id, "footer") || gutenbergEndPattern.MatchString(s.Text()) {
+			footer = append(footer, block)
+		} else {
+			header = append(header, block)
+		}
+		s.Remove()
+	})
+
+	body := doc.Find("body")
+	if body.Length() == 0 {
+		return header, footer
+	}
+	if marker := innermostMatch(body, gutenbergStartPattern); marker != nil {
+		header = append(header, detachAround(body.Nodes[0], marker, true)...)
+	}
+	if marker := innermostMatch(body, gutenbergEndPattern); marker != nil {
+		footer = append(detachAround(body.Nodes[0], marker, false), footer...)
+	}
+	return header, footer
+}
+
+// innermostMatch returns the first element under root whose text matches a
+// separator pattern while none of its child elements' does
+func innermostMatch(root *goquery.Selection, pattern *regexp.Regexp) *html.Node {
+	var match *html.Node
+	root.Find("*").EachWithBreak(func(i int, s *goquery.Selection) bool {
+		if !pattern.MatchString(s.Text()) {
+			return true
+		}
+		inner := false
+		s.Children().EachWithBreak(func(j int, child *goquery.Selection) bool {
+			inner = pattern.MatchString(child.Text())
+			return !inner
+		})
+		if !inner {
+			match = s.Nodes[0]
+			return false
+		}
+		return true
+	})
+	return match
+}
+
+// detachAround removes a separator with everything before it (or after it) up to
+// root, and returns the removed nodes as HTML in document order
+func detachAround(root, marker *html.Node, before bool) []string {
+	var levels [][]*html.Node
+	levels = append(levels, []*html.Node{marker})
+	for node := marker; node != root && node.Parent != nil; node = node.Parent {
+		var siblings []*html.Node
+		if before {
+			for sibling := node.Parent.FirstChild; sibling != node; sibling = sibling.NextSibling {
+				siblings = append(siblings, sibling)
+			}
+		} else {
+			for sibling := node.NextSibling; sibling != nil; sibling = sibling.NextSibling {
+				siblings = append(siblings, sibling)
+			}
+		}
+		if node.Parent == root {
+			levels = append(levels, siblings)
+			break
+		}
+		levels = append(levels, siblings)
+	}
+
+	// Before the separator, the outer levels come first; after it, the inner ones
+	var ordered []*html.Node
+	if before {
+		for i := len(levels) - 1; i >= 1; i-- {
+			ordered = append(ordered, levels[i]...)
+		}
+		ordered = append(ordered, marker)
+	} else {
+		for _, level := range levels {
+			ordered = append(ordered, level...)
+		}
+	}
+
+	var blocks []string
+	for _, node := range ordered {
+		if node.Type == html.TextNode && strings.TrimSpace(node.Data) == "" {
+			node.Parent.RemoveChild(node)
+			continue
+		}
+		var buf bytes.Buffer
+		if err := html.Render(&buf, node); err == nil {
+			blocks = append(blocks, buf.String())
+		}
+		node.Parent.RemoveChild(node)
+	}
+	return blocks
+}
+
+// mapGutenbergMarkup maps Project Gutenberg's markup conventions to the theme: page
+// numbers become page breaks, transcriber's notes boxes, corrections spans, figures
+// figure elements and its classes the theme's; it returns the transcriber's notes
+func mapGutenbergMarkup(doc *goquery.Document) int {
+	// Content ebookmaker keeps out of e-books, and the rules between chapters
+	doc.Find(".x-ebookmaker-drop").Remove()
+	doc.Find("hr[class]").Each(func(i int, s *goquery.Selection) {
+		for _, class := range strings.Fields(s.AttrOr("class", "")) {
+			switch {
+			case class == "tb":
+				s.SetAttr("class", "scene-break")
+				return
+			case gutenbergRulePattern.MatchString(class):
+				s.Remove()
+				return
+			}
+		}
+	})
+
+	// Page numbers in the margin become page breaks, keeping the anchors that
+	// indexes link to
+	doc.Find(".pagenum, .pageno").Each(func(i int, s *goquery.Selection) {
+		label := strings.Trim(strings.TrimSpace(s.Text()), "[]{}")
+		label = strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(label, "Pg"), "p."))
+		id := s.AttrOr("id", "")
+		if id == "" {
+			id = s.Find("[id]").First().AttrOr("id", "")
+		}
+		if id == "" || label == "" {
+			s.Remove()
+			return
+		}
+		s.ReplaceWithHtml(fmt.Sprintf(`<span epub:type="pagebreak" role="doc-pagebreak" id="%s" title="%s"></span>`,
+			html.EscapeString(id), html.EscapeString(label)))
+	})
+
+	// Transcriber's notes are boxed, with their headings as bold paragraphs since
+	// a chapter keeps a single heading
+	notes := 0
+	doc.Find("body [class], body [id]").Each(func(i int, s *goquery.Selection) {
+		if !gutenbergNoteClasses[strings.ToLower(s.AttrOr("id", ""))] && !hasNoteClass(s) {
+			return
+		}
+		if s.ParentsFiltered(".transcriber-note").Length() > 0 {
+			return
+		}
+		s.Find("h1, h2, h3, h4, h5, h6").Each(func(j int, heading *goquery.Selection) {
+			content, _ := heading.Html()
+			heading.ReplaceWithHtml(`<p class="nonindent"><strong>` + content + `</strong></p>`)
+		})
+		if goquery.NodeName(s) == "div" {
+			s.SetAttr("class", "box transcriber-note")
+			s.SetAttr("role", "note")
+		} else {
+			s.RemoveAttr("class")
+			s.WrapHtml(`<div class="box transcriber-note" role="note"></div>`)
+		}
+		notes++
+	})
+
+	// Corrections keep the original reading in their title
+	doc.Find("ins.corr, span.corr, ins.correction, span.correction").Each(func(i int, s *goquery.Selection) {
+		s.Nodes[0].Data, s.Nodes[0].DataAtom = "span", atom.Span
+		s.SetAttr("class", "correction")
+	})
+
+	// Block quotations and figures
+	doc.Find("div.blockquot, div.bq").Each(func(i int, s *goquery.Selection) {
+		s.Nodes[0].Data, s.Nodes[0].DataAtom = "blockquote", atom.Blockquote
+	})
+	doc.Find("div.figcenter, div.figleft, div.figright, div.figure, div.fig").Each(func(i int, s *goquery.Selection) {
+		if s.Find("img").Length() == 0 {
+			return
+		}
+		s.Nodes[0].Data, s.Nodes[0].DataAtom = "figure", atom.Figure
+		s.Find(".caption").First().Each(func(j int, caption *goquery.Selection) {
+			caption.Nodes[0].Data, caption.Nodes[0].DataAtom = "figcaption", atom.Figcaption
+		})
+	})
+
+	// Alignment, indentation and small capitals; aligned divisions pass their
+	// alignment to their paragraphs
+	doc.Find("body [class]").Each(func(i int, s *goquery.Selection) {
+		for _, class := range strings.Fields(s.AttrOr("class", "")) {
+			theme, ok := gutenbergClasses[strings.ToLower(class)]
+			if !ok {
+				continue
+			}
+			switch {
+			case theme == "small-caps":
+				if goquery.NodeName(s) == "span" {
+					s.AddClass(theme)
+				}
+			case goquery.NodeName(s) == "p":
+				s.AddClass(theme)
+			case goquery.NodeName(s) == "div" && theme != "nonindent":
+				s.ChildrenFiltered("p").AddClass(theme)
+			}
+		}
+	})
+	return notes
+}
+
+// hasNoteClass reports whether an element has a transcriber's note class
+func hasNoteClass(s *goquery.Selection) bool {
+	for _, class := range strings.Fields(s.AttrOr("class", "")) {
+		if gutenbergNoteClasses[strings.ToLower(class)] {
+			return true
+		}
+	}
+	return false
+}
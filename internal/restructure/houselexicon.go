@@ -0,0 +1,227 @@
+package restructure
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/flouciel/folian-parser/internal/policy"
+)
+
+// HouseLexicon is a JSON house-style lexicon of banned words, preferred spellings and
+// trademark capitalizations the chapter text is checked against
+var HouseLexicon string
+
+// HouseLexiconFix replaces the variants of preferred spellings and miscapitalized
+// trademarks in the text instead of only reporting them
+var HouseLexiconFix bool
+
+// HouseLexiconReport is the JSON file listing the lexicon violations found
+var HouseLexiconReport string
+
+// Lexicon rules
+const (
+	lexiconBanned    = "banned"
+	lexiconPreferred = "preferred"
+	lexiconTrademark = "trademark"
+)
+
+// LexiconRules are the rules of a house-style lexicon
+type LexiconRules struct {
+	// Banned are words and phrases that must not appear in the text
+	Banned []string `json:"banned"`
+	// Preferred maps variant spellings to the spelling the house prefers
+	Preferred map[string]string `json:"preferred"`
+	// Trademarks are names in the capitalization they must be written in
+	Trademarks []string `json:"trademarks"`
+}
+
+// LexiconEntry is a lexicon violation in the lexicon report
+type LexiconEntry struct {
+	Chapter string `json:"chapter"`
+	Line    int    `json:"line"`
+	Rule    string `json:"rule"`
+	Text    string `json:"text"`
+	// Suggestion is the preferred spelling or the trademark, "" for a banned word
+	Suggestion string `json:"suggestion,omitempty"`
+	Fixed      bool   `json:"fixed,omitempty"`
+}
+
+// lexiconTerm is a term of the lexicon with its rule and what replaces it
+type lexiconTerm struct {
+	rule        string
+	replacement string
+}
+
+// loadHouseLexicon reads HouseLexicon and compiles its terms into one
+// case-insensitive pattern, longest first so that phrases win over their words
+func (r *Restructurer) loadHouseLexicon() error {
+	r.lexiconTerms = nil
+	r.lexiconPattern = nil
+	r.lexiconEntries = nil
+	if HouseLexicon == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(HouseLexicon)
+	if err != nil {
+		return fmt.Errorf("failed to read lexicon: %w", err)
+	}
+	var lexicon LexiconRules
+	if err := json.Unmarshal(data, &lexicon); err != nil {
+		return fmt.Errorf("failed to parse lexicon %s: %w", HouseLexicon, err)
+	}
+
+	r.lexiconTerms = make(map[string]lexiconTerm)
+	add := func(term string, entry lexiconTerm) {
+		term = strings.Join(strings.Fields(term), " ")
+		if term != "" {
+			r.lexiconTerms[strings.ToLower(term)] = entry
+		}
+	}
+	for _, word := range lexicon.Banned {
+		add(word, lexiconTerm{rule: lexiconBanned})
+	}
+	for variant, preferred := range lexicon.Preferred {
+		add(variant, lexiconTerm{rule: lexiconPreferred, replacement: preferred})
+	}
+	for _, trademark := range lexicon.Trademarks {
+		add(trademark, lexiconTerm{rule: lexiconTrademark, replacement: strings.Join(strings.Fields(trademark), " ")})
+	}
+	if len(r.lexiconTerms) == 0 {
+		return nil
+	}
+
+	terms := make([]string, 0, len(r.lexiconTerms))
+	for term := range r.lexiconTerms {
+		terms = append(terms, term)
+	}
+	sort.Slice(terms, func(i, j int) bool {
+		if len(terms[i]) != len(terms[j]) {
+			return len(terms[i]) > len(terms[j])
+		}
+		return terms[i] < terms[j]
+	})
+	for i, term := range terms {
+		// Spaces in a phrase match any run of white space, as lines break in it
+		terms[i] = strings.Replace(regexp.QuoteMeta(term), " ", `\s+`, -1)
+	}
+	r.lexiconPattern, err = regexp.Compile(`(?i)` + strings.Join(terms, "|"))
+	if err != nil {
+		return fmt.Errorf("failed to compile lexicon %s: %w", HouseLexicon, err)
+	}
+	if DebugMode {
+		fmt.Printf("📖 House-style lexicon: %d banned, %d preferred, %d trademarks\n", len(lexicon.Banned), len(lexicon.Preferred), len(lexicon.Trademarks))
+	}
+	return nil
+}
+
+// checkHouseLexicon records the lexicon violations in the prose of a written chapter
+// and, with HouseLexiconFix, replaces variant spellings and miscapitalized trademarks
+func (r *Restructurer) checkHouseLexicon(content, target, lang string) string {
+	if r.lexiconPattern == nil {
+		return content
+	}
+	return rewriteProse(content, lang, func(text, _ string, line int) string {
+		return r.checkHouseLexiconText(text, target, line)
+	})
+}
+
+// checkHouseLexiconText checks a run of text starting on a line against the lexicon
+func (r *Restructurer) checkHouseLexiconText(text, target string, line int) string {
+	var b strings.Builder
+	last := 0
+	for _, match := range r.lexiconPattern.FindAllStringIndex(text, -1) {
+		found := text[match[0]:match[1]]
+		if !wordBoundary(text, match[0], match[1]) || strings.Contains(found, "&") {
+			continue
+		}
+		key := strings.ToLower(strings.Join(strings.Fields(found), " "))
+		term, ok := r.lexiconTerms[key]
+		if !ok {
+			continue
+		}
+
+		entry := LexiconEntry{Chapter: target, Line: line + strings.Count(text[:match[0]], "\n"), Rule: term.rule, Text: found}
+		replacement := found
+		switch term.rule {
+		case lexiconBanned:
+			policy.Warn(policy.KindLexicon, "Banned word %q in %s, line %d", found, target, entry.Line)
+		case lexiconPreferred:
+			entry.Suggestion = matchCase(found, term.replacement)
+			if found == entry.Suggestion {
+				continue
+			}
+			replacement = entry.Suggestion
+		case lexiconTrademark:
+			if found == term.replacement {
+				continue
+			}
+			entry.Suggestion = term.replacement
+			replacement = term.replacement
+		}
+		if HouseLexiconFix && replacement != found {
+			entry.Fixed = true
+			b.WriteString(text[last:match[0]])
+			b.WriteString(replacement)
+			last = match[1]
+		}
+		r.lexiconEntries = append(r.lexiconEntries, entry)
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+// wordBoundary reports whether text[start:end] is neither preceded nor followed by a
+// letter or a digit, so that a term is not matched inside a longer word
+func wordBoundary(text string, start, end int) bool {
+	isWord := func(c rune) bool {
+		return unicode.IsLetter(c) || unicode.IsDigit(c) || unicode.IsMark(c)
+	}
+	if before, _ := utf8.DecodeLastRuneInString(text[:start]); start > 0 && isWord(before) {
+		return false
+	}
+	if after, _ := utf8.DecodeRuneInString(text[end:]); end < len(text) && isWord(after) {
+		return false
+	}
+	return true
+}
+
+// writeHouseLexiconReport prints the lexicon violations by rule and writes them to
+// HouseLexiconReport
+func (r *Restructurer) writeHouseLexiconReport() error {
+	if r.lexiconPattern == nil {
+		return nil
+	}
+	counts := make(map[string]int)
+	fixed := 0
+	for _, entry := range r.lexiconEntries {
+		counts[entry.Rule]++
+		if entry.Fixed {
+			fixed++
+		}
+	}
+	fmt.Printf("ℹ️  House-style lexicon: %d banned words, %d variant spellings, %d miscapitalized trademarks (%d fixed)\n",
+		counts[lexiconBanned], counts[lexiconPreferred], counts[lexiconTrademark], fixed)
+	if HouseLexiconReport == "" {
+		return nil
+	}
+
+	entries := r.lexiconEntries
+	if entries == nil {
+		entries = []LexiconEntry{}
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode lexicon report: %w", err)
+	}
+	if err := ioutil.WriteFile(HouseLexiconReport, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write lexicon report: %w", err)
+	}
+	fmt.Printf("ℹ️  Listed %d lexicon violations in %s\n", len(entries), HouseLexiconReport)
+	return nil
+}
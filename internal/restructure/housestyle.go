@@ -0,0 +1,67 @@
+package restructure
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TextAlign aligns the body text "justify" or "left", overriding the theme; empty
+// keeps the theme's alignment
+var TextAlign string
+
+// Hyphenate turns on automatic hyphenation of the body text
+var Hyphenate bool
+
+// WidowsOrphans is the least number of lines of a paragraph left at the top or
+// bottom of a page (0 keeps the theme's)
+var WidowsOrphans int
+
+// ParagraphStyle separates paragraphs by an "indent" of their first line or by
+// "spaced" blank space between them, overriding the theme; empty keeps the theme's
+var ParagraphStyle string
+
+// TextAligns and ParagraphStyles are the values of TextAlign and ParagraphStyle
+var (
+	TextAligns      = []string{"justify", "left"}
+	ParagraphStyles = []string{"indent", "spaced"}
+)
+
+// houseStyles returns the paragraph rules generated from the house style options,
+// appended to the theme so they override its own, or "" when none is set
+func houseStyles() string {
+	var declarations []string
+	switch TextAlign {
+	case "justify":
+		declarations = append(declarations, "text-align: justify;")
+	case "left":
+		declarations = append(declarations, "text-align: left;")
+	}
+	if Hyphenate {
+		declarations = append(declarations, "-webkit-hyphens: auto;", "-epub-hyphens: auto;", "hyphens: auto;")
+	}
+	if WidowsOrphans > 0 {
+		declarations = append(declarations, fmt.Sprintf("widows: %d;", WidowsOrphans), fmt.Sprintf("orphans: %d;", WidowsOrphans))
+	}
+	switch ParagraphStyle {
+	case "indent":
+		declarations = append(declarations, "text-indent: 1.5em;", "margin-top: 0;", "margin-bottom: 0;")
+	case "spaced":
+		declarations = append(declarations, "text-indent: 0;", "margin-top: 0;", "margin-bottom: 1em;")
+	}
+	if len(declarations) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString("\n/* House style */\np {\n")
+	for _, declaration := range declarations {
+		fmt.Fprintf(&b, "  %s\n", declaration)
+	}
+	b.WriteString("}\n")
+
+	// Indented paragraphs after breaks and headings are set flush
+	if ParagraphStyle == "indent" {
+		b.WriteString("h1 + p, h2 + p, h3 + p, p.first-para, p.nonindent {\n  text-indent: 0;\n}\n")
+	}
+	return b.String()
+}
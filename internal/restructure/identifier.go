@@ -0,0 +1,166 @@
+package restructure
+
+import (
+	"crypto/rand"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/flouciel/folian-parser/internal/parser"
+)
+
+// NewIdentifier forces a new UUID identifier for derivative editions; the original
+// identifier is kept as dc:source
+var NewIdentifier bool
+
+// uuidPattern matches a canonical UUID
+var uuidPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// Identifier kinds recognized by classifyIdentifier
+const (
+	identifierISBN = "isbn"
+	identifierUUID = "uuid"
+)
+
+// resolveIdentifier chooses the unique identifier of the output book: the original
+// unique identifier normalized to its scheme, else any ISBN or UUID identifier, else
+// a newly generated UUID
+func (r *Restructurer) resolveIdentifier(book *parser.Book) string {
+	r.sourceIdentifier = ""
+	if NewIdentifier {
+		r.sourceIdentifier = book.Metadata.Identifier
+		return newUUID()
+	}
+
+	if strings.TrimSpace(book.Metadata.Identifier) != "" {
+		_, normalized := classifyIdentifier(book.Metadata.Identifier, identifierScheme(book, book.Metadata.Identifier))
+		return normalized
+	}
+
+	for _, kind := range []string{identifierISBN, identifierUUID} {
+		for _, identifier := range book.Metadata.Identifiers {
+			if found, normalized := classifyIdentifier(identifier.Value, identifier.Scheme); found == kind {
+				return normalized
+			}
+		}
+	}
+
+	return newUUID()
+}
+
+// buildIdentifierMetadata renders the identifier type of the unique identifier and
+// the book's other identifiers with their schemes preserved
+func (r *Restructurer) buildIdentifierMetadata(book *parser.Book) []metaElement {
+	var meta []metaElement
+
+	if kind, _ := classifyIdentifier(book.Metadata.Identifier, ""); kind == identifierISBN {
+		meta = append(meta, isbnTypeMeta("BookID", book.Metadata.Identifier))
+	}
+
+	seen := map[string]bool{book.Metadata.Identifier: true}
+	count := 0
+	for _, identifier := range book.Metadata.Identifiers {
+		kind, normalized := classifyIdentifier(identifier.Value, identifier.Scheme)
+		if seen[normalized] || normalized == r.sourceIdentifier {
+			continue
+		}
+		seen[normalized] = true
+		count++
+
+		id := fmt.Sprintf("identifier%d", count)
+		meta = append(meta, newMeta("dc:identifier", normalized, "id", id))
+		if kind == identifierISBN {
+			meta = append(meta, isbnTypeMeta(id, normalized))
+		}
+	}
+
+	if r.sourceIdentifier != "" {
+		meta = append(meta, newMeta("dc:source", r.sourceIdentifier))
+	}
+
+	return meta
+}
+
+// isbnTypeMeta renders the ONIX identifier type refinement of an ISBN identifier
+func isbnTypeMeta(id, isbn string) metaElement {
+	code := "15" // ISBN-13
+	if len(strings.TrimPrefix(isbn, "urn:isbn:")) == 10 {
+		code = "02" // ISBN-10
+	}
+	return newMeta("meta", code, "refines", "#"+id, "property", "identifier-type", "scheme", "onix:codelist5")
+}
+
+// identifierScheme returns the declared scheme of one of the book's identifiers
+func identifierScheme(book *parser.Book, value string) string {
+	for _, identifier := range book.Metadata.Identifiers {
+		if identifier.Value == value {
+			return identifier.Scheme
+		}
+	}
+	return ""
+}
+
+// classifyIdentifier recognizes ISBN and UUID identifiers and returns them as URNs;
+// other identifiers are returned unchanged
+func classifyIdentifier(value, scheme string) (string, string) {
+	value = strings.TrimSpace(value)
+	lower := strings.ToLower(value)
+	scheme = strings.ToLower(scheme)
+
+	isbn := strings.TrimPrefix(strings.TrimPrefix(lower, "urn:isbn:"), "isbn:")
+	isbn = strings.NewReplacer("-", "", " ", "").Replace(isbn)
+	declared := scheme == "isbn" || isbn != strings.NewReplacer("-", "", " ", "").Replace(lower)
+	if validISBN(isbn) && (declared || len(isbn) == 10 || strings.HasPrefix(isbn, "978") || strings.HasPrefix(isbn, "979")) {
+		return identifierISBN, "urn:isbn:" + strings.ToUpper(isbn)
+	}
+
+	uuid := strings.TrimPrefix(lower, "urn:uuid:")
+	if uuidPattern.MatchString(uuid) {
+		return identifierUUID, "urn:uuid:" + uuid
+	}
+
+	return "", value
+}
+
+// validISBN checks the length and check digit of an ISBN-10 or ISBN-13
+func validISBN(isbn string) bool {
+	switch len(isbn) {
+	case 10:
+		sum := 0
+		for i, c := range isbn {
+			digit := int(c - '0')
+			if i == 9 && (c == 'x' || c == 'X') {
+				digit = 10
+			} else if c < '0' || c > '9' {
+				return false
+			}
+			sum += digit * (10 - i)
+		}
+		return sum%11 == 0
+	case 13:
+		sum := 0
+		for i, c := range isbn {
+			if c < '0' || c > '9' {
+				return false
+			}
+			weight := 1
+			if i%2 == 1 {
+				weight = 3
+			}
+			sum += int(c-'0') * weight
+		}
+		return sum%10 == 0
+	}
+	return false
+}
+
+// newUUID generates a random (version 4) UUID URN
+func newUUID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("failed to generate UUID: %v", err))
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("urn:uuid:%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
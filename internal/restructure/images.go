@@ -0,0 +1,70 @@
+package restructure
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// CompatibilityProfile targets older readers by transcoding image formats they
+// cannot render (WEBP, AVIF) to JPEG or PNG
+var CompatibilityProfile bool
+
+// imageReferencePattern matches src and href attributes that may reference an image
+var imageReferencePattern = regexp.MustCompile(`((?:src|href)=")([^"]+)(")`)
+
+// needsTranscoding reports whether an image format is unsupported by older readers
+func needsTranscoding(ext string) bool {
+	switch strings.ToLower(ext) {
+	case ".webp", ".avif":
+		return true
+	}
+	return false
+}
+
+// transcodeImage converts a WEBP or AVIF image to PNG when it may carry transparency
+// (lossless or extended WEBP) and to JPEG otherwise, returning the new extension
+func transcodeImage(content []byte, ext string) ([]byte, string, error) {
+	target := ".jpg"
+	if strings.EqualFold(ext, ".webp") && len(content) >= 16 && string(content[12:16]) != "VP8 " {
+		target = ".png"
+	}
+
+	converted, err := convertImage(content, strings.ToLower(ext), target)
+	if err != nil {
+		return nil, "", err
+	}
+	return converted, target, nil
+}
+
+// outputImageName returns the filename an image is written under in the images
+// directory, following any transcoding
+func (r *Restructurer) outputImageName(imagePath string) string {
+	filename := path.Base(imagePath)
+	if renamed, ok := r.imageRenames[filename]; ok {
+		return renamed
+	}
+	return filename
+}
+
+// renameImageReferences points src and href attributes at transcoded images
+func (r *Restructurer) renameImageReferences(content string) string {
+	if len(r.imageRenames) == 0 {
+		return content
+	}
+
+	return imageReferencePattern.ReplaceAllStringFunc(content, func(match string) string {
+		parts := imageReferencePattern.FindStringSubmatch(match)
+		dir, filename := path.Split(parts[2])
+		renamed, ok := r.imageRenames[decodeHref(filename)]
+		if !ok {
+			return match
+		}
+		renamed = escapeHref(renamed)
+		if DebugMode {
+			fmt.Printf("🖼️  Updated image reference: %s -> %s%s\n", parts[2], dir, renamed)
+		}
+		return parts[1] + dir + renamed + parts[3]
+	})
+}
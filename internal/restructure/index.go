@@ -0,0 +1,64 @@
+package restructure
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/flouciel/folian-parser/internal/parser"
+)
+
+// indexTitlePattern matches the titles of back-of-book indexes
+var indexTitlePattern = regexp.MustCompile(`(?i)^\s*((general|subject|name|author)\s+)?index(\s+of\s+.+)?\s*$`)
+
+// indexTypePattern matches the EPUB3 and ARIA semantics of an index
+var indexTypePattern = regexp.MustCompile(`(?:epub:type="[^"]*\bindex\b[^"]*"|role="doc-index")`)
+
+// isIndexChapter reports whether a chapter is a back-of-book index, whose dense link
+// lists must be kept rather than treated as navigation
+func isIndexChapter(title, content string) bool {
+	return indexTitlePattern.MatchString(title) || indexTypePattern.MatchString(content)
+}
+
+// collectIndexTargets records the anchors the book's indexes link to, so that the
+// cleanup keeps them even when they look like publisher-generated IDs
+func (r *Restructurer) collectIndexTargets(book *parser.Book) {
+	r.keptAnchors = make(map[string]bool)
+	for _, chapter := range book.Chapters {
+		if !isIndexChapter(chapter.Title, chapter.Content) {
+			continue
+		}
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(chapter.Content))
+		if err != nil {
+			continue
+		}
+		doc.Find("a[href]").Each(func(i int, s *goquery.Selection) {
+			href, _ := s.Attr("href")
+			if i := strings.Index(href, "#"); i >= 0 && !strings.Contains(href, "://") {
+				r.keptAnchors[href[i+1:]] = true
+			}
+		})
+
+		if DebugMode {
+			fmt.Printf("📇 Keeping index %q with %d link targets\n", chapter.Title, len(r.keptAnchors))
+		}
+	}
+}
+
+// keepIndexHeadings removes the index's own title heading, which the chapter heading
+// replaces, and wraps the entries in an index section with the index vocabulary;
+// the letter group headings are kept
+func keepIndexHeadings(doc *goquery.Document, title string) {
+	doc.Find("h1, h2, h3, h4, h5, h6").Each(func(i int, s *goquery.Selection) {
+		if indexTitlePattern.MatchString(s.Text()) {
+			s.Remove()
+		}
+	})
+	removeTitleHeading(doc, title)
+
+	if doc.Find(`[role="doc-index"]`).Length() == 0 {
+		doc.Find("body").WrapInnerHtml(`<section epub:type="index" role="doc-index"></section>`)
+	}
+	markIndexEntries(doc)
+}
@@ -0,0 +1,137 @@
+package restructure
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/flouciel/folian-parser/internal/lookup"
+	"github.com/flouciel/folian-parser/internal/parser"
+	"github.com/flouciel/folian-parser/internal/policy"
+)
+
+// JacketTextFile is a JSON file with the description, author bio and series blurb
+// shown on the jacket page
+var JacketTextFile string
+
+// JacketLookup looks the jacket text up on OpenLibrary/Google Books by ISBN
+var JacketLookup bool
+
+// jacketText is the "about the book" text of the jacket page
+type jacketText struct {
+	Description string `json:"description"`
+	AuthorBio   string `json:"author_bio"`
+	SeriesBlurb string `json:"series_blurb"`
+}
+
+// Patterns used to turn descriptions into plain text paragraphs
+var (
+	htmlTagPattern      = regexp.MustCompile(`<[^>]*>`)
+	paragraphEndPattern = regexp.MustCompile(`(?i)</p>|<br\s*/?>`)
+	blankLinePattern    = regexp.MustCompile(`\n\s*\n`)
+)
+
+// loadJacketText gathers the jacket text from the local file, then fills the missing
+// parts online; the book's description is used when neither provides one
+func loadJacketText(book *parser.Book) jacketText {
+	var text jacketText
+	if JacketTextFile != "" {
+		data, err := ioutil.ReadFile(JacketTextFile)
+		if err == nil {
+			err = json.Unmarshal(data, &text)
+		}
+		if err != nil {
+			policy.Warn(policy.KindOther, "Failed to read jacket text from %s: %v", JacketTextFile, err)
+		}
+	}
+
+	if JacketLookup && (text.Description == "" || text.AuthorBio == "") {
+		if isbn := bookISBN(book); isbn == "" {
+			policy.Warn(policy.KindOther, "Cannot look up jacket text: the book has no ISBN")
+		} else if record, err := lookup.ByISBN(isbn); err != nil {
+			policy.Warn(policy.KindOther, "%v", err)
+		} else {
+			if text.Description == "" {
+				text.Description = record.Description
+			}
+			if text.AuthorBio == "" {
+				text.AuthorBio = record.AuthorBio
+			}
+			if DebugMode {
+				fmt.Printf("🌐 Jacket text from %s\n", record.Source)
+			}
+		}
+	}
+
+	if text.Description == "" && text != (jacketText{}) {
+		text.Description = book.Metadata.Description
+	}
+	return text
+}
+
+// bookISBN returns the first ISBN among the book's identifiers
+func bookISBN(book *parser.Book) string {
+	candidates := []string{book.Metadata.Identifier}
+	for _, identifier := range book.Metadata.Identifiers {
+		candidates = append(candidates, identifier.Value)
+	}
+	for _, candidate := range candidates {
+		if kind, normalized := classifyIdentifier(candidate, ""); kind == identifierISBN {
+			return strings.TrimPrefix(normalized, "urn:isbn:")
+		}
+	}
+	return ""
+}
+
+// jacketParagraphs renders text, possibly containing HTML, as escaped paragraphs
+func jacketParagraphs(text, class string) string {
+	text = paragraphEndPattern.ReplaceAllString(text, "\n\n")
+	text = html.UnescapeString(htmlTagPattern.ReplaceAllString(text, ""))
+
+	var paragraphs strings.Builder
+	for _, paragraph := range blankLinePattern.Split(text, -1) {
+		if paragraph = strings.Join(strings.Fields(paragraph), " "); paragraph != "" {
+			fmt.Fprintf(&paragraphs, "      <p class=\"%s\">%s</p>\n", class, html.EscapeString(paragraph))
+		}
+	}
+	return paragraphs.String()
+}
+
+// render returns the jacket text section with its headings, or an empty string
+// without text
+func (t jacketText) render(aboutAuthor, aboutSeries string) string {
+	if t == (jacketText{}) {
+		return ""
+	}
+
+	var section strings.Builder
+	section.WriteString("<div class=\"jacket-text\">\n")
+	section.WriteString(jacketParagraphs(t.Description, "description"))
+	if bio := jacketParagraphs(t.AuthorBio, "author-bio"); bio != "" {
+		section.WriteString("      <h2>" + html.EscapeString(aboutAuthor) + "</h2>\n" + bio)
+	}
+	if blurb := jacketParagraphs(t.SeriesBlurb, "series-blurb"); blurb != "" {
+		section.WriteString("      <h2>" + html.EscapeString(aboutSeries) + "</h2>\n" + blurb)
+	}
+	section.WriteString("    </div>")
+	return section.String()
+}
+
+// applyJacketText fills the {{JACKET_TEXT}} placeholder with a rendered section,
+// adding it before </body> when the template has none, and lets the jacket grow
+// beyond one screen
+func applyJacketText(jacket, section string) string {
+	if section == "" {
+		return strings.Replace(jacket, "{{JACKET_TEXT}}", "", -1)
+	}
+
+	if !strings.Contains(jacket, "{{JACKET_TEXT}}") {
+		jacket = strings.Replace(jacket, "</body>", "{{JACKET_TEXT}}\n</body>", 1)
+	}
+	jacket = strings.Replace(jacket, "{{JACKET_TEXT}}", section, -1)
+	jacket = strings.Replace(jacket, "<html ", `<html class="with-jacket-text" `, 1)
+	return strings.Replace(jacket, "<body>", `<body class="with-jacket-text">`, 1)
+}
@@ -0,0 +1,104 @@
+package restructure
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html/atom"
+)
+
+// poetryClassPattern matches publisher classes used for verse and its stanzas
+var poetryClassPattern = regexp.MustCompile(`(?i)(^|[-_])(poem|poetry|verse|stanza)`)
+
+// blockquoteClasses are publisher class fragments of divs that set a block quotation
+var blockquoteClasses = []string{"blockquote", "block-quote", "quotation", "extract", "epigraph"}
+
+// layoutStyles are the inline style properties kept by the cleanup, keyed by the
+// structure they lay out
+var layoutStyles = map[string]map[string]bool{
+	"table": {"text-align": true, "vertical-align": true, "width": true, "white-space": true},
+	"verse": {"margin-left": true, "padding-left": true, "text-indent": true, "text-align": true},
+	"pre":   {"white-space": true},
+	"img":   {"width": true, "height": true},
+}
+
+// preserveLayout normalizes the publisher's verse and block quotation markup to the
+// .poem/.stanza classes and blockquote elements styled by the theme, before the
+// cleanup strips the publisher's classes and styles
+func preserveLayout(doc *goquery.Document) {
+	doc.Find("body div").Each(func(i int, s *goquery.Selection) {
+		if hasClassFragment(s, blockquoteClasses) {
+			s.Nodes[0].Data, s.Nodes[0].DataAtom = "blockquote", atom.Blockquote
+		}
+	})
+
+	poems := 0
+	doc.Find("body [class]").Each(func(i int, s *goquery.Selection) {
+		class, _ := s.Attr("class")
+		match := false
+		for _, cls := range strings.Fields(class) {
+			if poetryClassPattern.MatchString(cls) {
+				match = true
+				break
+			}
+		}
+		if !match {
+			return
+		}
+
+		switch {
+		case strings.Contains(strings.ToLower(class), "stanza"):
+			s.AddClass("stanza")
+		case goquery.NodeName(s) == "p" && s.ParentsFiltered(".poem").Length() == 0:
+			s.AddClass("poem")
+		case (goquery.NodeName(s) == "div" || goquery.NodeName(s) == "blockquote") && s.ParentsFiltered(".poem").Length() == 0:
+			s.AddClass("poem")
+			poems++
+		}
+	})
+
+	if DebugMode && poems > 0 {
+		fmt.Printf("📜 Preserved the layout of %d poems\n", poems)
+	}
+}
+
+// inPreservedLayout reports whether an element is part of verse or preformatted text,
+// whose empty spans and line structure carry meaning
+func inPreservedLayout(s *goquery.Selection) bool {
+	return s.Is(".poem, .stanza, pre") || s.ParentsFiltered(".poem, .stanza, pre").Length() > 0
+}
+
+// preservedStyle returns the layout declarations of an element's inline style that
+// the cleanup keeps: the responsive sizes of images, cell alignment and widths in
+// tables, indentation in verse and whitespace handling in preformatted text
+func preservedStyle(s *goquery.Selection) string {
+	style, ok := s.Attr("style")
+	if !ok {
+		return ""
+	}
+
+	var allowed map[string]bool
+	switch {
+	case s.Is("img"):
+		allowed = layoutStyles["img"]
+	case s.Is("table, tr, td, th, col, colgroup"):
+		allowed = layoutStyles["table"]
+	case s.Is("pre") || s.ParentsFiltered("pre").Length() > 0:
+		allowed = layoutStyles["pre"]
+	case inPreservedLayout(s):
+		allowed = layoutStyles["verse"]
+	default:
+		return ""
+	}
+
+	var kept []string
+	for _, declaration := range strings.Split(style, ";") {
+		normalized := normalizeDeclaration(declaration)
+		if normalized != "" && allowed[strings.SplitN(normalized, ":", 2)[0]] {
+			kept = append(kept, normalized)
+		}
+	}
+	return strings.Join(kept, "; ")
+}
@@ -0,0 +1,293 @@
+package restructure
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/flouciel/folian-parser/internal/langtag"
+	"github.com/flouciel/folian-parser/internal/parser"
+	"github.com/flouciel/folian-parser/internal/policy"
+)
+
+// UILanguage is the language of the generated labels, overriding the book's language
+var UILanguage string
+
+// StringsFile is a JSON file of custom string tables by language, overriding the
+// bundled translations
+var StringsFile string
+
+// fallbackLanguage is the language of the labels of books in a language without
+// translations
+const fallbackLanguage = "en"
+
+// stringTables are the bundled translations of the generated labels by language;
+// {{NUMBER}} in the chapter label is the chapter number and {{PURCHASER}} in the
+// watermark line the purchaser
+var stringTables = map[string]map[string]string{
+	"vi": {
+		"chapter":           "Chương {{NUMBER}}",
+		"cover":             "Bìa",
+		"title_page":        "Trang tên sách",
+		"table_of_contents": "Mục lục",
+		"list_of_figures":   "Danh mục hình",
+		"list_of_tables":    "Danh mục bảng",
+		"beginning":         "Bắt đầu",
+		"subtitle":          "Sách Folian",
+		"book_title":        "Tên sách",
+		"author":            "Tác giả",
+		"about_author":      "Về tác giả",
+		"about_series":      "Về bộ sách",
+		"licensed_to":       "Bản sách này được cấp phép cho {{PURCHASER}}",
+	},
+	"en": {
+		"chapter":           "Chapter {{NUMBER}}",
+		"cover":             "Cover",
+		"title_page":        "Title Page",
+		"table_of_contents": "Table of Contents",
+		"list_of_figures":   "List of Figures",
+		"list_of_tables":    "List of Tables",
+		"beginning":         "Beginning",
+		"subtitle":          "A Folian Book",
+		"book_title":        "Book Title",
+		"author":            "Author",
+		"about_author":      "About the Author",
+		"about_series":      "About the Series",
+		"licensed_to":       "Licensed to {{PURCHASER}}",
+	},
+	"fr": {
+		"chapter":           "Chapitre {{NUMBER}}",
+		"cover":             "Couverture",
+		"title_page":        "Page de titre",
+		"table_of_contents": "Table des matières",
+		"list_of_figures":   "Table des figures",
+		"list_of_tables":    "Liste des tableaux",
+		"beginning":         "Début",
+		"subtitle":          "Un livre Folian",
+		"book_title":        "Titre du livre",
+		"author":            "Auteur",
+		"about_author":      "À propos de l'auteur",
+		"about_series":      "À propos de la série",
+		"licensed_to":       "Exemplaire concédé à {{PURCHASER}}",
+	},
+	"de": {
+		"chapter":           "Kapitel {{NUMBER}}",
+		"cover":             "Umschlag",
+		"title_page":        "Titelseite",
+		"table_of_contents": "Inhaltsverzeichnis",
+		"list_of_figures":   "Abbildungsverzeichnis",
+		"list_of_tables":    "Tabellenverzeichnis",
+		"beginning":         "Anfang",
+		"subtitle":          "Ein Folian-Buch",
+		"book_title":        "Buchtitel",
+		"author":            "Autor",
+		"about_author":      "Über den Autor",
+		"about_series":      "Über die Reihe",
+		"licensed_to":       "Lizenziert für {{PURCHASER}}",
+	},
+	"es": {
+		"chapter":           "Capítulo {{NUMBER}}",
+		"cover":             "Cubierta",
+		"title_page":        "Portada",
+		"table_of_contents": "Índice",
+		"list_of_figures":   "Índice de figuras",
+		"list_of_tables":    "Índice de tablas",
+		"beginning":         "Inicio",
+		"subtitle":          "Un libro Folian",
+		"book_title":        "Título del libro",
+		"author":            "Autor",
+		"about_author":      "Sobre el autor",
+		"about_series":      "Sobre la serie",
+		"licensed_to":       "Licenciado a {{PURCHASER}}",
+	},
+	"pt": {
+		"chapter":           "Capítulo {{NUMBER}}",
+		"cover":             "Capa",
+		"title_page":        "Folha de rosto",
+		"table_of_contents": "Sumário",
+		"list_of_figures":   "Lista de figuras",
+		"list_of_tables":    "Lista de tabelas",
+		"beginning":         "Início",
+		"subtitle":          "Um livro Folian",
+		"book_title":        "Título do livro",
+		"author":            "Autor",
+		"about_author":      "Sobre o autor",
+		"about_series":      "Sobre a série",
+		"licensed_to":       "Licenciado para {{PURCHASER}}",
+	},
+	"it": {
+		"chapter":           "Capitolo {{NUMBER}}",
+		"cover":             "Copertina",
+		"title_page":        "Frontespizio",
+		"table_of_contents": "Indice",
+		"list_of_figures":   "Indice delle figure",
+		"list_of_tables":    "Indice delle tabelle",
+		"beginning":         "Inizio",
+		"subtitle":          "Un libro Folian",
+		"book_title":        "Titolo del libro",
+		"author":            "Autore",
+		"about_author":      "L'autore",
+		"about_series":      "La serie",
+		"licensed_to":       "Concesso in licenza a {{PURCHASER}}",
+	},
+	"nl": {
+		"chapter":           "Hoofdstuk {{NUMBER}}",
+		"cover":             "Omslag",
+		"title_page":        "Titelpagina",
+		"table_of_contents": "Inhoudsopgave",
+		"list_of_figures":   "Lijst van figuren",
+		"list_of_tables":    "Lijst van tabellen",
+		"beginning":         "Begin",
+		"subtitle":          "Een Folian-boek",
+		"book_title":        "Boektitel",
+		"author":            "Auteur",
+		"about_author":      "Over de auteur",
+		"about_series":      "Over de reeks",
+		"licensed_to":       "In licentie gegeven aan {{PURCHASER}}",
+	},
+	"ru": {
+		"chapter":           "Глава {{NUMBER}}",
+		"cover":             "Обложка",
+		"title_page":        "Титульный лист",
+		"table_of_contents": "Оглавление",
+		"list_of_figures":   "Список иллюстраций",
+		"list_of_tables":    "Список таблиц",
+		"beginning":         "Начало",
+		"subtitle":          "Книга Folian",
+		"book_title":        "Название книги",
+		"author":            "Автор",
+		"about_author":      "Об авторе",
+		"about_series":      "О серии",
+		"licensed_to":       "Лицензировано для {{PURCHASER}}",
+	},
+	"zh": {
+		"chapter":           "第{{NUMBER}}章",
+		"cover":             "封面",
+		"title_page":        "书名页",
+		"table_of_contents": "目录",
+		"list_of_figures":   "插图目录",
+		"list_of_tables":    "表格目录",
+		"beginning":         "开始",
+		"subtitle":          "Folian 图书",
+		"book_title":        "书名",
+		"author":            "作者",
+		"about_author":      "关于作者",
+		"about_series":      "关于本系列",
+		"licensed_to":       "授权给 {{PURCHASER}}",
+	},
+	"ja": {
+		"chapter":           "第{{NUMBER}}章",
+		"cover":             "表紙",
+		"title_page":        "扉",
+		"table_of_contents": "目次",
+		"list_of_figures":   "図目次",
+		"list_of_tables":    "表目次",
+		"beginning":         "本文",
+		"subtitle":          "Folian の本",
+		"book_title":        "書名",
+		"author":            "著者",
+		"about_author":      "著者について",
+		"about_series":      "シリーズについて",
+		"licensed_to":       "{{PURCHASER}} にライセンス",
+	},
+	"ko": {
+		"chapter":           "제{{NUMBER}}장",
+		"cover":             "표지",
+		"title_page":        "속표지",
+		"table_of_contents": "목차",
+		"list_of_figures":   "그림 목차",
+		"list_of_tables":    "표 목차",
+		"beginning":         "본문",
+		"subtitle":          "Folian 도서",
+		"book_title":        "책 제목",
+		"author":            "저자",
+		"about_author":      "저자 소개",
+		"about_series":      "시리즈 소개",
+		"licensed_to":       "{{PURCHASER}} 님에게 사용 허가됨",
+	},
+}
+
+// loadStringTables reads the custom string tables of StringsFile, keyed by language
+// then by label, e.g. {"vi": {"cover": "Ảnh bìa"}}
+func loadStringTables() (map[string]map[string]string, error) {
+	if StringsFile == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(StringsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read strings file: %w", err)
+	}
+
+	var tables map[string]map[string]string
+	if err := json.Unmarshal(data, &tables); err != nil {
+		return nil, fmt.Errorf("failed to parse strings file %s: %w", StringsFile, err)
+	}
+
+	custom := make(map[string]map[string]string)
+	for lang, table := range tables {
+		for key, value := range table {
+			if _, known := stringTables[fallbackLanguage][key]; !known {
+				policy.Warn(policy.KindOther, "Unknown label %q in %s", key, StringsFile)
+				continue
+			}
+			lang = strings.ToLower(lang)
+			if custom[lang] == nil {
+				custom[lang] = make(map[string]string)
+			}
+			custom[lang][key] = value
+		}
+	}
+	return custom, nil
+}
+
+// setupLocalization chooses the language of the generated labels and loads the
+// custom string tables
+func (r *Restructurer) setupLocalization(book *parser.Book) error {
+	r.uiLanguage = UILanguage
+	if r.uiLanguage == "" {
+		r.uiLanguage = book.Metadata.Language
+	}
+
+	custom, err := loadStringTables()
+	if err != nil {
+		return err
+	}
+	r.customStrings = custom
+
+	if DebugMode {
+		fmt.Printf("🌐 Label language: %s\n", r.labelTable(r.uiLanguage))
+	}
+	return nil
+}
+
+// labelTable returns the language whose translations are used for a language tag:
+// the full tag or its primary subtag when translated, the fallback language otherwise
+func (r *Restructurer) labelTable(lang string) string {
+	lang = strings.ToLower(strings.TrimSpace(lang))
+	for _, candidate := range []string{lang, langtag.Primary(lang)} {
+		if _, ok := r.customStrings[candidate]; ok {
+			return candidate
+		}
+		if _, ok := stringTables[candidate]; ok {
+			return candidate
+		}
+	}
+	return fallbackLanguage
+}
+
+// localize returns a generated label in a language, or in the book's label language
+// when lang is empty
+func (r *Restructurer) localize(lang, key string) string {
+	if lang == "" {
+		lang = r.uiLanguage
+	}
+	table := r.labelTable(lang)
+	if value, ok := r.customStrings[table][key]; ok {
+		return value
+	}
+	if value, ok := stringTables[table][key]; ok {
+		return value
+	}
+	return stringTables[fallbackLanguage][key]
+}
@@ -0,0 +1,89 @@
+package restructure
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/flouciel/folian-parser/internal/parser"
+)
+
+// SeriesName overrides the series parsed from the input EPUB
+var SeriesName string
+
+// SeriesIndex overrides the position of the book in its series
+var SeriesIndex string
+
+// buildExtraMetadata returns the optional OPF metadata entries that follow the core
+// Dublin Core fields
+func (r *Restructurer) buildExtraMetadata(book *parser.Book) []metaElement {
+	var meta []metaElement
+
+	meta = append(meta, r.buildIdentifierMetadata(book)...)
+	meta = append(meta, buildDateMetadata(book)...)
+
+	if SeriesName != "" {
+		book.Metadata.Series = SeriesName
+	}
+	if SeriesIndex != "" {
+		book.Metadata.SeriesIndex = SeriesIndex
+	}
+	if series := strings.TrimSpace(book.Metadata.Series); series != "" {
+		index := normalizeSeriesIndex(book.Metadata.SeriesIndex)
+
+		// EPUB3 collection metadata
+		meta = append(meta,
+			newMeta("meta", series, "property", "belongs-to-collection", "id", "series"),
+			newMeta("meta", "series", "refines", "#series", "property", "collection-type"))
+		if index != "" {
+			meta = append(meta, newMeta("meta", index, "refines", "#series", "property", "group-position"))
+		}
+
+		// Calibre fallback for reading systems without EPUB3 collection support
+		meta = append(meta, newMeta("meta", "", "name", "calibre:series", "content", series))
+		if index != "" {
+			meta = append(meta, newMeta("meta", "", "name", "calibre:series_index", "content", index))
+		}
+	}
+
+	meta = append(meta, buildContributorMetadata(book)...)
+	meta = append(meta, buildSubjectMetadata(book)...)
+
+	if DictionaryMode {
+		meta = append(meta, newMeta("dc:type", "dictionary"))
+	}
+	if PeriodicalKind != "" {
+		meta = append(meta, newMeta("dc:type", PeriodicalKind))
+	}
+
+	meta = append(meta, buildWatermarkMetadata(book)...)
+
+	return meta
+}
+
+// buildContributorMetadata credits the other creators and the contributors of the
+// book with their MARC relator roles; writers and artists are creators, such as
+// the penciller of a comic, and the others contributors
+func buildContributorMetadata(book *parser.Book) []metaElement {
+	var meta []metaElement
+	for i, contributor := range book.Metadata.Contributors {
+		name := "dc:contributor"
+		if contributor.Role == "aut" || contributor.Role == "art" {
+			name = "dc:creator"
+		}
+		id := fmt.Sprintf("contributor%d", i+1)
+		meta = append(meta, newMeta(name, contributor.Name, "id", id))
+		if contributor.Role != "" {
+			meta = append(meta, newMeta("meta", contributor.Role, "refines", "#"+id, "property", "role", "scheme", "marc:relators"))
+		}
+	}
+	return meta
+}
+
+// normalizeSeriesIndex trims calibre-style fractional zeroes ("2.0" → "2")
+func normalizeSeriesIndex(index string) string {
+	index = strings.TrimSpace(index)
+	if strings.Contains(index, ".") {
+		index = strings.TrimRight(strings.TrimRight(index, "0"), ".")
+	}
+	return index
+}
@@ -0,0 +1,177 @@
+package restructure
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/flouciel/folian-parser/internal/lookup"
+	"github.com/flouciel/folian-parser/internal/parser"
+	"github.com/flouciel/folian-parser/internal/policy"
+)
+
+// FetchMetadata fills missing publisher, date, description, subjects and cover from
+// OpenLibrary/Google Books, looking the book up by ISBN or by title and author
+var FetchMetadata bool
+
+// FetchMetadataPolicy chooses between title/author search results: "ask" prompts for
+// the match, "best" takes the closest one without asking
+var FetchMetadataPolicy = "ask"
+
+// minMatchScore is the lowest title/author similarity accepted as a best match
+const minMatchScore = 0.6
+
+// maxCandidates is the number of search results offered when asking for the match
+const maxCandidates = 5
+
+// fetchMetadata fills the metadata the book lacks from an online record; fields the
+// book already has are never replaced
+func (r *Restructurer) fetchMetadata(book *parser.Book, basePath string) {
+	if !FetchMetadata {
+		return
+	}
+	if book.Metadata.Publisher != "" && book.Metadata.Date != "" && book.Metadata.Description != "" &&
+		len(book.Metadata.Subjects) > 0 && book.CoverImage != "" {
+		return
+	}
+
+	record, err := findMetadataRecord(book)
+	if err != nil {
+		policy.Warn(policy.KindOther, "Failed to fetch metadata: %v", err)
+		return
+	}
+	if record == nil {
+		return
+	}
+
+	var filled []string
+	if book.Metadata.Publisher == "" && record.Publisher != "" {
+		book.Metadata.Publisher = record.Publisher
+		filled = append(filled, "publisher")
+	}
+	if book.Metadata.Date == "" {
+		if date, ok := normalizeDate(record.Date); ok {
+			book.Metadata.Date = date
+			book.Metadata.Dates = append(book.Metadata.Dates, parser.DateEvent{Event: "publication", Value: date})
+			filled = append(filled, "date")
+		}
+	}
+	if book.Metadata.Description == "" && record.Description != "" {
+		book.Metadata.Description = record.Description
+		filled = append(filled, "description")
+	}
+	if len(book.Metadata.Subjects) == 0 && len(record.Subjects) > 0 {
+		book.Metadata.Subjects = record.Subjects
+		filled = append(filled, "subjects")
+	}
+	if book.CoverImage == "" && record.CoverURL != "" {
+		if coverFile, err := downloadCover(record.CoverURL, basePath); err != nil {
+			policy.Warn(policy.KindOther, "Failed to fetch cover: %v", err)
+		} else {
+			book.CoverImage = coverFile
+			filled = append(filled, "cover")
+		}
+	}
+
+	if len(filled) == 0 {
+		fmt.Printf("ℹ️  %s has no metadata missing from the book\n", record.Source)
+		return
+	}
+	fmt.Printf("🌐 Filled %s from %s\n", strings.Join(filled, ", "), record.Source)
+}
+
+// findMetadataRecord looks the book up by ISBN, falling back to a title and author
+// search whose result is chosen by the match policy; it returns nil when no match
+// is chosen
+func findMetadataRecord(book *parser.Book) (*lookup.Record, error) {
+	if isbn := bookISBN(book); isbn != "" {
+		record, err := lookup.ByISBN(isbn)
+		if err == nil {
+			return record, nil
+		}
+		if DebugMode {
+			fmt.Printf("⚠️  ISBN lookup failed, searching by title: %v\n", err)
+		}
+	}
+
+	title, author := book.Metadata.Title, book.Metadata.Creator
+	candidates, err := lookup.Search(title, author)
+	if err != nil {
+		return nil, err
+	}
+	if len(candidates) == 0 {
+		policy.Warn(policy.KindOther, "No metadata found for %q", title)
+		return nil, nil
+	}
+
+	var record *lookup.Record
+	if FetchMetadataPolicy == "best" {
+		if score := lookup.Score(candidates[0], title, author); score < minMatchScore {
+			policy.Warn(policy.KindOther, "No close metadata match for %q (best: %q, %.0f%%)", title, candidates[0].Title, score*100)
+			return nil, nil
+		}
+		record = candidates[0]
+	} else if record, err = chooseCandidate(candidates, title, author); err != nil || record == nil {
+		return nil, err
+	}
+
+	lookup.Complete(record)
+	return record, nil
+}
+
+// chooseCandidate lists the search results and asks which one describes the book
+func chooseCandidate(candidates []*lookup.Record, title, author string) (*lookup.Record, error) {
+	if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return nil, fmt.Errorf("cannot ask for the match without a terminal (use -meta-policy best)")
+	}
+	if len(candidates) > maxCandidates {
+		candidates = candidates[:maxCandidates]
+	}
+
+	fmt.Printf("Metadata matches for %q by %s:\n", title, author)
+	for i, candidate := range candidates {
+		fmt.Printf("  %d. %s by %s", i+1, candidate.Title, strings.Join(candidate.Authors, ", "))
+		if candidate.Publisher != "" || candidate.Date != "" {
+			fmt.Printf(" (%s)", strings.Trim(candidate.Publisher+", "+candidate.Date, ", "))
+		}
+		fmt.Printf(" [%s]\n", candidate.Source)
+	}
+	fmt.Printf("Use which match? [1-%d, 0 to skip]: ", len(candidates))
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return nil, fmt.Errorf("failed to read the choice: %w", err)
+	}
+	choice, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil || choice < 0 || choice > len(candidates) {
+		return nil, fmt.Errorf("invalid choice %q", strings.TrimSpace(line))
+	}
+	if choice == 0 {
+		return nil, nil
+	}
+	return candidates[choice-1], nil
+}
+
+// downloadCover saves a fetched cover next to the book's content, returning its path
+// relative to the content directory
+func downloadCover(coverURL, basePath string) (string, error) {
+	data, err := lookup.Download(coverURL)
+	if err != nil {
+		return "", err
+	}
+
+	ext := strings.ToLower(path.Ext(strings.SplitN(coverURL, "?", 2)[0]))
+	if ext != ".png" && ext != ".jpg" && ext != ".jpeg" {
+		ext = ".jpg"
+	}
+	coverFile := "cover-fetched" + ext
+	if err := ioutil.WriteFile(filepath.Join(basePath, coverFile), data, 0644); err != nil {
+		return "", fmt.Errorf("failed to write %s: %w", coverFile, err)
+	}
+	return coverFile, nil
+}
@@ -0,0 +1,116 @@
+package restructure
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/flouciel/folian-parser/internal/langtag"
+	"github.com/flouciel/folian-parser/internal/parser"
+)
+
+// minLanguageChapters is how many chapters each language needs for a book to count
+// as multilingual, so that a lone foreign-language page does not
+const minLanguageChapters = 2
+
+// chapterLanguage returns the language declared by a chapter's html or body element,
+// or shared by all its top-level blocks; mixed parallel text has no single language
+func chapterLanguage(content string) string {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(content))
+	if err != nil {
+		return ""
+	}
+	if lang := langtag.Element(doc.Find("body")); lang != "" {
+		return lang
+	}
+	if lang := langtag.Element(doc.Find("html")); lang != "" {
+		return lang
+	}
+
+	var shared string
+	blocks := doc.Find("body").Children()
+	if blocks.Length() == 0 {
+		return ""
+	}
+	mixed := false
+	blocks.EachWithBreak(func(i int, s *goquery.Selection) bool {
+		lang := langtag.Element(s)
+		if lang == "" || (shared != "" && !strings.EqualFold(lang, shared)) {
+			mixed = true
+			return false
+		}
+		shared = lang
+		return true
+	})
+	if mixed {
+		return ""
+	}
+	return shared
+}
+
+// detectLanguages records the language of each chapter and whether the book mixes
+// languages, as bilingual and parallel-text editions do
+func (r *Restructurer) detectLanguages(book *parser.Book) {
+	r.chapterLanguages = make(map[string]string)
+	r.multilingual = false
+
+	counts := make(map[string]int)
+	for _, chapter := range book.Chapters {
+		lang := chapterLanguage(chapter.Content)
+		if lang == "" {
+			continue
+		}
+		r.chapterLanguages[chapter.ID] = lang
+		counts[langtag.Primary(lang)]++
+	}
+
+	var languages []string
+	for lang, count := range counts {
+		if count >= minLanguageChapters {
+			languages = append(languages, lang)
+		}
+	}
+	sort.Strings(languages)
+	r.multilingual = len(languages) > 1
+
+	if DebugMode && r.multilingual {
+		var summary []string
+		for _, lang := range languages {
+			summary = append(summary, fmt.Sprintf("%s (%d chapters)", lang, counts[lang]))
+		}
+		fmt.Printf("🌐 Multilingual book: %s\n", strings.Join(summary, ", "))
+	}
+}
+
+// labelLanguage returns the language a chapter's generated title is written in:
+// its own language in multilingual books, and "" (the label language) otherwise
+func (r *Restructurer) labelLanguage(chapter parser.Chapter) string {
+	if !r.multilingual {
+		return ""
+	}
+	return r.chapterLanguages[chapter.ID]
+}
+
+// chapterLabel returns the generic label of a chapter in a language
+func (r *Restructurer) chapterLabel(lang, number string) string {
+	return strings.Replace(r.localize(lang, "chapter"), "{{NUMBER}}", number, -1)
+}
+
+// navLanguage returns the language of a chapter's navigation label in a
+// multilingual book, when it differs from the book's
+func (r *Restructurer) navLanguage(book *parser.Book, chapter parser.Chapter) string {
+	lang := r.labelLanguage(chapter)
+	if langtag.Primary(lang) == langtag.Primary(book.Metadata.Language) {
+		return ""
+	}
+	return lang
+}
+
+// languageAttributes renders the lang attributes of a written chapter's html element
+func languageAttributes(lang string) string {
+	if lang == "" {
+		return ""
+	}
+	return fmt.Sprintf(` xml:lang="%s" lang="%s"`, xmlEscape(lang), xmlEscape(lang))
+}
@@ -0,0 +1,122 @@
+package restructure
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/flouciel/folian-parser/internal/parser"
+)
+
+// ncxUID returns the dtb:uid of toc.ncx, which must match the unique identifier of
+// content.opf; a book without one is given the identifier the package would get
+func (r *Restructurer) ncxUID(book *parser.Book) string {
+	if uid := strings.TrimSpace(book.Metadata.Identifier); uid != "" {
+		return uid
+	}
+	book.Metadata.Identifier = r.resolveIdentifier(book)
+	if DebugMode {
+		fmt.Printf("🆔 NCX: No identifier set, using %s as dtb:uid\n", book.Metadata.Identifier)
+	}
+	return book.Metadata.Identifier
+}
+
+// chapterNavPoints returns a navigation point per chapter, with the sections of the
+// original TOC that point into it; nav.xhtml and toc.ncx are both built from them
+func (r *Restructurer) chapterNavPoints(book *parser.Book) []ncxNavPoint {
+	targets := r.tocTargets(book.TOC)
+	var navPoints []ncxNavPoint
+	for i, chapter := range book.Chapters {
+		file := r.chapterFiles[i]
+		id := fmt.Sprintf("navpoint-%d", i+1)
+		count := 0
+		navPoints = append(navPoints, ncxNavPoint{
+			ID:       id,
+			Label:    ncxNavLabel{Lang: r.navLanguage(book, chapter), Text: chapter.Title},
+			Content:  ncxContent{file},
+			Children: sectionNavPoints(book.TOC, targets, file, chapter.Title, id, &count),
+		})
+	}
+	if PeriodicalKind != "" {
+		return r.periodicalNavPoints(book, navPoints)
+	}
+	return navPoints
+}
+
+// navLinks converts navigation points to nested nav document list entries
+func navLinks(navPoints []ncxNavPoint) []navLink {
+	var links []navLink
+	for _, navPoint := range navPoints {
+		link := newNavLink(navPoint.Content.Src, navPoint.Label.Text, navPoint.Label.Lang)
+		if len(navPoint.Children) > 0 {
+			link.List = &navList{navLinks(navPoint.Children)}
+		}
+		links = append(links, link)
+	}
+	return links
+}
+
+// tocTargets maps the hrefs of the original TOC entries to their output locations
+func (r *Restructurer) tocTargets(entries []parser.TOCEntry) map[string]string {
+	targets := make(map[string]string)
+	var walk func(entries []parser.TOCEntry)
+	walk = func(entries []parser.TOCEntry) {
+		for _, entry := range entries {
+			if target, ok := r.mapHref(entry.Href); ok && entry.Href != "" {
+				targets[entry.Href] = target
+			}
+			walk(entry.Children)
+		}
+	}
+	walk(entries)
+	return targets
+}
+
+// sectionNavPoints returns the navigation points of the original TOC entries that
+// point at an anchor within file, nested as in the original TOC; entries pointing
+// elsewhere or repeating the chapter title are left out and their children moved up
+// a level
+func sectionNavPoints(entries []parser.TOCEntry, targets map[string]string, file, chapterTitle, parentID string, count *int) []ncxNavPoint {
+	var navPoints []ncxNavPoint
+	for _, entry := range entries {
+		target := targets[entry.Href]
+		title := strings.TrimSpace(entry.Title)
+		if title == "" || title == strings.TrimSpace(chapterTitle) || !strings.HasPrefix(target, file+"#") {
+			navPoints = append(navPoints, sectionNavPoints(entry.Children, targets, file, chapterTitle, parentID, count)...)
+			continue
+		}
+		*count++
+		navPoints = append(navPoints, ncxNavPoint{
+			ID:       fmt.Sprintf("%s-%d", parentID, *count),
+			Label:    ncxNavLabel{Text: title},
+			Content:  ncxContent{target},
+			Children: sectionNavPoints(entry.Children, targets, file, chapterTitle, parentID, count),
+		})
+	}
+	return navPoints
+}
+
+// assignPlayOrder numbers navigation points in reading order, starting at 1 without
+// gaps; points with the same target share its number, as the NCX specification requires
+func assignPlayOrder(navPoints []ncxNavPoint, orders map[string]int) {
+	for i := range navPoints {
+		src := navPoints[i].Content.Src
+		if _, exists := orders[src]; !exists {
+			orders[src] = len(orders) + 1
+		}
+		navPoints[i].PlayOrder = orders[src]
+		assignPlayOrder(navPoints[i].Children, orders)
+	}
+}
+
+// navDepth returns the depth of the deepest navigation point, at least 1
+func navDepth(navPoints []ncxNavPoint) int {
+	depth := 1
+	for _, navPoint := range navPoints {
+		if len(navPoint.Children) > 0 {
+			if d := navDepth(navPoint.Children) + 1; d > depth {
+				depth = d
+			}
+		}
+	}
+	return depth
+}
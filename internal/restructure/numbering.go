@@ -0,0 +1,235 @@
+package restructure
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/flouciel/folian-parser/internal/parser"
+)
+
+// numberingScheme is the way a book writes its chapter numbers
+type numberingScheme int
+
+const (
+	arabicNumbering numberingScheme = iota
+	romanNumbering
+	englishNumbering
+	vietnameseNumbering
+)
+
+// numberingNames name the numbering schemes in debug output
+var numberingNames = map[numberingScheme]string{
+	arabicNumbering:     "Arabic",
+	romanNumbering:      "Roman",
+	englishNumbering:    "English words",
+	vietnameseNumbering: "Vietnamese words",
+}
+
+// chapterWords introduce a chapter number in a title
+var chapterWords = map[string]bool{
+	"chapter": true, "chương": true, "chapitre": true, "kapitel": true, "capítulo": true,
+	"capitolo": true, "hoofdstuk": true, "глава": true, "part": true, "phần": true, "book": true,
+	"hồi": true, "quyển": true,
+}
+
+// englishUnits and englishTens are the English number words
+var englishUnits = []string{"zero", "one", "two", "three", "four", "five", "six", "seven", "eight", "nine",
+	"ten", "eleven", "twelve", "thirteen", "fourteen", "fifteen", "sixteen", "seventeen", "eighteen", "nineteen"}
+var englishTens = []string{"", "", "twenty", "thirty", "forty", "fifty", "sixty", "seventy", "eighty", "ninety"}
+
+// vietnameseUnits are the Vietnamese digit words; after "mươi", 1, 4 and 5 are
+// written "mốt", "tư" and "lăm"
+var vietnameseUnits = []string{"không", "một", "hai", "ba", "bốn", "năm", "sáu", "bảy", "tám", "chín"}
+
+// romanValues are the Roman numeral symbols by value, subtractive pairs included
+var romanValues = []struct {
+	value  int
+	symbol string
+}{
+	{100, "C"}, {90, "XC"}, {50, "L"}, {40, "XL"}, {10, "X"}, {9, "IX"}, {5, "V"}, {4, "IV"}, {1, "I"},
+}
+
+// maxSpelledNumber is the largest number written in words or Roman numerals; larger
+// ones fall back to Arabic digits
+const maxSpelledNumber = 99
+
+// toRoman writes a number in Roman numerals
+func toRoman(n int) string {
+	var roman strings.Builder
+	for _, rv := range romanValues {
+		for n >= rv.value {
+			roman.WriteString(rv.symbol)
+			n -= rv.value
+		}
+	}
+	return roman.String()
+}
+
+// fromRoman reads an uppercase Roman numeral, or returns 0
+func fromRoman(word string) int {
+	if !isRomanNumeral(word) {
+		return 0
+	}
+	n := 0
+	for _, rv := range romanValues {
+		for strings.HasPrefix(word, rv.symbol) {
+			n += rv.value
+			word = word[len(rv.symbol):]
+		}
+	}
+	return n
+}
+
+// toEnglish writes a number below 100 in English words
+func toEnglish(n int) string {
+	if n < 20 {
+		return englishUnits[n]
+	}
+	if n%10 == 0 {
+		return englishTens[n/10]
+	}
+	return englishTens[n/10] + "-" + englishUnits[n%10]
+}
+
+// toVietnamese writes a number below 100 in Vietnamese words
+func toVietnamese(n int) string {
+	if n < 10 {
+		return vietnameseUnits[n]
+	}
+	tens := "mười"
+	if n >= 20 {
+		tens = vietnameseUnits[n/10] + " mươi"
+	}
+	switch unit := n % 10; {
+	case unit == 0:
+		return tens
+	case unit == 1 && n >= 20:
+		return tens + " mốt"
+	case unit == 4 && n >= 20:
+		return tens + " tư"
+	case unit == 5:
+		return tens + " lăm"
+	default:
+		return tens + " " + vietnameseUnits[unit]
+	}
+}
+
+// parseNumber reads a chapter number written in any scheme at the start of words,
+// returning the number, its scheme, whether it is capitalized and how many words it
+// takes; n is 0 when the words do not start with a number
+func parseNumber(words []string) (n int, scheme numberingScheme, capitalized bool, used int) {
+	if len(words) == 0 {
+		return 0, arabicNumbering, false, 0
+	}
+	first := strings.TrimRight(words[0], ".:,")
+	capitalized = unicode.IsUpper([]rune(first)[0])
+
+	if value, err := strconv.Atoi(first); err == nil && value > 0 {
+		return value, arabicNumbering, false, 1
+	}
+	if value := fromRoman(first); value > 0 {
+		return value, romanNumbering, false, 1
+	}
+
+	// Spelled-out numbers may span several words; the longest match wins
+	for count := min(len(words), 3); count > 0; count-- {
+		phrase := strings.ToLower(strings.TrimRight(strings.Join(words[:count], " "), ".:,"))
+		phrase = strings.NewReplacer(" mươi một", " mươi mốt", " mươi bốn", " mươi tư").Replace(phrase)
+		for value := 1; value <= maxSpelledNumber; value++ {
+			if phrase == toEnglish(value) {
+				return value, englishNumbering, capitalized, count
+			}
+			if phrase == toVietnamese(value) {
+				return value, vietnameseNumbering, capitalized, count
+			}
+		}
+	}
+	return 0, arabicNumbering, false, 0
+}
+
+// bareNumber returns the number a title consists of, or 0
+func bareNumber(title string) int {
+	words := strings.Fields(title)
+	if n, _, _, used := parseNumber(words); n > 0 && used == len(words) {
+		return n
+	}
+	return 0
+}
+
+// titleNumber finds the chapter number of a title: the whole title, or the words
+// after a chapter word such as "Chapter" or "Chương"
+func titleNumber(title string) (n int, scheme numberingScheme, capitalized bool) {
+	words := strings.Fields(title)
+	if value, s, c, used := parseNumber(words); value > 0 && used == len(words) {
+		return value, s, c
+	}
+	for i, word := range words {
+		if chapterWords[strings.ToLower(word)] {
+			if value, s, c, _ := parseNumber(words[i+1:]); value > 0 {
+				return value, s, c
+			}
+		}
+	}
+	return 0, arabicNumbering, false
+}
+
+// detectNumbering records the numbering scheme most of the book's numbered titles
+// use, so that renumbered chapters keep it
+func (r *Restructurer) detectNumbering(book *parser.Book) {
+	counts := make(map[numberingScheme]int)
+	capitalized := make(map[numberingScheme]int)
+	for _, chapter := range book.Chapters {
+		if n, scheme, c := titleNumber(chapter.Title); n > 0 {
+			counts[scheme]++
+			if c {
+				capitalized[scheme]++
+			}
+		}
+	}
+
+	r.numbering, r.numberingCapitalized = arabicNumbering, false
+	best := 0
+	for _, scheme := range []numberingScheme{arabicNumbering, romanNumbering, englishNumbering, vietnameseNumbering} {
+		if counts[scheme] > best {
+			r.numbering, best = scheme, counts[scheme]
+		}
+	}
+	r.numberingCapitalized = capitalized[r.numbering]*2 > counts[r.numbering]
+
+	if DebugMode && best > 0 {
+		fmt.Printf("🔢 Chapter numbering: %s (%d numbered titles)\n", numberingNames[r.numbering], best)
+	}
+}
+
+// formatChapterNumber writes a chapter number in the book's numbering scheme
+func (r *Restructurer) formatChapterNumber(n int) string {
+	if n < 1 || n > maxSpelledNumber {
+		return strconv.Itoa(n)
+	}
+
+	var number string
+	switch r.numbering {
+	case romanNumbering:
+		return toRoman(n)
+	case englishNumbering:
+		number = toEnglish(n)
+		if r.numberingCapitalized {
+			// Each part of a hyphenated number is capitalized, as in "Twenty-One"
+			parts := strings.Split(number, "-")
+			for i, part := range parts {
+				parts[i] = capitalize(part)
+			}
+			number = strings.Join(parts, "-")
+		}
+	case vietnameseNumbering:
+		number = toVietnamese(n)
+		if r.numberingCapitalized {
+			number = capitalize(number)
+		}
+	default:
+		return strconv.Itoa(n)
+	}
+	return number
+}
@@ -0,0 +1,231 @@
+package restructure
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/flouciel/folian-parser/internal/langtag"
+	"github.com/flouciel/folian-parser/internal/parser"
+	"github.com/flouciel/folian-parser/internal/policy"
+)
+
+// ONIXExport writes an ONIX 3.0 product record of the output next to it
+var ONIXExport bool
+
+// onixLanguages maps language tags to the ISO 639-2/B codes ONIX requires
+var onixLanguages = map[string]string{
+	"vi": "vie", "en": "eng", "fr": "fre", "de": "ger", "es": "spa", "pt": "por", "it": "ita",
+	"nl": "dut", "ru": "rus", "zh": "chi", "ja": "jpn", "ko": "kor", "th": "tha", "id": "ind",
+	"pl": "pol", "sv": "swe", "da": "dan", "no": "nor", "fi": "fin", "cs": "cze", "el": "gre",
+	"tr": "tur", "ar": "ara", "he": "heb", "hi": "hin", "uk": "ukr", "hu": "hun", "ro": "rum",
+}
+
+// onixProductIdentifier renders a ProductIdentifier: ISBN-13 (15), ISBN-10 (02) or a
+// proprietary identifier (01) named by its kind
+func onixProductIdentifier(identifier string) string {
+	kind, normalized := classifyIdentifier(identifier, "")
+	idType, value, name := "01", normalized, ""
+	switch kind {
+	case identifierISBN:
+		value = strings.TrimPrefix(normalized, "urn:isbn:")
+		idType = "15"
+		if len(value) == 10 {
+			idType = "02"
+		}
+	case identifierUUID:
+		value = strings.TrimPrefix(normalized, "urn:uuid:")
+		name = "UUID"
+	default:
+		name = "Identifier"
+	}
+
+	var out strings.Builder
+	out.WriteString("    <ProductIdentifier>\n")
+	fmt.Fprintf(&out, "      <ProductIDType>%s</ProductIDType>\n", idType)
+	if name != "" {
+		fmt.Fprintf(&out, "      <IDTypeName>%s</IDTypeName>\n", name)
+	}
+	fmt.Fprintf(&out, "      <IDValue>%s</IDValue>\n", xmlEscape(value))
+	out.WriteString("    </ProductIdentifier>\n")
+	return out.String()
+}
+
+// onixDate converts a date to the ONIX format and its dateformat code: YYYYMMDD (00),
+// YYYYMM (01) or YYYY (05)
+func onixDate(value string) (string, string, bool) {
+	date, ok := normalizeDate(value)
+	if !ok {
+		return "", "", false
+	}
+	date = strings.Replace(date, "-", "", -1)
+	switch {
+	case len(date) >= 8:
+		return date[:8], "00", true
+	case len(date) == 6:
+		return date, "01", true
+	default:
+		return date, "05", true
+	}
+}
+
+// onixProtection returns the EPUB technical protection code of the output: Readium
+// LCP (06), digital watermarking (02) or none (00)
+func onixProtection(lcp bool) string {
+	switch {
+	case lcp:
+		return "06"
+	case watermarking():
+		return "02"
+	}
+	return "00"
+}
+
+// bookWords counts the words of the book's chapters
+func bookWords(book *parser.Book) int {
+	words := 0
+	for _, chapter := range book.Chapters {
+		words += len(strings.Fields(chapter.Text()))
+	}
+	return words
+}
+
+// BuildONIX renders an ONIX 3.0 message holding the product record of a processed
+// book: identifiers, title, series, contributor, language, extents, subjects with
+// their BISAC and Thema codes, description, publisher and publication date
+func BuildONIX(book *parser.Book, fileSize int64, lcp bool) string {
+	meta := book.Metadata
+	sender := meta.Publisher
+	if sender == "" {
+		sender = "Folian Parser"
+	}
+
+	var out strings.Builder
+	out.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<ONIXMessage release="3.0" xmlns="http://ns.editeur.org/onix/3.0/reference">
+  <Header>
+    <Sender>
+`)
+	fmt.Fprintf(&out, "      <SenderName>%s</SenderName>\n", xmlEscape(sender))
+	out.WriteString("    </Sender>\n")
+	fmt.Fprintf(&out, "    <SentDateTime>%s</SentDateTime>\n", time.Now().UTC().Format("20060102T1504Z"))
+	out.WriteString("  </Header>\n  <Product>\n")
+
+	fmt.Fprintf(&out, "    <RecordReference>%s</RecordReference>\n", xmlEscape(meta.Identifier))
+	out.WriteString("    <NotificationType>03</NotificationType>\n")
+	out.WriteString(onixProductIdentifier(meta.Identifier))
+	seen := map[string]bool{meta.Identifier: true}
+	for _, identifier := range meta.Identifiers {
+		if _, normalized := classifyIdentifier(identifier.Value, identifier.Scheme); !seen[normalized] {
+			seen[normalized] = true
+			out.WriteString(onixProductIdentifier(normalized))
+		}
+	}
+
+	// Descriptive detail: form, series, title, contributor, language, extents, subjects
+	out.WriteString(`    <DescriptiveDetail>
+      <ProductComposition>00</ProductComposition>
+      <ProductForm>ED</ProductForm>
+      <ProductFormDetail>E101</ProductFormDetail>
+`)
+	fmt.Fprintf(&out, "      <EpubTechnicalProtection>%s</EpubTechnicalProtection>\n", onixProtection(lcp))
+	if series := strings.TrimSpace(meta.Series); series != "" {
+		out.WriteString("      <Collection>\n        <CollectionType>10</CollectionType>\n        <TitleDetail>\n          <TitleType>01</TitleType>\n          <TitleElement>\n            <TitleElementLevel>02</TitleElementLevel>\n")
+		if index := normalizeSeriesIndex(meta.SeriesIndex); index != "" {
+			fmt.Fprintf(&out, "            <PartNumber>%s</PartNumber>\n", xmlEscape(index))
+		}
+		fmt.Fprintf(&out, "            <TitleText>%s</TitleText>\n", xmlEscape(series))
+		out.WriteString("          </TitleElement>\n        </TitleDetail>\n      </Collection>\n")
+	}
+	out.WriteString("      <TitleDetail>\n        <TitleType>01</TitleType>\n        <TitleElement>\n          <TitleElementLevel>01</TitleElementLevel>\n")
+	fmt.Fprintf(&out, "          <TitleText>%s</TitleText>\n", xmlEscape(meta.Title))
+	out.WriteString("        </TitleElement>\n      </TitleDetail>\n")
+	if meta.Creator != "" {
+		out.WriteString("      <Contributor>\n        <SequenceNumber>1</SequenceNumber>\n        <ContributorRole>A01</ContributorRole>\n")
+		fmt.Fprintf(&out, "        <PersonName>%s</PersonName>\n", xmlEscape(meta.Creator))
+		out.WriteString("      </Contributor>\n")
+	}
+	if code, ok := onixLanguages[langtag.Primary(strings.ToLower(meta.Language))]; ok {
+		fmt.Fprintf(&out, "      <Language>\n        <LanguageRole>01</LanguageRole>\n        <LanguageCode>%s</LanguageCode>\n      </Language>\n", code)
+	} else if meta.Language != "" {
+		policy.Warn(policy.KindOther, "No ONIX language code for %q", meta.Language)
+	}
+	fmt.Fprintf(&out, "      <Extent>\n        <ExtentType>02</ExtentType>\n        <ExtentValue>%d</ExtentValue>\n        <ExtentUnit>02</ExtentUnit>\n      </Extent>\n", bookWords(book))
+	if fileSize > 0 {
+		fmt.Fprintf(&out, "      <Extent>\n        <ExtentType>22</ExtentType>\n        <ExtentValue>%d</ExtentValue>\n        <ExtentUnit>17</ExtentUnit>\n      </Extent>\n", fileSize)
+	}
+	out.WriteString(buildONIXSubjects(meta.Subjects))
+	out.WriteString("    </DescriptiveDetail>\n")
+
+	if description := strings.TrimSpace(meta.Description); description != "" {
+		out.WriteString("    <CollateralDetail>\n      <TextContent>\n        <TextType>03</TextType>\n        <ContentAudience>00</ContentAudience>\n")
+		fmt.Fprintf(&out, "        <Text>%s</Text>\n", xmlEscape(description))
+		out.WriteString("      </TextContent>\n    </CollateralDetail>\n")
+	}
+
+	out.WriteString("    <PublishingDetail>\n")
+	if meta.Publisher != "" {
+		fmt.Fprintf(&out, "      <Publisher>\n        <PublishingRole>01</PublishingRole>\n        <PublisherName>%s</PublisherName>\n      </Publisher>\n", xmlEscape(meta.Publisher))
+	}
+	if date, format, ok := onixDate(meta.Date); ok {
+		fmt.Fprintf(&out, "      <PublishingDate>\n        <PublishingDateRole>01</PublishingDateRole>\n        <Date dateformat=\"%s\">%s</Date>\n      </PublishingDate>\n", format, date)
+	}
+	out.WriteString("    </PublishingDetail>\n  </Product>\n</ONIXMessage>\n")
+	return out.String()
+}
+
+// buildONIXSubjects renders the subjects as keywords (20) and, when SubjectCodes is
+// set, their BISAC (10) and Thema (93) codes, the first of each as the main subject
+func buildONIXSubjects(subjects []string) string {
+	if len(subjects) == 0 {
+		return ""
+	}
+
+	var out strings.Builder
+	subject := func(main bool, scheme, element, value string) {
+		out.WriteString("      <Subject>\n")
+		if main {
+			out.WriteString("        <MainSubject/>\n")
+		}
+		fmt.Fprintf(&out, "        <SubjectSchemeIdentifier>%s</SubjectSchemeIdentifier>\n        <%s>%s</%s>\n      </Subject>\n", scheme, element, xmlEscape(value), element)
+	}
+
+	if table, err := loadSubjectTable(); err == nil && SubjectCodes {
+		var bisac, thema []string
+		seen := make(map[string]bool)
+		for _, s := range subjects {
+			if code, ok := matchSubjectCode(table, s); ok && !seen[code.bisacCode] {
+				seen[code.bisacCode] = true
+				bisac = append(bisac, code.bisacCode)
+				thema = append(thema, code.themaCode)
+			}
+		}
+		for i, code := range bisac {
+			subject(i == 0, "10", "SubjectCode", code)
+		}
+		for i, code := range thema {
+			subject(i == 0, "93", "SubjectCode", code)
+		}
+	}
+	subject(false, "20", "SubjectHeadingText", strings.Join(subjects, "; "))
+	return out.String()
+}
+
+// WriteONIX writes the ONIX record of a processed book to OUTPUT.onix.xml, next to
+// the output EPUB
+func WriteONIX(book *parser.Book, outputPath string, lcp bool) error {
+	var fileSize int64
+	if info, err := os.Stat(outputPath); err == nil {
+		fileSize = info.Size()
+	}
+
+	onixPath := strings.TrimSuffix(outputPath, filepath.Ext(outputPath)) + ".onix.xml"
+	if err := ioutil.WriteFile(onixPath, []byte(BuildONIX(book, fileSize, lcp)), 0644); err != nil {
+		return fmt.Errorf("failed to write ONIX record: %w", err)
+	}
+	fmt.Printf("📇 ONIX record written to %s\n", onixPath)
+	return nil
+}
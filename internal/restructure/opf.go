@@ -0,0 +1,161 @@
+package restructure
+
+import (
+	"encoding/xml"
+	"fmt"
+
+	"github.com/flouciel/folian-parser/internal/xmlfmt"
+)
+
+// ncxDoctype is the document type declaration of toc.ncx
+const ncxDoctype = `<!DOCTYPE ncx PUBLIC "-//NISO//DTD ncx 2005-1//EN" "http://www.daisy.org/z3986/2005/ncx-2005-1.dtd">` + "\n"
+
+// opfPackage is the package document written as content.opf
+type opfPackage struct {
+	XMLName          xml.Name       `xml:"package"`
+	Xmlns            string         `xml:"xmlns,attr"`
+	Version          string         `xml:"version,attr"`
+	UniqueIdentifier string         `xml:"unique-identifier,attr"`
+	Prefix           string         `xml:"prefix,attr,omitempty"`
+	Metadata         opfMetadata    `xml:"metadata"`
+	Manifest         []opfItem      `xml:"manifest>item"`
+	Spine            opfSpine       `xml:"spine"`
+	Guide            []opfReference `xml:"guide>reference"`
+}
+
+// opfMetadata holds the Dublin Core fields and meta elements of the package
+type opfMetadata struct {
+	XmlnsDC  string `xml:"xmlns:dc,attr"`
+	XmlnsOPF string `xml:"xmlns:opf,attr"`
+	Elements []metaElement
+}
+
+// metaElement is an element of the OPF metadata: a Dublin Core field or a meta,
+// named with its prefix
+type metaElement struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr `xml:",any,attr"`
+	Value   string     `xml:",chardata"`
+}
+
+// opfItem is an item of the manifest
+type opfItem struct {
+	ID           string `xml:"id,attr"`
+	Href         string `xml:"href,attr"`
+	MediaType    string `xml:"media-type,attr"`
+	Properties   string `xml:"properties,attr,omitempty"`
+	MediaOverlay string `xml:"media-overlay,attr,omitempty"`
+}
+
+// opfSpine is the reading order of the package
+type opfSpine struct {
+	Toc      string       `xml:"toc,attr,omitempty"`
+	PageMap  string       `xml:"page-map,attr,omitempty"`
+	Itemrefs []opfItemref `xml:"itemref"`
+}
+
+// opfItemref is an entry of the spine
+type opfItemref struct {
+	IDRef  string `xml:"idref,attr"`
+	Linear string `xml:"linear,attr,omitempty"`
+}
+
+// opfReference is a reference of the EPUB2 guide
+type opfReference struct {
+	Type  string `xml:"type,attr"`
+	Title string `xml:"title,attr"`
+	Href  string `xml:"href,attr"`
+}
+
+// ncxDocument is the EPUB2 navigation document written as toc.ncx
+type ncxDocument struct {
+	XMLName   xml.Name      `xml:"ncx"`
+	Xmlns     string        `xml:"xmlns,attr"`
+	Version   string        `xml:"version,attr"`
+	Head      []ncxMeta     `xml:"head>meta"`
+	DocTitle  string        `xml:"docTitle>text"`
+	DocAuthor string        `xml:"docAuthor>text"`
+	NavPoints []ncxNavPoint `xml:"navMap>navPoint"`
+}
+
+// ncxMeta is a meta element of the NCX head
+type ncxMeta struct {
+	Name    string `xml:"name,attr"`
+	Content string `xml:"content,attr"`
+}
+
+// ncxNavPoint is an entry of the NCX navigation map
+type ncxNavPoint struct {
+	ID        string        `xml:"id,attr"`
+	PlayOrder int           `xml:"playOrder,attr"`
+	Label     ncxNavLabel   `xml:"navLabel"`
+	Content   ncxContent    `xml:"content"`
+	Children  []ncxNavPoint `xml:"navPoint"`
+}
+
+// ncxNavLabel is the label of a navigation point, in its language when it differs
+// from the book's
+type ncxNavLabel struct {
+	Lang string `xml:"xml:lang,attr,omitempty"`
+	Text string `xml:"text"`
+}
+
+// ncxContent is the target of a navigation point
+type ncxContent struct {
+	Src string `xml:"src,attr"`
+}
+
+// navLink is an entry of a nav document list
+type navLink struct {
+	XMLName xml.Name `xml:"li"`
+	Link    struct {
+		Href    string `xml:"href,attr"`
+		XMLLang string `xml:"xml:lang,attr,omitempty"`
+		Lang    string `xml:"lang,attr,omitempty"`
+		Text    string `xml:",chardata"`
+	} `xml:"a"`
+	List *navList `xml:"ol"`
+}
+
+// navList is a nested list of a nav document, left out when empty
+type navList struct {
+	Items []navLink `xml:"li"`
+}
+
+// newMeta returns a metadata element with a value and its attributes, given as
+// name/value pairs
+func newMeta(name, value string, attrs ...string) metaElement {
+	element := metaElement{XMLName: xml.Name{Local: name}, Value: value}
+	for i := 0; i+1 < len(attrs); i += 2 {
+		element.Attrs = append(element.Attrs, xml.Attr{Name: xml.Name{Local: attrs[i]}, Value: attrs[i+1]})
+	}
+	return element
+}
+
+// newNavLink returns a nav list entry linking to href, with the language of its
+// label when set
+func newNavLink(href, label, lang string) navLink {
+	var link navLink
+	link.Link.Href, link.Link.Text = href, label
+	link.Link.XMLLang, link.Link.Lang = lang, lang
+	return link
+}
+
+// marshalXML renders a document with its XML declaration and any prolog, laid out
+// by the canonical formatter
+func marshalXML(v interface{}, prolog string) ([]byte, error) {
+	data, err := xml.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render XML: %w", err)
+	}
+	return xmlfmt.Format([]byte(xml.Header+prolog+string(data)), false)
+}
+
+// marshalFragment renders elements to be inserted into a document
+func marshalFragment(v interface{}) (string, error) {
+	data, err := xml.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("failed to render XML: %w", err)
+	}
+	return string(data), nil
+}
@@ -0,0 +1,307 @@
+package restructure
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/flouciel/folian-parser/internal/audio"
+	"github.com/flouciel/folian-parser/internal/parser"
+	"github.com/flouciel/folian-parser/internal/policy"
+)
+
+// AudioDir is a directory of narrated audio files with their cue sheet; the chapters
+// get media overlays synchronized at paragraph level, for a read-along edition
+var AudioDir string
+
+// overlayActiveClass is the class reading systems set on the paragraph being read
+const overlayActiveClass = "-epub-media-overlay-active"
+
+// overlayTitleID is the ID of the chapter heading, read before its paragraphs
+const overlayTitleID = "mo-title"
+
+// overlayStyles highlights the paragraph being read
+const overlayStyles = `
+/* Read-along highlighting */
+.` + overlayActiveClass + ` {
+  background-color: #fff3b0;
+  color: inherit;
+}
+`
+
+// overlayText is a paragraph of a chapter the narration is synchronized with
+type overlayText struct {
+	id string
+	// weight is the length of the paragraph's text, which its share of the
+	// chapter's narration is proportional to
+	weight int
+}
+
+// mediaOverlay is a written media overlay
+type mediaOverlay struct {
+	chapter  string
+	file     string
+	duration float64
+}
+
+// overlayBlocks returns the blocks of a chapter read in the narration: its text
+// blocks outside notes, in reading order
+func overlayBlocks(doc *goquery.Document) *goquery.Selection {
+	return doc.Find(sentenceBlocks).FilterFunction(func(i int, s *goquery.Selection) bool {
+		if s.Find(sentenceContainers).Length() > 0 || strings.TrimSpace(s.Text()) == "" {
+			return false
+		}
+		return s.ParentsFiltered("aside, nav").Length() == 0
+	})
+}
+
+// markOverlayParagraphs gives the blocks read in the narration IDs that the media
+// overlay refers to
+func markOverlayParagraphs(doc *goquery.Document) {
+	count := 0
+	overlayBlocks(doc).Each(func(i int, s *goquery.Selection) {
+		if _, exists := s.Attr("id"); exists {
+			return
+		}
+		count++
+		s.SetAttr("id", fmt.Sprintf("mo-p%d", count))
+	})
+}
+
+// collectOverlayTexts records the paragraphs of a written chapter that the
+// narration is synchronized with
+func (r *Restructurer) collectOverlayTexts(content, target string) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(content))
+	if err != nil {
+		return
+	}
+	var texts []overlayText
+	if title := doc.Find("h1#" + overlayTitleID); title.Length() > 0 {
+		texts = append(texts, overlayText{id: overlayTitleID, weight: utf8.RuneCountInString(strings.TrimSpace(title.Text()))})
+	}
+	overlayBlocks(doc).Each(func(i int, s *goquery.Selection) {
+		if id, exists := s.Attr("id"); exists {
+			texts = append(texts, overlayText{id: id, weight: utf8.RuneCountInString(strings.TrimSpace(s.Text()))})
+		}
+	})
+	r.overlayTexts[target] = texts
+}
+
+// processMediaOverlays copies the narration of AudioDir into the book and writes
+// the media overlays synchronizing each chapter with its track
+func (r *Restructurer) processMediaOverlays(book *parser.Book, oebpsPath string) error {
+	r.overlays = nil
+	r.audioFiles = nil
+	r.narrator = ""
+	if AudioDir == "" {
+		return nil
+	}
+
+	narration, err := audio.Load(AudioDir)
+	if err != nil {
+		return err
+	}
+	r.narrator = narration.Narrator
+
+	var titles []string
+	for _, chapter := range book.Chapters {
+		titles = append(titles, chapter.Title)
+	}
+	chapters := matchTracks(narration.Tracks, titles)
+
+	audioPath := filepath.Join(oebpsPath, "audio")
+	overlaysPath := filepath.Join(oebpsPath, "overlays")
+	for _, dir := range []string{audioPath, overlaysPath} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create %s: %w", filepath.Base(dir), err)
+		}
+	}
+
+	copied := make(map[string]string)
+	var total float64
+	for i, track := range narration.Tracks {
+		if chapters[i] < 0 || chapters[i] >= len(r.chapterFiles) {
+			continue
+		}
+		chapterFile := r.chapterFiles[chapters[i]]
+		texts := r.overlayTexts[chapterFile]
+		if len(texts) == 0 {
+			continue
+		}
+
+		audioFile, ok := copied[track.File]
+		if !ok {
+			audioFile = "audio/" + profileFilename(filepath.Base(track.File))
+			if err := copyFile(track.File, filepath.Join(oebpsPath, filepath.FromSlash(audioFile))); err != nil {
+				return fmt.Errorf("failed to copy %s: %w", filepath.Base(track.File), err)
+			}
+			copied[track.File] = audioFile
+			r.audioFiles = append(r.audioFiles, audioFile)
+		}
+
+		overlayFile := "overlays/" + strings.TrimSuffix(path.Base(chapterFile), path.Ext(chapterFile)) + ".smil"
+		content := buildOverlay(chapterFile, audioFile, track, texts)
+		if err := ioutil.WriteFile(filepath.Join(oebpsPath, filepath.FromSlash(overlayFile)), []byte(content), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", overlayFile, err)
+		}
+		duration := track.End - track.Start
+		total += duration
+		r.overlays = append(r.overlays, mediaOverlay{chapter: chapterFile, file: overlayFile, duration: duration})
+		if DebugMode {
+			fmt.Printf("🎧 %s: %d paragraphs over %s of %s\n", chapterFile, len(texts), clockValue(duration), filepath.Base(track.File))
+		}
+	}
+	if len(r.overlays) == 0 {
+		return fmt.Errorf("no chapter could be synchronized with the narration in %s", AudioDir)
+	}
+
+	// Highlight the paragraph being read
+	stylesheet := filepath.Join(oebpsPath, "styles", "stylesheet.css")
+	if css, err := ioutil.ReadFile(stylesheet); err == nil && !strings.Contains(string(css), "."+overlayActiveClass) {
+		if err := ioutil.WriteFile(stylesheet, append(css, overlayStyles...), 0644); err != nil {
+			return fmt.Errorf("failed to update stylesheet: %w", err)
+		}
+	}
+
+	fmt.Printf("ℹ️  Synchronized %d chapters with %d audio files (%s of narration)\n", len(r.overlays), len(r.audioFiles), clockValue(total))
+	return nil
+}
+
+// matchTracks maps each track of the narration to the index of its chapter, -1 for
+// none: by title when every track names a chapter, else in order
+func matchTracks(tracks []audio.Track, titles []string) []int {
+	normalize := func(title string) string {
+		return strings.ToLower(strings.Join(strings.Fields(title), " "))
+	}
+	byTitle := make(map[string]int)
+	for i, title := range titles {
+		if _, exists := byTitle[normalize(title)]; !exists {
+			byTitle[normalize(title)] = i
+		}
+	}
+
+	chapters := make([]int, len(tracks))
+	used := make(map[int]bool)
+	matched := true
+	for i, track := range tracks {
+		index, ok := byTitle[normalize(track.Title)]
+		if track.Title == "" || !ok || used[index] {
+			matched = false
+			break
+		}
+		chapters[i] = index
+		used[index] = true
+	}
+	if matched {
+		return chapters
+	}
+
+	if len(tracks) != len(titles) {
+		policy.Warn(policy.KindChapter, "The narration has %d tracks for %d chapters and its track titles do not name them; synchronizing them in order", len(tracks), len(titles))
+	}
+	for i := range tracks {
+		chapters[i] = -1
+		if i < len(titles) {
+			chapters[i] = i
+		}
+	}
+	return chapters
+}
+
+// buildOverlay writes the SMIL media overlay of a chapter, sharing its track among
+// the paragraphs in proportion to the length of their text
+func buildOverlay(chapterFile, audioFile string, track audio.Track, texts []overlayText) string {
+	total := 0
+	for _, text := range texts {
+		total += text.weight + 1
+	}
+
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<smil xmlns="http://www.w3.org/ns/SMIL" xmlns:epub="http://www.idpf.org/2007/ops" version="3.0">
+  <body>
+`)
+	textHref := "../" + escapeHref(chapterFile)
+	fmt.Fprintf(&b, "    <seq id=\"seq1\" epub:textref=\"%s\">\n", textHref)
+	clipBegin, elapsed := track.Start, 0
+	for i, text := range texts {
+		elapsed += text.weight + 1
+		clipEnd := track.Start + (track.End-track.Start)*float64(elapsed)/float64(total)
+		if i == len(texts)-1 {
+			clipEnd = track.End
+		}
+		fmt.Fprintf(&b, "      <par id=\"par%d\">\n", i+1)
+		fmt.Fprintf(&b, "        <text src=\"%s#%s\"/>\n", textHref, html.EscapeString(text.id))
+		fmt.Fprintf(&b, "        <audio src=\"../%s\" clipBegin=\"%s\" clipEnd=\"%s\"/>\n", escapeHref(audioFile), clockValue(clipBegin), clockValue(clipEnd))
+		b.WriteString("      </par>\n")
+		clipBegin = clipEnd
+	}
+	b.WriteString("    </seq>\n  </body>\n</smil>\n")
+	return b.String()
+}
+
+// clockValue formats seconds as a SMIL full clock value, h:mm:ss.fff
+func clockValue(seconds float64) string {
+	millis := int64(seconds*1000 + 0.5)
+	return fmt.Sprintf("%d:%02d:%02d.%03d", millis/3600000, millis/60000%60, millis/1000%60, millis%1000)
+}
+
+// overlayMetadata returns the package metadata of the media overlays: their
+// durations, the narrator and the class of the paragraph being read
+func (r *Restructurer) overlayMetadata() []metaElement {
+	if len(r.overlays) == 0 {
+		return nil
+	}
+	var meta []metaElement
+	var total float64
+	for i, overlay := range r.overlays {
+		meta = append(meta, newMeta("meta", clockValue(overlay.duration), "property", "media:duration", "refines", fmt.Sprintf("#overlay%d", i+1)))
+		total += overlay.duration
+	}
+	meta = append(meta, newMeta("meta", clockValue(total), "property", "media:duration"))
+	if r.narrator != "" {
+		meta = append(meta, newMeta("meta", r.narrator, "property", "media:narrator"))
+	}
+	return append(meta, newMeta("meta", overlayActiveClass, "property", "media:active-class"))
+}
+
+// overlayItems returns the manifest items of the media overlays and the audio they
+// play, and the overlay IDs of the chapters
+func (r *Restructurer) overlayItems() ([]opfItem, map[string]string) {
+	var items []opfItem
+	chapters := make(map[string]string)
+	for i, overlay := range r.overlays {
+		id := fmt.Sprintf("overlay%d", i+1)
+		items = append(items, opfItem{ID: id, Href: hrefPath(overlay.file), MediaType: "application/smil+xml"})
+		chapters[overlay.chapter] = id
+	}
+	for i, file := range r.audioFiles {
+		items = append(items, opfItem{ID: fmt.Sprintf("audio%d", i+1), Href: hrefPath(file), MediaType: audio.MediaType(file)})
+	}
+	return items, chapters
+}
+
+// copyFile copies a file
+func copyFile(source, target string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
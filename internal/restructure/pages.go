@@ -0,0 +1,119 @@
+package restructure
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/flouciel/folian-parser/internal/parser"
+)
+
+// PageBreakWords synthesizes a page break every N words when the book has no
+// print page numbers of its own (0 disables synthesis)
+var PageBreakWords int
+
+// pageListEntry is an entry of the regenerated page-list navigation
+type pageListEntry struct {
+	Label string
+	Href  string
+}
+
+// isPageBreak reports whether an element marks a print page break
+func isPageBreak(s *goquery.Selection) bool {
+	epubType, _ := s.Attr("epub:type")
+	role, _ := s.Attr("role")
+	return strings.Contains(" "+epubType+" ", " pagebreak ") || role == "doc-pagebreak"
+}
+
+// hasPageBreaks reports whether any chapter of the book carries page break markers
+func hasPageBreaks(book *parser.Book) bool {
+	for _, chapter := range book.Chapters {
+		if !strings.Contains(chapter.Content, "pagebreak") {
+			continue
+		}
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(chapter.Content))
+		if err != nil {
+			continue
+		}
+		found := false
+		doc.Find("*").EachWithBreak(func(i int, s *goquery.Selection) bool {
+			found = isPageBreak(s)
+			return !found
+		})
+		if found {
+			return true
+		}
+	}
+	return false
+}
+
+// resetPages prepares page list collection and page break synthesis for a book
+func (r *Restructurer) resetPages(book *parser.Book) {
+	r.pageList = nil
+	r.pageNumber = 0
+	r.pageWordCount = 0
+	r.synthesizePages = PageBreakWords > 0 && !hasPageBreaks(book)
+
+	if DebugMode && r.synthesizePages {
+		fmt.Printf("📄 Synthesizing page breaks every %d words\n", PageBreakWords)
+	}
+}
+
+// insertPageBreaks inserts a page break before the paragraph where each page of
+// PageBreakWords words starts, numbering pages continuously across chapters
+func (r *Restructurer) insertPageBreaks(doc *goquery.Document) {
+	doc.Find("body p").Each(func(i int, s *goquery.Selection) {
+		if r.pageWordCount >= r.pageNumber*PageBreakWords {
+			r.pageNumber++
+			s.BeforeHtml(fmt.Sprintf(`<span epub:type="pagebreak" role="doc-pagebreak" id="page_%d" title="%d"></span>`,
+				r.pageNumber, r.pageNumber))
+		}
+		r.pageWordCount += len(strings.Fields(s.Text()))
+	})
+}
+
+// collectPageBreaks adds the page breaks of a written chapter to the page list
+func (r *Restructurer) collectPageBreaks(content, target string) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(content))
+	if err != nil {
+		return
+	}
+
+	doc.Find("[id]").Each(func(i int, s *goquery.Selection) {
+		if !isPageBreak(s) {
+			return
+		}
+		id, _ := s.Attr("id")
+		label, _ := s.Attr("title")
+		if label == "" {
+			label, _ = s.Attr("aria-label")
+		}
+		if label == "" {
+			label = strings.TrimSpace(s.Text())
+		}
+		if label == "" {
+			label = id
+		}
+		r.pageList = append(r.pageList, pageListEntry{Label: label, Href: target + "#" + id})
+	})
+}
+
+// pageListNav renders the page-list nav element, or an empty string without pages
+func (r *Restructurer) pageListNav() (string, error) {
+	if len(r.pageList) == 0 {
+		return "", nil
+	}
+
+	nav := struct {
+		XMLName xml.Name  `xml:"nav"`
+		Type    string    `xml:"epub:type,attr"`
+		ID      string    `xml:"id,attr"`
+		Hidden  string    `xml:"hidden,attr"`
+		Pages   []navLink `xml:"ol>li"`
+	}{Type: "page-list", ID: "page-list", Hidden: "hidden"}
+	for _, page := range r.pageList {
+		nav.Pages = append(nav.Pages, newNavLink(page.Href, page.Label, ""))
+	}
+	return marshalFragment(nav)
+}
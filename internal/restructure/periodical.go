@@ -0,0 +1,163 @@
+package restructure
+
+import (
+	"fmt"
+	"html"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/flouciel/folian-parser/internal/parser"
+)
+
+// PeriodicalKind structures the book as an issue of a periodical of this kind
+// (newspaper, magazine, newsletter or journal): sections of articles with bylines
+var PeriodicalKind string
+
+// IssueDate is the date of a periodical issue, YYYY-MM-DD
+var IssueDate string
+
+// PeriodicalKinds are the kinds of periodicals, recorded as dc:type
+var PeriodicalKinds = []string{"newspaper", "magazine", "newsletter", "journal"}
+
+// periodicalStyles sets the bylines of articles
+const periodicalStyles = `
+/* Periodical articles */
+p.byline {
+  font-style: italic;
+  text-indent: 0;
+  margin: 0 0 1.5em 0;
+}
+`
+
+// bylinePattern matches the opening of a byline in the languages with localized
+// labels, followed by a name
+var bylinePattern = regexp.MustCompile(`^(?i:by|par|von|por|di|door|bởi|от)\s+\p{Lu}`)
+
+// bylineClasses are the class names marking bylines
+var bylineClasses = []string{"byline", "author", "article-author", "dateline"}
+
+// bylineMaxLength is the longest text taken for a byline
+const bylineMaxLength = 120
+
+// preparePeriodical titles and dates an issue of a periodical: the publication
+// becomes the series it belongs to, and the issue is titled and dated by IssueDate
+func (r *Restructurer) preparePeriodical(book *parser.Book, oebpsPath string) error {
+	if PeriodicalKind == "" {
+		return nil
+	}
+	publication := strings.TrimSpace(book.Metadata.Title)
+	if book.Metadata.Series == "" && SeriesName == "" {
+		book.Metadata.Series = publication
+	}
+	if IssueDate != "" {
+		if !strings.Contains(publication, IssueDate) {
+			book.Metadata.Title = publication + " — " + IssueDate
+		}
+		book.Metadata.Date = IssueDate
+	}
+
+	// Set the bylines of the articles
+	stylesheet := filepath.Join(oebpsPath, "styles", "stylesheet.css")
+	if css, err := ioutil.ReadFile(stylesheet); err == nil && !strings.Contains(string(css), "p.byline") {
+		if err := ioutil.WriteFile(stylesheet, append(css, periodicalStyles...), 0644); err != nil {
+			return fmt.Errorf("failed to update stylesheet: %w", err)
+		}
+	}
+	if DebugMode {
+		fmt.Printf("📰 %s issue of %s dated %s\n", PeriodicalKind, publication, book.Metadata.Date)
+	}
+	return nil
+}
+
+// extractByline removes the byline of an article from the start of its content
+// and returns it as text: an element marked as a byline or an address, or a short
+// paragraph starting with "By"
+func extractByline(doc *goquery.Document) string {
+	byline := ""
+	doc.Find("body").Find("p, div, address, span").EachWithBreak(func(i int, s *goquery.Selection) bool {
+		// Only the opening blocks of an article hold its byline
+		if i >= 6 {
+			return false
+		}
+		text := strings.Join(strings.Fields(s.Text()), " ")
+		if text == "" || len([]rune(text)) > bylineMaxLength {
+			return true
+		}
+		marked := goquery.NodeName(s) == "address"
+		for _, class := range bylineClasses {
+			if s.HasClass(class) {
+				marked = true
+			}
+		}
+		if marked || (goquery.NodeName(s) == "p" && bylinePattern.MatchString(text)) {
+			byline = text
+			s.Remove()
+			return false
+		}
+		return true
+	})
+	return byline
+}
+
+// bylineHTML renders the byline of an article, to follow its heading
+func bylineHTML(byline string) string {
+	if byline == "" {
+		return ""
+	}
+	return fmt.Sprintf("  <p class=\"byline\">%s</p>\n", html.EscapeString(byline))
+}
+
+// articleSections returns the section of each chapter file from the original table
+// of contents: its top-level entries that have children are the sections, and the
+// chapters they point into are their articles
+func (r *Restructurer) articleSections(book *parser.Book) map[string]string {
+	sections := make(map[string]string)
+	targets := r.tocTargets(book.TOC)
+	var walk func(entries []parser.TOCEntry, section string)
+	walk = func(entries []parser.TOCEntry, section string) {
+		for _, entry := range entries {
+			file := strings.SplitN(targets[entry.Href], "#", 2)[0]
+			if _, exists := sections[file]; file != "" && !exists {
+				sections[file] = section
+			}
+			walk(entry.Children, section)
+		}
+	}
+	for _, entry := range book.TOC {
+		if len(entry.Children) > 0 {
+			walk(entry.Children, strings.TrimSpace(entry.Title))
+		}
+	}
+	return sections
+}
+
+// periodicalNavPoints groups the navigation points of the articles under their
+// sections; consecutive articles of a section share its navigation point, which
+// leads to the first of them
+func (r *Restructurer) periodicalNavPoints(book *parser.Book, navPoints []ncxNavPoint) []ncxNavPoint {
+	sections := r.articleSections(book)
+	var grouped []ncxNavPoint
+	current := ""
+	for _, navPoint := range navPoints {
+		section := sections[navPoint.Content.Src]
+		if section == "" || section == navPoint.Label.Text {
+			grouped = append(grouped, navPoint)
+			current = ""
+			continue
+		}
+		if section != current {
+			grouped = append(grouped, ncxNavPoint{
+				ID:      fmt.Sprintf("section-%d", len(grouped)+1),
+				Label:   ncxNavLabel{Lang: navPoint.Label.Lang, Text: section},
+				Content: navPoint.Content,
+			})
+			current = section
+		}
+		last := &grouped[len(grouped)-1]
+		last.Children = append(last.Children, navPoint)
+	}
+	return grouped
+}
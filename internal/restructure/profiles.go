@@ -0,0 +1,215 @@
+package restructure
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Profile bundles the output choices for a target reading ecosystem
+type Profile struct {
+	Name string
+	// TranscodeImages converts WEBP/AVIF images to JPEG/PNG
+	TranscodeImages bool
+	// FontFormats lists the font file extensions the devices can load
+	FontFormats []string
+	// NCX includes the EPUB2 toc.ncx
+	NCX bool
+	// EPUB2 writes an EPUB 2.0.1 package and XHTML 1.1 content documents
+	EPUB2 bool
+	// SafeFilenames restricts image and font filenames to lowercase ASCII
+	SafeFilenames bool
+}
+
+// profiles are the device profiles selectable with SetProfile; their CSS restrictions
+// are listed in the capability matrix (cssCapabilities)
+var profiles = map[string]Profile{
+	"kindle": {
+		TranscodeImages: true,
+		FontFormats:     []string{".ttf", ".otf"},
+		NCX:             true,
+		SafeFilenames:   true,
+	},
+	"kobo": {
+		TranscodeImages: true,
+		FontFormats:     []string{".ttf", ".otf", ".woff"},
+		NCX:             true,
+	},
+	"apple": {
+		FontFormats: []string{".ttf", ".otf", ".woff", ".woff2"},
+	},
+	"adobe": {
+		TranscodeImages: true,
+		FontFormats:     []string{".ttf", ".otf"},
+		NCX:             true,
+	},
+	"strict-epub2": {
+		TranscodeImages: true,
+		FontFormats:     []string{".ttf", ".otf"},
+		NCX:             true,
+		EPUB2:           true,
+		SafeFilenames:   true,
+	},
+}
+
+// ActiveProfile is the device profile applied to the output, if any
+var ActiveProfile *Profile
+
+// SetProfile selects a device profile by name; an empty name clears it
+func SetProfile(name string) error {
+	if name == "" {
+		ActiveProfile = nil
+		return nil
+	}
+
+	profile, ok := profiles[name]
+	if !ok {
+		return fmt.Errorf("unknown profile %q (available: %s)", name, strings.Join(ProfileNames(), ", "))
+	}
+	profile.Name = name
+	ActiveProfile = &profile
+
+	if profile.TranscodeImages {
+		CompatibilityProfile = true
+	}
+	return nil
+}
+
+// ProfileNames lists the available device profiles
+func ProfileNames() []string {
+	var names []string
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// fontAllowed reports whether the active profile can load a font format
+func fontAllowed(fontPath string) bool {
+	if ActiveProfile == nil {
+		return true
+	}
+	ext := strings.ToLower(filepath.Ext(fontPath))
+	for _, format := range ActiveProfile.FontFormats {
+		if ext == format {
+			return true
+		}
+	}
+	return false
+}
+
+// unsafeFilenameChars matches characters that are not safe in filenames on every device
+var unsafeFilenameChars = regexp.MustCompile(`[^a-z0-9._-]+`)
+
+// profileFilename returns the output filename of a resource under the active profile
+func profileFilename(filename string) string {
+	if ActiveProfile == nil || !ActiveProfile.SafeFilenames {
+		return filename
+	}
+	return strings.Trim(unsafeFilenameChars.ReplaceAllString(strings.ToLower(filename), "_"), "_")
+}
+
+// finalizeOutput applies the dark-mode styles, CSS linting and the active profile's
+// CSS fallbacks, NCX choice and EPUB2 downgrade to the written book
+func (r *Restructurer) finalizeOutput(oebpsPath string) error {
+	if !cssLintEnabled() && !DarkModeCSS && !vendorCleanup("css-hacks") {
+		return nil
+	}
+	if DebugMode && ActiveProfile != nil {
+		fmt.Printf("📱 Applying %s profile\n", ActiveProfile.Name)
+	}
+
+	err := filepath.Walk(oebpsPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		ext := strings.ToLower(filepath.Ext(path))
+		if ext != ".css" && ext != ".xhtml" {
+			return nil
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", filepath.Base(path), err)
+		}
+		source, _ := filepath.Rel(oebpsPath, path)
+		content := string(data)
+		if ext == ".css" {
+			if vendorCleanup("css-hacks") {
+				content = stripVendorCSS(content)
+			}
+			if DarkModeCSS {
+				content = darkModeStyles(content)
+			}
+			content = lintCSS(content, filepath.ToSlash(source))
+		} else {
+			if DarkModeCSS {
+				content = darkModeStyleBlocks(content)
+			}
+			content = lintXHTMLStyles(content, filepath.ToSlash(source))
+			if ActiveProfile != nil && ActiveProfile.EPUB2 {
+				content = downgradeXHTML(content)
+			}
+		}
+
+		if content == string(data) {
+			return nil
+		}
+		return ioutil.WriteFile(path, []byte(content), 0644)
+	})
+	if err != nil || ActiveProfile == nil {
+		return err
+	}
+
+	opfPath := filepath.Join(oebpsPath, "content.opf")
+	data, err := ioutil.ReadFile(opfPath)
+	if err != nil {
+		return fmt.Errorf("failed to read content.opf: %w", err)
+	}
+	opf := string(data)
+
+	if !ActiveProfile.NCX {
+		if err := os.Remove(filepath.Join(oebpsPath, "toc.ncx")); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove toc.ncx: %w", err)
+		}
+		opf = ncxItemPattern.ReplaceAllString(opf, "")
+		opf = strings.Replace(opf, `<spine toc="ncx"`, `<spine`, 1)
+	}
+	if ActiveProfile.EPUB2 {
+		opf = downgradeOPF(opf)
+	}
+
+	return ioutil.WriteFile(opfPath, []byte(opf), 0644)
+}
+
+// Patterns used to apply profiles to the written files
+var (
+	styleBlockPattern     = regexp.MustCompile(`(?s)<style[^>]*>.*?</style>`)
+	cssBlockPattern       = regexp.MustCompile(`\{[^{}]*\}`)
+	ncxItemPattern        = regexp.MustCompile(`(?m)^\s*<item id="ncx"[^>]*/>\n`)
+	epub3MetaPattern      = regexp.MustCompile(`(?m)^\s*<(?:opf:)?meta [^>]*(?:property|refines)=[^>]*>.*?</(?:opf:)?meta>\n`)
+	propertiesAttrPattern = regexp.MustCompile(` properties="[^"]*"`)
+	epubAttrPattern       = regexp.MustCompile(` (?:xmlns:epub|epub:type|epub:prefix|role)="[^"]*"`)
+	html5ElementPattern   = regexp.MustCompile(`<(/?)(?:section|article|aside|nav|header|footer|figure|figcaption|main)\b`)
+)
+
+// downgradeXHTML turns an EPUB3 content document into XHTML 1.1 by removing EPUB3
+// attributes and replacing HTML5 sectioning elements with divs
+func downgradeXHTML(content string) string {
+	content = epubAttrPattern.ReplaceAllString(content, "")
+	content = html5ElementPattern.ReplaceAllString(content, "<${1}div")
+	return strings.Replace(content, ` hidden="hidden"`, ` style="display: none"`, -1)
+}
+
+// downgradeOPF turns the EPUB3 package document into an EPUB 2.0.1 one
+func downgradeOPF(opf string) string {
+	opf = strings.Replace(opf, `version="3.0"`, `version="2.0"`, 1)
+	opf = epub3MetaPattern.ReplaceAllString(opf, "")
+	return propertiesAttrPattern.ReplaceAllString(opf, "")
+}
@@ -0,0 +1,129 @@
+package restructure
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/flouciel/folian-parser/internal/parser"
+	"github.com/flouciel/folian-parser/internal/policy"
+)
+
+// KeepRenditions carries the renditions other than the processed one into the
+// output unchanged, declared after it in container.xml
+var KeepRenditions bool
+
+// renditionNamespace is the namespace of the container.xml rendition selection
+// attributes
+const renditionNamespace = "http://www.idpf.org/vocab/rendition/#"
+
+// renditionAttributes renders the selection attributes of a rendition
+func renditionAttributes(rendition parser.Rendition) string {
+	var attrs strings.Builder
+	for _, attr := range []struct{ name, value string }{
+		{"label", rendition.Label},
+		{"language", rendition.Language},
+		{"layout", rendition.Layout},
+		{"media", rendition.Media},
+		{"accessMode", rendition.AccessMode},
+	} {
+		if attr.value != "" {
+			fmt.Fprintf(&attrs, ` rendition:%s="%s"`, attr.name, xmlEscape(attr.value))
+		}
+	}
+	return attrs.String()
+}
+
+// renditionFiles lists the OPF and manifest files of a rendition, relative to the
+// EPUB root
+func renditionFiles(book *parser.Book, rendition parser.Rendition) ([]string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(book.Path, filepath.FromSlash(rendition.FullPath)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", rendition.FullPath, err)
+	}
+
+	var pkg struct {
+		Items []struct {
+			Href string `xml:"href,attr"`
+		} `xml:"manifest>item"`
+	}
+	if err := xml.Unmarshal(data, &pkg); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal %s: %w", rendition.FullPath, err)
+	}
+
+	files := []string{rendition.FullPath}
+	for _, item := range pkg.Items {
+		file := path.Join(path.Dir(rendition.FullPath), strings.SplitN(item.Href, "#", 2)[0])
+		if strings.HasPrefix(file, "../") || strings.Contains(item.Href, "://") {
+			continue
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// processRenditions reports the renditions that are dropped, or with KeepRenditions
+// copies them under renditions/N/ and declares them after the processed one
+func (r *Restructurer) processRenditions(book *parser.Book, restructuredPath string) error {
+	if len(book.Renditions) < 2 {
+		return nil
+	}
+	selected := book.Renditions[book.Rendition]
+	if !KeepRenditions {
+		fmt.Printf("ℹ️  Processing rendition %d of %d, %s; the others are dropped (use -keep-renditions to carry them over)\n",
+			book.Rendition+1, len(book.Renditions), selected.Describe())
+		return nil
+	}
+
+	var rootFiles strings.Builder
+	fmt.Fprintf(&rootFiles, "    <rootfile full-path=\"OEBPS/content.opf\" media-type=\"application/oebps-package+xml\"%s/>\n", renditionAttributes(selected))
+	for i, rendition := range book.Renditions {
+		if i == book.Rendition {
+			continue
+		}
+		files, err := renditionFiles(book, rendition)
+		if err != nil {
+			return err
+		}
+
+		// Renditions carried over by an earlier run keep their place
+		prefix := fmt.Sprintf("renditions/%d", i+1)
+		if strings.HasPrefix(rendition.FullPath, "renditions/") {
+			prefix = ""
+		}
+		for _, file := range files {
+			content, err := ioutil.ReadFile(filepath.Join(book.Path, filepath.FromSlash(file)))
+			if err != nil {
+				policy.Warn(policy.KindOther, "Rendition %d is missing %s", i+1, file)
+				continue
+			}
+			target := filepath.Join(restructuredPath, filepath.FromSlash(prefix), filepath.FromSlash(file))
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return fmt.Errorf("failed to create directory for %s: %w", file, err)
+			}
+			if err := ioutil.WriteFile(target, content, 0644); err != nil {
+				return fmt.Errorf("failed to copy %s: %w", file, err)
+			}
+		}
+
+		fmt.Fprintf(&rootFiles, "    <rootfile full-path=\"%s\" media-type=\"application/oebps-package+xml\"%s/>\n",
+			xmlEscape(path.Join(prefix, rendition.FullPath)), renditionAttributes(rendition))
+		if DebugMode {
+			fmt.Printf("📚 Kept rendition %d: %s\n", i+1, rendition.Describe())
+		}
+	}
+
+	containerXML := `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container" xmlns:rendition="` + renditionNamespace + `">
+  <rootfiles>
+` + rootFiles.String() + `  </rootfiles>
+</container>`
+	if err := ioutil.WriteFile(filepath.Join(restructuredPath, "META-INF", "container.xml"), []byte(containerXML), 0644); err != nil {
+		return fmt.Errorf("failed to write container.xml: %w", err)
+	}
+	return nil
+}
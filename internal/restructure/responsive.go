@@ -0,0 +1,128 @@
+package restructure
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html/atom"
+)
+
+// emPixels is the size in pixels of 1em, which fixed image sizes are converted with
+const emPixels = 16.0
+
+// fullBleedPixels is the width from which a block image spans the page
+const fullBleedPixels = 600.0
+
+// inlineImagePixels is the size up to which an image set in running text, such as a
+// glyph or an icon, is sized with the text
+const inlineImagePixels = 48.0
+
+// imageLengthPattern matches a length in pixels or a percentage
+var imageLengthPattern = regexp.MustCompile(`^\s*(\d+(?:\.\d+)?)\s*(px|%)?\s*$`)
+
+// imageStyles are the theme's image styles, added to themes that predate them
+const imageStyles = `
+/* Images */
+img {
+  max-width: 100%;
+  height: auto;
+}
+.full-bleed {
+  margin: 1em 0;
+  text-indent: 0;
+  text-align: center;
+  page-break-inside: avoid;
+}
+.full-bleed img, img.full-bleed {
+  width: 100%;
+}
+`
+
+// makeImagesResponsive replaces the fixed pixel sizes of images by sizes that reflow:
+// images in running text are sized in em with the text, block images get their
+// width in em within the page width the theme caps them to, and images as wide as
+// a page become full-bleed blocks
+func makeImagesResponsive(doc *goquery.Document) int {
+	resized := 0
+	doc.Find("body img").Each(func(i int, s *goquery.Selection) {
+		width, widthUnit := imageLength(s, "width")
+		height, _ := imageLength(s, "height")
+		if width == 0 && height == 0 {
+			return
+		}
+		s.RemoveAttr("width")
+		s.RemoveAttr("height")
+		s.RemoveAttr("style")
+		resized++
+
+		switch {
+		case widthUnit == "%":
+			s.SetAttr("style", fmt.Sprintf("width: %s%%", formatLength(width)))
+		case inRunningText(s) && height > 0 && height <= inlineImagePixels:
+			s.SetAttr("style", fmt.Sprintf("height: %sem", formatLength(height/emPixels)))
+		case inRunningText(s) && height == 0 && width <= inlineImagePixels:
+			s.SetAttr("style", fmt.Sprintf("width: %sem", formatLength(width/emPixels)))
+		case width >= fullBleedPixels:
+			markFullBleed(s)
+		case width > 0:
+			s.SetAttr("style", fmt.Sprintf("width: %sem", formatLength(width/emPixels)))
+		}
+	})
+
+	if DebugMode && resized > 0 {
+		fmt.Printf("📐 Made %d fixed-size images responsive\n", resized)
+	}
+	return resized
+}
+
+// imageLength returns the width or height of an image from its attribute or inline
+// style, in pixels or as a percentage with its unit, or 0 when it has none
+func imageLength(s *goquery.Selection, property string) (float64, string) {
+	value, _ := s.Attr(property)
+	style, _ := s.Attr("style")
+	for _, declaration := range strings.Split(style, ";") {
+		parts := strings.SplitN(declaration, ":", 2)
+		if len(parts) == 2 && strings.EqualFold(strings.TrimSpace(parts[0]), property) {
+			value = parts[1]
+		}
+	}
+	match := imageLengthPattern.FindStringSubmatch(value)
+	if match == nil {
+		return 0, ""
+	}
+	length, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return 0, ""
+	}
+	return length, match[2]
+}
+
+// formatLength formats a length with at most two decimals
+func formatLength(length float64) string {
+	return strings.TrimSuffix(strings.TrimRight(strconv.FormatFloat(length, 'f', 2, 64), "0"), ".")
+}
+
+// inRunningText reports whether an image is set in a block with text of its own
+func inRunningText(s *goquery.Selection) bool {
+	block := s.Closest("p, li, td, th, h1, h2, h3, h4, h5, h6, div")
+	return block.Length() > 0 && strings.TrimSpace(block.Text()) != ""
+}
+
+// markFullBleed makes the block holding an image as wide as a page a full-bleed
+// block, or the image itself when it shares its block
+func markFullBleed(s *goquery.Selection) {
+	if figure := s.Closest("figure"); figure.Length() > 0 {
+		figure.AddClass("full-bleed")
+		return
+	}
+	block := s.Parent()
+	if block.Is("p, div") && isImageBlock(block) {
+		renameNode(block.Nodes[0], "div", atom.Div)
+		block.AddClass("full-bleed")
+		return
+	}
+	s.AddClass("full-bleed")
+}
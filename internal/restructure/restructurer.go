@@ -4,14 +4,22 @@ import (
 	"fmt"
 	"html"
 	"io/ioutil"
+	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/PuerkitoBio/goquery"
+	"github.com/flouciel/folian-parser/internal/events"
 	"github.com/flouciel/folian-parser/internal/parser"
+	"github.com/flouciel/folian-parser/internal/policy"
+	"github.com/flouciel/folian-parser/internal/timing"
+	"github.com/flouciel/folian-parser/internal/version"
+	"github.com/flouciel/folian-parser/internal/xmlfmt"
 )
 
 // FormatDirPath is the path to the format directory containing templates and assets
@@ -23,10 +31,122 @@ var DebugMode bool
 // EnhancedMode enables enhanced processing with intelligent chapter consolidation
 var EnhancedMode bool
 
+// KeepNavigationChapters retains detected navigation chapters during consolidation
+// instead of dropping them and redirecting links to nav.xhtml
+var KeepNavigationChapters bool
+
 // Restructurer handles the restructuring of EPUB content
 type Restructurer struct{
 	// chapterMapping maps original chapter filenames to new chapter filenames
 	chapterMapping map[string]string
+	// mapping records where original files and fragments ended up in the output
+	mapping []MappingEntry
+	// hasPageMap is set when a converted page-map.xml was written
+	hasPageMap bool
+	// pageTemplates lists the Adobe page templates kept in styles/
+	pageTemplates []string
+	// pageList collects the page breaks of the written chapters for the nav page-list
+	pageList []pageListEntry
+	// figureList and tableList collect the captioned figures and tables of the written
+	// chapters for the nav loi and lot
+	figureList []pageListEntry
+	tableList  []pageListEntry
+	// pageNumber and pageWordCount track synthesized page breaks across chapters
+	pageNumber    int
+	pageWordCount int
+	// synthesizePages is set when page breaks are synthesized every PageBreakWords words
+	synthesizePages bool
+	// sourceIdentifier is the original identifier when a new one is forced
+	sourceIdentifier string
+	// droppedChapterIDs lists navigation chapters removed during consolidation
+	droppedChapterIDs []string
+	// droppedFiles maps the original filenames of dropped chapters to the generated
+	// page that replaces them
+	droppedFiles map[string]string
+	// unresolvedLinks collects the links of the chapter being processed that point
+	// at no output file
+	unresolvedLinks []string
+	// chapterFiles are the written chapter files relative to OEBPS, in the order of
+	// the book's chapters; skipped chapters leave gaps in the numbering
+	chapterFiles []string
+	// mathChapters are the written chapter files with MathML, marked in the manifest
+	mathChapters map[string]bool
+	// coverFilename is the filename of the cover in the images directory
+	coverFilename string
+	// hasCoverThumbnail is set when a cover thumbnail was written
+	hasCoverThumbnail bool
+	// imageRenames maps the filenames of transcoded images to their new filenames
+	imageRenames map[string]string
+	// noteDocuments holds the parsed original chapters by filename for copying notes
+	noteDocuments map[string]*goquery.Document
+	// keptAnchors holds the anchors the cleanup keeps: those the book's indexes,
+	// citations and glossaries link to, and with the referenced and slugs ID
+	// policies every anchor the book links to
+	keptAnchors map[string]bool
+	// anchorRenames maps the output chapters to the heading IDs replaced by slugs
+	anchorRenames map[string]map[string]string
+	// altTexts, proposedAltTexts and altTextEntries hold the alt texts of the alt
+	// text file, those proposed by the alt text command and the images without one
+	altTexts         map[string]string
+	proposedAltTexts map[string]string
+	altTextEntries   []AltTextEntry
+	// glossaryTerms holds the glossary terms the text links to
+	glossaryTerms map[string]bool
+	// searchKeys collects the dictionary headwords of the written chapters
+	searchKeys []searchKey
+	// hasSearchKeyMap is set when a dictionary search key map was written
+	hasSearchKeyMap bool
+	// chapterLanguages holds the declared language of the original chapters by ID
+	chapterLanguages map[string]string
+	// multilingual is set when the chapters are in several languages
+	multilingual bool
+	// dictionaries holds the spelling dictionaries loaded, by language, and
+	// autocorrections the autocorrect rules
+	dictionaries    map[string]*spellDictionary
+	autocorrections map[string]string
+	// spellingEntries are the unknown and autocorrected words of the spelling report
+	spellingEntries []SpellingEntry
+	// lexiconTerms holds the house-style lexicon terms by lowercase term, matched by
+	// lexiconPattern, and lexiconEntries the violations found
+	lexiconTerms   map[string]lexiconTerm
+	lexiconPattern *regexp.Regexp
+	lexiconEntries []LexiconEntry
+	// bookLanguage is the language of the book, which chapters without one of their
+	// own are in
+	bookLanguage string
+	// uiLanguage is the language of the generated labels
+	uiLanguage string
+	// customStrings holds the string tables of StringsFile by language
+	customStrings map[string]map[string]string
+	// numbering is how the book writes chapter numbers, capitalized when spelled out
+	// with a capital
+	numbering            numberingScheme
+	numberingCapitalized bool
+	// adChapterIDs lists the advertisement pages removed by StripAds
+	adChapterIDs []string
+	// adBlocksRemoved counts the advertisement blocks removed from chapters
+	adBlocksRemoved int
+	// repeatedBlocks holds the text of blocks repeated across chapters, with the
+	// number of chapters they appear in
+	repeatedBlocks map[string]int
+	// colophonFile is the written colophon, which holds the watermark
+	colophonFile string
+	// lexicons lists the pronunciation lexicons linked from the chapters
+	lexicons []lexicon
+	// overlayTexts holds the paragraphs of the written chapters the narration is
+	// synchronized with, by chapter file
+	overlayTexts map[string][]overlayText
+	// overlays and audioFiles are the written media overlays and the narration
+	// they play, read by narrator
+	overlays   []mediaOverlay
+	audioFiles []string
+	narrator   string
+}
+
+// MappingEntry maps an original file or fragment to its location in the restructured book
+type MappingEntry struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
 }
 
 // NewRestructurer creates a new restructurer
@@ -98,6 +218,11 @@ func (r *Restructurer) processContent(book *parser.Book, restructuredPath string
 	oebpsPath := filepath.Join(restructuredPath, "OEBPS")
 	basePath := filepath.Dir(filepath.Join(book.Path, book.Manifest[book.Spine[0].IDRef].Href))
 
+	// Choose the language of the generated labels
+	if err := r.setupLocalization(book); err != nil {
+		return err
+	}
+
 	// Check if we have a cover image
 	if book.CoverImage == "" {
 		// Look for a cover image in the images directory
@@ -111,11 +236,25 @@ func (r *Restructurer) processContent(book *parser.Book, restructuredPath string
 		}
 	}
 
+	// Fill missing metadata from online catalogues
+	r.fetchMetadata(book, basePath)
+
 	// Process and copy stylesheets
+	stopResources := timing.Start(timing.StageResources)
 	if err := r.processStylesheets(book, basePath, oebpsPath); err != nil {
 		return fmt.Errorf("failed to process stylesheets: %w", err)
 	}
 
+	// Title and date a periodical issue
+	if err := r.preparePeriodical(book, oebpsPath); err != nil {
+		return fmt.Errorf("failed to prepare periodical: %w", err)
+	}
+
+	// Clean up the conventions of the book's source
+	if err := r.prepareSource(book, oebpsPath); err != nil {
+		return fmt.Errorf("failed to prepare source: %w", err)
+	}
+
 	// Copy fonts
 	if err := r.copyFonts(book, basePath, oebpsPath); err != nil {
 		return fmt.Errorf("failed to copy fonts: %w", err)
@@ -126,12 +265,44 @@ func (r *Restructurer) processContent(book *parser.Book, restructuredPath string
 		return fmt.Errorf("failed to process images: %w", err)
 	}
 
+	// Copy the pronunciation lexicons the chapters link to
+	if err := r.processLexicons(book, oebpsPath); err != nil {
+		return fmt.Errorf("failed to process lexicons: %w", err)
+	}
+	stopResources()
+
 	// Process chapters
 	if err := r.processChapters(book, basePath, oebpsPath); err != nil {
 		return fmt.Errorf("failed to process chapters: %w", err)
 	}
 
+	// Synchronize the narration with the chapters
+	if err := r.processMediaOverlays(book, oebpsPath); err != nil {
+		return fmt.Errorf("failed to process media overlays: %w", err)
+	}
+
+	// Name the purchaser of a personalized copy
+	if err := r.applyWatermark(book, oebpsPath); err != nil {
+		return fmt.Errorf("failed to apply watermark: %w", err)
+	}
+
+	// Preserve or convert vendor support files
+	if err := r.processExtras(book, restructuredPath, oebpsPath); err != nil {
+		return fmt.Errorf("failed to process extra files: %w", err)
+	}
+
+	// Carry over or report the other renditions
+	if err := r.processRenditions(book, restructuredPath); err != nil {
+		return fmt.Errorf("failed to process renditions: %w", err)
+	}
+
+	// Write the dictionary search key map
+	if err := r.processSearchKeys(book, oebpsPath); err != nil {
+		return fmt.Errorf("failed to process search keys: %w", err)
+	}
+
 	// Create nav.xhtml
+	stopNavigation := timing.Start(timing.StageNavigation)
 	if err := r.createNavDocument(book, oebpsPath); err != nil {
 		return fmt.Errorf("failed to create nav.xhtml: %w", err)
 	}
@@ -145,6 +316,14 @@ func (r *Restructurer) processContent(book *parser.Book, restructuredPath string
 	if err := r.createTocNCX(book, oebpsPath); err != nil {
 		return fmt.Errorf("failed to create toc.ncx: %w", err)
 	}
+	stopNavigation()
+
+	// Apply output styles and the device profile to the written files
+	stopFinalize := timing.Start(timing.StageFinalize)
+	if err := r.finalizeOutput(oebpsPath); err != nil {
+		return fmt.Errorf("failed to finalize output: %w", err)
+	}
+	stopFinalize()
 
 	return nil
 }
@@ -158,17 +337,34 @@ func (r *Restructurer) processStylesheets(book *parser.Book, basePath, oebpsPath
 		return fmt.Errorf("failed to read stylesheet from format directory: %w", err)
 	}
 
+	// Style figures and images with themes that predate them
+	if !strings.Contains(string(stylesheetContent), "figcaption") {
+		stylesheetContent = append(stylesheetContent, figureStyles...)
+	}
+	if !strings.Contains(string(stylesheetContent), "full-bleed") {
+		stylesheetContent = append(stylesheetContent, imageStyles...)
+	}
+
+	// Apply the house style options over the theme
+	if styles := houseStyles(); styles != "" {
+		stylesheetContent = append(stylesheetContent, styles...)
+		if DebugMode {
+			fmt.Printf("🎨 Applied house style:%s", styles)
+		}
+	}
+
 	// Write the stylesheet
 	stylesPath := filepath.Join(oebpsPath, "styles")
 	if err := ioutil.WriteFile(filepath.Join(stylesPath, "stylesheet.css"), stylesheetContent, 0644); err != nil {
 		return fmt.Errorf("failed to create stylesheet: %w", err)
 	}
+	events.Publish(events.ResourceCopied{Kind: events.ResourceStylesheet, Source: stylesheetPath, Target: "styles/stylesheet.css", Size: len(stylesheetContent)})
 
 	// Copy the Jura font from the format directory
 	fontPath := filepath.Join(FormatDirPath, "jura.ttf")
 	fontData, err := ioutil.ReadFile(fontPath)
 	if err != nil {
-		fmt.Printf("Warning: Could not read Jura font from %s: %v\n", fontPath, err)
+		policy.Warn(policy.KindOther, "Could not read Jura font from %s: %v", fontPath, err)
 		// Continue without the font
 	} else {
 		// Write the font to the fonts directory
@@ -177,9 +373,7 @@ func (r *Restructurer) processStylesheets(book *parser.Book, basePath, oebpsPath
 		if err := ioutil.WriteFile(outputFontPath, fontData, 0644); err != nil {
 			return fmt.Errorf("failed to write Jura font to %s: %w", outputFontPath, err)
 		}
-		if DebugMode {
-			fmt.Printf("✅ Copied Jura font: %s → %s\n", fontPath, outputFontPath)
-		}
+		events.Publish(events.ResourceCopied{Kind: events.ResourceFont, Source: fontPath, Target: "fonts/jura.ttf", Size: len(fontData)})
 	}
 
 	return nil
@@ -206,20 +400,30 @@ func (r *Restructurer) processCalibreStyles(content string) string {
 func (r *Restructurer) copyFonts(book *parser.Book, basePath, oebpsPath string) error {
 	fontsPath := filepath.Join(oebpsPath, "fonts")
 
+	opfDir := filepath.Dir(filepath.Join(book.Path, book.OPFPath))
 	for _, fontPath := range book.Fonts {
-		// Read the font file
-		fullPath := filepath.Join(basePath, fontPath)
+		if !fontAllowed(fontPath) {
+			fmt.Printf("ℹ️  Dropping font %s: not supported by the %s profile\n", filepath.Base(fontPath), ActiveProfile.Name)
+			continue
+		}
+
+		// Read the font file; manifest hrefs are relative to the OPF
+		fullPath := filepath.Join(opfDir, fontPath)
 		content, err := ioutil.ReadFile(fullPath)
+		if err != nil {
+			content, err = ioutil.ReadFile(filepath.Join(basePath, fontPath))
+		}
 		if err != nil {
 			return fmt.Errorf("failed to read font %s: %w", fontPath, err)
 		}
 
 		// Write the font file
-		filename := filepath.Base(fontPath)
+		filename := profileFilename(filepath.Base(fontPath))
 		outputPath := filepath.Join(fontsPath, filename)
 		if err := ioutil.WriteFile(outputPath, content, 0644); err != nil {
 			return fmt.Errorf("failed to write font %s: %w", filename, err)
 		}
+		events.Publish(events.ResourceCopied{Kind: events.ResourceFont, Source: fontPath, Target: "fonts/" + filename, Size: len(content)})
 	}
 
 	return nil
@@ -228,6 +432,7 @@ func (r *Restructurer) copyFonts(book *parser.Book, basePath, oebpsPath string)
 // processImages processes and copies image files
 func (r *Restructurer) processImages(book *parser.Book, basePath, oebpsPath string) error {
 	imagesPath := filepath.Join(oebpsPath, "images")
+	r.imageRenames = make(map[string]string)
 
 	// Process cover image if it exists
 	var coverFilename string
@@ -290,11 +495,23 @@ func (r *Restructurer) processImages(book *parser.Book, basePath, oebpsPath stri
 		}
 
 		// Write the cover image
-		coverFilename = "cover" + filepath.Ext(book.CoverImage)
+		content, coverExt := prepareCover(content, filepath.Ext(book.CoverImage))
+		coverFilename = "cover" + coverExt
+		r.coverFilename = coverFilename
 		outputPath := filepath.Join(imagesPath, coverFilename)
 		if err := ioutil.WriteFile(outputPath, content, 0644); err != nil {
 			return fmt.Errorf("failed to write cover image: %w", err)
 		}
+		events.Publish(events.ResourceCopied{Kind: events.ResourceCover, Source: book.CoverImage, Target: "images/" + coverFilename, Size: len(content)})
+
+		r.hasCoverThumbnail = false
+		if CoverThumbnail {
+			if err := writeCoverThumbnail(content, imagesPath); err != nil {
+				policy.Warn(policy.KindOther, "Skipping cover thumbnail: %v", err)
+			} else {
+				r.hasCoverThumbnail = true
+			}
+		}
 
 		// Create titlepage.xhtml from template
 		titlePagePath := filepath.Join(FormatDirPath, "titlepage.xhtml")
@@ -306,6 +523,7 @@ func (r *Restructurer) processImages(book *parser.Book, basePath, oebpsPath stri
 		// Replace the cover image reference using placeholder
 		titlePageContentStr := string(titlePageContent)
 		titlePageContentStr = strings.Replace(titlePageContentStr, "{{COVER_IMAGE}}", coverFilename, -1)
+		titlePageContentStr = setCoverDimensions(titlePageContentStr, content)
 		titlePageContent = []byte(titlePageContentStr)
 
 		if err := ioutil.WriteFile(filepath.Join(oebpsPath, "titlepage.xhtml"), titlePageContent, 0644); err != nil {
@@ -322,22 +540,24 @@ func (r *Restructurer) processImages(book *parser.Book, basePath, oebpsPath stri
 		// Extract book title and author from metadata
 		title := book.Metadata.Title
 		if title == "" {
-			title = "Book Title"
+			title = r.localize("", "book_title")
 		}
 
 		author := book.Metadata.Creator
 		if author == "" {
-			author = "Author"
+			author = r.localize("", "author")
 		}
 
 		// Replace template variables in jacket.xhtml
 		jacketContentStr := string(jacketContent)
-		jacketContentStr = strings.Replace(jacketContentStr, "{{BOOK_TITLE}}", title, -1)
-		jacketContentStr = strings.Replace(jacketContentStr, "{{BOOK_AUTHOR}}", author, -1)
-
-		// Set a default subtitle or use a description if available
-		subtitle := "A Folian Book"
-		if book.Metadata.Description != "" {
+		jacketContentStr = strings.Replace(jacketContentStr, "{{BOOK_TITLE}}", html.EscapeString(title), -1)
+		jacketContentStr = strings.Replace(jacketContentStr, "{{BOOK_AUTHOR}}", html.EscapeString(author), -1)
+
+		// Set a default subtitle or use a description if available; a description
+		// shown in full as jacket text is not repeated
+		text := loadJacketText(book)
+		subtitle := r.localize("", "subtitle")
+		if book.Metadata.Description != "" && text.Description == "" {
 			// Use a shortened version of the description as subtitle
 			if len(book.Metadata.Description) > 60 {
 				subtitle = book.Metadata.Description[:57] + "..."
@@ -345,7 +565,8 @@ func (r *Restructurer) processImages(book *parser.Book, basePath, oebpsPath stri
 				subtitle = book.Metadata.Description
 			}
 		}
-		jacketContentStr = strings.Replace(jacketContentStr, "{{BOOK_SUBTITLE}}", subtitle, -1)
+		jacketContentStr = strings.Replace(jacketContentStr, "{{BOOK_SUBTITLE}}", html.EscapeString(subtitle), -1)
+		jacketContentStr = applyJacketText(jacketContentStr, text.render(r.localize("", "about_author"), r.localize("", "about_series")))
 
 		jacketContent = []byte(jacketContentStr)
 
@@ -366,12 +587,12 @@ func (r *Restructurer) processImages(book *parser.Book, basePath, oebpsPath stri
 			if err == nil {
 				outputLogoPath := filepath.Join(imagesPath, "folian.png")
 				if err := ioutil.WriteFile(outputLogoPath, folianLogoContent, 0644); err != nil {
-					fmt.Printf("Warning: Failed to copy Folian logo to %s: %v\n", outputLogoPath, err)
-				} else if DebugMode {
-					fmt.Printf("✅ Copied Folian logo: %s → %s\n", folianLogoPath, outputLogoPath)
+					policy.Warn(policy.KindOther, "Failed to copy Folian logo to %s: %v", outputLogoPath, err)
+				} else {
+					events.Publish(events.ResourceCopied{Kind: events.ResourceImage, Source: folianLogoPath, Target: "images/folian.png", Size: len(folianLogoContent)})
 				}
 			} else {
-				fmt.Printf("Warning: Failed to read Folian logo from %s: %v\n", folianLogoPath, err)
+				policy.Warn(policy.KindOther, "Failed to read Folian logo from %s: %v", folianLogoPath, err)
 			}
 		} else if DebugMode {
 			fmt.Printf("ℹ️  Folian logo not found at %s\n", folianLogoPath)
@@ -384,6 +605,9 @@ func (r *Restructurer) processImages(book *parser.Book, basePath, oebpsPath stri
 		if imagePath == book.CoverImage {
 			continue
 		}
+		if r.hasCoverThumbnail && filepath.Base(imagePath) == coverThumbnailFile {
+			continue
+		}
 
 		// Extract the base filename of the image
 		imageBase := filepath.Base(imagePath)
@@ -434,7 +658,7 @@ func (r *Restructurer) processImages(book *parser.Book, basePath, oebpsPath stri
 
 						// If we still can't find the file, log a warning and continue
 						if err != nil {
-							fmt.Printf("Warning: failed to find image %s: %v\n", imagePath, err)
+							policy.Warn(policy.KindOther, "failed to find image %s: %v", imagePath, err)
 							continue
 						}
 					}
@@ -442,12 +666,30 @@ func (r *Restructurer) processImages(book *parser.Book, basePath, oebpsPath stri
 			}
 		}
 
+		// Transcode formats that older readers cannot render
+		original := filepath.Base(imagePath)
+		filename := original
+		if ext := filepath.Ext(filename); CompatibilityProfile && needsTranscoding(ext) {
+			converted, newExt, err := transcodeImage(content, ext)
+			if err != nil {
+				policy.Warn(policy.KindOther, "Keeping %s: %v", filename, err)
+			} else {
+				filename, content = strings.TrimSuffix(filename, ext)+newExt, converted
+				if DebugMode {
+					fmt.Printf("🖼️  Transcoded image: %s → %s\n", original, filename)
+				}
+			}
+		}
+		if filename = profileFilename(filename); filename != original {
+			r.imageRenames[original] = filename
+		}
+
 		// Write the image file
-		filename := filepath.Base(imagePath)
 		outputPath := filepath.Join(imagesPath, filename)
 		if err := ioutil.WriteFile(outputPath, content, 0644); err != nil {
 			return fmt.Errorf("failed to write image %s: %w", filename, err)
 		}
+		events.Publish(events.ResourceCopied{Kind: events.ResourceImage, Source: imagePath, Target: "images/" + filename, Size: len(content)})
 	}
 
 	return nil
@@ -457,16 +699,34 @@ func (r *Restructurer) processImages(book *parser.Book, basePath, oebpsPath stri
 func (r *Restructurer) processChapters(book *parser.Book, basePath, oebpsPath string) error {
 	chaptersPath := filepath.Join(oebpsPath, "chapters")
 
-	// Build chapter mapping for footnote link transformation
-	r.buildChapterMapping(book)
+	// Drop advertisement pages and find watermarks repeated across chapters
+	r.removeAdChapters(book)
+	r.collectRepeatedBlocks(book)
+
+	// Detect bilingual and parallel-text books and the chapter numbering scheme
+	// before titles are cleaned
+	r.detectLanguages(book)
+	r.detectNumbering(book)
 
 	// Use enhanced processing if enabled
 	var chaptersToProcess []parser.Chapter
-	if EnhancedMode {
+	if EnhancedMode && DictionaryMode {
+		if DebugMode {
+			fmt.Printf("📖 Dictionary mode: keeping %d entry files without consolidation\n", len(book.Chapters))
+		}
+		chaptersToProcess = book.Chapters
+	} else if EnhancedMode && PeriodicalKind != "" {
+		if DebugMode {
+			fmt.Printf("📰 Periodical mode: keeping %d articles without consolidation\n", len(book.Chapters))
+		}
+		chaptersToProcess = book.Chapters
+	} else if EnhancedMode {
 		if DebugMode {
 			fmt.Printf("🚀 Enhanced mode: Consolidating %d chapters intelligently\n", len(book.Chapters))
 		}
+		stopConsolidate := timing.Start(timing.StageConsolidate)
 		chaptersToProcess = r.consolidateChapters(book.Chapters)
+		stopConsolidate()
 		if DebugMode {
 			fmt.Printf("📚 Consolidated to %d chapters\n", len(chaptersToProcess))
 		}
@@ -474,100 +734,372 @@ func (r *Restructurer) processChapters(book *parser.Book, basePath, oebpsPath st
 		chaptersToProcess = book.Chapters
 	}
 
-	// Process each chapter
+	// Normalize the characters and the casing of the titles shown in the navigation
+	// and headings
+	r.bookLanguage = book.Metadata.Language
+	r.normalizeTitleText(book, chaptersToProcess)
+	r.normalizeChapterTitles(book, chaptersToProcess)
+
+	// Build chapter mapping for footnote link transformation
+	r.buildChapterMapping(book, chaptersToProcess)
+
+	// Keep the original chapters to map their fragments to the output
+	originalChapters := make(map[string]parser.Chapter)
+	for _, chapter := range book.Chapters {
+		originalChapters[chapter.ID] = chapter
+	}
+	r.mapping = nil
+	r.recordDroppedChapters(book)
+
+	// Prepare page list collection and optional page break synthesis
+	r.resetPages(book)
+	r.figureList, r.tableList = nil, nil
+
+	// Index the original chapters for popup footnotes and keep the index link targets
+	r.loadNoteDocuments(book)
+	r.collectIndexTargets(book)
+	r.collectCitationTargets(book)
+	r.collectGlossaryTargets(book)
+	r.collectLinkedAnchors(book)
+	r.assignHeadingSlugs(chaptersToProcess)
+	r.searchKeys = nil
+	r.colophonFile = ""
+	if err := r.loadAltTexts(); err != nil {
+		return err
+	}
+	if err := r.loadSpelling(); err != nil {
+		return err
+	}
+	if err := r.loadHouseLexicon(); err != nil {
+		return err
+	}
+
+	// Chapters cleaned by previous runs are reused when neither they nor the
+	// options changed
+	cacheContext := r.chapterCacheContext()
+	reused := 0
+
+	// Process each chapter, keeping those actually written
+	var emitted []parser.Chapter
+	var skipped []parser.Chapter
+	redirects := make(map[string]string)
+	r.chapterFiles = nil
+	r.mathChapters = make(map[string]bool)
+	r.overlayTexts = make(map[string][]overlayText)
+	stopClean := timing.Start(timing.StageClean)
 	for i, chapter := range chaptersToProcess {
 		// Use the chapter title from the TOC entries
 		chapterTitle := chapter.Title
 		if chapterTitle == "" {
-			chapterTitle = fmt.Sprintf("Chapter %d", i+1)
+			chapterTitle = r.chapterLabel(r.labelLanguage(chapter), r.formatChapterNumber(i+1))
 		}
 
-		// Create the chapter content using proper HTML parsing
-		processedContent, err := r.createCleanChapterContent(chapterTitle, chapter.Content)
-		if err != nil {
-			if DebugMode {
-				fmt.Printf("⚠️  HTML parsing failed for chapter %d, using basic processing: %v\n", i+1, err)
+		// Create the chapter content, or reuse the cached one
+		r.unresolvedLinks = nil
+		var processedContent string
+		key := chapterCacheKey(cacheContext, chapterTitle, chapter.Content)
+		if cached, ok := loadCachedChapter(key); ok {
+			processedContent, r.unresolvedLinks = cached.Content, cached.UnresolvedLinks
+			reused++
+		} else {
+			processedContent = r.cleanChapter(i+1, chapterTitle, chapter.Content)
+			if err := storeCachedChapter(key, cachedChapter{Content: processedContent, UnresolvedLinks: r.unresolvedLinks}); err != nil && DebugMode {
+				fmt.Printf("⚠️  Could not cache chapter %d: %v\n", i+1, err)
 			}
-			// Fallback to basic processing if HTML parsing fails
-			processedContent = r.createBasicChapterContent(chapterTitle, chapter.Content)
 		}
 
-		// Transform footnote links in the processed content
-		processedContent = r.transformFootnoteLinks(processedContent)
-
 		// Validate the content is not empty
 		if len(strings.TrimSpace(processedContent)) < 100 {
 			if DebugMode {
 				fmt.Printf("⚠️  Chapter %d appears to be empty or too short, skipping\n", i+1)
 			}
+			skipped = append(skipped, chapter)
+			redirects[fmt.Sprintf("chapter_%03d.xhtml", i+1)] = ""
 			continue
 		}
 
-		// Write the processed chapter
+		// Write the processed chapter, giving its images alt text
 		filename := fmt.Sprintf("chapter_%03d.xhtml", i+1)
+		processedContent = r.assistAltText(processedContent, "chapters/"+filename, chapterTitle, oebpsPath)
+
+		// Autocorrect and check the spelling of the chapter in its language, or else
+		// the book's
+		lang := r.chapterLanguages[chapter.ID]
+		if lang == "" {
+			lang = r.bookLanguage
+		}
+		processedContent = r.checkSpelling(processedContent, "chapters/"+filename, lang)
+		processedContent = r.checkHouseLexicon(processedContent, "chapters/"+filename, lang)
 		outputPath := filepath.Join(chaptersPath, filename)
 		if err := ioutil.WriteFile(outputPath, []byte(processedContent), 0644); err != nil {
-			return fmt.Errorf("failed to write chapter %s: %w", filename, err)
+			if err := policy.ChapterError(filename, fmt.Errorf("failed to write chapter %s: %w", filename, err)); err != nil {
+				return err
+			}
+			skipped = append(skipped, chapter)
+			redirects[filename] = ""
+			continue
 		}
+		r.reportChapterWarnings("chapters/"+filename, chapterTitle, processedContent)
 
-		if DebugMode {
-			fmt.Printf("✅ Created chapter: %s (%d chars)\n", filename, len(processedContent))
+		events.Publish(events.ChapterProcessed{Index: i + 1, Total: len(chaptersToProcess), Title: chapterTitle, File: "chapters/" + filename, Size: len(processedContent)})
+		emitted = append(emitted, chapter)
+		r.chapterFiles = append(r.chapterFiles, "chapters/"+filename)
+		if strings.Contains(processedContent, "<math") {
+			r.mathChapters["chapters/"+filename] = true
+		}
+		for skippedFile, target := range redirects {
+			if target == "" {
+				redirects[skippedFile] = "chapters/" + filename
+			}
+		}
+		if r.colophonFile == "" && isColophon(chapter) {
+			r.colophonFile = "chapters/" + filename
+		}
+
+		// Record where the original files and their anchors ended up
+		r.recordMapping(book, originalChapters, chapter, "chapters/"+filename, processedContent)
+
+		// Collect page breaks for the page-list navigation
+		r.collectPageBreaks(processedContent, "chapters/"+filename)
+		r.collectFigures(processedContent, "chapters/"+filename)
+
+		// Collect dictionary headwords for the search key map
+		if DictionaryMode {
+			r.collectSearchKeys(processedContent, "chapters/"+filename)
+		}
+
+		// Collect the paragraphs the narration is synchronized with
+		if AudioDir != "" {
+			r.collectOverlayTexts(processedContent, "chapters/"+filename)
 		}
 	}
 
-	// Update the book's chapters to reflect the processed chapters
-	book.Chapters = chaptersToProcess
+	stopClean()
+	if reused > 0 && DebugMode {
+		fmt.Printf("ℹ️  Reused %d of %d cleaned chapters from the chapter cache\n", reused, len(chaptersToProcess))
+	}
 
-	return nil
+	// Update the book's chapters to reflect the written chapters, pointing the links
+	// to skipped chapters at the chapter that follows them
+	book.Chapters = emitted
+	if err := r.redirectSkippedChapters(book, skipped, redirects, oebpsPath); err != nil {
+		return err
+	}
+	r.reportAds()
+
+	if err := r.writeAltTextReport(); err != nil {
+		return err
+	}
+	if err := r.writeSpellingReport(); err != nil {
+		return err
+	}
+	return r.writeHouseLexiconReport()
+}
+
+// cleanChapter creates the content of an output chapter from its source
+func (r *Restructurer) cleanChapter(chapterNum int, title, content string) string {
+	// Create the chapter content using proper HTML parsing
+	processedContent, err := r.createCleanChapterContent(title, content)
+	if err != nil {
+		if DebugMode {
+			fmt.Printf("⚠️  HTML parsing failed for chapter %d, using basic processing: %v\n", chapterNum, err)
+		}
+		// Fallback to basic processing if HTML parsing fails
+		processedContent = r.createBasicChapterContent(title, content)
+	}
+	processedContent = r.renameImageReferences(processedContent)
+	return r.addLexiconLinks(processedContent)
 }
 
 // buildChapterMapping creates a mapping from original chapter filenames to new chapter filenames
-func (r *Restructurer) buildChapterMapping(book *parser.Book) {
+func (r *Restructurer) buildChapterMapping(book *parser.Book, chapters []parser.Chapter) {
 	// Clear existing mapping
 	r.chapterMapping = make(map[string]string)
+	r.droppedFiles = make(map[string]string)
+
+	// Build mapping for all chapters, including the originals merged into them
+	for i, chapter := range chapters {
+		newFilename := fmt.Sprintf("chapter_%03d.xhtml", i+1)
+		for _, sourceID := range chapterSources(chapter) {
+			// Get the original filename from the manifest
+			if manifestItem, exists := book.Manifest[sourceID]; exists {
+				originalFilename := filepath.Base(manifestItem.Href)
+				r.chapterMapping[originalFilename] = newFilename
+
+				if DebugMode {
+					fmt.Printf("📝 Mapping: %s -> %s\n", originalFilename, newFilename)
+				}
+			}
+		}
+	}
+}
+
+// recordDroppedChapters redirects the dropped navigation chapters to nav.xhtml and
+// the original cover page to the generated title page
+func (r *Restructurer) recordDroppedChapters(book *parser.Book) {
+	if book.CoverPage != "" {
+		r.droppedFiles[filepath.Base(book.CoverPage)] = "titlepage.xhtml"
+		r.mapping = append(r.mapping, MappingEntry{Source: book.CoverPage, Target: "titlepage.xhtml"})
+
+		if DebugMode {
+			fmt.Printf("📝 Redirecting cover page %s -> titlepage.xhtml\n", book.CoverPage)
+		}
+	}
+
+	// Navigation chapters and advertisement pages are replaced by the nav document
+	dropped := append(append([]string{}, r.droppedChapterIDs...), r.adChapterIDs...)
+	for _, id := range dropped {
+		manifestItem, exists := book.Manifest[id]
+		if !exists {
+			continue
+		}
+		r.droppedFiles[filepath.Base(manifestItem.Href)] = "nav.xhtml#toc"
+		r.mapping = append(r.mapping, MappingEntry{Source: manifestItem.Href, Target: "nav.xhtml"})
 
-	// Build mapping for all chapters in the book
-	for i, chapter := range book.Chapters {
-		// Get the original filename from the manifest
-		if manifestItem, exists := book.Manifest[chapter.ID]; exists {
-			originalFilename := filepath.Base(manifestItem.Href)
-			newFilename := fmt.Sprintf("chapter_%03d.xhtml", i+1)
-			r.chapterMapping[originalFilename] = newFilename
+		if DebugMode {
+			fmt.Printf("📝 Redirecting dropped chapter %s -> nav.xhtml\n", manifestItem.Href)
+		}
+	}
+}
+
+// redirectSkippedChapters points the links to chapters that were not written at the
+// chapter that follows them, or at the last written chapter, both in the written
+// chapters and in the mapping
+func (r *Restructurer) redirectSkippedChapters(book *parser.Book, skipped []parser.Chapter, redirects map[string]string, oebpsPath string) error {
+	if len(skipped) == 0 {
+		return nil
+	}
+	fallback := "nav.xhtml"
+	if len(r.chapterFiles) > 0 {
+		fallback = r.chapterFiles[len(r.chapterFiles)-1]
+	}
+	for skippedFile, target := range redirects {
+		if target == "" {
+			redirects[skippedFile] = fallback
+		}
+	}
+
+	// Redirect the original files of the skipped chapters
+	for _, chapter := range skipped {
+		for _, sourceID := range chapterSources(chapter) {
+			manifestItem, exists := book.Manifest[sourceID]
+			if !exists {
+				continue
+			}
+			target := redirects[r.chapterMapping[filepath.Base(manifestItem.Href)]]
+			if target == "" {
+				continue
+			}
+			r.mapping = append(r.mapping, MappingEntry{Source: manifestItem.Href, Target: target})
 
 			if DebugMode {
-				fmt.Printf("📝 Mapping: %s -> %s\n", originalFilename, newFilename)
+				fmt.Printf("📝 Redirecting skipped chapter %s -> %s\n", manifestItem.Href, target)
 			}
 		}
 	}
+	for original, newFilename := range r.chapterMapping {
+		if target, exists := redirects[newFilename]; exists {
+			delete(r.chapterMapping, original)
+			r.droppedFiles[original] = target
+		}
+	}
+
+	// Rewrite the links the written chapters already contain
+	linkPattern := regexp.MustCompile(`"\.\./chapters/(chapter_\d{3}\.xhtml)(?:#[^"]*)?"`)
+	for _, file := range r.chapterFiles {
+		path := filepath.Join(oebpsPath, filepath.FromSlash(file))
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", file, err)
+		}
+		updated := linkPattern.ReplaceAllStringFunc(string(content), func(match string) string {
+			if target, exists := redirects[linkPattern.FindStringSubmatch(match)[1]]; exists {
+				return `"../` + target + `"`
+			}
+			return match
+		})
+		if updated != string(content) {
+			if err := ioutil.WriteFile(path, []byte(updated), 0644); err != nil {
+				return fmt.Errorf("failed to write %s: %w", file, err)
+			}
+		}
+	}
+	return nil
+}
+
+// chapterSources returns the IDs of the original chapters a chapter was built from
+func chapterSources(chapter parser.Chapter) []string {
+	if len(chapter.Sources) > 0 {
+		return chapter.Sources
+	}
+	return []string{chapter.ID}
+}
+
+// recordMapping adds mapping entries for the original files of a written chapter
+// and for every anchor they contained, pointing at the anchor if it survived processing
+func (r *Restructurer) recordMapping(book *parser.Book, originalChapters map[string]parser.Chapter, chapter parser.Chapter, target, processedContent string) {
+	outputIDs := make(map[string]bool)
+	if doc, err := goquery.NewDocumentFromReader(strings.NewReader(processedContent)); err == nil {
+		doc.Find("[id]").Each(func(i int, s *goquery.Selection) {
+			id, _ := s.Attr("id")
+			outputIDs[id] = true
+		})
+	}
+
+	for _, sourceID := range chapterSources(chapter) {
+		manifestItem, exists := book.Manifest[sourceID]
+		if !exists {
+			continue
+		}
+		r.mapping = append(r.mapping, MappingEntry{Source: manifestItem.Href, Target: target})
+
+		original, exists := originalChapters[sourceID]
+		if !exists {
+			continue
+		}
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(original.Content))
+		if err != nil {
+			continue
+		}
+		doc.Find("[id]").Each(func(i int, s *goquery.Selection) {
+			id, _ := s.Attr("id")
+			entry := MappingEntry{Source: manifestItem.Href + "#" + id, Target: target}
+			if id = r.renamedAnchor(filepath.Base(target), id); outputIDs[id] {
+				entry.Target += "#" + id
+			}
+			r.mapping = append(r.mapping, entry)
+		})
+	}
+}
+
+// Mapping returns where the original files and fragments ended up in the last restructured book
+func (r *Restructurer) Mapping() []MappingEntry {
+	return r.mapping
 }
 
 // transformFootnoteLinks transforms footnote links from old chapter references to new ones
 func (r *Restructurer) transformFootnoteLinks(content string) string {
-	// Pattern to match footnote links: href="partXXXX.html#anchor"
-	linkPattern := regexp.MustCompile(`href="([^"]*part[0-9]+\.html)(#[^"]*)"`)
+	// Pattern to match links to other documents: href="file.html#anchor"
+	linkPattern := regexp.MustCompile(`href="([^"#]+\.x?html?(?:#[^"]*)?)"`)
 
 	// Replace all footnote links
 	transformedContent := linkPattern.ReplaceAllStringFunc(content, func(match string) string {
 		// Extract the parts of the match
 		parts := linkPattern.FindStringSubmatch(match)
-		if len(parts) != 3 {
+		if len(parts) != 2 {
 			return match // Return original if parsing fails
 		}
 
-		originalFile := parts[1]
-		anchor := parts[2]
-		filename := filepath.Base(originalFile)
-
-		// Look up the new filename in our mapping
-		if newFilename, exists := r.chapterMapping[filename]; exists {
-			newHref := fmt.Sprintf(`href="../chapters/%s%s"`, newFilename, anchor)
+		// Look up the new location in our mapping
+		if newHref, exists := r.rewriteHref(parts[1]); exists {
 			if DebugMode {
-				fmt.Printf("🔗 Transformed link: %s -> %s\n", match, newHref)
+				fmt.Printf("🔗 Transformed link: %s -> %s\n", parts[1], newHref)
 			}
-			return newHref
+			return fmt.Sprintf(`href="%s"`, newHref)
 		}
 
 		// If no mapping found, return original
+		r.noteUnresolvedLink(parts[1])
 		return match
 	})
 
@@ -583,28 +1115,65 @@ func (r *Restructurer) transformFootnoteLinksInDOM(doc *goquery.Document) {
 			return
 		}
 
-		// Check if this is a footnote link (contains partXXXX.html#)
-		linkPattern := regexp.MustCompile(`^([^#]*part[0-9]+\.html)(#.*)$`)
-		matches := linkPattern.FindStringSubmatch(href)
-
-		if len(matches) == 3 {
-			originalFile := matches[1]
-			anchor := matches[2]
-			filename := filepath.Base(originalFile)
+		// Look up the new location in our mapping
+		if newHref, exists := r.rewriteHref(href); exists {
+			s.SetAttr("href", newHref)
 
-			// Look up the new filename in our mapping
-			if newFilename, exists := r.chapterMapping[filename]; exists {
-				newHref := fmt.Sprintf("../chapters/%s%s", newFilename, anchor)
-				s.SetAttr("href", newHref)
-
-				if DebugMode {
-					fmt.Printf("🔗 DOM transformed link: %s -> %s\n", href, newHref)
-				}
+			if DebugMode {
+				fmt.Printf("🔗 DOM transformed link: %s -> %s\n", href, newHref)
 			}
+		} else {
+			r.noteUnresolvedLink(href)
 		}
 	})
 }
 
+// rewriteHref maps a link to an original chapter file onto its output chapter, or
+// onto the generated page that replaces a dropped chapter
+func (r *Restructurer) rewriteHref(href string) (string, bool) {
+	if strings.Contains(href, "://") || strings.HasPrefix(href, "mailto:") || strings.HasPrefix(href, "#") {
+		return "", false
+	}
+
+	file, anchor := href, ""
+	if i := strings.Index(href, "#"); i >= 0 {
+		file, anchor = href[:i], href[i:]
+	}
+	filename := filepath.Base(decodeHref(file))
+
+	if newFilename, exists := r.chapterMapping[filename]; exists {
+		if anchor != "" {
+			anchor = "#" + r.renamedAnchor(newFilename, anchor[1:])
+		}
+		return fmt.Sprintf("../chapters/%s%s", newFilename, anchor), true
+	}
+	if target, exists := r.droppedFiles[filename]; exists {
+		return "../" + target, true
+	}
+
+	return "", false
+}
+
+// decodeHref decodes the percent-encoded path of an href to the file name it
+// points at, keeping malformed escapes as written
+func decodeHref(href string) string {
+	if decoded, err := url.PathUnescape(href); err == nil {
+		return decoded
+	}
+	return href
+}
+
+// hrefPath percent-encodes a file path for an href attribute (RFC 3986)
+func hrefPath(filePath string) string {
+	return (&url.URL{Path: filePath}).EscapedPath()
+}
+
+// escapeHref percent-encodes a file path for an href attribute, escaping it for XML
+// as well
+func escapeHref(filePath string) string {
+	return html.EscapeString(hrefPath(filePath))
+}
+
 // processChapterContent processes chapter content
 func (r *Restructurer) processChapterContent(content string, chapterNum int) string {
 	// Extract title from content
@@ -684,11 +1253,9 @@ func (r *Restructurer) createBasicChapterTemplate(title string, chapterNum int)
 
 // createContentOPF creates the content.opf file with enhanced EPUB 3.0 metadata
 func (r *Restructurer) createContentOPF(book *parser.Book, oebpsPath string) error {
-	// Generate a unique identifier if missing
-	identifier := book.Metadata.Identifier
-	if identifier == "" {
-		identifier = fmt.Sprintf("folian-%d", len(book.Metadata.Title))
-	}
+	// Preserve the unique identifier, generating a UUID if missing or forced
+	identifier := r.resolveIdentifier(book)
+	book.Metadata.Identifier = identifier
 
 	// Set default language if missing
 	language := book.Metadata.Language
@@ -699,58 +1266,57 @@ func (r *Restructurer) createContentOPF(book *parser.Book, oebpsPath string) err
 	// Get current timestamp for EPUB 3.0 compliance
 	currentTime := time.Now().UTC().Format("2006-01-02T15:04:05Z")
 
-	// Set default date if missing
-	publicationDate := book.Metadata.Date
-	if publicationDate == "" {
-		publicationDate = currentTime
-	}
+	// Normalize the publication date, defaulting to now if missing or invalid
+	publicationDate := resolvePublicationDate(book, currentTime)
 
 	// Enhanced EPUB 3.0 metadata with proper structure
-	opfContent := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
-<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="BookID">
-  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:opf="http://www.idpf.org/2007/opf">
-    <dc:title id="title">%s</dc:title>
-    <dc:creator id="creator">%s</dc:creator>
-    <dc:language>%s</dc:language>
-    <dc:identifier id="BookID">%s</dc:identifier>
-    <dc:publisher>%s</dc:publisher>
-    <dc:description>%s</dc:description>
-    <dc:date>%s</dc:date>
-    <meta name="cover" content="cover-image"/>
-    <meta property="dcterms:modified">%s</meta>
-    <meta name="generator">Folian Parser v0.2.4</meta>
-    <opf:meta refines="#title" property="title-type">main</opf:meta>
-    <opf:meta refines="#title" property="file-as">%s</opf:meta>
-    <opf:meta refines="#creator" property="role" scheme="marc:relators">aut</opf:meta>
-    <opf:meta refines="#creator" property="file-as">%s</opf:meta>
-  </metadata>
-  <manifest>
-`,
-		html.EscapeString(book.Metadata.Title),
-		html.EscapeString(book.Metadata.Creator),
-		language,
-		identifier,
-		html.EscapeString(book.Metadata.Publisher),
-		html.EscapeString(book.Metadata.Description),
-		publicationDate,
-		currentTime,
-		html.EscapeString(book.Metadata.Title),
-		html.EscapeString(book.Metadata.Creator))
+	opf := opfPackage{
+		Xmlns:            "http://www.idpf.org/2007/opf",
+		Version:          "3.0",
+		UniqueIdentifier: "BookID",
+		Metadata: opfMetadata{
+			XmlnsDC:  "http://purl.org/dc/elements/1.1/",
+			XmlnsOPF: "http://www.idpf.org/2007/opf",
+			Elements: []metaElement{
+				newMeta("dc:title", book.Metadata.Title, "id", "title"),
+				newMeta("dc:creator", book.Metadata.Creator, "id", "creator"),
+				newMeta("dc:language", language),
+				newMeta("dc:identifier", identifier, "id", "BookID"),
+				newMeta("dc:publisher", book.Metadata.Publisher),
+				newMeta("dc:description", book.Metadata.Description),
+				newMeta("meta", "", "name", "cover", "content", "cover-image"),
+				newMeta("meta", currentTime, "property", "dcterms:modified"),
+				newMeta("meta", "Folian Parser v"+version.Version, "name", "generator"),
+				newMeta("opf:meta", "main", "refines", "#title", "property", "title-type"),
+				newMeta("opf:meta", book.Metadata.Title, "refines", "#title", "property", "file-as"),
+				newMeta("opf:meta", "aut", "refines", "#creator", "property", "role", "scheme", "marc:relators"),
+				newMeta("opf:meta", book.Metadata.Creator, "refines", "#creator", "property", "file-as"),
+			},
+		},
+	}
+	if publicationDate != "" {
+		opf.Metadata.Elements = append(opf.Metadata.Elements, newMeta("dc:date", publicationDate))
+	}
+	opf.Metadata.Elements = append(opf.Metadata.Elements, r.buildExtraMetadata(book)...)
+	opf.Metadata.Elements = append(opf.Metadata.Elements, r.overlayMetadata()...)
+	vendorMetas, prefix := r.vendorMetadata(book)
+	opf.Metadata.Elements = append(opf.Metadata.Elements, vendorMetas...)
+	opf.Prefix = prefix
 
 	// Add items to manifest
-	manifestItems := []string{
-		`    <item id="ncx" href="toc.ncx" media-type="application/x-dtbncx+xml"/>`,
-		`    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>`,
+	manifestItems := []opfItem{
+		{ID: "ncx", Href: "toc.ncx", MediaType: "application/x-dtbncx+xml"},
+		{ID: "nav", Href: "nav.xhtml", MediaType: "application/xhtml+xml", Properties: "nav"},
 	}
 
 	// Add titlepage and jacket
 	hasCover := book.CoverImage != ""
 	if hasCover {
-		manifestItems = append(manifestItems, `    <item id="titlepage" href="titlepage.xhtml" media-type="application/xhtml+xml" properties="svg"/>`)
-		manifestItems = append(manifestItems, `    <item id="jacket" href="jacket.xhtml" media-type="application/xhtml+xml"/>`)
+		manifestItems = append(manifestItems, opfItem{ID: "titlepage", Href: "titlepage.xhtml", MediaType: "application/xhtml+xml", Properties: "svg"})
+		manifestItems = append(manifestItems, opfItem{ID: "jacket", Href: "jacket.xhtml", MediaType: "application/xhtml+xml"})
 
 		// Determine correct media type for cover image
-		ext := strings.ToLower(filepath.Ext(book.CoverImage))
+		ext := strings.ToLower(filepath.Ext(r.coverFilename))
 		mediaType := "image/jpeg" // Default
 		if ext == ".png" {
 			mediaType = "image/png"
@@ -760,52 +1326,82 @@ func (r *Restructurer) createContentOPF(book *parser.Book, oebpsPath string) err
 			mediaType = "image/gif"
 		} else if ext == ".webp" {
 			mediaType = "image/webp"
+		} else if ext == ".avif" {
+			mediaType = "image/avif"
 		}
 
-		manifestItems = append(manifestItems, fmt.Sprintf(`    <item id="cover-image" href="images/cover%s" media-type="%s" properties="cover-image"/>`,
-			filepath.Ext(book.CoverImage), mediaType))
+		manifestItems = append(manifestItems, opfItem{ID: "cover-image", Href: "images/" + hrefPath(r.coverFilename), MediaType: mediaType, Properties: "cover-image"})
+		if r.hasCoverThumbnail {
+			manifestItems = append(manifestItems, opfItem{ID: "cover-thumbnail", Href: "images/" + coverThumbnailFile, MediaType: "image/jpeg"})
+		}
 
 		// Add Folian logo if it exists
 		folianLogoPath := filepath.Join(FormatDirPath, "folian.png")
 		if _, err := os.Stat(folianLogoPath); err == nil {
-			manifestItems = append(manifestItems, `    <item id="folian-logo" href="images/folian.png" media-type="image/png"/>`)
+			manifestItems = append(manifestItems, opfItem{ID: "folian-logo", Href: "images/folian.png", MediaType: "image/png"})
 		}
 	}
 
 	// Add stylesheets
-	manifestItems = append(manifestItems, `    <item id="stylesheet" href="styles/stylesheet.css" media-type="text/css"/>`)
+	manifestItems = append(manifestItems, opfItem{ID: "stylesheet", Href: "styles/stylesheet.css", MediaType: "text/css"})
+	manifestItems = append(manifestItems, r.pageTemplateItems()...)
 	//for i, stylesheet := range book.Stylesheets {
 	//	manifestItems = append(manifestItems, fmt.Sprintf(`    <item id="style%d" href="styles/%s" media-type="text/css"/>`, i+1, filepath.Base(stylesheet)))
 	//}
 
-	// Add chapters
-	for i := range book.Chapters {
-		manifestItems = append(manifestItems, fmt.Sprintf(`    <item id="chapter%d" href="chapters/chapter_%03d.xhtml" media-type="application/xhtml+xml"/>`, i+1, i+1))
+	// Add chapters with their media overlays
+	overlayItems, chapterOverlays := r.overlayItems()
+	for i, file := range r.chapterFiles {
+		item := opfItem{ID: fmt.Sprintf("chapter%d", i+1), Href: file, MediaType: "application/xhtml+xml", MediaOverlay: chapterOverlays[file]}
+		if r.mathChapters[file] {
+			item.Properties = "mathml"
+		}
+		manifestItems = append(manifestItems, item)
 	}
+	manifestItems = append(manifestItems, overlayItems...)
 
-	// Add images
-	for i, imagePath := range book.Images {
+	// Add images, ordered by output filename so that renamed images keep their IDs
+	var imageFilenames []string
+	for _, imagePath := range book.Images {
+		// The Folian logo from a previous run is already listed above
+		if hasCover && filepath.Base(imagePath) == "folian.png" {
+			continue
+		}
+		// The thumbnail from a previous run is regenerated from the cover
+		if r.hasCoverThumbnail && filepath.Base(imagePath) == coverThumbnailFile {
+			continue
+		}
 		if imagePath != book.CoverImage {
-			ext := strings.ToLower(filepath.Ext(imagePath))
-			mediaType := "image/jpeg" // Default
-			if ext == ".png" {
-				mediaType = "image/png"
-			} else if ext == ".jpg" || ext == ".jpeg" {
-				mediaType = "image/jpeg"
-			} else if ext == ".gif" {
-				mediaType = "image/gif"
-			} else if ext == ".webp" {
-				mediaType = "image/webp"
-			} else if ext == ".svg" {
-				mediaType = "image/svg+xml"
-			}
-			manifestItems = append(manifestItems, fmt.Sprintf(`    <item id="image%d" href="images/%s" media-type="%s"/>`, i+1, filepath.Base(imagePath), mediaType))
+			imageFilenames = append(imageFilenames, r.outputImageName(imagePath))
 		}
 	}
+	sort.Strings(imageFilenames)
+
+	for i, filename := range imageFilenames {
+		ext := strings.ToLower(filepath.Ext(filename))
+		mediaType := "image/jpeg" // Default
+		if ext == ".png" {
+			mediaType = "image/png"
+		} else if ext == ".jpg" || ext == ".jpeg" {
+			mediaType = "image/jpeg"
+		} else if ext == ".gif" {
+			mediaType = "image/gif"
+		} else if ext == ".webp" {
+			mediaType = "image/webp"
+		} else if ext == ".avif" {
+			mediaType = "image/avif"
+		} else if ext == ".svg" {
+			mediaType = "image/svg+xml"
+		}
+		manifestItems = append(manifestItems, opfItem{ID: fmt.Sprintf("image%d", i+1), Href: "images/" + hrefPath(filename), MediaType: mediaType})
+	}
 
 	// Add fonts with correct EPUB 3.0 media types
-	manifestItems = append(manifestItems, `    <item id="jura-font" href="fonts/jura.ttf" media-type="application/vnd.ms-opentype"/>`)
+	manifestItems = append(manifestItems, opfItem{ID: "jura-font", Href: "fonts/jura.ttf", MediaType: "application/vnd.ms-opentype"})
 	for i, fontPath := range book.Fonts {
+		if !fontAllowed(fontPath) {
+			continue
+		}
 		ext := strings.ToLower(filepath.Ext(fontPath))
 		mediaType := "application/vnd.ms-opentype" // Default for TTF/OTF
 		if ext == ".ttf" || ext == ".otf" {
@@ -815,34 +1411,53 @@ func (r *Restructurer) createContentOPF(book *parser.Book, oebpsPath string) err
 		} else if ext == ".woff2" {
 			mediaType = "font/woff2"
 		}
-		manifestItems = append(manifestItems, fmt.Sprintf(`    <item id="font%d" href="fonts/%s" media-type="%s"/>`, i+1, filepath.Base(fontPath), mediaType))
+		manifestItems = append(manifestItems, opfItem{ID: fmt.Sprintf("font%d", i+1), Href: "fonts/" + hrefPath(profileFilename(filepath.Base(fontPath))), MediaType: mediaType})
 	}
 
-	// Add manifest items to OPF
-	opfContent += strings.Join(manifestItems, "\n") + "\n  </manifest>\n  <spine toc=\"ncx\">\n"
+	// Add the dictionary search key map
+	if r.hasSearchKeyMap {
+		manifestItems = append(manifestItems, opfItem{ID: "search-key-map", Href: "search-key-map.xml", MediaType: searchKeyMapMediaType, Properties: "search-key-map"})
+	}
 
-	// Add spine items
-	spineItems := []string{}
+	// Add the pronunciation lexicons
+	for i, lex := range r.lexicons {
+		manifestItems = append(manifestItems, opfItem{ID: fmt.Sprintf("lexicon%d", i+1), Href: "lexicons/" + lex.filename, MediaType: lexiconMediaType})
+	}
+
+	// Add the converted page-map
+	opf.Spine.Toc = "ncx"
+	if r.hasPageMap {
+		manifestItems = append(manifestItems, opfItem{ID: "page-map", Href: "page-map.xml", MediaType: "application/oebps-page-map+xml"})
+		opf.Spine.PageMap = "page-map"
+	}
+	opf.Manifest = manifestItems
 
 	// Add titlepage and jacket to spine
 	if hasCover {
-		spineItems = append(spineItems, `    <itemref idref="titlepage"/>`)
-		spineItems = append(spineItems, `    <itemref idref="jacket"/>`)
+		opf.Spine.Itemrefs = append(opf.Spine.Itemrefs, opfItemref{IDRef: "titlepage"}, opfItemref{IDRef: "jacket"})
 	}
 
 	// Add nav document to spine
-	spineItems = append(spineItems, `    <itemref idref="nav"/>`)
+	opf.Spine.Itemrefs = append(opf.Spine.Itemrefs, opfItemref{IDRef: "nav"})
 
 	// Add chapters to spine
 	for i := range book.Chapters {
-		spineItems = append(spineItems, fmt.Sprintf(`    <itemref idref="chapter%d"/>`, i+1))
+		itemref := opfItemref{IDRef: fmt.Sprintf("chapter%d", i+1)}
+		if book.Chapters[i].NonLinear {
+			itemref.Linear = "no"
+		}
+		opf.Spine.Itemrefs = append(opf.Spine.Itemrefs, itemref)
 	}
 
-	// Add spine items to OPF
-	opfContent += strings.Join(spineItems, "\n") + "\n  </spine>\n</package>"
+	// Add the guide for EPUB2 reading systems
+	opf.Guide = r.buildGuide(book)
 
 	// Write the OPF file
-	return ioutil.WriteFile(filepath.Join(oebpsPath, "content.opf"), []byte(opfContent), 0644)
+	opfContent, err := marshalXML(opf, "")
+	if err != nil {
+		return fmt.Errorf("failed to render content.opf: %w", err)
+	}
+	return ioutil.WriteFile(filepath.Join(oebpsPath, "content.opf"), opfContent, 0644)
 }
 
 // createNavDocument creates the nav.xhtml file for EPUB3 navigation
@@ -856,19 +1471,50 @@ func (r *Restructurer) createNavDocument(book *parser.Book, oebpsPath string) er
 
 	// Replace book title
 	navContent := string(navTemplate)
-	navContent = strings.Replace(navContent, "{{BOOK_TITLE}}", book.Metadata.Title, -1)
+	navContent = strings.Replace(navContent, "{{BOOK_TITLE}}", html.EscapeString(book.Metadata.Title), -1)
+	navContent = strings.Replace(navContent, "{{TOC_TITLE}}", html.EscapeString(r.localize("", "table_of_contents")), -1)
 
 	// Generate TOC entries
-	var tocEntries strings.Builder
-
-	// Add chapters to TOC
-	for i, chapter := range book.Chapters {
-		chapterPath := fmt.Sprintf("chapters/chapter_%03d.xhtml", i+1)
-		tocEntries.WriteString(fmt.Sprintf("<li><a href=\"%s\">%s</a></li>\n", chapterPath, chapter.Title))
+	tocEntries, err := marshalFragment(navLinks(r.chapterNavPoints(book)))
+	if err != nil {
+		return fmt.Errorf("failed to render nav.xhtml: %w", err)
 	}
 
 	// Replace TOC entries placeholder
-	navContent = strings.Replace(navContent, "{{TOC_ENTRIES}}", tocEntries.String(), -1)
+	navContent = strings.Replace(navContent, "{{TOC_ENTRIES}}", tocEntries, -1)
+
+	// Add the lists of figures and tables, before the page-list if the template has
+	// no placeholder for them
+	figureLists, err := r.figureListNavs()
+	if err != nil {
+		return fmt.Errorf("failed to render nav.xhtml: %w", err)
+	}
+	if strings.Contains(navContent, "{{FIGURE_LISTS}}") {
+		navContent = strings.Replace(navContent, "{{FIGURE_LISTS}}", figureLists, -1)
+	} else if strings.Contains(navContent, "{{PAGE_LIST}}") {
+		navContent = strings.Replace(navContent, "{{PAGE_LIST}}", figureLists+"{{PAGE_LIST}}", 1)
+	} else if figureLists != "" {
+		navContent = strings.Replace(navContent, "</body>", figureLists+"</body>", 1)
+	}
+
+	// Add the page-list, inserting it before </body> if the template has no placeholder
+	pageList, err := r.pageListNav()
+	if err != nil {
+		return fmt.Errorf("failed to render nav.xhtml: %w", err)
+	}
+	if strings.Contains(navContent, "{{PAGE_LIST}}") {
+		navContent = strings.Replace(navContent, "{{PAGE_LIST}}", pageList, -1)
+	} else if pageList != "" {
+		navContent = strings.Replace(navContent, "</body>", pageList+"</body>", 1)
+	}
+
+	// Lay the document out canonically; a template that is not well-formed is
+	// written as it is
+	if formatted, err := xmlfmt.Format([]byte(navContent), true); err == nil {
+		navContent = string(formatted)
+	} else {
+		policy.Warn(policy.KindOther, "nav.xhtml template is not well-formed XML: %v", err)
+	}
 
 	// Write the nav.xhtml file
 	navPath := filepath.Join(oebpsPath, "nav.xhtml")
@@ -894,15 +1540,35 @@ func (r *Restructurer) consolidateChapters(chapters []parser.Chapter) []parser.C
 
 	var consolidated []parser.Chapter
 	var currentChapter *parser.Chapter
+	r.droppedChapterIDs = nil
 	const minChapterLength = 800 // Minimum characters for a standalone chapter
 	const maxChapterLength = 15000 // Maximum characters before forcing a split
 
 	for _, chapter := range chapters {
 		contentLength := len(strings.TrimSpace(chapter.Content))
 
-		// Check if this looks like a table of contents or navigation page
-		if r.isNavigationChapter(chapter) {
-			// Skip navigation chapters in consolidation
+		// Check if this looks like a table of contents or navigation page; indexes,
+		// bibliographies and glossaries are link-dense too but are kept
+		isIndex := isIndexChapter(chapter.Title, chapter.Content) || isBibliographyChapter(chapter.Title, chapter.Content) ||
+			isGlossaryChapter(chapter.Title, chapter.Content)
+		isNavigation := !isIndex && r.isNavigationChapter(chapter)
+		if isNavigation && !KeepNavigationChapters {
+			// Skip navigation chapters in consolidation; links to them are redirected to nav.xhtml
+			r.droppedChapterIDs = append(r.droppedChapterIDs, chapter.ID)
+			continue
+		}
+
+		// Keep non-linear documents, indexes, bibliographies, glossaries and retained
+		// navigation chapters standalone
+		if chapter.NonLinear || isNavigation || isIndex {
+			if currentChapter != nil {
+				consolidated = append(consolidated, *currentChapter)
+				currentChapter = nil
+			}
+			newChapter := chapter
+			newChapter.Title = r.cleanChapterTitle(chapter.Title, len(consolidated)+1, r.labelLanguage(chapter))
+			newChapter.Sources = []string{chapter.ID}
+			consolidated = append(consolidated, newChapter)
 			continue
 		}
 
@@ -912,6 +1578,7 @@ func (r *Restructurer) consolidateChapters(chapters []parser.Chapter) []parser.C
 			if len(currentChapter.Content) + contentLength < maxChapterLength {
 				// Merge with current chapter
 				currentChapter.Content += "\n\n" + chapter.Content
+				currentChapter.Sources = append(currentChapter.Sources, chapter.ID)
 				// Update title if the current one is generic or less descriptive
 				if r.isBetterTitle(chapter.Title, currentChapter.Title) {
 					currentChapter.Title = chapter.Title
@@ -922,7 +1589,8 @@ func (r *Restructurer) consolidateChapters(chapters []parser.Chapter) []parser.C
 					consolidated = append(consolidated, *currentChapter)
 				}
 				newChapter := chapter
-				newChapter.Title = r.cleanChapterTitle(chapter.Title, len(consolidated)+1)
+				newChapter.Title = r.cleanChapterTitle(chapter.Title, len(consolidated)+1, r.labelLanguage(chapter))
+				newChapter.Sources = []string{chapter.ID}
 				currentChapter = &newChapter
 			}
 		} else {
@@ -933,7 +1601,8 @@ func (r *Restructurer) consolidateChapters(chapters []parser.Chapter) []parser.C
 
 			newChapter := chapter
 			// Clean up the title
-			newChapter.Title = r.cleanChapterTitle(chapter.Title, len(consolidated)+1)
+			newChapter.Title = r.cleanChapterTitle(chapter.Title, len(consolidated)+1, r.labelLanguage(chapter))
+			newChapter.Sources = []string{chapter.ID}
 			currentChapter = &newChapter
 		}
 	}
@@ -951,7 +1620,7 @@ func (r *Restructurer) cleanupChapterTitles(chapters []parser.Chapter) []parser.
 	cleaned := make([]parser.Chapter, len(chapters))
 	for i, chapter := range chapters {
 		cleaned[i] = chapter
-		cleaned[i].Title = r.cleanChapterTitle(chapter.Title, i+1)
+		cleaned[i].Title = r.cleanChapterTitle(chapter.Title, i+1, r.labelLanguage(chapter))
 	}
 	return cleaned
 }
@@ -1000,8 +1669,9 @@ func (r *Restructurer) isNavigationChapter(chapter parser.Chapter) bool {
 	return false
 }
 
-// cleanChapterTitle cleans and standardizes chapter titles
-func (r *Restructurer) cleanChapterTitle(title string, chapterNum int) string {
+// cleanChapterTitle cleans and standardizes chapter titles, labelling generic ones
+// in the given language
+func (r *Restructurer) cleanChapterTitle(title string, chapterNum int, lang string) string {
 	title = strings.TrimSpace(title)
 	title = html.UnescapeString(title)
 
@@ -1019,11 +1689,12 @@ func (r *Restructurer) cleanChapterTitle(title string, chapterNum int) string {
 		}
 	}
 
-	// If title is just a number or very generic, create a better title
-	if matched, _ := regexp.MatchString(`^\d+$`, title); matched {
-		title = fmt.Sprintf("Chương %s", title)
+	// If title is just a number or very generic, create a better title, numbered the
+	// way the book numbers its chapters
+	if n := bareNumber(title); n > 0 {
+		title = r.chapterLabel(lang, r.formatChapterNumber(n))
 	} else if title == "" || strings.ToLower(title) == "untitled" {
-		title = fmt.Sprintf("Chương %d", chapterNum)
+		title = r.chapterLabel(lang, r.formatChapterNumber(chapterNum))
 	}
 
 	return title
@@ -1037,6 +1708,41 @@ func (r *Restructurer) createCleanChapterContent(title, content string) (string,
 		return "", err
 	}
 
+	// Remove advertisements and watermarks before the layout is normalized
+	r.stripAdBlocks(doc, title)
+
+	// Remove the markup of the Kobo and Kindle stores
+	stripVendorMarkup(doc)
+
+	// Repair mojibake and normalize the characters and spacing of the text, in the
+	// chapter's language or else the book's
+	if NormalizeText {
+		lang := chapterLanguage(content)
+		if lang == "" {
+			lang = r.bookLanguage
+		}
+		normalizeText(doc, lang)
+	}
+
+	// Take the byline of an article, set under its heading, before its opening
+	// paragraph is marked
+	byline := ""
+	if PeriodicalKind != "" {
+		byline = extractByline(doc)
+	}
+
+	// Keep verse, block quotations, scene breaks, chapter openings and drop caps
+	// as theme classes
+	preserveLayout(doc)
+	markFigures(doc)
+	makeImagesResponsive(doc)
+	markTypography(doc)
+
+	// Give dictionary entries IDs for the search key map
+	if DictionaryMode {
+		markDictionaryEntries(doc)
+	}
+
 	// Remove publisher-specific elements and classes
 	doc.Find("*").Each(func(i int, s *goquery.Selection) {
 		// Remove publisher-specific classes but keep semantic ones
@@ -1060,8 +1766,9 @@ func (r *Restructurer) createCleanChapterContent(title, content string) (string,
 			}
 		}
 
-		// Remove publisher-specific IDs
-		if id, exists := s.Attr("id"); exists {
+		// Remove publisher-specific IDs unless an index links to them or they
+		// identify dictionary entries
+		if id, exists := s.Attr("id"); exists && !r.keptAnchors[id] && !DictionaryMode {
 			lowerID := strings.ToLower(id)
 			if strings.Contains(lowerID, "calibre") ||
 			   strings.Contains(lowerID, "toc") ||
@@ -1070,26 +1777,87 @@ func (r *Restructurer) createCleanChapterContent(title, content string) (string,
 			}
 		}
 
-		// Remove unnecessary style attributes
-		s.RemoveAttr("style")
+		// Remove unnecessary style attributes, keeping table and verse layout
+		if style := preservedStyle(s); style != "" {
+			s.SetAttr("style", style)
+		} else {
+			s.RemoveAttr("style")
+		}
 	})
 
-	// Remove empty divs and spans
+	// Give the captioned figures and tables IDs for the lists of figures and tables
+	if FigureLists {
+		numberFigures(doc)
+	}
+
+	// Remove empty divs and spans, keeping anchors, page breaks and verse spacing
 	doc.Find("div, span").Each(func(i int, s *goquery.Selection) {
+		if _, hasID := s.Attr("id"); hasID || isPageBreak(s) || inPreservedLayout(s) {
+			return
+		}
 		if strings.TrimSpace(s.Text()) == "" && s.Children().Length() == 0 {
 			s.Remove()
 		}
 	})
 
-	// Transform footnote links
+	// Synthesize page breaks for books without print page numbers
+	if r.synthesizePages {
+		r.insertPageBreaks(doc)
+	}
+
+	// Convert footnote references to popups and transform footnote links
+	r.convertFootnotes(doc)
 	r.transformFootnoteLinksInDOM(doc)
+	r.markGlossaryRefs(doc)
+
+	// Remove all existing headings to avoid duplicates, keeping the letter group
+	// headings of an index and glossary and the sections of a bibliography; the
+	// kept anchor of the title heading moves to the chapter heading
+	titleID := ""
+	if isIndexChapter(title, content) {
+		keepIndexHeadings(doc, title)
+	} else if isBibliographyChapter(title, content) {
+		markBibliography(doc, title)
+	} else if isGlossaryChapter(title, content) {
+		markGlossary(doc, title)
+	} else if DictionaryMode {
+		// Entry headwords are often headings
+		removeTitleHeading(doc, title)
+	} else {
+		headingCount := doc.Find("h1, h2, h3, h4, h5, h6").Length()
+		if DebugMode && headingCount > 0 {
+			fmt.Printf("🧹 Removing %d existing headings from '%s' to avoid duplicates\n", headingCount, title)
+		}
+		doc.Find("h1, h2, h3, h4, h5, h6").Each(func(i int, s *goquery.Selection) {
+			id, exists := s.Attr("id")
+			if !exists || !r.keptAnchors[id] {
+				return
+			}
+			if titleID == "" && AudioDir == "" && strings.EqualFold(strings.TrimSpace(s.Text()), strings.TrimSpace(title)) {
+				titleID = id
+				return
+			}
+			s.BeforeHtml(fmt.Sprintf(`<span id="%s"></span>`, html.EscapeString(id)))
+		}).Remove()
+	}
 
-	// Remove all existing headings to avoid duplicates
-	headingCount := doc.Find("h1, h2, h3, h4, h5, h6").Length()
-	if DebugMode && headingCount > 0 {
-		fmt.Printf("🧹 Removing %d existing headings from '%s' to avoid duplicates\n", headingCount, title)
+	// Wrap sentences in spans for read-aloud systems
+	if TTSMode {
+		count := markSentences(doc)
+		if DebugMode {
+			fmt.Printf("🗣️  Marked %d sentences in '%s'\n", count, title)
+		}
+	}
+
+	// Name the paragraphs the narration is synchronized with
+	headingID := ""
+	if titleID != "" {
+		headingID = fmt.Sprintf(` id="%s"`, html.EscapeString(titleID))
+	}
+	if AudioDir != "" {
+		markOverlayParagraphs(doc)
+		headingID = fmt.Sprintf(` id="%s"`, overlayTitleID)
 	}
-	doc.Find("h1, h2, h3, h4, h5, h6").Remove()
 
 	// Extract the body content
 	bodyContent, err := doc.Find("body").Html()
@@ -1097,13 +1865,19 @@ func (r *Restructurer) createCleanChapterContent(title, content string) (string,
 		// Try to get all content if body is not found
 		bodyContent, _ = doc.Html()
 	}
+	bodyContent = strings.TrimSpace(bodyContent)
 
-	// Create the final chapter structure with a single clean heading
+	// Create the final chapter structure with a single clean heading; the
+	// articles of a periodical are marked as such
 	if DebugMode {
 		fmt.Printf("➕ Adding clean heading for '%s'\n", title)
 	}
+	articleOpen, articleClose := "", ""
+	if PeriodicalKind != "" {
+		articleOpen, articleClose = "<article>\n  ", "</article>\n"
+	}
 	cleanContent := fmt.Sprintf(`<?xml version='1.0' encoding='utf-8'?>
-<html xmlns="http://www.w3.org/1999/xhtml">
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops"%s>
 
 <head>
   <title>%s</title>
@@ -1111,15 +1885,15 @@ func (r *Restructurer) createCleanChapterContent(title, content string) (string,
 </head>
 
 <body>
-  <h1>%s</h1>
-
+  %s<h1%s>%s</h1>
+%s
 %s
 
-</body>
+%s</body>
 
-</html>`, html.EscapeString(title), html.EscapeString(title), bodyContent)
+</html>`, languageAttributes(chapterLanguage(content)), html.EscapeString(title), articleOpen, headingID, html.EscapeString(title), bylineHTML(byline), bodyContent, articleClose)
 
-	return cleanContent, nil
+	return addSpeechNamespaces(addDictionaryNamespaces(cleanContent)), nil
 }
 
 // createBasicChapterContent creates basic chapter content as fallback
@@ -1150,13 +1924,14 @@ func (r *Restructurer) createBasicChapterContent(title, content string) string {
 		fmt.Printf("🧹 Basic: Removing %d existing headings from '%s' to avoid duplicates\n", len(headingMatches), title)
 	}
 	bodyContent = headingPattern.ReplaceAllString(bodyContent, "")
+	bodyContent = strings.TrimSpace(bodyContent)
 
 	// Always add a clean heading after removing duplicates
 	if DebugMode {
 		fmt.Printf("➕ Basic: Adding clean heading for '%s'\n", title)
 	}
 	return fmt.Sprintf(`<?xml version='1.0' encoding='utf-8'?>
-<html xmlns="http://www.w3.org/1999/xhtml">
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
 
 <head>
   <title>%s</title>
@@ -1175,64 +1950,37 @@ func (r *Restructurer) createBasicChapterContent(title, content string) string {
 
 // createTocNCX creates the toc.ncx file
 func (r *Restructurer) createTocNCX(book *parser.Book, oebpsPath string) error {
-	// Start building the NCX content
-	ncxContent := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
-<!DOCTYPE ncx PUBLIC "-//NISO//DTD ncx 2005-1//EN" "http://www.daisy.org/z3986/2005/ncx-2005-1.dtd">
-<ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1">
-  <head>
-    <meta name="dtb:uid" content="%s"/>
-    <meta name="dtb:depth" content="1"/>
-    <meta name="dtb:totalPageCount" content="0"/>
-    <meta name="dtb:maxPageNumber" content="0"/>
-  </head>
-  <docTitle>
-    <text>%s</text>
-  </docTitle>
-  <docAuthor>
-    <text>%s</text>
-  </docAuthor>
-  <navMap>
-`,
-		book.Metadata.Identifier,
-		book.Metadata.Title,
-		book.Metadata.Creator)
-
-	// Add nav points
-	navPoints := []string{}
+	var navPoints []ncxNavPoint
 
 	// Add titlepage and jacket if cover exists
-	playOrder := 1
 	if book.CoverImage != "" {
-		navPoints = append(navPoints, fmt.Sprintf(`    <navPoint id="navpoint-titlepage" playOrder="%d">
-      <navLabel>
-        <text>Cover</text>
-      </navLabel>
-      <content src="titlepage.xhtml"/>
-    </navPoint>`, playOrder))
-		playOrder++
-
-		navPoints = append(navPoints, fmt.Sprintf(`    <navPoint id="navpoint-jacket" playOrder="%d">
-      <navLabel>
-        <text>Title Page</text>
-      </navLabel>
-      <content src="jacket.xhtml"/>
-    </navPoint>`, playOrder))
-		playOrder++
+		navPoints = append(navPoints,
+			ncxNavPoint{ID: "navpoint-titlepage", Label: ncxNavLabel{Text: r.localize("", "cover")}, Content: ncxContent{"titlepage.xhtml"}},
+			ncxNavPoint{ID: "navpoint-jacket", Label: ncxNavLabel{Text: r.localize("", "title_page")}, Content: ncxContent{"jacket.xhtml"}})
 	}
 
 	// Add chapters
-	for i, chapter := range book.Chapters {
-		navPoints = append(navPoints, fmt.Sprintf(`    <navPoint id="navpoint-%d" playOrder="%d">
-      <navLabel>
-        <text>%s</text>
-      </navLabel>
-      <content src="chapters/chapter_%03d.xhtml"/>
-    </navPoint>`, i+1, i+playOrder, chapter.Title, i+1))
+	navPoints = append(navPoints, r.chapterNavPoints(book)...)
+	assignPlayOrder(navPoints, map[string]int{})
+
+	ncx := ncxDocument{
+		Xmlns:   "http://www.daisy.org/z3986/2005/ncx/",
+		Version: "2005-1",
+		Head: []ncxMeta{
+			{"dtb:uid", r.ncxUID(book)},
+			{"dtb:depth", strconv.Itoa(navDepth(navPoints))},
+			{"dtb:totalPageCount", "0"},
+			{"dtb:maxPageNumber", "0"},
+		},
+		DocTitle:  book.Metadata.Title,
+		DocAuthor: book.Metadata.Creator,
+		NavPoints: navPoints,
 	}
 
-	// Add nav points to NCX
-	ncxContent += strings.Join(navPoints, "\n") + "\n  </navMap>\n</ncx>"
-
 	// Write the NCX file
-	return ioutil.WriteFile(filepath.Join(oebpsPath, "toc.ncx"), []byte(ncxContent), 0644)
-}
\ No newline at end of file
+	ncxContent, err := marshalXML(ncx, ncxDoctype)
+	if err != nil {
+		return fmt.Errorf("failed to render toc.ncx: %w", err)
+	}
+	return ioutil.WriteFile(filepath.Join(oebpsPath, "toc.ncx"), ncxContent, 0644)
+}
@@ -0,0 +1,301 @@
+package restructure
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/flouciel/folian-parser/internal/parser"
+)
+
+// SourceProfile tunes the cleanup to the conventions of the book's source, one of
+// SourceProfiles; when empty the source is detected, and "none" turns profiles off
+var SourceProfile string
+
+// SourceProfiles are the source profiles selectable with SourceProfile
+var SourceProfiles = []string{"calibre", "google-docs", "gutenberg", "indesign", "sigil", "vellum"}
+
+// sourceTools names the tools and sources of the profiles
+var sourceTools = map[string]string{
+	"calibre":     "calibre",
+	"google-docs": "Google Docs",
+	"gutenberg":   "Project Gutenberg",
+	"indesign":    "Adobe InDesign",
+	"sigil":       "Sigil",
+	"vellum":      "Vellum",
+}
+
+// Fingerprint is the source of a book, detected from the markers its tool leaves
+type Fingerprint struct {
+	// Profile is the source profile of the tool, "" when none is recognized
+	Profile string
+	// Tool names the tool or source
+	Tool string
+	// Evidence lists the markers found
+	Evidence []string
+}
+
+// sourceMarker is a telltale sign of a tool in the package or the content of a book
+type sourceMarker struct {
+	profile  string
+	evidence string
+	// producer, href and content match the producers named by the package, the
+	// manifest hrefs and the chapter markup
+	producer *regexp.Regexp
+	href     *regexp.Regexp
+	content  *regexp.Regexp
+}
+
+// sourceMarkers are the markers each tool leaves
+var sourceMarkers = []sourceMarker{
+	{profile: "calibre", evidence: "generator", producer: regexp.MustCompile(`(?i)^calibre\b`)},
+	{profile: "calibre", evidence: "page_styles.css", href: regexp.MustCompile(`(^|/)page_styles\.css$`)},
+	{profile: "calibre", evidence: "split files", href: regexp.MustCompile(`_split_\d{3}\.x?html$`)},
+	{profile: "calibre", evidence: "calibre classes", content: regexp.MustCompile(`class="calibre\d*\b`)},
+	{profile: "sigil", evidence: "generator", producer: regexp.MustCompile(`(?i)^sigil\b`)},
+	{profile: "sigil", evidence: "Text/Section files", href: regexp.MustCompile(`(^|/)Text/Section\d{4}\.x?html$`)},
+	{profile: "sigil", evidence: "sgc- classes", content: regexp.MustCompile(`class="[^"]*\bsgc-`)},
+	{profile: "sigil", evidence: "split markers", content: regexp.MustCompile(`class="(sigil_split_marker|sigilChapterBreak)"`)},
+	{profile: "indesign", evidence: "generator", producer: regexp.MustCompile(`(?i)indesign`)},
+	{profile: "indesign", evidence: "idGeneratedStyles.css", href: regexp.MustCompile(`(^|/)idGeneratedStyles\.css$`)},
+	{profile: "indesign", evidence: "override classes", content: regexp.MustCompile(`class="[^"]*\b(_idGen\w+|CharOverride-\d+|ParaOverride-\d+)`)},
+	{profile: "indesign", evidence: "text anchors", content: regexp.MustCompile(`id="_idTextAnchor\d+"`)},
+	{profile: "vellum", evidence: "generator", producer: regexp.MustCompile(`(?i)\bvellum\b`)},
+	{profile: "vellum", evidence: "Created with Vellum", content: regexp.MustCompile(`(?i)created with vellum|vellum\.pub`)},
+	{profile: "google-docs", evidence: "generator", producer: regexp.MustCompile(`(?i)google docs`)},
+	{profile: "google-docs", evidence: "kix list classes", content: regexp.MustCompile(`class="[^"]*\blst-kix_`)},
+	{profile: "google-docs", evidence: "heading IDs", content: regexp.MustCompile(`id="h\.[0-9a-z]{6,}"`)},
+	{profile: "google-docs", evidence: "redirect links", content: regexp.MustCompile(`href="https://www\.google\.com/url\?q=`)},
+}
+
+// DetectSource finds the tool that produced a book from its markers, taking the
+// tool with the most of them
+func DetectSource(book *parser.Book) Fingerprint {
+	evidence := make(map[string][]string)
+	for _, marker := range sourceMarkers {
+		found := false
+		switch {
+		case marker.producer != nil:
+			for _, producer := range book.Metadata.Producers {
+				found = found || marker.producer.MatchString(producer)
+			}
+		case marker.href != nil:
+			for _, item := range book.Manifest {
+				found = found || marker.href.MatchString(item.Href)
+			}
+		case marker.content != nil:
+			for _, chapter := range book.Chapters {
+				if marker.content.MatchString(chapter.Content) {
+					found = true
+					break
+				}
+			}
+		}
+		if found {
+			evidence[marker.profile] = append(evidence[marker.profile], marker.evidence)
+		}
+	}
+	if isGutenberg(book) {
+		evidence["gutenberg"] = append(evidence["gutenberg"], "license")
+	}
+
+	// Project Gutenberg books are often converted by calibre, and are cleaned up
+	// as Gutenberg books
+	fingerprint := Fingerprint{}
+	for _, profile := range SourceProfiles {
+		if len(evidence[profile]) > len(fingerprint.Evidence) || (profile == "gutenberg" && len(evidence[profile]) > 0) {
+			fingerprint = Fingerprint{Profile: profile, Tool: sourceTools[profile], Evidence: evidence[profile]}
+			if profile == "gutenberg" {
+				break
+			}
+		}
+	}
+	return fingerprint
+}
+
+// prepareSource applies the source profile, forced with SourceProfile or detected
+func (r *Restructurer) prepareSource(book *parser.Book, oebpsPath string) error {
+	profile := SourceProfile
+	if profile == "" {
+		fingerprint := DetectSource(book)
+		if fingerprint.Profile != "" {
+			fmt.Printf("ℹ️  Detected %s (%s); applying its cleanup profile, -source none turns it off\n", fingerprint.Tool, strings.Join(fingerprint.Evidence, ", "))
+		}
+		profile = fingerprint.Profile
+	}
+	switch profile {
+	case "", "none":
+		return nil
+	case "gutenberg":
+		return r.prepareGutenberg(book, oebpsPath)
+	}
+
+	rule := toolCleanups[profile]
+	changed := 0
+	for i, chapter := range book.Chapters {
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(chapter.Content))
+		if err != nil {
+			continue
+		}
+		count := rule(doc)
+		if count == 0 {
+			continue
+		}
+		if content, err := doc.Html(); err == nil {
+			book.Chapters[i].Content = content
+			changed += count
+		}
+	}
+	if DebugMode {
+		fmt.Printf("🏭 %s: cleaned up %d elements\n", sourceTools[profile], changed)
+	}
+	return nil
+}
+
+// toolCleanups are the cleanup rules of the tool profiles, returning the number of
+// elements changed
+var toolCleanups = map[string]func(doc *goquery.Document) int{
+	"calibre":     cleanCalibre,
+	"google-docs": cleanGoogleDocs,
+	"indesign":    cleanInDesign,
+	"sigil":       cleanSigil,
+	"vellum":      cleanVellum,
+}
+
+// cleanCalibre removes the page breaks of MOBI conversions and the spacer paragraphs
+// calibre keeps from them
+func cleanCalibre(doc *goquery.Document) int {
+	breaks := doc.Find("mbp\\:pagebreak, .mbppagebreak").Remove().Length()
+	return breaks + removeSpacers(doc)
+}
+
+// cleanSigil removes the split markers Sigil leaves behind and spacer paragraphs
+func cleanSigil(doc *goquery.Document) int {
+	markers := doc.Find("hr.sigil_split_marker, hr.sigilChapterBreak").Remove().Length()
+	return markers + removeSpacers(doc)
+}
+
+// inDesignClassPattern matches the classes InDesign generates for local overrides
+// and frames
+var inDesignClassPattern = regexp.MustCompile(`^(_idGen\w+|_idContainer\d+|CharOverride-\d+|ParaOverride-\d+)$`)
+
+// cleanInDesign drops the override classes InDesign generates and unwraps its text
+// frame containers and the spans left without a class
+func cleanInDesign(doc *goquery.Document) int {
+	changed := 0
+	doc.Find("div[class^='_idContainer'], div[class*=' _idContainer']").Each(func(i int, s *goquery.Selection) {
+		if s.Children().Length() > 0 {
+			s.Children().First().Unwrap()
+			changed++
+		}
+	})
+	return changed + dropClasses(doc, inDesignClassPattern) + unwrapBareSpans(doc)
+}
+
+// googleDocsClassPattern matches the numbered classes and kix list classes of Google
+// Docs exports
+var googleDocsClassPattern = regexp.MustCompile(`^(c\d+|lst-kix_[\w-]+)$`)
+
+// cleanGoogleDocs drops the numbered classes of Google Docs exports, unwraps their
+// spans and redirect links, and removes comments and spacer paragraphs
+func cleanGoogleDocs(doc *goquery.Document) int {
+	changed := dropClasses(doc, googleDocsClassPattern)
+	doc.Find("a[href^='https://www.google.com/url?']").Each(func(i int, s *goquery.Selection) {
+		href, _ := s.Attr("href")
+		if u, err := url.Parse(href); err == nil && u.Query().Get("q") != "" {
+			s.SetAttr("href", u.Query().Get("q"))
+			changed++
+		}
+	})
+	// Comments are linked from their anchors and listed at the end
+	doc.Find("a[id^='cmnt']").Each(func(i int, s *goquery.Selection) {
+		if id, _ := s.Attr("id"); strings.HasPrefix(id, "cmnt_ref") {
+			s.Parent().Filter("sup").Remove()
+			s.Remove()
+		} else {
+			s.Closest("div").Remove()
+		}
+		changed++
+	})
+	return changed + unwrapBareSpans(doc) + removeSpacers(doc)
+}
+
+// cleanVellum removes the Created with Vellum page Vellum adds to its books
+func cleanVellum(doc *goquery.Document) int {
+	changed := 0
+	doc.Find("a[href*='vellum.pub'], img[alt*='Vellum']").Each(func(i int, s *goquery.Selection) {
+		block := s.Closest("p, div, figure")
+		if block.Length() == 0 {
+			block = s
+		}
+		changed += block.Remove().Length()
+	})
+	doc.Find("p").Each(func(i int, s *goquery.Selection) {
+		if strings.EqualFold(strings.Join(strings.Fields(s.Text()), " "), "Created with Vellum") {
+			changed += s.Remove().Length()
+		}
+	})
+	return changed
+}
+
+// dropClasses removes the classes matching pattern, returning the number of elements
+// changed
+func dropClasses(doc *goquery.Document, pattern *regexp.Regexp) int {
+	changed := 0
+	doc.Find("[class]").Each(func(i int, s *goquery.Selection) {
+		class, _ := s.Attr("class")
+		var kept []string
+		for _, cls := range strings.Fields(class) {
+			if !pattern.MatchString(cls) {
+				kept = append(kept, cls)
+			}
+		}
+		if len(kept) == len(strings.Fields(class)) {
+			return
+		}
+		if len(kept) > 0 {
+			s.SetAttr("class", strings.Join(kept, " "))
+		} else {
+			s.RemoveAttr("class")
+		}
+		changed++
+	})
+	return changed
+}
+
+// unwrapBareSpans replaces the spans without attributes by their content
+func unwrapBareSpans(doc *goquery.Document) int {
+	changed := 0
+	doc.Find("span").Each(func(i int, s *goquery.Selection) {
+		if len(s.Nodes[0].Attr) > 0 {
+			return
+		}
+		node := s.Nodes[0]
+		for child := node.FirstChild; child != nil; child = node.FirstChild {
+			node.RemoveChild(child)
+			node.Parent.InsertBefore(child, node)
+		}
+		node.Parent.RemoveChild(node)
+		changed++
+	})
+	return changed
+}
+
+// removeSpacers removes the empty paragraphs used as vertical space, which hold
+// nothing but line breaks and non-breaking spaces
+func removeSpacers(doc *goquery.Document) int {
+	changed := 0
+	doc.Find("p").Each(func(i int, s *goquery.Selection) {
+		if _, hasID := s.Attr("id"); hasID || inPreservedLayout(s) {
+			return
+		}
+		if strings.TrimSpace(strings.ReplaceAll(s.Text(), "\u00a0", "")) != "" || s.Find("img, svg, a[id]").Length() > 0 {
+			return
+		}
+		s.Remove()
+		changed++
+	})
+	return changed
+}
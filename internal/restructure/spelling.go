@@ -0,0 +1,511 @@
+package restructure
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/flouciel/folian-parser/internal/langtag"
+	"github.com/flouciel/folian-parser/internal/policy"
+	"golang.org/x/net/html"
+)
+
+// SpellDictionaries is a directory of Hunspell dictionaries, an .aff and a .dic file
+// named by language (en_US.dic, vi.dic), the chapter text is checked against
+var SpellDictionaries string
+
+// SpellReport is the JSON file listing the words the dictionaries do not know and the
+// words autocorrected
+var SpellReport string
+
+// AutocorrectFile is a JSON file mapping misspelled words to their corrections, which
+// replace them in the chapter text
+var AutocorrectFile string
+
+// spellWordPattern finds the words of a text, and the character references to skip
+var spellWordPattern = regexp.MustCompile(`&#?\w+;|[\p{L}\p{M}]+(?:['’][\p{L}\p{M}]+)*`)
+
+// spellSkippedElements are the elements whose text is not prose
+var spellSkippedElements = map[string]bool{
+	"head": true, "script": true, "style": true, "pre": true, "code": true,
+	"kbd": true, "samp": true, "var": true, "math": true, "svg": true,
+}
+
+// voidElement reports whether an element has no end tag
+func voidElement(name string) bool {
+	switch name {
+	case "area", "base", "br", "col", "embed", "hr", "img", "input", "link", "meta", "source", "track", "wbr":
+		return true
+	}
+	return false
+}
+
+// SpellingEntry is an unknown or autocorrected word in the spelling report
+type SpellingEntry struct {
+	Chapter  string `json:"chapter"`
+	Line     int    `json:"line"`
+	Word     string `json:"word"`
+	Language string `json:"language,omitempty"`
+	// Correction is the word the autocorrect rules replaced it by, or "" for a word
+	// the dictionary does not know
+	Correction string `json:"correction,omitempty"`
+}
+
+// spellDictionary is a Hunspell dictionary: its stems with their affix flags and
+// the prefix and suffix rules of its affix file
+type spellDictionary struct {
+	words    map[string][]string
+	prefixes []affixRule
+	suffixes []affixRule
+	flagType string
+}
+
+// affixRule is a prefix or suffix rule of a Hunspell affix file
+type affixRule struct {
+	flag      string
+	strip     string
+	add       string
+	condition *regexp.Regexp
+	cross     bool
+}
+
+// loadSpelling reads the autocorrect rules and prepares the spelling report
+func (r *Restructurer) loadSpelling() error {
+	r.dictionaries = make(map[string]*spellDictionary)
+	r.autocorrections = nil
+	r.spellingEntries = nil
+	if AutocorrectFile == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(AutocorrectFile)
+	if err != nil {
+		return fmt.Errorf("failed to read autocorrect file: %w", err)
+	}
+	if err := json.Unmarshal(data, &r.autocorrections); err != nil {
+		return fmt.Errorf("failed to parse autocorrect file %s: %w", AutocorrectFile, err)
+	}
+	return nil
+}
+
+// checkSpelling applies the autocorrect rules to the text of a written chapter and
+// records the words the dictionary of their language does not know, with their line
+func (r *Restructurer) checkSpelling(content, target, lang string) string {
+	if SpellDictionaries == "" && len(r.autocorrections) == 0 {
+		return content
+	}
+	return rewriteProse(content, lang, func(text, textLang string, line int) string {
+		return r.checkText(text, target, textLang, line)
+	})
+}
+
+// rewriteProse passes each run of prose text of a chapter to rewrite, with the
+// language it is in and the line it starts on, and returns the chapter with the
+// rewritten text; code, math and other text that is not prose is left alone
+func rewriteProse(content, lang string, rewrite func(text, lang string, line int) string) string {
+	type scope struct{ name, lang string }
+	var stack []scope
+	var b strings.Builder
+	line := 1
+	tokenizer := html.NewTokenizer(strings.NewReader(content))
+	for {
+		kind := tokenizer.Next()
+		if kind == html.ErrorToken {
+			break
+		}
+		raw := string(tokenizer.Raw())
+		switch kind {
+		case html.StartTagToken:
+			name, hasAttr := tokenizer.TagName()
+			current := scope{name: string(name), lang: lang}
+			if len(stack) > 0 {
+				current.lang = stack[len(stack)-1].lang
+			}
+			for hasAttr {
+				var key, val []byte
+				key, val, hasAttr = tokenizer.TagAttr()
+				if (string(key) == "lang" || string(key) == "xml:lang") && len(val) > 0 {
+					current.lang = string(val)
+				}
+			}
+			if !voidElement(current.name) {
+				stack = append(stack, current)
+			}
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			for i := len(stack) - 1; i >= 0; i-- {
+				if stack[i].name == string(name) {
+					stack = stack[:i]
+					break
+				}
+			}
+		case html.TextToken:
+			skipped := false
+			textLang := lang
+			for _, s := range stack {
+				skipped = skipped || spellSkippedElements[s.name]
+				textLang = s.lang
+			}
+			if !skipped {
+				raw = rewrite(raw, textLang, line)
+			}
+		}
+		line += strings.Count(raw, "\n")
+		b.WriteString(raw)
+	}
+	return b.String()
+}
+
+// checkText autocorrects and checks the words of a run of text starting on a line
+func (r *Restructurer) checkText(text, target, lang string, line int) string {
+	dictionary := r.dictionary(lang)
+	var b strings.Builder
+	last := 0
+	for _, match := range spellWordPattern.FindAllStringIndex(text, -1) {
+		word := text[match[0]:match[1]]
+		b.WriteString(text[last:match[0]])
+		last = match[1]
+		wordLine := line + strings.Count(text[:match[0]], "\n")
+		if strings.HasPrefix(word, "&") {
+			b.WriteString(word)
+			continue
+		}
+
+		if correction, ok := r.autocorrect(word); ok {
+			r.spellingEntries = append(r.spellingEntries, SpellingEntry{Chapter: target, Line: wordLine, Word: word, Language: lang, Correction: correction})
+			b.WriteString(correction)
+			continue
+		}
+		if dictionary != nil && utf8.RuneCountInString(word) > 1 && !dictionary.knows(word) {
+			r.spellingEntries = append(r.spellingEntries, SpellingEntry{Chapter: target, Line: wordLine, Word: word, Language: lang})
+		}
+		b.WriteString(word)
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+// autocorrect returns the correction of a word by the autocorrect rules, keeping the
+// capitalization of a capitalized or uppercase word
+func (r *Restructurer) autocorrect(word string) (string, bool) {
+	if correction, ok := r.autocorrections[word]; ok {
+		return correction, true
+	}
+	correction, ok := r.autocorrections[strings.ToLower(word)]
+	if !ok {
+		return "", false
+	}
+	return matchCase(word, correction), true
+}
+
+// matchCase gives a replacement the case of the word it replaces: uppercase for an
+// uppercase word, capitalized for a capitalized one
+func matchCase(word, replacement string) string {
+	first, _ := utf8.DecodeRuneInString(word)
+	switch {
+	case strings.ToUpper(word) == word && utf8.RuneCountInString(word) > 1:
+		return strings.ToUpper(replacement)
+	case unicode.IsUpper(first):
+		c, n := utf8.DecodeRuneInString(replacement)
+		return string(unicode.ToUpper(c)) + replacement[n:]
+	}
+	return replacement
+}
+
+// dictionary returns the dictionary of a language, loading it from SpellDictionaries
+// the first time, or nil when there is none
+func (r *Restructurer) dictionary(lang string) *spellDictionary {
+	if SpellDictionaries == "" {
+		return nil
+	}
+	key := strings.ToLower(strings.Replace(lang, "-", "_", -1))
+	if dictionary, ok := r.dictionaries[key]; ok {
+		return dictionary
+	}
+
+	name := dictionaryName(key)
+	var dictionary *spellDictionary
+	if name == "" {
+		policy.Warn(policy.KindSpelling, "No spelling dictionary for language %q in %s", lang, SpellDictionaries)
+	} else {
+		loaded, err := loadDictionary(filepath.Join(SpellDictionaries, name))
+		if err != nil {
+			policy.Warn(policy.KindSpelling, "Could not read spelling dictionary %s: %v", name, err)
+		} else {
+			dictionary = loaded
+			if DebugMode {
+				fmt.Printf("🔡 Spelling dictionary for %q: %s (%d stems)\n", lang, name, len(dictionary.words))
+			}
+		}
+	}
+	r.dictionaries[key] = dictionary
+	return dictionary
+}
+
+// dictionaryName returns the name, without extension, of the dictionary of a
+// language in SpellDictionaries: the same tag, its primary language, or another
+// region of it
+func dictionaryName(key string) string {
+	entries, err := os.ReadDir(SpellDictionaries)
+	if err != nil || key == "" {
+		return ""
+	}
+	names := make(map[string]string)
+	var sorted []string
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) != ".dic" {
+			continue
+		}
+		name := strings.TrimSuffix(entry.Name(), ".dic")
+		names[strings.ToLower(strings.Replace(name, "-", "_", -1))] = name
+		sorted = append(sorted, strings.ToLower(strings.Replace(name, "-", "_", -1)))
+	}
+	sort.Strings(sorted)
+
+	primary := langtag.Primary(key)
+	if name, ok := names[key]; ok {
+		return name
+	}
+	if name, ok := names[primary]; ok {
+		return name
+	}
+	for _, candidate := range sorted {
+		if strings.HasPrefix(candidate, primary+"_") {
+			return names[candidate]
+		}
+	}
+	return ""
+}
+
+// loadDictionary reads the .aff and .dic files of a Hunspell dictionary
+func loadDictionary(base string) (*spellDictionary, error) {
+	dictionary := &spellDictionary{words: make(map[string][]string)}
+	encoding := "UTF-8"
+
+	aff, err := ioutil.ReadFile(base + ".aff")
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(aff), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "SET" {
+			encoding = strings.ToUpper(fields[1])
+		}
+	}
+	crosses := make(map[string]bool)
+	for _, line := range strings.Split(decodeDictionary(aff, encoding), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "FLAG":
+			dictionary.flagType = fields[1]
+		case "PFX", "SFX":
+			if len(fields) < 4 {
+				continue
+			}
+			if _, err := strconv.Atoi(fields[3]); err == nil && (fields[2] == "Y" || fields[2] == "N") {
+				// The header of the rules of a flag, which may combine prefixes and
+				// suffixes
+				crosses[fields[0]+fields[1]] = fields[2] == "Y"
+				continue
+			}
+			rule := affixRule{flag: fields[1], strip: fields[2], add: fields[3], cross: crosses[fields[0]+fields[1]]}
+			if rule.strip == "0" {
+				rule.strip = ""
+			}
+			if i := strings.Index(rule.add, "/"); i >= 0 {
+				rule.add = rule.add[:i]
+			}
+			if rule.add == "0" {
+				rule.add = ""
+			}
+			condition := "."
+			if len(fields) > 4 {
+				condition = fields[4]
+			}
+			pattern := condition + "$"
+			if fields[0] == "PFX" {
+				pattern = "^" + condition
+			}
+			if rule.condition, err = regexp.Compile(pattern); err != nil {
+				continue
+			}
+			if fields[0] == "PFX" {
+				dictionary.prefixes = append(dictionary.prefixes, rule)
+			} else {
+				dictionary.suffixes = append(dictionary.suffixes, rule)
+			}
+		}
+	}
+
+	dic, err := ioutil.ReadFile(base + ".dic")
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(strings.NewReader(decodeDictionary(dic, encoding)))
+	first := true
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if first {
+			// The first line holds the number of stems
+			first = false
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.Fields(line)[0]
+		word, flags := line, ""
+		if i := strings.Index(line, "/"); i > 0 {
+			word, flags = line[:i], line[i+1:]
+		}
+		dictionary.words[word] = append(dictionary.words[word], dictionary.splitFlags(flags)...)
+	}
+	return dictionary, scanner.Err()
+}
+
+// decodeDictionary decodes the files of a dictionary in ISO-8859-1, the most common
+// encoding besides UTF-8
+func decodeDictionary(data []byte, encoding string) string {
+	if encoding != "ISO8859-1" && encoding != "ISO-8859-1" {
+		return string(bytes.TrimPrefix(data, []byte("\ufeff")))
+	}
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
+
+// splitFlags splits the affix flags of a stem as the dictionary's FLAG declares them
+func (d *spellDictionary) splitFlags(flags string) []string {
+	var split []string
+	switch d.flagType {
+	case "long":
+		runes := []rune(flags)
+		for i := 0; i+1 < len(runes); i += 2 {
+			split = append(split, string(runes[i:i+2]))
+		}
+	case "num":
+		split = strings.Split(flags, ",")
+	default:
+		for _, flag := range flags {
+			split = append(split, string(flag))
+		}
+	}
+	return split
+}
+
+// knows reports whether a word is in the dictionary, as a stem or with an affix,
+// also in lowercase when it is capitalized or uppercase
+func (d *spellDictionary) knows(word string) bool {
+	word = strings.Replace(word, "’", "'", -1)
+	if d.knowsForm(word) {
+		return true
+	}
+	lower := strings.ToLower(word)
+	if lower != word && d.knowsForm(lower) {
+		return true
+	}
+	// A capitalized uppercase word, as in a heading set in capitals
+	first, size := utf8.DecodeRuneInString(lower)
+	return lower != word && d.knowsForm(string(unicode.ToUpper(first))+lower[size:])
+}
+
+// knowsForm reports whether a word in its exact case is a stem of the dictionary or
+// one with a prefix, a suffix or both
+func (d *spellDictionary) knowsForm(word string) bool {
+	if _, ok := d.words[word]; ok {
+		return true
+	}
+	if d.knowsSuffixed(word, "") {
+		return true
+	}
+	for _, rule := range d.prefixes {
+		if !strings.HasPrefix(word, rule.add) {
+			continue
+		}
+		stem := rule.strip + word[len(rule.add):]
+		if !rule.condition.MatchString(stem) {
+			continue
+		}
+		if d.hasFlag(stem, rule.flag) || rule.cross && d.knowsSuffixed(stem, rule.flag) {
+			return true
+		}
+	}
+	return false
+}
+
+// knowsSuffixed reports whether a word is a stem with a suffix; prefixFlag is the
+// flag the stem also needs when the word had a prefix
+func (d *spellDictionary) knowsSuffixed(word, prefixFlag string) bool {
+	for _, rule := range d.suffixes {
+		if !strings.HasSuffix(word, rule.add) || prefixFlag != "" && !rule.cross {
+			continue
+		}
+		stem := word[:len(word)-len(rule.add)] + rule.strip
+		if stem == "" || !rule.condition.MatchString(stem) || !d.hasFlag(stem, rule.flag) {
+			continue
+		}
+		if prefixFlag == "" || d.hasFlag(stem, prefixFlag) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasFlag reports whether a stem of the dictionary has an affix flag
+func (d *spellDictionary) hasFlag(stem, flag string) bool {
+	for _, f := range d.words[stem] {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// writeSpellingReport writes the unknown and autocorrected words to SpellReport
+func (r *Restructurer) writeSpellingReport() error {
+	unknown, corrected := 0, 0
+	for _, entry := range r.spellingEntries {
+		if entry.Correction == "" {
+			unknown++
+		} else {
+			corrected++
+		}
+	}
+	if len(r.autocorrections) > 0 {
+		fmt.Printf("ℹ️  Autocorrected %d words\n", corrected)
+	}
+	if SpellDictionaries != "" {
+		fmt.Printf("ℹ️  Found %d words the spelling dictionaries do not know\n", unknown)
+	}
+	if SpellReport == "" {
+		return nil
+	}
+
+	entries := r.spellingEntries
+	if entries == nil {
+		entries = []SpellingEntry{}
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode spelling report: %w", err)
+	}
+	if err := ioutil.WriteFile(SpellReport, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write spelling report: %w", err)
+	}
+	fmt.Printf("ℹ️  Listed %d unknown and autocorrected words in %s\n", len(entries), SpellReport)
+	return nil
+}
@@ -0,0 +1,136 @@
+package restructure
+
+import (
+	_ "embed"
+	"encoding/csv"
+	"fmt"
+	"strings"
+
+	"github.com/flouciel/folian-parser/internal/parser"
+	"github.com/flouciel/folian-parser/internal/policy"
+)
+
+// AddSubjects lists subjects to add to the output metadata
+var AddSubjects []string
+
+// RemoveSubjects lists subjects to remove from the output metadata (case-insensitive)
+var RemoveSubjects []string
+
+// SubjectCodes maps subjects to BISAC and Thema codes from the bundled table
+var SubjectCodes bool
+
+//go:embed subjects.csv
+var subjectTableCSV string
+
+// subjectCode is a row of the bundled BISAC/Thema table
+type subjectCode struct {
+	keyword      string
+	bisacCode    string
+	bisacHeading string
+	themaCode    string
+}
+
+// loadSubjectTable parses the bundled BISAC/Thema table
+func loadSubjectTable() ([]subjectCode, error) {
+	records, err := csv.NewReader(strings.NewReader(subjectTableCSV)).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse subject table: %w", err)
+	}
+
+	var table []subjectCode
+	for _, record := range records[1:] {
+		table = append(table, subjectCode{
+			keyword:      record[0],
+			bisacCode:    record[1],
+			bisacHeading: record[2],
+			themaCode:    record[3],
+		})
+	}
+	return table, nil
+}
+
+// resolveSubjects applies the add/remove flags to the parsed subjects, dropping duplicates
+func resolveSubjects(book *parser.Book) []string {
+	removed := make(map[string]bool)
+	for _, subject := range RemoveSubjects {
+		removed[strings.ToLower(strings.TrimSpace(subject))] = true
+	}
+
+	seen := make(map[string]bool)
+	var subjects []string
+	for _, subject := range append(append([]string{}, book.Metadata.Subjects...), AddSubjects...) {
+		subject = strings.TrimSpace(subject)
+		key := strings.ToLower(subject)
+		if subject == "" || removed[key] || seen[key] {
+			continue
+		}
+		seen[key] = true
+		subjects = append(subjects, subject)
+	}
+	return subjects
+}
+
+// matchSubjectCode finds the table row whose keyword best matches a subject, preferring
+// exact matches over the longest keyword contained in the subject
+func matchSubjectCode(table []subjectCode, subject string) (subjectCode, bool) {
+	subject = strings.ToLower(subject)
+	var best subjectCode
+	for _, code := range table {
+		if subject == code.keyword {
+			return code, true
+		}
+		if strings.Contains(subject, code.keyword) && len(code.keyword) > len(best.keyword) {
+			best = code
+		}
+	}
+	return best, best.keyword != ""
+}
+
+// buildSubjectMetadata renders the dc:subject entries and, when enabled, the BISAC and
+// Thema codes of the subjects with their authority refinements
+func buildSubjectMetadata(book *parser.Book) []metaElement {
+	subjects := resolveSubjects(book)
+	book.Metadata.Subjects = subjects
+
+	var meta []metaElement
+	for _, subject := range subjects {
+		meta = append(meta, newMeta("dc:subject", subject))
+	}
+
+	if !SubjectCodes || len(subjects) == 0 {
+		return meta
+	}
+
+	table, err := loadSubjectTable()
+	if err != nil {
+		policy.Warn(policy.KindOther, "%v", err)
+		return meta
+	}
+
+	seen := make(map[string]bool)
+	for _, subject := range subjects {
+		code, ok := matchSubjectCode(table, subject)
+		if !ok {
+			if DebugMode {
+				fmt.Printf("ℹ️  No BISAC/Thema code for subject %q\n", subject)
+			}
+			continue
+		}
+		if seen[code.bisacCode] {
+			continue
+		}
+		seen[code.bisacCode] = true
+
+		id := strings.ToLower(code.bisacCode)
+		meta = append(meta,
+			newMeta("dc:subject", code.bisacHeading, "id", "bisac-"+id),
+			newMeta("meta", "BISAC", "refines", "#bisac-"+id, "property", "authority"),
+			newMeta("meta", code.bisacCode, "refines", "#bisac-"+id, "property", "term"),
+			newMeta("dc:subject", code.themaCode, "id", "thema-"+id),
+			newMeta("meta", "THEMA", "refines", "#thema-"+id, "property", "authority"),
+			newMeta("meta", code.themaCode, "refines", "#thema-"+id, "property", "term"),
+		)
+	}
+
+	return meta
+}
@@ -0,0 +1,267 @@
+package restructure
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/flouciel/folian-parser/internal/langtag"
+	"github.com/flouciel/folian-parser/internal/parser"
+	"golang.org/x/net/html"
+)
+
+// NormalizeText repairs and normalizes the characters of the chapter text: mojibake
+// from double-encoded UTF-8, Windows-1252 control characters, decomposed letters,
+// zero-width characters and misused no-break spaces
+var NormalizeText bool
+
+// textRules are the language-specific rules of the normalization
+type textRules struct {
+	// keepZeroWidthSpace keeps U+200B, which separates the words of the scripts
+	// written without spaces
+	keepZeroWidthSpace bool
+	// punctuationSpace sets a no-break space before high punctuation and inside
+	// guillemets, as French typography does
+	punctuationSpace bool
+}
+
+// textRulesets are the normalization rules by language
+var textRulesets = map[string]textRules{
+	"fr": {punctuationSpace: true},
+	"km": {keepZeroWidthSpace: true},
+	"lo": {keepZeroWidthSpace: true},
+	"my": {keepZeroWidthSpace: true},
+	"th": {keepZeroWidthSpace: true},
+}
+
+// windows1252 maps the characters Windows-1252 encodes in 0x80-0x9F to their bytes
+var windows1252 = map[rune]byte{
+	'€': 0x80, '‚': 0x82, 'ƒ': 0x83, '„': 0x84, '…': 0x85, '†': 0x86, '‡': 0x87,
+	'ˆ': 0x88, '‰': 0x89, 'Š': 0x8A, '‹': 0x8B, 'Œ': 0x8C, 'Ž': 0x8E, '‘': 0x91,
+	'’': 0x92, '“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97, '˜': 0x98,
+	'™': 0x99, 'š': 0x9A, '›': 0x9B, 'œ': 0x9C, 'ž': 0x9E, 'Ÿ': 0x9F,
+}
+
+// windows1252Controls maps the C1 control characters left by Windows-1252 text
+// decoded as Latin-1 to the characters they stand for
+var windows1252Controls = func() map[rune]rune {
+	controls := make(map[rune]rune)
+	for r, b := range windows1252 {
+		controls[rune(b)] = r
+	}
+	return controls
+}()
+
+// compositions maps a letter and a combining mark to the letter they compose
+var (
+	compositions     map[[2]rune]rune
+	compositionsOnce sync.Once
+)
+
+// Patterns of the misused no-break spaces
+var (
+	// nbspRunPattern matches runs of spaces with more than one no-break space
+	nbspRunPattern = regexp.MustCompile(`[ \x{00a0}]*\x{00a0}[ \x{00a0}]*\x{00a0}[ \x{00a0}]*`)
+	// highPunctuationPattern and guillemetPattern match the spaces French sets
+	// before high punctuation and inside guillemets
+	highPunctuationPattern = regexp.MustCompile(`[ \x{00a0}\x{202f}]+([;:!?»])`)
+	guillemetPattern       = regexp.MustCompile(`«[ \x{00a0}\x{202f}]+`)
+)
+
+// normalizeText normalizes the text of a chapter with the rules of its language, or
+// of the language its elements declare, leaving scripts and styles alone and the
+// spacing of preformatted text as it is
+func normalizeText(doc *goquery.Document, lang string) int {
+	changed := 0
+	var walk func(node *html.Node, rules textRules, pre bool)
+	walk = func(node *html.Node, rules textRules, pre bool) {
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			switch child.Type {
+			case html.TextNode:
+				text := normalizeString(child.Data, rules, pre)
+				if text != child.Data {
+					child.Data = text
+					changed++
+				}
+			case html.ElementNode:
+				if child.Data == "script" || child.Data == "style" {
+					continue
+				}
+				childRules := rules
+				if lang := langtag.Declared(child); lang != "" {
+					childRules = textRulesets[langtag.Primary(lang)]
+				}
+				walk(child, childRules, pre || child.Data == "pre" || child.Data == "code")
+			}
+		}
+	}
+	for _, body := range doc.Find("body").Nodes {
+		walk(body, textRulesets[langtag.Primary(lang)], false)
+	}
+
+	// Paragraphs indented with no-break spaces
+	doc.Find("body p").Each(func(i int, s *goquery.Selection) {
+		first := s.Nodes[0].FirstChild
+		if first == nil || first.Type != html.TextNode || strings.TrimSpace(strings.ReplaceAll(s.Text(), "\u00a0", "")) == "" {
+			return
+		}
+		if trimmed := strings.TrimLeft(first.Data, " \u00a0"); trimmed != first.Data {
+			first.Data = trimmed
+			changed++
+		}
+	})
+
+	if DebugMode && changed > 0 {
+		fmt.Printf("🔤 Normalized the characters of %d text runs\n", changed)
+	}
+	return changed
+}
+
+// normalizeTitleText normalizes the characters of the titles of the chapters to write,
+// in each chapter's language or else the book's
+func (r *Restructurer) normalizeTitleText(book *parser.Book, chapters []parser.Chapter) {
+	if !NormalizeText {
+		return
+	}
+	for i, chapter := range chapters {
+		lang := r.chapterLanguages[chapter.ID]
+		if lang == "" {
+			lang = book.Metadata.Language
+		}
+		chapters[i].Title = strings.TrimSpace(normalizeString(chapter.Title, textRulesets[langtag.Primary(lang)], false))
+	}
+}
+
+// normalizeString normalizes a run of text with the rules of its language; pre keeps
+// its spacing
+func normalizeString(text string, rules textRules, pre bool) string {
+	text = composeLetters(fixControls(fixMojibake(text)))
+
+	text = strings.ReplaceAll(text, "\ufeff", "")
+	if !rules.keepZeroWidthSpace {
+		text = strings.ReplaceAll(text, "\u200b", "")
+	}
+	if pre || !strings.ContainsRune(text, '\u00a0') && !rules.punctuationSpace {
+		return text
+	}
+
+	text = nbspRunPattern.ReplaceAllString(text, " ")
+	if rules.punctuationSpace {
+		text = highPunctuationPattern.ReplaceAllString(text, "\u00a0$1")
+		text = guillemetPattern.ReplaceAllString(text, "«\u00a0")
+	}
+	return text
+}
+
+// mojibakeByte returns the byte a character stands for when UTF-8 text was decoded
+// as Windows-1252 or Latin-1
+func mojibakeByte(r rune) (byte, bool) {
+	if r >= 0x80 && r <= 0xFF {
+		return byte(r), true
+	}
+	b, ok := windows1252[r]
+	return b, ok
+}
+
+// fixMojibake decodes the UTF-8 sequences of double-encoded text, such as "Viá»‡t"
+// for "Việt", repeating for text encoded more than twice
+func fixMojibake(text string) string {
+	for pass := 0; pass < 2; pass++ {
+		runes := []rune(text)
+		var b strings.Builder
+		fixed := false
+		for i := 0; i < len(runes); i++ {
+			if n, decoded := mojibakeSequence(runes[i:]); n > 0 {
+				b.WriteString(decoded)
+				i += n - 1
+				fixed = true
+				continue
+			}
+			b.WriteRune(runes[i])
+		}
+		if !fixed {
+			break
+		}
+		text = b.String()
+	}
+	return text
+}
+
+// mojibakeSequence returns the length in characters and the decoded text of the
+// double-encoded UTF-8 sequence runes start with, or 0
+func mojibakeSequence(runes []rune) (int, string) {
+	lead, ok := mojibakeByte(runes[0])
+	var n int
+	switch {
+	case !ok:
+		return 0, ""
+	case lead >= 0xC2 && lead <= 0xDF:
+		n = 2
+	case lead >= 0xE0 && lead <= 0xEF:
+		n = 3
+	case lead >= 0xF0 && lead <= 0xF4:
+		n = 4
+	default:
+		return 0, ""
+	}
+	if len(runes) < n {
+		return 0, ""
+	}
+	bytes := []byte{lead}
+	for _, r := range runes[1:n] {
+		b, ok := mojibakeByte(r)
+		if !ok || b < 0x80 || b > 0xBF {
+			return 0, ""
+		}
+		bytes = append(bytes, b)
+	}
+	if !utf8.Valid(bytes) {
+		return 0, ""
+	}
+
+	// Two-byte sequences are only taken for the scripts double-encoded text is met
+	// in, so that a letter followed by a guillemet or a quote is left alone
+	if decoded, _ := utf8.DecodeRune(bytes); n == 2 && decoded >= 0x0530 {
+		return 0, ""
+	}
+	return n, string(bytes)
+}
+
+// fixControls replaces the C1 control characters by the Windows-1252 characters
+// they stand for
+func fixControls(text string) string {
+	return strings.Map(func(r rune) rune {
+		if c, ok := windows1252Controls[r]; ok {
+			return c
+		}
+		return r
+	}, text)
+}
+
+// composeLetters composes the letters written as a letter followed by combining
+// marks, as in text typed or converted in decomposed form
+func composeLetters(text string) string {
+	compositionsOnce.Do(func() {
+		compositions = make(map[[2]rune]rune)
+		table := []rune(compositionTable)
+		for i := 0; i+2 < len(table); i += 3 {
+			compositions[[2]rune{table[i], table[i+1]}] = table[i+2]
+		}
+	})
+
+	runes := []rune(text)
+	composed := runes[:0]
+	for _, r := range runes {
+		if n := len(composed); n > 0 {
+			if c, ok := compositions[[2]rune{composed[n-1], r}]; ok {
+				composed[n-1] = c
+				continue
+			}
+		}
+		composed = append(composed, r)
+	}
+	return string(composed)
+}
@@ -0,0 +1,182 @@
+package restructure
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/flouciel/folian-parser/internal/langtag"
+	"github.com/flouciel/folian-parser/internal/parser"
+)
+
+// NormalizeTitles normalizes the casing and spacing of the chapter titles used in the
+// navigation, NCX and chapter headings
+var NormalizeTitles bool
+
+// englishMinorWords stay lowercase inside English titles
+var englishMinorWords = map[string]bool{
+	"a": true, "an": true, "the": true, "and": true, "but": true, "or": true, "nor": true,
+	"for": true, "so": true, "yet": true, "as": true, "at": true, "by": true, "in": true,
+	"of": true, "off": true, "on": true, "per": true, "to": true, "up": true, "via": true,
+	"from": true, "into": true, "onto": true, "with": true, "over": true, "than": true,
+}
+
+// romanNumeralPattern matches a Roman numeral below 400; larger ones are rare in
+// titles and collide with words such as MIX and DIX
+var romanNumeralPattern = regexp.MustCompile(`^C{0,3}(XC|XL|L?X{0,3})(IX|IV|V?I{0,3})$`)
+
+// spaceBeforePunctuationPattern matches spaces left before closing punctuation
+var spaceBeforePunctuationPattern = regexp.MustCompile(`\s+([,.;:!?])`)
+
+// isRomanNumeral reports whether a word is an uppercase Roman numeral
+func isRomanNumeral(word string) bool {
+	return word != "" && romanNumeralPattern.MatchString(word)
+}
+
+// isAllCaps reports whether a title has several letters and none in lowercase
+func isAllCaps(title string) bool {
+	letters := 0
+	for _, c := range title {
+		if unicode.IsLower(c) {
+			return false
+		}
+		if unicode.IsUpper(c) {
+			letters++
+		}
+	}
+	return letters > 1
+}
+
+// isAllLower reports whether a title has letters and none in uppercase
+func isAllLower(title string) bool {
+	letters := 0
+	for _, c := range title {
+		if unicode.IsUpper(c) || unicode.IsTitle(c) {
+			return false
+		}
+		if unicode.IsLower(c) {
+			letters++
+		}
+	}
+	return letters > 0
+}
+
+// capitalize uppercases the first letter of a word and lowercases the rest; rune-wise
+// casing keeps Vietnamese tone marks, precomposed or combining, on their letters
+func capitalize(word string) string {
+	runes := []rune(strings.ToLower(word))
+	for i, c := range runes {
+		if unicode.IsLetter(c) {
+			runes[i] = unicode.ToTitle(c)
+			break
+		}
+	}
+	return string(runes)
+}
+
+// splitTitleWords splits a title into words and the separators between them, so
+// that joining them gives back the title
+func splitTitleWords(title string) []string {
+	var parts []string
+	var current strings.Builder
+	inWord := false
+	for _, c := range title {
+		// Combining marks belong to the letter they follow
+		wordRune := unicode.IsLetter(c) || unicode.IsDigit(c) || unicode.Is(unicode.Mn, c) || c == '\'' || c == '’'
+		if current.Len() > 0 && wordRune != inWord {
+			parts = append(parts, current.String())
+			current.Reset()
+		}
+		inWord = wordRune
+		current.WriteRune(c)
+	}
+	if current.Len() > 0 {
+		parts = append(parts, current.String())
+	}
+	return parts
+}
+
+// recase rewrites the words of a title: English titles in title case with minor
+// words in lowercase, other languages in sentence case; the first word and the word
+// after a colon or dash are capitalized and Roman numerals stay uppercase
+func recase(title, lang string) string {
+	english := langtag.Primary(lang) == "en"
+	parts := splitTitleWords(title)
+
+	// The last word of an English title is capitalized even when minor
+	last := -1
+	for i, part := range parts {
+		if r := []rune(part)[0]; unicode.IsLetter(r) || unicode.IsDigit(r) {
+			last = i
+		}
+	}
+
+	var result strings.Builder
+	startOfClause := true
+	for i, part := range parts {
+		first := []rune(part)[0]
+		if !unicode.IsLetter(first) && !unicode.IsDigit(first) {
+			if strings.ContainsAny(part, ":–—.!?") {
+				startOfClause = true
+			}
+			result.WriteString(part)
+			continue
+		}
+
+		upper := strings.ToUpper(part)
+		lower := strings.ToLower(part)
+		switch {
+		case isRomanNumeral(upper) && (len(part) > 1 || !english || upper != "I"):
+			result.WriteString(upper)
+		case startOfClause:
+			result.WriteString(capitalize(part))
+		case english && englishMinorWords[lower] && i != last:
+			result.WriteString(lower)
+		case english:
+			result.WriteString(capitalize(part))
+		default:
+			result.WriteString(lower)
+		}
+		startOfClause = false
+	}
+	return result.String()
+}
+
+// normalizeTitle collapses whitespace, removes spaces before punctuation and a
+// trailing period, and recases titles written in all caps, or English titles
+// written in lowercase
+func normalizeTitle(title, lang string) string {
+	normalized := strings.Join(strings.Fields(title), " ")
+	if langtag.Primary(lang) != "fr" {
+		// French typography keeps a space before double punctuation
+		normalized = spaceBeforePunctuationPattern.ReplaceAllString(normalized, "$1")
+	}
+	if strings.HasSuffix(normalized, ".") && !strings.HasSuffix(normalized, "..") {
+		normalized = strings.TrimSuffix(normalized, ".")
+	}
+
+	if isAllCaps(normalized) || (langtag.Primary(lang) == "en" && isAllLower(normalized)) {
+		normalized = recase(normalized, lang)
+	}
+	return normalized
+}
+
+// normalizeChapterTitles normalizes the titles of the chapters to write, in each
+// chapter's language or else the book's
+func (r *Restructurer) normalizeChapterTitles(book *parser.Book, chapters []parser.Chapter) {
+	if !NormalizeTitles {
+		return
+	}
+	for i, chapter := range chapters {
+		lang := r.chapterLanguages[chapter.ID]
+		if lang == "" {
+			lang = book.Metadata.Language
+		}
+		title := normalizeTitle(chapter.Title, lang)
+		if DebugMode && title != chapter.Title {
+			fmt.Printf("🔠 Title: %q -> %q\n", chapter.Title, title)
+		}
+		chapters[i].Title = title
+	}
+}
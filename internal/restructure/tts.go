@@ -0,0 +1,284 @@
+package restructure
+
+import (
+	"fmt"
+	"html"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/flouciel/folian-parser/internal/parser"
+	nethtml "golang.org/x/net/html"
+)
+
+// TTSMode annotates the output for read-aloud systems: the book's pronunciation
+// lexicons are kept and linked, and sentences are wrapped in spans
+var TTSMode bool
+
+// LexiconFile is a PLS pronunciation lexicon added to the book and linked from
+// every chapter
+var LexiconFile string
+
+// lexiconMediaType is the media type of PLS pronunciation lexicons
+const lexiconMediaType = "application/pls+xml"
+
+// ssmlNamespace declares the SSML prefix of ssml:ph and ssml:alphabet attributes
+const ssmlNamespace = ` xmlns:ssml="http://www.w3.org/2001/10/synthesis"`
+
+// sentenceClass marks the sentence spans, which are unwrapped before a book is
+// annotated again
+const sentenceClass = "tts-sentence"
+
+// lexiconLanguagePattern matches the language of a PLS lexicon
+var lexiconLanguagePattern = regexp.MustCompile(`<lexicon\b[^>]*\sxml:lang="([^"]+)"`)
+
+// sentenceEndPattern matches the end of a sentence: closing punctuation with its
+// quotes and brackets, then whitespace; CJK full stops need no whitespace
+var sentenceEndPattern = regexp.MustCompile(`[.!?…]+["'”’»)\]]*\s+|[。！？]+["'”’」』）]*\s*`)
+
+// abbreviations end with a period that does not end a sentence
+var abbreviations = map[string]bool{
+	"mr": true, "mrs": true, "ms": true, "dr": true, "st": true, "jr": true, "sr": true,
+	"prof": true, "mt": true, "vs": true, "etc": true, "e.g": true, "i.e": true, "mme": true, "tp": true,
+}
+
+// sentenceBlocks are the blocks whose text is split into sentences
+const sentenceBlocks = "body p, body li, body dt, body dd, body td, body th, body figcaption"
+
+// sentenceContainers are the blocks that make a sentence block a container of others
+const sentenceContainers = "p, div, ul, ol, dl, table, blockquote, section, aside"
+
+// lexicon is a pronunciation lexicon written to the lexicons directory
+type lexicon struct {
+	filename string
+	language string
+}
+
+// ttsAnnotating reports whether chapters link pronunciation lexicons
+func ttsAnnotating() bool {
+	return TTSMode || LexiconFile != ""
+}
+
+// processLexicons copies the book's PLS lexicons, in TTS mode, and LexiconFile to
+// OEBPS/lexicons
+func (r *Restructurer) processLexicons(book *parser.Book, oebpsPath string) error {
+	r.lexicons = nil
+	if !ttsAnnotating() {
+		return nil
+	}
+
+	var sources []string
+	if TTSMode {
+		opfDir := filepath.Dir(filepath.Join(book.Path, book.OPFPath))
+		for _, item := range book.Manifest {
+			if item.MediaType == lexiconMediaType {
+				sources = append(sources, filepath.Join(opfDir, filepath.FromSlash(item.Href)))
+			}
+		}
+	}
+	if LexiconFile != "" {
+		sources = append(sources, LexiconFile)
+	}
+	if len(sources) == 0 {
+		return nil
+	}
+
+	lexiconsPath := filepath.Join(oebpsPath, "lexicons")
+	if err := os.MkdirAll(lexiconsPath, 0755); err != nil {
+		return fmt.Errorf("failed to create lexicons directory: %w", err)
+	}
+
+	written := make(map[string]bool)
+	for _, source := range sources {
+		filename := filepath.Base(source)
+		if written[filename] {
+			continue
+		}
+		content, err := ioutil.ReadFile(source)
+		if err != nil {
+			return fmt.Errorf("failed to read lexicon %s: %w", source, err)
+		}
+		if !strings.Contains(string(content), "<lexicon") {
+			return fmt.Errorf("%s is not a PLS lexicon", source)
+		}
+		if err := ioutil.WriteFile(filepath.Join(lexiconsPath, filename), content, 0644); err != nil {
+			return fmt.Errorf("failed to write lexicon %s: %w", filename, err)
+		}
+		written[filename] = true
+
+		lex := lexicon{filename: filename}
+		if match := lexiconLanguagePattern.FindStringSubmatch(string(content)); match != nil {
+			lex.language = match[1]
+		}
+		r.lexicons = append(r.lexicons, lex)
+		if DebugMode {
+			fmt.Printf("🗣️  Pronunciation lexicon: lexicons/%s (%s)\n", filename, lex.language)
+		}
+	}
+	return nil
+}
+
+// addLexiconLinks links the pronunciation lexicons from a chapter's head
+func (r *Restructurer) addLexiconLinks(content string) string {
+	if len(r.lexicons) == 0 {
+		return content
+	}
+	var links strings.Builder
+	for _, lex := range r.lexicons {
+		hreflang := ""
+		if lex.language != "" {
+			hreflang = fmt.Sprintf(` hreflang="%s"`, html.EscapeString(lex.language))
+		}
+		fmt.Fprintf(&links, "  <link rel=\"pronunciation\" type=\"%s\"%s href=\"../lexicons/%s\"/>\n", lexiconMediaType, hreflang, html.EscapeString(lex.filename))
+	}
+	return strings.Replace(content, "</head>", links.String()+"</head>", 1)
+}
+
+// addSpeechNamespaces declares the SSML prefix on a chapter whose ssml:ph hints
+// were kept from the original
+func addSpeechNamespaces(content string) string {
+	if !strings.Contains(content, " ssml:") || strings.Contains(content, "xmlns:ssml=") {
+		return content
+	}
+	return strings.Replace(content, `xmlns:epub="http://www.idpf.org/2007/ops"`, `xmlns:epub="http://www.idpf.org/2007/ops"`+ssmlNamespace, 1)
+}
+
+// isAbbreviation reports whether text ends with an abbreviation before its period
+func isAbbreviation(text string) bool {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return false
+	}
+	word := strings.ToLower(strings.TrimRight(fields[len(fields)-1], "."))
+	word = strings.TrimLeft(word, `"'“‘«(`)
+	return abbreviations[word]
+}
+
+// splitSentences splits text at sentence ends, returning the sentence parts, each
+// ending its sentence but the last, and the whitespace between them
+func splitSentences(text string) (parts []string, gaps []string) {
+	start := 0
+	for _, match := range sentenceEndPattern.FindAllStringIndex(text, -1) {
+		end := match[0] + len(strings.TrimRightFunc(text[match[0]:match[1]], unicode.IsSpace))
+		if text[match[0]] == '.' && isAbbreviation(text[start:match[0]+1]) {
+			continue
+		}
+		parts = append(parts, text[start:end])
+		gaps = append(gaps, text[end:match[1]])
+		start = match[1]
+	}
+	parts = append(parts, text[start:])
+	return parts, gaps
+}
+
+// markSentences wraps the sentences of the book's text blocks in spans with IDs, for
+// read-aloud highlighting and media overlays; sentences spanning inline elements
+// end after them
+func markSentences(doc *goquery.Document) int {
+	doc.Find("span." + sentenceClass).Each(func(i int, s *goquery.Selection) {
+		s.ReplaceWithSelection(s.Contents())
+	})
+
+	count := 0
+	doc.Find(sentenceBlocks).Each(func(i int, s *goquery.Selection) {
+		if s.Find(sentenceContainers).Length() > 0 || strings.TrimSpace(s.Text()) == "" {
+			return
+		}
+		for _, block := range s.Nodes {
+			count = wrapSentences(block, count)
+		}
+	})
+	return count
+}
+
+// wrapSentences replaces the children of a block with sentence spans and the
+// whitespace between them, numbering the spans from count
+func wrapSentences(block *nethtml.Node, count int) int {
+	mergeTextNodes(block)
+
+	var children []*nethtml.Node
+	for child := block.FirstChild; child != nil; child = child.NextSibling {
+		children = append(children, child)
+	}
+	for _, child := range children {
+		block.RemoveChild(child)
+	}
+
+	var sentence *nethtml.Node
+	closeSentence := func() {
+		if sentence == nil {
+			return
+		}
+		if strings.TrimSpace(textOf(sentence)) == "" && sentence.FirstChild != nil && sentence.FirstChild == sentence.LastChild && sentence.FirstChild.Type == nethtml.TextNode {
+			// A sentence of whitespace only stays plain text
+			text := sentence.FirstChild
+			sentence.RemoveChild(text)
+			block.AppendChild(text)
+		} else if sentence.FirstChild != nil {
+			count++
+			sentence.Attr = []nethtml.Attribute{
+				{Key: "id", Val: fmt.Sprintf("tts-s%d", count)},
+				{Key: "class", Val: sentenceClass},
+			}
+			block.AppendChild(sentence)
+		}
+		sentence = nil
+	}
+	addToSentence := func(node *nethtml.Node) {
+		if sentence == nil {
+			sentence = &nethtml.Node{Type: nethtml.ElementNode, Data: "span"}
+		}
+		sentence.AppendChild(node)
+	}
+
+	for _, child := range children {
+		if child.Type != nethtml.TextNode {
+			addToSentence(child)
+			continue
+		}
+		parts, gaps := splitSentences(child.Data)
+		for j, part := range parts {
+			if part != "" {
+				addToSentence(&nethtml.Node{Type: nethtml.TextNode, Data: part})
+			}
+			if j < len(gaps) {
+				closeSentence()
+				if gaps[j] != "" {
+					block.AppendChild(&nethtml.Node{Type: nethtml.TextNode, Data: gaps[j]})
+				}
+			}
+		}
+	}
+	closeSentence()
+	return count
+}
+
+// mergeTextNodes joins the adjacent text children of a node, as left by unwrapped
+// sentence spans
+func mergeTextNodes(node *nethtml.Node) {
+	for child := node.FirstChild; child != nil; {
+		next := child.NextSibling
+		if child.Type == nethtml.TextNode && next != nil && next.Type == nethtml.TextNode {
+			child.Data += next.Data
+			node.RemoveChild(next)
+			continue
+		}
+		child = next
+	}
+}
+
+// textOf returns the text of a node and its descendants
+func textOf(node *nethtml.Node) string {
+	if node.Type == nethtml.TextNode {
+		return node.Data
+	}
+	var text strings.Builder
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		text.WriteString(textOf(child))
+	}
+	return text.String()
+}
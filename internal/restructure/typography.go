@@ -0,0 +1,105 @@
+package restructure
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// sceneBreakTextPattern matches paragraphs made only of ornaments such as "***",
+// "* * *", "#", "~" or dingbats
+var sceneBreakTextPattern = regexp.MustCompile(`^[\s*#~§•·⁂❦❧☙✱✲✳✵✶✻✼✽❀❁❂❃❋✦✧◆◇※]+$`)
+
+// maxSceneBreakLength is the longest ornament text treated as a scene break
+const maxSceneBreakLength = 20
+
+// sceneBreakClasses are publisher class fragments that mark scene breaks
+var sceneBreakClasses = []string{"scene-break", "scenebreak", "scene_break", "scene-change", "section-break", "sectionbreak", "space-break", "spacebreak", "ornament", "asterism", "dinkus", "transition"}
+
+// dropCapClasses are publisher class fragments that mark drop cap initials
+var dropCapClasses = []string{"dropcap", "drop-cap", "drop_cap", "initial", "firstletter", "first-letter", "bigcap", "big-cap"}
+
+// centeredStylePattern matches inline styles that center a paragraph
+var centeredStylePattern = regexp.MustCompile(`text-align:\s*center`)
+
+// hasClassFragment reports whether any class of an element contains one of the fragments
+func hasClassFragment(s *goquery.Selection, fragments []string) bool {
+	class, _ := s.Attr("class")
+	for _, cls := range strings.Fields(strings.ToLower(class)) {
+		for _, fragment := range fragments {
+			if strings.Contains(cls, fragment) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isBlankParagraph reports whether a paragraph has no visible content
+func isBlankParagraph(s *goquery.Selection) bool {
+	return strings.TrimSpace(strings.Replace(s.Text(), " ", " ", -1)) == "" && s.Find("img, svg, image").Length() == 0
+}
+
+// isCentered reports whether the publisher centered an element
+func isCentered(s *goquery.Selection) bool {
+	style, _ := s.Attr("style")
+	return centeredStylePattern.MatchString(strings.ToLower(style)) || hasClassFragment(s, []string{"center", "centre"})
+}
+
+// markTypography converts the publisher's scene breaks, chapter openings and drop caps
+// into the .scene-break, .first-para and .drop-cap classes styled by the theme, before
+// the cleanup strips the publisher's own classes and styles
+func markTypography(doc *goquery.Document) {
+	sceneBreaks := 0
+	doc.Find("body p, body div, body hr").Each(func(i int, s *goquery.Selection) {
+		if inPreservedLayout(s) || s.ParentsFiltered("table").Length() > 0 {
+			return
+		}
+		switch {
+		case goquery.NodeName(s) == "hr":
+			s.SetAttr("class", "scene-break")
+		case goquery.NodeName(s) == "div" && s.Find("p, div").Length() > 0:
+			return
+		case isBlankParagraph(s) && (isCentered(s) || hasClassFragment(s, sceneBreakClasses)):
+			// Blank lines vanish at page boundaries, so they become a visible ornament
+			s.ReplaceWithHtml(`<hr class="scene-break"/>`)
+		case hasClassFragment(s, sceneBreakClasses) ||
+			(goquery.NodeName(s) == "p" && len(s.Text()) <= maxSceneBreakLength && sceneBreakTextPattern.MatchString(s.Text()) && s.Find("img").Length() == 0):
+			if isBlankParagraph(s) {
+				return
+			}
+			s.SetAttr("class", "scene-break")
+		default:
+			return
+		}
+		sceneBreaks++
+	})
+
+	// The chapter opening and the paragraph following each scene break are set without indent
+	firstPara := doc.Find("body p").FilterFunction(func(i int, s *goquery.Selection) bool {
+		return s.ParentsFiltered("blockquote, table, aside, figure, li").Length() == 0 && !inPreservedLayout(s) &&
+			!s.HasClass("scene-break") && !isBlankParagraph(s)
+	}).First()
+	firstPara.AddClass("first-para")
+	doc.Find(".scene-break").Next().Filter("p").Not(".scene-break").AddClass("first-para")
+
+	// Publisher drop caps are unwrapped and restyled on their paragraph by the theme
+	doc.Find("body p span, body p b, body p big").Each(func(i int, s *goquery.Selection) {
+		if !hasClassFragment(s, dropCapClasses) {
+			return
+		}
+		paragraph := s.ParentsFiltered("p").First()
+		initial := strings.TrimSpace(s.Text())
+		if initial == "" || !strings.HasPrefix(strings.TrimSpace(paragraph.Text()), initial) {
+			return
+		}
+		s.ReplaceWithSelection(s.Contents())
+		paragraph.AddClass("first-para drop-cap")
+	})
+
+	if DebugMode && sceneBreaks > 0 {
+		fmt.Printf("✒️  Marked %d scene breaks\n", sceneBreaks)
+	}
+}
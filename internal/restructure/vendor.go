@@ -0,0 +1,197 @@
+package restructure
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/flouciel/folian-parser/internal/parser"
+)
+
+// VendorCleanups are the cleanup rules for the files and markup reading system
+// vendors leave in books, all applied unless turned off in KeepVendor
+var VendorCleanups = []string{"amazon-markup", "css-hacks", "display-options", "kobo-spans", "meta-tags", "page-templates"}
+
+// KeepVendor holds the vendor cleanup rules turned off, keeping what they remove
+var KeepVendor = map[string]bool{}
+
+// vendorCleanup reports whether a vendor cleanup rule is applied
+func vendorCleanup(rule string) bool {
+	return !KeepVendor[rule]
+}
+
+// pageTemplateMediaType is the media type of Adobe page templates
+const pageTemplateMediaType = "application/vnd.adobe-page-template+xml"
+
+// processPageTemplates drops the Adobe page templates of a book, or copies them to
+// styles/ and links them from the chapters when the page-templates rule is off
+func (r *Restructurer) processPageTemplates(book *parser.Book, oebpsPath string) error {
+	r.pageTemplates = nil
+	var hrefs []string
+	for _, item := range book.Manifest {
+		if item.MediaType == pageTemplateMediaType || strings.EqualFold(path.Ext(item.Href), ".xpgt") {
+			hrefs = append(hrefs, item.Href)
+		}
+	}
+	if len(hrefs) == 0 {
+		return nil
+	}
+	sort.Strings(hrefs)
+
+	if vendorCleanup("page-templates") {
+		for _, href := range hrefs {
+			fmt.Printf("ℹ️  Dropping Adobe page template %s (use -keep-vendor page-templates to keep it)\n", href)
+		}
+		return nil
+	}
+
+	opfDir := filepath.Dir(filepath.Join(book.Path, book.OPFPath))
+	var links strings.Builder
+	for _, href := range hrefs {
+		content, err := ioutil.ReadFile(filepath.Join(opfDir, filepath.FromSlash(href)))
+		if err != nil {
+			return fmt.Errorf("failed to read page template %s: %w", href, err)
+		}
+		name := path.Base(href)
+		if err := ioutil.WriteFile(filepath.Join(oebpsPath, "styles", name), content, 0644); err != nil {
+			return fmt.Errorf("failed to write page template %s: %w", name, err)
+		}
+		r.pageTemplates = append(r.pageTemplates, name)
+		fmt.Fprintf(&links, "\n  <link href=\"../styles/%s\" rel=\"stylesheet\" type=\"%s\"/>", xmlEscape(name), pageTemplateMediaType)
+	}
+
+	// The templates apply to the chapters, after the theme
+	chapters, err := filepath.Glob(filepath.Join(oebpsPath, "chapters", "*.xhtml"))
+	if err != nil {
+		return err
+	}
+	for _, chapter := range chapters {
+		data, err := ioutil.ReadFile(chapter)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", filepath.Base(chapter), err)
+		}
+		content := strings.Replace(string(data), stylesheetLink, stylesheetLink+links.String(), 1)
+		if err := ioutil.WriteFile(chapter, []byte(content), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", filepath.Base(chapter), err)
+		}
+	}
+	if DebugMode {
+		fmt.Printf("✅ Kept %d Adobe page templates\n", len(r.pageTemplates))
+	}
+	return nil
+}
+
+// stylesheetLink is the link to the theme in the head of the chapters
+const stylesheetLink = `<link href="../styles/stylesheet.css" rel="stylesheet" type="text/css"/>`
+
+// displayOptionPattern matches an option of the Apple display options
+var displayOptionPattern = regexp.MustCompile(`\s*<option\s+name="([^"]+)"\s*>([^<]*)</option>`)
+
+// cleanDisplayOptions fixes the Apple display options that do not hold for the
+// output, which is reflowable and embeds the theme's fonts
+func cleanDisplayOptions(content string) (string, int) {
+	changed := 0
+	content = displayOptionPattern.ReplaceAllStringFunc(content, func(option string) string {
+		match := displayOptionPattern.FindStringSubmatch(option)
+		switch match[1] {
+		case "fixed-layout", "open-to-spread", "orientation-lock":
+			changed++
+			return ""
+		case "specified-fonts":
+			if strings.TrimSpace(match[2]) != "true" {
+				changed++
+				return strings.Replace(option, ">"+match[2]+"<", ">true<", 1)
+			}
+		}
+		return option
+	})
+	return content, changed
+}
+
+// vendorMetaPattern matches the names and properties of the proprietary metas of
+// calibre, Sigil, Apple Books, Adobe and the Kindle and Kobo stores
+var vendorMetaPattern = regexp.MustCompile(`(?i)^(calibre:|ibooks:|adept\.|sigil version$|kobo|amzn|amazon)`)
+
+// ibooksPrefix declares the ibooks: prefix of Apple's metas
+const ibooksPrefix = "ibooks: http://vocabulary.itunes.apple.com/rdf/ibooks/vocabulary-extensions-1.0/"
+
+// vendorMetadata returns the proprietary metas of the book carried into the output
+// when the meta-tags rule is off, with the package prefix they need
+func (r *Restructurer) vendorMetadata(book *parser.Book) ([]metaElement, string) {
+	var elements []metaElement
+	prefix := ""
+	dropped := 0
+	for _, meta := range book.Metadata.Metas {
+		key := meta.Name + meta.Property
+		if !vendorMetaPattern.MatchString(key) {
+			continue
+		}
+		// The series metas are written from the book's series
+		if vendorCleanup("meta-tags") || meta.Name == "calibre:series" || meta.Name == "calibre:series_index" {
+			dropped++
+			continue
+		}
+		if meta.Property != "" {
+			elements = append(elements, newMeta("meta", meta.Value, "property", meta.Property))
+			if strings.HasPrefix(meta.Property, "ibooks:") {
+				prefix = ibooksPrefix
+			}
+		} else {
+			elements = append(elements, newMeta("meta", "", "name", meta.Name, "content", meta.Content))
+		}
+	}
+	if DebugMode && dropped > 0 {
+		fmt.Printf("🧹 Dropped %d proprietary metas\n", dropped)
+	}
+	return elements, prefix
+}
+
+// vendorCSSPattern matches the declarations of Adobe's proprietary properties, the
+// prefixed properties of Internet Explorer and Opera, and the star and underscore
+// hacks of old Internet Explorer versions, none of which reading systems use
+var vendorCSSPattern = regexp.MustCompile(`(?i)([{;])\s*(-?adobe-|-ms-|-o-|[*_])[a-z-]+\s*:[^;{}]*;?`)
+
+// stripVendorCSS removes the vendor CSS hacks of a stylesheet
+func stripVendorCSS(content string) string {
+	// Adjacent declarations share the separator one of them consumes
+	for vendorCSSPattern.MatchString(content) {
+		content = vendorCSSPattern.ReplaceAllString(content, "$1")
+	}
+	return content
+}
+
+// stripVendorMarkup unwraps the sentence spans the Kobo store adds and removes the
+// markup of Kindle conversions: the content only older Kindles show and the
+// attributes that mark it
+func stripVendorMarkup(doc *goquery.Document) {
+	if vendorCleanup("kobo-spans") {
+		doc.Find("span.koboSpan").Each(func(i int, s *goquery.Selection) {
+			if s.Contents().Length() == 0 {
+				s.Remove()
+				return
+			}
+			s.Contents().Unwrap()
+		})
+	}
+	if vendorCleanup("amazon-markup") {
+		doc.Find("[data-amznremoved-m8]").Remove()
+		doc.Find("[data-amznremoved], [data-amznpagebreak]").Each(func(i int, s *goquery.Selection) {
+			s.RemoveAttr("data-amznremoved")
+			s.RemoveAttr("data-amznpagebreak")
+		})
+	}
+}
+
+// pageTemplateItems lists the kept Adobe page templates in the manifest
+func (r *Restructurer) pageTemplateItems() []opfItem {
+	var items []opfItem
+	for i, name := range r.pageTemplates {
+		items = append(items, opfItem{ID: fmt.Sprintf("page-template%d", i+1), Href: "styles/" + name, MediaType: pageTemplateMediaType})
+	}
+	return items
+}
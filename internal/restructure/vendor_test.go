@@ -0,0 +1,152 @@
+package restructure
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/flouciel/folian-parser/internal/parser"
+)
+
+// cleanMarkup runs stripVendorMarkup on a body and returns it
+func cleanMarkup(t *testing.T, body string) string {
+	t.Helper()
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader("<html><body>" + body + "</body></html>"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stripVendorMarkup(doc)
+	html, err := doc.Find("body").Html()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return html
+}
+
+// keptPageTemplates runs processPageTemplates on a book with one Adobe page
+// template and returns the templates kept
+func keptPageTemplates(t *testing.T) string {
+	t.Helper()
+	bookPath, oebpsPath := t.TempDir(), t.TempDir()
+	for _, dir := range []string{filepath.Join(bookPath, "OEBPS"), filepath.Join(oebpsPath, "styles"), filepath.Join(oebpsPath, "chapters")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(bookPath, "OEBPS", "page.xpgt"), []byte("<ade:template/>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	book := &parser.Book{
+		Path:     bookPath,
+		OPFPath:  "OEBPS/content.opf",
+		Manifest: map[string]parser.ManifestItem{"template": {ID: "template", Href: "page.xpgt", MediaType: pageTemplateMediaType}},
+	}
+	r := &Restructurer{}
+	if err := r.processPageTemplates(book, oebpsPath); err != nil {
+		t.Fatal(err)
+	}
+	return strings.Join(r.pageTemplates, ",")
+}
+
+func TestVendorCleanups(t *testing.T) {
+	tests := []struct {
+		rule    string
+		run     func(t *testing.T) string
+		applied string
+		kept    string
+	}{
+		{
+			rule: "amazon-markup",
+			run: func(t *testing.T) string {
+				return cleanMarkup(t, `<p data-amznremoved="mobi7">Text</p><p data-amznremoved-m8="true">Old</p>`)
+			},
+			applied: `<p>Text</p>`,
+			kept:    `<p data-amznremoved="mobi7">Text</p><p data-amznremoved-m8="true">Old</p>`,
+		},
+		{
+			rule: "css-hacks",
+			run: func(t *testing.T) string {
+				content := "p { margin: 0; -adobe-hyphenate: none; *zoom: 1; color: red }"
+				if vendorCleanup("css-hacks") {
+					content = stripVendorCSS(content)
+				}
+				return content
+			},
+			applied: "p { margin: 0; color: red }",
+			kept:    "p { margin: 0; -adobe-hyphenate: none; *zoom: 1; color: red }",
+		},
+		{
+			rule: "display-options",
+			run: func(t *testing.T) string {
+				content := `<display_options><platform name="*"><option name="fixed-layout">true</option><option name="specified-fonts">false</option></platform></display_options>`
+				if vendorCleanup("display-options") {
+					content, _ = cleanDisplayOptions(content)
+				}
+				return content
+			},
+			applied: `<display_options><platform name="*"><option name="specified-fonts">true</option></platform></display_options>`,
+			kept:    `<display_options><platform name="*"><option name="fixed-layout">true</option><option name="specified-fonts">false</option></platform></display_options>`,
+		},
+		{
+			rule: "kobo-spans",
+			run: func(t *testing.T) string {
+				return cleanMarkup(t, `<p><span class="koboSpan" id="kobo.1.1">Text</span><span class="koboSpan"></span></p>`)
+			},
+			applied: `<p>Text</p>`,
+			kept:    `<p><span class="koboSpan" id="kobo.1.1">Text</span><span class="koboSpan"></span></p>`,
+		},
+		{
+			rule: "meta-tags",
+			run: func(t *testing.T) string {
+				book := &parser.Book{}
+				book.Metadata.Metas = []parser.Meta{
+					{Name: "calibre:timestamp", Content: "2024-01-01"},
+					{Name: "calibre:series", Content: "Series"},
+					{Property: "ibooks:version", Value: "1.0"},
+					{Name: "cover", Content: "cover-image"},
+				}
+				elements, prefix := (&Restructurer{}).vendorMetadata(book)
+				var kept []string
+				for _, element := range elements {
+					kept = append(kept, element.Attrs[0].Value)
+				}
+				if prefix != "" {
+					kept = append(kept, "prefix")
+				}
+				return strings.Join(kept, ",")
+			},
+			applied: "",
+			kept:    "calibre:timestamp,ibooks:version,prefix",
+		},
+		{
+			rule:    "page-templates",
+			run:     keptPageTemplates,
+			applied: "",
+			kept:    "page.xpgt",
+		},
+	}
+
+	keepVendor := KeepVendor
+	t.Cleanup(func() { KeepVendor = keepVendor })
+	covered := map[string]bool{}
+	for _, test := range tests {
+		covered[test.rule] = true
+		t.Run(test.rule, func(t *testing.T) {
+			KeepVendor = map[string]bool{}
+			if got := test.run(t); got != test.applied {
+				t.Errorf("applied: got %q, want %q", got, test.applied)
+			}
+			KeepVendor = map[string]bool{test.rule: true}
+			if got := test.run(t); got != test.kept {
+				t.Errorf("kept: got %q, want %q", got, test.kept)
+			}
+		})
+	}
+	for _, rule := range VendorCleanups {
+		if !covered[rule] {
+			t.Errorf("vendor cleanup rule %q is not tested", rule)
+		}
+	}
+}
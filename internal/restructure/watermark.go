@@ -0,0 +1,106 @@
+package restructure
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/flouciel/folian-parser/internal/parser"
+	"github.com/flouciel/folian-parser/internal/policy"
+)
+
+// WatermarkName and WatermarkEmail identify the purchaser of a personalized copy,
+// named in the colophon
+var (
+	WatermarkName  string
+	WatermarkEmail string
+)
+
+// WatermarkID is the invisible identifier of a personalized copy recorded in the OPF;
+// it is derived from the purchaser and the book when not set
+var WatermarkID string
+
+// colophonTitlePattern matches the titles of colophon and copyright pages
+var colophonTitlePattern = regexp.MustCompile(`(?i)^\s*(colophon|copyright( page)?|bản quyền|thông tin xuất bản)\s*$`)
+
+// colophonTypePattern matches the EPUB3 semantics of a colophon or copyright page
+var colophonTypePattern = regexp.MustCompile(`epub:type="[^"]*\b(colophon|copyright-page)\b[^"]*"`)
+
+// watermarkPattern matches a watermark line written by an earlier run
+var watermarkPattern = regexp.MustCompile(`\s*<p class="folian-watermark"[^>]*>.*?</p>`)
+
+// watermarking reports whether a personalization watermark is requested
+func watermarking() bool {
+	return WatermarkName != "" || WatermarkEmail != "" || WatermarkID != ""
+}
+
+// isColophon reports whether a chapter is the book's colophon or copyright page
+func isColophon(chapter parser.Chapter) bool {
+	return colophonTitlePattern.MatchString(chapter.Title) || colophonTypePattern.MatchString(chapter.Content)
+}
+
+// watermarkID returns the identifier of the personalized copy
+func watermarkID(book *parser.Book) string {
+	if WatermarkID != "" {
+		return WatermarkID
+	}
+	sum := sha256.Sum256([]byte(WatermarkName + "\n" + WatermarkEmail + "\n" + book.Metadata.Identifier))
+	return hex.EncodeToString(sum[:8])
+}
+
+// buildWatermarkMetadata renders the OPF entry holding the watermark identifier
+func buildWatermarkMetadata(book *parser.Book) []metaElement {
+	if !watermarking() {
+		return nil
+	}
+	return []metaElement{newMeta("meta", "", "name", "folian:watermark", "content", watermarkID(book))}
+}
+
+// applyWatermark adds the purchaser line to the colophon, or to the first written
+// chapter when the book has none, replacing the line of an earlier run
+func (r *Restructurer) applyWatermark(book *parser.Book, oebpsPath string) error {
+	if WatermarkName == "" && WatermarkEmail == "" {
+		return nil
+	}
+
+	target := r.colophonFile
+	if target == "" && len(r.chapterFiles) > 0 {
+		target = r.chapterFiles[0]
+	}
+	if target == "" {
+		policy.Warn(policy.KindOther, "No chapter was written, skipping the watermark")
+		return nil
+	}
+	path := filepath.Join(oebpsPath, filepath.FromSlash(target))
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s for the watermark: %w", target, err)
+	}
+
+	purchaser := WatermarkName
+	if WatermarkEmail != "" && purchaser != "" {
+		purchaser += " <" + WatermarkEmail + ">"
+	} else if WatermarkEmail != "" {
+		purchaser = WatermarkEmail
+	}
+	line := strings.Replace(r.localize("", "licensed_to"), "{{PURCHASER}}", purchaser, -1)
+	paragraph := fmt.Sprintf(`<p class="folian-watermark" style="font-size: 0.75em; text-align: center; margin-top: 2em;">%s</p>`, html.EscapeString(line))
+
+	updated := watermarkPattern.ReplaceAllString(string(content), "")
+	if i := strings.LastIndex(updated, "</body>"); i >= 0 {
+		updated = updated[:i] + "  " + paragraph + "\n" + updated[i:]
+	}
+	if err := ioutil.WriteFile(path, []byte(updated), 0644); err != nil {
+		return fmt.Errorf("failed to write the watermark to %s: %w", target, err)
+	}
+
+	if DebugMode {
+		fmt.Printf("🔏 Watermarked %s for %s (ID %s)\n", target, purchaser, watermarkID(book))
+	}
+	return nil
+}
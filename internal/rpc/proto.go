@@ -0,0 +1,139 @@
+package rpc
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Protocol buffer wire types
+const (
+	wireVarint = 0
+	wire64     = 1
+	wireBytes  = 2
+	wire32     = 5
+)
+
+// Message encodes the fields of a protocol buffer message in the order they are
+// added; fields with their zero value are left out, as proto3 does
+type Message []byte
+
+// String adds a string field
+func (m *Message) String(field int, s string) {
+	if s != "" {
+		m.Bytes(field, []byte(s))
+	}
+}
+
+// Bytes adds a bytes field
+func (m *Message) Bytes(field int, b []byte) {
+	if len(b) == 0 {
+		return
+	}
+	m.tag(field, wireBytes)
+	m.varint(uint64(len(b)))
+	*m = append(*m, b...)
+}
+
+// Int adds an int32 or int64 field
+func (m *Message) Int(field int, v int64) {
+	if v == 0 {
+		return
+	}
+	m.tag(field, wireVarint)
+	m.varint(uint64(v))
+}
+
+// Bool adds a bool field
+func (m *Message) Bool(field int, v bool) {
+	if v {
+		m.Int(field, 1)
+	}
+}
+
+// Embed adds an embedded message field, also when it is empty so that the field of
+// a oneof is set
+func (m *Message) Embed(field int, sub Message) {
+	m.tag(field, wireBytes)
+	m.varint(uint64(len(sub)))
+	*m = append(*m, sub...)
+}
+
+// tag adds the key of a field
+func (m *Message) tag(field, wireType int) {
+	m.varint(uint64(field)<<3 | uint64(wireType))
+}
+
+// varint adds a base 128 varint
+func (m *Message) varint(v uint64) {
+	for v >= 0x80 {
+		*m = append(*m, byte(v)|0x80)
+		v >>= 7
+	}
+	*m = append(*m, byte(v))
+}
+
+// Field is a decoded field of a message: Bytes holds a length-delimited value,
+// Varint the others
+type Field struct {
+	Number int
+	Varint uint64
+	Bytes  []byte
+}
+
+// errTruncated reports a message that ends inside a field
+var errTruncated = errors.New("truncated protocol buffer message")
+
+// Fields decodes the fields of a message in order
+func Fields(data []byte) ([]Field, error) {
+	var fields []Field
+	for len(data) > 0 {
+		key, n := readVarint(data)
+		if n == 0 {
+			return nil, errTruncated
+		}
+		data = data[n:]
+		field := Field{Number: int(key >> 3)}
+		switch key & 7 {
+		case wireVarint:
+			v, n := readVarint(data)
+			if n == 0 {
+				return nil, errTruncated
+			}
+			field.Varint, data = v, data[n:]
+		case wire64, wire32:
+			size := 8
+			if key&7 == wire32 {
+				size = 4
+			}
+			if len(data) < size {
+				return nil, errTruncated
+			}
+			for i := size - 1; i >= 0; i-- {
+				field.Varint = field.Varint<<8 | uint64(data[i])
+			}
+			data = data[size:]
+		case wireBytes:
+			length, n := readVarint(data)
+			if n == 0 || uint64(len(data)-n) < length {
+				return nil, errTruncated
+			}
+			field.Bytes, data = data[n:n+int(length)], data[n+int(length):]
+		default:
+			return nil, fmt.Errorf("unsupported protocol buffer wire type %d", key&7)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// readVarint reads a varint, returning its size or 0 when it is truncated
+func readVarint(data []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < len(data) && i < 10; i++ {
+		v |= uint64(data[i]&0x7f) << (7 * uint(i))
+		if data[i] < 0x80 {
+			return v, i + 1
+		}
+	}
+	return 0, 0
+}
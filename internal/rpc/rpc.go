@@ -0,0 +1,172 @@
+// Package rpc serves gRPC services over cleartext HTTP/2 with the standard library
+// and golang.org/x/net, encoding the protocol buffer messages by hand, so that the
+// command needs no gRPC runtime or generated code. Only server-streaming methods
+// without compression are supported
+package rpc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// DefaultMaxRequest is the largest request message accepted when the server sets
+// no limit, in bytes
+const DefaultMaxRequest = 32 << 20
+
+// gRPC status codes
+const (
+	CodeOK                = 0
+	CodeInvalidArgument   = 3
+	CodeResourceExhausted = 8
+	CodeUnimplemented     = 12
+	CodeInternal          = 13
+	CodeUnavailable       = 14
+)
+
+// Error is a failure of a call with its gRPC status code
+type Error struct {
+	Code    int
+	Message string
+}
+
+func (e *Error) Error() string { return fmt.Sprintf("rpc error %d: %s", e.Code, e.Message) }
+
+// Errorf returns an Error with a status code
+func Errorf(code int, format string, args ...interface{}) error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// StreamHandler handles a call of a server-streaming method: it receives the request
+// message and sends the response messages with send
+type StreamHandler func(request []byte, send func(Message) error) error
+
+// Server routes gRPC calls to the handlers of their methods
+type Server struct {
+	methods map[string]StreamHandler
+	// fallback serves the requests that are not gRPC calls, such as health checks
+	fallback http.Handler
+	// MaxRequest is the largest request message accepted, in bytes, or
+	// DefaultMaxRequest when 0
+	MaxRequest int64
+}
+
+// NewServer creates a server without methods; requests that are not gRPC calls are
+// passed to fallback, or answered 404 when it is nil
+func NewServer(fallback http.Handler) *Server {
+	if fallback == nil {
+		fallback = http.NotFoundHandler()
+	}
+	return &Server{methods: make(map[string]StreamHandler), fallback: fallback}
+}
+
+// Handle registers the handler of a method by its full name, /package.Service/Method
+func (s *Server) Handle(method string, handler StreamHandler) {
+	s.methods[method] = handler
+}
+
+// Handler returns the HTTP handler of the server, accepting HTTP/2 without TLS
+func (s *Server) Handler() http.Handler {
+	return h2c.NewHandler(s, &http2.Server{})
+}
+
+// ServeHTTP answers a gRPC call
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		s.fallback.ServeHTTP(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/grpc+proto")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	handler, ok := s.methods[r.URL.Path]
+	if !ok {
+		w.WriteHeader(http.StatusOK)
+		writeStatus(w, Errorf(CodeUnimplemented, "unknown method %s", r.URL.Path))
+		return
+	}
+
+	maxRequest := s.MaxRequest
+	if maxRequest <= 0 {
+		maxRequest = DefaultMaxRequest
+	}
+	request, err := readMessage(r.Body, maxRequest)
+	if err != nil {
+		w.WriteHeader(http.StatusOK)
+		writeStatus(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	send := func(m Message) error {
+		frame := make([]byte, 5, 5+len(m))
+		binary.BigEndian.PutUint32(frame[1:], uint32(len(m)))
+		if _, err := w.Write(append(frame, m...)); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	}
+	writeStatus(w, handler(request, send))
+}
+
+// readMessage reads the one length-prefixed message of a request. The length is the
+// caller's, so the message is read as it arrives rather than allocated from it
+func readMessage(body io.Reader, maxRequest int64) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(body, prefix[:]); err != nil {
+		return nil, Errorf(CodeInvalidArgument, "missing request message: %v", err)
+	}
+	if prefix[0] != 0 {
+		return nil, Errorf(CodeUnimplemented, "compressed messages are not supported")
+	}
+	length := int64(binary.BigEndian.Uint32(prefix[1:]))
+	if length > maxRequest {
+		return nil, Errorf(CodeResourceExhausted, "request message of %d bytes is larger than %d bytes", length, maxRequest)
+	}
+	message, err := io.ReadAll(io.LimitReader(body, length))
+	if err != nil {
+		return nil, Errorf(CodeInvalidArgument, "truncated request message: %v", err)
+	}
+	if int64(len(message)) < length {
+		return nil, Errorf(CodeInvalidArgument, "truncated request message: %d of %d bytes", len(message), length)
+	}
+	return message, nil
+}
+
+// writeStatus ends a call with the status of its error in the trailers
+func writeStatus(w http.ResponseWriter, err error) {
+	code, message := CodeOK, ""
+	if err != nil {
+		code, message = CodeInternal, err.Error()
+		if e, ok := err.(*Error); ok {
+			code, message = e.Code, e.Message
+		}
+	}
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	if message != "" {
+		w.Header().Set("Grpc-Message", encodeStatusMessage(message))
+	}
+}
+
+// encodeStatusMessage percent-encodes a status message as gRPC requires: the bytes
+// outside printable ASCII, and the percent sign
+func encodeStatusMessage(message string) string {
+	var b strings.Builder
+	for i := 0; i < len(message); i++ {
+		c := message[i]
+		if c < 0x20 || c > 0x7e || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,226 @@
+package stats
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/flouciel/folian-parser/internal/langtag"
+	"github.com/flouciel/folian-parser/internal/parser"
+	nethtml "golang.org/x/net/html"
+)
+
+// WordsPerMinute is the average silent reading speed used for reading time estimates
+const WordsPerMinute = 238
+
+// BookStats holds content statistics for a whole book
+type BookStats struct {
+	Title              string         `json:"title"`
+	Language           string         `json:"language"`
+	TotalWords         int            `json:"totalWords"`
+	ReadingMinutes     float64        `json:"readingMinutes"`
+	Images             int            `json:"images"`
+	ImagesPer1000Words float64        `json:"imagesPer1000Words"`
+	MaxHeadingDepth    int            `json:"maxHeadingDepth"`
+	Languages          map[string]int `json:"languages"`
+	// Producer names the tool detected as having produced the book, when known
+	Producer string         `json:"producer,omitempty"`
+	Chapters []ChapterStats `json:"chapters"`
+}
+
+// ChapterStats holds content statistics for a single chapter
+type ChapterStats struct {
+	Index           int            `json:"index"`
+	Title           string         `json:"title"`
+	Href            string         `json:"href"`
+	Words           int            `json:"words"`
+	ReadingMinutes  float64        `json:"readingMinutes"`
+	Images          int            `json:"images"`
+	Headings        map[string]int `json:"headings"`
+	MaxHeadingDepth int            `json:"maxHeadingDepth"`
+	Languages       map[string]int `json:"languages"`
+}
+
+// Analyze computes word counts, reading time, image density, heading depth and
+// language distribution for every chapter of a book
+func Analyze(book *parser.Book) *BookStats {
+	defaultLanguage := book.Metadata.Language
+	if defaultLanguage == "" {
+		defaultLanguage = "und"
+	}
+
+	bookStats := &BookStats{
+		Title:     book.Metadata.Title,
+		Language:  book.Metadata.Language,
+		Languages: make(map[string]int),
+	}
+
+	for i, chapter := range book.Chapters {
+		chapterStats := analyzeChapter(chapter, defaultLanguage)
+		chapterStats.Index = i + 1
+		if manifestItem, ok := book.Manifest[chapter.ID]; ok {
+			chapterStats.Href = manifestItem.Href
+		}
+
+		bookStats.TotalWords += chapterStats.Words
+		bookStats.Images += chapterStats.Images
+		if chapterStats.MaxHeadingDepth > bookStats.MaxHeadingDepth {
+			bookStats.MaxHeadingDepth = chapterStats.MaxHeadingDepth
+		}
+		for language, words := range chapterStats.Languages {
+			bookStats.Languages[language] += words
+		}
+
+		bookStats.Chapters = append(bookStats.Chapters, chapterStats)
+	}
+
+	bookStats.ReadingMinutes = readingMinutes(bookStats.TotalWords)
+	if bookStats.TotalWords > 0 {
+		bookStats.ImagesPer1000Words = float64(bookStats.Images) * 1000 / float64(bookStats.TotalWords)
+	}
+
+	return bookStats
+}
+
+// analyzeChapter computes the statistics of a single chapter
+func analyzeChapter(chapter parser.Chapter, defaultLanguage string) ChapterStats {
+	chapterStats := ChapterStats{
+		Title:     chapter.Title,
+		Headings:  make(map[string]int),
+		Languages: make(map[string]int),
+	}
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(chapter.Content))
+	if err != nil {
+		return chapterStats
+	}
+
+	// Documents usually declare their language on the root element
+	language := defaultLanguage
+	if lang := langtag.Element(doc.Find("html")); lang != "" {
+		language = normalizeLanguage(lang)
+	}
+
+	var walk func(node *nethtml.Node, language string)
+	walk = func(node *nethtml.Node, language string) {
+		switch node.Type {
+		case nethtml.TextNode:
+			words := len(strings.Fields(node.Data))
+			chapterStats.Words += words
+			if words > 0 {
+				chapterStats.Languages[language] += words
+			}
+		case nethtml.ElementNode:
+			if lang := langtag.Declared(node); lang != "" {
+				language = normalizeLanguage(lang)
+			}
+			switch node.Data {
+			case "img", "image":
+				chapterStats.Images++
+			case "h1", "h2", "h3", "h4", "h5", "h6":
+				chapterStats.Headings[node.Data]++
+				if depth := int(node.Data[1] - '0'); depth > chapterStats.MaxHeadingDepth {
+					chapterStats.MaxHeadingDepth = depth
+				}
+			}
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			walk(child, language)
+		}
+	}
+	for _, node := range doc.Find("body").Nodes {
+		walk(node, language)
+	}
+
+	chapterStats.ReadingMinutes = readingMinutes(chapterStats.Words)
+	return chapterStats
+}
+
+// normalizeLanguage reduces a language tag to its lowercase primary subtag, und
+// when it is unknown
+func normalizeLanguage(lang string) string {
+	if lang = langtag.Primary(lang); lang == "" {
+		return "und"
+	}
+	return lang
+}
+
+// readingMinutes estimates the reading time of a word count, rounded to one decimal
+func readingMinutes(words int) float64 {
+	minutes := float64(words) / WordsPerMinute
+	return float64(int(minutes*10+0.5)) / 10
+}
+
+// WriteJSON writes the statistics as indented JSON
+func (s *BookStats) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(s)
+}
+
+// WriteCSV writes one row per chapter followed by a total row
+func (s *BookStats) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	header := []string{"index", "title", "href", "words", "reading_minutes", "images", "max_heading_depth", "languages"}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+
+	for _, chapter := range s.Chapters {
+		record := []string{
+			strconv.Itoa(chapter.Index),
+			chapter.Title,
+			chapter.Href,
+			strconv.Itoa(chapter.Words),
+			strconv.FormatFloat(chapter.ReadingMinutes, 'f', 1, 64),
+			strconv.Itoa(chapter.Images),
+			strconv.Itoa(chapter.MaxHeadingDepth),
+			FormatLanguages(chapter.Languages),
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	total := []string{
+		"total",
+		s.Title,
+		"",
+		strconv.Itoa(s.TotalWords),
+		strconv.FormatFloat(s.ReadingMinutes, 'f', 1, 64),
+		strconv.Itoa(s.Images),
+		strconv.Itoa(s.MaxHeadingDepth),
+		FormatLanguages(s.Languages),
+	}
+	if err := writer.Write(total); err != nil {
+		return err
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// FormatLanguages renders a language distribution as "en:120 fr:30", largest first
+func FormatLanguages(languages map[string]int) string {
+	codes := make([]string, 0, len(languages))
+	for code := range languages {
+		codes = append(codes, code)
+	}
+	sort.Slice(codes, func(i, j int) bool {
+		if languages[codes[i]] != languages[codes[j]] {
+			return languages[codes[i]] > languages[codes[j]]
+		}
+		return codes[i] < codes[j]
+	})
+
+	parts := make([]string, 0, len(codes))
+	for _, code := range codes {
+		parts = append(parts, fmt.Sprintf("%s:%d", code, languages[code]))
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,133 @@
+// Package timing measures the wall time and allocations of the processing stages
+// of an input, to find where slow books spend their time
+package timing
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"time"
+)
+
+// Enabled turns on the measurements; stages are not measured otherwise
+var Enabled bool
+
+// Stages of processing, in the order they run
+const (
+	StageExtract     = "extract"
+	StageParse       = "parse"
+	StageResources   = "resources"
+	StageConsolidate = "consolidate"
+	StageClean       = "clean"
+	StageNavigation  = "navigation"
+	StageFinalize    = "finalize"
+	StagePackage     = "package"
+)
+
+// Stage is the time and allocations spent in one stage of the current input,
+// summed over its runs
+type Stage struct {
+	Name       string  `json:"name"`
+	Runs       int     `json:"runs"`
+	DurationMS float64 `json:"duration_ms"`
+	Allocs     uint64  `json:"allocs"`
+	AllocBytes uint64  `json:"alloc_bytes"`
+	duration   time.Duration
+}
+
+// stages are the stages measured for the current input, in the order they first ran
+var stages []*Stage
+
+// Start begins measuring a stage and returns the function that ends it:
+//
+//	defer timing.Start(timing.StageParse)()
+func Start(name string) func() {
+	if !Enabled {
+		return func() {}
+	}
+	var before runtime.MemStats
+	runtime.ReadMemStats(&before)
+	started := time.Now()
+	return func() {
+		elapsed := time.Since(started)
+		var after runtime.MemStats
+		runtime.ReadMemStats(&after)
+		record(name, elapsed, after.Mallocs-before.Mallocs, after.TotalAlloc-before.TotalAlloc)
+	}
+}
+
+// record adds a run of a stage
+func record(name string, elapsed time.Duration, allocs, allocBytes uint64) {
+	var stage *Stage
+	for _, s := range stages {
+		if s.Name == name {
+			stage = s
+			break
+		}
+	}
+	if stage == nil {
+		stage = &Stage{Name: name}
+		stages = append(stages, stage)
+	}
+	stage.Runs++
+	stage.duration += elapsed
+	stage.DurationMS = float64(stage.duration.Microseconds()) / 1000
+	stage.Allocs += allocs
+	stage.AllocBytes += allocBytes
+}
+
+// Stages returns the stages measured for the current input
+func Stages() []Stage {
+	result := make([]Stage, len(stages))
+	for i, stage := range stages {
+		result[i] = *stage
+	}
+	return result
+}
+
+// Reset forgets the measured stages, before the next input
+func Reset() {
+	stages = nil
+}
+
+// WriteText writes the measured stages as a table, with the share of total each
+// took; the time not spent in any stage is reported as other
+func WriteText(w io.Writer, total time.Duration) error {
+	if len(stages) == 0 {
+		return nil
+	}
+	fmt.Fprintln(w, "⏱️  Timings:")
+	fmt.Fprintf(w, "   %-12s %10s %6s %12s %10s\n", "stage", "time", "share", "allocated", "allocs")
+	var measured time.Duration
+	for _, stage := range stages {
+		measured += stage.duration
+		fmt.Fprintf(w, "   %-12s %10s %5.1f%% %12s %10d\n", stage.Name, stage.duration.Round(time.Microsecond*100), share(stage.duration, total), formatBytes(stage.AllocBytes), stage.Allocs)
+	}
+	if other := total - measured; other > 0 {
+		fmt.Fprintf(w, "   %-12s %10s %5.1f%%\n", "other", other.Round(time.Microsecond*100), share(other, total))
+	}
+	_, err := fmt.Fprintf(w, "   %-12s %10s\n", "total", total.Round(time.Microsecond*100))
+	return err
+}
+
+// share returns part as a percentage of total
+func share(part, total time.Duration) float64 {
+	if total <= 0 {
+		return 0
+	}
+	return float64(part) * 100 / float64(total)
+}
+
+// formatBytes formats a byte count with a binary unit
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,149 @@
+// Package usage keeps the opt-in ledger of the books processed on this machine:
+// how many, how large, how long they took and the warnings they raised, to help
+// tune thresholds. The ledger is a local JSON file and is never sent anywhere; it
+// holds counts only, and warnings are counted by kind because their messages name
+// files and metadata of the books
+package usage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/flouciel/folian-parser/internal/policy"
+)
+
+// Ledger is the usage statistics file
+type Ledger struct {
+	Since   time.Time `json:"since"`
+	Updated time.Time `json:"updated"`
+	// Books counts the processed inputs, Statuses them by exit status
+	Books    int            `json:"books"`
+	Statuses map[string]int `json:"statuses"`
+	// InputBytes and DurationMS are totals over the books, OutputBytes and Chapters
+	// over the books processed successfully
+	InputBytes  int64 `json:"input_bytes"`
+	OutputBytes int64 `json:"output_bytes"`
+	Chapters    int   `json:"chapters"`
+	DurationMS  int64 `json:"duration_ms"`
+	// Warnings counts the warnings by kind
+	Warnings map[string]int `json:"warnings"`
+}
+
+// Run is the outcome of processing one book
+type Run struct {
+	Status      string
+	InputBytes  int64
+	OutputBytes int64
+	Chapters    int
+	Duration    time.Duration
+	Warnings    []policy.Warning
+}
+
+// Count is a counted name, for the most common warnings
+type Count struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// DefaultPath returns the default location of the ledger: folian-parser/usage.json
+// in the user config directory
+func DefaultPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "folian-usage.json"
+	}
+	return filepath.Join(dir, "folian-parser", "usage.json")
+}
+
+// Load reads the ledger at path; a missing one is empty
+func Load(path string) (*Ledger, error) {
+	ledger := &Ledger{}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read usage statistics: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, ledger); err != nil {
+			return nil, fmt.Errorf("failed to parse usage statistics %s: %w", path, err)
+		}
+	}
+	if ledger.Statuses == nil {
+		ledger.Statuses = make(map[string]int)
+	}
+	if ledger.Warnings == nil {
+		ledger.Warnings = make(map[string]int)
+	}
+	return ledger, nil
+}
+
+// Save writes the ledger to path, replacing the previous one at once so that an
+// interrupted run does not leave it truncated
+func (l *Ledger) Save(path string) error {
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode usage statistics: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create usage statistics directory: %w", err)
+	}
+	temp := path + ".tmp"
+	if err := os.WriteFile(temp, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write usage statistics: %w", err)
+	}
+	if err := os.Rename(temp, path); err != nil {
+		os.Remove(temp)
+		return fmt.Errorf("failed to write usage statistics: %w", err)
+	}
+	return nil
+}
+
+// Add counts a run in the ledger
+func (l *Ledger) Add(run Run) {
+	now := time.Now().UTC().Truncate(time.Second)
+	if l.Since.IsZero() {
+		l.Since = now
+	}
+	l.Updated = now
+	l.Books++
+	l.Statuses[run.Status]++
+	l.InputBytes += run.InputBytes
+	l.OutputBytes += run.OutputBytes
+	l.Chapters += run.Chapters
+	l.DurationMS += run.Duration.Milliseconds()
+	for _, warning := range run.Warnings {
+		l.Warnings[warning.Kind]++
+	}
+}
+
+// Record counts a run in the ledger at path
+func Record(path string, run Run) error {
+	ledger, err := Load(path)
+	if err != nil {
+		return err
+	}
+	ledger.Add(run)
+	return ledger.Save(path)
+}
+
+// Top returns the n most common names of counts, most common first and by name
+// among equals; n = 0 returns them all
+func Top(counts map[string]int, n int) []Count {
+	top := make([]Count, 0, len(counts))
+	for name, count := range counts {
+		top = append(top, Count{Name: name, Count: count})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+		return top[i].Name < top[j].Name
+	})
+	if n > 0 && len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
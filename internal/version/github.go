@@ -0,0 +1,157 @@
+package version
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// TokenEnv is the environment variable holding a GitHub token; authenticated API
+// requests have a far higher rate limit, which CI runners sharing an address need
+const TokenEnv = "GITHUB_TOKEN"
+
+// apiVersion is the GitHub REST API version requested
+const apiVersion = "2022-11-28"
+
+// CacheRoot is the directory API responses are cached in, instead of the user
+// cache directory, when set
+var CacheRoot string
+
+// ErrUnavailable is returned when GitHub cannot be reached, e.g. in offline mode or
+// without a network; update checks are skipped rather than failed
+var ErrUnavailable = errors.New("GitHub is unreachable")
+
+// cachedResponse is an API response kept with its ETag, so that a check whose
+// response has not changed is answered 304 and does not use up the rate limit
+type cachedResponse struct {
+	URL  string          `json:"url"`
+	ETag string          `json:"etag"`
+	Body json.RawMessage `json:"body"`
+}
+
+// responseCachePath returns the cache file of an API URL, or "" when there is no
+// cache directory
+func responseCachePath(url string) string {
+	dir := CacheRoot
+	if dir == "" {
+		var err error
+		if dir, err = os.UserCacheDir(); err != nil {
+			return ""
+		}
+	}
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(dir, "folian-parser", "github", hex.EncodeToString(sum[:8])+".json")
+}
+
+// loadResponse returns the cached response of an API URL, or nil
+func loadResponse(path, url string) *cachedResponse {
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var cached cachedResponse
+	if json.Unmarshal(data, &cached) != nil || cached.URL != url || len(cached.Body) == 0 {
+		return nil
+	}
+	return &cached
+}
+
+// storeResponse caches an API response; failing to is not an error, the next
+// check only costs a full request
+func storeResponse(path string, cached cachedResponse) {
+	if path == "" || cached.ETag == "" {
+		return
+	}
+	data, err := json.Marshal(cached)
+	if err != nil {
+		return
+	}
+	if os.MkdirAll(filepath.Dir(path), 0755) == nil {
+		os.WriteFile(path, data, 0644)
+	}
+}
+
+// getJSON fetches a GitHub API URL and decodes its JSON response into v. The
+// request is authenticated with TokenEnv when it is set and made conditional on
+// the ETag of the cached response, which is used when GitHub answers 304 or the
+// rate limit is exhausted
+func getJSON(url string, v interface{}) error {
+	cachePath := responseCachePath(url)
+	cached := loadResponse(cachePath, url)
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to check latest version: %w", err)
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("X-GitHub-Api-Version", apiVersion)
+	req.Header.Set("User-Agent", "folian-parser/"+Version)
+	if token := os.Getenv(TokenEnv); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if cached != nil {
+		req.Header.Set("If-None-Match", cached.ETag)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	var body []byte
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cached != nil:
+		body = cached.Body
+	case resp.StatusCode == http.StatusOK:
+		if body, err = io.ReadAll(resp.Body); err != nil {
+			return fmt.Errorf("failed to read response: %w", err)
+		}
+		storeResponse(cachePath, cachedResponse{URL: url, ETag: resp.Header.Get("ETag"), Body: body})
+	case rateLimited(resp) && cached != nil:
+		body = cached.Body
+	case rateLimited(resp):
+		return fmt.Errorf("failed to check latest version: GitHub API rate limit exceeded%s; set %s to raise it", rateLimitReset(resp), TokenEnv)
+	default:
+		return fmt.Errorf("failed to check latest version: status code %d", resp.StatusCode)
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	return nil
+}
+
+// rateLimited reports whether a response refuses a request for exceeding the
+// primary or secondary rate limit
+func rateLimited(resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusForbidden:
+		return resp.Header.Get("X-RateLimit-Remaining") == "0" || resp.Header.Get("Retry-After") != ""
+	}
+	return false
+}
+
+// rateLimitReset describes when a rate limit resets, or "" when the response does
+// not say
+func rateLimitReset(resp *http.Response) string {
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		return fmt.Sprintf(" (retry in %ds)", seconds)
+	}
+	if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+		return fmt.Sprintf(" (resets at %s)", time.Unix(reset, 0).Format("15:04"))
+	}
+	return ""
+}
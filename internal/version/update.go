@@ -0,0 +1,189 @@
+package version
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/flouciel/folian-parser/internal/network"
+)
+
+// ChecksumsAsset is the release asset listing the SHA-256 checksums of the binaries,
+// in sha256sum format
+const ChecksumsAsset = "checksums.txt"
+
+// BinaryAsset returns the name of the prebuilt binary of a platform, e.g.
+// folian-parser_linux_amd64 or folian-parser_windows_amd64.exe
+func BinaryAsset(goos, goarch string) string {
+	name := fmt.Sprintf("folian-parser_%s_%s", goos, goarch)
+	if goos == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+// asset returns the release asset with a name, or nil
+func (r *Release) asset(name string) *Asset {
+	for i := range r.Assets {
+		if r.Assets[i].Name == name {
+			return &r.Assets[i]
+		}
+	}
+	return nil
+}
+
+// download fetches a URL
+func download(url string) ([]byte, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download %s: status code %d", url, resp.StatusCode)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", url, err)
+	}
+	return data, nil
+}
+
+// expectedChecksum finds the checksum of a file in sha256sum output
+func expectedChecksum(checksums []byte, name string) (string, bool) {
+	scanner := bufio.NewScanner(bytes.NewReader(checksums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), true
+		}
+	}
+	return "", false
+}
+
+// Update replaces the running binary with a release: the prebuilt binary of the host
+// platform when the release has one, otherwise a build with the Go toolchain
+func Update(release *Release) error {
+	name := BinaryAsset(runtime.GOOS, runtime.GOARCH)
+	if release.asset(name) != nil {
+		return updateBinary(release, name)
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		return fmt.Errorf("release %s has no %s binary and the Go toolchain is not installed", release.TagName, name)
+	}
+	return goInstall(release)
+}
+
+// updateBinary downloads the prebuilt binary of a release, verifies it against the
+// release checksums and atomically replaces the running binary with it
+func updateBinary(release *Release, name string) error {
+	checksumsAsset := release.asset(ChecksumsAsset)
+	if checksumsAsset == nil {
+		return fmt.Errorf("release %s has no %s to verify the download against", release.TagName, ChecksumsAsset)
+	}
+	checksums, err := download(checksumsAsset.URL)
+	if err != nil {
+		return err
+	}
+	expected, ok := expectedChecksum(checksums, name)
+	if !ok {
+		return fmt.Errorf("%s of release %s has no checksum for %s", ChecksumsAsset, release.TagName, name)
+	}
+
+	binary, err := download(release.asset(name).URL)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(binary)
+	if actual := hex.EncodeToString(sum[:]); actual != expected {
+		return fmt.Errorf("checksum verification failed for %s: expected %s, got %s", name, expected, actual)
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate the running binary: %w", err)
+	}
+	if resolved, err := filepath.EvalSymlinks(executable); err == nil {
+		executable = resolved
+	}
+	return replaceBinary(executable, binary)
+}
+
+// replaceBinary writes a new binary next to the old one and renames it into place,
+// so an interrupted update leaves the old binary intact
+func replaceBinary(executable string, binary []byte) error {
+	mode := os.FileMode(0755)
+	if info, err := os.Stat(executable); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(executable), ".folian-parser-update-*")
+	if err != nil {
+		return fmt.Errorf("failed to write the new binary: %w", err)
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	if _, err := tmp.Write(binary); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write the new binary: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write the new binary: %w", err)
+	}
+	if err := os.Chmod(tmpPath, mode); err != nil {
+		return fmt.Errorf("failed to make the new binary executable: %w", err)
+	}
+
+	// Windows cannot replace a running executable, but can rename it out of the way
+	if runtime.GOOS == "windows" {
+		old := executable + ".old"
+		os.Remove(old)
+		if err := os.Rename(executable, old); err != nil {
+			return fmt.Errorf("failed to move the old binary aside: %w", err)
+		}
+		if err := os.Rename(tmpPath, executable); err != nil {
+			os.Rename(old, executable)
+			return fmt.Errorf("failed to replace the binary: %w", err)
+		}
+		return nil
+	}
+	if err := os.Rename(tmpPath, executable); err != nil {
+		return fmt.Errorf("failed to replace the binary: %w", err)
+	}
+	return nil
+}
+
+// goInstall builds and installs a release with the Go toolchain, requiring the Go
+// checksum database to verify the downloaded module before it is built
+func goInstall(release *Release) error {
+	if network.Offline {
+		return network.ErrOffline
+	}
+	if sumdb := os.Getenv("GOSUMDB"); sumdb == "off" {
+		return fmt.Errorf("refusing to update with GOSUMDB=off: the download could not be verified")
+	}
+
+	cmd := exec.Command("go", "install", fmt.Sprintf("github.com/%s@%s", GitHubRepo, release.TagName))
+	// Never exempt the tool's module from checksum verification
+	cmd.Env = append(os.Environ(), "GONOSUMDB=", "GOPRIVATE=", "GOINSECURE=")
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if strings.Contains(stderr.String(), "SECURITY ERROR") || strings.Contains(stderr.String(), "checksum mismatch") {
+			return fmt.Errorf("checksum verification failed for %s: %s", release.TagName, strings.TrimSpace(stderr.String()))
+		}
+		return fmt.Errorf("failed to update: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package version
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/flouciel/folian-parser/internal/network"
+)
+
+// Version information
+const (
+	Version    = "0.3.2"
+	GitHubRepo = "flouciel/folian-parser"
+)
+
+// Release channels: stable follows full releases only, beta also follows
+// pre-releases such as 0.4.0-beta.1
+const (
+	ChannelStable = "stable"
+	ChannelBeta   = "beta"
+)
+
+// Channels lists the release channels in the order they are offered
+var Channels = []string{ChannelStable, ChannelBeta}
+
+// Release is a GitHub release of the tool
+type Release struct {
+	TagName    string  `json:"tag_name"`
+	Draft      bool    `json:"draft"`
+	Prerelease bool    `json:"prerelease"`
+	Assets     []Asset `json:"assets"`
+}
+
+// Asset is a file attached to a release
+type Asset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+}
+
+// Version returns the version of the release without its "v" prefix
+func (r *Release) Version() string {
+	return strings.TrimPrefix(r.TagName, "v")
+}
+
+// client is the HTTP client used for update checks and downloads
+var client = network.NewClient(5 * time.Minute)
+
+// LatestRelease returns the newest release of a channel from GitHub releases
+func LatestRelease(channel string) (*Release, error) {
+	switch channel {
+	case ChannelStable:
+		var release Release
+		if err := getJSON(fmt.Sprintf("https://api.github.com/repos/%s/releases/latest", GitHubRepo), &release); err != nil {
+			return nil, err
+		}
+		return &release, nil
+	case ChannelBeta:
+		var releases []Release
+		if err := getJSON(fmt.Sprintf("https://api.github.com/repos/%s/releases?per_page=50", GitHubRepo), &releases); err != nil {
+			return nil, err
+		}
+		var latest *Release
+		for i := range releases {
+			if releases[i].Draft {
+				continue
+			}
+			if latest == nil || Compare(releases[i].Version(), latest.Version()) > 0 {
+				latest = &releases[i]
+			}
+		}
+		if latest == nil {
+			return nil, fmt.Errorf("no releases found for %s", GitHubRepo)
+		}
+		return latest, nil
+	}
+	return nil, fmt.Errorf("unknown release channel %q (valid: %s)", channel, strings.Join(Channels, ", "))
+}
+
+// splitVersion splits a semantic version into its dot-separated core and pre-release
+// identifiers, dropping a "v" prefix and the build metadata
+func splitVersion(v string) (core, prerelease []string) {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	if i := strings.Index(v, "+"); i >= 0 {
+		v = v[:i]
+	}
+	if i := strings.Index(v, "-"); i >= 0 {
+		prerelease = strings.Split(v[i+1:], ".")
+		v = v[:i]
+	}
+	return strings.Split(v, "."), prerelease
+}
+
+// compareCore compares two core version parts: numerically when both are numbers,
+// a number before anything else, and the rest in ASCII order
+func compareCore(a, b string) int {
+	_, errA := strconv.ParseUint(a, 10, 64)
+	_, errB := strconv.ParseUint(b, 10, 64)
+	switch {
+	case errA == nil && errB != nil:
+		return 1
+	case errA != nil && errB == nil:
+		return -1
+	}
+	return compareIdentifiers(a, b)
+}
+
+// compareIdentifiers compares two version identifiers: numerically when both are
+// numbers, numbers before other identifiers, and the rest in ASCII order
+func compareIdentifiers(a, b string) int {
+	na, errA := strconv.ParseUint(a, 10, 64)
+	nb, errB := strconv.ParseUint(b, 10, 64)
+	switch {
+	case errA == nil && errB == nil:
+		switch {
+		case na > nb:
+			return 1
+		case na < nb:
+			return -1
+		}
+		return 0
+	case errA == nil:
+		return -1
+	case errB == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+// Compare compares two semantic versions, returning 1, 0 or -1 when v1 is newer than,
+// the same as or older than v2; missing core parts count as 0, a pre-release is
+// older than its release, and a malformed version is older than a valid one, so
+// that a bad release tag never triggers an update
+func Compare(v1, v2 string) int {
+	core1, pre1 := splitVersion(v1)
+	core2, pre2 := splitVersion(v2)
+
+	for i := 0; i < len(core1) || i < len(core2); i++ {
+		a, b := "0", "0"
+		if i < len(core1) {
+			a = core1[i]
+		}
+		if i < len(core2) {
+			b = core2[i]
+		}
+		if c := compareCore(a, b); c != 0 {
+			return c
+		}
+	}
+
+	switch {
+	case len(pre1) == 0 && len(pre2) == 0:
+		return 0
+	case len(pre1) == 0:
+		return 1
+	case len(pre2) == 0:
+		return -1
+	}
+	for i := 0; i < len(pre1) && i < len(pre2); i++ {
+		if c := compareIdentifiers(pre1[i], pre2[i]); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case len(pre1) > len(pre2):
+		return 1
+	case len(pre1) < len(pre2):
+		return -1
+	}
+	return 0
+}
@@ -0,0 +1,55 @@
+package version
+
+import "testing"
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		v1, v2 string
+		want   int
+	}{
+		// Core versions
+		{"1.2.3", "1.2.3", 0},
+		{"1.2.4", "1.2.3", 1},
+		{"1.2.3", "1.10.0", -1},
+		{"2.0.0", "1.99.99", 1},
+
+		// Leading v and build metadata
+		{"v1.2.3", "1.2.3", 0},
+		{"v1.2.4", "v1.2.3", 1},
+		{"1.2.3", "v1.3.0", -1},
+		{"1.2.3+build.5", "1.2.3", 0},
+
+		// Segment counts
+		{"1.2", "1.2.0", 0},
+		{"1", "1.0.0", 0},
+		{"1.2.0.1", "1.2.0", 1},
+		{"1.2", "1.2.1", -1},
+
+		// Pre-releases
+		{"1.0.0-alpha", "1.0.0", -1},
+		{"1.0.0", "1.0.0-rc.1", 1},
+		{"1.0.0-alpha", "1.0.0-alpha.1", -1},
+		{"1.0.0-alpha.1", "1.0.0-alpha.beta", -1},
+		{"1.0.0-alpha.beta", "1.0.0-beta", -1},
+		{"1.0.0-beta.2", "1.0.0-beta.11", -1},
+		{"1.0.0-rc.1", "1.0.0-beta.11", 1},
+		{"1.0.0-rc.1", "0.9.0", 1},
+		{"v1.0.0-rc.1", "1.0.0-rc.1", 0},
+
+		// Malformed versions are older than valid ones
+		{"", "0.3.2", -1},
+		{"latest", "0.3.2", -1},
+		{"0.3.2", "nightly", 1},
+		{"1.x.0", "1.0.0", -1},
+		{"v", "0.0.1", -1},
+		{"", "", 0},
+	}
+	for _, test := range tests {
+		if got := Compare(test.v1, test.v2); got != test.want {
+			t.Errorf("Compare(%q, %q) = %d, want %d", test.v1, test.v2, got, test.want)
+		}
+		if got := Compare(test.v2, test.v1); got != -test.want {
+			t.Errorf("Compare(%q, %q) = %d, want %d", test.v2, test.v1, got, -test.want)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+// Package xmlfmt indents XML documents: OPF, NCX and other data files are laid out
+// one element per line, while XHTML is indented only where whitespace is not
+// significant, so that a formatted book renders as the original
+package xmlfmt
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// indentUnit is the indentation of each nesting level
+const indentUnit = "  "
+
+// inlineElements are the XHTML elements laid out within text, whose surrounding
+// whitespace is significant
+var inlineElements = map[string]bool{
+	"a": true, "abbr": true, "b": true, "bdi": true, "bdo": true, "big": true, "br": true, "button": true,
+	"cite": true, "code": true, "data": true, "del": true, "dfn": true, "em": true, "font": true, "i": true,
+	"img": true, "input": true, "ins": true, "kbd": true, "label": true, "mark": true, "math": true, "q": true,
+	"rp": true, "rt": true, "ruby": true, "s": true, "samp": true, "select": true, "small": true, "span": true,
+	"strike": true, "strong": true, "sub": true, "sup": true, "svg": true, "textarea": true, "time": true,
+	"tt": true, "u": true, "var": true, "wbr": true,
+}
+
+// verbatimElements are the XHTML elements whose content is kept exactly as it is
+var verbatimElements = map[string]bool{"pre": true, "script": true, "style": true, "textarea": true}
+
+// voidElements are the XHTML elements that are always empty; other empty XHTML
+// elements are closed with an end tag, as HTML parsers expect
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true, "input": true,
+	"link": true, "meta": true, "param": true, "source": true, "track": true, "wbr": true,
+}
+
+// textEscaper and attributeEscaper escape text and double-quoted attribute values
+var (
+	textEscaper      = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	attributeEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", `"`, "&quot;")
+)
+
+// node is an element, text, comment, processing instruction or directive
+type node struct {
+	token    xml.Token
+	children []*node
+}
+
+// Format indents an XML document; with xhtml set, the document is laid out as XHTML,
+// only breaking lines between block elements
+func Format(data []byte, xhtml bool) ([]byte, error) {
+	root, err := parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	for _, child := range root.children {
+		if isBlank(child) {
+			continue
+		}
+		write(&out, child, 0, xhtml, false)
+		out.WriteByte('\n')
+	}
+	return out.Bytes(), nil
+}
+
+// parse reads a document into a tree, keeping namespace prefixes as written
+func parse(data []byte) (*node, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.Strict = false
+	decoder.Entity = xml.HTMLEntity
+
+	root := &node{}
+	stack := []*node{root}
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse XML: %w", err)
+		}
+		parent := stack[len(stack)-1]
+		switch t := token.(type) {
+		case xml.StartElement:
+			child := &node{token: t.Copy()}
+			parent.children = append(parent.children, child)
+			stack = append(stack, child)
+		case xml.EndElement:
+			if len(stack) == 1 {
+				return nil, fmt.Errorf("failed to parse XML: unexpected </%s>", qualifiedName(t.Name))
+			}
+			stack = stack[:len(stack)-1]
+		default:
+			parent.children = append(parent.children, &node{token: xml.CopyToken(t)})
+		}
+	}
+	if len(stack) != 1 {
+		return nil, fmt.Errorf("failed to parse XML: <%s> is not closed", qualifiedName(stack[len(stack)-1].token.(xml.StartElement).Name))
+	}
+	return root, nil
+}
+
+// write serializes a node; nested nodes are indented from depth unless inline
+func write(out *bytes.Buffer, n *node, depth int, xhtml, inline bool) {
+	switch t := n.token.(type) {
+	case xml.CharData:
+		out.WriteString(textEscaper.Replace(string(t)))
+	case xml.Comment:
+		fmt.Fprintf(out, "<!--%s-->", t)
+	case xml.ProcInst:
+		if len(t.Inst) == 0 {
+			fmt.Fprintf(out, "<?%s?>", t.Target)
+		} else {
+			fmt.Fprintf(out, "<?%s %s?>", t.Target, t.Inst)
+		}
+	case xml.Directive:
+		fmt.Fprintf(out, "<!%s>", t)
+	case xml.StartElement:
+		name := qualifiedName(t.Name)
+		out.WriteString("<" + name)
+		for _, attr := range t.Attr {
+			out.WriteString(" " + qualifiedName(attr.Name) + `="` + attributeEscaper.Replace(attr.Value) + `"`)
+		}
+		if len(n.children) == 0 && (!xhtml || voidElements[strings.ToLower(t.Name.Local)]) {
+			out.WriteString("/>")
+			return
+		}
+		out.WriteByte('>')
+
+		if inline || !laidOut(n, xhtml) {
+			// Content within text is written as it is
+			for _, child := range n.children {
+				write(out, child, depth, xhtml, true)
+			}
+		} else {
+			indent := "\n" + strings.Repeat(indentUnit, depth+1)
+			for _, child := range n.children {
+				if isBlank(child) {
+					continue
+				}
+				out.WriteString(indent)
+				write(out, child, depth+1, xhtml, false)
+			}
+			out.WriteString("\n" + strings.Repeat(indentUnit, depth))
+		}
+		out.WriteString("</" + name + ">")
+	}
+}
+
+// laidOut reports whether the children of an element go on their own lines: when it
+// holds no text and, in XHTML, holds block elements but no inline elements next to
+// each other, between which a line break would add a space
+func laidOut(n *node, xhtml bool) bool {
+	start := n.token.(xml.StartElement)
+	if xhtml && (verbatimElements[strings.ToLower(start.Name.Local)] || inlineElements[strings.ToLower(start.Name.Local)]) {
+		return false
+	}
+	previousInline, hasBlock := false, !xhtml
+	for _, child := range n.children {
+		switch t := child.token.(type) {
+		case xml.CharData:
+			if len(bytes.TrimSpace(t)) > 0 {
+				return false
+			}
+		case xml.StartElement:
+			inline := xhtml && inlineElements[strings.ToLower(t.Name.Local)]
+			if inline && previousInline {
+				return false
+			}
+			previousInline = inline
+			hasBlock = hasBlock || !inline
+		case xml.Comment:
+			hasBlock = true
+		}
+	}
+	return hasBlock
+}
+
+// isBlank reports whether a node is whitespace-only text
+func isBlank(n *node) bool {
+	text, ok := n.token.(xml.CharData)
+	return ok && len(bytes.TrimSpace(text)) == 0
+}
+
+// qualifiedName returns a name with its namespace prefix, as written
+func qualifiedName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
+}
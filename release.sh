@@ -0,0 +1,27 @@
+#!/bin/bash
+
+# Build the prebuilt release binaries and their checksums into dist/,
+# named as the self-update (-u) expects: folian-parser_<os>_<arch>[.exe], and
+# the desktop frontend as folian-gui_<os>_<arch>[.exe]
+set -e
+
+platforms="linux/amd64 linux/arm64 darwin/amd64 darwin/arm64 windows/amd64 windows/arm64"
+
+rm -rf dist
+mkdir -p dist
+for platform in $platforms; do
+    os=${platform%/*}
+    arch=${platform#*/}
+    name="folian-parser_${os}_${arch}"
+    gui="folian-gui_${os}_${arch}"
+    if [ "$os" = "windows" ]; then
+        name="$name.exe"
+        gui="$gui.exe"
+    fi
+    CGO_ENABLED=0 GOOS=$os GOARCH=$arch go build -trimpath -o "dist/$name" .
+    CGO_ENABLED=0 GOOS=$os GOARCH=$arch go build -trimpath -o "dist/$gui" ./cmd/folian-gui
+done
+
+(cd dist && sha256sum folian-parser_* folian-gui_* > checksums.txt)
+
+echo "Release binaries and checksums.txt are in dist/. Attach them all to the GitHub release."